@@ -0,0 +1,68 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// AllowedActions returns the candidate actions that subject may perform on resource,
+// that is those for which querying the (subject, action, resource) triple produces
+// allowEffect, in the order of candidateActions. RegisteredActions can provide the
+// candidates.
+func (ruleSet *RuleSet) AllowedActions(subject interface{}, resource interface{}, candidateActions []interface{}, allowEffect string) []interface{} {
+	var allowed []interface{}
+	for _, action := range candidateActions {
+		decision, _ := ruleSet.evaluate(context.Background(), subject, action, resource)
+		if decision.Effect == allowEffect {
+			allowed = append(allowed, action)
+		}
+	}
+	return allowed
+}
+
+// RegisteredActions returns the action templates of the rules evaluated for subjects
+// and resources with the types of subjectType and resourceType (templates as passed to
+// AddRule), including the rules for the interfaces they implement and the "jolly"
+// rules with nil subject or resource templates. Only concrete action values are listed,
+// once each in the order the rules were added.
+//
+// anyAction reports that some of those rules applies to any action: the rules with a
+// nil action template, and those with a template not matching a single value, such as
+// a pointer, an empty string or a pattern. The actions granted to roles (see Roles)
+// are not listed.
+func (ruleSet *RuleSet) RegisteredActions(subjectType interface{}, resourceType interface{}) (actions []interface{}, anyAction bool) {
+	table := ruleSet.current()
+	s, r := ruleSet.queryValue(0, subjectType, table), ruleSet.queryValue(2, resourceType, table)
+	sTypes, rTypes := lookupTypes(&s), lookupTypes(&r)
+
+	seen := make(map[interface{}]bool)
+	for _, rule := range table.allRules() {
+		if !sTypes[rule.sT] || !rTypes[rule.rT] {
+			continue
+		}
+		if rule.action == nil || rule.patterns[1] != nil || rule.action == "" || !newQueryValue(rule.action).literal {
+			anyAction = true
+			continue
+		}
+		if !seen[rule.action] {
+			seen[rule.action] = true
+			actions = append(actions, rule.action)
+		}
+	}
+	return actions, anyAction
+}
+
+// lookupTypes returns the types the rules for q are looked up under, including nil
+// for the "jolly" rules.
+func lookupTypes(q *queryValue) map[typ]bool {
+	types := map[typ]bool{nil: true}
+	for i := 0; i < q.numTypes(); i++ {
+		t, _ := q.form(i)
+		types[t] = true
+	}
+	return types
+}
@@ -0,0 +1,95 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowedActions(t *testing.T) {
+	rs := newVideoRuleSet()
+	candidates := []interface{}{"view", "modify", "delete"}
+
+	tests := []struct {
+		subject  interface{}
+		resource interface{}
+		want     []interface{}
+	}{
+		{&User{Name: "john"}, &Video{User: "john"}, []interface{}{"view", "modify"}},
+		{&User{Name: "john"}, &Video{User: "jack", Public: true}, []interface{}{"view"}},
+		{&User{Name: "john"}, &Playlist{User: "jack"}, nil},
+		{&User{Name: "admin", IsSuperuser: true}, &Playlist{User: "jack"}, []interface{}{"view"}},
+		{&Group{Name: "editors"}, &Playlist{Group: "editors"}, []interface{}{"modify"}},
+	}
+	for i, test := range tests {
+		if got := rs.AllowedActions(test.subject, test.resource, candidates, ALLOW); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: got %v want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestRegisteredActions(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddRule(&User{}, "share", &Video{}, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+
+	actions, anyAction := rs.RegisteredActions(&User{}, &Video{})
+	if want := []interface{}{"view", "modify", "share"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v want %v", actions, want)
+	}
+	if anyAction {
+		t.Errorf("got anyAction for (*User, *Video)")
+	}
+
+	actions, anyAction = rs.RegisteredActions(&Group{}, &Playlist{})
+	if want := []interface{}{"modify"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v want %v", actions, want)
+	}
+	if anyAction {
+		t.Errorf("got anyAction for (*Group, *Playlist)")
+	}
+
+	if actions, _ := rs.RegisteredActions(&Group{}, &Video{}); actions != nil {
+		t.Errorf("got %v for (*Group, *Video) want none", actions)
+	}
+
+	// jolly resources and actions apply to every resource and action
+	rs.AddRule(&User{}, "publish", nil, effectMatcher(ALLOW))
+	rs.AddRule(nil, nil, &Playlist{}, effectMatcher(DENY))
+	actions, anyAction = rs.RegisteredActions(&User{}, &Video{})
+	if want := []interface{}{"view", "modify", "share", "publish"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v want %v", actions, want)
+	}
+	if anyAction {
+		t.Errorf("got anyAction for (*User, *Video)")
+	}
+	actions, anyAction = rs.RegisteredActions(&User{}, &Playlist{})
+	if want := []interface{}{"view", "modify", "publish"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v want %v", actions, want)
+	}
+	if !anyAction {
+		t.Errorf("got no anyAction for (*User, *Playlist) with a jolly action rule")
+	}
+
+	// templates not matching a single action
+	rs = NewRuleSet(DENY)
+	if _, err := rs.AddGlobRule(&User{}, "video.*", &Video{}, effectMatcher(ALLOW)); err != nil {
+		t.Fatal(err)
+	}
+	if actions, anyAction = rs.RegisteredActions(&User{}, &Video{}); actions != nil || !anyAction {
+		t.Errorf("got %v, %v for a glob action want none, true", actions, anyAction)
+	}
+
+	// interfaces
+	rs = NewRuleSet(DENY)
+	rs.AddRule(&User{}, "transfer", InterfaceOf[NamedOwner](), ownerMatcher)
+	if actions, _ = rs.RegisteredActions(&User{}, &Video{}); !reflect.DeepEqual(actions, []interface{}{"transfer"}) {
+		t.Errorf("got %v for an interface resource want [transfer]", actions)
+	}
+
+	// the registered actions are the candidates for AllowedActions
+	rs = newVideoRuleSet()
+	actions, _ = rs.RegisteredActions(&User{}, &Video{})
+	if got := rs.AllowedActions(&User{Name: "john"}, &Video{User: "john"}, actions, ALLOW); !reflect.DeepEqual(got, actions) {
+		t.Errorf("got %v want %v", got, actions)
+	}
+}
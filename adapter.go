@@ -0,0 +1,133 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrWatchUnsupported is returned by SyncFrom for an adapter that is not a
+// WatchAdapter.
+var ErrWatchUnsupported = errors.New("perms: adapter without watch")
+
+// Adapter stores rules outside the program, eg. in a database, see LoadFrom. The
+// specs are identified by their ID, and usually declarative (see RuleSpec), since
+// matchers can't be stored. The package sqladapter implements an Adapter over
+// database/sql.
+type Adapter interface {
+	// LoadAll returns all the stored rules, in the order they are to be added.
+	LoadAll(ctx context.Context) ([]RuleSpec, error)
+	// Save stores spec, replacing the stored rule with the same id if any.
+	Save(ctx context.Context, spec RuleSpec) error
+	// Delete removes the stored rule with the given id.
+	Delete(ctx context.Context, id RuleID) error
+}
+
+// WatchAdapter is an Adapter reporting the changes of the stored rules, see SyncFrom.
+type WatchAdapter interface {
+	Adapter
+	// Watch returns a channel receiving the changes of the stored rules, made by this
+	// or other programs, until ctx is done, when the channel is closed.
+	Watch(ctx context.Context) (<-chan Change, error)
+}
+
+// ChangeKind is the kind of a Change.
+type ChangeKind string
+
+const (
+	// RuleSaved is a rule added or replaced, see Adapter.Save.
+	RuleSaved ChangeKind = "saved"
+	// RuleDeleted is a rule removed, see Adapter.Delete.
+	RuleDeleted ChangeKind = "deleted"
+)
+
+// Change is a change of the rules stored by a WatchAdapter: the rule with the given
+// id, saved as Spec or deleted.
+type Change struct {
+	Kind ChangeKind
+	ID   RuleID
+	Spec RuleSpec
+}
+
+// LoadFrom adds the rules stored by adapter, at once: if a spec is invalid no rule is
+// added, and the returned error reports the position of the offending spec.
+func (ruleSet *RuleSet) LoadFrom(ctx context.Context, adapter Adapter) error {
+	specs, err := adapter.LoadAll(ctx)
+	if err != nil {
+		return err
+	}
+	rules := make([]*Rule, len(specs))
+	for i, spec := range specs {
+		rule, err := ruleSet.specRule(spec)
+		if err != nil {
+			return fmt.Errorf("perms: rule %d: %w", i, err)
+		}
+		rules[i] = rule
+	}
+	return ruleSet.addRules(rules...)
+}
+
+// SyncFrom applies the changes reported by adapter, a WatchAdapter, to the rules of
+// the rule set: the saved rules are added or replaced with UpsertRule, the deleted
+// ones removed. It blocks until ctx is done or the adapter closes the channel, so it
+// is usually run in its own goroutine after LoadFrom:
+//
+//	if err := rs.LoadFrom(ctx, adapter); err != nil {
+//		return err
+//	}
+//	go rs.SyncFrom(ctx, adapter, onError)
+//
+// The errors applying a change, like an invalid spec, are passed to onError, or logged
+// if onError is nil, and the change is skipped. SyncFrom returns ErrWatchUnsupported if
+// the adapter is not a WatchAdapter, the error of Watch, or the error of ctx once done.
+func (ruleSet *RuleSet) SyncFrom(ctx context.Context, adapter Adapter, onError func(error)) error {
+	watcher, ok := adapter.(WatchAdapter)
+	if !ok {
+		return ErrWatchUnsupported
+	}
+	changes, err := watcher.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok := <-changes:
+			if !ok {
+				return ctx.Err()
+			}
+			if err := ruleSet.applyChange(change); err != nil {
+				if onError != nil {
+					onError(err)
+				} else {
+					ruleSet.logf("perms: syncing rule %s: %v", change.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// applyChange applies a change reported by a WatchAdapter.
+func (ruleSet *RuleSet) applyChange(change Change) error {
+	id := change.ID
+	if id == "" {
+		id = change.Spec.ID
+	}
+	switch change.Kind {
+	case RuleSaved:
+		_, err := ruleSet.UpsertRule(id, change.Spec)
+		return err
+	case RuleDeleted:
+		if err := ruleSet.RemoveRule(id); err != nil && err != ErrRuleNotFound {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("perms: unknown change %q of rule %s", change.Kind, id)
+}
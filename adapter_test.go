@@ -0,0 +1,113 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memoryAdapter is an Adapter over a list of specs, reporting the changes sent to
+// its channel.
+type memoryAdapter struct {
+	specs   []RuleSpec
+	changes chan Change
+}
+
+func (adapter *memoryAdapter) LoadAll(ctx context.Context) ([]RuleSpec, error) {
+	return adapter.specs, nil
+}
+
+func (adapter *memoryAdapter) Save(ctx context.Context, spec RuleSpec) error {
+	adapter.specs = append(adapter.specs, spec)
+	return nil
+}
+
+func (adapter *memoryAdapter) Delete(ctx context.Context, id RuleID) error {
+	return nil
+}
+
+// watchingAdapter is a memoryAdapter implementing WatchAdapter.
+type watchingAdapter struct {
+	memoryAdapter
+}
+
+func (adapter *watchingAdapter) Watch(ctx context.Context) (<-chan Change, error) {
+	return adapter.changes, nil
+}
+
+func viewVideoRule(id RuleID, effect Effect) RuleSpec {
+	return RuleSpec{ID: id, Declarative: &DeclarativeRule{Subject: "User", Action: "view", Resource: "Video", Effect: effect}}
+}
+
+func TestLoadFrom(t *testing.T) {
+	rs := newPolicyRuleSet()
+	adapter := &memoryAdapter{specs: []RuleSpec{
+		viewVideoRule("view", ALLOW),
+		{ID: "modify", Subject: &User{}, Action: "modify", Matcher: effectMatcher(ALLOW).ErrFn().CtxFn()},
+	}}
+	ctx := context.Background()
+	if err := rs.LoadFrom(ctx, adapter); err != nil {
+		t.Fatal(err)
+	}
+	john := &User{Name: "john"}
+	if !rs.IsAllowed(john, "view", &Video{}) || !rs.IsAllowed(john, "modify", &Video{}) {
+		t.Errorf("got the rules not loaded")
+	}
+	if rs.RemoveRule("view") != nil || rs.RemoveRule("modify") != nil {
+		t.Errorf("got the rules without the ids of the specs")
+	}
+
+	// an invalid spec loads nothing
+	broken := viewVideoRule("broken", ALLOW)
+	broken.Declarative.Resource = "Unknown"
+	adapter.specs = append(adapter.specs, broken)
+	if err := rs.LoadFrom(ctx, adapter); err == nil || !strings.HasPrefix(err.Error(), "perms: rule 2:") {
+		t.Errorf("got %v want the error of rule 2", err)
+	}
+	if n := len(rs.Rules()); n != 0 {
+		t.Errorf("got %d rules want none", n)
+	}
+	broken.Declarative.Resource = "Video"
+	broken.Declarative.Condition = "resource.Public &&"
+	var exprErr *ExpressionError
+	if err := rs.LoadFrom(ctx, adapter); !errors.As(err, &exprErr) {
+		t.Errorf("got %#v want an ExpressionError", err)
+	}
+}
+
+func TestSyncFrom(t *testing.T) {
+	rs := newPolicyRuleSet()
+	ctx := context.Background()
+	if err := rs.SyncFrom(ctx, &memoryAdapter{}, nil); err != ErrWatchUnsupported {
+		t.Errorf("got %v want ErrWatchUnsupported", err)
+	}
+	adapter := &watchingAdapter{memoryAdapter{changes: make(chan Change, 10)}}
+	broken := viewVideoRule("broken", ALLOW)
+	broken.Declarative.Resource = "Unknown"
+	for _, change := range []Change{
+		{Kind: RuleSaved, ID: "view", Spec: viewVideoRule("", ALLOW)},
+		{Kind: RuleSaved, Spec: viewVideoRule("modify", ALLOW)},
+		{Kind: RuleSaved, ID: "view", Spec: viewVideoRule("", DENY)},
+		{Kind: RuleSaved, ID: "broken", Spec: broken},
+		{Kind: RuleDeleted, ID: "modify"},
+		{Kind: RuleDeleted, ID: "missing"},
+	} {
+		adapter.changes <- change
+	}
+	close(adapter.changes)
+	var errs []error
+	if err := rs.SyncFrom(ctx, adapter, func(err error) { errs = append(errs, err) }); err != nil {
+		t.Errorf("got %v want nil once the changes are closed", err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "Unknown") {
+		t.Errorf("got errors %v want the broken rule", errs)
+	}
+	rules := rs.Rules()
+	if len(rules) != 1 || rules[0].ID != "view" {
+		t.Errorf("got %+v want the view rule", rules)
+	}
+	if got := rs.Query(&User{}, "view", &Video{}); got != DENY {
+		t.Errorf("got %q want the replaced rule", got)
+	}
+}
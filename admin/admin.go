@@ -0,0 +1,232 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package admin exposes a go-perms rule set over HTTP, for inspecting and changing
+// the rules of a running program. The handler does no authentication: wrap it in the
+// middleware protecting the other administrative endpoints.
+//
+//	mux.Handle("/admin/perms/", http.StripPrefix("/admin/perms", requireAdmin(admin.NewHandler(rs, nil))))
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	perms "github.com/panta/go-perms"
+)
+
+// Rule is the JSON description of a rule returned by GET /rules.
+type Rule struct {
+	ID          perms.RuleID `json:"id"`
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	// Subject, Action and Resource are the registered names of the types of the
+	// templates, or their Go names if not registered, "*" for a "jolly". Action is
+	// the template itself for the string actions.
+	Subject  string `json:"subject"`
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Index    int    `json:"index"`
+	Priority int    `json:"priority"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// Decision is the JSON description of the decision returned by GET /query.
+type Decision struct {
+	Effect  perms.Effect  `json:"effect"`
+	Rule    *perms.RuleID `json:"rule,omitempty"`
+	Role    string        `json:"role,omitempty"`
+	Reason  string        `json:"reason,omitempty"`
+	Default bool          `json:"default"`
+}
+
+type handler struct {
+	rs    *perms.RuleSet
+	types *perms.TypeRegistry
+	mux   *http.ServeMux
+}
+
+// NewHandler returns a handler serving the rules of rs:
+//
+//	GET    /rules              lists the rules, see Rule
+//	POST   /rules              adds the perms.DeclarativeRule of the JSON body
+//	DELETE /rules/{id}         removes a rule
+//	POST   /rules/{id}/disable disables a rule, see perms.RuleSet.DisableRule
+//	POST   /rules/{id}/enable  enables it again
+//	GET    /query              evaluates a triple, see Decision
+//
+// The query parameters of /query are the registered type names "subject" and
+// "resource", with their JSON values "subject_value" and "resource_value", and
+// the string "action". The type names are resolved in types, or in the TypeRegistry
+// of rs if types is nil. The type names of the added rules are always resolved in the
+// TypeRegistry of rs, as by perms.RuleSet.AddDeclarativeRule: they are part of the
+// policy of rs, saved with those names by perms.RuleSet.SaveJSON, while types only
+// names the values of /query and the types of the listed rules. With a types
+// registering more types than rs, /query accepts names that POST /rules refuses. The
+// errors are reported as a JSON object with an "error" string.
+//
+// The handler can serve requests concurrently with the queries of the program.
+func NewHandler(rs *perms.RuleSet, types *perms.TypeRegistry) http.Handler {
+	if types == nil {
+		types = rs.Types()
+	}
+	h := &handler{rs: rs, types: types, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /rules", h.listRules)
+	h.mux.HandleFunc("POST /rules", h.addRule)
+	h.mux.HandleFunc("DELETE /rules/{id}", h.removeRule)
+	h.mux.HandleFunc("POST /rules/{id}/disable", h.setEnabled(false))
+	h.mux.HandleFunc("POST /rules/{id}/enable", h.setEnabled(true))
+	h.mux.HandleFunc("GET /query", h.query)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) listRules(w http.ResponseWriter, r *http.Request) {
+	rules := []Rule{}
+	for _, info := range h.rs.Rules() {
+		rule := Rule{
+			ID:          info.ID,
+			Name:        info.Name,
+			Description: info.Description,
+			Tags:        info.Tags,
+			Subject:     h.typeName(info.SubjectType),
+			Action:      h.typeName(info.ActionType),
+			Resource:    h.typeName(info.ResourceType),
+			Index:       info.Index,
+			Priority:    info.Priority,
+			Enabled:     info.Enabled,
+		}
+		if action, ok := info.ActionTemplate.(string); ok && action != "" {
+			rule.Action = action
+		}
+		rules = append(rules, rule)
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (h *handler) typeName(t reflect.Type) string {
+	if t == nil {
+		return "*"
+	}
+	if name, ok := h.types.Name(t); ok {
+		return name
+	}
+	return t.String()
+}
+
+func (h *handler) addRule(w http.ResponseWriter, r *http.Request) {
+	var decl perms.DeclarativeRule
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&decl); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id, err := h.rs.AddDeclarativeRule(decl)
+	if errors.Is(err, perms.ErrDuplicateRuleID) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]perms.RuleID{"id": id})
+}
+
+func (h *handler) removeRule(w http.ResponseWriter, r *http.Request) {
+	if err := h.rs.RemoveRule(perms.RuleID(r.PathValue("id"))); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) setEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := perms.RuleID(r.PathValue("id"))
+		var err error
+		if enabled {
+			err = h.rs.EnableRule(id)
+		} else {
+			err = h.rs.DisableRule(id)
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *handler) query(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	subject, err := h.decodeValue(params.Get("subject"), params.Get("subject_value"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resource, err := h.decodeValue(params.Get("resource"), params.Get("resource_value"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var action interface{}
+	if params.Has("action") {
+		action = params.Get("action")
+	}
+	d, err := h.rs.QueryDecision(r.Context(), subject, action, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	decision := Decision{Effect: d.Effect, Role: d.Role, Reason: d.Reason, Default: d.Default}
+	if d.Rule != nil {
+		decision.Rule = &d.Rule.ID
+	}
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// decodeValue returns a value of the type registered under typeName, decoding the JSON
+// value into it, or nil if typeName is empty.
+func (h *handler) decodeValue(typeName string, value string) (interface{}, error) {
+	if typeName == "" {
+		return nil, nil
+	}
+	t, ok := h.types.Lookup(typeName)
+	if !ok {
+		return nil, errors.New("admin: unknown type " + typeName)
+	}
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), v.Interface()); err != nil {
+			return nil, errors.New("admin: bad " + typeName + " value: " + err.Error())
+		}
+	}
+	if ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+type user struct {
+	Name string
+}
+
+type document struct {
+	Owner  string
+	Public bool
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.RegisterType("User", &user{})
+	rs.RegisterType("Document", &document{})
+	rs.AddRule(&user{}, "view", &document{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res.(*document).Public, perms.Allow, false
+	}, perms.Named("public documents"))
+	return rs
+}
+
+func serve(t *testing.T, handler http.Handler, method string, target string, body string, wantStatus int, result interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s: got status %d want %d: %s", method, target, rec.Code, wantStatus, rec.Body)
+	}
+	if result != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+	}
+}
+
+func queryTarget(subject string, action string, resource string) string {
+	return "/query?" + url.Values{
+		"subject":        {"User"},
+		"subject_value":  {subject},
+		"action":         {action},
+		"resource":       {"Document"},
+		"resource_value": {resource},
+	}.Encode()
+}
+
+func TestHandler(t *testing.T) {
+	rs := newRuleSet()
+	handler := NewHandler(rs, nil)
+
+	var rules []Rule
+	serve(t, handler, "GET", "/rules", "", http.StatusOK, &rules)
+	if len(rules) != 1 || rules[0].Name != "public documents" || rules[0].Subject != "User" || rules[0].Action != "view" || !rules[0].Enabled {
+		t.Errorf("got %+v want the public documents rule", rules)
+	}
+
+	edit := queryTarget(`{"Name": "john"}`, "edit", `{"Owner": "john"}`)
+	var decision Decision
+	serve(t, handler, "GET", edit, "", http.StatusOK, &decision)
+	if decision.Effect != perms.Deny || !decision.Default {
+		t.Errorf("got %+v want the default decision", decision)
+	}
+
+	var added map[string]perms.RuleID
+	serve(t, handler, "POST", "/rules", `{"id": "owners", "subject": "User", "action": "edit", "resource": "Document",
+		"conditions": ["resource.Owner == subject.Name"], "effect": "allow"}`, http.StatusCreated, &added)
+	if added["id"] != "owners" {
+		t.Errorf("got %v want the owners id", added)
+	}
+	serve(t, handler, "GET", edit, "", http.StatusOK, &decision)
+	if decision.Effect != perms.Allow || decision.Rule == nil || *decision.Rule != "owners" {
+		t.Errorf("got %+v want allowed by owners", decision)
+	}
+	serve(t, handler, "POST", "/rules", `{"id": "owners", "effect": "allow"}`, http.StatusConflict, nil)
+	serve(t, handler, "POST", "/rules", `{"subject": "Missing", "effect": "allow"}`, http.StatusBadRequest, nil)
+
+	serve(t, handler, "POST", "/rules/owners/disable", "", http.StatusNoContent, nil)
+	serve(t, handler, "GET", edit, "", http.StatusOK, &decision)
+	if decision.Effect != perms.Deny {
+		t.Errorf("got %+v want the disabled rule skipped", decision)
+	}
+	serve(t, handler, "GET", "/rules", "", http.StatusOK, &rules)
+	if len(rules) != 2 || rules[1].ID != "owners" || rules[1].Enabled {
+		t.Errorf("got %+v want owners disabled", rules)
+	}
+	serve(t, handler, "POST", "/rules/owners/enable", "", http.StatusNoContent, nil)
+	if !rs.IsAllowed(&user{Name: "john"}, "edit", &document{Owner: "john"}) {
+		t.Errorf("got edit denied want the enabled rule to apply")
+	}
+
+	serve(t, handler, "DELETE", "/rules/owners", "", http.StatusNoContent, nil)
+	serve(t, handler, "DELETE", "/rules/owners", "", http.StatusNotFound, nil)
+	serve(t, handler, "POST", "/rules/owners/disable", "", http.StatusNotFound, nil)
+	serve(t, handler, "GET", "/query?subject=Missing", "", http.StatusBadRequest, nil)
+	serve(t, handler, "GET", queryTarget("{", "view", ""), "", http.StatusBadRequest, nil)
+}
+
+func TestHandlerTypes(t *testing.T) {
+	rs := newRuleSet()
+	types := perms.NewTypeRegistry()
+	types.RegisterType("Person", &user{})
+	types.RegisterType("Document", &document{})
+	handler := NewHandler(rs, types)
+
+	var rules []Rule
+	serve(t, handler, "GET", "/rules", "", http.StatusOK, &rules)
+	if len(rules) != 1 || rules[0].Subject != "Person" {
+		t.Errorf("got %+v want the names of types", rules)
+	}
+	var decision Decision
+	view := "/query?" + url.Values{
+		"subject":        {"Person"},
+		"subject_value":  {`{}`},
+		"action":         {"view"},
+		"resource":       {"Document"},
+		"resource_value": {`{"Public": true}`},
+	}.Encode()
+	serve(t, handler, "GET", view, "", http.StatusOK, &decision)
+	if decision.Effect != perms.Allow {
+		t.Errorf("got %+v want allowed", decision)
+	}
+
+	// the rules are named by the registry of the rule set
+	serve(t, handler, "POST", "/rules", `{"subject": "Person", "action": "edit", "effect": "allow"}`, http.StatusBadRequest, nil)
+	serve(t, handler, "POST", "/rules", `{"subject": "User", "action": "edit", "effect": "allow"}`, http.StatusCreated, nil)
+}
+
+func TestHandlerConcurrentQueries(t *testing.T) {
+	rs := newRuleSet()
+	handler := NewHandler(rs, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if !rs.IsAllowed(&user{Name: "john"}, "view", &document{Public: true}) {
+					t.Error("got a public document denied")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		serve(t, handler, "POST", "/rules", `{"id": "deny-all", "effect": "none", "priority": -1}`, http.StatusCreated, nil)
+		serve(t, handler, "DELETE", "/rules/deny-all", "", http.StatusNoContent, nil)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+)
+
+// ErrActionAlias is returned by AliasAction for the aliases that would form a chain,
+// or that are already aliases of another action.
+var ErrActionAlias = errors.New("perms: invalid action alias")
+
+// AliasAction makes alias an alias of the canonical action, eg. "GET" of "view": the
+// queries for alias look up the rules for canonical, as well as the actions implying
+// it (see ImplyAction) and the roles granting it. The matchers receive the queried
+// action, or canonical if CanonicalActions is set. Actions must be comparable values.
+//
+// Aliases are single-level: AliasAction returns ErrActionAlias, and changes nothing,
+// if canonical is an alias, if alias is the canonical action of other aliases, or if
+// alias is already an alias of another action. It returns ErrUncomparableAction if
+// either is not comparable.
+func (ruleSet *RuleSet) AliasAction(alias interface{}, canonical interface{}) error {
+	if !comparable(alias) || !comparable(canonical) {
+		return ErrUncomparableAction
+	}
+	var err error
+	ruleSet.update(func(w *tableWriter) {
+		graph := w.table.actions
+		current, aliased := graph.canonical(alias)
+		if aliased && current == canonical {
+			return
+		}
+		if _, chained := graph.canonical(canonical); alias == canonical || aliased || chained || graph.isCanonical(alias) {
+			err = ErrActionAlias
+			return
+		}
+		graph = graph.clone()
+		graph.aliases[alias] = canonical
+		w.table.actions = graph
+	})
+	return err
+}
+
+// ActionAliases returns the aliases of the actions, mapped to their canonical actions.
+// See AliasAction.
+func (ruleSet *RuleSet) ActionAliases() map[interface{}]interface{} {
+	aliases := make(map[interface{}]interface{})
+	if graph := ruleSet.current().actions; graph != nil {
+		for alias, canonical := range graph.aliases {
+			aliases[alias] = canonical
+		}
+	}
+	return aliases
+}
+
+// canonical returns the canonical action of action, if it is an alias.
+func (graph *actionGraph) canonical(action interface{}) (interface{}, bool) {
+	if graph == nil || len(graph.aliases) == 0 || !comparable(action) {
+		return nil, false
+	}
+	canonical, ok := graph.aliases[action]
+	return canonical, ok
+}
+
+// isCanonical reports whether action is the canonical action of some alias.
+func (graph *actionGraph) isCanonical(action interface{}) bool {
+	if graph == nil {
+		return false
+	}
+	for _, canonical := range graph.aliases {
+		if canonical == action {
+			return true
+		}
+	}
+	return false
+}
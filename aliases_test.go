@@ -0,0 +1,84 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAliasAction(t *testing.T) {
+	rs := newVideoRuleSet()
+	if err := rs.AliasAction("GET", "view"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.AliasAction("PUT", "modify"); err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range videoSubjects {
+		for _, resource := range videoResources {
+			for alias, action := range map[string]string{"GET": "view", "PUT": "modify"} {
+				if got, want := rs.Query(subject, alias, resource), rs.Query(subject, action, resource); got != want {
+					t.Errorf("%v %s %v: got %q want %q", subject, alias, resource, got, want)
+				}
+			}
+		}
+		got := rs.FilterAllowed(subject, "GET", videoResources, ALLOW)
+		if want := rs.FilterAllowed(subject, "view", videoResources, ALLOW); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v allowed want %v", subject, got, want)
+		}
+	}
+	if got, want := rs.ActionAliases(), map[interface{}]interface{}{"GET": "view", "PUT": "modify"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got aliases %v want %v", got, want)
+	}
+
+	// aliases are single-level
+	for _, pair := range [][2]string{{"HEAD", "GET"}, {"view", "show"}, {"GET", "modify"}, {"list", "list"}} {
+		if err := rs.AliasAction(pair[0], pair[1]); err != ErrActionAlias {
+			t.Errorf("alias %s of %s: got %v want ErrActionAlias", pair[0], pair[1], err)
+		}
+	}
+	if err := rs.AliasAction("GET", "view"); err != nil {
+		t.Errorf("got %v aliasing GET again", err)
+	}
+	if got := len(rs.ActionAliases()); got != 2 {
+		t.Errorf("got %d aliases want 2", got)
+	}
+}
+
+func TestAliasActionMatchers(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AliasAction("GET", "view")
+	rs.ImplyAction("modify", "view")
+	var actions []interface{}
+	recorder := func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		actions = append(actions, act)
+		return true, ALLOW, false
+	}
+	rs.AddRule(&User{}, "view", &Video{}, recorder)
+	rs.AddRule(&User{}, "modify", &Playlist{}, recorder)
+
+	john := &User{Name: "john"}
+	if !rs.IsAllowed(john, "GET", &Video{}) || !rs.IsAllowed(john, "GET", &Playlist{}) {
+		t.Errorf("got GET denied want allowed")
+	}
+	if want := []interface{}{"GET", "GET"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got matcher actions %v want %v", actions, want)
+	}
+
+	actions = nil
+	rs.CanonicalActions = true
+	rs.IsAllowed(john, "GET", &Video{})
+	rs.IsAllowed(john, "GET", &Playlist{})
+	if want := []interface{}{"view", "view"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got matcher actions %v want %v", actions, want)
+	}
+}
+
+func TestAliasActionUncomparable(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if err := rs.AliasAction([]string{"GET"}, "view"); err != ErrUncomparableAction {
+		t.Errorf("got %v want %v", err, ErrUncomparableAction)
+	}
+	if err := rs.AliasAction("GET", map[string]bool{"view": true}); err != ErrUncomparableAction {
+		t.Errorf("got %v want %v", err, ErrUncomparableAction)
+	}
+}
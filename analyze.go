@@ -0,0 +1,208 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Severity grades the findings of Analyze.
+type Severity int
+
+const (
+	// SeverityInfo marks the findings that are often intended.
+	SeverityInfo Severity = iota
+	// SeverityWarning marks the rules that have no effect on the decisions.
+	SeverityWarning
+	// SeverityError marks the rules contradicting each other.
+	SeverityError
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(severity))
+}
+
+// FindingKind is the kind of a Finding.
+type FindingKind string
+
+const (
+	// ShadowedRule is a rule never evaluated because an earlier quick rule of the same
+	// RuleList, without conditions, matches every query it applies to.
+	ShadowedRule FindingKind = "shadowed"
+	// DuplicateTemplates are rules registered with the same templates. They are
+	// reported with SeverityWarning when they are identical declarative rules.
+	DuplicateTemplates FindingKind = "duplicate"
+	// ConflictingRules are declarative rules with the same templates and conditions
+	// but different effects.
+	ConflictingRules FindingKind = "conflict"
+)
+
+// Finding is a problem found by Analyze.
+type Finding struct {
+	Kind     FindingKind
+	Severity Severity
+	// Rules are the ids of the rules involved, in evaluation order.
+	Rules   []RuleID
+	Message string
+}
+
+// Analyze inspects the rules, including those of the namespaces, without evaluating
+// any query, and returns the findings in evaluation order of the rules of each
+// RuleList. Since the matchers added with AddRule and the like are opaque, the
+// shadowing and the conflicts are only detected for declarative rules.
+func (ruleSet *RuleSet) Analyze() []Finding {
+	table := ruleSet.current()
+	mode := ruleSet.templateMode()
+	findings := analyzeTable(table, mode)
+	names := make([]string, 0, len(table.namespaces))
+	for name := range table.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		findings = append(findings, analyzeTable(table.namespaces[name], mode)...)
+	}
+	return findings
+}
+
+func analyzeTable(table *ruleTable, mode templateMode) []Finding {
+	var findings []Finding
+	visited := make(map[[3]typ]bool)
+	for _, first := range table.allRules() {
+		types := first.types()
+		if visited[types] {
+			continue
+		}
+		visited[types] = true
+		findings = append(findings, analyzeList(table.m3rules[first.sT][first.aT][first.rT], mode)...)
+	}
+	return findings
+}
+
+// analyzeList returns the findings for the rules of a RuleList.
+func analyzeList(rules RuleList, mode templateMode) []Finding {
+	var findings []Finding
+	for j, later := range rules {
+		shadowed := false
+		for _, earlier := range rules[:j] {
+			if !shadowed && earlier.alwaysQuick() && earlier.covers(later, mode) {
+				shadowed = true
+				findings = append(findings, Finding{
+					Kind:     ShadowedRule,
+					Severity: SeverityWarning,
+					Rules:    []RuleID{earlier.id, later.id},
+					Message:  fmt.Sprintf("rule %s is unreachable: the quick rule %s matches first", later.id, earlier.id),
+				})
+			}
+			if !earlier.covers(later, mode) || !later.covers(earlier, mode) {
+				continue
+			}
+			if finding, ok := compareDuplicates(earlier, later); ok {
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings
+}
+
+// compareDuplicates returns the finding for two rules with the same templates.
+func compareDuplicates(earlier *Rule, later *Rule) (Finding, bool) {
+	ids := []RuleID{earlier.id, later.id}
+	if earlier.decl == nil || later.decl == nil {
+		return Finding{
+			Kind:     DuplicateTemplates,
+			Severity: SeverityInfo,
+			Rules:    ids,
+			Message:  fmt.Sprintf("rules %s and %s have the same templates", earlier.id, later.id),
+		}, true
+	}
+	if conditionsKey(earlier.decl) != conditionsKey(later.decl) {
+		return Finding{}, false
+	}
+	if earlier.decl.Effect != later.decl.Effect {
+		return Finding{
+			Kind:     ConflictingRules,
+			Severity: SeverityError,
+			Rules:    ids,
+			Message:  fmt.Sprintf("rules %s and %s have the same conditions but the effects %q and %q", earlier.id, later.id, earlier.decl.Effect, later.decl.Effect),
+		}, true
+	}
+	return Finding{
+		Kind:     DuplicateTemplates,
+		Severity: SeverityWarning,
+		Rules:    ids,
+		Message:  fmt.Sprintf("rule %s duplicates rule %s", later.id, earlier.id),
+	}, true
+}
+
+// conditionsKey returns a description of the conditions of decl, the same for the
+// conditions listed in any order.
+func conditionsKey(decl *DeclarativeRule) string {
+	conditions := make([]string, len(decl.Conditions))
+	for i, cond := range decl.Conditions {
+		conditions[i] = cond.String()
+	}
+	sort.Strings(conditions)
+	return strings.Join(conditions, " && ") + " | " + strings.TrimSpace(decl.Condition)
+}
+
+// alwaysQuick reports whether the rule is a declarative quick rule matching every
+// query it applies to, whenever it is evaluated.
+func (rule *Rule) alwaysQuick() bool {
+	decl := rule.decl
+	return decl != nil && decl.Quick && len(decl.Conditions) == 0 && decl.Condition == "" &&
+		!rule.disabled && !rule.expires()
+}
+
+// covers reports whether the templates of rule admit every value admitted by those of
+// other, which is in the same RuleList.
+func (rule *Rule) covers(other *Rule, mode templateMode) bool {
+	templates, others := rule.templates(), other.templates()
+	for position, template := range templates {
+		if pattern := rule.patterns[position]; pattern != nil || other.patterns[position] != nil {
+			if !reflect.DeepEqual(pattern, other.patterns[position]) {
+				return false
+			}
+			continue
+		}
+		t := reflect.TypeOf(template)
+		switch {
+		case t == nil:
+		case t.Kind() == reflect.Map:
+			if reflect.ValueOf(template).Len() > 0 && !reflect.DeepEqual(template, others[position]) {
+				return false
+			}
+		case t.Kind() == reflect.Struct && mode.structFields:
+			if !reflect.DeepEqual(template, others[position]) {
+				return false
+			}
+		case t == stringType || (t.Comparable() && t.Kind() != reflect.Ptr):
+			if template != "" && template != others[position] {
+				return false
+			}
+		case mode.deepValues && rule.deep[position]:
+			if !reflect.DeepEqual(template, others[position]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rule *Rule) templates() [3]interface{} {
+	return [3]interface{}{rule.subject, rule.action, rule.resource}
+}
@@ -0,0 +1,117 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newDeclarativeRuleSet returns a rule set with the test types registered.
+func newDeclarativeRuleSet(t *testing.T, decls ...DeclarativeRule) *RuleSet {
+	t.Helper()
+	rs := NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Playlist", &Playlist{})
+	rs.RegisterType("Video", &Video{})
+	for _, decl := range decls {
+		if _, err := rs.AddDeclarativeRule(decl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rs
+}
+
+func TestAnalyzeShadowed(t *testing.T) {
+	rs := newDeclarativeRuleSet(t,
+		DeclarativeRule{ID: "owner", Subject: "User", Action: "view", Resource: "Playlist", Conditions: []Condition{{Field: "resource.User", Ref: "subject.Name"}}, Effect: ALLOW, Quick: true},
+		DeclarativeRule{ID: "everyone", Subject: "User", Action: "", Resource: "Playlist", Effect: ALLOW, Quick: true},
+		DeclarativeRule{ID: "banned", Subject: "User", Action: "", Resource: "Playlist", Conditions: []Condition{{Field: "subject.Name", Value: "mallory"}}, Effect: DENY},
+	)
+	// the rules for other actions aren't shadowed
+	rs.AddDeclarativeRule(DeclarativeRule{ID: "deny-view", Subject: "User", Action: "view", Resource: "Playlist", Effect: DENY})
+	findings := rs.Analyze()
+	want := []Finding{{
+		Kind:     ShadowedRule,
+		Severity: SeverityWarning,
+		Rules:    []RuleID{"everyone", "banned"},
+		Message:  "rule banned is unreachable: the quick rule everyone matches first",
+	}}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("got %+v want %+v", findings, want)
+	}
+
+	// a disabled rule shadows nothing
+	rs.DisableRule("everyone")
+	if findings := rs.Analyze(); len(findings) != 0 {
+		t.Errorf("got %+v want no findings", findings)
+	}
+}
+
+func TestAnalyzeDuplicates(t *testing.T) {
+	rs := newDeclarativeRuleSet(t,
+		DeclarativeRule{ID: "public", Subject: "User", Action: "view", Resource: "Video", Conditions: []Condition{{Field: "resource.Public", Value: true}, {Field: "subject.IsSuperuser", Value: false}}, Effect: ALLOW},
+		DeclarativeRule{ID: "public-again", Subject: "User", Action: "view", Resource: "Video", Conditions: []Condition{{Field: "subject.IsSuperuser", Value: false}, {Field: "resource.Public", Value: true}}, Effect: ALLOW},
+		DeclarativeRule{ID: "private", Subject: "User", Action: "view", Resource: "Video", Condition: "resource.Public == false", Effect: DENY},
+	)
+	rs.AddRuleWithID("go-view", &User{}, "view", &Video{}, effectMatcher(ALLOW))
+	rs.AddRuleWithID("go-modify", &User{}, "modify", &Video{}, effectMatcher(ALLOW))
+	rs.AddGlobRule("vi*", "view", "doc", effectMatcher(ALLOW))
+	rs.AddGlobRule("vi*", "view", "doc", effectMatcher(DENY))
+
+	type summary struct {
+		kind     FindingKind
+		severity Severity
+		rules    []RuleID
+	}
+	var got []summary
+	for _, finding := range rs.Analyze() {
+		got = append(got, summary{finding.Kind, finding.Severity, finding.Rules})
+	}
+	want := []summary{
+		{DuplicateTemplates, SeverityWarning, []RuleID{"public", "public-again"}},
+		{DuplicateTemplates, SeverityInfo, []RuleID{"public", "go-view"}},
+		{DuplicateTemplates, SeverityInfo, []RuleID{"public-again", "go-view"}},
+		{DuplicateTemplates, SeverityInfo, []RuleID{"private", "go-view"}},
+		{DuplicateTemplates, SeverityInfo, []RuleID{"rule-1", "rule-2"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestAnalyzeConflicts(t *testing.T) {
+	rs := newDeclarativeRuleSet(t,
+		DeclarativeRule{ID: "allow-public", Subject: "User", Action: "view", Resource: "Video", Condition: "resource.Public == true", Effect: ALLOW},
+		DeclarativeRule{ID: "deny-other", Subject: "User", Action: "view", Resource: "Video", Condition: "resource.Public == false", Effect: DENY},
+		DeclarativeRule{ID: "deny-public", Subject: "User", Action: "view", Resource: "Video", Condition: "resource.Public == true", Effect: DENY},
+	)
+	for _, decl := range []DeclarativeRule{
+		{ID: "tenant-allow", Subject: "User", Action: "modify", Resource: "Video", Effect: ALLOW},
+		{ID: "tenant-deny", Subject: "User", Action: "modify", Resource: "Video", Effect: DENY},
+	} {
+		rule, err := rs.newDeclarativeRule(decl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.addRulesIn("tenant", rule)
+	}
+
+	findings := rs.Analyze()
+	if len(findings) != 2 {
+		t.Fatalf("got %+v want 2 conflicts", findings)
+	}
+	want := Finding{
+		Kind:     ConflictingRules,
+		Severity: SeverityError,
+		Rules:    []RuleID{"allow-public", "deny-public"},
+		Message:  `rules allow-public and deny-public have the same conditions but the effects "allow" and "deny"`,
+	}
+	if !reflect.DeepEqual(findings[0], want) {
+		t.Errorf("got %+v want %+v", findings[0], want)
+	}
+	if got := findings[1].Rules; findings[1].Kind != ConflictingRules || !reflect.DeepEqual(got, []RuleID{"tenant-allow", "tenant-deny"}) {
+		t.Errorf("got %+v want the conflict of the namespace", findings[1])
+	}
+	if got := SeverityError.String(); got != "error" {
+		t.Errorf("got %q want error", got)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// AttributeProvider fetches the attributes of the subjects and the resources which are
+// not part of their values, eg. the department of a user kept in a directory service,
+// for the attr function of the expressions, see RuleSet.Attributes.
+type AttributeProvider interface {
+	// GetAttribute returns the attribute name of entity, nil if it has none.
+	GetAttribute(ctx context.Context, entity interface{}, name string) (interface{}, error)
+}
+
+// ErrNoAttributeProvider is the error of the attributes fetched by the queries of a
+// RuleSet without Attributes.
+var ErrNoAttributeProvider = errors.New("perms: no attribute provider")
+
+// AttributeError reports an attribute which can't be fetched. Unlike the other errors
+// of the expressions, it fails the query even without StrictExpressions, see QueryE.
+type AttributeError struct {
+	Entity interface{}
+	Name   string
+	Err    error
+}
+
+func (err *AttributeError) Error() string {
+	return fmt.Sprintf("fetching attribute %q of %s: %v", err.Name, KeyOf(err.Entity), err.Err)
+}
+
+// Unwrap returns the error of the AttributeProvider.
+func (err *AttributeError) Unwrap() error {
+	return err.Err
+}
+
+// queryAttributes memoizes the attributes fetched by a query, shared by its rules.
+type queryAttributes struct {
+	provider AttributeProvider
+	mu       sync.Mutex
+	values   map[attributeKey]attributeValue
+}
+
+type attributeKey struct {
+	entity interface{}
+	name   string
+}
+
+type attributeValue struct {
+	value interface{}
+	err   error
+}
+
+// attributesKey is the context key of the queryAttributes of a query.
+type attributesKey struct{}
+
+func withAttributes(ctx context.Context, provider AttributeProvider) context.Context {
+	return context.WithValue(ctx, attributesKey{}, &queryAttributes{provider: provider})
+}
+
+// attributesFrom returns the attributes of the query of ctx, nil if the RuleSet has no
+// Attributes.
+func attributesFrom(ctx context.Context) *queryAttributes {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attributesKey{}).(*queryAttributes)
+	return attrs
+}
+
+// get returns the attribute name of entity, fetching it the first time. A nil
+// queryAttributes has no provider.
+func (attrs *queryAttributes) get(ctx context.Context, entity interface{}, name string) (interface{}, error) {
+	if attrs == nil {
+		return nil, &AttributeError{Entity: entity, Name: name, Err: ErrNoAttributeProvider}
+	}
+	key := attributeKey{entity: entityKey(entity), name: name}
+	attrs.mu.Lock()
+	cached, ok := attrs.values[key]
+	attrs.mu.Unlock()
+	if ok {
+		return cached.value, cached.err
+	}
+	value, err := attrs.provider.GetAttribute(ctx, entity, name)
+	if err != nil {
+		err = &AttributeError{Entity: entity, Name: name, Err: err}
+	}
+	attrs.mu.Lock()
+	if attrs.values == nil {
+		attrs.values = make(map[attributeKey]attributeValue)
+	}
+	attrs.values[key] = attributeValue{value: value, err: err}
+	attrs.mu.Unlock()
+	return value, err
+}
+
+// entityKey returns a comparable key identifying entity: the value itself for the
+// pointers and the basic types, its KeyOf for the others.
+func entityKey(entity interface{}) interface{} {
+	switch reflect.ValueOf(entity).Kind() {
+	case reflect.Invalid, reflect.Ptr, reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return entity
+	}
+	return KeyOf(entity)
+}
+
+// MemoryAttributes is an AttributeProvider holding the attributes in memory, eg. for
+// the tests. The entities are identified by KeyOf. It is safe for concurrent use.
+type MemoryAttributes struct {
+	mu     sync.RWMutex
+	values map[string]map[string]interface{}
+}
+
+// NewMemoryAttributes returns a provider without attributes.
+func NewMemoryAttributes() *MemoryAttributes {
+	return &MemoryAttributes{values: make(map[string]map[string]interface{})}
+}
+
+// Set sets the attribute name of entity to value.
+func (attrs *MemoryAttributes) Set(entity interface{}, name string, value interface{}) {
+	attrs.mu.Lock()
+	defer attrs.mu.Unlock()
+	key := KeyOf(entity)
+	if attrs.values[key] == nil {
+		attrs.values[key] = make(map[string]interface{})
+	}
+	attrs.values[key][name] = value
+}
+
+// GetAttribute returns the attribute set for entity, nil if none.
+func (attrs *MemoryAttributes) GetAttribute(ctx context.Context, entity interface{}, name string) (interface{}, error) {
+	attrs.mu.RLock()
+	defer attrs.mu.RUnlock()
+	return attrs.values[KeyOf(entity)][name], nil
+}
+
+// AttributeCache is an AttributeProvider caching the attributes fetched by another
+// one for a time, across the queries. The entities are identified by KeyOf, and the
+// errors are not cached. It is safe for concurrent use.
+type AttributeCache struct {
+	provider AttributeProvider
+	ttl      time.Duration
+	// Now returns the current time, used to expire the attributes. When nil, time.Now
+	// is used.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[attributeKey]cachedAttribute
+}
+
+type cachedAttribute struct {
+	value   interface{}
+	expires time.Time
+}
+
+// NewAttributeCache returns a cache of the attributes of provider, kept for ttl.
+func NewAttributeCache(provider AttributeProvider, ttl time.Duration) *AttributeCache {
+	return &AttributeCache{provider: provider, ttl: ttl, entries: make(map[attributeKey]cachedAttribute)}
+}
+
+func (cache *AttributeCache) now() time.Time {
+	if cache.Now != nil {
+		return cache.Now()
+	}
+	return time.Now()
+}
+
+// GetAttribute returns the cached attribute, fetching it from the provider when
+// missing or expired.
+func (cache *AttributeCache) GetAttribute(ctx context.Context, entity interface{}, name string) (interface{}, error) {
+	key := attributeKey{entity: KeyOf(entity), name: name}
+	now := cache.now()
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	if ok && !now.Before(entry.expires) {
+		delete(cache.entries, key)
+		ok = false
+	}
+	cache.mu.Unlock()
+	if ok {
+		return entry.value, nil
+	}
+	value, err := cache.provider.GetAttribute(ctx, entity, name)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.entries[key] = cachedAttribute{value: value, expires: now.Add(cache.ttl)}
+	cache.mu.Unlock()
+	return value, nil
+}
+
+// Invalidate drops the cached attributes of entity, eg. when they change.
+func (cache *AttributeCache) Invalidate(entity interface{}) {
+	key := KeyOf(entity)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for k := range cache.entries {
+		if k.entity == key {
+			delete(cache.entries, k)
+		}
+	}
+}
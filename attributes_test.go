@@ -0,0 +1,125 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingAttributes counts the attributes fetched from a MemoryAttributes.
+type countingAttributes struct {
+	*MemoryAttributes
+	calls int
+	err   error
+}
+
+func (attrs *countingAttributes) GetAttribute(ctx context.Context, entity interface{}, name string) (interface{}, error) {
+	attrs.calls++
+	if attrs.err != nil {
+		return nil, attrs.err
+	}
+	return attrs.MemoryAttributes.GetAttribute(ctx, entity, name)
+}
+
+func TestAttributes(t *testing.T) {
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	video := &Video{Name: "holidays", User: "john"}
+	attrs := &countingAttributes{MemoryAttributes: NewMemoryAttributes()}
+	attrs.Set(john, "department", "sales")
+	attrs.Set(jack, "department", "legal")
+	attrs.Set(video, "classification", "internal")
+
+	rs := NewRuleSet(DENY)
+	rs.Attributes = attrs
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Video", &Video{})
+	for _, decl := range []DeclarativeRule{
+		{Subject: "User", Action: "view", Resource: "Video", Condition: `attr(subject, "department") == "sales"`, Effect: ALLOW},
+		{Subject: "User", Action: "view", Resource: "Video", Condition: `attr(subject, "department") == "legal" || attr(resource, "classification") == "secret"`, Effect: DENY},
+		{Subject: "User", Action: "modify", Resource: "Video", Condition: `resource.User == subject.Name`, Effect: ALLOW},
+	} {
+		if _, err := rs.AddDeclarativeRule(decl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := rs.Query(john, "view", video); got != ALLOW {
+		t.Errorf("got %q want %q for sales", got, ALLOW)
+	}
+	// the department is shared by the rules, the classification only fetched once
+	if attrs.calls != 2 {
+		t.Errorf("got %d calls want 2 in a query", attrs.calls)
+	}
+	if got := rs.Query(jack, "view", video); got != DENY {
+		t.Errorf("got %q want %q for legal", got, DENY)
+	}
+	// the rules not using the attributes don't fetch them
+	attrs.calls = 0
+	if got := rs.Query(john, "modify", video); got != ALLOW || attrs.calls != 0 {
+		t.Errorf("got %q, %d calls want %q without attributes", got, attrs.calls, ALLOW)
+	}
+
+	// the errors fail the query, even without StrictExpressions
+	failure := errors.New("directory unavailable")
+	attrs.err = failure
+	attrs.calls = 0
+	effect, err := rs.QueryE(john, "view", video)
+	var attrErr *AttributeError
+	if effect != DENY || !errors.As(err, &attrErr) || !errors.Is(err, failure) || attrErr.Name != "department" || attrErr.Entity != john {
+		t.Errorf("got %q, %v want the attribute error", effect, err)
+	}
+	if attrs.calls != 1 {
+		t.Errorf("got %d calls want the failure memoized", attrs.calls)
+	}
+
+	rs.Attributes = nil
+	if _, err := rs.QueryE(john, "view", video); !errors.Is(err, ErrNoAttributeProvider) {
+		t.Errorf("got %v want %v", err, ErrNoAttributeProvider)
+	}
+}
+
+func TestAttributeCache(t *testing.T) {
+	john := &User{Name: "john"}
+	attrs := &countingAttributes{MemoryAttributes: NewMemoryAttributes()}
+	attrs.Set(john, "department", "sales")
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewAttributeCache(attrs, time.Minute)
+	cache.Now = func() time.Time { return now }
+
+	get := func() interface{} {
+		value, err := cache.GetAttribute(context.Background(), john, "department")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	if value := get(); value != "sales" || attrs.calls != 1 {
+		t.Errorf("got %v, %d calls want sales fetched", value, attrs.calls)
+	}
+	attrs.Set(john, "department", "legal")
+	if value := get(); value != "sales" || attrs.calls != 1 {
+		t.Errorf("got %v, %d calls want sales cached", value, attrs.calls)
+	}
+	now = now.Add(time.Minute)
+	if value := get(); value != "legal" || attrs.calls != 2 {
+		t.Errorf("got %v, %d calls want legal after the ttl", value, attrs.calls)
+	}
+	attrs.Set(john, "department", "sales")
+	cache.Invalidate(john)
+	if value := get(); value != "sales" || attrs.calls != 3 {
+		t.Errorf("got %v, %d calls want sales after Invalidate", value, attrs.calls)
+	}
+
+	// the errors are not cached
+	cache.Invalidate(john)
+	attrs.err = errors.New("directory unavailable")
+	if _, err := cache.GetAttribute(context.Background(), john, "department"); err == nil {
+		t.Error("got no error")
+	}
+	attrs.err = nil
+	if value := get(); value != "sales" || attrs.calls != 5 {
+		t.Errorf("got %v, %d calls want sales fetched again", value, attrs.calls)
+	}
+}
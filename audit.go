@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// SuperuserRuleID is the ID of the rule passed to the AuditFn hook for the superuser
+// overrides, see RuleSet.SuperuserFn.
+const SuperuserRuleID RuleID = "superuser"
+
+// AuditEvent is the record of a query passed to the AuditDecisionFn hook.
+type AuditEvent struct {
+	Subject  interface{}
+	Action   interface{}
+	Resource interface{}
+	// Actor is the subject acting on behalf of Subject, nil unless the query comes
+	// from QueryAs.
+	Actor interface{}
+	// Decision is the decision of the query, with its Reason and Obligations.
+	Decision Decision
+}
+
+// audit calls the AuditFn and AuditDecisionFn hooks with the decision, recovering
+// from their panics.
+func (ruleSet *RuleSet) audit(subject interface{}, action interface{}, resource interface{}, decision Decision) {
+	if ruleSet.AuditFn != nil {
+		ruleSet.callAuditHook(func() {
+			var rule *RuleInfo
+			if decision.Revoked {
+				rule = &RuleInfo{ID: RevokedRuleID, Enabled: true}
+			} else if decision.Superuser {
+				rule = &RuleInfo{ID: SuperuserRuleID, Enabled: true}
+			} else if decision.Rule != nil {
+				rule = copyRuleInfo(decision.Rule)
+			}
+			ruleSet.AuditFn(subject, action, resource, decision.Effect, rule)
+		})
+	}
+	if ruleSet.AuditDecisionFn != nil {
+		ruleSet.callAuditHook(func() {
+			// a copy, so that the hook can't alter the returned decision
+			event := AuditEvent{Subject: subject, Action: action, Resource: resource, Actor: decision.Actor, Decision: decision}
+			if decision.Rule != nil {
+				event.Decision.Rule = copyRuleInfo(decision.Rule)
+			}
+			event.Decision.Obligations = append([]Obligation(nil), decision.Obligations...)
+			ruleSet.AuditDecisionFn(event)
+		})
+	}
+}
+
+// copyRuleInfo returns a copy of info that can be modified without affecting info.
+func copyRuleInfo(info *RuleInfo) *RuleInfo {
+	c := *info
+	c.Tags = copyTags(info.Tags)
+	return &c
+}
+
+func (ruleSet *RuleSet) callAuditHook(hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			ruleSet.logf("perms: audit hook panic: %v", r)
+		}
+	}()
+	hook()
+}
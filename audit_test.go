@@ -0,0 +1,69 @@
+package perms
+
+import (
+	"strings"
+	"testing"
+)
+
+type auditRecord struct {
+	subject, action, resource interface{}
+	effect                    string
+	rule                      *RuleInfo
+}
+
+func TestAuditFn(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	id := rs.AddRule(nil, "view", nil, effectMatcher(ALLOW))
+	var records []auditRecord
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		records = append(records, auditRecord{subject, action, resource, effect, rule})
+	}
+
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	// the jolly rule is only found in the last pass
+	if got := rs.Query(john, "view", video); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d audit records want 1", len(records))
+	}
+	r := records[0]
+	if r.subject != john || r.action != "view" || r.resource != video || r.effect != ALLOW || r.rule == nil || r.rule.ID != id {
+		t.Errorf("got %+v want the jolly rule allowing", r)
+	}
+
+	records = nil
+	if got := rs.Query(john, "edit", video); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+	if len(records) != 1 || records[0].effect != DENY || records[0].rule != nil {
+		t.Errorf("got %+v want one default decision without rule", records)
+	}
+
+	// the hook can't change the result
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		rule.ID = "changed"
+	}
+	if d := rs.QueryExplain(john, "view", video); d.Rule.ID != id {
+		t.Errorf("got rule %q want %q", d.Rule.ID, id)
+	}
+}
+
+func TestAuditFnPanic(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	logger := &recordingLogger{}
+	rs.SetLogger(logger)
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		panic("audit backend down")
+	}
+
+	if got := rs.Query(&User{}, "view", &Video{}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+	last := logger.lines[len(logger.lines)-1]
+	if !strings.Contains(last, "audit backend down") {
+		t.Errorf("got last log line %q want the panic", last)
+	}
+}
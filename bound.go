@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"sync"
+)
+
+// BoundChecker checks the permissions of a single subject, resolved once by For, eg.
+// for the many checks of a request. It is safe for concurrent use.
+type BoundChecker struct {
+	ruleSet *RuleSet
+	bound   *boundSubject
+
+	// groups, when grouped, are the groups of the subject, see QueryWithGroups
+	mu      sync.Mutex
+	groups  []*boundSubject
+	grouped bool
+}
+
+// boundSubject is a subject resolved for the rules of a table: what decide and run
+// would otherwise derive from the subject for each query.
+type boundSubject struct {
+	table   *ruleTable
+	subject interface{}
+	value   queryValue
+	// superuser is the answer of the SuperuserFn
+	superuser bool
+	// revocationKey, if revocable, is the key for RevokeSubject
+	revocationKey string
+	revocable     bool
+	// rolesKey, if rolesKeyed, is the key of the subject for roles
+	roles      *Roles
+	rolesKey   string
+	rolesKeyed bool
+}
+
+// For returns a checker of the permissions of subject, seeing the current rules for
+// all its lifetime, like a Snapshot. The type of the subject, the interfaces it
+// implements, its identity keys, the answer of the SuperuserFn and, once queried, its
+// groups (see QueryWithGroups) are resolved once, instead of for each query: the
+// subject must not change meanwhile. The other settings of the rule set are read at
+// each query.
+func (ruleSet *RuleSet) For(subject interface{}) *BoundChecker {
+	return &BoundChecker{ruleSet: ruleSet, bound: ruleSet.bind(ruleSet.current(), subject)}
+}
+
+func (ruleSet *RuleSet) bind(table *ruleTable, subject interface{}) *boundSubject {
+	bound := &boundSubject{
+		table:   table,
+		subject: subject,
+		value:   ruleSet.queryValue(0, subject, table),
+		roles:   ruleSet.Roles,
+	}
+	if ruleSet.SuperuserFn != nil {
+		bound.superuser = ruleSet.SuperuserFn(subject)
+	}
+	bound.revocationKey, bound.revocable = ruleSet.revocationKey(subject)
+	if bound.roles != nil {
+		bound.rolesKey, bound.rolesKeyed = bound.roles.subjectKey(subject)
+	}
+	return bound
+}
+
+// revokedSubject is like revoked, with the key of bound if non-nil.
+func (ruleSet *RuleSet) revokedSubject(table *ruleTable, subject interface{}, bound *boundSubject) bool {
+	if bound == nil {
+		return ruleSet.revoked(table, subject)
+	}
+	return bound.revocable && len(table.revoked) > 0 && ruleSet.revokedKey(table, bound.revocationKey)
+}
+
+// isSuperuser calls the SuperuserFn, unless answered for bound.
+func (ruleSet *RuleSet) isSuperuser(subject interface{}, bound *boundSubject) bool {
+	if bound != nil {
+		return bound.superuser
+	}
+	return ruleSet.SuperuserFn(subject)
+}
+
+func (checker *BoundChecker) options() queryOptions {
+	return queryOptions{table: checker.bound.table, bound: checker.bound}
+}
+
+// Subject returns the subject of the checker.
+func (checker *BoundChecker) Subject() interface{} {
+	return checker.bound.subject
+}
+
+// Query is like RuleSet.Query, for the subject of the checker.
+func (checker *BoundChecker) Query(action interface{}, resource interface{}) string {
+	return checker.QueryExplain(action, resource).Effect
+}
+
+// IsAllowed is like RuleSet.IsAllowed, for the subject of the checker.
+func (checker *BoundChecker) IsAllowed(action interface{}, resource interface{}) bool {
+	return checker.Query(action, resource) == Allow
+}
+
+// QueryExplain is like RuleSet.QueryExplain, for the subject of the checker.
+func (checker *BoundChecker) QueryExplain(action interface{}, resource interface{}) Decision {
+	decision, _ := checker.QueryDecision(context.Background(), action, resource)
+	return decision
+}
+
+// QueryDecision is like RuleSet.QueryDecision, for the subject of the checker.
+func (checker *BoundChecker) QueryDecision(ctx context.Context, action interface{}, resource interface{}) (Decision, error) {
+	return checker.ruleSet.evaluateWith(ctx, checker.options(), checker.bound.subject, action, resource)
+}
+
+// Enforce is like RuleSet.Enforce, for the subject of the checker.
+func (checker *BoundChecker) Enforce(action interface{}, resource interface{}) error {
+	return checker.EnforceCtx(context.Background(), action, resource)
+}
+
+// EnforceCtx is like RuleSet.EnforceCtx, for the subject of the checker.
+func (checker *BoundChecker) EnforceCtx(ctx context.Context, action interface{}, resource interface{}) error {
+	return checker.ruleSet.enforce(ctx, checker.options(), checker.bound.subject, action, resource)
+}
+
+// FilterAllowed is like RuleSet.FilterAllowed, for the subject of the checker.
+func (checker *BoundChecker) FilterAllowed(action interface{}, resources []interface{}, allowEffect string) []interface{} {
+	var allowed []interface{}
+	for i, ok := range checker.FilterAllowedMask(action, resources, allowEffect) {
+		if ok {
+			allowed = append(allowed, resources[i])
+		}
+	}
+	return allowed
+}
+
+// FilterAllowedMask is like RuleSet.FilterAllowedMask, for the subject of the checker.
+func (checker *BoundChecker) FilterAllowedMask(action interface{}, resources []interface{}, allowEffect string) []bool {
+	bound := checker.bound
+	return checker.ruleSet.filterAllowedMask(bound.table, bound.subject, bound.value, bound, action, resources, allowEffect)
+}
+
+// QueryWithGroups is like RuleSet.QueryWithGroups, for the subject of the checker. The
+// groups are asked to the Membership of the rule set by the first call, and reused by
+// the following ones, unless it fails.
+func (checker *BoundChecker) QueryWithGroups(ctx context.Context, action interface{}, resource interface{}) (MultiDecision, error) {
+	groups, err := checker.groupsOf(ctx)
+	if err != nil {
+		return checker.ruleSet.multiDefault(), err
+	}
+	subjects := make([]interface{}, 1+len(groups))
+	options := make([]queryOptions, 1+len(groups))
+	subjects[0], options[0] = checker.bound.subject, checker.options()
+	for i, group := range groups {
+		subjects[i+1] = group.subject
+		options[i+1] = queryOptions{table: group.table, bound: group}
+	}
+	return checker.ruleSet.queryMulti(ctx, subjects, options, action, resource, true)
+}
+
+func (checker *BoundChecker) groupsOf(ctx context.Context) ([]*boundSubject, error) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	if checker.grouped {
+		return checker.groups, nil
+	}
+	subjects, err := checker.ruleSet.groupsOf(ctx, checker.bound.subject)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]*boundSubject, len(subjects))
+	for i, subject := range subjects {
+		groups[i] = checker.ruleSet.bind(checker.bound.table, subject)
+	}
+	checker.groups, checker.grouped = groups, true
+	return groups, nil
+}
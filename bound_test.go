@@ -0,0 +1,146 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBoundChecker(t *testing.T) {
+	rs := newVideoRuleSet()
+	for _, subject := range videoSubjects {
+		checker := rs.For(subject)
+		for _, action := range videoActions {
+			for _, resource := range videoResources {
+				want := rs.QueryExplain(subject, action, resource)
+				if got := checker.QueryExplain(action, resource); !reflect.DeepEqual(got, want) {
+					t.Errorf("(%v, %v, %v): got %+v want %+v", subject, action, resource, got, want)
+				}
+				if err := checker.Enforce(action, resource); (err == nil) != (want.Effect == ALLOW) || (err != nil && !errors.Is(err, ErrPermissionDenied)) {
+					t.Errorf("(%v, %v, %v): got %v enforcing %q", subject, action, resource, err, want.Effect)
+				}
+			}
+			if got, want := checker.FilterAllowed(action, videoResources, ALLOW), rs.FilterAllowed(subject, action, videoResources, ALLOW); !reflect.DeepEqual(got, want) {
+				t.Errorf("(%v, %v): got %v allowed want %v", subject, action, got, want)
+			}
+		}
+	}
+
+	// the checker keeps the rules it was created with
+	john := &User{Name: "john"}
+	checker := rs.For(john)
+	rs.AddRuleWithPriority(10, &User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, DENY, true
+	})
+	video := &Video{Name: "outro", User: "john", Public: true}
+	if got := checker.Query("view", video); got != ALLOW {
+		t.Errorf("got %q from the checker want the rules at its creation", got)
+	}
+	if got := rs.For(john).Query("view", video); got != DENY {
+		t.Errorf("got %q from a new checker want the current rules", got)
+	}
+}
+
+func TestBoundCheckerSubject(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.NormalizePointers = true
+	rs.AddRule(&User{}, "view", nil, effectMatcher(ALLOW))
+	superusers := 0
+	rs.SuperuserFn = func(subject interface{}) bool {
+		superusers++
+		return subject.(*User).IsSuperuser
+	}
+	rs.Roles = NewRoles()
+	rs.Roles.SubjectKey = userKey
+	rs.Roles.AssignRole("john", "editor")
+	rs.Roles.GrantRole("editor", "modify", nil, ALLOW)
+	rs.RevokeSubject("jack")
+	rs.RevocationKey = userKey
+
+	checker := rs.For(&User{Name: "john"})
+	for i := 0; i < 3; i++ {
+		if !checker.IsAllowed("view", &Video{}) || !checker.IsAllowed("modify", &Video{}) || checker.IsAllowed("delete", &Video{}) {
+			t.Errorf("got the wrong decisions for john")
+		}
+	}
+	if superusers != 1 {
+		t.Errorf("got %d SuperuserFn calls want 1", superusers)
+	}
+	if d := rs.For(&User{Name: "jack"}).QueryExplain("view", &Video{}); !d.Revoked {
+		t.Errorf("got %+v want jack revoked", d)
+	}
+	if d := rs.For(&User{Name: "admin", IsSuperuser: true}).QueryExplain("delete", &Video{}); !d.Superuser {
+		t.Errorf("got %+v want a superuser", d)
+	}
+}
+
+func TestBoundCheckerGroups(t *testing.T) {
+	resolver := &membershipMap{groups: map[string][]string{"john": {"banned", "editors"}}}
+	rs := NewRuleSet(DENY)
+	rs.Membership = resolver
+	rs.AddRule("", "view", nil, effectMatcher(ALLOW))
+	rs.AddRule(GroupKey("editors"), "modify", nil, effectMatcher(ALLOW))
+	rs.AddRule(GroupKey("banned"), "view", nil, effectMatcher(DENY))
+	ctx := context.Background()
+
+	checker := rs.For("john")
+	resolver.err = errors.New("unreachable")
+	if d, err := checker.QueryWithGroups(ctx, "view", "doc"); err != resolver.err || !d.Default {
+		t.Errorf("got %+v, %v want the default effect and the resolver error", d, err)
+	}
+	resolver.err = nil
+	for _, action := range []string{"modify", "view", "delete"} {
+		want, _ := rs.QueryWithGroups(ctx, "john", action, "doc")
+		if got, err := checker.QueryWithGroups(ctx, action, "doc"); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, %v want %+v", action, got, err, want)
+		}
+	}
+	// the failed call, one per QueryWithGroups, and one for the checker
+	if resolver.calls != 5 {
+		t.Errorf("got %d resolver calls want 5", resolver.calls)
+	}
+}
+
+// newBoundBenchmarkRuleSet returns the video rule set, with the settings resolving
+// the subjects of the queries.
+func newBoundBenchmarkRuleSet() *RuleSet {
+	rs := newVideoRuleSet()
+	rs.NormalizePointers = true
+	rs.SuperuserFn = func(subject interface{}) bool {
+		user, ok := subject.(*User)
+		return ok && user.IsSuperuser
+	}
+	rs.RevocationKey = userKey
+	rs.RevokeSubject("banned")
+	rs.AddRule((*NamedOwner)(nil), "view", nil, effectMatcher(DENY))
+	return rs
+}
+
+// BenchmarkBoundQueries runs the ten checks of a request, with a checker bound to the
+// subject.
+func BenchmarkBoundQueries(b *testing.B) {
+	rs := newBoundBenchmarkRuleSet()
+	john := &User{Name: "john"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		checker := rs.For(john)
+		for j := 0; j < 10; j++ {
+			checker.Query(videoActions[j%2], videoResources[j%len(videoResources)])
+		}
+	}
+}
+
+// BenchmarkUnboundQueries runs the checks of BenchmarkBoundQueries with Query.
+func BenchmarkUnboundQueries(b *testing.B) {
+	rs := newBoundBenchmarkRuleSet()
+	john := &User{Name: "john"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			rs.Query(john, videoActions[j%2], videoResources[j%len(videoResources)])
+		}
+	}
+}
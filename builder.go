@@ -0,0 +1,310 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import "errors"
+
+var (
+	// ErrMissingEffect is returned by RuleBuilder.Build when the rule has neither an
+	// effect nor a matcher.
+	ErrMissingEffect = errors.New("perms: rule without effect")
+	// ErrNilPredicate is returned by RuleBuilder.Build when the predicate given to
+	// When is nil.
+	ErrNilPredicate = errors.New("perms: nil rule predicate")
+	// ErrNilMatcher is returned by AddRules and UpsertRule for a RuleSpec without
+	// matcher, and by RuleBuilder.Build when the matcher given to Matcher is nil.
+	ErrNilMatcher = errors.New("perms: nil rule matcher")
+)
+
+// RuleSpec describes a rule to add with AddRules, usually produced by a RuleBuilder.
+// The fields are the arguments of AddRuleCtx and AddRuleWithPriority, and the id of
+// the rule, generated if empty.
+//
+// Declarative, if not nil, describes the rule instead of the templates, the matcher
+// and the priority, which are ignored, as for AddDeclarativeRule: the specs stored by
+// an Adapter are usually declarative.
+type RuleSpec struct {
+	ID          RuleID
+	Subject     interface{}
+	Action      interface{}
+	Resource    interface{}
+	Matcher     MatcherCtxFn
+	Priority    int
+	Options     []RuleOption
+	Declarative *DeclarativeRule
+}
+
+// AddRules adds the rules described by specs, in order, returning their ids. The
+// rules are added at once: the queries see either none or all of them, and nothing is
+// added if a spec is invalid: ErrNilMatcher is returned for a spec with a nil matcher,
+// ErrDuplicateRuleID for an id in use, or the error of a declarative rule.
+//
+//	specs, err := perms.NewRule().ForSubject(&User{}).ForActions("view", "list").ForResource(&Video{}).
+//		When(isOwner).Effect(perms.ALLOW).Build()
+//	if err == nil {
+//		_, err = rs.AddRules(specs...)
+//	}
+func (ruleSet *RuleSet) AddRules(specs ...RuleSpec) ([]RuleID, error) {
+	rules := make([]*Rule, len(specs))
+	for i, spec := range specs {
+		rule, err := ruleSet.specRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules[i] = rule
+	}
+	if err := ruleSet.addRules(rules...); err != nil {
+		return nil, err
+	}
+	ids := make([]RuleID, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.id
+	}
+	return ids, nil
+}
+
+// specRule returns the rule described by spec.
+func (ruleSet *RuleSet) specRule(spec RuleSpec) (*Rule, error) {
+	var rule *Rule
+	if spec.Declarative != nil {
+		var err error
+		if rule, err = ruleSet.newDeclarativeRule(*spec.Declarative); err != nil {
+			return nil, err
+		}
+	} else {
+		if spec.Matcher == nil {
+			return nil, ErrNilMatcher
+		}
+		rule = newRule(spec.Subject, spec.Action, spec.Resource, spec.Matcher)
+		rule.priority = spec.Priority
+	}
+	rule.apply(spec.Options)
+	if spec.ID != "" {
+		rule.id = spec.ID
+	}
+	return rule, nil
+}
+
+// RuleBuilder builds the RuleSpecs of a rule step by step, see NewRule. The methods
+// return the builder itself so that the calls can be chained, and the mistakes are
+// reported by Build.
+type RuleBuilder struct {
+	subject   interface{}
+	actions   []interface{}
+	resource  interface{}
+	predicate Predicate
+	when      bool
+	matcher   MatcherCtxFn
+	matcherOk bool
+	effect    string
+	otherwise string
+	quick     bool
+	priority  int
+	options   []RuleOption
+}
+
+// NewRule returns a builder for a rule matching any subject, action and resource,
+// until restricted with ForSubject, ForActions and ForResource.
+//
+// The rule either produces the Effect for the triples satisfying the When predicate,
+// or for all of them without one, or runs the matcher given with Matcher.
+func NewRule() *RuleBuilder {
+	return &RuleBuilder{}
+}
+
+// ForSubject restricts the rule to the subjects of the type of subjectType, like the
+// subjectType argument of AddRule.
+func (b *RuleBuilder) ForSubject(subjectType interface{}) *RuleBuilder {
+	b.subject = subjectType
+	return b
+}
+
+// ForAction restricts the rule to action.
+func (b *RuleBuilder) ForAction(action interface{}) *RuleBuilder {
+	return b.ForActions(action)
+}
+
+// ForActions restricts the rule to the given actions: Build produces a RuleSpec for
+// each of them, in order.
+func (b *RuleBuilder) ForActions(actions ...interface{}) *RuleBuilder {
+	b.actions = append(b.actions[:len(b.actions):len(b.actions)], actions...)
+	return b
+}
+
+// ForResource restricts the rule to the resources of the type of resourceType, like
+// the resourceType argument of AddRule.
+func (b *RuleBuilder) ForResource(resourceType interface{}) *RuleBuilder {
+	b.resource = resourceType
+	return b
+}
+
+// When makes the rule match only the triples satisfying predicate, unless an
+// Otherwise effect is set.
+func (b *RuleBuilder) When(predicate Predicate) *RuleBuilder {
+	b.predicate = predicate
+	b.when = true
+	return b
+}
+
+// Effect sets the effect produced by the rule.
+func (b *RuleBuilder) Effect(effect string) *RuleBuilder {
+	b.effect = effect
+	return b
+}
+
+// Otherwise sets the effect produced, never quick, for the triples not satisfying the
+// When predicate, which otherwise don't match the rule.
+func (b *RuleBuilder) Otherwise(effect string) *RuleBuilder {
+	b.otherwise = effect
+	return b
+}
+
+// Quick makes the Effect quick, ending the evaluation. See MatcherFn.
+func (b *RuleBuilder) Quick() *RuleBuilder {
+	b.quick = true
+	return b
+}
+
+// Matcher makes the rule run matcher, instead of producing the Effect for the triples
+// satisfying the When predicate, which are then ignored.
+func (b *RuleBuilder) Matcher(matcher MatcherFn) *RuleBuilder {
+	if matcher == nil {
+		return b.MatcherCtx(nil)
+	}
+	return b.MatcherCtx(matcher.ErrFn().CtxFn())
+}
+
+// MatcherCtx is like Matcher, but takes a matcher receiving the query context.
+func (b *RuleBuilder) MatcherCtx(matcher MatcherCtxFn) *RuleBuilder {
+	b.matcher = matcher
+	b.matcherOk = true
+	return b
+}
+
+// Priority sets the priority of the rule, see AddRuleWithPriority.
+func (b *RuleBuilder) Priority(priority int) *RuleBuilder {
+	b.priority = priority
+	return b
+}
+
+// With adds metadata options to the rule, like Named and Tagged.
+func (b *RuleBuilder) With(options ...RuleOption) *RuleBuilder {
+	b.options = append(b.options[:len(b.options):len(b.options)], options...)
+	return b
+}
+
+// Build returns the RuleSpecs of the rule, one per action given to ForActions, or a
+// single one for any action. It returns ErrNilMatcher or ErrNilPredicate if Matcher
+// or When were given nil, and ErrMissingEffect if the rule has neither a matcher nor
+// an effect.
+func (b *RuleBuilder) Build() ([]RuleSpec, error) {
+	matcher, err := b.buildMatcher()
+	if err != nil {
+		return nil, err
+	}
+	actions := b.actions
+	if len(actions) == 0 {
+		actions = []interface{}{nil}
+	}
+	specs := make([]RuleSpec, len(actions))
+	for i, action := range actions {
+		specs[i] = RuleSpec{
+			Subject:  b.subject,
+			Action:   action,
+			Resource: b.resource,
+			Matcher:  matcher,
+			Priority: b.priority,
+			Options:  b.options[:len(b.options):len(b.options)],
+		}
+	}
+	return specs, nil
+}
+
+func (b *RuleBuilder) buildMatcher() (MatcherCtxFn, error) {
+	if b.matcherOk {
+		if b.matcher == nil {
+			return nil, ErrNilMatcher
+		}
+		return b.matcher, nil
+	}
+	if b.when && b.predicate == nil {
+		return nil, ErrNilPredicate
+	}
+	if b.effect == "" {
+		return nil, ErrMissingEffect
+	}
+	effect, otherwise, quick := b.effect, b.otherwise, b.quick
+	if !b.when {
+		return MatcherFn(func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+			return true, effect, quick
+		}).ErrFn().CtxFn(), nil
+	}
+	predicate := b.predicate
+	return MatcherFn(func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		if predicate(subject, action, resource) {
+			return true, effect, quick
+		}
+		if otherwise != "" {
+			return true, otherwise, false
+		}
+		return false, "", false
+	}).ErrFn().CtxFn(), nil
+}
+
+// UpsertRule adds the rule described by spec under id, or replaces the rule with that
+// id, including its templates, matcher, priority and metadata, keeping its position in
+// the evaluation order, its namespace and whether it is disabled. The rule moves when
+// its position can't be kept: when the templates change the types triple the rule
+// is registered under, or the priority changes, it is evaluated after the rules of its
+// new place with the same or higher priority, as if just added. The counters of
+// the replaced rule are reset.
+//
+// UpsertRule returns true if the rule was added, false if it was replaced, and
+// ErrNilMatcher, changing nothing, if the spec has a nil matcher, or the error of a
+// declarative spec. The id of the spec is ignored.
+func (ruleSet *RuleSet) UpsertRule(id RuleID, spec RuleSpec) (inserted bool, err error) {
+	rule, err := ruleSet.specRule(spec)
+	if err != nil {
+		return false, err
+	}
+	rule.id = id
+	for {
+		ruleSet.rules.mu.Lock()
+		current := ruleSet.byID[id]
+		ruleSet.rules.mu.Unlock()
+		namespace := ""
+		if current != nil {
+			namespace = current.namespace
+		}
+		done := false
+		ruleSet.updateIn(namespace, func(w *tableWriter) {
+			if ruleSet.byID[id] != current {
+				// changed meanwhile, in another namespace maybe
+				return
+			}
+			done = true
+			if current == nil {
+				w.add(rule)
+				return
+			}
+			rule.disabled = current.disabled
+			if rule.types() != current.types() || rule.priority != current.priority {
+				w.remove(current)
+				w.add(rule)
+				return
+			}
+			rule.namespace = current.namespace
+			rule.seq = current.seq
+			rule.counters = &ruleCounters{stats: ruleSet.stats}
+			w.replace(current, rule)
+			// the templates indexed may have changed
+			w.dirty[rule.types()] = true
+		})
+		if done {
+			return current == nil, nil
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newVideoRuleSetBuilt is newVideoRuleSet, expressed with the rule builder.
+func newVideoRuleSetBuilt(t *testing.T) *RuleSet {
+	t.Helper()
+	isSuperuser := func(subj interface{}, act interface{}, res interface{}) bool {
+		return subj.(*User).IsSuperuser
+	}
+	ownerOrPublic := func(public func(res interface{}) bool, owner func(res interface{}) string) MatcherFn {
+		return Or(
+			WithEffect(isSuperuser, ALLOW, true),
+			WithEffect(func(subj interface{}, act interface{}, res interface{}) bool {
+				return public(res) || owner(res) == subj.(*User).Name
+			}, ALLOW, false),
+			effectMatcher(DENY))
+	}
+	playlistOwner := func(res interface{}) string { return res.(*Playlist).User }
+	videoOwner := func(res interface{}) string { return res.(*Video).User }
+	builders := []*RuleBuilder{
+		NewRule().ForSubject(&User{}).ForAction("view").ForResource(&Playlist{}).
+			Matcher(ownerOrPublic(func(res interface{}) bool { return res.(*Playlist).Public }, playlistOwner)),
+		NewRule().ForSubject(&User{}).ForAction("modify").ForResource(&Playlist{}).
+			When(func(subj interface{}, act interface{}, res interface{}) bool {
+				return playlistOwner(res) == subj.(*User).Name
+			}).Effect(ALLOW).Otherwise(DENY),
+		NewRule().ForSubject(&User{}).ForAction("view").ForResource(&Video{}).
+			Matcher(ownerOrPublic(func(res interface{}) bool { return res.(*Video).Public }, videoOwner)),
+		NewRule().ForSubject(&User{}).ForAction("modify").ForResource(&Video{}).
+			Matcher(ownerOrPublic(func(res interface{}) bool { return false }, videoOwner)),
+		NewRule().ForSubject(&Group{}).ForAction("modify").ForResource(&Playlist{}).
+			When(func(subj interface{}, act interface{}, res interface{}) bool {
+				return res.(*Playlist).Group == subj.(*Group).Name
+			}).Effect(ALLOW).Otherwise(DENY),
+		NewRule().ForSubject(&User{}).ForAction("view").
+			When(isSuperuser).Effect(ALLOW).Quick().Otherwise(DENY),
+	}
+	rs := NewRuleSet(DENY)
+	var specs []RuleSpec
+	for _, b := range builders {
+		built, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, built...)
+	}
+	if _, err := rs.AddRules(specs...); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestRuleBuilder(t *testing.T) {
+	want := newVideoRuleSet()
+	rs := newVideoRuleSetBuilt(t)
+	checkSameDecisions(t, rs, want)
+	if got, want := len(rs.Rules()), len(want.Rules()); got != want {
+		t.Errorf("got %d rules want %d", got, want)
+	}
+}
+
+func TestRuleBuilderActions(t *testing.T) {
+	isPublic := func(subj interface{}, act interface{}, res interface{}) bool {
+		return res.(*Video).Public
+	}
+	specs, err := NewRule().ForSubject(&User{}).ForActions("view", "list").ForResource(&Video{}).
+		When(isPublic).Effect(ALLOW).Priority(2).With(Tagged("public")).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 || specs[0].Action != "view" || specs[1].Action != "list" {
+		t.Fatalf("got %+v want a spec per action", specs)
+	}
+	rs := NewRuleSet(DENY)
+	ids, err := rs.AddRules(specs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []RuleID{"rule-1", "rule-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got ids %v want %v", ids, want)
+	}
+	for _, info := range rs.Rules() {
+		if info.Priority != 2 || !reflect.DeepEqual(info.Tags, []string{"public"}) {
+			t.Errorf("got %+v want priority 2 and the public tag", info)
+		}
+	}
+	john := &User{Name: "john"}
+	for _, action := range []string{"view", "list"} {
+		if !rs.IsAllowed(john, action, &Video{Public: true}) {
+			t.Errorf("got %s of a public video denied want allowed", action)
+		}
+		if rs.IsAllowed(john, action, &Video{}) {
+			t.Errorf("got %s of a private video allowed want denied", action)
+		}
+	}
+	if rs.IsAllowed(john, "modify", &Video{Public: true}) {
+		t.Errorf("got modify allowed want denied")
+	}
+
+	// without actions, a single rule for any action
+	specs, err = NewRule().ForSubject(&User{}).Effect(ALLOW).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 || specs[0].Action != nil {
+		t.Errorf("got %+v want a spec for any action", specs)
+	}
+}
+
+func TestRuleBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *RuleBuilder
+		want    error
+	}{
+		{"missing effect", NewRule().ForSubject(&User{}), ErrMissingEffect},
+		{"missing effect with predicate", NewRule().When(func(s, a, r interface{}) bool { return true }), ErrMissingEffect},
+		{"nil predicate", NewRule().When(nil).Effect(ALLOW), ErrNilPredicate},
+		{"nil matcher", NewRule().Matcher(nil), ErrNilMatcher},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if specs, err := tt.builder.Build(); err != tt.want || specs != nil {
+				t.Errorf("got %v, %v want %v", specs, err, tt.want)
+			}
+		})
+	}
+
+	rs := NewRuleSet(DENY)
+	valid := RuleSpec{Subject: &User{}, Matcher: effectMatcher(ALLOW).ErrFn().CtxFn()}
+	if _, err := rs.AddRules(valid, RuleSpec{Subject: &User{}}); err != ErrNilMatcher {
+		t.Errorf("got %v want ErrNilMatcher", err)
+	}
+	if n := len(rs.Rules()); n != 0 {
+		t.Errorf("got %d rules want none added", n)
+	}
+}
+
+func TestUpsertRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	for _, effect := range []string{"first", "second", "third"} {
+		rs.AddRule(&User{}, "view", &Video{}, func(effect string) MatcherFn {
+			return func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+				return true, effect, false
+			}
+		}(effect), Named(effect))
+	}
+	rs.Combining = FirstApplicable
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Video{}); got != "first" {
+		t.Fatalf("got %q want first", got)
+	}
+
+	// the first rule replaced in place, now not matching for john
+	inserted, err := rs.UpsertRule("rule-1", RuleSpec{
+		Subject: &User{}, Action: "view", Resource: &Video{},
+		Matcher: WithEffect(func(subj interface{}, act interface{}, res interface{}) bool {
+			return subj.(*User).Name == "jack"
+		}, "replaced", false).ErrFn().CtxFn(),
+		Options: []RuleOption{Named("replaced")},
+	})
+	if err != nil || inserted {
+		t.Fatalf("got %v, %v want an update", inserted, err)
+	}
+	if got := rs.Query(john, "view", &Video{}); got != "second" {
+		t.Errorf("got %q want second", got)
+	}
+	if got := rs.Query(&User{Name: "jack"}, "view", &Video{}); got != "replaced" {
+		t.Errorf("got %q want replaced", got)
+	}
+	// a middle rule
+	if _, err := rs.UpsertRule("rule-2", RuleSpec{
+		Subject: &User{}, Action: "view", Resource: &Video{},
+		Matcher: effectMatcher("middle").ErrFn().CtxFn(),
+		Options: []RuleOption{Named("middle")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(john, "view", &Video{}); got != "middle" {
+		t.Errorf("got %q want middle", got)
+	}
+	var names []string
+	for _, info := range rs.Rules() {
+		names = append(names, info.Name)
+	}
+	if want := []string{"replaced", "middle", "third"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v want %v", names, want)
+	}
+
+	// a changed types triple moves the rule
+	if _, err := rs.UpsertRule("rule-2", RuleSpec{
+		Subject: &User{}, Action: "view", Resource: &Playlist{},
+		Matcher: effectMatcher("moved").ErrFn().CtxFn(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(john, "view", &Video{}); got != "third" {
+		t.Errorf("got %q want third", got)
+	}
+	if got := rs.Query(john, "view", &Playlist{}); got != "moved" {
+		t.Errorf("got %q want moved", got)
+	}
+
+	inserted, err = rs.UpsertRule("synced", RuleSpec{Subject: &Group{}, Matcher: effectMatcher(ALLOW).ErrFn().CtxFn()})
+	if err != nil || !inserted {
+		t.Errorf("got %v, %v want an insert", inserted, err)
+	}
+	if !rs.IsAllowed(&Group{}, "delete", &Video{}) {
+		t.Errorf("got the inserted rule not applied")
+	}
+	if n := len(rs.Rules()); n != 4 {
+		t.Errorf("got %d rules want 4", n)
+	}
+	if _, err := rs.UpsertRule("synced", RuleSpec{Subject: &Group{}}); err != ErrNilMatcher {
+		t.Errorf("got %v want ErrNilMatcher", err)
+	}
+}
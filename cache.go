@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheKeyFn returns a stable key identifying the (subject, action, resource) triple,
+// or false if the triple must not be cached (eg. pointers to values without an ID).
+// Triples with the same key must always produce the same decision.
+type CacheKeyFn func(subject interface{}, action interface{}, resource interface{}) (string, bool)
+
+// Cache stores the effects of the decisions outside the rule set, eg. in a server
+// shared by the replicas of a program, see WithSharedCache. The package rediscache
+// implements a Cache over Redis. The methods must be safe for concurrent use.
+type Cache interface {
+	// Get returns the effect stored for key, or false if missing, expired, or stored
+	// before the last InvalidateAll.
+	Get(key string) (effect string, ok bool)
+	// Set stores the effect for key, expiring after ttl.
+	Set(key string, effect string, ttl time.Duration)
+	// InvalidateAll drops all the stored effects, for every user of the Cache.
+	InvalidateAll()
+}
+
+// decisionCache is a LRU cache of decisions, or a Cache of their effects, safe for
+// concurrent use.
+type decisionCache struct {
+	keyFn      CacheKeyFn
+	maxEntries int
+	// shared, if not nil, stores the effects for ttl in place of the entries
+	shared Cache
+	ttl    time.Duration
+
+	// mu is held exclusively to change the entries or the generation, and shared to
+	// store an effect in the shared cache
+	mu         sync.RWMutex
+	generation uint64
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type cacheEntry struct {
+	key      string
+	decision Decision
+}
+
+// WithCache enables a cache of the decisions produced by queries, holding up to maxEntries
+// decisions identified by the keys returned by keyFn, and evicting the least recently used
+// ones. A maxEntries <= 0 disables the cache. It returns the rule set, to allow chaining.
+// The cache is cleared whenever a rule is added or removed; call PurgeCache after
+// changing anything else affecting the decisions, like the DefaultEffect or the Roles.
+// Decisions of queries returning an error are not cached, and cached decisions are
+// returned without evaluating the matchers, regardless of the query context.
+func (ruleSet *RuleSet) WithCache(maxEntries int, keyFn CacheKeyFn) *RuleSet {
+	if maxEntries <= 0 || keyFn == nil {
+		ruleSet.cache = nil
+		return ruleSet
+	}
+	ruleSet.cache = &decisionCache{
+		keyFn:      keyFn,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	return ruleSet
+}
+
+// WithSharedCache is like WithCache, but stores the effects of the decisions in cache,
+// eg. shared with the other replicas of the program, for ttl. The shared cache is
+// invalidated, with InvalidateAll, whenever a rule is added or removed, or PurgeCache
+// is called, so that the decisions cached by the other users of the cache with the
+// previous rules are not used anymore. A nil cache disables the cache.
+//
+// Only the effect of a decision is stored: a cached decision has none of the details
+// of an evaluation, like the Rule, and the decisions with obligations are not cached.
+// Neither are the default decisions, which a stored effect could not tell from those
+// of the rules, eg. for QueryMatched and the RuleChain.
+func (ruleSet *RuleSet) WithSharedCache(cache Cache, ttl time.Duration, keyFn CacheKeyFn) *RuleSet {
+	if cache == nil || keyFn == nil {
+		ruleSet.cache = nil
+		return ruleSet
+	}
+	ruleSet.cache = &decisionCache{
+		keyFn:  keyFn,
+		shared: cache,
+		ttl:    ttl,
+	}
+	return ruleSet
+}
+
+// PurgeCache removes all the decisions from the cache enabled with WithCache or
+// WithSharedCache.
+func (ruleSet *RuleSet) PurgeCache() {
+	ruleSet.cache.purge()
+}
+
+// get returns the decision cached for key, and the current generation of the cache,
+// to be passed to add.
+func (cache *decisionCache) get(key string) (Decision, uint64, bool) {
+	if cache.shared != nil {
+		cache.mu.RLock()
+		generation := cache.generation
+		cache.mu.RUnlock()
+		effect, ok := cache.shared.Get(key)
+		return Decision{Effect: effect}, generation, ok
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return Decision{}, cache.generation, false
+	}
+	cache.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).decision, cache.generation, true
+}
+
+// add caches decision for key, unless the cache was purged after generation.
+func (cache *decisionCache) add(key string, decision Decision, generation uint64) {
+	if cache.shared != nil {
+		if len(decision.Obligations) > 0 || decision.Default {
+			return
+		}
+		// not stored after a concurrent purge, which waits
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		if generation == cache.generation {
+			cache.shared.Set(key, decision.Effect, cache.ttl)
+		}
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*cacheEntry).decision = decision
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.lru.PushFront(&cacheEntry{key: key, decision: decision})
+	if cache.lru.Len() > cache.maxEntries {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (cache *decisionCache) purge() {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.generation++
+	if cache.shared != nil {
+		cache.shared.InvalidateAll()
+		return
+	}
+	cache.entries = make(map[string]*list.Element)
+	cache.lru.Init()
+}
+
+func (cache *decisionCache) len() int {
+	if cache.shared != nil {
+		return 0
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.lru.Len()
+}
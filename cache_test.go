@@ -0,0 +1,244 @@
+package perms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func playlistKey(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+	user, ok := subject.(*User)
+	if !ok {
+		return "", false
+	}
+	playlist, ok := resource.(*Playlist)
+	if !ok || playlist.ID == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s|%v|%s", user.Name, action, playlist.ID), true
+}
+
+func TestCache(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, playlistKey)
+	calls := 0
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			calls++
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "john"}
+	for i := 0; i < 3; i++ {
+		if got := rs.Query(john, "view", playlist); got != ALLOW {
+			t.Errorf("got %q want %q", got, ALLOW)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d matcher calls want 1", calls)
+	}
+
+	// uncacheable triples are always evaluated
+	calls = 0
+	anonymous := &Playlist{User: "john"}
+	rs.Query(john, "view", anonymous)
+	rs.Query(john, "view", anonymous)
+	if calls != 2 {
+		t.Errorf("got %d matcher calls want 2", calls)
+	}
+
+	// adding a rule invalidates the cache
+	id := rs.AddRuleWithPriority(1, &User{}, "view", &Playlist{}, quickMatcher(DENY))
+	if got := rs.Query(john, "view", playlist); got != DENY {
+		t.Errorf("got %q want %q after adding a rule", got, DENY)
+	}
+	// and so does removing it
+	if err := rs.RemoveRule(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(john, "view", playlist); got != ALLOW {
+		t.Errorf("got %q want %q after removing the rule", got, ALLOW)
+	}
+
+	rs.DefaultEffect = "none"
+	jack := &User{Name: "jack"}
+	if got := rs.Query(jack, "view", playlist); got != "none" {
+		t.Errorf("got %q want %q", got, "none")
+	}
+	rs.DefaultEffect = DENY
+	if got := rs.Query(jack, "view", playlist); got != "none" {
+		t.Errorf("got %q want the cached %q", got, "none")
+	}
+	rs.PurgeCache()
+	if got := rs.Query(jack, "view", playlist); got != DENY {
+		t.Errorf("got %q want %q after purging the cache", got, DENY)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(2, playlistKey)
+	calls := 0
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			calls++
+			return true, ALLOW, false
+		})
+
+	john := &User{Name: "john"}
+	first, second, third := &Playlist{ID: "1"}, &Playlist{ID: "2"}, &Playlist{ID: "3"}
+	rs.Query(john, "view", first)
+	rs.Query(john, "view", second)
+	rs.Query(john, "view", first) // second is now the least recently used
+	rs.Query(john, "view", third)
+	if got := rs.cache.len(); got != 2 {
+		t.Errorf("got %d cached decisions want 2", got)
+	}
+
+	calls = 0
+	rs.Query(john, "view", first)
+	rs.Query(john, "view", third)
+	if calls != 0 {
+		t.Errorf("got %d matcher calls want 0", calls)
+	}
+	rs.Query(john, "view", second)
+	if calls != 1 {
+		t.Errorf("got %d matcher calls want 1 for the evicted decision", calls)
+	}
+}
+
+func newCacheBenchmarkRuleSet() *RuleSet {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < 100; i++ {
+		owner := fmt.Sprintf("user-%d", i)
+		rs.AddRule(&User{}, "view", &Playlist{},
+			func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+				return res.(*Playlist).User == owner, ALLOW, false
+			})
+	}
+	rs.AddRule(nil, nil, nil, effectMatcher(DENY))
+	return rs
+}
+
+func benchmarkQuery(b *testing.B, rs *RuleSet) {
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "user-99"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query(john, "view", playlist) != ALLOW {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func BenchmarkUncachedQuery(b *testing.B) {
+	benchmarkQuery(b, newCacheBenchmarkRuleSet())
+}
+
+func BenchmarkCachedQuery(b *testing.B) {
+	benchmarkQuery(b, newCacheBenchmarkRuleSet().WithCache(1000, playlistKey))
+}
+
+// mapCache is a Cache over a map, ignoring the ttl.
+type mapCache struct {
+	mu          sync.Mutex
+	effects     map[string]string
+	invalidated int
+}
+
+func (cache *mapCache) Get(key string) (string, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	effect, ok := cache.effects[key]
+	return effect, ok
+}
+
+func (cache *mapCache) Set(key string, effect string, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.effects[key] = effect
+}
+
+func (cache *mapCache) InvalidateAll() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.effects = make(map[string]string)
+	cache.invalidated++
+}
+
+func TestSharedCache(t *testing.T) {
+	shared := &mapCache{effects: make(map[string]string)}
+	first := NewRuleSet(DENY).WithSharedCache(shared, time.Minute, playlistKey)
+	calls := 0
+	first.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			calls++
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+	if shared.invalidated != 1 {
+		t.Errorf("got %d invalidations want 1 adding a rule", shared.invalidated)
+	}
+	second := first.Clone().WithSharedCache(shared, time.Minute, playlistKey)
+
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "john"}
+	if got := first.Query(john, "view", playlist); got != ALLOW || shared.effects["john|view|6563"] != ALLOW {
+		t.Errorf("got %q, cached %v want allow cached", got, shared.effects)
+	}
+	for i := 0; i < 3; i++ {
+		if d := second.QueryExplain(john, "view", playlist); d.Effect != ALLOW || d.Rule != nil {
+			t.Errorf("got %+v want the cached effect only", d)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d matcher calls want 1", calls)
+	}
+	if clone := first.Clone(); clone.cache != nil {
+		t.Errorf("got the shared cache used by the clone")
+	}
+
+	// a change of the rules of either invalidates the decisions of both
+	second.AddRuleWithPriority(1, &User{}, "view", &Playlist{}, quickMatcher(DENY))
+	if len(shared.effects) != 0 {
+		t.Errorf("got %v cached after adding a rule", shared.effects)
+	}
+	first.PurgeCache()
+	if shared.invalidated != 3 {
+		t.Errorf("got %d invalidations want 3", shared.invalidated)
+	}
+
+	// the decisions with obligations are not cached
+	rs := NewRuleSet(DENY).WithSharedCache(shared, time.Minute, playlistKey)
+	rs.AddRuleWithObligations(&User{}, "view", &Playlist{},
+		func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, []Obligation, error) {
+			return true, ALLOW, false, []Obligation{{Name: "log"}}, nil
+		})
+	if d := rs.QueryExplain(john, "view", playlist); d.Effect != ALLOW || len(d.Obligations) != 1 || len(shared.effects) != 0 {
+		t.Errorf("got %+v, cached %v want the obligations, not cached", d, shared.effects)
+	}
+}
+
+func TestSharedCacheDefault(t *testing.T) {
+	shared := &mapCache{effects: make(map[string]string)}
+	rs := NewRuleSet(DENY).WithSharedCache(shared, time.Minute, playlistKey)
+	fallback := NewRuleSet(DENY)
+	fallback.AddRule(&User{}, "view", &Playlist{}, effectMatcher(ALLOW))
+	chain := Chain(rs, fallback)
+
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "jack"}
+	// the default decisions are evaluated again, not taken for those of a rule
+	for i := 0; i < 2; i++ {
+		if effect, matched := rs.QueryMatched(john, "view", playlist); effect != DENY || matched {
+			t.Errorf("got %q, %v want the default effect", effect, matched)
+		}
+		if got := chain.Query(john, "view", playlist); got != ALLOW {
+			t.Errorf("got %q want %q from the next rule set", got, ALLOW)
+		}
+	}
+	if len(shared.effects) != 0 {
+		t.Errorf("got %v cached want the default decisions not cached", shared.effects)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CasbinOption configures LoadCasbinCSV.
+type CasbinOption func(loader *casbinLoader)
+
+// WithCasbinGrouping sets a function called for each grouping line ("g, user, role")
+// loaded, eg. to record the role assignments elsewhere.
+func WithCasbinGrouping(fn func(subject string, role string)) CasbinOption {
+	return func(loader *casbinLoader) {
+		loader.groupingFn = fn
+	}
+}
+
+type casbinLoader struct {
+	groupingFn func(subject string, role string)
+}
+
+// casbinPolicy is a policy line ("p, subject, object, action[, effect]").
+type casbinPolicy struct {
+	subject, object, action string
+	effect                  Effect
+}
+
+// LoadCasbinCSV reads a Casbin policy file in CSV form and adds its rules to ruleSet.
+//
+// Each policy line "p, subject, object, action[, effect]" becomes a rule with the string
+// templates subject, action and object (as resource), whose matcher returns the effect,
+// Allow if omitted. Subjects, objects and actions are compared literally: the Casbin
+// matcher functions (keyMatch and the like) are not supported.
+//
+// Each grouping line "g, subject, role" assigns role to subject: the policy lines
+// of role also apply to the subjects holding it, directly or through other roles.
+// If ruleSet.Roles is set the assignments are added to it, and later changes to
+// the assignments of the Roles layer are taken into account by the rules. The
+// WithCasbinGrouping option reports the grouping lines.
+//
+// Empty lines and lines starting with "#" are skipped. If a line is malformed no rule
+// is added, and the returned error reports its line number.
+//
+// With the usual Casbin policy effect, allowing when some policy allows and none
+// denies, ruleSet.Combining should be set to DenyOverrides.
+func LoadCasbinCSV(ruleSet *RuleSet, r io.Reader, opts ...CasbinOption) error {
+	loader := &casbinLoader{}
+	for _, opt := range opts {
+		opt(loader)
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var policies []casbinPolicy
+	var groupings [][2]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return fmt.Errorf("perms: line %d: %v", parseErr.Line, parseErr.Err)
+			}
+			return fmt.Errorf("perms: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		switch record[0] {
+		case "p":
+			if len(record) != 4 && len(record) != 5 {
+				return fmt.Errorf("perms: line %d: a policy line must have 3 or 4 fields, got %d", line, len(record)-1)
+			}
+			policy := casbinPolicy{subject: record[1], object: record[2], action: record[3], effect: Allow}
+			if len(record) == 5 {
+				policy.effect = record[4]
+			}
+			if policy.subject == "" || policy.object == "" || policy.action == "" || policy.effect == "" {
+				return fmt.Errorf("perms: line %d: empty field in policy line", line)
+			}
+			policies = append(policies, policy)
+		case "g":
+			if len(record) != 3 {
+				return fmt.Errorf("perms: line %d: a grouping line must have 2 fields, got %d", line, len(record)-1)
+			}
+			if record[1] == "" || record[2] == "" {
+				return fmt.Errorf("perms: line %d: empty field in grouping line", line)
+			}
+			groupings = append(groupings, [2]string{record[1], record[2]})
+		default:
+			return fmt.Errorf("perms: line %d: unsupported line type %q", line, record[0])
+		}
+	}
+
+	isRole := make(map[string]bool)
+	for _, grouping := range groupings {
+		isRole[grouping[1]] = true
+	}
+	var rolesOf func(subjectKey string) []string
+	if roles := ruleSet.Roles; roles != nil {
+		rolesOf = roles.RolesOf
+		for _, grouping := range groupings {
+			roles.AssignRole(grouping[0], grouping[1])
+		}
+	} else {
+		assignments := make(map[string][]string)
+		for _, grouping := range groupings {
+			assignments[grouping[0]] = append(assignments[grouping[0]], grouping[1])
+		}
+		rolesOf = func(subjectKey string) []string {
+			return assignments[subjectKey]
+		}
+	}
+	if loader.groupingFn != nil {
+		for _, grouping := range groupings {
+			loader.groupingFn(grouping[0], grouping[1])
+		}
+	}
+
+	for _, policy := range policies {
+		effect := policy.effect
+		if !isRole[policy.subject] {
+			ruleSet.AddRule(policy.subject, policy.action, policy.object, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+				return true, effect, false
+			})
+			continue
+		}
+		// the empty string template admits any subject, holding the role or not
+		role := policy.subject
+		ruleSet.AddRule("", policy.action, policy.object, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if !holdsRole(rolesOf, subj.(string), role) {
+				return false, "", false
+			}
+			return true, effect, false
+		})
+	}
+	return nil
+}
+
+// holdsRole reports whether subjectKey is role, or holds it directly or through the
+// roles it holds.
+func holdsRole(rolesOf func(subjectKey string) []string, subjectKey string, role string) bool {
+	visited := map[string]bool{subjectKey: true}
+	pending := []string{subjectKey}
+	for len(pending) > 0 {
+		key := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if key == role {
+			return true
+		}
+		for _, held := range rolesOf(key) {
+			if !visited[held] {
+				visited[held] = true
+				pending = append(pending, held)
+			}
+		}
+	}
+	return false
+}
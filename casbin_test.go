@@ -0,0 +1,106 @@
+package perms
+
+import (
+	"strings"
+	"testing"
+)
+
+const casbinPolicyCSV = `# users
+p, alice, data1, read
+p, bob, data2, write
+p, eve, data1, read, deny
+
+# roles
+p, data2_admin, data2, read
+p, data2_admin, data2, write
+p, admin, data1, write
+p, admin, data1, read
+p, auditor, data2, write, deny
+
+g, alice, data2_admin
+g, carol, admin
+g, admin, data2_admin
+g, dave, auditor
+g, dave, data2_admin
+`
+
+func TestLoadCasbinCSV(t *testing.T) {
+	for _, withRoles := range []bool{false, true} {
+		rs := NewRuleSet(Deny)
+		rs.Combining = DenyOverrides
+		if withRoles {
+			rs.Roles = NewRoles()
+		}
+		var groupings []string
+		err := LoadCasbinCSV(rs, strings.NewReader(casbinPolicyCSV), WithCasbinGrouping(func(subject string, role string) {
+			groupings = append(groupings, subject+":"+role)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Join(groupings, " "), "alice:data2_admin carol:admin admin:data2_admin dave:auditor dave:data2_admin"; got != want {
+			t.Errorf("got groupings %q want %q", got, want)
+		}
+
+		cases := []struct {
+			subject, action, object string
+			want                    Effect
+		}{
+			{"alice", "read", "data1", Allow},
+			{"alice", "write", "data1", Deny},
+			{"alice", "read", "data2", Allow},
+			{"alice", "write", "data2", Allow},
+			{"bob", "write", "data2", Allow},
+			{"bob", "read", "data2", Deny},
+			{"bob", "read", "data1", Deny},
+			{"eve", "read", "data1", Deny},
+			{"carol", "write", "data1", Allow},
+			{"carol", "write", "data2", Allow},
+			{"carol", "read", "data2", Allow},
+			{"admin", "read", "data1", Allow},
+			{"dave", "read", "data2", Allow},
+			{"dave", "write", "data2", Deny},
+			{"mallory", "read", "data1", Deny},
+		}
+		for _, c := range cases {
+			if got := rs.Query(c.subject, c.action, c.object); got != c.want {
+				t.Errorf("roles %v: Query(%q, %q, %q): got %q want %q", withRoles, c.subject, c.action, c.object, got, c.want)
+			}
+		}
+
+		if withRoles {
+			if got := rs.Roles.RolesOf("dave"); strings.Join(got, " ") != "auditor data2_admin" {
+				t.Errorf("got roles %v for dave", got)
+			}
+			// the rules follow the assignments of the Roles layer
+			rs.Roles.AssignRole("mallory", "admin")
+			if got := rs.Query("mallory", "read", "data2"); got != Allow {
+				t.Errorf("got %q after assigning a role want %q", got, Allow)
+			}
+		}
+	}
+}
+
+func TestLoadCasbinCSVErrors(t *testing.T) {
+	cases := []struct {
+		csv string
+		err string
+	}{
+		{"p, alice, data1, read\np, bob, data2\n", `perms: line 2: a policy line must have 3 or 4 fields, got 2`},
+		{"# comment\n\np, alice, data1, read, allow, extra\n", `perms: line 3: a policy line must have 3 or 4 fields, got 5`},
+		{"g, alice\n", `perms: line 1: a grouping line must have 2 fields, got 1`},
+		{"p, alice, data1, read\ng2, alice, admin, domain1\n", `perms: line 2: unsupported line type "g2"`},
+		{"p, alice, , read\n", `perms: line 1: empty field in policy line`},
+		{"p, alice, \"data1, read\n", `perms: line 1: extraneous or missing " in quoted-field`},
+	}
+	for _, c := range cases {
+		rs := NewRuleSet(Deny)
+		err := LoadCasbinCSV(rs, strings.NewReader(c.csv))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+		if n := len(rs.byID); n != 0 {
+			t.Errorf("got %d rules loaded from an invalid policy", n)
+		}
+	}
+}
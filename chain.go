@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// RuleChain evaluates a sequence of rule sets, eg. an organization-wide baseline policy
+// followed by the policy of a service: the first rule set producing an effect, with a
+// rule or a role grant, decides the query, the following ones are not consulted.
+// When no rule set produces an effect, the default effect of the last one is used.
+type RuleChain struct {
+	ruleSets []*RuleSet
+}
+
+// Chain returns the chain of the rule sets, evaluated in the given order.
+// The rule sets are not copied: later changes to them affect the chain.
+func Chain(first *RuleSet, second *RuleSet, others ...*RuleSet) *RuleChain {
+	ruleSets := append([]*RuleSet{first, second}, others...)
+	return &RuleChain{ruleSets: ruleSets}
+}
+
+// Query is like RuleSet.Query, for the chain.
+func (chain *RuleChain) Query(subject interface{}, action interface{}, resource interface{}) string {
+	return chain.QueryExplain(subject, action, resource).Effect
+}
+
+// IsAllowed is like RuleSet.IsAllowed, for the chain.
+func (chain *RuleChain) IsAllowed(subject interface{}, action interface{}, resource interface{}) bool {
+	return chain.Query(subject, action, resource) == Allow
+}
+
+// QueryMatched is like RuleSet.QueryMatched, for the chain: matched is false when no
+// rule set produced an effect.
+func (chain *RuleChain) QueryMatched(subject interface{}, action interface{}, resource interface{}) (effect string, matched bool) {
+	decision := chain.QueryExplain(subject, action, resource)
+	return decision.Effect, !decision.Default
+}
+
+// QueryExplain is like RuleSet.QueryExplain, for the chain.
+func (chain *RuleChain) QueryExplain(subject interface{}, action interface{}, resource interface{}) Decision {
+	decision, _ := chain.QueryDecision(context.Background(), subject, action, resource)
+	return decision
+}
+
+// QueryE is like RuleSet.QueryE, for the chain.
+func (chain *RuleChain) QueryE(subject interface{}, action interface{}, resource interface{}) (string, error) {
+	return chain.QueryCtx(context.Background(), subject, action, resource)
+}
+
+// QueryCtx is like RuleSet.QueryCtx, for the chain.
+func (chain *RuleChain) QueryCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (string, error) {
+	decision, err := chain.QueryDecision(ctx, subject, action, resource)
+	return decision.Effect, err
+}
+
+// QueryDecision is like RuleSet.QueryDecision, for the chain. An error evaluating a rule
+// set stops the evaluation, and the default decision of the last rule set for the
+// query (see DefaultEffectFn and SetDefaultEffectForType) is returned along with the
+// error.
+func (chain *RuleChain) QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	last := chain.ruleSets[len(chain.ruleSets)-1]
+	for _, ruleSet := range chain.ruleSets[:len(chain.ruleSets)-1] {
+		decision, err := ruleSet.evaluate(ctx, subject, action, resource)
+		if err != nil {
+			return last.defaultDecision(last.current(), subject, action, resource), err
+		}
+		if !decision.Default {
+			return decision, nil
+		}
+	}
+	return last.evaluate(ctx, subject, action, resource)
+}
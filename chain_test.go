@@ -0,0 +1,84 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	// the baseline denies deletions and lets superusers do anything
+	baseline := NewRuleSet("baseline-default")
+	baseline.AddRule(&User{}, "delete", nil, effectMatcher(DENY))
+	baseline.AddRule(&User{IsSuperuser: true}, nil, nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return subj.(*User).IsSuperuser, ALLOW, false
+		})
+	// a rule producing no effect doesn't stop the chain
+	baseline.AddRule(&User{}, "view", &Video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return false, "", false
+		})
+	service := newVideoRuleSet()
+	chain := Chain(baseline, service)
+
+	john := &User{Name: "john"}
+	johnVideo := &Video{Name: "intro", User: "john"}
+	admin := &User{Name: "admin", IsSuperuser: true}
+
+	// answered by the baseline, even though the service would allow it
+	if effect, matched := chain.QueryMatched(john, "delete", johnVideo); effect != DENY || !matched {
+		t.Errorf("got %q, %v want %q from the baseline", effect, matched, DENY)
+	}
+	if d := chain.QueryExplain(admin, "publish", &Archive{}); d.Effect != ALLOW || d.Rule == nil || d.Rule.ID != "rule-2" {
+		t.Errorf("got %+v want the baseline superuser rule", d)
+	}
+	// answered only by the service
+	if d := chain.QueryExplain(john, "view", johnVideo); d.Effect != ALLOW || d.Rule == nil || d.Rule.ID != "rule-3" {
+		t.Errorf("got %+v want the service view video rule", d)
+	}
+	if !chain.IsAllowed(john, "view", &Playlist{User: "john"}) {
+		t.Errorf("got view playlist denied want the service rule")
+	}
+	// answered by neither: the default effect of the last rule set
+	if effect, matched := chain.QueryMatched(john, "publish", johnVideo); effect != DENY || matched {
+		t.Errorf("got %q, %v want the service default effect", effect, matched)
+	}
+	if d := chain.QueryExplain(&Group{Name: "editors"}, "view", johnVideo); !d.Default || d.Effect != DENY {
+		t.Errorf("got %+v want the default decision", d)
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	first := NewRuleSet(ALLOW)
+	first.AddRuleE(&User{}, "view", nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+			return false, "", false, errors.New("storage unavailable")
+		})
+	second := NewRuleSet(DENY)
+	second.AddRule(&User{}, "view", nil, effectMatcher(ALLOW))
+	third := NewRuleSet("third-default")
+	chain := Chain(first, second, third)
+
+	if effect, err := chain.QueryE(&User{}, "view", nil); err == nil || effect != "third-default" {
+		t.Errorf("got %q, %v want the default effect of the last rule set with the error", effect, err)
+	}
+	if effect, err := chain.QueryCtx(context.Background(), &User{}, "view", &Video{}); err == nil || effect != "third-default" {
+		t.Errorf("got %q, %v want the error", effect, err)
+	}
+	if effect, err := chain.QueryE(&Group{}, "view", nil); err != nil || effect != "third-default" {
+		t.Errorf("got %q, %v want the default effect of the last rule set", effect, err)
+	}
+
+	// the default effects of the last rule set apply along with the error
+	third.SetDefaultEffectForType(&Video{}, "video-default")
+	if effect, err := chain.QueryE(&User{}, "view", &Video{}); err == nil || effect != "video-default" {
+		t.Errorf("got %q, %v want the default effect of the videos with the error", effect, err)
+	}
+	third.DefaultEffectFn = func(subject interface{}, action interface{}, resource interface{}) string {
+		return "dynamic"
+	}
+	if d, err := chain.QueryDecision(context.Background(), &User{}, "view", &Video{}); err == nil || d.Effect != "dynamic" || d.Reason != DynamicDefaultReason {
+		t.Errorf("got %+v, %v want the dynamic default with the error", d, err)
+	}
+}
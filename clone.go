@@ -0,0 +1,41 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// Clone returns a copy of the rule set, with the same rules and options, that can be
+// modified without affecting the original, eg. to add an experimental rule and run a
+// what-if query. The rules themselves, which are immutable, are shared, as are the
+// values referenced by the options, like Roles and Logger.
+// A cache enabled with WithCache is cloned empty, and the Stats counters start from zero.
+// The clone has no checkpoints, see Checkpoint.
+// The clone doesn't use the cache enabled with WithSharedCache, holding the decisions
+// of the original rules.
+func (ruleSet *RuleSet) Clone() *RuleSet {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	clone := *ruleSet
+
+	// the rule table is immutable, the changes to either rule set build a new one
+	clone.rules = newRuleStore(ruleSet.current())
+	clone.byID = make(map[RuleID]*Rule, len(ruleSet.byID))
+	for id, rule := range ruleSet.byID {
+		clone.byID[id] = rule
+	}
+
+	clone.types = NewTypeRegistry()
+	for name, t := range ruleSet.types.byName {
+		clone.types.byName[name] = t
+		clone.types.names[t] = name
+	}
+
+	clone.stats = &ruleStats{}
+	clone.checkpoints = nil
+	clone.cache = nil
+	if ruleSet.cache != nil && ruleSet.cache.shared == nil {
+		clone.WithCache(ruleSet.cache.maxEntries, ruleSet.cache.keyFn)
+	}
+	return &clone
+}
@@ -0,0 +1,59 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.Combining = FirstApplicable
+	clone := rs.Clone()
+	checkSameDecisions(t, clone, rs)
+	if clone.DefaultEffect != rs.DefaultEffect || clone.Combining != rs.Combining {
+		t.Errorf("got options %q, %v want %q, %v", clone.DefaultEffect, clone.Combining, rs.DefaultEffect, rs.Combining)
+	}
+
+	collect := func(rs *RuleSet) []RuleID {
+		var ids []RuleID
+		rs.findRules(newQueryValue(&User{}), newQueryValue("view"), newQueryValue(&Video{}), func(c candidate) bool {
+			ids = append(ids, c.rule.id)
+			return true
+		})
+		return ids
+	}
+	before := collect(rs)
+
+	john := &User{Name: "john"}
+	intro := &Video{Name: "intro", User: "jack"}
+	id := clone.AddRuleWithPriority(1, &User{}, "view", &Video{}, quickMatcher(ALLOW))
+	clone.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+	clone.AddRule(&User{}, "publish", InterfaceOf[NamedOwner](), effectMatcher(ALLOW))
+	clone.RegisterType("Archive", &Archive{})
+	if got := clone.Query(john, "view", intro); got != ALLOW {
+		t.Errorf("got %q want %q from the clone", got, ALLOW)
+	}
+	if got := rs.Query(john, "view", intro); got != DENY {
+		t.Errorf("got %q want %q from the original", got, DENY)
+	}
+	if got := collect(rs); !reflect.DeepEqual(got, before) {
+		t.Errorf("got rules %v want %v", got, before)
+	}
+	if _, ok := rs.byID[id]; ok {
+		t.Errorf("rule %q added to the original", id)
+	}
+	if _, ok := rs.Types().Lookup("Archive"); ok {
+		t.Error("type registered in the original")
+	}
+	if len(rs.current().interfaces[2].types) != 0 {
+		t.Error("interface key added to the original")
+	}
+
+	// removing from the original doesn't affect the clone
+	if err := rs.RemoveRule(before[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := clone.byID[before[0]]; !ok {
+		t.Errorf("rule %q removed from the clone", before[0])
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// Predicate is a condition on the (subject, action, resource) triple, turned into a
+// matcher by WithEffect.
+type Predicate func(subject interface{}, action interface{}, resource interface{}) bool
+
+// WithEffect returns a matcher producing effect, with the given quick flag, for the
+// triples satisfying predicate, and not matching the others.
+func WithEffect(predicate Predicate, effect string, quick bool) MatcherFn {
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		if predicate(subject, action, resource) {
+			return true, effect, quick
+		}
+		return false, "", false
+	}
+}
+
+// Not returns a predicate satisfied by the triples not satisfying predicate.
+func Not(predicate Predicate) Predicate {
+	return func(subject interface{}, action interface{}, resource interface{}) bool {
+		return !predicate(subject, action, resource)
+	}
+}
+
+// And returns a matcher running the matchers in order, like &&: it doesn't match as
+// soon as one of them doesn't, otherwise it produces the effect of the last one, so
+// that the previous matchers act as conditions. The effect is quick only if all the
+// matchers set quick.
+//
+//	perms.And(perms.WithEffect(notArchived, perms.Allow, false), perms.WithEffect(isOwner, perms.Allow, false))
+func And(first MatcherFn, others ...MatcherFn) MatcherFn {
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		matches, effect, quick := first(subject, action, resource)
+		for _, matcher := range others {
+			if !matches {
+				break
+			}
+			var q bool
+			matches, effect, q = matcher(subject, action, resource)
+			quick = quick && q
+		}
+		if !matches {
+			return false, "", false
+		}
+		return true, effect, quick
+	}
+}
+
+// Or returns a matcher running the matchers in order, like ||: it produces the effect,
+// and the quick flag, of the first one matching, and doesn't match if none does.
+func Or(first MatcherFn, others ...MatcherFn) MatcherFn {
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		if matches, effect, quick := first(subject, action, resource); matches {
+			return true, effect, quick
+		}
+		for _, matcher := range others {
+			if matches, effect, quick := matcher(subject, action, resource); matches {
+				return true, effect, quick
+			}
+		}
+		return false, "", false
+	}
+}
@@ -0,0 +1,79 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestCombinators(t *testing.T) {
+	isSuperuser := func(subj interface{}, act interface{}, res interface{}) bool {
+		return subj.(*User).IsSuperuser
+	}
+	always := func(subj interface{}, act interface{}, res interface{}) bool {
+		return true
+	}
+	ownsPlaylist := func(subj interface{}, act interface{}, res interface{}) bool {
+		return res.(*Playlist).User == subj.(*User).Name
+	}
+	publicPlaylist := func(subj interface{}, act interface{}, res interface{}) bool {
+		return res.(*Playlist).Public
+	}
+	ownsVideo := func(subj interface{}, act interface{}, res interface{}) bool {
+		return res.(*Video).User == subj.(*User).Name
+	}
+	publicVideo := func(subj interface{}, act interface{}, res interface{}) bool {
+		return res.(*Video).Public
+	}
+
+	// newVideoRuleSet, built out of combinators
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Playlist{}, Or(
+		WithEffect(isSuperuser, ALLOW, true),
+		WithEffect(publicPlaylist, ALLOW, false),
+		WithEffect(ownsPlaylist, ALLOW, false),
+		WithEffect(always, DENY, false)))
+	rs.AddRule(&User{}, "modify", &Playlist{}, Or(
+		WithEffect(ownsPlaylist, ALLOW, false),
+		WithEffect(Not(ownsPlaylist), DENY, false)))
+	rs.AddRule(&User{}, "view", &Video{}, Or(
+		WithEffect(isSuperuser, ALLOW, true),
+		WithEffect(publicVideo, ALLOW, false),
+		WithEffect(ownsVideo, ALLOW, false),
+		WithEffect(always, DENY, false)))
+	rs.AddRule(&User{}, "modify", &Video{}, Or(
+		WithEffect(isSuperuser, ALLOW, true),
+		And(WithEffect(Not(isSuperuser), DENY, false), WithEffect(ownsVideo, ALLOW, false)),
+		WithEffect(always, DENY, false)))
+	rs.AddRule(&Group{}, "modify", &Playlist{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		if res.(*Playlist).Group == subj.(*Group).Name {
+			return true, ALLOW, false
+		}
+		return true, DENY, false
+	})
+	rs.AddRule(&User{}, "view", nil, Or(WithEffect(isSuperuser, ALLOW, true), WithEffect(always, DENY, false)))
+	checkSameDecisions(t, rs, newVideoRuleSet())
+}
+
+func TestCombinatorsQuick(t *testing.T) {
+	yes := func(subj interface{}, act interface{}, res interface{}) bool {
+		return true
+	}
+	tests := []struct {
+		matcher MatcherFn
+		matches bool
+		effect  string
+		quick   bool
+	}{
+		{And(WithEffect(yes, DENY, true), WithEffect(yes, ALLOW, true)), true, ALLOW, true},
+		{And(WithEffect(yes, DENY, true), WithEffect(yes, ALLOW, false)), true, ALLOW, false},
+		{And(WithEffect(yes, ALLOW, true), WithEffect(Not(yes), ALLOW, true)), false, "", false},
+		{Or(WithEffect(Not(yes), DENY, true), WithEffect(yes, ALLOW, false)), true, ALLOW, false},
+		{Or(WithEffect(yes, DENY, true), WithEffect(yes, ALLOW, false)), true, DENY, true},
+		{Or(WithEffect(Not(yes), DENY, true)), false, "", false},
+	}
+	for i, test := range tests {
+		matches, effect, quick := test.matcher(nil, nil, nil)
+		if matches != test.matches || effect != test.effect || quick != test.quick {
+			t.Errorf("%d: got (%v, %q, %v) want (%v, %q, %v)", i, matches, effect, quick, test.matches, test.effect, test.quick)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Coverage records which rules are evaluated and which match during the queries
+// following StartCoverage, to find the rules a test suite doesn't exercise. It is
+// based on the counters of the rules (see RuleInfo.Evaluations), so it records
+// nothing while DisableRuleStats is set, and ResetStats restarts it.
+type Coverage struct {
+	// IncludeDisabled, when true, also reports the rules disabled with DisableRule and
+	// those outside of their validity (see AddRuleWithExpiry).
+	IncludeDisabled bool
+	// RequireMatch, when true, makes Assert also fail for the rules evaluated without
+	// ever matching.
+	RequireMatch bool
+
+	ruleSet *RuleSet
+	start   map[*ruleCounters][2]uint64
+}
+
+// CoverageReport is the outcome of a Coverage.
+type CoverageReport struct {
+	// Rules is the number of rules considered, Evaluated and Matched how many of them
+	// were evaluated and matched.
+	Rules     int
+	Evaluated int
+	Matched   int
+	// Unevaluated are the rules never evaluated, Unmatched those evaluated without
+	// ever matching, in the order of Rules.
+	Unevaluated []RuleInfo
+	Unmatched   []RuleInfo
+}
+
+// TestReporter is the subset of testing.TB used by Coverage.Assert.
+type TestReporter interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// StartCoverage starts recording the rules evaluated and matched by the queries.
+func (ruleSet *RuleSet) StartCoverage() *Coverage {
+	coverage := &Coverage{ruleSet: ruleSet, start: make(map[*ruleCounters][2]uint64)}
+	ruleSet.walkRules(func(rule *Rule, info *RuleInfo) bool {
+		coverage.start[rule.counters] = [2]uint64{info.Evaluations, info.Matches}
+		return true
+	})
+	return coverage
+}
+
+// Report returns the coverage of the current rules, excluding those not enabled at
+// the time of the call (see RuleInfo.Enabled) unless IncludeDisabled is set.
+func (coverage *Coverage) Report() CoverageReport {
+	var report CoverageReport
+	coverage.ruleSet.walkRules(func(rule *Rule, info *RuleInfo) bool {
+		if !info.Enabled && !coverage.IncludeDisabled {
+			return true
+		}
+		report.Rules++
+		evaluations, matches := info.Evaluations, info.Matches
+		if start, ok := coverage.start[rule.counters]; ok && start[0] <= evaluations {
+			evaluations, matches = evaluations-start[0], matches-start[1]
+		}
+		switch {
+		case evaluations == 0:
+			report.Unevaluated = append(report.Unevaluated, *info)
+		case matches == 0:
+			report.Evaluated++
+			report.Unmatched = append(report.Unmatched, *info)
+		default:
+			report.Evaluated++
+			report.Matched++
+		}
+		return true
+	})
+	return report
+}
+
+// Assert reports an error to t for each rule never evaluated, and, if RequireMatch
+// is set, for each rule never matched.
+func (coverage *Coverage) Assert(t TestReporter) {
+	t.Helper()
+	report := coverage.Report()
+	for _, info := range report.Unevaluated {
+		t.Errorf("perms: rule %s was never evaluated", describeRule(info))
+	}
+	if coverage.RequireMatch {
+		for _, info := range report.Unmatched {
+			t.Errorf("perms: rule %s never matched", describeRule(info))
+		}
+	}
+}
+
+// describeRule returns the ID of the rule along with its metadata.
+func describeRule(info RuleInfo) string {
+	description := string(info.ID)
+	if info.Name != "" {
+		description += fmt.Sprintf(" (%s)", info.Name)
+	}
+	if len(info.Tags) > 0 {
+		description += fmt.Sprintf(" [%s]", strings.Join(info.Tags, ", "))
+	}
+	return description
+}
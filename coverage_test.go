@@ -0,0 +1,70 @@
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// reporter is a TestReporter recording the errors.
+type reporter struct {
+	errors []string
+}
+
+func (r *reporter) Helper() {}
+
+func (r *reporter) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCoverage(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	// queries before the coverage starts don't count
+	rs.Query(john, "modify", &Playlist{User: "john"})
+
+	coverage := rs.StartCoverage()
+	rs.AddRule(&User{}, "publish", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return false, "", false
+	}, Named("publishers"), Tagged("video"))
+	rs.DisableRule("rule-5")
+	rs.Query(john, "view", &Playlist{User: "john"})
+	rs.Query(john, "view", &Archive{})
+	rs.Query(john, "publish", &Video{})
+
+	ids := func(infos []RuleInfo) []RuleID {
+		var ids []RuleID
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		return ids
+	}
+	report := coverage.Report()
+	if report.Rules != 6 || report.Evaluated != 3 || report.Matched != 2 {
+		t.Errorf("got %d rules, %d evaluated, %d matched want 6, 3, 2", report.Rules, report.Evaluated, report.Matched)
+	}
+	if got, want := ids(report.Unevaluated), []RuleID{"rule-2", "rule-3", "rule-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got unevaluated %v want %v", got, want)
+	}
+	if got, want := ids(report.Unmatched), []RuleID{"rule-7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got unmatched %v want %v", got, want)
+	}
+
+	r := &reporter{}
+	coverage.RequireMatch = true
+	coverage.Assert(r)
+	want := []string{
+		"perms: rule rule-2 was never evaluated",
+		"perms: rule rule-3 was never evaluated",
+		"perms: rule rule-4 was never evaluated",
+		"perms: rule rule-7 (publishers) [video] never matched",
+	}
+	if !reflect.DeepEqual(r.errors, want) {
+		t.Errorf("got errors %q want %q", r.errors, want)
+	}
+
+	coverage.IncludeDisabled = true
+	if got := ids(coverage.Report().Unevaluated); len(got) != 4 || got[3] != "rule-5" {
+		t.Errorf("got unevaluated %v want rule-5 included", got)
+	}
+}
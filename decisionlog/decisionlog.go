@@ -0,0 +1,349 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package decisionlog appends the decisions of go-perms rule sets to a log, one JSON
+// Record per line, eg. for audits, see perms.RuleSet.AuditDecisionFn:
+//
+//	log, err := decisionlog.OpenFile("decisions.jsonl", &decisionlog.Options{
+//		BufferSize:    64 << 10,
+//		FlushInterval: time.Second,
+//		MaxSize:       100 << 20,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer log.Close()
+//	rs.AuditDecisionFn = log.Audit
+//
+// The failures to write the log don't fail the queries: they are counted, see
+// Writer.Errors, and reported to Options.OnError.
+package decisionlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	perms "github.com/panta/go-perms"
+)
+
+// Record is a line of the log.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Actor is the key of the subject acting on behalf of Subject, see
+	// perms.RuleSet.QueryAs.
+	Actor string `json:"actor,omitempty"`
+	// Subject, Action and Resource are the keys of the query, see perms.KeyOf.
+	Subject  string `json:"subject"`
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Effect   string `json:"effect"`
+	// RuleID is the rule producing the effect, perms.SuperuserRuleID for the
+	// superusers and perms.RevokedRuleID for the revoked subjects, empty when the
+	// effect is the default one.
+	RuleID      string       `json:"rule_id,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+	Obligations []Obligation `json:"obligations,omitempty"`
+}
+
+// Obligation is an obligation of a Record, see perms.Obligation.
+type Obligation struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// NewRecord returns the record of the decision of event, made at t.
+func NewRecord(t time.Time, event perms.AuditEvent) Record {
+	record := Record{
+		Time:     t,
+		Subject:  perms.KeyOf(event.Subject),
+		Action:   fmt.Sprint(event.Action),
+		Resource: perms.KeyOf(event.Resource),
+		Effect:   event.Decision.Effect,
+		Reason:   event.Decision.Reason,
+	}
+	if event.Actor != nil {
+		record.Actor = perms.KeyOf(event.Actor)
+	}
+	switch {
+	case event.Decision.Revoked:
+		record.RuleID = string(perms.RevokedRuleID)
+	case event.Decision.Superuser:
+		record.RuleID = string(perms.SuperuserRuleID)
+	case event.Decision.Rule != nil:
+		record.RuleID = string(event.Decision.Rule.ID)
+	}
+	for _, obligation := range event.Decision.Obligations {
+		record.Obligations = append(record.Obligations, Obligation{Name: obligation.Name, Value: obligation.Value})
+	}
+	return record
+}
+
+// ErrClosed is reported for the records written after Close.
+var ErrClosed = errors.New("decisionlog: closed")
+
+// Options configures a Writer.
+type Options struct {
+	// BufferSize, if positive, is the size of the buffer of the writes: the records
+	// are written when it fills, at each FlushInterval, and by Flush and Close.
+	BufferSize int
+	// FlushInterval, if positive, is the interval of the periodic flushes of the
+	// buffer.
+	FlushInterval time.Duration
+	// MaxSize, if positive, is the size of the file opened by OpenFile, in bytes,
+	// beyond which it's rotated: renamed adding the suffix ".1", ".2", and so on, the
+	// highest the most recent, and created again. A record is never split.
+	MaxSize int64
+	// OnError, if not nil, is called with the errors of the writes, which are
+	// otherwise only counted.
+	OnError func(error)
+}
+
+// Writer writes the records of the decisions to an io.Writer. It's safe for
+// concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	out    io.Writer
+	buf    *bufio.Writer
+	closed bool
+
+	// path, file and size are those of the file opened by OpenFile
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+
+	onError func(error)
+	errors  atomic.Uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New returns a writer of the records to out, closed by Close if an io.Closer. The
+// options can be nil; MaxSize is ignored.
+func New(out io.Writer, options *Options) *Writer {
+	w := &Writer{out: out}
+	w.init(options)
+	return w
+}
+
+// OpenFile returns a writer of the records to the file at path, created if needed and
+// otherwise appended to, and rotated when larger than Options.MaxSize. The options can
+// be nil.
+func OpenFile(path string, options *Options) (*Writer, error) {
+	w := &Writer{path: path}
+	if options != nil {
+		w.maxSize = options.MaxSize
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.init(options)
+	return w, nil
+}
+
+func (w *Writer) init(options *Options) {
+	if options == nil {
+		return
+	}
+	w.onError = options.OnError
+	if options.BufferSize > 0 {
+		w.buf = bufio.NewWriterSize(w.out, options.BufferSize)
+		if options.FlushInterval > 0 {
+			w.stop, w.done = make(chan struct{}), make(chan struct{})
+			go w.flushEvery(options.FlushInterval)
+		}
+	}
+}
+
+// open opens the file at path, setting its size.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.out, w.size = file, file, info.Size()
+	if w.buf != nil {
+		w.buf.Reset(file)
+	}
+	return nil
+}
+
+// Audit writes the record of the decision of event, made now. It's a
+// perms.RuleSet.AuditDecisionFn.
+func (w *Writer) Audit(event perms.AuditEvent) {
+	w.Write(NewRecord(time.Now(), event))
+}
+
+// Write writes record, returning the error also counted and reported to
+// Options.OnError. An obligation value that can't be encoded to JSON is written
+// formatted with %v.
+func (w *Writer) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		obligations := make([]Obligation, len(record.Obligations))
+		for i, obligation := range record.Obligations {
+			obligations[i] = Obligation{Name: obligation.Name, Value: fmt.Sprintf("%v", obligation.Value)}
+		}
+		record.Obligations = obligations
+		if line, err = json.Marshal(record); err != nil {
+			return w.fail(err)
+		}
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.fail(ErrClosed)
+	}
+	if w.path != "" && w.file == nil {
+		// the file couldn't be opened again by rotate
+		if err := w.open(); err != nil {
+			return w.fail(err)
+		}
+	}
+	if w.file != nil && w.maxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			w.fail(err)
+			if w.out == nil {
+				return err
+			}
+		}
+	}
+	var n int
+	if w.buf != nil {
+		n, err = w.buf.Write(line)
+	} else {
+		n, err = w.out.Write(line)
+	}
+	w.size += int64(n)
+	if err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// rotate renames the file, adding the suffix following the highest of the rotated
+// files, and creates it again. The file is left open, if possible, when it can't be
+// renamed.
+func (w *Writer) rotate() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file, w.out = nil, nil
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return err
+	}
+	last := 0
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(match, w.path+".")); err == nil && n > last {
+			last = n
+		}
+	}
+	if err := os.Rename(w.path, w.path+"."+strconv.Itoa(last+1)); err != nil {
+		// keep appending to the file
+		if err := w.open(); err != nil {
+			return err
+		}
+		return err
+	}
+	return w.open()
+}
+
+// fail counts err and reports it to the OnError handler, returning it.
+func (w *Writer) fail(err error) error {
+	w.errors.Add(1)
+	if w.onError != nil {
+		w.onError(err)
+	}
+	return err
+}
+
+// Errors returns the number of failed writes.
+func (w *Writer) Errors() uint64 {
+	return w.errors.Load()
+}
+
+// Flush writes the buffered records.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.flush(); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+func (w *Writer) flush() error {
+	if w.buf == nil || w.out == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+func (w *Writer) flushEvery(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Flush()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Close flushes the buffered records and closes the file, or the io.Writer of New if
+// an io.Closer. The records written after are dropped, reporting ErrClosed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flush()
+	if closer, ok := w.out.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package decisionlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	perms "github.com/panta/go-perms"
+)
+
+type user struct {
+	Name string
+}
+
+func (u *user) PermKey() string {
+	return u.Name
+}
+
+type document struct {
+	ID    string
+	Owner string
+}
+
+func (d *document) PermKey() string {
+	return d.ID
+}
+
+func newRuleSet() (*perms.RuleSet, perms.RuleID) {
+	rs := perms.NewRuleSet(perms.Deny)
+	id := rs.AddRuleWithObligations(&user{}, "view", &document{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, []perms.Obligation, error) {
+		if res.(*document).Owner != subj.(*user).Name {
+			return false, "", false, nil, nil
+		}
+		return true, perms.Allow, false, []perms.Obligation{{Name: "watermark", Value: "confidential"}}, nil
+	})
+	rs.AddRuleWithReason(&user{}, "delete", &document{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, string, error) {
+		return true, perms.Deny, false, "documents are never deleted", nil
+	})
+	return rs, id
+}
+
+// lines returns the JSON objects of the lines of data.
+func lines(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	var objects []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var object map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			t.Fatalf("got %v for line %s", err, scanner.Text())
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+func TestWriter(t *testing.T) {
+	rs, id := newRuleSet()
+	var out bytes.Buffer
+	log := New(&out, nil)
+	rs.AuditDecisionFn = log.Audit
+	john, support := &user{Name: "john"}, &user{Name: "support"}
+
+	rs.Query(john, "view", &document{ID: "readme", Owner: "john"})
+	if _, err := rs.QueryAs(support, john, "view", &document{ID: "secrets", Owner: "jack"}); err != nil {
+		t.Fatal(err)
+	}
+	rs.Query(john, "delete", &document{ID: "readme", Owner: "john"})
+	rs.RevokeSubject("john")
+	rs.Query(john, "view", &document{ID: "readme", Owner: "john"})
+
+	objects := lines(t, out.Bytes())
+	if len(objects) != 4 {
+		t.Fatalf("got %d lines want 4:\n%s", len(objects), out.String())
+	}
+	for i, object := range objects {
+		timestamp, ok := object["time"].(string)
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); !ok || err != nil {
+			t.Errorf("%d: got time %v", i, object["time"])
+		}
+		delete(object, "time")
+	}
+	want := []map[string]interface{}{
+		{
+			"subject": "*decisionlog.user(john)", "action": "view", "resource": "*decisionlog.document(readme)",
+			"effect": "allow", "rule_id": string(id),
+			"obligations": []interface{}{map[string]interface{}{"name": "watermark", "value": "confidential"}},
+		},
+		{
+			"actor": "*decisionlog.user(support)", "subject": "*decisionlog.user(john)", "action": "view",
+			"resource": "*decisionlog.document(secrets)", "effect": "deny",
+		},
+		{
+			"subject": "*decisionlog.user(john)", "action": "delete", "resource": "*decisionlog.document(readme)",
+			"effect": "deny", "rule_id": objects[2]["rule_id"], "reason": "documents are never deleted",
+		},
+		{
+			"subject": "*decisionlog.user(john)", "action": "view", "resource": "*decisionlog.document(readme)",
+			"effect": "deny", "rule_id": string(perms.RevokedRuleID),
+		},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("got\n%v\nwant\n%v", objects, want)
+	}
+
+	// the obligation values that can't be encoded are formatted
+	out.Reset()
+	if err := log.Write(Record{Effect: perms.Allow, Obligations: []Obligation{{Name: "notify", Value: func() {}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if objects := lines(t, out.Bytes()); len(objects) != 1 || !strings.HasPrefix(objects[0]["obligations"].([]interface{})[0].(map[string]interface{})["value"].(string), "0x") {
+		t.Errorf("got %s", out.String())
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriterErrors(t *testing.T) {
+	rs, _ := newRuleSet()
+	var reported []error
+	log := New(failingWriter{}, &Options{OnError: func(err error) {
+		reported = append(reported, err)
+	}})
+	rs.AuditDecisionFn = log.Audit
+	john := &user{Name: "john"}
+
+	// the failures don't fail the queries
+	if effect := rs.Query(john, "view", &document{ID: "readme", Owner: "john"}); effect != perms.Allow {
+		t.Errorf("got %s want allow", effect)
+	}
+	rs.Query(john, "view", &document{ID: "secrets", Owner: "jack"})
+	if log.Errors() != 2 || len(reported) != 2 || reported[0].Error() != "disk full" {
+		t.Errorf("got %d errors, reported %v", log.Errors(), reported)
+	}
+
+	log.Close()
+	if err := log.Write(Record{}); err != ErrClosed || log.Errors() != 3 {
+		t.Errorf("got %v, %d errors want ErrClosed", err, log.Errors())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestWriterBuffering(t *testing.T) {
+	var out syncBuffer
+	log := New(&out, &Options{BufferSize: 4096})
+	log.Write(Record{Subject: "john", Effect: perms.Allow})
+	if out.Len() != 0 {
+		t.Errorf("got the record written before the flush")
+	}
+	if err := log.Flush(); err != nil || len(lines(t, out.buf.Bytes())) != 1 {
+		t.Errorf("got %v, %q want the record flushed", err, out.buf.String())
+	}
+	log.Write(Record{Subject: "jack", Effect: perms.Deny})
+	if err := log.Close(); err != nil || len(lines(t, out.buf.Bytes())) != 2 {
+		t.Errorf("got %v, %q want the record flushed by Close", err, out.buf.String())
+	}
+
+	// the periodic flushes
+	var periodic syncBuffer
+	log = New(&periodic, &Options{BufferSize: 4096, FlushInterval: time.Millisecond})
+	defer log.Close()
+	log.Write(Record{Subject: "john", Effect: perms.Allow})
+	for deadline := time.Now().Add(5 * time.Second); periodic.Len() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("got the record never flushed")
+		}
+	}
+}
+
+func TestOpenFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	record := Record{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Subject: "john", Action: "view", Resource: "readme", Effect: perms.Allow}
+	line, _ := json.Marshal(record)
+	// two records per file
+	log, err := OpenFile(path, &Options{MaxSize: int64(2*len(line) + 2), BufferSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := log.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		records int
+	}{
+		{"decisions.jsonl.1", 2},
+		{"decisions.jsonl.2", 2},
+		{"decisions.jsonl", 1},
+	} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), tc.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if objects := lines(t, data); len(objects) != tc.records {
+			t.Errorf("%s: got %d records want %d", tc.name, len(objects), tc.records)
+		}
+	}
+
+	// the file is appended to, and rotated after the last of the rotated files
+	if log, err = OpenFile(path, &Options{MaxSize: int64(2*len(line) + 2)}); err != nil {
+		t.Fatal(err)
+	}
+	log.Write(record)
+	log.Write(record)
+	log.Close()
+	matches, _ := filepath.Glob(path + "*")
+	if len(matches) != 4 {
+		t.Errorf("got files %v", matches)
+	}
+	if data, _ := os.ReadFile(path + ".3"); len(lines(t, data)) != 2 {
+		t.Errorf("got %s in the last rotated file", data)
+	}
+}
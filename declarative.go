@@ -0,0 +1,568 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TypeRegistry maps the type names used by declarative rules to Go types.
+type TypeRegistry struct {
+	byName map[string]reflect.Type
+	names  map[reflect.Type]string
+}
+
+// NewTypeRegistry returns an empty type registry.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		byName: make(map[string]reflect.Type),
+		names:  make(map[reflect.Type]string),
+	}
+}
+
+// RegisterType registers the type of sample under name. Since templates of comparable
+// non-pointer types constrain the queried values, struct types should usually be
+// registered as pointers, eg. RegisterType("User", &User{}).
+func (registry *TypeRegistry) RegisterType(name string, sample interface{}) {
+	t := reflect.TypeOf(sample)
+	registry.byName[name] = t
+	registry.names[t] = name
+}
+
+// Lookup returns the type registered under name.
+func (registry *TypeRegistry) Lookup(name string) (reflect.Type, bool) {
+	t, ok := registry.byName[name]
+	return t, ok
+}
+
+// Name returns the name t is registered under.
+func (registry *TypeRegistry) Name(t reflect.Type) (string, bool) {
+	name, ok := registry.names[t]
+	return name, ok
+}
+
+// Types returns the registry used to resolve the type names of declarative rules.
+func (ruleSet *RuleSet) Types() *TypeRegistry {
+	return ruleSet.types
+}
+
+// RegisterType registers a type for declarative rules, see TypeRegistry.RegisterType.
+func (ruleSet *RuleSet) RegisterType(name string, sample interface{}) {
+	ruleSet.types.RegisterType(name, sample)
+}
+
+// DeclarativeRule is a data representation of a rule, which can be stored outside the
+// program (eg. as JSON) and loaded in a RuleSet.
+//
+// Subject and Resource are type names registered in the rule set TypeRegistry, Action
+// is a string template. An empty value or "*" stands for a "jolly".
+// The rule applies, producing Effect, when all its Conditions hold and its Condition
+// expression (see CompileExpression), if not empty, is true. A rule with Fields is a
+// field rule, producing Effect for the listed fields of the resource, see QueryFields.
+type DeclarativeRule struct {
+	ID         RuleID      `json:"id,omitempty" yaml:"id,omitempty"`
+	Subject    string      `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Action     string      `json:"action,omitempty" yaml:"action,omitempty"`
+	Resource   string      `json:"resource,omitempty" yaml:"resource,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	Condition  string      `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Effect     Effect      `json:"effect" yaml:"effect"`
+	Quick      bool        `json:"quick,omitempty" yaml:"quick,omitempty"`
+	Priority   int         `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Fields     []string    `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// Condition is an equality test on a field of the subject, action or resource, or on
+// an attribute of the environment of the query (see QueryEnv).
+//
+// Field is a path like "resource.Public", "subject.Name" or "env.mfa", starting with
+// "subject", "action", "resource" or "env" and followed by exported struct fields or
+// map keys.
+// The field is compared with Value, or with the field referenced by Ref if not empty
+// (eg. "resource.User" equal to the Ref "subject.Name"). Numbers are compared by value
+// regardless of their Go type.
+//
+// A condition can also be written as a string, see ParseCondition.
+type Condition struct {
+	Field string      `json:"field" yaml:"field"`
+	Value interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty" yaml:"ref,omitempty"`
+}
+
+// ParseCondition parses the string form of a condition: a field path, "==", and either
+// another field path (the Ref) or a literal value: null, true, false, a number, a
+// quoted string or a bare word standing for a string. For example:
+//
+//	resource.Public == true
+//	resource.User == subject.Name
+func ParseCondition(s string) (Condition, error) {
+	i := strings.Index(s, "==")
+	if i < 0 {
+		return Condition{}, fmt.Errorf("malformed condition %q: missing ==", s)
+	}
+	field, operand := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+2:])
+	if _, err := parseFieldPath(field); err != nil {
+		return Condition{}, fmt.Errorf("malformed condition %q: %v", s, err)
+	}
+	if operand == "" {
+		return Condition{}, fmt.Errorf("malformed condition %q: missing value", s)
+	}
+	cond := Condition{Field: field}
+	if _, err := parseFieldPath(operand); err == nil {
+		cond.Ref = operand
+		return cond, nil
+	}
+	switch {
+	case operand == "null":
+	case operand == "true" || operand == "false":
+		cond.Value = operand == "true"
+	case operand[0] == '"':
+		value, err := strconv.Unquote(operand)
+		if err != nil {
+			return Condition{}, fmt.Errorf("malformed condition %q: bad string %s", s, operand)
+		}
+		cond.Value = value
+	default:
+		if f, err := strconv.ParseFloat(operand, 64); err == nil {
+			cond.Value = f
+		} else if strings.ContainsAny(operand, " \t=!&|()") {
+			return Condition{}, fmt.Errorf("malformed condition %q: bad value %s", s, operand)
+		} else {
+			cond.Value = operand
+		}
+	}
+	return cond, nil
+}
+
+// String returns the string form of the condition, see ParseCondition.
+func (cond Condition) String() string {
+	operand := cond.Ref
+	if operand == "" {
+		switch value := cond.Value.(type) {
+		case nil:
+			operand = "null"
+		case string:
+			operand = strconv.Quote(value)
+		default:
+			operand = fmt.Sprint(value)
+		}
+	}
+	return cond.Field + " == " + operand
+}
+
+// UnmarshalJSON accepts both the object and the string form of a condition.
+func (cond *Condition) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := ParseCondition(s)
+		if err != nil {
+			return err
+		}
+		*cond = parsed
+		return nil
+	}
+	type plain Condition
+	return json.Unmarshal(data, (*plain)(cond))
+}
+
+// Policy is the document format read by LoadJSON and LoadYAML.
+type Policy struct {
+	Rules []DeclarativeRule `json:"rules" yaml:"rules"`
+}
+
+const (
+	rootSubject = iota
+	rootAction
+	rootResource
+	rootEnv
+)
+
+// fieldPath is a parsed Condition field reference.
+type fieldPath struct {
+	root   int
+	fields []string
+}
+
+func parseFieldPath(path string) (fieldPath, error) {
+	parts := strings.Split(path, ".")
+	var fp fieldPath
+	switch parts[0] {
+	case "subject":
+		fp.root = rootSubject
+	case "action":
+		fp.root = rootAction
+	case "resource":
+		fp.root = rootResource
+	case "env":
+		fp.root = rootEnv
+	default:
+		return fp, fmt.Errorf("field %q must start with subject, action, resource or env", path)
+	}
+	for _, part := range parts[1:] {
+		if part == "" {
+			return fp, fmt.Errorf("malformed field %q", path)
+		}
+	}
+	fp.fields = parts[1:]
+	return fp, nil
+}
+
+// check verifies that the path can be resolved on values of type t (nil if unknown).
+func (fp fieldPath) check(t reflect.Type) error {
+	for _, name := range fp.fields {
+		if t == nil {
+			return nil
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := t.FieldByName(name)
+			if !ok || field.PkgPath != "" {
+				return fmt.Errorf("type %v has no exported field %q", t, name)
+			}
+			t = field.Type
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return fmt.Errorf("type %v has no string keys for %q", t, name)
+			}
+			t = t.Elem()
+		case reflect.Interface:
+			t = nil
+		default:
+			return fmt.Errorf("type %v has no field %q", t, name)
+		}
+	}
+	return nil
+}
+
+func (fp fieldPath) resolve(roots *[4]interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(roots[fp.root])
+	for _, name := range fp.fields {
+		v = indirect(v)
+		if !v.IsValid() {
+			return v, false
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := v.Type().FieldByName(name)
+			if !ok || field.PkgPath != "" {
+				return reflect.Value{}, false
+			}
+			v = v.FieldByIndex(field.Index)
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}, false
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	v = indirect(v)
+	return v, v.IsValid()
+}
+
+// indirect dereferences pointers and interfaces, returning the zero Value for nil ones.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// valuesEqual compares two values, comparing numbers by value.
+func valuesEqual(a reflect.Value, b reflect.Value) bool {
+	a, b = indirect(a), indirect(b)
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if fa, ok := asFloat(a); ok {
+		fb, ok := asFloat(b)
+		return ok && fa == fb
+	}
+	if a.Kind() == reflect.String && b.Kind() == reflect.String {
+		return a.String() == b.String()
+	}
+	if a.Kind() == reflect.Bool && b.Kind() == reflect.Bool {
+		return a.Bool() == b.Bool()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func asFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// compiledCondition is a Condition with resolved field paths.
+type compiledCondition struct {
+	field fieldPath
+	ref   *fieldPath
+	value reflect.Value
+}
+
+func (cond compiledCondition) holds(roots *[4]interface{}) bool {
+	v, ok := cond.field.resolve(roots)
+	if !ok {
+		return false
+	}
+	if cond.ref != nil {
+		other, ok := cond.ref.resolve(roots)
+		return ok && valuesEqual(v, other)
+	}
+	return valuesEqual(v, cond.value)
+}
+
+// compile resolves the type names of the rule and returns its templates and matcher.
+// The matcher fails if the evaluation of the condition expression fails.
+func (decl *DeclarativeRule) compile(types *TypeRegistry) (subject interface{}, action interface{}, resource interface{}, matcher MatcherCtxFn, err error) {
+	templateFor := func(role string, name string) (interface{}, reflect.Type, error) {
+		if name == "" || name == "*" {
+			return nil, nil, nil
+		}
+		t, ok := types.Lookup(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown %s type %q", role, name)
+		}
+		return reflect.Zero(t).Interface(), t, nil
+	}
+	subject, sT, err := templateFor("subject", decl.Subject)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	resource, rT, err := templateFor("resource", decl.Resource)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var aT reflect.Type
+	if decl.Action != "" && decl.Action != "*" {
+		action = decl.Action
+		aT = reflect.TypeOf(decl.Action)
+	}
+	if decl.Effect == "" {
+		return nil, nil, nil, nil, fmt.Errorf("missing effect")
+	}
+
+	for _, name := range decl.Fields {
+		if err := (fieldPath{root: rootResource, fields: []string{name}}).check(rT); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	// the attributes of the environment are not typed
+	rootTypes := [...]reflect.Type{sT, aT, rT, nil}
+	conditions := make([]compiledCondition, 0, len(decl.Conditions))
+	for _, cond := range decl.Conditions {
+		field, err := parseFieldPath(cond.Field)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if err := field.check(rootTypes[field.root]); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		compiled := compiledCondition{field: field, value: reflect.ValueOf(cond.Value)}
+		if cond.Ref != "" {
+			ref, err := parseFieldPath(cond.Ref)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if err := ref.check(rootTypes[ref.root]); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			compiled.ref = &ref
+		}
+		conditions = append(conditions, compiled)
+	}
+	var expr *Expression
+	if decl.Condition != "" {
+		if expr, err = CompileExpression(decl.Condition); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if err := expr.check(rootTypes); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	// spare the lookups of the environment and of the query in the context to the
+	// rules not using them
+	usesEnv := expr != nil && usesRoot(expr.root, rootEnv)
+	needsQuery := expr != nil && usesQuery(expr.root)
+	for _, cond := range conditions {
+		usesEnv = usesEnv || cond.field.root == rootEnv || (cond.ref != nil && cond.ref.root == rootEnv)
+	}
+	effect, quick := decl.Effect, decl.Quick
+	matcher = func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		roots := [...]interface{}{subject, action, resource, nil}
+		if usesEnv {
+			roots[rootEnv] = envFrom(ctx)
+		}
+		for _, cond := range conditions {
+			if !cond.holds(&roots) {
+				return false, "", false, nil
+			}
+		}
+		if expr != nil {
+			scope := &exprScope{roots: roots}
+			if needsQuery {
+				scope.ctx, scope.attrs = ctx, attributesFrom(ctx)
+			}
+			if ok, err := expr.eval(scope); !ok {
+				return false, "", false, err
+			}
+		}
+		return true, effect, quick, nil
+	}
+	return subject, action, resource, matcher, nil
+}
+
+// Check returns the error AddDeclarativeRule would return for decl, apart from a
+// duplicate id, resolving the type names in types. An error in the Condition
+// expression is an *ExpressionError.
+func (decl DeclarativeRule) Check(types *TypeRegistry) error {
+	_, _, _, _, err := decl.compile(types)
+	return err
+}
+
+// AddDeclarativeRule adds a rule described by decl. The type names are resolved in the
+// rule set TypeRegistry, and an error is returned if they are not registered or if a
+// condition refers to a field the types don't have.
+func (ruleSet *RuleSet) AddDeclarativeRule(decl DeclarativeRule) (RuleID, error) {
+	if decl.ID != "" && ruleSet.hasRule(decl.ID) {
+		return "", ErrDuplicateRuleID
+	}
+	rule, err := ruleSet.newDeclarativeRule(decl)
+	if err != nil {
+		return "", err
+	}
+	if err := ruleSet.addRules(rule); err != nil {
+		return "", err
+	}
+	return rule.id, nil
+}
+
+func (ruleSet *RuleSet) newDeclarativeRule(decl DeclarativeRule) (*Rule, error) {
+	subject, action, resource, matcher, err := decl.compile(ruleSet.types)
+	if err != nil {
+		return nil, err
+	}
+	if !ruleSet.StrictExpressions {
+		strict := matcher
+		matcher = func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+			matches, effect, quick, err := strict(ctx, subject, action, resource)
+			// the attributes which can't be fetched fail the query anyway
+			var attrErr *AttributeError
+			if err != nil && !errors.As(err, &attrErr) {
+				return false, "", false, nil
+			}
+			return matches, effect, quick, err
+		}
+	}
+	decl.Conditions = append([]Condition(nil), decl.Conditions...)
+	decl.Fields = append([]string(nil), decl.Fields...)
+	var rule *Rule
+	if len(decl.Fields) > 0 {
+		rule = newFieldRule(subject, action, resource, declarativeFieldMatcher(matcher, decl.Fields))
+	} else {
+		rule = newRule(subject, action, resource, matcher)
+	}
+	rule.id = decl.ID
+	rule.priority = decl.Priority
+	rule.decl = &decl
+	return rule, nil
+}
+
+// AddPolicy adds all the rules of the policy. If any rule is invalid, no rule is added
+// and the returned error reports the position of the offending rule.
+func (ruleSet *RuleSet) AddPolicy(policy Policy) error {
+	rules, err := ruleSet.policyRules(policy, nil)
+	if err != nil {
+		return err
+	}
+	return ruleSet.addRules(rules...)
+}
+
+// policyRules returns the rules of the policy, checking that their ids are not in use
+// but by the rules being replaced.
+func (ruleSet *RuleSet) policyRules(policy Policy, replaced map[RuleID]bool) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(policy.Rules))
+	ids := make(map[RuleID]bool)
+	for i, decl := range policy.Rules {
+		if decl.ID != "" {
+			if (ruleSet.hasRule(decl.ID) && !replaced[decl.ID]) || ids[decl.ID] {
+				return nil, fmt.Errorf("perms: rule %d: duplicate id %q", i, decl.ID)
+			}
+			ids[decl.ID] = true
+		}
+		rule, err := ruleSet.newDeclarativeRule(decl)
+		if err != nil {
+			return nil, fmt.Errorf("perms: rule %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// DeclarativeRules returns the declarative rules of the rule set, in insertion order.
+func (ruleSet *RuleSet) DeclarativeRules() []DeclarativeRule {
+	var decls []DeclarativeRule
+	for _, rule := range ruleSet.current().allRules() {
+		if rule.decl == nil {
+			continue
+		}
+		decl := *rule.decl
+		decl.Conditions = append([]Condition(nil), decl.Conditions...)
+		decl.Fields = append([]string(nil), decl.Fields...)
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// LoadJSON reads a JSON Policy document and adds its rules, see AddPolicy.
+func (ruleSet *RuleSet) LoadJSON(r io.Reader) error {
+	policy, err := parseJSON(r)
+	if err != nil {
+		return err
+	}
+	return ruleSet.AddPolicy(policy)
+}
+
+// parseJSON reads a JSON Policy document.
+func parseJSON(r io.Reader) (Policy, error) {
+	var policy Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return Policy{}, fmt.Errorf("perms: %v", err)
+	}
+	return policy, nil
+}
+
+// SaveJSON writes the declarative rules of the rule set as a JSON Policy document.
+// Rules added as Go matchers are not included.
+func (ruleSet *RuleSet) SaveJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Policy{Rules: ruleSet.DeclarativeRules()})
+}
@@ -0,0 +1,143 @@
+package perms
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const playlistPolicyJSON = `{
+  "rules": [
+    {"subject": "User", "action": "view", "resource": "Playlist",
+     "conditions": [{"field": "resource.Public", "value": true}], "effect": "allow"},
+    {"id": "owner-view", "subject": "User", "action": "view", "resource": "Playlist",
+     "conditions": [{"field": "resource.User", "ref": "subject.Name"}], "effect": "allow"},
+    {"subject": "User", "action": "modify", "resource": "Playlist",
+     "conditions": [{"field": "resource.User", "ref": "subject.Name"}], "effect": "allow"},
+    {"subject": "Group", "action": "modify", "resource": "Playlist",
+     "conditions": [{"field": "resource.Group", "ref": "subject.Name"}], "effect": "allow"},
+    {"subject": "User", "action": "*",
+     "conditions": [{"field": "subject.IsSuperuser", "value": true}], "effect": "allow", "quick": true}
+  ]
+}`
+
+func newPolicyRuleSet() *RuleSet {
+	rs := NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Group", &Group{})
+	rs.RegisterType("Playlist", &Playlist{})
+	rs.RegisterType("Video", &Video{})
+	return rs
+}
+
+func checkPlaylistPolicy(t *testing.T, rs *RuleSet) {
+	t.Helper()
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	johnPlaylist := &Playlist{ID: "6563", User: "john", Group: "john"}
+	johnPublicPlaylist := &Playlist{ID: "6274", Public: true, User: "john", Group: "john"}
+	jackPlaylist := &Playlist{ID: "9374", User: "jack", Group: "editors"}
+	cases := []struct {
+		subject  interface{}
+		action   string
+		resource interface{}
+		want     string
+	}{
+		{john, "modify", johnPlaylist, ALLOW},
+		{john, "modify", jackPlaylist, DENY},
+		{jack, "modify", jackPlaylist, ALLOW},
+		{jack, "view", johnPlaylist, DENY},
+		{jack, "view", johnPublicPlaylist, ALLOW},
+		{john, "view", johnPlaylist, ALLOW},
+		{&Group{Name: "editors"}, "modify", johnPlaylist, DENY},
+		{&Group{Name: "editors"}, "modify", jackPlaylist, ALLOW},
+		{overlord, "delete", &Archive{Name: "test"}, ALLOW},
+		{jack, "delete", &Archive{Name: "test"}, DENY},
+	}
+	for _, c := range cases {
+		if got := rs.Query(c.subject, c.action, c.resource); got != c.want {
+			t.Errorf("Query(%v, %q, %v): got %q want %q", c.subject, c.action, c.resource, got, c.want)
+		}
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadJSON(strings.NewReader(playlistPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	checkPlaylistPolicy(t, rs)
+	if d := rs.QueryExplain(&User{Name: "john"}, "view", &Playlist{User: "john"}); d.Rule == nil || d.Rule.ID != "owner-view" {
+		t.Errorf("got %+v want the owner-view rule", d)
+	}
+}
+
+func TestSaveJSON(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadJSON(strings.NewReader(playlistPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+
+	var buf bytes.Buffer
+	if err := rs.SaveJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newPolicyRuleSet()
+	if err := reloaded.LoadJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkPlaylistPolicy(t, reloaded)
+	if got, want := reloaded.DeclarativeRules(), rs.DeclarativeRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %+v want %+v", got, want)
+	}
+	if got := reloaded.Query(&User{Name: "jack"}, "view", &Video{}); got != DENY {
+		t.Errorf("got %q want %q: Go matchers must not be saved", got, DENY)
+	}
+}
+
+func TestLoadJSONErrors(t *testing.T) {
+	cases := []struct {
+		policy string
+		err    string
+	}{
+		{`{"rules": [{"subject": "Admin", "action": "view", "effect": "allow"}]}`,
+			`perms: rule 0: unknown subject type "Admin"`},
+		{`{"rules": [{"subject": "User", "effect": "allow"}, {"resource": "Song", "effect": "allow"}]}`,
+			`perms: rule 1: unknown resource type "Song"`},
+		{`{"rules": [{"resource": "Video", "conditions": [{"field": "resource.Owner", "value": "x"}], "effect": "allow"}]}`,
+			`perms: rule 0: type perms.Video has no exported field "Owner"`},
+		{`{"rules": [{"subject": "User", "conditions": [{"field": "user.Name", "value": "x"}], "effect": "allow"}]}`,
+			`perms: rule 0: field "user.Name" must start with subject, action, resource or env`},
+		{`{"rules": [{"subject": "User", "action": "view"}]}`,
+			`perms: rule 0: missing effect`},
+		{`{"rules": [{"id": "a", "effect": "allow"}, {"id": "a", "effect": "deny"}]}`,
+			`perms: rule 1: duplicate id "a"`},
+	}
+	for _, c := range cases {
+		rs := newPolicyRuleSet()
+		err := rs.LoadJSON(strings.NewReader(c.policy))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+		if n := len(rs.byID); n != 0 {
+			t.Errorf("got %d rules loaded from an invalid policy", n)
+		}
+	}
+}
+
+func TestDeclarativeRuleCheck(t *testing.T) {
+	types := newPolicyRuleSet().Types()
+	if err := (DeclarativeRule{Subject: "User", Condition: "subject.IsSuperuser", Effect: ALLOW}).Check(types); err != nil {
+		t.Errorf("got %v", err)
+	}
+	if err := (DeclarativeRule{Subject: "Admin", Effect: ALLOW}).Check(types); err == nil {
+		t.Errorf("got no error for an unknown type")
+	}
+	err := (DeclarativeRule{Resource: "Video", Condition: "resource.Public && resource.Owner", Effect: ALLOW}).Check(types)
+	if exprErr, ok := err.(*ExpressionError); !ok || exprErr.Column != 20 {
+		t.Errorf("got %#v want an ExpressionError at column 20", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// DynamicDefaultReason is the Reason of the decisions with the default effect returned
+// by the DefaultEffectFn of the rule set.
+const DynamicDefaultReason = "default (dynamic)"
+
+// SetDefaultEffectForType sets the default effect of the queries for resources of the
+// same type as resourceType, used in place of DefaultEffect when no rule nor role
+// produces an effect. The queries stopped by an error evaluating their rules still
+// return DefaultEffect.
+// With NormalizePointers, the default effect of a type also applies to the pointers
+// to it, and vice versa, unless they have their own. An empty effect removes the
+// default effect of the type.
+func (ruleSet *RuleSet) SetDefaultEffectForType(resourceType interface{}, effect string) {
+	t := reflect.TypeOf(resourceType)
+	ruleSet.update(func(w *tableWriter) {
+		defaults := make(map[reflect.Type]Effect, len(w.table.defaults)+1)
+		for t, effect := range w.table.defaults {
+			defaults[t] = effect
+		}
+		if effect == "" {
+			delete(defaults, t)
+		} else {
+			defaults[t] = effect
+		}
+		w.table.defaults = defaults
+	})
+}
+
+// DefaultEffectForType returns the default effect set for the type of resourceType
+// with SetDefaultEffectForType, if any.
+func (ruleSet *RuleSet) DefaultEffectForType(resourceType interface{}) (Effect, bool) {
+	effect, ok := ruleSet.current().defaults[reflect.TypeOf(resourceType)]
+	return effect, ok
+}
+
+// defaultDecision returns the decision of the query of (subject, action, resource) for
+// which no rule nor role produces an effect, with the default effects of table: that
+// of the DefaultEffectFn, of the resource type, or else DefaultEffect.
+func (ruleSet *RuleSet) defaultDecision(table *ruleTable, subject interface{}, action interface{}, resource interface{}) Decision {
+	if ruleSet.DefaultEffectFn != nil {
+		if effect := ruleSet.DefaultEffectFn(subject, action, resource); effect != "" {
+			ruleSet.logf("perms: no rule applies, dynamic default effect %q", effect)
+			return Decision{Effect: effect, Default: true, Reason: DynamicDefaultReason}
+		}
+	}
+	if effect, ok := table.defaultEffectFor(resource, ruleSet.NormalizePointers); ok {
+		ruleSet.logf("perms: no rule applies, default effect %q of the resource type", effect)
+		return Decision{Effect: effect, Default: true}
+	}
+	if ruleSet.Logger != nil {
+		ruleSet.logf("perms: no rule applies, default effect %q", ruleSet.DefaultEffect)
+	}
+	return Decision{Effect: ruleSet.DefaultEffect, Default: true}
+}
+
+// defaultEffectFor returns the default effect of the type of resource, or of its
+// pointer or pointed type when normalizing the pointers.
+func (table *ruleTable) defaultEffectFor(resource interface{}, normalize bool) (Effect, bool) {
+	if len(table.defaults) == 0 {
+		return "", false
+	}
+	t := reflect.TypeOf(resource)
+	if effect, ok := table.defaults[t]; ok || !normalize || t == nil {
+		return effect, ok
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	} else {
+		t = reflect.PointerTo(t)
+	}
+	effect, ok := table.defaults[t]
+	return effect, ok
+}
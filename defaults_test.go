@@ -0,0 +1,105 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestDefaultEffectForType(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.WithCache(100, nil)
+	rs.AddRule(&User{}, "delete", &Archive{}, effectMatcher(DENY))
+	rs.AddRule(&User{}, "modify", &Archive{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return false, "", false
+	})
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Archive{}); got != DENY {
+		t.Fatalf("got %q want %q before the type default", got, DENY)
+	}
+
+	rs.SetDefaultEffectForType(&Archive{}, ALLOW)
+	if effect, ok := rs.DefaultEffectForType(&Archive{}); !ok || effect != ALLOW {
+		t.Errorf("got %q, %v want the type default", effect, ok)
+	}
+	d := rs.QueryExplain(john, "view", &Archive{})
+	if d.Effect != ALLOW || !d.Default {
+		t.Errorf("got %+v want the default of the archives", d)
+	}
+	if got := rs.Query(john, "modify", &Archive{}); got != ALLOW {
+		t.Errorf("got %q want %q when no rule matches", got, ALLOW)
+	}
+	// the explicit deny wins
+	if d := rs.QueryExplain(john, "delete", &Archive{}); d.Effect != DENY || d.Default {
+		t.Errorf("got %+v want the deny rule", d)
+	}
+	// the other types keep the global default
+	if got := rs.Query(john, "view", &Video{}); got != DENY {
+		t.Errorf("got %q want %q for the videos", got, DENY)
+	}
+	// the value type only shares the default when normalizing the pointers
+	if got := rs.Query(john, "view", Archive{}); got != DENY {
+		t.Errorf("got %q want %q for an Archive value", got, DENY)
+	}
+	rs.NormalizePointers = true
+	if got := rs.Query(john, "view", Archive{}); got != ALLOW {
+		t.Errorf("got %q want %q for a normalized Archive value", got, ALLOW)
+	}
+
+	rs.SetDefaultEffectForType(&Archive{}, "")
+	if got := rs.Query(john, "view", &Archive{}); got != DENY {
+		t.Errorf("got %q want %q after removing the type default", got, DENY)
+	}
+}
+
+func TestDefaultEffectFn(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "delete", nil, effectMatcher(DENY))
+	var called []interface{}
+	rs.DefaultEffectFn = func(subject interface{}, action interface{}, resource interface{}) string {
+		called = append(called, subject, action, resource)
+		switch resource.(type) {
+		case *Archive:
+			// the legacy archives are open to everyone
+			return ALLOW
+		case *Video:
+			return DENY
+		}
+		return ""
+	}
+	var audited []AuditEvent
+	rs.AuditDecisionFn = func(event AuditEvent) {
+		audited = append(audited, event)
+	}
+	john := &User{Name: "john"}
+	archive := &Archive{}
+
+	d := rs.QueryExplain(john, "view", archive)
+	if d.Effect != ALLOW || !d.Default || d.Reason != DynamicDefaultReason || d.Rule != nil {
+		t.Errorf("got %+v want the dynamic default", d)
+	}
+	if len(called) != 3 || called[0] != john || called[1] != "view" || called[2] != archive {
+		t.Errorf("got %v want the values of the query", called)
+	}
+	if len(audited) != 1 || audited[0].Decision.Reason != DynamicDefaultReason {
+		t.Errorf("got %+v want the dynamic default audited", audited)
+	}
+	rs.DefaultEffect = ALLOW
+	if d := rs.QueryExplain(john, "view", &Video{}); d.Effect != DENY || d.Reason != DynamicDefaultReason {
+		t.Errorf("got %+v want the videos denied", d)
+	}
+
+	// the rules decide first, and "" falls back to the default effects
+	called = nil
+	if d := rs.QueryExplain(john, "delete", archive); d.Effect != DENY || d.Default || len(called) != 0 {
+		t.Errorf("got %+v, called %v want the rule", d, called)
+	}
+	if d := rs.QueryExplain(john, "view", "readme"); d.Effect != ALLOW || d.Reason != "" {
+		t.Errorf("got %+v want DefaultEffect", d)
+	}
+	rs.SetDefaultEffectForType("", DENY)
+	if d := rs.QueryExplain(john, "view", "readme"); d.Effect != DENY || d.Reason != "" {
+		t.Errorf("got %+v want the default of the strings", d)
+	}
+	if got := rs.QueryOpt(john, "view", archive, WithDefaultEffect(DENY)); got.Effect != DENY {
+		t.Errorf("got %+v want the default effect of the query", got)
+	}
+}
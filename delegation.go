@@ -0,0 +1,32 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDelegationDenied is returned by QueryAs when the DelegationPolicy vetoes the
+// delegation.
+var ErrDelegationDenied = errors.New("perms: delegation denied")
+
+// QueryAs evaluates the (subject, action, resource) triple for actor acting on behalf
+// of subject, eg. a support user impersonating a customer: the rules, and their
+// matchers, only see subject, while the decision and the audit hooks also report
+// actor (see Decision.Actor and AuditEvent.Actor).
+//
+// When the DelegationPolicy of the rule set vetoes the delegation, the rules are not
+// evaluated, and QueryAs returns a Deny decision along with ErrDelegationDenied. The
+// other errors are those of QueryCtx.
+func (ruleSet *RuleSet) QueryAs(actor interface{}, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	return ruleSet.QueryAsCtx(context.Background(), actor, subject, action, resource)
+}
+
+// QueryAsCtx is like QueryAs, but passes ctx to the matchers, see QueryCtx.
+func (ruleSet *RuleSet) QueryAsCtx(ctx context.Context, actor interface{}, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	return ruleSet.evaluateWith(ctx, queryOptions{actor: actor, delegated: true}, subject, action, resource)
+}
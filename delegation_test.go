@@ -0,0 +1,71 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestQueryAs(t *testing.T) {
+	rs := newVideoRuleSet()
+	var seen []interface{}
+	rs.AddRule(&User{}, "delete", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		seen = append(seen, subj)
+		return true, ALLOW, false
+	})
+	support := &User{Name: "mary"}
+	customer := &User{Name: "jack"}
+	var events []AuditEvent
+	rs.AuditDecisionFn = func(event AuditEvent) {
+		events = append(events, event)
+	}
+
+	// the decisions are those of the customer
+	for _, resource := range videoResources {
+		for _, action := range videoActions {
+			d, err := rs.QueryAs(support, customer, action, resource)
+			if err != nil || d.Effect != rs.Query(customer, action, resource) || d.Actor != support {
+				t.Errorf("%v %v: got %+v, %v want the decision of jack", action, resource, d, err)
+			}
+		}
+	}
+	for _, subject := range seen {
+		if subject != customer {
+			t.Errorf("got the matcher called with %v want only the customer", subject)
+		}
+	}
+	if len(seen) == 0 {
+		t.Errorf("got the delete matcher never called")
+	}
+	if len(events) == 0 || events[0].Actor != support || events[0].Subject != customer || events[0].Decision.Actor != support {
+		t.Errorf("got audit events %+v want the actor and the customer", events)
+	}
+	if last := events[len(events)-1]; last.Actor != nil {
+		t.Errorf("got actor %v for a plain query", last.Actor)
+	}
+}
+
+func TestDelegationPolicy(t *testing.T) {
+	rs := NewRuleSet(ALLOW)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	rs.DelegationPolicy = func(actor interface{}, subject interface{}, action interface{}, resource interface{}) bool {
+		// only the superusers may impersonate
+		user, ok := actor.(*User)
+		return ok && user.IsSuperuser
+	}
+	var audited []AuditEvent
+	rs.AuditDecisionFn = func(event AuditEvent) {
+		audited = append(audited, event)
+	}
+	customer := &User{Name: "jack"}
+
+	d, err := rs.QueryAs(&User{Name: "john"}, customer, "view", &Video{})
+	if err != ErrDelegationDenied || d.Effect != DENY || d.Rule != nil {
+		t.Errorf("got %+v, %v want the delegation vetoed", d, err)
+	}
+	if len(audited) != 1 || audited[0].Decision.Effect != DENY || audited[0].Actor == nil {
+		t.Errorf("got audit events %+v want the vetoed delegation", audited)
+	}
+	d, err = rs.QueryAs(&User{Name: "admin", IsSuperuser: true}, customer, "view", &Video{})
+	if err != nil || d.Effect != ALLOW || d.Rule == nil {
+		t.Errorf("got %+v, %v want the delegation allowed", d, err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DiffKind is the kind of a RuleChange.
+type DiffKind string
+
+const (
+	// RuleAdded is a rule only in the new rule set.
+	RuleAdded DiffKind = "added"
+	// RuleRemoved is a rule only in the old rule set.
+	RuleRemoved DiffKind = "removed"
+	// RuleModified is a rule in both rule sets, but different.
+	RuleModified DiffKind = "modified"
+)
+
+// RuleChange is a change of a rule found by Diff.
+type RuleChange struct {
+	Kind DiffKind
+	// Old and New describe the rule in the old and in the new rule set, nil for the
+	// added and the removed rules respectively.
+	Old *RuleInfo
+	New *RuleInfo
+	// Fields are the changed properties of a modified rule, among "Templates",
+	// "Priority", "Enabled", "Validity", "Name", "Description", "Tags", "Matcher"
+	// (the MatcherName) and "Declaration" (the source of a declarative rule).
+	Fields []string
+}
+
+// DiffResult lists the changes of the rules between two rule sets, see Diff.
+type DiffResult struct {
+	// Changes are the removed and modified rules, in the order of the old rule set,
+	// followed by the added rules, in the order of the new one.
+	Changes []RuleChange
+}
+
+// Empty reports whether the rule sets have the same rules.
+func (diff DiffResult) Empty() bool {
+	return len(diff.Changes) == 0
+}
+
+// String renders the changes one per line, eg. for a diff block in a review comment:
+// the added and the removed rules prefixed by "+" and "-", with their templates, and
+// the modified ones prefixed by "~", with their changes, like "Priority 0 -> 10".
+func (diff DiffResult) String() string {
+	if diff.Empty() {
+		return "no changes\n"
+	}
+	var b strings.Builder
+	for _, change := range diff.Changes {
+		switch change.Kind {
+		case RuleAdded:
+			fmt.Fprintf(&b, "+ %s: %s\n", describeRule(*change.New), describeTemplates(change.New))
+		case RuleRemoved:
+			fmt.Fprintf(&b, "- %s: %s\n", describeRule(*change.Old), describeTemplates(change.Old))
+		default:
+			details := make([]string, len(change.Fields))
+			for i, field := range change.Fields {
+				details[i] = describeFieldChange(field, change.Old, change.New)
+			}
+			fmt.Fprintf(&b, "~ %s: %s\n", describeRule(*change.New), strings.Join(details, ", "))
+		}
+	}
+	return b.String()
+}
+
+// Diff returns the changes of the rules from old to new, eg. to review a policy before
+// deploying it. The rules with an explicit id, as given to AddRuleWithID or by a
+// DeclarativeRule, are matched by id. Those with a generated id (see RuleSet.Rules),
+// which changes from a rule set to another, are matched by their templates and the
+// name of their matcher, the MatcherName or else the Name set by Named, in order when
+// several rules have the same. The rules of the namespaces are not compared.
+func Diff(old *RuleSet, new *RuleSet) DiffResult {
+	olds, news := diffRulesOf(old), diffRulesOf(new)
+	byID := make(map[RuleID]*diffRule)
+	byKey := make(map[string][]*diffRule)
+	for _, rule := range news {
+		if generatedRuleID(rule.rule.id) {
+			byKey[rule.key()] = append(byKey[rule.key()], rule)
+		} else {
+			byID[rule.rule.id] = rule
+		}
+	}
+
+	var diff DiffResult
+	for _, rule := range olds {
+		var other *diffRule
+		if generatedRuleID(rule.rule.id) {
+			key := rule.key()
+			if candidates := byKey[key]; len(candidates) > 0 {
+				other, byKey[key] = candidates[0], candidates[1:]
+			}
+		} else {
+			other = byID[rule.rule.id]
+		}
+		if other == nil {
+			diff.Changes = append(diff.Changes, RuleChange{Kind: RuleRemoved, Old: rule.info})
+			continue
+		}
+		other.matched = true
+		if fields := rule.changedFields(other); len(fields) > 0 {
+			diff.Changes = append(diff.Changes, RuleChange{Kind: RuleModified, Old: rule.info, New: other.info, Fields: fields})
+		}
+	}
+	for _, rule := range news {
+		if !rule.matched {
+			diff.Changes = append(diff.Changes, RuleChange{Kind: RuleAdded, New: rule.info})
+		}
+	}
+	return diff
+}
+
+// diffRule is a rule compared by Diff.
+type diffRule struct {
+	rule    *Rule
+	info    *RuleInfo
+	matched bool
+}
+
+func diffRulesOf(ruleSet *RuleSet) []*diffRule {
+	var rules []*diffRule
+	ruleSet.walkTable(ruleSet.current(), func(rule *Rule, info *RuleInfo) bool {
+		rules = append(rules, &diffRule{rule: rule, info: info})
+		return true
+	})
+	return rules
+}
+
+// generatedRuleID reports whether id has the form of the generated ids, "rule-N".
+func generatedRuleID(id RuleID) bool {
+	digits := strings.TrimPrefix(string(id), "rule-")
+	if digits == string(id) || digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the description of the templates and of the matcher of a rule with a
+// generated id.
+func (r *diffRule) key() string {
+	matcher := r.rule.matcherName
+	if matcher == "" {
+		matcher = r.rule.name
+	}
+	types := r.rule.types()
+	return fmt.Sprintf("%s %s %s %#v %#v %#v %s", typeString(types[0]), typeString(types[1]), typeString(types[2]),
+		r.rule.subject, r.rule.action, r.rule.resource, matcher)
+}
+
+// changedFields returns the properties of the rule that differ in other, see
+// RuleChange.Fields.
+func (r *diffRule) changedFields(other *diffRule) []string {
+	a, b := r.rule, other.rule
+	var fields []string
+	if a.types() != b.types() || !reflect.DeepEqual(a.templates(), b.templates()) || !reflect.DeepEqual(a.patterns, b.patterns) {
+		fields = append(fields, "Templates")
+	}
+	if a.priority != b.priority {
+		fields = append(fields, "Priority")
+	}
+	if a.disabled != b.disabled {
+		fields = append(fields, "Enabled")
+	}
+	if !a.notBefore.Equal(b.notBefore) || !a.notAfter.Equal(b.notAfter) {
+		fields = append(fields, "Validity")
+	}
+	if a.name != b.name {
+		fields = append(fields, "Name")
+	}
+	if a.description != b.description {
+		fields = append(fields, "Description")
+	}
+	if strings.Join(a.tags, "\x00") != strings.Join(b.tags, "\x00") || len(a.tags) != len(b.tags) {
+		fields = append(fields, "Tags")
+	}
+	if a.matcherName != b.matcherName {
+		fields = append(fields, "Matcher")
+	}
+	if !reflect.DeepEqual(a.decl, b.decl) {
+		fields = append(fields, "Declaration")
+	}
+	return fields
+}
+
+// describeTemplates returns the templates of a rule, "*" for those admitting any value
+// and the type for the others but the strings.
+func describeTemplates(info *RuleInfo) string {
+	templates := [3]interface{}{info.SubjectTemplate, info.ActionTemplate, info.ResourceTemplate}
+	types := [3]reflect.Type{info.SubjectType, info.ActionType, info.ResourceType}
+	descriptions := make([]string, len(templates))
+	for i, template := range templates {
+		switch template := template.(type) {
+		case nil:
+			descriptions[i] = "*"
+		case string:
+			descriptions[i] = fmt.Sprintf("%q", template)
+		default:
+			descriptions[i] = typeString(types[i])
+		}
+	}
+	return strings.Join(descriptions, " ")
+}
+
+// describeFieldChange returns the change of a field of a modified rule, with the old
+// and the new values when in RuleInfo.
+func describeFieldChange(field string, old *RuleInfo, new *RuleInfo) string {
+	switch field {
+	case "Templates":
+		return fmt.Sprintf("%s %s -> %s", field, describeTemplates(old), describeTemplates(new))
+	case "Priority":
+		return fmt.Sprintf("%s %d -> %d", field, old.Priority, new.Priority)
+	case "Name", "Description":
+		before, after := old.Name, new.Name
+		if field == "Description" {
+			before, after = old.Description, new.Description
+		}
+		return fmt.Sprintf("%s %q -> %q", field, before, after)
+	case "Tags":
+		return fmt.Sprintf("%s %v -> %v", field, old.Tags, new.Tags)
+	}
+	return field
+}
@@ -0,0 +1,77 @@
+package perms
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := NewRuleSet(DENY)
+	old.AddRuleWithPriority(0, &User{}, "view", &Video{}, effectMatcher(ALLOW), Named("owner"))
+	old.AddRule(nil, "view", &Video{}, effectMatcher(ALLOW), Named("public"))
+	old.AddRuleWithID("legacy", nil, "delete", nil, effectMatcher(DENY))
+	old.AddRuleWithID("editors", &User{}, "edit", &Video{}, effectMatcher(ALLOW))
+
+	// the generated ids differ
+	new := NewRuleSet(DENY)
+	new.AddRule(&User{}, "share", &Video{}, effectMatcher(ALLOW))
+	new.AddRuleWithID("editors", &User{}, "edit", &Video{}, effectMatcher(ALLOW), Tagged("video"))
+	new.AddRule(nil, "view", &Video{}, effectMatcher(ALLOW), Named("public"))
+	new.AddRuleWithPriority(10, &User{}, "view", &Video{}, effectMatcher(ALLOW), Named("owner"))
+
+	diff := Diff(old, new)
+	var kinds []DiffKind
+	for _, change := range diff.Changes {
+		kinds = append(kinds, change.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []DiffKind{RuleModified, RuleModified, RuleRemoved, RuleAdded}) {
+		t.Fatalf("got %v", diff.Changes)
+	}
+	owner, editors, legacy, share := diff.Changes[0], diff.Changes[1], diff.Changes[2], diff.Changes[3]
+	if owner.Old.Name != "owner" || owner.New.Priority != 10 || !reflect.DeepEqual(owner.Fields, []string{"Priority"}) {
+		t.Errorf("got %+v want the priority of owner changed", owner)
+	}
+	if legacy.Old.ID != "legacy" || legacy.New != nil {
+		t.Errorf("got %+v want legacy removed", legacy)
+	}
+	if editors.Old.ID != "editors" || !reflect.DeepEqual(editors.Fields, []string{"Tags"}) {
+		t.Errorf("got %+v want the tags of editors changed", editors)
+	}
+	if share.Old != nil || share.New.ActionTemplate != "share" {
+		t.Errorf("got %+v want share added", share)
+	}
+
+	want := `~ rule-3 (owner): Priority 0 -> 10
+~ editors [video]: Tags [] -> [video]
+- legacy: * "delete" *
++ rule-1: *perms.User "share" *perms.Video
+`
+	if diff.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", diff, want)
+	}
+
+	if diff := Diff(old, old.Clone()); !diff.Empty() || diff.String() != "no changes\n" {
+		t.Errorf("got %v want no changes", diff)
+	}
+}
+
+func TestDiffDeclarative(t *testing.T) {
+	old := newPolicyRuleSet()
+	if err := old.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	new := old.Clone()
+	decl := new.DeclarativeRules()[1]
+	decl.Effect = DENY
+	if err := new.RemoveRule(decl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := new.AddDeclarativeRule(decl); err != nil {
+		t.Fatal(err)
+	}
+	diff := Diff(old, new)
+	if len(diff.Changes) != 1 || diff.Changes[0].Kind != RuleModified || !reflect.DeepEqual(diff.Changes[0].Fields, []string{"Declaration"}) {
+		t.Errorf("got %v want the declaration of %s changed", diff.Changes, decl.ID)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DOTOptions controls the graph written by ExportDOT.
+type DOTOptions struct {
+	// ClusterByResource groups the resource nodes of each type in a cluster.
+	ClusterByResource bool
+	// OmitDisabled leaves out the rules disabled with DisableRule, otherwise drawn
+	// with dashed edges.
+	OmitDisabled bool
+}
+
+// dotNode is a node of the graph: a template of the rules in a position.
+type dotNode struct {
+	position int
+	// label describes the template, t is its type, nil for the "jolly" templates
+	label string
+	t     reflect.Type
+	id    string
+}
+
+// ExportDOT writes the rules as a Graphviz DOT graph: the subject, action and resource
+// templates are the nodes, and each rule is a path from its subject to its action and
+// then to its resource, labeled with its name (or id), its priority and, for the
+// declarative rules, its effect and conditions. The "jolly" templates are drawn as
+// wildcard nodes. The output only depends on the rules, and is sorted.
+func (ruleSet *RuleSet) ExportDOT(w io.Writer, opts DOTOptions) error {
+	type dotEdge struct {
+		nodes [3]*dotNode
+		rule  *Rule
+	}
+	nodes := make(map[[2]string]*dotNode)
+	var edges []dotEdge
+	ruleSet.walkRules(func(rule *Rule, info *RuleInfo) bool {
+		if rule.disabled && opts.OmitDisabled {
+			return true
+		}
+		edge := dotEdge{rule: rule}
+		for position, template := range rule.templates() {
+			node := ruleSet.dotNode(rule, position, template)
+			key := [2]string{fmt.Sprint(position), node.label}
+			if existing, ok := nodes[key]; ok {
+				node = existing
+			} else {
+				nodes[key] = node
+			}
+			edge.nodes[position] = node
+		}
+		edges = append(edges, edge)
+		return true
+	})
+
+	// the nodes in label order, the wildcards first
+	sorted := make([]*dotNode, 0, len(nodes))
+	for _, node := range nodes {
+		sorted = append(sorted, node)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.position != b.position {
+			return a.position < b.position
+		}
+		if (a.t == nil) != (b.t == nil) {
+			return a.t == nil
+		}
+		return a.label < b.label
+	})
+	counts := [3]int{}
+	for _, node := range sorted {
+		counts[node.position]++
+		node.id = fmt.Sprintf("%c%d", "sar"[node.position], counts[node.position])
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph perms {")
+	fmt.Fprintln(out, "\trankdir=LR;")
+	fmt.Fprintln(out, "\tnode [shape=box];")
+	clusters := make(map[string][]*dotNode)
+	var clusterNames []string
+	for _, node := range sorted {
+		if node.position == 2 && opts.ClusterByResource && node.t != nil {
+			name := ruleSet.dotTypeName(node.t)
+			if _, ok := clusters[name]; !ok {
+				clusterNames = append(clusterNames, name)
+			}
+			clusters[name] = append(clusters[name], node)
+			continue
+		}
+		writeDOTNode(out, "\t", node)
+	}
+	sort.Strings(clusterNames)
+	for i, name := range clusterNames {
+		fmt.Fprintf(out, "\tsubgraph cluster_%d {\n", i+1)
+		fmt.Fprintf(out, "\t\tlabel=%s;\n", dotQuote(name))
+		for _, node := range clusters[name] {
+			writeDOTNode(out, "\t\t", node)
+		}
+		fmt.Fprintln(out, "\t}")
+	}
+	for _, edge := range edges {
+		attributes := []string{"label=" + dotQuote(dotRuleLabel(edge.rule))}
+		if edge.rule.decl != nil {
+			switch edge.rule.decl.Effect {
+			case Allow:
+				attributes = append(attributes, "color=darkgreen")
+			case Deny:
+				attributes = append(attributes, "color=red")
+			}
+		}
+		if edge.rule.disabled {
+			attributes = append(attributes, "style=dashed")
+		}
+		fmt.Fprintf(out, "\t%s -> %s -> %s [%s];\n", edge.nodes[0].id, edge.nodes[1].id, edge.nodes[2].id, strings.Join(attributes, ", "))
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// dotNode returns the node for the template of rule in the given position.
+func (ruleSet *RuleSet) dotNode(rule *Rule, position int, template interface{}) *dotNode {
+	t := reflect.TypeOf(template)
+	node := &dotNode{position: position, t: t}
+	literal := t != nil && (t == stringType || (t.Comparable() && t.Kind() != reflect.Ptr))
+	switch {
+	case t == nil:
+		node.label = "*"
+	case template == "":
+		node.label = "string (any)"
+	case rule.patterns[position] == nil && !literal && !rule.deep[position]:
+		node.label = ruleSet.dotTypeName(t)
+	case t == stringType:
+		node.label = fmt.Sprintf("%q", template)
+	default:
+		node.label = ruleSet.dotTypeName(t) + " " + KeyOf(template)
+	}
+	return node
+}
+
+// dotTypeName returns the name of t in the TypeRegistry, or its Go name.
+func (ruleSet *RuleSet) dotTypeName(t reflect.Type) string {
+	if name, ok := ruleSet.types.Name(t); ok {
+		return name
+	}
+	return t.String()
+}
+
+func writeDOTNode(out io.Writer, indent string, node *dotNode) {
+	if node.t == nil {
+		fmt.Fprintf(out, "%s%s [label=%s, shape=circle, style=dashed];\n", indent, node.id, dotQuote(node.label))
+		return
+	}
+	shape := ""
+	if node.position == 1 {
+		shape = ", shape=ellipse"
+	}
+	fmt.Fprintf(out, "%s%s [label=%s%s];\n", indent, node.id, dotQuote(node.label), shape)
+}
+
+// dotRuleLabel returns the label of the edges of rule.
+func dotRuleLabel(rule *Rule) string {
+	lines := []string{string(rule.id)}
+	if rule.name != "" {
+		lines[0] = rule.name
+	}
+	if decl := rule.decl; decl != nil {
+		effect := decl.Effect
+		if decl.Quick {
+			effect += " (quick)"
+		}
+		lines = append(lines, effect)
+		var conditions []string
+		for _, cond := range decl.Conditions {
+			conditions = append(conditions, cond.String())
+		}
+		if decl.Condition != "" {
+			conditions = append(conditions, decl.Condition)
+		}
+		if len(conditions) > 0 {
+			lines = append(lines, strings.Join(conditions, " && "))
+		}
+	}
+	if rule.priority != 0 {
+		lines = append(lines, fmt.Sprintf("priority %d", rule.priority))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
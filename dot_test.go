@@ -0,0 +1,65 @@
+package perms
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+// checkGolden compares got with the content of testdata/name, rewriting it with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func newDOTRuleSet(t *testing.T) *RuleSet {
+	rs := newVideoRuleSet()
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Playlist", &Playlist{})
+	rs.AddRuleWithPriority(10, "", nil, "report", effectMatcher(DENY), Named("no \"reports\""))
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{ID: "public", Subject: "User", Action: "view", Resource: "Playlist", Conditions: []Condition{{Field: "resource.Public", Value: true}}, Condition: "subject.Name != \"\"", Effect: ALLOW, Quick: true}); err != nil {
+		t.Fatal(err)
+	}
+	rs.DisableRule("rule-5")
+	return rs
+}
+
+func TestExportDOT(t *testing.T) {
+	rs := newDOTRuleSet(t)
+	var buf bytes.Buffer
+	if err := rs.ExportDOT(&buf, DOTOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "video.dot", buf.Bytes())
+
+	// the output doesn't depend on the map ordering
+	for i := 0; i < 10; i++ {
+		var again bytes.Buffer
+		newDOTRuleSet(t).ExportDOT(&again, DOTOptions{})
+		if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+			t.Fatalf("got a different output\n%s", again.Bytes())
+		}
+	}
+
+	buf.Reset()
+	if err := rs.ExportDOT(&buf, DOTOptions{ClusterByResource: true, OmitDisabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "video_clustered.dot", buf.Bytes())
+}
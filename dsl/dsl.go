@@ -0,0 +1,336 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package dsl parses policies written in a plain text language into declarative
+// go-perms rules. A policy has a rule per line, eg.:
+//
+//	# owners and superusers can view videos, everyone the public ones
+//	allow User "view" Video when resource.User == subject.Name
+//	allow User "view" Video when resource.Public
+//	allow User * * when subject.IsSuperuser quick
+//	deny * "delete" * unless subject.IsSuperuser priority 10
+//
+// A rule is made of:
+//
+//   - the effect, allow or deny;
+//   - the subject and resource types, names registered in the TypeRegistry of the rule
+//     set, and the action, a "double quoted" string; * stands for a "jolly";
+//   - optionally, when followed by a condition, the rule applying when it is true, or
+//     unless followed by a condition, the rule applying when it is false. The condition
+//     is an expression over the subject, action, resource and env, see
+//     perms.CompileExpression, eg. resource.Public && resource.User != subject.Name;
+//   - optionally, quick, making the effect final (see perms.RuleSet.AddRule), and
+//     priority followed by an integer (see perms.RuleSet.AddRuleWithPriority).
+//
+// Blank lines are skipped, and # starts a comment running to the end of the line.
+// The rules are added in the order they are written:
+//
+//	if err := dsl.Load(rs, file); err != nil {
+//		return err
+//	}
+package dsl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	perms "github.com/panta/go-perms"
+)
+
+// Error reports an invalid policy: the line and the column, in runes, of the offending
+// text, from 1.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("dsl: line %d, column %d: %s", err.Line, err.Column, err.Msg)
+}
+
+// Parse parses a policy and returns its rules, as specs of declarative rules. The rules
+// are checked against types: the type names must be registered and the fields in the
+// conditions must exist. The first error found is returned, as an *Error, or the error
+// reading r.
+func Parse(r io.Reader, types *perms.TypeRegistry) ([]perms.RuleSpec, error) {
+	var specs []perms.RuleSpec
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		p := &lineParser{line: n, src: scanner.Text(), types: types}
+		decl, ok, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			specs = append(specs, perms.RuleSpec{Declarative: decl})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// ParseString is like Parse, reading the policy from src.
+func ParseString(src string, types *perms.TypeRegistry) ([]perms.RuleSpec, error) {
+	return Parse(strings.NewReader(src), types)
+}
+
+// Load parses a policy and adds its rules to ruleSet at once, see Parse and
+// perms.RuleSet.AddRules.
+func Load(ruleSet *perms.RuleSet, r io.Reader) error {
+	specs, err := Parse(r, ruleSet.Types())
+	if err != nil {
+		return err
+	}
+	_, err = ruleSet.AddRules(specs...)
+	return err
+}
+
+const (
+	tokEOL = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOther
+)
+
+type token struct {
+	kind int
+	text string
+	// offset is the byte offset of the token in the line, column its 1-based column
+	offset int
+	column int
+}
+
+func (tok token) String() string {
+	if tok.kind == tokEOL {
+		return "end of line"
+	}
+	return strconv.Quote(tok.text)
+}
+
+// lineParser parses a line of a policy.
+type lineParser struct {
+	line   int
+	src    string
+	types  *perms.TypeRegistry
+	tokens []token
+	i      int
+}
+
+func (p *lineParser) errorf(column int, format string, args ...interface{}) error {
+	return &Error{Line: p.line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *lineParser) column(offset int) int {
+	return utf8.RuneCountInString(p.src[:offset]) + 1
+}
+
+// scan splits the line in tokens, up to the comment if any.
+func (p *lineParser) scan() error {
+	offset := 0
+	for {
+		for offset < len(p.src) {
+			r, size := utf8.DecodeRuneInString(p.src[offset:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			offset += size
+		}
+		start := offset
+		if offset == len(p.src) || p.src[offset] == '#' {
+			p.tokens = append(p.tokens, token{kind: tokEOL, offset: start, column: p.column(start)})
+			return nil
+		}
+		r, size := utf8.DecodeRuneInString(p.src[offset:])
+		kind := tokOther
+		switch {
+		case r == '"' || r == '\'':
+			kind = tokString
+			offset += size
+			for {
+				if offset >= len(p.src) {
+					return p.errorf(p.column(start), "unterminated string")
+				}
+				c := p.src[offset]
+				offset++
+				if c == '\\' && offset < len(p.src) {
+					offset++
+				} else if rune(c) == r {
+					break
+				}
+			}
+		case r == '_' || unicode.IsLetter(r):
+			kind = tokIdent
+			for offset < len(p.src) {
+				r, size := utf8.DecodeRuneInString(p.src[offset:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				offset += size
+			}
+		case r >= '0' && r <= '9':
+			kind = tokNumber
+			for offset < len(p.src) && (p.src[offset] >= '0' && p.src[offset] <= '9' || p.src[offset] == '.') {
+				offset++
+			}
+		default:
+			offset += size
+		}
+		p.tokens = append(p.tokens, token{kind: kind, text: p.src[start:offset], offset: start, column: p.column(start)})
+	}
+}
+
+func (p *lineParser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOL {
+		p.i++
+	}
+	return tok
+}
+
+func (p *lineParser) peek() token {
+	return p.tokens[p.i]
+}
+
+// parse returns the rule of the line, or false for a blank line.
+func (p *lineParser) parse() (*perms.DeclarativeRule, bool, error) {
+	if err := p.scan(); err != nil {
+		return nil, false, err
+	}
+	if p.peek().kind == tokEOL {
+		return nil, false, nil
+	}
+	decl := &perms.DeclarativeRule{}
+
+	tok := p.next()
+	switch {
+	case tok.kind == tokIdent && tok.text == "allow":
+		decl.Effect = perms.Allow
+	case tok.kind == tokIdent && tok.text == "deny":
+		decl.Effect = perms.Deny
+	default:
+		return nil, false, p.errorf(tok.column, "expected allow or deny, got %s", tok)
+	}
+	var err error
+	if decl.Subject, err = p.parseType("subject"); err != nil {
+		return nil, false, err
+	}
+	if decl.Action, err = p.parseAction(); err != nil {
+		return nil, false, err
+	}
+	if decl.Resource, err = p.parseType("resource"); err != nil {
+		return nil, false, err
+	}
+
+	// the condition, whose errors are reported from its column
+	conditionColumn := 0
+	if tok := p.peek(); tok.kind == tokIdent && (tok.text == "when" || tok.text == "unless") {
+		p.next()
+		start := p.peek()
+		if start.kind == tokEOL || isOption(start) {
+			return nil, false, p.errorf(start.column, "expected a condition after %s, got %s", tok.text, start)
+		}
+		for end := p.peek(); end.kind != tokEOL && !(isOption(end) && p.tokens[p.i-1].text != "."); end = p.peek() {
+			p.next()
+		}
+		condition := strings.TrimSpace(p.src[start.offset:p.peek().offset])
+		conditionColumn = start.column
+		if _, err := perms.CompileExpression(condition); err != nil {
+			return nil, false, p.expressionError(err, conditionColumn)
+		}
+		if tok.text == "unless" {
+			condition = "!(" + condition + ")"
+			conditionColumn -= 2
+		}
+		decl.Condition = condition
+	}
+
+	for seen := make(map[string]bool); p.peek().kind != tokEOL; {
+		tok := p.next()
+		if !isOption(tok) {
+			return nil, false, p.errorf(tok.column, "expected when, unless, quick or priority, got %s", tok)
+		}
+		if seen[tok.text] {
+			return nil, false, p.errorf(tok.column, "duplicate %s", tok.text)
+		}
+		seen[tok.text] = true
+		switch tok.text {
+		case "quick":
+			decl.Quick = true
+		case "priority":
+			sign := ""
+			if p.peek().kind == tokOther && p.peek().text == "-" {
+				sign = p.next().text
+			}
+			n := p.next()
+			priority, err := strconv.Atoi(sign + n.text)
+			if n.kind != tokNumber || err != nil {
+				return nil, false, p.errorf(n.column, "expected an integer priority, got %s", n)
+			}
+			decl.Priority = priority
+		}
+	}
+
+	if err := decl.Check(p.types); err != nil {
+		if conditionColumn > 0 {
+			if _, ok := err.(*perms.ExpressionError); ok {
+				return nil, false, p.expressionError(err, conditionColumn)
+			}
+		}
+		return nil, false, p.errorf(1, "%v", err)
+	}
+	return decl, true, nil
+}
+
+// parseType parses the subject or resource type of a rule.
+func (p *lineParser) parseType(role string) (string, error) {
+	tok := p.next()
+	if tok.kind == tokOther && tok.text == "*" {
+		return "", nil
+	}
+	if tok.kind != tokIdent {
+		return "", p.errorf(tok.column, "expected a %s type or *, got %s", role, tok)
+	}
+	if _, ok := p.types.Lookup(tok.text); !ok {
+		return "", p.errorf(tok.column, "unknown %s type %s", role, tok.text)
+	}
+	return tok.text, nil
+}
+
+// parseAction parses the action of a rule.
+func (p *lineParser) parseAction() (string, error) {
+	tok := p.next()
+	if tok.kind == tokOther && tok.text == "*" {
+		return "", nil
+	}
+	if tok.kind != tokString || tok.text[0] != '"' {
+		return "", p.errorf(tok.column, "expected a quoted action or *, got %s", tok)
+	}
+	action, err := strconv.Unquote(tok.text)
+	if err != nil {
+		return "", p.errorf(tok.column, "malformed action %s", tok.text)
+	}
+	return action, nil
+}
+
+// expressionError converts the error of the condition starting at column.
+func (p *lineParser) expressionError(err error, column int) error {
+	if exprErr, ok := err.(*perms.ExpressionError); ok {
+		return p.errorf(column+exprErr.Column-1, "%s", exprErr.Msg)
+	}
+	return p.errorf(column, "%v", err)
+}
+
+func isOption(tok token) bool {
+	return tok.kind == tokIdent && (tok.text == "quick" || tok.text == "priority")
+}
@@ -0,0 +1,184 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+type User struct {
+	Name        string
+	IsSuperuser bool
+}
+
+type Group struct {
+	Name string
+}
+
+type Video struct {
+	Name   string
+	Public bool
+	User   string
+	Group  string
+}
+
+type Playlist struct {
+	ID     string
+	Public bool
+	User   string
+	Group  string
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Group", &Group{})
+	rs.RegisterType("Video", &Video{})
+	rs.RegisterType("Playlist", &Playlist{})
+	return rs
+}
+
+// videoPolicy has the rules of TestAddRule in package perms.
+const videoPolicy = `
+# playlists and videos are viewed by their owners, or by everyone if public
+allow User "view" Playlist when resource.Public || resource.User == subject.Name
+allow User "modify" Playlist when resource.User == subject.Name
+allow User "view" Video when resource.Public || resource.User == subject.Name
+allow User "modify" Video when resource.User == subject.Name  # owners only
+allow Group "modify" Playlist when resource.Group == subject.Name
+
+# superusers can do anything, but deleting is for them only
+allow User * * when subject.IsSuperuser quick
+deny * "delete" * unless subject.IsSuperuser priority 10
+`
+
+func TestLoad(t *testing.T) {
+	rs := newRuleSet()
+	if err := Load(rs, strings.NewReader(videoPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rs.Rules()); n != 7 {
+		t.Errorf("got %d rules want 7", n)
+	}
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	admin := &User{Name: "admin", IsSuperuser: true}
+	editors := &Group{Name: "editors"}
+	johnVideo := &Video{Name: "holidays", User: "john"}
+	publicVideo := &Video{Name: "trailer", User: "jack", Public: true}
+	playlist := &Playlist{ID: "best", User: "john", Group: "editors"}
+
+	for _, tc := range []struct {
+		subject  interface{}
+		action   string
+		resource interface{}
+		effect   string
+	}{
+		{john, "view", johnVideo, perms.Allow},
+		{jack, "view", johnVideo, perms.Deny},
+		{jack, "view", publicVideo, perms.Allow},
+		{john, "modify", johnVideo, perms.Allow},
+		{john, "modify", publicVideo, perms.Deny},
+		{admin, "modify", johnVideo, perms.Allow},
+		{john, "view", playlist, perms.Allow},
+		{jack, "view", playlist, perms.Deny},
+		{jack, "modify", playlist, perms.Deny},
+		{editors, "modify", playlist, perms.Allow},
+		{editors, "modify", &Playlist{Group: "writers"}, perms.Deny},
+		{john, "delete", johnVideo, perms.Deny},
+		{admin, "delete", johnVideo, perms.Allow},
+		{admin, "archive", "anything", perms.Allow},
+	} {
+		if effect := rs.Query(tc.subject, tc.action, tc.resource); effect != tc.effect {
+			t.Errorf("%v %s %v: got %s want %s", tc.subject, tc.action, tc.resource, effect, tc.effect)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	rs := newRuleSet()
+	policy := `allow User "modify" Video when env.mfa == true && resource.User == subject.Name`
+	if err := Load(rs, strings.NewReader(policy)); err != nil {
+		t.Fatal(err)
+	}
+	john := &User{Name: "john"}
+	video := &Video{Name: "holidays", User: "john"}
+	if effect := rs.QueryEnv(john, "modify", video, map[string]interface{}{"mfa": true}); effect != perms.Allow {
+		t.Errorf("got %s want %s with mfa", effect, perms.Allow)
+	}
+	if effect := rs.QueryEnv(john, "modify", video, nil); effect != perms.Deny {
+		t.Errorf("got %s want %s without mfa", effect, perms.Deny)
+	}
+}
+
+func TestParse(t *testing.T) {
+	specs, err := ParseString(videoPolicy, newRuleSet().Types())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 7 {
+		t.Fatalf("got %d specs want 7", len(specs))
+	}
+	want := perms.DeclarativeRule{
+		Action:    "delete",
+		Condition: "!(subject.IsSuperuser)",
+		Effect:    perms.Deny,
+		Priority:  10,
+	}
+	if decl := specs[6].Declarative; decl == nil || decl.Subject != "" || decl.Resource != "" || decl.Action != want.Action ||
+		decl.Condition != want.Condition || decl.Effect != want.Effect || decl.Priority != want.Priority || decl.Quick {
+		t.Errorf("got %+v want %+v", decl, want)
+	}
+	if decl := specs[5].Declarative; !decl.Quick || decl.Subject != "User" || decl.Action != "" || decl.Condition != "subject.IsSuperuser" {
+		t.Errorf("got %+v", decl)
+	}
+	if decl := specs[3].Declarative; decl.Condition != "resource.User == subject.Name" {
+		t.Errorf("got the comment in the condition %q", decl.Condition)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	types := newRuleSet().Types()
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{`permit User "view" Video`, `dsl: line 1, column 1: expected allow or deny, got "permit"`},
+		{"\n\nallow", `dsl: line 3, column 6: expected a subject type or *, got end of line`},
+		{`allow Admin "view" Video`, `dsl: line 1, column 7: unknown subject type Admin`},
+		{`allow User view Video`, `dsl: line 1, column 12: expected a quoted action or *, got "view"`},
+		{`allow User 'view' Video`, `dsl: line 1, column 12: expected a quoted action or *, got "'view'"`},
+		{`allow User "view`, `dsl: line 1, column 12: unterminated string`},
+		{`allow User "view" Folder`, `dsl: line 1, column 19: unknown resource type Folder`},
+		{`allow User "view" "Video"`, `dsl: line 1, column 19: expected a resource type or *, got "\"Video\""`},
+		{`allow User "view" Video if resource.Public`, `dsl: line 1, column 25: expected when, unless, quick or priority, got "if"`},
+		{`allow User "view" Video when`, `dsl: line 1, column 29: expected a condition after when, got end of line`},
+		{`allow User "view" Video when priority 3`, `dsl: line 1, column 30: expected a condition after when, got "priority"`},
+		{`allow User "view" Video when resource.Public &&`, `dsl: line 1, column 48: unexpected end of expression`},
+		{`allow User "view" Video when resource.Public = true`, `dsl: line 1, column 46: unexpected character '='`},
+		{`allow User "view" Video unless (subject.Name == "john"`, `dsl: line 1, column 55: got end of expression want ")"`},
+		{`allow User "view" Video when resource.Owner == subject.Name`, `dsl: line 1, column 30: type dsl.Video has no exported field "Owner"`},
+		{`deny User "view" Video unless subject.Banned`, `dsl: line 1, column 31: type dsl.User has no exported field "Banned"`},
+		{`allow User "view" Video priority high`, `dsl: line 1, column 34: expected an integer priority, got "high"`},
+		{`allow User "view" Video priority 1.5`, `dsl: line 1, column 34: expected an integer priority, got "1.5"`},
+		{`allow User "view" Video priority`, `dsl: line 1, column 33: expected an integer priority, got end of line`},
+		{`allow User "view" Video quick quick`, `dsl: line 1, column 31: duplicate quick`},
+		{"allow * * *\n  deny User \"view\" Video when resource.Public priority 1 2", `dsl: line 2, column 58: expected when, unless, quick or priority, got "2"`},
+	} {
+		_, err := ParseString(tc.src, types)
+		if err == nil {
+			t.Errorf("%q: got no error want %s", tc.src, tc.err)
+			continue
+		}
+		if _, ok := err.(*Error); !ok || err.Error() != tc.err {
+			t.Errorf("%q: got %v want %s", tc.src, err, tc.err)
+		}
+	}
+
+	// nothing is added from an invalid policy
+	rs := newRuleSet()
+	if err := Load(rs, strings.NewReader("allow * * *\nallow User")); err == nil || len(rs.Rules()) != 0 {
+		t.Errorf("got %v and %d rules", err, len(rs.Rules()))
+	}
+}
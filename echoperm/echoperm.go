@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package echoperm enforces go-perms rule sets on Echo routes.
+package echoperm
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	perms "github.com/panta/go-perms"
+)
+
+const (
+	// SubjectKey is the context key the subject is read from by default, where an
+	// authentication middleware can store it with c.Set.
+	SubjectKey = "perms.subject"
+	// ResourceKey is the default Config.ResourceKey.
+	ResourceKey = "perms.resource"
+)
+
+// Config configures the middleware returned by MiddlewareWithConfig.
+type Config struct {
+	// Skipper, when non-nil, selects the requests served without any check, eg. those
+	// of the public routes.
+	Skipper middleware.Skipper
+	// SubjectFn extracts the subject of the query, failing by returning nil. By default
+	// the subject is the value stored in the context under SubjectKey.
+	SubjectFn func(c echo.Context) interface{}
+	// ActionFn extracts the action of the query, failing by returning nil. By default
+	// the action is the method of the request.
+	ActionFn func(c echo.Context) interface{}
+	// ResourceFn loads the resource of the query, eg. fetching from a database the
+	// record identified by a path parameter. By default the resource is the route of
+	// the request, eg. "/playlists/:id".
+	ResourceFn func(c echo.Context) (interface{}, error)
+	// ResourceKey is the context key the resource is stored under, so that the handlers
+	// don't need to load it again. ResourceKey by default.
+	ResourceKey string
+	// ErrorHandler, when non-nil, returns the error of the requests denied by the rule
+	// set, receiving the *perms.PermissionDeniedError. By default it is a 403 Forbidden
+	// echo.HTTPError.
+	ErrorHandler func(c echo.Context, err error) error
+}
+
+// Middleware returns the middleware of MiddlewareWithConfig with the default Config.
+func Middleware(rs *perms.RuleSet) echo.MiddlewareFunc {
+	return MiddlewareWithConfig(rs, Config{})
+}
+
+// MiddlewareWithConfig returns a middleware enforcing rs on the subject, action and
+// resource of each request, see perms.RuleSet.EnforceCtx. It fails the request with
+// 401 Unauthorized if there is no subject, with the error of the ErrorHandler if the
+// permission is denied, with 500 Internal Server Error if the action can't be
+// extracted, the resource can't be loaded or the query returns an error.
+func MiddlewareWithConfig(rs *perms.RuleSet, config Config) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = middleware.DefaultSkipper
+	}
+	if config.SubjectFn == nil {
+		config.SubjectFn = func(c echo.Context) interface{} {
+			return c.Get(SubjectKey)
+		}
+	}
+	if config.ActionFn == nil {
+		config.ActionFn = func(c echo.Context) interface{} {
+			return c.Request().Method
+		}
+	}
+	if config.ResourceFn == nil {
+		config.ResourceFn = func(c echo.Context) (interface{}, error) {
+			return c.Path(), nil
+		}
+	}
+	if config.ResourceKey == "" {
+		config.ResourceKey = ResourceKey
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(c echo.Context, err error) error {
+			return echo.NewHTTPError(http.StatusForbidden).SetInternal(err)
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+			subject := config.SubjectFn(c)
+			if subject == nil {
+				return echo.ErrUnauthorized
+			}
+			action := config.ActionFn(c)
+			if action == nil {
+				return echo.ErrInternalServerError
+			}
+			resource, err := config.ResourceFn(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+			}
+			c.Set(config.ResourceKey, resource)
+			err = rs.EnforceCtx(c.Request().Context(), subject, action, resource)
+			if errors.Is(err, perms.ErrPermissionDenied) {
+				return config.ErrorHandler(c, err)
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package echoperm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	perms "github.com/panta/go-perms"
+)
+
+type user struct {
+	Name string
+}
+
+type playlist struct {
+	ID   string
+	User string
+}
+
+var playlists = map[string]*playlist{
+	"6563": {ID: "6563", User: "john"},
+	"9374": {ID: "9374", User: "jack"},
+}
+
+func newServer() *echo.Echo {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.AddRule(&user{}, "GET", &playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*playlist).User == subj.(*user).Name {
+				return true, perms.Allow, false
+			}
+			return true, perms.Deny, false
+		})
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if name := c.Request().Header.Get("X-User"); name != "" {
+				c.Set(SubjectKey, &user{Name: name})
+			}
+			return next(c)
+		}
+	})
+	e.Use(MiddlewareWithConfig(rs, Config{
+		Skipper: func(c echo.Context) bool {
+			return strings.HasPrefix(c.Path(), "/public/")
+		},
+		ResourceFn: func(c echo.Context) (interface{}, error) {
+			p, ok := playlists[c.Param("id")]
+			if !ok {
+				return nil, errors.New("no such playlist")
+			}
+			return p, nil
+		},
+		ResourceKey: "playlist",
+		ErrorHandler: func(c echo.Context, err error) error {
+			return c.JSON(http.StatusForbidden, map[string]string{"denied": err.(*perms.PermissionDeniedError).Effect})
+		},
+	}))
+	e.GET("/playlists/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("playlist").(*playlist).ID)
+	})
+	e.GET("/public/hello", func(c echo.Context) error {
+		return c.String(http.StatusOK, "hello")
+	})
+	return e
+}
+
+func TestMiddleware(t *testing.T) {
+	e := newServer()
+	tests := []struct {
+		user, path string
+		status     int
+		body       string
+	}{
+		{"john", "/playlists/6563", http.StatusOK, "6563"},
+		{"john", "/playlists/9374", http.StatusForbidden, `{"denied":"deny"}`},
+		{"", "/playlists/6563", http.StatusUnauthorized, `{"message":"Unauthorized"}`},
+		{"", "/public/hello", http.StatusOK, "hello"},
+		// a failing loader is not a denial
+		{"john", "/playlists/1", http.StatusInternalServerError, `{"message":"Internal Server Error"}`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			req.Header.Set("X-User", test.user)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != test.status || strings.TrimSpace(rec.Body.String()) != test.body {
+			t.Errorf("%s %s: got %d %q want %d %q", test.user, test.path, rec.Code, rec.Body, test.status, test.body)
+		}
+	}
+}
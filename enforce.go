@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"fmt"
+)
+
+// PermissionDeniedError is the error returned by Enforce when the effect of the query
+// is not one of the AllowedEffects. It matches ErrPermissionDenied with errors.Is.
+type PermissionDeniedError struct {
+	// Subject, Action and Resource describe the queried triple, see RuleSet.FormatValue.
+	Subject  string
+	Action   string
+	Resource string
+	// Effect is the effect of the query.
+	Effect Effect
+	// Rule describes the rule producing Effect, nil when it is the default effect
+	// or comes from a role or the superuser override.
+	Rule *RuleInfo
+	// Reason is the reason given by the rule, see AddRuleWithReason.
+	Reason string
+}
+
+func (err *PermissionDeniedError) Error() string {
+	msg := fmt.Sprintf("perms: permission denied to %s for %s on %s: effect %q", err.Subject, err.Action, err.Resource, err.Effect)
+	if err.Rule != nil {
+		msg += fmt.Sprintf(" by rule %s", err.Rule.ID)
+		if err.Rule.Name != "" {
+			msg += fmt.Sprintf(" (%s)", err.Rule.Name)
+		}
+	}
+	if err.Reason != "" {
+		msg += ": " + err.Reason
+	}
+	return msg
+}
+
+// Is reports whether target is ErrPermissionDenied.
+func (err *PermissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// Enforce queries the (subject, action, resource) triple, returning nil if the effect
+// is one of the AllowedEffects, a *PermissionDeniedError otherwise.
+//
+//	if err := rs.Enforce(user, "edit", doc); errors.Is(err, perms.ErrPermissionDenied) {
+//		http.Error(w, err.Error(), http.StatusForbidden)
+//	}
+func (ruleSet *RuleSet) Enforce(subject interface{}, action interface{}, resource interface{}) error {
+	return ruleSet.EnforceCtx(context.Background(), subject, action, resource)
+}
+
+// EnforceCtx is like Enforce, but evaluates the rules with the context of the query,
+// see QueryCtx. The errors stopping the evaluation are returned as they are.
+func (ruleSet *RuleSet) EnforceCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) error {
+	return ruleSet.enforce(ctx, queryOptions{}, subject, action, resource)
+}
+
+// enforce is like EnforceCtx, with the given options.
+func (ruleSet *RuleSet) enforce(ctx context.Context, opts queryOptions, subject interface{}, action interface{}, resource interface{}) error {
+	decision, err := ruleSet.evaluateWith(ctx, opts, subject, action, resource)
+	if err != nil {
+		return err
+	}
+	if ruleSet.allows(decision.Effect) {
+		return nil
+	}
+	format := ruleSet.FormatValue
+	if format == nil {
+		format = KeyOf
+	}
+	return &PermissionDeniedError{
+		Subject:  format(subject),
+		Action:   format(action),
+		Resource: format(resource),
+		Effect:   decision.Effect,
+		Rule:     decision.Rule,
+		Reason:   decision.Reason,
+	}
+}
+
+// allows reports whether effect is one of the AllowedEffects.
+func (ruleSet *RuleSet) allows(effect Effect) bool {
+	if len(ruleSet.AllowedEffects) == 0 {
+		return effect == Allow
+	}
+	for _, allowed := range ruleSet.AllowedEffects {
+		if effect == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestEnforce(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "modify", &Video{}, effectMatcher(DENY), Named("read-only videos"))
+	rs.AddRule(&User{}, "share", &Video{}, effectMatcher("audit"))
+	rs.FormatValue = func(value interface{}) string {
+		switch v := value.(type) {
+		case *User:
+			return "user " + v.Name
+		case *Video:
+			return "video " + v.Name
+		}
+		return fmt.Sprint(value)
+	}
+
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	if err := rs.Enforce(john, "view", video); err != nil {
+		t.Errorf("got %v want nil", err)
+	}
+
+	err := rs.Enforce(john, "modify", video)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("got %v want ErrPermissionDenied", err)
+	}
+	var denied *PermissionDeniedError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &denied) {
+		t.Fatalf("got %T want a *PermissionDeniedError", err)
+	}
+	if denied.Effect != DENY || denied.Rule == nil || denied.Rule.ID != "rule-2" || denied.Subject != "user john" {
+		t.Errorf("got %+v want the rule-2 denial of john", denied)
+	}
+	want := `perms: permission denied to user john for modify on video intro: effect "deny" by rule rule-2 (read-only videos)`
+	if err.Error() != want {
+		t.Errorf("got %q want %q", err.Error(), want)
+	}
+	want = `perms: permission denied to user john for delete on video intro: effect "deny"`
+	if err := rs.Enforce(john, "delete", video); err == nil || err.Error() != want {
+		t.Errorf("got %v want %q", err, want)
+	}
+
+	// the allowed effects are configurable
+	if err := rs.Enforce(john, "share", video); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("got %v want ErrPermissionDenied", err)
+	}
+	rs.AllowedEffects = []Effect{ALLOW, "audit"}
+	if err := rs.Enforce(john, "share", video); err != nil {
+		t.Errorf("got %v want nil", err)
+	}
+
+	// the evaluation errors are not denials
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rs.EnforceCtx(ctx, john, "view", video); err != context.Canceled {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+}
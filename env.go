@@ -0,0 +1,58 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// EnvMatcherFn is like MatcherCtxFn, but also receives the attributes of the
+// environment of the query, eg. the client IP or whether the user authenticated with
+// MFA, see QueryEnv. env is nil for the queries without an environment, and must not
+// be modified.
+type EnvMatcherFn func(ctx context.Context, subject interface{}, action interface{}, resource interface{}, env map[string]interface{}) (matches bool, effect string, quick bool, err error)
+
+// AddRuleEnv is like AddRuleCtx, but takes a matcher receiving the environment of the
+// query. The matchers of the other rules don't see it, while the conditions of the
+// declarative rules can refer to its attributes as env.ip, env.mfa etc.
+func (ruleSet *RuleSet) AddRuleEnv(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher EnvMatcherFn, options ...RuleOption) RuleID {
+	return ruleSet.AddRuleCtx(subjectType, actionType, resourceType, func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		return matcher(ctx, subject, action, resource, envFrom(ctx))
+	}, options...)
+}
+
+// WithEnv makes the query pass env, the attributes of the environment of the query, to
+// the matchers of AddRuleEnv and to the conditions of the declarative rules. The
+// library never modifies env.
+func WithEnv(env map[string]interface{}) QueryOption {
+	return func(opts *queryOptions) {
+		opts.env = env
+	}
+}
+
+// QueryEnv is like Query, with the attributes of the environment of the query, such as
+// the request context which is not part of the subject, see WithEnv:
+//
+//	rs.QueryEnv(user, "modify", video, map[string]interface{}{"ip": ip, "mfa": true})
+func (ruleSet *RuleSet) QueryEnv(subject interface{}, action interface{}, resource interface{}, env map[string]interface{}) string {
+	return ruleSet.QueryOpt(subject, action, resource, WithEnv(env)).Effect
+}
+
+// envKey is the context key of the environment of a query.
+type envKey struct{}
+
+func withEnv(ctx context.Context, env map[string]interface{}) context.Context {
+	return context.WithValue(ctx, envKey{}, env)
+}
+
+// envFrom returns the environment of the query of ctx, nil if none.
+func envFrom(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	env, _ := ctx.Value(envKey{}).(map[string]interface{})
+	return env
+}
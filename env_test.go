@@ -0,0 +1,91 @@
+package perms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestQueryEnv(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.WithCache(100, nil)
+	rs.AddRuleEnv(&User{}, "modify", &Video{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}, env map[string]interface{}) (bool, string, bool, error) {
+		if env["mfa"] == true && res.(*Video).User == subj.(*User).Name {
+			return true, ALLOW, false, nil
+		}
+		return false, "", false, nil
+	})
+	// the rules of the old API keep working, without seeing the environment
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	john := &User{Name: "john"}
+	video := &Video{Name: "holidays", User: "john"}
+
+	env := map[string]interface{}{"mfa": true, "ip": "10.0.0.1"}
+	if got := rs.QueryEnv(john, "modify", video, env); got != ALLOW {
+		t.Errorf("got %q want %q with mfa", got, ALLOW)
+	}
+	if got := rs.QueryEnv(john, "modify", video, map[string]interface{}{"mfa": false}); got != DENY {
+		t.Errorf("got %q want %q without mfa", got, DENY)
+	}
+	if got := rs.QueryEnv(john, "modify", video, nil); got != DENY {
+		t.Errorf("got %q want %q without an environment", got, DENY)
+	}
+	if got := rs.Query(john, "modify", video); got != DENY {
+		t.Errorf("got %q want %q from Query", got, DENY)
+	}
+	// the decision with the environment is not cached
+	if got := rs.QueryEnv(john, "modify", video, env); got != ALLOW {
+		t.Errorf("got %q want %q with mfa after Query", got, ALLOW)
+	}
+	if got := rs.QueryEnv(john, "view", video, env); got != ALLOW {
+		t.Errorf("got %q want %q from the old rule", got, ALLOW)
+	}
+	if want := map[string]interface{}{"mfa": true, "ip": "10.0.0.1"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got %v want the environment unchanged", env)
+	}
+}
+
+func TestQueryEnvDeclarative(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Video", &Video{})
+	for _, decl := range []DeclarativeRule{
+		{Subject: "User", Action: "modify", Resource: "Video", Condition: `env.mfa == true && resource.User == subject.Name`, Effect: ALLOW},
+		{Subject: "User", Action: "delete", Resource: "Video", Conditions: []Condition{{Field: "env.ip", Value: "10.0.0.1"}}, Effect: ALLOW},
+	} {
+		if _, err := rs.AddDeclarativeRule(decl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	john := &User{Name: "john"}
+	video := &Video{Name: "holidays", User: "john"}
+
+	for _, tc := range []struct {
+		action string
+		env    map[string]interface{}
+		effect string
+	}{
+		{"modify", map[string]interface{}{"mfa": true}, ALLOW},
+		{"modify", map[string]interface{}{"mfa": false}, DENY},
+		{"modify", map[string]interface{}{}, DENY},
+		{"modify", nil, DENY},
+		{"delete", map[string]interface{}{"ip": "10.0.0.1"}, ALLOW},
+		{"delete", map[string]interface{}{"ip": "192.168.1.1"}, DENY},
+		{"delete", nil, DENY},
+	} {
+		if got := rs.QueryEnv(john, tc.action, video, tc.env); got != tc.effect {
+			t.Errorf("%s %v: got %q want %q", tc.action, tc.env, got, tc.effect)
+		}
+	}
+
+	expr, err := CompileExpression(`startsWith(env.ip, "10.") && !env.mfa`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := expr.EvalEnv(nil, nil, nil, map[string]interface{}{"ip": "10.1.2.3", "mfa": false}); !ok || err != nil {
+		t.Errorf("got %v, %v want true", ok, err)
+	}
+	if _, err := ParseCondition("env.mfa == true"); err != nil {
+		t.Errorf("got %v parsing a condition on the environment", err)
+	}
+}
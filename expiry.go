@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"time"
+)
+
+// AddRuleWithExpiry is like AddRule, but the rule is only evaluated between notBefore
+// and notAfter, inclusive, according to the RuleSet Now clock. A zero notBefore or
+// notAfter leaves the validity unbounded on that side.
+// Note that the decisions cached with WithCache are not invalidated when a rule
+// becomes valid or expires.
+func (ruleSet *RuleSet) AddRuleWithExpiry(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, notBefore time.Time, notAfter time.Time, options ...RuleOption) RuleID {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	rule.notBefore = notBefore
+	rule.notAfter = notAfter
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+// PruneExpired removes the rules whose validity ended, including those of the
+// namespaces, returning how many were removed.
+func (ruleSet *RuleSet) PruneExpired() int {
+	now := ruleSet.now()
+	removed := 0
+	prune := func(w *tableWriter) {
+		for _, rule := range w.table.allRules() {
+			if !rule.notAfter.IsZero() && now.After(rule.notAfter) {
+				w.remove(rule)
+				removed++
+			}
+		}
+	}
+	ruleSet.update(prune)
+	for _, name := range ruleSet.Namespaces() {
+		ruleSet.updateIn(name, prune)
+	}
+	return removed
+}
+
+func (ruleSet *RuleSet) now() time.Time {
+	if ruleSet.Now != nil {
+		return ruleSet.Now()
+	}
+	return time.Now()
+}
+
+// expires reports whether the validity of the rule is limited.
+func (rule *Rule) expires() bool {
+	return !rule.notBefore.IsZero() || !rule.notAfter.IsZero()
+}
+
+// validAt reports whether the rule is valid at time t.
+func (rule *Rule) validAt(t time.Time) bool {
+	if !rule.notBefore.IsZero() && t.Before(rule.notBefore) {
+		return false
+	}
+	if !rule.notAfter.IsZero() && t.After(rule.notAfter) {
+		return false
+	}
+	return true
+}
+
+// now returns the time of the evaluation, reading the clock on first use.
+func (ev *evaluation) now() time.Time {
+	if ev.time.IsZero() {
+		ev.time = ev.ruleSet.now()
+	}
+	return ev.time
+}
@@ -0,0 +1,48 @@
+package perms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddRuleWithExpiry(t *testing.T) {
+	start := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	now := start.Add(-time.Hour)
+
+	rs := NewRuleSet(DENY)
+	rs.Now = func() time.Time { return now }
+	rs.AddRuleWithExpiry(&User{}, "view", &Video{}, effectMatcher(ALLOW), start, end)
+	// unbounded on either side
+	rs.AddRuleWithExpiry(&User{}, "edit", &Video{}, effectMatcher(ALLOW), time.Time{}, end)
+	rs.AddRuleWithExpiry(&User{}, "share", &Video{}, effectMatcher(ALLOW), start, time.Time{})
+
+	contractor := &User{Name: "contractor"}
+	check := func(when string, want map[string]string) {
+		t.Helper()
+		for action, effect := range want {
+			if got := rs.Query(contractor, action, &Video{}); got != effect {
+				t.Errorf("%s: %s: got %q want %q", when, action, got, effect)
+			}
+		}
+	}
+
+	check("not yet valid", map[string]string{"view": DENY, "edit": ALLOW, "share": DENY})
+	now = start
+	check("start", map[string]string{"view": ALLOW, "edit": ALLOW, "share": ALLOW})
+	now = end
+	check("end", map[string]string{"view": ALLOW, "edit": ALLOW, "share": ALLOW})
+	now = end.Add(time.Second)
+	check("expired", map[string]string{"view": DENY, "edit": DENY, "share": ALLOW})
+
+	if n := rs.PruneExpired(); n != 2 {
+		t.Errorf("got %d pruned rules want 2", n)
+	}
+	if n := len(rs.byID); n != 1 {
+		t.Errorf("got %d rules want 1", n)
+	}
+	if n := rs.PruneExpired(); n != 0 {
+		t.Errorf("got %d pruned rules want 0", n)
+	}
+	check("pruned", map[string]string{"view": DENY, "edit": DENY, "share": ALLOW})
+}
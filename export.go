@@ -0,0 +1,508 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is the version of the format written by ExportSnapshot.
+const snapshotVersion = 1
+
+// snapshotMagic starts the documents written by ExportSnapshot, before the version.
+const snapshotMagic = "go-perms snapshot\n"
+
+// ErrSnapshotVersion is returned by ImportSnapshot for a document of a version it
+// can't read, written by a later release.
+var ErrSnapshotVersion = errors.New("perms: unsupported snapshot version")
+
+// MatcherRegistry names the matchers of the rules, and the types of their templates,
+// so that ImportSnapshot can restore the rules exported by ExportSnapshot.
+type MatcherRegistry struct {
+	// Types holds the names of the types of the templates and of the declarative
+	// rules, see RuleSet.RegisterType.
+	Types    *TypeRegistry
+	matchers map[string]registeredMatcher
+}
+
+// registeredMatcher is a named matcher, of one of the kinds of AddRuleCtx,
+// AddRuleWithObligations, AddRuleWithReason and AddFieldRule.
+type registeredMatcher struct {
+	matcher     MatcherCtxFn
+	obligations ObligationMatcherFn
+	reason      ReasonMatcherFn
+	fields      FieldMatcherFn
+}
+
+// NewMatcherRegistry returns a registry without matchers, with the given types, or
+// with a new TypeRegistry if nil.
+func NewMatcherRegistry(types *TypeRegistry) *MatcherRegistry {
+	if types == nil {
+		types = NewTypeRegistry()
+	}
+	return &MatcherRegistry{Types: types, matchers: make(map[string]registeredMatcher)}
+}
+
+// Register registers matcher under name, replacing the matcher with the same name.
+func (registry *MatcherRegistry) Register(name string, matcher MatcherFn) {
+	registry.RegisterCtx(name, matcher.ErrFn().CtxFn())
+}
+
+// RegisterCtx is like Register, for a matcher receiving the query context.
+func (registry *MatcherRegistry) RegisterCtx(name string, matcher MatcherCtxFn) {
+	registry.matchers[name] = registeredMatcher{matcher: matcher}
+}
+
+// RegisterObligations is like Register, for the matchers of AddRuleWithObligations.
+func (registry *MatcherRegistry) RegisterObligations(name string, matcher ObligationMatcherFn) {
+	registry.matchers[name] = registeredMatcher{obligations: matcher}
+}
+
+// RegisterReason is like Register, for the matchers of AddRuleWithReason.
+func (registry *MatcherRegistry) RegisterReason(name string, matcher ReasonMatcherFn) {
+	registry.matchers[name] = registeredMatcher{reason: matcher}
+}
+
+// RegisterFields is like Register, for the matchers of AddFieldRule.
+func (registry *MatcherRegistry) RegisterFields(name string, matcher FieldMatcherFn) {
+	registry.matchers[name] = registeredMatcher{fields: matcher}
+}
+
+// MatcherName records that the matcher of the rule is registered under name in the
+// MatcherRegistry used to import the snapshots of the rule set, see ExportSnapshot.
+func MatcherName(name string) RuleOption {
+	return func(rule *Rule) {
+		rule.matcherName = name
+	}
+}
+
+// The kinds of the templates of a snapshotRule.
+const (
+	templateJolly = iota
+	// templateString is a string, matching literally or, for the rules added with
+	// AddGlobRule and AddRegexpRule, as a glob pattern or a regular expression
+	templateString
+	templateGlob
+	templateRegexp
+	// templateZero is the zero value of a named type, templateNew a pointer to it
+	templateZero
+	templateNew
+)
+
+// snapshotHeader is the part of a snapshot preceding the rules.
+type snapshotHeader struct {
+	Options      snapshotOptions
+	LastID       uint64
+	Aliases      [][2]string
+	Implications [][2]string
+	Defaults     [][2]string
+	Rules        int
+}
+
+// snapshotOptions are the settings of a RuleSet exported by ExportSnapshot.
+type snapshotOptions struct {
+	DefaultEffect        Effect
+	Combining            CombiningStrategy
+	PerPassQuick         bool
+	FlatEvaluation       bool
+	GrantsAfterRules     bool
+	CanonicalActions     bool
+	StructFieldTemplates bool
+	DeepValueMatch       bool
+	NormalizePointers    bool
+	MaxParentDepth       int
+	StrictExpressions    bool
+	DisableRuleStats     bool
+	MaxEvaluations       int
+	SuperuserEffect      Effect
+	RevokedEffect        Effect
+	AllowedEffects       []Effect
+}
+
+// snapshotRule is a rule in a snapshot.
+type snapshotRule struct {
+	ID          RuleID
+	Namespace   string
+	Kinds       [3]uint8
+	Templates   [3]string
+	Matcher     string
+	Declarative *DeclarativeRule
+	Priority    int
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Disabled    bool
+	Name        string
+	Description string
+	Tags        []string
+}
+
+// ExportSnapshot writes the rules of the rule set, including those of the namespaces,
+// its action aliases and implications, the default effects of the types, and the
+// settings that are not functions nor interfaces, like DefaultEffect and Combining, as
+// a compact binary document that ImportSnapshot restores, eg. to warm a new replica
+// quickly. The grants, the revocations, the roles and the counters are not exported.
+//
+// The templates of the rules must be nil, strings, or the zero values of the types
+// registered with RegisterType (or pointers to them, like &User{}), and the rules but
+// the declarative ones must have a matcher named with MatcherName; otherwise
+// ExportSnapshot returns an error, before writing anything.
+func (ruleSet *RuleSet) ExportSnapshot(w io.Writer) error {
+	table := ruleSet.current()
+	header := snapshotHeader{Options: ruleSet.snapshotOptions()}
+	ruleSet.rules.mu.Lock()
+	header.LastID = ruleSet.lastID
+	ruleSet.rules.mu.Unlock()
+	if err := ruleSet.exportActions(table.actions, &header); err != nil {
+		return err
+	}
+	for t, effect := range table.defaults {
+		name, ok := ruleSet.types.Name(t)
+		if !ok {
+			return fmt.Errorf("perms: default effect of the unregistered type %v", t)
+		}
+		header.Defaults = append(header.Defaults, [2]string{name, effect})
+	}
+	sort.Slice(header.Defaults, func(i, j int) bool {
+		return header.Defaults[i][0] < header.Defaults[j][0]
+	})
+
+	rules := table.allRules()
+	for _, name := range sortedNamespaces(table) {
+		rules = append(rules, table.namespaces[name].allRules()...)
+	}
+	exported := make([]snapshotRule, len(rules))
+	for i, rule := range rules {
+		if err := ruleSet.exportRule(rule, &exported[i]); err != nil {
+			return err
+		}
+	}
+	header.Rules = len(exported)
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(snapshotVersion); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(bw)
+	if err := enc.Encode(&header); err != nil {
+		return err
+	}
+	for i := range exported {
+		if err := enc.Encode(&exported[i]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func sortedNamespaces(table *ruleTable) []string {
+	names := make([]string, 0, len(table.namespaces))
+	for name := range table.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ruleSet *RuleSet) snapshotOptions() snapshotOptions {
+	return snapshotOptions{
+		DefaultEffect:        ruleSet.DefaultEffect,
+		Combining:            ruleSet.Combining,
+		PerPassQuick:         ruleSet.PerPassQuick,
+		FlatEvaluation:       ruleSet.FlatEvaluation,
+		GrantsAfterRules:     ruleSet.GrantsAfterRules,
+		CanonicalActions:     ruleSet.CanonicalActions,
+		StructFieldTemplates: ruleSet.StructFieldTemplates,
+		DeepValueMatch:       ruleSet.DeepValueMatch,
+		NormalizePointers:    ruleSet.NormalizePointers,
+		MaxParentDepth:       ruleSet.MaxParentDepth,
+		StrictExpressions:    ruleSet.StrictExpressions,
+		DisableRuleStats:     ruleSet.DisableRuleStats,
+		MaxEvaluations:       ruleSet.MaxEvaluations,
+		SuperuserEffect:      ruleSet.SuperuserEffect,
+		RevokedEffect:        ruleSet.RevokedEffect,
+		AllowedEffects:       ruleSet.AllowedEffects,
+	}
+}
+
+func (opts snapshotOptions) apply(ruleSet *RuleSet) {
+	ruleSet.DefaultEffect = opts.DefaultEffect
+	ruleSet.Combining = opts.Combining
+	ruleSet.PerPassQuick = opts.PerPassQuick
+	ruleSet.FlatEvaluation = opts.FlatEvaluation
+	ruleSet.GrantsAfterRules = opts.GrantsAfterRules
+	ruleSet.CanonicalActions = opts.CanonicalActions
+	ruleSet.StructFieldTemplates = opts.StructFieldTemplates
+	ruleSet.DeepValueMatch = opts.DeepValueMatch
+	ruleSet.NormalizePointers = opts.NormalizePointers
+	ruleSet.MaxParentDepth = opts.MaxParentDepth
+	ruleSet.StrictExpressions = opts.StrictExpressions
+	ruleSet.DisableRuleStats = opts.DisableRuleStats
+	ruleSet.MaxEvaluations = opts.MaxEvaluations
+	ruleSet.SuperuserEffect = opts.SuperuserEffect
+	ruleSet.RevokedEffect = opts.RevokedEffect
+	ruleSet.AllowedEffects = opts.AllowedEffects
+}
+
+// exportActions adds the string aliases and implications of graph to header, in an
+// order restoring them as they are.
+func (ruleSet *RuleSet) exportActions(graph *actionGraph, header *snapshotHeader) error {
+	if graph == nil {
+		return nil
+	}
+	for alias, canonical := range graph.aliases {
+		a, ok1 := alias.(string)
+		c, ok2 := canonical.(string)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("perms: alias %v of %v not a string", alias, canonical)
+		}
+		header.Aliases = append(header.Aliases, [2]string{a, c})
+	}
+	sort.Slice(header.Aliases, func(i, j int) bool {
+		return header.Aliases[i][0] < header.Aliases[j][0]
+	})
+	weakers := make([]string, 0, len(graph.impliers))
+	for weaker := range graph.impliers {
+		w, ok := weaker.(string)
+		if !ok {
+			return fmt.Errorf("perms: implied action %v not a string", weaker)
+		}
+		weakers = append(weakers, w)
+	}
+	sort.Strings(weakers)
+	for _, weaker := range weakers {
+		// the implying actions are evaluated in the order of the ImplyAction calls
+		for _, stronger := range graph.impliers[weaker] {
+			s, ok := stronger.(string)
+			if !ok {
+				return fmt.Errorf("perms: action %v implying %q not a string", stronger, weaker)
+			}
+			header.Implications = append(header.Implications, [2]string{s, weaker})
+		}
+	}
+	return nil
+}
+
+func (ruleSet *RuleSet) exportRule(rule *Rule, exported *snapshotRule) error {
+	*exported = snapshotRule{
+		ID:          rule.id,
+		Namespace:   rule.namespace,
+		Matcher:     rule.matcherName,
+		Declarative: rule.decl,
+		Priority:    rule.priority,
+		NotBefore:   rule.notBefore,
+		NotAfter:    rule.notAfter,
+		Disabled:    rule.disabled,
+		Name:        rule.name,
+		Description: rule.description,
+		Tags:        rule.tags,
+	}
+	if rule.decl != nil {
+		return nil
+	}
+	if rule.matcher != nil && rule.matcherName == "" {
+		return fmt.Errorf("perms: rule %s: matcher without MatcherName", rule.id)
+	}
+	for position := range exported.Templates {
+		kind, value, err := ruleSet.exportTemplate(rule, position)
+		if err != nil {
+			return fmt.Errorf("perms: rule %s: %v", rule.id, err)
+		}
+		exported.Kinds[position], exported.Templates[position] = kind, value
+	}
+	return nil
+}
+
+func (ruleSet *RuleSet) exportTemplate(rule *Rule, position int) (uint8, string, error) {
+	template := rule.template(position)
+	if template == nil {
+		return templateJolly, "", nil
+	}
+	if s, ok := template.(string); ok {
+		switch rule.patterns[position].(type) {
+		case *globTemplate:
+			return templateGlob, s, nil
+		case *regexpTemplate:
+			return templateRegexp, s, nil
+		}
+		return templateString, s, nil
+	}
+	t := reflect.TypeOf(template)
+	name, ok := ruleSet.types.Name(t)
+	if !ok {
+		return 0, "", fmt.Errorf("template of the unregistered type %v", t)
+	}
+	v := reflect.ValueOf(template)
+	if t.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().IsZero() {
+		return templateNew, name, nil
+	}
+	if v.IsZero() {
+		return templateZero, name, nil
+	}
+	return 0, "", fmt.Errorf("template %v not the zero value of its type", template)
+}
+
+// ImportSnapshot returns a new rule set, with the rules and the settings written by
+// ExportSnapshot. The types of the templates and of the declarative rules are those
+// of registry, which become those of the rule set, and the matchers are those
+// registered under the names of the rules: ImportSnapshot returns an error if a name
+// or a type is not registered. It returns ErrSnapshotVersion for the documents
+// written by later releases.
+func ImportSnapshot(r io.Reader, registry *MatcherRegistry) (*RuleSet, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("perms: not a snapshot")
+	}
+	if magic[len(snapshotMagic)] != snapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+	dec := gob.NewDecoder(br)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("perms: %v", err)
+	}
+	ruleSet := NewRuleSet(header.Options.DefaultEffect)
+	header.Options.apply(ruleSet)
+	for name, t := range registry.Types.byName {
+		ruleSet.types.byName[name] = t
+		ruleSet.types.names[t] = name
+	}
+	for _, alias := range header.Aliases {
+		if err := ruleSet.AliasAction(alias[0], alias[1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, implication := range header.Implications {
+		if err := ruleSet.ImplyAction(implication[0], implication[1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, def := range header.Defaults {
+		t, ok := ruleSet.types.Lookup(def[0])
+		if !ok {
+			return nil, fmt.Errorf("perms: unknown type %q", def[0])
+		}
+		ruleSet.SetDefaultEffectForType(reflect.Zero(t).Interface(), def[1])
+	}
+
+	byNamespace := make(map[string][]*Rule)
+	var namespaces []string
+	for i := 0; i < header.Rules; i++ {
+		var imported snapshotRule
+		if err := dec.Decode(&imported); err != nil {
+			return nil, fmt.Errorf("perms: %v", err)
+		}
+		rule, err := ruleSet.importRule(&imported, registry)
+		if err != nil {
+			return nil, fmt.Errorf("perms: rule %s: %v", imported.ID, err)
+		}
+		if _, ok := byNamespace[rule.namespace]; !ok {
+			namespaces = append(namespaces, rule.namespace)
+		}
+		byNamespace[rule.namespace] = append(byNamespace[rule.namespace], rule)
+	}
+	for _, namespace := range namespaces {
+		if err := ruleSet.addRulesIn(namespace, byNamespace[namespace]...); err != nil {
+			return nil, err
+		}
+	}
+	ruleSet.rules.mu.Lock()
+	if header.LastID > ruleSet.lastID {
+		ruleSet.lastID = header.LastID
+	}
+	ruleSet.rules.mu.Unlock()
+	return ruleSet, nil
+}
+
+func (ruleSet *RuleSet) importRule(imported *snapshotRule, registry *MatcherRegistry) (*Rule, error) {
+	var rule *Rule
+	if imported.Declarative != nil {
+		var err error
+		if rule, err = ruleSet.newDeclarativeRule(*imported.Declarative); err != nil {
+			return nil, err
+		}
+	} else {
+		var templates [3]interface{}
+		for position := range templates {
+			template, err := ruleSet.importTemplate(imported.Kinds[position], imported.Templates[position])
+			if err != nil {
+				return nil, err
+			}
+			templates[position] = template
+		}
+		registered, ok := registry.matchers[imported.Matcher]
+		if !ok && imported.Matcher != "" {
+			return nil, fmt.Errorf("unknown matcher %q", imported.Matcher)
+		}
+		switch {
+		case registered.obligations != nil:
+			rule = newObligationRule(templates[0], templates[1], templates[2], registered.obligations)
+		case registered.reason != nil:
+			rule = newReasonRule(templates[0], templates[1], templates[2], registered.reason)
+		case registered.fields != nil:
+			rule = newFieldRule(templates[0], templates[1], templates[2], registered.fields)
+		default:
+			rule = newRule(templates[0], templates[1], templates[2], registered.matcher)
+		}
+		for position := range templates {
+			var err error
+			switch pattern := imported.Templates[position]; imported.Kinds[position] {
+			case templateGlob:
+				rule.patterns[position], err = compileGlob(pattern)
+			case templateRegexp:
+				var re *regexp.Regexp
+				if re, err = compileFullMatch(pattern); err == nil {
+					rule.patterns[position] = &regexpTemplate{re: re}
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	rule.id = imported.ID
+	rule.namespace = imported.Namespace
+	rule.matcherName = imported.Matcher
+	rule.priority = imported.Priority
+	rule.notBefore, rule.notAfter = imported.NotBefore, imported.NotAfter
+	rule.disabled = imported.Disabled
+	rule.name, rule.description = imported.Name, imported.Description
+	rule.tags = imported.Tags[:len(imported.Tags):len(imported.Tags)]
+	return rule, nil
+}
+
+func (ruleSet *RuleSet) importTemplate(kind uint8, value string) (interface{}, error) {
+	switch kind {
+	case templateJolly:
+		return nil, nil
+	case templateString, templateGlob, templateRegexp:
+		return value, nil
+	case templateZero, templateNew:
+		t, ok := ruleSet.types.Lookup(value)
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", value)
+		}
+		if kind == templateNew {
+			if t.Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("type %q not a pointer", value)
+			}
+			return reflect.New(t.Elem()).Interface(), nil
+		}
+		return reflect.Zero(t).Interface(), nil
+	}
+	return nil, fmt.Errorf("unknown template kind %d", kind)
+}
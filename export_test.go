@@ -0,0 +1,240 @@
+package perms
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSnapshotRegistry returns the registry of the matchers of newSnapshotRuleSet.
+func newSnapshotRegistry(types *TypeRegistry) *MatcherRegistry {
+	registry := NewMatcherRegistry(types)
+	registry.Register("allow", effectMatcher(ALLOW))
+	registry.Register("quick-deny", quickMatcher(DENY))
+	registry.Register("video-owner", func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res.(*Video).User == subj.(*User).Name, ALLOW, false
+	})
+	registry.RegisterObligations("logged", func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, []Obligation, error) {
+		return true, ALLOW, false, []Obligation{{Name: "log", Value: "archive"}}, nil
+	})
+	return registry
+}
+
+// newSnapshotRuleSet returns a rule set with rules of every kind ExportSnapshot
+// supports, added with the matchers of registry.
+func newSnapshotRuleSet(t *testing.T, registry *MatcherRegistry) *RuleSet {
+	t.Helper()
+	rs := newPolicyRuleSet()
+	rs.RegisterType("Archive", &Archive{})
+	rs.Combining = DenyOverrides
+	rs.NormalizePointers = true
+	rs.AllowedEffects = []Effect{ALLOW, "audit"}
+	if err := rs.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	matcher := func(name string) MatcherFn {
+		m := registry.matchers[name].matcher
+		return func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			matches, effect, quick, _ := m(context.Background(), subj, act, res)
+			return matches, effect, quick
+		}
+	}
+	rs.AddRule(&User{}, "view", &Video{}, matcher("video-owner"), MatcherName("video-owner"), Named("owner"), Tagged("video", "owner"))
+	rs.AddRuleWithPriority(5, &Group{}, nil, &Video{}, matcher("quick-deny"), MatcherName("quick-deny"), Described("no groups"))
+	if _, err := rs.AddGlobRule(&User{}, "mod*", &Video{}, matcher("allow"), MatcherName("allow")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.AddRegexpRule(&User{}, "del(ete)?", nil, matcher("allow"), MatcherName("allow")); err != nil {
+		t.Fatal(err)
+	}
+	rs.AddRuleWithObligations(&User{}, "view", &Archive{}, registry.matchers["logged"].obligations, MatcherName("logged"))
+	rs.AddRuleWithExpiry(&User{}, "share", nil, matcher("allow"), time.Time{}, time.Now().Add(-time.Hour), MatcherName("allow"))
+	disabled := rs.AddRule(nil, nil, nil, matcher("quick-deny"), MatcherName("quick-deny"))
+	if err := rs.DisableRule(disabled); err != nil {
+		t.Fatal(err)
+	}
+	rs.Namespace("tenant").AddRule(&User{}, "view", nil, matcher("allow"), MatcherName("allow"))
+	if err := rs.AliasAction("watch", "view"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.ImplyAction("modify", "view"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.ImplyAction("delete", "modify"); err != nil {
+		t.Fatal(err)
+	}
+	rs.SetDefaultEffectForType(&Archive{}, "archived")
+	return rs
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	types := NewTypeRegistry()
+	registry := newSnapshotRegistry(types)
+	rs := newSnapshotRuleSet(t, registry)
+	var buf bytes.Buffer
+	if err := rs.ExportSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for name, t := range rs.types.byName {
+		types.byName[name] = t
+		types.names[t] = name
+	}
+	imported, err := ImportSnapshot(&buf, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := imported.Rules(), rs.Rules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules\n%+v\nwant\n%+v", got, want)
+	}
+	if got, want := imported.Namespace("tenant").Rules(), rs.Namespace("tenant").Rules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got namespace rules %+v want %+v", got, want)
+	}
+	if !reflect.DeepEqual(imported.snapshotOptions(), rs.snapshotOptions()) {
+		t.Errorf("got options %+v want %+v", imported.snapshotOptions(), rs.snapshotOptions())
+	}
+	if got, want := imported.ActionAliases(), rs.ActionAliases(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got aliases %v want %v", got, want)
+	}
+	if effect, ok := imported.DefaultEffectForType(&Archive{}); !ok || effect != "archived" {
+		t.Errorf("got default effect %q, %v want archived", effect, ok)
+	}
+	checkSameDecisions(t, imported, rs)
+	for _, action := range []interface{}{"watch", "share", "mod", "del"} {
+		for _, resource := range videoResources {
+			john := &User{Name: "john"}
+			if got, want := imported.QueryExplain(john, action, resource), rs.QueryExplain(john, action, resource); !reflect.DeepEqual(got, want) {
+				t.Errorf("(%v, %v): got %+v want %+v", action, resource, got, want)
+			}
+		}
+	}
+
+	// the ids generated after the import are new
+	id := imported.AddRule(nil, "print", nil, effectMatcher(ALLOW))
+	if id != rs.AddRule(nil, "print", nil, effectMatcher(ALLOW)) {
+		t.Errorf("got id %s generated differently", id)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, nil, nil, effectMatcher(ALLOW))
+	var buf bytes.Buffer
+	if err := rs.ExportSnapshot(&buf); err == nil || !strings.Contains(err.Error(), "MatcherName") {
+		t.Errorf("got %v want a matcher without name", err)
+	}
+	rs = NewRuleSet(DENY)
+	rs.AddRule(&User{}, nil, nil, effectMatcher(ALLOW), MatcherName("allow"))
+	if err := rs.ExportSnapshot(&buf); err == nil || !strings.Contains(err.Error(), "unregistered type") {
+		t.Errorf("got %v want an unregistered type", err)
+	}
+	rs.RegisterType("User", &User{})
+	rs.AddRule(&User{Name: "john"}, nil, nil, effectMatcher(ALLOW), MatcherName("allow"))
+	if err := rs.ExportSnapshot(&buf); err == nil || !strings.Contains(err.Error(), "zero value") {
+		t.Errorf("got %v want a template not exported", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %d bytes written", buf.Len())
+	}
+
+	rs = NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.AddRule(&User{}, nil, nil, effectMatcher(ALLOW), MatcherName("allow"))
+	if err := rs.ExportSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+	registry := NewMatcherRegistry(rs.Types())
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), registry); err == nil || !strings.Contains(err.Error(), `unknown matcher "allow"`) {
+		t.Errorf("got %v want an unknown matcher", err)
+	}
+	registry.Register("allow", effectMatcher(ALLOW))
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), NewMatcherRegistry(nil)); err == nil || !strings.Contains(err.Error(), `unknown type "User"`) {
+		t.Errorf("got %v want an unknown type", err)
+	}
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), registry); err != nil {
+		t.Errorf("got %v", err)
+	}
+	later := append([]byte(nil), snapshot...)
+	later[len(snapshotMagic)]++
+	if _, err := ImportSnapshot(bytes.NewReader(later), registry); err != ErrSnapshotVersion {
+		t.Errorf("got %v want ErrSnapshotVersion", err)
+	}
+	if _, err := ImportSnapshot(strings.NewReader("rules: []"), registry); err == nil {
+		t.Errorf("got no error for a document not a snapshot")
+	}
+}
+
+// newLargeSnapshotRuleSet returns a rule set with n rules for string templates, a
+// tenth of them declarative.
+func newLargeSnapshotRuleSet(n int) *RuleSet {
+	rs := newPolicyRuleSet()
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			rs.AddDeclarativeRule(DeclarativeRule{
+				Subject: "User", Action: fmt.Sprintf("action-%d", i%100), Resource: "Playlist",
+				Conditions: []Condition{{Field: "resource.User", Ref: "subject.Name"}},
+				Effect:     ALLOW,
+			})
+			continue
+		}
+		rs.AddRule(&User{}, fmt.Sprintf("action-%d", i%100), fmt.Sprintf("doc-%d", i), effectMatcher(ALLOW), MatcherName("allow"), Tagged("generated"))
+	}
+	return rs
+}
+
+func TestSnapshotLarge(t *testing.T) {
+	const n = 50000
+	rs := newLargeSnapshotRuleSet(n)
+	var buf bytes.Buffer
+	if err := rs.ExportSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewMatcherRegistry(rs.Types())
+	registry.Register("allow", effectMatcher(ALLOW))
+	start := time.Now()
+	imported, err := ImportSnapshot(bytes.NewReader(buf.Bytes()), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relaxed, for the slow machines and the race detector
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("got %v importing %d rules", elapsed, n)
+	}
+	if got := imported.RuleCount(); got != n {
+		t.Errorf("got %d rules want %d", got, n)
+	}
+	if got, want := imported.Rules(), rs.Rules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got the rules changed by the round trip")
+	}
+	john := &User{Name: "john"}
+	for _, resource := range []interface{}{"doc-1", "doc-49999", &Playlist{User: "john"}, &Playlist{User: "jack"}} {
+		for _, action := range []string{"action-0", "action-1", "action-99"} {
+			if got, want := imported.Query(john, action, resource), rs.Query(john, action, resource); got != want {
+				t.Errorf("(%s, %v): got %q want %q", action, resource, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkImportSnapshot(b *testing.B) {
+	rs := newLargeSnapshotRuleSet(10000)
+	var buf bytes.Buffer
+	if err := rs.ExportSnapshot(&buf); err != nil {
+		b.Fatal(err)
+	}
+	registry := NewMatcherRegistry(rs.Types())
+	registry.Register("allow", effectMatcher(ALLOW))
+	b.SetBytes(int64(buf.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ImportSnapshot(bytes.NewReader(buf.Bytes()), registry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
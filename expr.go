@@ -0,0 +1,893 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expression is a compiled boolean condition over the subject, action, resource and
+// environment of a query, see CompileExpression.
+type Expression struct {
+	src  string
+	root exprNode
+}
+
+// ExpressionError reports an expression which cannot be compiled.
+type ExpressionError struct {
+	Expr string
+	// Column is the 1-based position of the offending character, in runes
+	Column int
+	Msg    string
+}
+
+func (err *ExpressionError) Error() string {
+	return fmt.Sprintf("invalid expression %q at column %d: %s", err.Expr, err.Column, err.Msg)
+}
+
+// CompileExpression compiles a condition expression. The variables subject, action and
+// resource stand for the queried values, env for the attributes of the environment of
+// the query (see QueryEnv), and their fields (or map keys) are accessed with ".", eg.:
+//
+//	resource.Duration < 600 && (resource.Public || resource.User == subject.Name)
+//	action in ["view", "list"] && !subject.Banned
+//	env.mfa == true && startsWith(env.ip, "10.")
+//
+// The language has null, true, false, numbers, "double" or 'single' quoted strings and
+// [lists]; the operators, from the lowest precedence, are ||, &&, the comparisons
+// (==, !=, <, <=, >, >=, in), + -, * / %, and the unary ! and -. Values can be
+// indexed with [], and the functions size(x), startsWith(s, prefix), endsWith(s,
+// suffix) and contains(s, substr) are available. Numbers are compared by value
+// regardless of their Go type, and a nil pointer or a missing map key is null.
+//
+// attr(x, name) is the attribute name of x, eg. attr(subject, "department"), fetched
+// by the AttributeProvider of the RuleSet (see RuleSet.Attributes) once per query.
+// subquery(subject, action, resource) is the effect of a sub-query of the query, as
+// with QueryContext.SubQuery, eg. subquery(subject, "admin", resource.Parent) ==
+// "allow".
+func CompileExpression(src string) (*Expression, error) {
+	p := &exprParser{src: src}
+	p.next()
+	root := p.parseOr()
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.fail(p.tok.pos, "unexpected %s", p.tok)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (expr *Expression) String() string {
+	return expr.src
+}
+
+// Eval evaluates the expression for a query. It fails if the expression has not a
+// boolean value or if an operation is applied to values of the wrong type.
+func (expr *Expression) Eval(subject interface{}, action interface{}, resource interface{}) (bool, error) {
+	return expr.EvalEnv(subject, action, resource, nil)
+}
+
+// EvalEnv is like Eval, with the attributes of the environment of the query, the
+// values of the variable env.
+func (expr *Expression) EvalEnv(subject interface{}, action interface{}, resource interface{}, env map[string]interface{}) (bool, error) {
+	return expr.eval(&exprScope{roots: [...]interface{}{subject, action, resource, env}})
+}
+
+func (expr *Expression) eval(scope *exprScope) (bool, error) {
+	value, err := expr.root.eval(scope)
+	if err != nil {
+		return false, fmt.Errorf("perms: evaluating %q: %w", expr.src, err)
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("perms: evaluating %q: got %s want a boolean", expr.src, exprTypeName(value))
+	}
+	return b, nil
+}
+
+// check verifies that the field paths of the expression can be resolved on values of
+// the given types (nil if unknown), see fieldPath.check.
+func (expr *Expression) check(rootTypes [4]reflect.Type) error {
+	var err error
+	walkExpr(expr.root, func(node exprNode) {
+		if path, ok := node.(*exprPath); ok && err == nil {
+			if checkErr := path.path.check(rootTypes[path.path.root]); checkErr != nil {
+				err = &ExpressionError{Expr: expr.src, Column: path.pos, Msg: checkErr.Error()}
+			}
+		}
+	})
+	return err
+}
+
+// exprScope holds the values of the variables of an evaluation.
+type exprScope struct {
+	roots [4]interface{}
+	// ctx and attrs fetch the attributes of the attr function, see queryAttributes
+	ctx   context.Context
+	attrs *queryAttributes
+}
+
+// exprNode is a node of a compiled expression. Values are nil, bool, float64 (for all
+// the numbers), string, []interface{} (for list literals) or the values found in the
+// queried values.
+type exprNode interface {
+	eval(scope *exprScope) (interface{}, error)
+	children() []exprNode
+}
+
+func walkExpr(node exprNode, fn func(node exprNode)) {
+	fn(node)
+	for _, child := range node.children() {
+		walkExpr(child, fn)
+	}
+}
+
+// usesRoot reports whether the expression refers to the variable root, eg. the
+// resource.
+func usesRoot(node exprNode, root int) bool {
+	uses := false
+	walkExpr(node, func(node exprNode) {
+		if path, ok := node.(*exprPath); ok && path.path.root == root {
+			uses = true
+		}
+	})
+	return uses
+}
+
+// usesQuery reports whether the expression calls the functions needing the query,
+// attr and subquery.
+func usesQuery(node exprNode) bool {
+	uses := false
+	walkExpr(node, func(node exprNode) {
+		switch node.(type) {
+		case *exprAttr, *exprSubquery:
+			uses = true
+		}
+	})
+	return uses
+}
+
+type exprLiteral struct {
+	value interface{}
+}
+
+func (node *exprLiteral) eval(scope *exprScope) (interface{}, error) {
+	return node.value, nil
+}
+
+func (node *exprLiteral) children() []exprNode { return nil }
+
+// exprPath is a variable followed by a chain of field names.
+type exprPath struct {
+	path fieldPath
+	pos  int
+}
+
+func (node *exprPath) eval(scope *exprScope) (interface{}, error) {
+	v, err := node.resolve(scope)
+	if err != nil {
+		return nil, err
+	}
+	return exprValue(v), nil
+}
+
+// resolve returns the value of the path.
+func (node *exprPath) resolve(scope *exprScope) (reflect.Value, error) {
+	v := reflect.ValueOf(scope.roots[node.path.root])
+	for _, name := range node.path.fields {
+		var err error
+		if v, err = exprField(v, name); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return v, nil
+}
+
+func (node *exprPath) children() []exprNode { return nil }
+
+// exprField returns the field (or map key) name of v, an invalid Value if v is nil or
+// the map has no such key.
+func exprField(v reflect.Value, name string) (reflect.Value, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return v, nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		field, ok := v.Type().FieldByName(name)
+		if !ok || field.PkgPath != "" {
+			return reflect.Value{}, fmt.Errorf("type %v has no exported field %q", v.Type(), name)
+		}
+		return v.FieldByIndex(field.Index), nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("type %v has no string keys for %q", v.Type(), name)
+		}
+		return v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())), nil
+	}
+	return reflect.Value{}, fmt.Errorf("type %v has no field %q", v.Type(), name)
+}
+
+// exprValue converts v to an expression value.
+func exprValue(v reflect.Value) interface{} {
+	v = indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+	if f, ok := asFloat(v); ok {
+		return f
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func exprTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	}
+	return fmt.Sprintf("a %T", value)
+}
+
+type exprList struct {
+	elems []exprNode
+}
+
+func (node *exprList) eval(scope *exprScope) (interface{}, error) {
+	values := make([]interface{}, len(node.elems))
+	for i, elem := range node.elems {
+		value, err := elem.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (node *exprList) children() []exprNode { return node.elems }
+
+type exprIndex struct {
+	x, index exprNode
+}
+
+func (node *exprIndex) eval(scope *exprScope) (interface{}, error) {
+	x, err := node.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	index, err := node.index.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	v := indirect(reflect.ValueOf(x))
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		f, ok := index.(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("got %s want an integer index", exprTypeName(index))
+		}
+		if f < 0 || int(f) >= v.Len() {
+			return nil, fmt.Errorf("index %v out of range", f)
+		}
+		return exprValue(v.Index(int(f))), nil
+	case reflect.Map, reflect.Struct:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("got %s want a string key", exprTypeName(index))
+		}
+		field, err := exprField(v, key)
+		if err != nil {
+			return nil, err
+		}
+		return exprValue(field), nil
+	}
+	return nil, fmt.Errorf("cannot index %s", exprTypeName(x))
+}
+
+func (node *exprIndex) children() []exprNode { return []exprNode{node.x, node.index} }
+
+type exprCall struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []exprNode
+}
+
+func (node *exprCall) eval(scope *exprScope) (interface{}, error) {
+	args := make([]interface{}, len(node.args))
+	for i, arg := range node.args {
+		value, err := arg.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return node.fn(args)
+}
+
+func (node *exprCall) children() []exprNode { return node.args }
+
+// exprAttr is a call of the attr function.
+type exprAttr struct {
+	entity, name exprNode
+}
+
+func (node *exprAttr) eval(scope *exprScope) (interface{}, error) {
+	entity, err := evalRaw(node.entity, scope)
+	if err != nil {
+		return nil, err
+	}
+	name, err := node.name.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := name.(string)
+	if !ok {
+		return nil, fmt.Errorf("got %s want a string attribute name", exprTypeName(name))
+	}
+	value, err := scope.attrs.get(scope.ctx, entity, s)
+	if err != nil {
+		return nil, err
+	}
+	return exprValue(reflect.ValueOf(value)), nil
+}
+
+func (node *exprAttr) children() []exprNode { return []exprNode{node.entity, node.name} }
+
+// exprSubquery is a call of the subquery function.
+type exprSubquery struct {
+	args [3]exprNode
+}
+
+func (node *exprSubquery) eval(scope *exprScope) (interface{}, error) {
+	var values [3]interface{}
+	for i, arg := range node.args {
+		var err error
+		if values[i], err = evalRaw(arg, scope); err != nil {
+			return nil, err
+		}
+	}
+	var frame *evaluationContext
+	if scope.ctx != nil {
+		frame = callerOf(scope.ctx)
+	}
+	if frame == nil {
+		return nil, ErrNotInQuery
+	}
+	decision, err := frame.subQuery(scope.ctx, values[0], values[1], values[2])
+	if err != nil {
+		return nil, err
+	}
+	return decision.Effect, nil
+}
+
+func (node *exprSubquery) children() []exprNode { return node.args[:] }
+
+// evalRaw evaluates node, returning the values of the paths as they are, not as
+// expression values, eg. the pointers, for the functions passing them to the rule
+// set as the values of a query.
+func evalRaw(node exprNode, scope *exprScope) (interface{}, error) {
+	path, ok := node.(*exprPath)
+	if !ok {
+		return node.eval(scope)
+	}
+	v, err := path.resolve(scope)
+	if err != nil || !v.IsValid() || !v.CanInterface() {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// exprQueryFunctions are the functions needing the query, by name and number of
+// arguments, see exprAttr and exprSubquery.
+var exprQueryFunctions = map[string]int{"attr": 2, "subquery": 3}
+
+// exprFunctions are the functions available to the expressions, by name and number of
+// arguments.
+var exprFunctions = map[string]struct {
+	arity int
+	fn    func(args []interface{}) (interface{}, error)
+}{
+	"size": {1, func(args []interface{}) (interface{}, error) {
+		if s, ok := args[0].(string); ok {
+			return float64(utf8.RuneCountInString(s)), nil
+		}
+		v := reflect.ValueOf(args[0])
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return float64(v.Len()), nil
+		}
+		return nil, fmt.Errorf("size of %s", exprTypeName(args[0]))
+	}},
+	"startsWith": {2, stringFunction(strings.HasPrefix)},
+	"endsWith":   {2, stringFunction(strings.HasSuffix)},
+	"contains":   {2, stringFunction(strings.Contains)},
+}
+
+func stringFunction(fn func(s, t string) bool) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		t, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("got %s and %s want strings", exprTypeName(args[0]), exprTypeName(args[1]))
+		}
+		return fn(s, t), nil
+	}
+}
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+func (node *exprUnary) eval(scope *exprScope) (interface{}, error) {
+	x, err := node.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch node.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		if f, ok := x.(float64); ok {
+			return -f, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined on %s", node.op, exprTypeName(x))
+}
+
+func (node *exprUnary) children() []exprNode { return []exprNode{node.x} }
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+func (node *exprBinary) eval(scope *exprScope) (interface{}, error) {
+	x, err := node.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if node.op == "&&" || node.op == "||" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.op, exprTypeName(x))
+		}
+		if b == (node.op == "||") {
+			return b, nil
+		}
+		y, err := node.y.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := y.(bool); !ok {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.op, exprTypeName(y))
+		}
+		return y, nil
+	}
+	y, err := node.y.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch node.op {
+	case "==":
+		return valuesEqual(reflect.ValueOf(x), reflect.ValueOf(y)), nil
+	case "!=":
+		return !valuesEqual(reflect.ValueOf(x), reflect.ValueOf(y)), nil
+	case "in":
+		return exprIn(x, y)
+	}
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			return numberOp(node.op, x, y)
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return stringOp(node.op, x, y)
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined on %s and %s", node.op, exprTypeName(x), exprTypeName(y))
+}
+
+func (node *exprBinary) children() []exprNode { return []exprNode{node.x, node.y} }
+
+func numberOp(op string, x float64, y float64) (interface{}, error) {
+	switch op {
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "%" {
+			return math.Mod(x, y), nil
+		}
+		return x / y, nil
+	}
+	return nil, fmt.Errorf("operator %s not defined on numbers", op)
+}
+
+func stringOp(op string, x string, y string) (interface{}, error) {
+	switch op {
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	case "+":
+		return x + y, nil
+	}
+	return nil, fmt.Errorf("operator %s not defined on strings", op)
+}
+
+// exprIn reports whether x is an element of the list (or a key of the map) y.
+func exprIn(x interface{}, y interface{}) (interface{}, error) {
+	v := indirect(reflect.ValueOf(y))
+	if v.IsValid() {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				if valuesEqual(reflect.ValueOf(x), v.Index(i)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case reflect.Map:
+			for _, key := range v.MapKeys() {
+				if valuesEqual(reflect.ValueOf(x), key) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	}
+	return nil, fmt.Errorf("operator in not defined on %s", exprTypeName(y))
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	// value of number and string tokens
+	value interface{}
+	// pos is the 1-based column of the token
+	pos int
+}
+
+func (tok exprToken) String() string {
+	if tok.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(tok.text)
+}
+
+// exprOperators are the operator tokens, longest first.
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."}
+
+// exprParser is a recursive descent parser of expressions. The first error is kept in
+// err, after which the parse functions return placeholder nodes.
+type exprParser struct {
+	src    string
+	offset int
+	tok    exprToken
+	err    *ExpressionError
+}
+
+func (p *exprParser) fail(pos int, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &ExpressionError{Expr: p.src, Column: pos, Msg: fmt.Sprintf(format, args...)}
+	}
+}
+
+func (p *exprParser) column(offset int) int {
+	return utf8.RuneCountInString(p.src[:offset]) + 1
+}
+
+// next scans the next token.
+func (p *exprParser) next() {
+	for p.offset < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.offset:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.offset += size
+	}
+	start := p.offset
+	pos := p.column(start)
+	if start >= len(p.src) {
+		p.tok = exprToken{kind: tokEOF, pos: pos}
+		return
+	}
+	rest := p.src[start:]
+	r, _ := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		p.tok = exprToken{kind: tokIdent, text: rest[:end], pos: pos}
+	case r >= '0' && r <= '9':
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != 'e' && r != 'E'
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		f, err := strconv.ParseFloat(rest[:end], 64)
+		if err != nil {
+			p.fail(pos, "bad number %s", rest[:end])
+		}
+		p.tok = exprToken{kind: tokNumber, text: rest[:end], value: f, pos: pos}
+	case r == '"' || r == '\'':
+		end := 1
+		for end < len(rest) && rest[end] != byte(r) {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			p.fail(pos, "unterminated string")
+			p.tok = exprToken{kind: tokEOF, pos: pos}
+			p.offset = len(p.src)
+			return
+		}
+		text := rest[:end+1]
+		quoted := text
+		if r == '\'' {
+			quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:end], `\'`, `'`), `"`, `\"`) + `"`
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			p.fail(pos, "bad string %s", text)
+		}
+		p.tok = exprToken{kind: tokString, text: text, value: value, pos: pos}
+	default:
+		p.tok = exprToken{kind: tokOp, pos: pos}
+		for _, op := range exprOperators {
+			if strings.HasPrefix(rest, op) {
+				p.tok.text = op
+				break
+			}
+		}
+		if p.tok.text == "" {
+			p.fail(pos, "unexpected character %q", r)
+			p.tok = exprToken{kind: tokEOF, pos: pos}
+			p.offset = len(p.src)
+			return
+		}
+	}
+	p.offset = start + len(p.tok.text)
+}
+
+// isOp reports whether the current token is one of the operators ("in" included).
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp && !(p.tok.kind == tokIdent && p.tok.text == "in") {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) {
+	if !p.isOp(op) {
+		p.fail(p.tok.pos, "got %s want %q", p.tok, op)
+		return
+	}
+	p.next()
+}
+
+func (p *exprParser) parseBinary(parseOperand func() exprNode, ops ...string) exprNode {
+	x := parseOperand()
+	for p.err == nil && p.isOp(ops...) {
+		op := p.tok.text
+		p.next()
+		x = &exprBinary{op: op, x: x, y: parseOperand()}
+	}
+	return x
+}
+
+func (p *exprParser) parseOr() exprNode {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() exprNode {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() exprNode {
+	return p.parseBinary(p.parseAdditive, "==", "!=", "<", "<=", ">", ">=", "in")
+}
+
+func (p *exprParser) parseAdditive() exprNode {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() exprNode {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() exprNode {
+	if p.isOp("!", "-") {
+		op := p.tok.text
+		p.next()
+		return &exprUnary{op: op, x: p.parseUnary()}
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() exprNode {
+	x := p.parsePrimary()
+	for p.err == nil {
+		switch {
+		case p.isOp("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				p.fail(p.tok.pos, "got %s want a field name", p.tok)
+				return x
+			}
+			name := p.tok.text
+			p.next()
+			if path, ok := x.(*exprPath); ok {
+				path.path.fields = append(path.path.fields, name)
+			} else {
+				x = &exprIndex{x: x, index: &exprLiteral{name}}
+			}
+		case p.isOp("["):
+			p.next()
+			index := p.parseOr()
+			p.expect("]")
+			x = &exprIndex{x: x, index: index}
+		default:
+			return x
+		}
+	}
+	return x
+}
+
+func (p *exprParser) parsePrimary() exprNode {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber, tokString:
+		p.next()
+		return &exprLiteral{tok.value}
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "null":
+			return &exprLiteral{nil}
+		case "true", "false":
+			return &exprLiteral{tok.text == "true"}
+		case "subject":
+			return &exprPath{path: fieldPath{root: rootSubject}, pos: tok.pos}
+		case "action":
+			return &exprPath{path: fieldPath{root: rootAction}, pos: tok.pos}
+		case "resource":
+			return &exprPath{path: fieldPath{root: rootResource}, pos: tok.pos}
+		case "env":
+			return &exprPath{path: fieldPath{root: rootEnv}, pos: tok.pos}
+		}
+		if arity, ok := exprQueryFunctions[tok.text]; ok && p.isOp("(") {
+			p.next()
+			var args []exprNode
+			if !p.isOp(")") {
+				args = p.parseList()
+			}
+			p.expect(")")
+			if p.err == nil && len(args) != arity {
+				p.fail(tok.pos, "%s takes %d arguments, got %d", tok.text, arity, len(args))
+			}
+			if p.err != nil {
+				return &exprLiteral{nil}
+			}
+			if tok.text == "attr" {
+				return &exprAttr{entity: args[0], name: args[1]}
+			}
+			return &exprSubquery{args: [3]exprNode{args[0], args[1], args[2]}}
+		}
+		if function, ok := exprFunctions[tok.text]; ok && p.isOp("(") {
+			p.next()
+			call := &exprCall{name: tok.text, fn: function.fn}
+			if !p.isOp(")") {
+				call.args = p.parseList()
+			}
+			p.expect(")")
+			if p.err == nil && len(call.args) != function.arity {
+				p.fail(tok.pos, "%s takes %d arguments, got %d", tok.text, function.arity, len(call.args))
+			}
+			return call
+		}
+		p.fail(tok.pos, "unknown name %q", tok.text)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			p.next()
+			x := p.parseOr()
+			p.expect(")")
+			return x
+		case "[":
+			p.next()
+			list := &exprList{}
+			if !p.isOp("]") {
+				list.elems = p.parseList()
+			}
+			p.expect("]")
+			return list
+		}
+		p.fail(tok.pos, "unexpected %s", tok)
+	default:
+		p.fail(tok.pos, "unexpected %s", tok)
+	}
+	return &exprLiteral{nil}
+}
+
+// parseList parses a comma separated list of expressions.
+func (p *exprParser) parseList() []exprNode {
+	nodes := []exprNode{p.parseOr()}
+	for p.err == nil && p.isOp(",") {
+		p.next()
+		nodes = append(nodes, p.parseOr())
+	}
+	return nodes
+}
@@ -0,0 +1,260 @@
+package perms
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	john := &User{Name: "john"}
+	group := &Group{Name: "editors", Members: []string{"john", "jack"}}
+	video := &Video{Name: "intro", Duration: 300, User: "john"}
+	cases := []struct {
+		expr     string
+		subject  interface{}
+		resource interface{}
+		want     bool
+	}{
+		{`true`, john, video, true},
+		{`resource.User == subject.Name`, john, video, true},
+		{`resource.User != subject.Name`, john, video, false},
+		{`resource.Duration < 600 && (resource.Public || resource.User == subject.Name)`, john, video, true},
+		{`resource.Duration >= 600 || resource.Public`, john, video, false},
+		{`resource.Duration == 300`, john, video, true},
+		{`resource.Duration * 2 - 100 == 500 && resource.Duration / 3 == 100 && resource.Duration % 7 == 6`, john, video, true},
+		{`-resource.Duration < 0`, john, video, true},
+		{`!subject.IsSuperuser`, john, video, true},
+		{`action in ["view", 'list']`, john, video, true},
+		{`action in []`, john, video, false},
+		{`subject.Name in resource.Members`, john, group, true},
+		{`resource.Members[1] == "jack" && size(resource.Members) == 2`, john, group, true},
+		{`size(subject.Name) == 4 && startsWith(subject.Name, "jo") && endsWith(subject.Name, "hn") && contains(subject.Name, "oh")`, john, video, true},
+		{`resource.Name + "-" + resource.User == "intro-john"`, john, video, true},
+		{`resource.Name < "outro"`, john, video, true},
+		{`resource["Name"] == "intro"`, john, video, true},
+		{`resource == null`, john, nil, true},
+		{`resource.Name == null`, john, (*Video)(nil), true},
+		{`subject.Name == "jack" || subject.Name == "john" && !subject.IsSuperuser`, john, video, true},
+		{`1e3 == 1000.0`, john, video, true},
+		{`"a\"b" == 'a"b'`, john, video, true},
+	}
+	for _, c := range cases {
+		expr, err := CompileExpression(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		got, err := expr.Eval(c.subject, "view", c.resource)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+		} else if got != c.want {
+			t.Errorf("%s: got %v want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{``, `invalid expression "" at column 1: unexpected end of expression`},
+		{`resource.Public &&`, `invalid expression "resource.Public &&" at column 19: unexpected end of expression`},
+		{`resource.Public resource.User`, `invalid expression "resource.Public resource.User" at column 17: unexpected "resource"`},
+		{`user.Name == "x"`, `invalid expression "user.Name == \"x\"" at column 1: unknown name "user"`},
+		{`(resource.Public`, `invalid expression "(resource.Public" at column 17: got end of expression want ")"`},
+		{`resource. == 1`, `invalid expression "resource. == 1" at column 11: got "==" want a field name`},
+		{`subject.Name == "john`, `invalid expression "subject.Name == \"john" at column 17: unterminated string`},
+		{`subject.Name = "john"`, `invalid expression "subject.Name = \"john\"" at column 14: unexpected character '='`},
+		{`size(subject.Name, 1) > 0`, `invalid expression "size(subject.Name, 1) > 0" at column 1: size takes 1 arguments, got 2`},
+	}
+	for _, c := range cases {
+		_, err := CompileExpression(c.expr)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+	}
+}
+
+func TestExpressionEvalErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{`resource.Duration`, `perms: evaluating "resource.Duration": got a number want a boolean`},
+		{`resource.Duration && true`, `perms: evaluating "resource.Duration && true": operator && not defined on a number`},
+		{`resource.Name < 3`, `perms: evaluating "resource.Name < 3": operator < not defined on a string and a number`},
+		{`resource.Duration / 0 > 1`, `perms: evaluating "resource.Duration / 0 > 1": division by zero`},
+		{`resource.Owner == "john"`, `perms: evaluating "resource.Owner == \"john\"": type perms.Video has no exported field "Owner"`},
+	}
+	for _, c := range cases {
+		expr, err := CompileExpression(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = expr.Eval(&User{}, "view", &Video{Duration: 300})
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+	}
+}
+
+// videoPolicyJSON expresses the rules of newVideoRuleSet as declarative rules with
+// condition expressions.
+const videoPolicyJSON = `{
+  "rules": [
+    {"subject": "User", "action": "view", "resource": "Playlist",
+     "condition": "subject.IsSuperuser || resource.Public || resource.User == subject.Name", "effect": "allow"},
+    {"subject": "User", "action": "view", "resource": "Playlist",
+     "condition": "!(subject.IsSuperuser || resource.Public || resource.User == subject.Name)", "effect": "deny"},
+    {"subject": "User", "action": "modify", "resource": "Playlist",
+     "condition": "resource.User == subject.Name", "effect": "allow"},
+    {"subject": "User", "action": "modify", "resource": "Playlist",
+     "condition": "resource.User != subject.Name", "effect": "deny"},
+    {"subject": "User", "action": "view", "resource": "Video",
+     "condition": "subject.IsSuperuser || resource.Public || resource.User == subject.Name", "effect": "allow"},
+    {"subject": "User", "action": "view", "resource": "Video",
+     "condition": "!(subject.IsSuperuser || resource.Public || resource.User == subject.Name)", "effect": "deny"},
+    {"subject": "User", "action": "modify", "resource": "Video",
+     "condition": "subject.IsSuperuser || resource.User == subject.Name", "effect": "allow"},
+    {"subject": "User", "action": "modify", "resource": "Video",
+     "condition": "!subject.IsSuperuser && resource.User != subject.Name", "effect": "deny"},
+    {"subject": "Group", "action": "modify", "resource": "Playlist",
+     "condition": "resource.Group == subject.Name", "effect": "allow"},
+    {"subject": "Group", "action": "modify", "resource": "Playlist",
+     "condition": "resource.Group != subject.Name", "effect": "deny"},
+    {"subject": "User", "action": "view",
+     "condition": "subject.IsSuperuser", "effect": "allow", "quick": true},
+    {"subject": "User", "action": "view",
+     "condition": "!subject.IsSuperuser", "effect": "deny"}
+  ]
+}`
+
+func TestExpressionConditions(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadJSON(strings.NewReader(videoPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	checkSameDecisions(t, rs, newVideoRuleSet())
+
+	// a condition on the video duration, as a closure and as an expression
+	closure := NewRuleSet(DENY)
+	closure.AddRule(&User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		video := res.(*Video)
+		return video.Duration < 600 && (video.Public || video.User == subj.(*User).Name), ALLOW, false
+	})
+	expr := newPolicyRuleSet()
+	_, err := expr.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "view", Resource: "Video",
+		Condition: "resource.Duration < 600 && (resource.Public || resource.User == subject.Name)", Effect: ALLOW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	john := &User{Name: "john"}
+	for _, video := range []*Video{
+		{Duration: 300, User: "john"},
+		{Duration: 900, User: "john"},
+		{Duration: 300, User: "jack"},
+		{Duration: 300, User: "jack", Public: true},
+		{Duration: 600, Public: true},
+	} {
+		if got, want := expr.Query(john, "view", video), closure.Query(john, "view", video); got != want {
+			t.Errorf("%+v: got %q want %q", video, got, want)
+		}
+	}
+
+	// conditions and the expression must all hold
+	_, err = expr.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "delete", Resource: "Video",
+		Conditions: []Condition{{Field: "resource.Public", Value: false}},
+		Condition:  "resource.User == subject.Name", Effect: ALLOW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expr.Query(john, "delete", &Video{User: "john"}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+	if got := expr.Query(john, "delete", &Video{User: "john", Public: true}); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+}
+
+func TestExpressionLoadErrors(t *testing.T) {
+	cases := []struct {
+		policy string
+		err    string
+	}{
+		{`{"rules": [{"subject": "User", "condition": "subject.Name ==", "effect": "allow"}]}`,
+			`perms: rule 0: invalid expression "subject.Name ==" at column 16: unexpected end of expression`},
+		{`{"rules": [{"effect": "allow"}, {"resource": "Video", "condition": "resource.Public && resource.Owner == subject.Name", "effect": "allow"}]}`,
+			`perms: rule 1: invalid expression "resource.Public && resource.Owner == subject.Name" at column 20: type perms.Video has no exported field "Owner"`},
+	}
+	for _, c := range cases {
+		rs := newPolicyRuleSet()
+		err := rs.LoadJSON(strings.NewReader(c.policy))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+		var exprErr *ExpressionError
+		if !errors.As(err, &exprErr) {
+			t.Errorf("got %#v want an ExpressionError", err)
+		}
+	}
+
+	rs := newPolicyRuleSet()
+	err := rs.LoadYAML(strings.NewReader("rules:\n  - subject: User\n    effect: allow\n  - subject: User\n    condition: subject.Nickname == 'x'\n    effect: allow\n"))
+	if want := `perms: line 4: invalid expression "subject.Nickname == 'x'" at column 1: type perms.User has no exported field "Nickname"`; err == nil || err.Error() != want {
+		t.Errorf("got error %v want %q", err, want)
+	}
+	var exprErr *ExpressionError
+	if !errors.As(err, &exprErr) || exprErr.Column != 1 {
+		t.Errorf("got %#v want an ExpressionError at column 1", err)
+	}
+}
+
+func TestStrictExpressions(t *testing.T) {
+	decl := DeclarativeRule{Subject: "User", Action: "view", Condition: "resource.Duration < 600", Effect: ALLOW}
+	rs := newPolicyRuleSet()
+	rs.DefaultEffect = "none"
+	rs.AddRule(&User{}, "view", nil, effectMatcher(DENY))
+	if _, err := rs.AddDeclarativeRule(decl); err != nil {
+		t.Fatal(err)
+	}
+	// the jolly resource may lack the Duration field: the rule doesn't match
+	if got, err := rs.QueryE(&User{}, "view", &Playlist{}); err != nil || got != DENY {
+		t.Errorf("got %q, %v want %q", got, err, DENY)
+	}
+	if got := rs.Query(&User{}, "view", &Video{Duration: 300}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+
+	rs = newPolicyRuleSet()
+	rs.DefaultEffect = "none"
+	rs.StrictExpressions = true
+	rs.AddRule(&User{}, "view", nil, effectMatcher(DENY))
+	if _, err := rs.AddDeclarativeRule(decl); err != nil {
+		t.Fatal(err)
+	}
+	want := `perms: evaluating "resource.Duration < 600": type perms.Playlist has no exported field "Duration"`
+	if got, err := rs.QueryE(&User{}, "view", &Playlist{}); err == nil || err.Error() != want || got != "none" {
+		t.Errorf("got %q, %v want the default effect and %q", got, err, want)
+	}
+	if got := rs.Query(&User{}, "view", &Video{Duration: 300}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+}
+
+func TestExpressionRoundTrip(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadJSON(strings.NewReader(videoPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	var yamlDoc strings.Builder
+	if err := rs.SaveYAML(&yamlDoc); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newPolicyRuleSet()
+	if err := reloaded.LoadYAML(strings.NewReader(yamlDoc.String())); err != nil {
+		t.Fatalf("%v\n%s", err, yamlDoc.String())
+	}
+	checkSameDecisions(t, reloaded, rs)
+}
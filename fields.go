@@ -0,0 +1,211 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// FieldMatcherFn is the matcher of a field rule, returning the effects of the fields
+// of the resource it has an opinion on, by name, eg. {"Duration": Deny}.
+type FieldMatcherFn func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (matches bool, fields map[string]Effect, err error)
+
+// AddFieldRule adds a field rule, granting or denying fields of the resources to the
+// subjects, see QueryFields. The field rules are evaluated only by QueryFields, which
+// evaluates only them: they don't take part in the other queries.
+func (ruleSet *RuleSet) AddFieldRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher FieldMatcherFn, options ...RuleOption) RuleID {
+	rule := newFieldRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+func newFieldRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher FieldMatcherFn) *Rule {
+	rule := newRule(subjectType, actionType, resourceType, func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		return false, "", false, nil
+	})
+	rule.fieldMatcher = matcher
+	return rule
+}
+
+// declarativeFieldMatcher returns the field matcher of a declarative rule with fields.
+func declarativeFieldMatcher(matcher MatcherCtxFn, names []string) FieldMatcherFn {
+	return func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, map[string]Effect, error) {
+		matches, effect, _, err := matcher(ctx, subject, action, resource)
+		if !matches || err != nil {
+			return false, nil, err
+		}
+		fields := make(map[string]Effect, len(names))
+		for _, name := range names {
+			fields[name] = effect
+		}
+		return true, fields, nil
+	}
+}
+
+// fieldEffect is the effect of a field in a QueryFields evaluation, produced in the
+// given pass.
+type fieldEffect struct {
+	effect Effect
+	pass   int
+}
+
+// combineFields merges the field effects produced by a rule, following the combining
+// strategy for each field.
+func (ev *evaluation) combineFields(fields map[string]Effect) {
+	for name, effect := range fields {
+		if effect == "" {
+			continue
+		}
+		current, ok := ev.fields[name]
+		switch ev.combining {
+		case FirstApplicable:
+			if ok {
+				continue
+			}
+		case DenyOverrides, AllowOverrides:
+			overriding := Deny
+			if ev.combining == AllowOverrides {
+				overriding = Allow
+			}
+			if ok && (current.effect == overriding || effect != overriding) {
+				continue
+			}
+		default:
+			// the first pass producing an effect for the field decides
+			if ok && current.pass < ev.pass {
+				continue
+			}
+		}
+		ev.fields[name] = fieldEffect{effect: effect, pass: ev.pass}
+	}
+}
+
+// QueryFields returns the effects of the fields of the resource: those produced by the
+// field rules (see AddFieldRule and DeclarativeRule.Fields), merged for each field
+// following the combining strategy like the effects of a query, or else the effect of
+// the query itself. The fields are the exported fields of the resource, if a struct or
+// a pointer to one, and those named by the field rules.
+//
+// The field rules are not evaluated for the superuser and the revoked subjects, whose
+// fields all have the effect of the query. The decisions of QueryFields are not cached.
+func (ruleSet *RuleSet) QueryFields(subject interface{}, action interface{}, resource interface{}) (map[string]Effect, error) {
+	return ruleSet.QueryFieldsCtx(context.Background(), subject, action, resource)
+}
+
+// QueryFieldsCtx is like QueryFields, with the context of the query, see QueryCtx.
+func (ruleSet *RuleSet) QueryFieldsCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (map[string]Effect, error) {
+	table := ruleSet.current()
+	decision, err := ruleSet.evaluateWith(ctx, queryOptions{table: table}, subject, action, resource)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]Effect)
+	for _, name := range structFieldNames(resource) {
+		fields[name] = decision.Effect
+	}
+	if decision.Superuser || decision.Revoked {
+		return fields, nil
+	}
+
+	ev := &evaluation{
+		ruleSet:  ruleSet,
+		table:    table,
+		ctx:      ctx,
+		subject:  subject,
+		action:   action,
+		resource: resource,
+		fields:   make(map[string]fieldEffect),
+		query:    [3]interface{}{subject, action, resource},
+		caller:   callerOf(ctx),
+
+		maxEvaluations: ruleSet.MaxEvaluations,
+		combining:      ruleSet.Combining,
+	}
+	if canonical, ok := table.actions.canonical(action); ok {
+		ev.action = canonical
+		if !ruleSet.CanonicalActions {
+			ev.alias, ev.aliased = action, true
+		}
+	}
+	ev.run()
+	if ev.err != nil {
+		return nil, ev.err
+	}
+	for name, field := range ev.fields {
+		fields[name] = field.effect
+	}
+	return fields, nil
+}
+
+// structFieldNames returns the names of the exported fields of value, if a struct or a
+// pointer to one.
+func structFieldNames(value interface{}) []string {
+	t := reflect.TypeOf(value)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// AllowedFields returns the names of the fields with the Allow effect, sorted, eg. to
+// pass the result of QueryFields to ApplyFieldMask.
+func AllowedFields(fields map[string]Effect) []string {
+	var names []string
+	for name, effect := range fields {
+		if effect == Allow {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrNotStructPointer is returned by ApplyFieldMask for a resource that is not a non
+// nil pointer to a struct.
+var ErrNotStructPointer = errors.New("perms: not a pointer to a struct")
+
+// ApplyFieldMask sets to their zero value the exported fields of the struct pointed to
+// by resource not in allowedFields, eg. to filter the fields of an API response:
+//
+//	fields, err := rs.QueryFields(user, "view", video)
+//	if err != nil {
+//		return err
+//	}
+//	perms.ApplyFieldMask(video, perms.AllowedFields(fields))
+//
+// The unexported fields are left alone. The struct is changed in place, so resource
+// is usually a copy of the value served.
+func ApplyFieldMask(resource interface{}, allowedFields []string) error {
+	v := reflect.ValueOf(resource)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrNotStructPointer
+	}
+	allowed := make(map[string]bool, len(allowedFields))
+	for _, name := range allowedFields {
+		allowed[name] = true
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" && !allowed[field.Name] {
+			v.Field(i).Set(reflect.Zero(field.Type))
+		}
+	}
+	return nil
+}
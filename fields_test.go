@@ -0,0 +1,171 @@
+package perms
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hideDuration hides the Duration of the videos from everyone but their owners.
+func hideDuration(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, map[string]Effect, error) {
+	if res.(*Video).User == subj.(*User).Name {
+		return false, nil, nil
+	}
+	return true, map[string]Effect{"Duration": DENY}, nil
+}
+
+func TestQueryFields(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddFieldRule(&User{}, "view", &Video{}, hideDuration)
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	video := &Video{Name: "holidays", Duration: 300, Public: true, User: "john"}
+
+	fields, err := rs.QueryFields(john, "view", video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Effect{"Name": ALLOW, "Duration": ALLOW, "Public": ALLOW, "User": ALLOW, "Group": ALLOW}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v want %v", fields, want)
+	}
+	if fields, err = rs.QueryFields(jack, "view", video); err != nil {
+		t.Fatal(err)
+	}
+	want["Duration"] = DENY
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v want %v", fields, want)
+	}
+
+	// the unspecified fields fall back to the effect of the query
+	private := &Video{Name: "secret", Duration: 60, User: "john"}
+	if fields, err = rs.QueryFields(jack, "view", private); err != nil {
+		t.Fatal(err)
+	}
+	if AllowedFields(fields) != nil {
+		t.Errorf("got allowed fields %v of a denied video", AllowedFields(fields))
+	}
+
+	// the field rules don't take part in the other queries
+	if !rs.IsAllowed(jack, "view", video) || rs.RuleCount() == 0 {
+		t.Errorf("got the field rule in the query")
+	}
+
+	served := *video
+	if err := ApplyFieldMask(&served, AllowedFields(fields)); err != nil {
+		t.Fatal(err)
+	}
+	if served != (Video{}) {
+		t.Errorf("got %+v want the zero video", served)
+	}
+	served = *video
+	fields, _ = rs.QueryFields(jack, "view", video)
+	ApplyFieldMask(&served, AllowedFields(fields))
+	if served.Duration != 0 || served.Name != "holidays" || served.User != "john" {
+		t.Errorf("got %+v want the video without the duration", served)
+	}
+	if err := ApplyFieldMask(*video, nil); err != ErrNotStructPointer {
+		t.Errorf("got %v want ErrNotStructPointer", err)
+	}
+}
+
+func TestQueryFieldsCombining(t *testing.T) {
+	showDuration := func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, map[string]Effect, error) {
+		return true, map[string]Effect{"Duration": ALLOW, "Rating": ALLOW}, nil
+	}
+	for _, tc := range []struct {
+		combining CombiningStrategy
+		duration  Effect
+	}{
+		{LastApplicable, ALLOW},
+		{FirstApplicable, DENY},
+		{DenyOverrides, DENY},
+		{AllowOverrides, ALLOW},
+	} {
+		rs := newVideoRuleSet()
+		rs.Combining = tc.combining
+		rs.AddFieldRule(&User{}, "view", &Video{}, hideDuration)
+		rs.AddFieldRule(&User{}, "view", &Video{}, showDuration)
+		jack, video := &User{Name: "jack"}, &Video{User: "john", Public: true}
+		fields, err := rs.QueryFields(jack, "view", video)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["Duration"] != tc.duration || fields["Rating"] != ALLOW || fields["Name"] != rs.Query(jack, "view", video) {
+			t.Errorf("%v: got %v want Duration %s", tc.combining, fields, tc.duration)
+		}
+	}
+
+	// with LastApplicable the most specific rules decide
+	rs := newVideoRuleSet()
+	rs.AddFieldRule(&User{}, "view", &Video{}, hideDuration)
+	rs.AddFieldRule(nil, "view", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, map[string]Effect, error) {
+		return true, map[string]Effect{"Duration": ALLOW}, nil
+	})
+	if fields, _ := rs.QueryFields(&User{Name: "jack"}, "view", &Video{Public: true}); fields["Duration"] != DENY {
+		t.Errorf("got %v want the Duration denied", fields)
+	}
+}
+
+func TestQueryFieldsSuperuser(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddFieldRule(&User{}, "view", &Video{}, hideDuration)
+	rs.SuperuserFn = func(subject interface{}) bool {
+		user, ok := subject.(*User)
+		return ok && user.IsSuperuser
+	}
+	fields, err := rs.QueryFields(&User{Name: "admin", IsSuperuser: true}, "view", &Video{User: "john"})
+	if err != nil || fields["Duration"] != ALLOW {
+		t.Errorf("got %v, %v want the Duration allowed", fields, err)
+	}
+}
+
+func TestDeclarativeFieldRule(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{
+		Subject:    "User",
+		Action:     "view",
+		Resource:   "Playlist",
+		Conditions: []Condition{{Field: "resource.User", Ref: "subject.Name"}},
+		Effect:     ALLOW,
+		Fields:     []string{"Group"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{
+		Subject:  "User",
+		Action:   "view",
+		Resource: "Playlist",
+		Effect:   DENY,
+		Fields:   []string{"Group"},
+		Priority: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Resource: "Playlist", Effect: DENY, Fields: []string{"Owner"}}); err == nil {
+		t.Errorf("got no error for an unknown field")
+	}
+	playlist := &Playlist{ID: "best", Public: true, User: "john", Group: "editors"}
+	for _, tc := range []struct {
+		user  string
+		group Effect
+	}{
+		{"john", ALLOW},
+		{"jack", DENY},
+	} {
+		fields, err := rs.QueryFields(&User{Name: tc.user}, "view", playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["Group"] != tc.group || fields["ID"] != ALLOW {
+			t.Errorf("%s: got %v want Group %s", tc.user, fields, tc.group)
+		}
+	}
+	if decls := rs.DeclarativeRules(); len(decls[len(decls)-1].Fields) != 1 {
+		t.Errorf("got %+v want the fields", decls[len(decls)-1])
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// resolvedList holds the rules of a RuleList found in m3rules for a query whose subject
+// and action templates admit the queried values, along with their positions in the
+// RuleList and the indexes of the forms of the subject, action and resource values the
+// list was found under (see queryValue.form).
+type resolvedList struct {
+	rules   []*Rule
+	indexes []int
+	forms   [3]int
+}
+
+// resolvedRules holds the rules of each fallback pass for given subject and action
+// values and a resource type, to evaluate many resources of the same type without
+// probing m3rules and checking the subject and action of the rules for each one.
+type resolvedRules struct {
+	subject, action queryValue
+	passes          [len(jollyTiers)][]resolvedList
+}
+
+// resolveRules returns the rule lists for the subject and action values and the type
+// of the resource value.
+func (ruleSet *RuleSet) resolveRules(table *ruleTable, subject queryValue, action queryValue, resource queryValue) *resolvedRules {
+	resolved := &resolvedRules{subject: subject, action: action}
+	mode := ruleSet.templateMode()
+	for pass, tier := range jollyTiers {
+		s, a, r := subject.pick(tier[0]), action.pick(tier[1]), resource.pick(tier[2])
+		for i := 0; i < s.numTypes(); i++ {
+			sT, sq := s.form(i)
+			aMap, ok := table.m3rules[sT]
+			if !ok {
+				continue
+			}
+			for j := 0; j < a.numTypes(); j++ {
+				aT, aq := a.form(j)
+				rMap, ok := aMap[aT]
+				if !ok {
+					continue
+				}
+				for k := 0; k < r.numTypes(); k++ {
+					rT, _ := r.form(k)
+					list := resolvedList{forms: [3]int{i, j, k}}
+					rules := rMap[rT]
+					table.scanList([3]typ{sT, aT, rT}, rules, [3]*queryValue{sq, aq, nil}, func(index int) bool {
+						if rule := rules[index]; rule.admits(0, sq, rule.subject, mode) && rule.admits(1, aq, rule.action, mode) {
+							list.rules = append(list.rules, rule)
+							list.indexes = append(list.indexes, index)
+						}
+						return true
+					})
+					if len(list.rules) > 0 {
+						resolved.passes[pass] = append(resolved.passes[pass], list)
+					}
+				}
+			}
+		}
+	}
+	return resolved
+}
+
+// scan is like RuleSet.lookupRules, for the rules resolved for the pass.
+func (resolved *resolvedRules) scan(ruleSet *RuleSet, pass int, resource *queryValue, skipped func(rule *Rule, index int), fn func(c candidate) bool) {
+	tier := jollyTiers[pass]
+	subject, action, picked := resolved.subject.pick(tier[0]), resolved.action.pick(tier[1]), resource.pick(tier[2])
+	for _, list := range resolved.passes[pass] {
+		if list.forms[2] >= picked.numTypes() {
+			// the counterpart of a nil pointer
+			continue
+		}
+		_, s := subject.form(list.forms[0])
+		_, a := action.form(list.forms[1])
+		_, r := picked.form(list.forms[2])
+		for i, rule := range list.rules {
+			if !rule.admits(2, r, rule.resource, ruleSet.templateMode()) {
+				if skipped != nil {
+					skipped(rule, list.indexes[i])
+				}
+				continue
+			}
+			if !fn(candidate{rule, list.indexes[i], s.value, a.value, r.value}) {
+				return
+			}
+		}
+	}
+}
+
+// resolutionKey identifies the resources sharing the same resolvedRules.
+type resolutionKey struct {
+	t typ
+	// counterpart is false for nil pointers, see counterpartOf
+	counterpart bool
+}
+
+// FilterAllowed returns the resources that subject may access with action, that is
+// those for which querying the (subject, action, resource) triple produces allowEffect.
+// It is equivalent to calling Query for each resource, but the rules to evaluate are
+// looked up once for each type of resource, so that only the matchers run for each
+// resource. The resources may be of different types.
+func (ruleSet *RuleSet) FilterAllowed(subject interface{}, action interface{}, resources []interface{}, allowEffect string) []interface{} {
+	var allowed []interface{}
+	for i, ok := range ruleSet.FilterAllowedMask(subject, action, resources, allowEffect) {
+		if ok {
+			allowed = append(allowed, resources[i])
+		}
+	}
+	return allowed
+}
+
+// FilterAllowedMask is like FilterAllowed, but returns whether each resource is allowed.
+func (ruleSet *RuleSet) FilterAllowedMask(subject interface{}, action interface{}, resources []interface{}, allowEffect string) []bool {
+	table := ruleSet.current()
+	return ruleSet.filterAllowedMask(table, subject, ruleSet.queryValue(0, subject, table), nil, action, resources, allowEffect)
+}
+
+// filterAllowedMask is like FilterAllowedMask, for the rules of table, with s the
+// queryValue of subject and bound, if non-nil, the subject resolved for table.
+func (ruleSet *RuleSet) filterAllowedMask(table *ruleTable, subject interface{}, s queryValue, bound *boundSubject, action interface{}, resources []interface{}, allowEffect string) []bool {
+	mask := make([]bool, len(resources))
+	// the rules are resolved for the canonical action, see decide
+	canonical := action
+	if c, ok := table.actions.canonical(action); ok {
+		canonical = c
+	}
+	a := ruleSet.queryValue(1, canonical, table)
+
+	resolutions := make(map[resolutionKey]*resolvedRules)
+	var r queryValue
+	for i, resource := range resources {
+		r = ruleSet.queryValue(2, resource, table)
+		key := resolutionKey{r.t, r.counterpart != nil}
+		resolved, ok := resolutions[key]
+		if !ok {
+			resolved = ruleSet.resolveRules(table, s, a, r)
+			resolutions[key] = resolved
+		}
+		decision, _ := ruleSet.evaluateWith(context.Background(), queryOptions{resolved: resolved, resource: &r, table: table, bound: bound}, subject, action, resource)
+		mask[i] = decision.Effect == allowEffect
+	}
+	return mask
+}
@@ -0,0 +1,128 @@
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFilterAllowed(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddRule(&User{}, "view", "", func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res == "/public", ALLOW, false
+	})
+	john := &User{Name: "john"}
+	resources := []interface{}{
+		&Video{Name: "intro", User: "john"},
+		&Video{Name: "outro", User: "jack"},
+		&Playlist{ID: "6563", User: "john"},
+		&Video{Name: "trailer", User: "jack", Public: true},
+		&Archive{Name: "2019", User: "john"},
+		&Playlist{ID: "9374", User: "jack"},
+		"/public",
+		"/private",
+		nil,
+	}
+
+	var want []interface{}
+	var wantMask []bool
+	for _, resource := range resources {
+		allowed := rs.Query(john, "view", resource) == ALLOW
+		wantMask = append(wantMask, allowed)
+		if allowed {
+			want = append(want, resource)
+		}
+	}
+	if len(want) != 4 {
+		t.Fatalf("got %d allowed resources with Query want 4", len(want))
+	}
+	if got := rs.FilterAllowedMask(john, "view", resources, ALLOW); !reflect.DeepEqual(got, wantMask) {
+		t.Errorf("got mask %v want %v", got, wantMask)
+	}
+	if got := rs.FilterAllowed(john, "view", resources, ALLOW); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	admin := &User{Name: "admin", IsSuperuser: true}
+	if got := rs.FilterAllowed(admin, "view", resources, ALLOW); len(got) != len(resources) {
+		t.Errorf("got %d resources allowed to the superuser want %d", len(got), len(resources))
+	}
+}
+
+func TestFilterAllowedOptions(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.NormalizePointers = true
+	rs.ParentOf = func(resource interface{}) interface{} {
+		if video, ok := resource.(*Video); ok && video.Group != "" {
+			return &Playlist{ID: video.Group}
+		}
+		return nil
+	}
+	rs.AddRule(&User{}, "view", Video{Name: "intro"}, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "view", &Playlist{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res.(*Playlist).ID == "6563", ALLOW, false
+	})
+	rs.AddRule(&User{}, "view", InterfaceOf[NamedOwner](), ownerMatcher)
+	rs.AddRule(&User{}, "view", Archive{Name: "2019"}, effectMatcher(ALLOW))
+
+	john := &User{Name: "john"}
+	resources := []interface{}{
+		(*Archive)(nil),
+		&Video{Name: "intro"},
+		&Video{Name: "outro"},
+		&Video{Name: "outro", Group: "6563"},
+		&Video{Name: "outro", User: "john"},
+		&Archive{Name: "2019"},
+		Archive{Name: "2020"},
+	}
+	want := []bool{false, true, false, true, true, true, false}
+	if got := rs.FilterAllowedMask(john, "view", resources, ALLOW); !reflect.DeepEqual(got, want) {
+		t.Errorf("got mask %v want %v", got, want)
+	}
+	for i, resource := range resources {
+		if got := rs.Query(john, "view", resource) == ALLOW; got != want[i] {
+			t.Errorf("%d: got %v from Query want %v", i, got, want[i])
+		}
+	}
+}
+
+func newFilterBenchmark() (*RuleSet, []interface{}) {
+	rs := newVideoRuleSet()
+	for i := 0; i < 20; i++ {
+		rs.AddRule(&User{}, fmt.Sprintf("action-%d", i), &Video{}, effectMatcher(ALLOW))
+	}
+	resources := make([]interface{}, 10000)
+	for i := range resources {
+		if i%2 == 0 {
+			resources[i] = &Video{Name: fmt.Sprint(i), User: fmt.Sprint("user-", i%7)}
+		} else {
+			resources[i] = &Playlist{ID: fmt.Sprint(i), User: fmt.Sprint("user-", i%7)}
+		}
+	}
+	return rs, resources
+}
+
+func BenchmarkFilterAllowed(b *testing.B) {
+	rs, resources := newFilterBenchmark()
+	user := &User{Name: "user-3"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs.FilterAllowed(user, "modify", resources, ALLOW)
+	}
+}
+
+func BenchmarkFilterWithQuery(b *testing.B) {
+	rs, resources := newFilterBenchmark()
+	user := &User{Name: "user-3"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var allowed []interface{}
+		for _, resource := range resources {
+			if rs.Query(user, "modify", resource) == ALLOW {
+				allowed = append(allowed, resource)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package ginperm enforces go-perms rule sets on Gin routes.
+package ginperm
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	perms "github.com/panta/go-perms"
+)
+
+const (
+	// SubjectKey is the context key the subject is read from by default, where an
+	// authentication middleware can store it with c.Set.
+	SubjectKey = "perms.subject"
+	// ResourceKey is the context key the loaded resource is stored under by default,
+	// see WithResourceKey.
+	ResourceKey = "perms.resource"
+)
+
+// ExtractorFn extracts the subject or the action of a query from the context. An
+// extractor fails by returning nil.
+type ExtractorFn func(c *gin.Context) interface{}
+
+// LoaderFn loads the resource of a query, eg. fetching from a database the record
+// identified by a path parameter.
+type LoaderFn func(c *gin.Context) (interface{}, error)
+
+type config struct {
+	subjectFn   ExtractorFn
+	actionFn    ExtractorFn
+	resourceFn  LoaderFn
+	resourceKey string
+}
+
+// Option configures the Middleware.
+type Option func(*config)
+
+// WithSubject sets the extractor of the subject. By default the subject is the value
+// stored in the context under SubjectKey.
+func WithSubject(fn ExtractorFn) Option {
+	return func(c *config) {
+		c.subjectFn = fn
+	}
+}
+
+// WithAction sets the extractor of the action. By default the action is the method of
+// the request.
+func WithAction(fn ExtractorFn) Option {
+	return func(c *config) {
+		c.actionFn = fn
+	}
+}
+
+// WithResource sets the loader of the resource. By default the resource is the route
+// of the request, eg. "/playlists/:id".
+func WithResource(fn LoaderFn) Option {
+	return func(c *config) {
+		c.resourceFn = fn
+	}
+}
+
+// WithResourceKey sets the context key the resource is stored under, ResourceKey by
+// default.
+func WithResourceKey(key string) Option {
+	return func(c *config) {
+		c.resourceKey = key
+	}
+}
+
+// Middleware returns a middleware enforcing rs on the subject, action and resource of
+// each request, see perms.RuleSet.EnforceCtx. The loaded resource is stored in the
+// context, so that the handlers can fetch it with c.Get instead of loading it again.
+// The request is aborted with a JSON {"error": ...} body and the status 401 Unauthorized
+// if there is no subject, 403 Forbidden if the permission is denied, 500 Internal Server
+// Error if the action can't be extracted, the loader fails or the query returns an error.
+func Middleware(rs *perms.RuleSet, options ...Option) gin.HandlerFunc {
+	cfg := config{
+		subjectFn: func(c *gin.Context) interface{} {
+			subject, _ := c.Get(SubjectKey)
+			return subject
+		},
+		actionFn: func(c *gin.Context) interface{} {
+			return c.Request.Method
+		},
+		resourceFn: func(c *gin.Context) (interface{}, error) {
+			return c.FullPath(), nil
+		},
+		resourceKey: ResourceKey,
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	return func(c *gin.Context) {
+		subject := cfg.subjectFn(c)
+		if subject == nil {
+			abort(c, http.StatusUnauthorized)
+			return
+		}
+		action := cfg.actionFn(c)
+		if action == nil {
+			abort(c, http.StatusInternalServerError)
+			return
+		}
+		resource, err := cfg.resourceFn(c)
+		if err != nil {
+			abort(c, http.StatusInternalServerError)
+			return
+		}
+		c.Set(cfg.resourceKey, resource)
+		err = rs.EnforceCtx(c.Request.Context(), subject, action, resource)
+		if errors.Is(err, perms.ErrPermissionDenied) {
+			abort(c, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			abort(c, http.StatusInternalServerError)
+			return
+		}
+		c.Next()
+	}
+}
+
+func abort(c *gin.Context, status int) {
+	c.AbortWithStatusJSON(status, gin.H{"error": http.StatusText(status)})
+}
@@ -0,0 +1,89 @@
+package ginperm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	perms "github.com/panta/go-perms"
+)
+
+type user struct {
+	Name string
+}
+
+type playlist struct {
+	ID   string
+	User string
+}
+
+var playlists = map[string]*playlist{
+	"6563": {ID: "6563", User: "john"},
+	"9374": {ID: "9374", User: "jack"},
+}
+
+func newRouter(loads *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.AddRule(&user{}, "GET", &playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*playlist).User == subj.(*user).Name {
+				return true, perms.Allow, false
+			}
+			return true, perms.Deny, false
+		})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if name := c.GetHeader("X-User"); name != "" {
+			c.Set(SubjectKey, &user{Name: name})
+		}
+	})
+	load := func(c *gin.Context) (interface{}, error) {
+		*loads++
+		p, ok := playlists[c.Param("id")]
+		if !ok {
+			return nil, errors.New("no such playlist")
+		}
+		return p, nil
+	}
+	router.GET("/playlists/:id", Middleware(rs, WithResource(load)), func(c *gin.Context) {
+		// the handler gets the resource loaded by the middleware
+		p := c.MustGet(ResourceKey).(*playlist)
+		c.String(http.StatusOK, p.ID)
+	})
+	return router
+}
+
+func TestMiddleware(t *testing.T) {
+	loads := 0
+	router := newRouter(&loads)
+	tests := []struct {
+		user, path string
+		status     int
+		body       string
+	}{
+		{"john", "/playlists/6563", http.StatusOK, "6563"},
+		{"john", "/playlists/9374", http.StatusForbidden, `{"error":"Forbidden"}`},
+		{"", "/playlists/6563", http.StatusUnauthorized, `{"error":"Unauthorized"}`},
+		{"john", "/playlists/1", http.StatusInternalServerError, `{"error":"Internal Server Error"}`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			req.Header.Set("X-User", test.user)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status || strings.TrimSpace(rec.Body.String()) != test.body {
+			t.Errorf("%s %s: got %d %q want %d %q", test.user, test.path, rec.Code, rec.Body, test.status, test.body)
+		}
+	}
+	// the subject is checked before loading the resource
+	if loads != 3 {
+		t.Errorf("got %d loads want 3", loads)
+	}
+}
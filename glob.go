@@ -0,0 +1,101 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrBadPattern is returned when registering a rule with a malformed pattern template.
+var ErrBadPattern = errors.New("perms: syntax error in pattern")
+
+// globTemplate matches string values against a glob pattern.
+type globTemplate struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (glob *globTemplate) matchTemplate(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && glob.re.MatchString(s)
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// compileGlob translates a glob pattern to an anchored regexp.
+func compileGlob(pattern string) (*globTemplate, error) {
+	var re strings.Builder
+	re.WriteString(`\A`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(`.*`)
+		case '?':
+			re.WriteString(`.`)
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, ErrBadPattern
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, ErrBadPattern
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = class[1:]
+				re.WriteString(`[^`)
+			} else {
+				re.WriteString(`[`)
+			}
+			if class == "" {
+				return nil, ErrBadPattern
+			}
+			re.WriteString(class)
+			re.WriteString(`]`)
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString(`\z`)
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, ErrBadPattern
+	}
+	return &globTemplate{pattern: pattern, re: compiled}, nil
+}
+
+// AddGlobRule is like AddRule, but string templates containing the metacharacters
+// '*', '?', '[' or '\\' are interpreted as glob patterns, matching with the same syntax
+// as path.Match except that '*', '?' and the character classes also match '/' (so
+// "/projects/42/*" matches "/projects/42/assets/7"). As in path.Match, '\\' escapes
+// the following character: the template `C:\\docs` matches "C:\\docs". String
+// templates without metacharacters keep matching literally. ErrBadPattern is
+// returned, and no rule is added, if a pattern is malformed.
+func (ruleSet *RuleSet) AddGlobRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) (RuleID, error) {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	for position, template := range [...]interface{}{subjectType, actionType, resourceType} {
+		pattern, ok := template.(string)
+		if !ok || !isGlob(pattern) {
+			continue
+		}
+		glob, err := compileGlob(pattern)
+		if err != nil {
+			return "", err
+		}
+		rule.patterns[position] = glob
+	}
+	ruleSet.addRules(rule)
+	return rule.id, nil
+}
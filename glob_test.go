@@ -0,0 +1,60 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestAddGlobRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	mustAdd := func(subject, action, resource interface{}, effect string) {
+		t.Helper()
+		if _, err := rs.AddGlobRule(subject, action, resource, effectMatcher(effect)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustAdd("svc-*", "video:*", "/projects/42/*", ALLOW)
+	mustAdd("svc-*", "video:delete", "/projects/42/*", DENY)
+	mustAdd("svc-transcoder", "video:?ublish", "/projects/[0-9]*", "publish")
+	mustAdd("svc-backup", "backup", `C:\\docs`, "escaped")
+	mustAdd("svc-backup", "restore", `/backups/?`, "single")
+	rs.AddRule("admin", "video:*", "/projects/42/assets/7", effectMatcher("literal"))
+
+	cases := []struct {
+		subject, action, resource string
+		want                      string
+	}{
+		{"svc-transcoder", "video:transcode", "/projects/42/assets/7", ALLOW},
+		{"svc-uploader", "video:delete", "/projects/42/assets/7", DENY},
+		{"svc-uploader", "audio:transcode", "/projects/42/assets/7", DENY},
+		{"svc-uploader", "video:transcode", "/projects/43/assets/7", DENY},
+		{"user-jack", "video:transcode", "/projects/42/assets/7", DENY},
+		{"svc-transcoder", "video:publish", "/projects/7", "publish"},
+		{"svc-transcoder", "video:publish", "/projects/x7", DENY},
+		// '\\' escapes the following character, '?' matches any one, even '/'
+		{"svc-backup", "backup", `C:\docs`, "escaped"},
+		{"svc-backup", "backup", `C:docs`, DENY},
+		{"svc-backup", "restore", "/backups/7", "single"},
+		{"svc-backup", "restore", "/backups//", "single"},
+		{"svc-backup", "restore", "/backups/42", DENY},
+		// without AddGlobRule the metacharacters are literal
+		{"admin", "video:transcode", "/projects/42/assets/7", DENY},
+		{"admin", "video:*", "/projects/42/assets/7", "literal"},
+	}
+	for _, c := range cases {
+		if got := rs.Query(c.subject, c.action, c.resource); got != c.want {
+			t.Errorf("Query(%q, %q, %q): got %q want %q", c.subject, c.action, c.resource, got, c.want)
+		}
+	}
+}
+
+func TestAddGlobRuleBadPattern(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	for _, pattern := range []string{"video:[", "video:[]", `video:\`} {
+		if _, err := rs.AddGlobRule(&User{}, pattern, nil, effectMatcher(ALLOW)); err != ErrBadPattern {
+			t.Errorf("%q: got error %v want %v", pattern, err, ErrBadPattern)
+		}
+	}
+	if n := len(rs.byID); n != 0 {
+		t.Errorf("got %d rules after bad patterns", n)
+	}
+}
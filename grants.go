@@ -0,0 +1,174 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// grantKey identifies a temporary grant, see Grant.
+type grantKey struct {
+	subject, action, resource string
+}
+
+// grant is the effect of a temporary grant and the time it expires, zero if never.
+type grant struct {
+	effect  Effect
+	expires time.Time
+}
+
+func (g grant) validAt(t time.Time) bool {
+	return g.expires.IsZero() || t.Before(g.expires)
+}
+
+// GrantInfo describes a temporary grant, see Grants.
+type GrantInfo struct {
+	SubjectKey  string
+	Action      string
+	ResourceKey string
+	Effect      Effect
+	// Expires is the time the grant stops applying, zero if it doesn't expire.
+	Expires time.Time
+}
+
+// Grant gives effect to the subject with subjectKey for the action on the resource
+// with resourceKey, for ttl according to the RuleSet Now clock, or until revoked if
+// ttl is not positive. The keys are the strings themselves for string subjects and
+// resources, and the PermKey of the Identifiable ones. Granting the same triple again
+// replaces the grant.
+//
+// The grants are consulted before the rules, or after them when GrantsAfterRules is
+// set, and in both cases before the roles. An expired grant stops applying right
+// away, and is removed by SweepGrants. The decisions produced by the grants have
+// Granted set, and are not cached.
+func (ruleSet *RuleSet) Grant(subjectKey string, action string, resourceKey string, effect string, ttl time.Duration) {
+	g := grant{effect: effect}
+	if ttl > 0 {
+		g.expires = ruleSet.now().Add(ttl)
+	}
+	ruleSet.updateGrants(func(grants map[grantKey]grant) bool {
+		grants[grantKey{subjectKey, action, resourceKey}] = g
+		return true
+	})
+}
+
+// Revoke cancels the grant for the triple, returning false if there was none.
+func (ruleSet *RuleSet) Revoke(subjectKey string, action string, resourceKey string) bool {
+	key := grantKey{subjectKey, action, resourceKey}
+	if _, ok := ruleSet.current().grants[key]; !ok {
+		return false
+	}
+	revoked := false
+	ruleSet.updateGrants(func(grants map[grantKey]grant) bool {
+		_, revoked = grants[key]
+		delete(grants, key)
+		return revoked
+	})
+	return revoked
+}
+
+// Grants returns the grants not yet expired, ordered by subject, action and resource.
+func (ruleSet *RuleSet) Grants() []GrantInfo {
+	now := ruleSet.now()
+	var infos []GrantInfo
+	for key, g := range ruleSet.current().grants {
+		if g.validAt(now) {
+			infos = append(infos, GrantInfo{SubjectKey: key.subject, Action: key.action, ResourceKey: key.resource, Effect: g.effect, Expires: g.expires})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.SubjectKey != b.SubjectKey {
+			return a.SubjectKey < b.SubjectKey
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.ResourceKey < b.ResourceKey
+	})
+	return infos
+}
+
+// SweepGrants removes the expired grants, returning how many were removed.
+func (ruleSet *RuleSet) SweepGrants() int {
+	now := ruleSet.now()
+	removed := 0
+	ruleSet.updateGrants(func(grants map[grantKey]grant) bool {
+		for key, g := range grants {
+			if !g.validAt(now) {
+				delete(grants, key)
+				removed++
+			}
+		}
+		return removed > 0
+	})
+	return removed
+}
+
+// SweepGrantsEvery calls SweepGrants every interval until ctx is done. It blocks, so
+// it is usually run in its own goroutine:
+//
+//	go rs.SweepGrantsEvery(ctx, time.Minute)
+func (ruleSet *RuleSet) SweepGrantsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ruleSet.SweepGrants()
+		}
+	}
+}
+
+// updateGrants publishes a copy of the grants changed by fn, unless fn returns false.
+func (ruleSet *RuleSet) updateGrants(fn func(grants map[grantKey]grant) bool) {
+	ruleSet.update(func(w *tableWriter) {
+		grants := make(map[grantKey]grant, len(w.table.grants)+1)
+		for key, g := range w.table.grants {
+			grants[key] = g
+		}
+		if fn(grants) {
+			w.table.grants = grants
+		}
+	})
+}
+
+// valueKey returns the key of a string or Identifiable value.
+func valueKey(value interface{}) (string, bool) {
+	if key, ok := value.(string); ok {
+		return key, true
+	}
+	return permKey(value)
+}
+
+// grant returns the decision of the grant applying to the query, if any.
+func (ev *evaluation) grant() (Decision, bool) {
+	grants := ev.table.grants
+	if len(grants) == 0 {
+		return Decision{}, false
+	}
+	action, ok := ev.action.(string)
+	if !ok {
+		return Decision{}, false
+	}
+	subject, ok := valueKey(ev.subject)
+	if !ok {
+		return Decision{}, false
+	}
+	resource, ok := valueKey(ev.resource)
+	if !ok {
+		return Decision{}, false
+	}
+	g, ok := grants[grantKey{subject, action, resource}]
+	if !ok || !g.validAt(ev.now()) {
+		return Decision{}, false
+	}
+	return Decision{Effect: g.effect, Granted: true}, true
+}
@@ -0,0 +1,77 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGrant(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	rs := NewRuleSet(DENY)
+	rs.Now = func() time.Time { return now }
+	rs.WithCache(100, nil)
+	rs.AddRule("", "modify", "", effectMatcher(DENY))
+
+	rs.Grant("jack", "modify", "playlist-9374", ALLOW, 24*time.Hour)
+	d := rs.QueryExplain("jack", "modify", "playlist-9374")
+	if d.Effect != ALLOW || !d.Granted || d.Rule != nil {
+		t.Errorf("got %+v want the grant", d)
+	}
+	if got := rs.Query("jack", "modify", "playlist-6563"); got != DENY {
+		t.Errorf("got %q want %q for another playlist", got, DENY)
+	}
+	if got := rs.Query("jack", "view", "playlist-9374"); got != DENY {
+		t.Errorf("got %q want %q for another action", got, DENY)
+	}
+	want := []GrantInfo{{SubjectKey: "jack", Action: "modify", ResourceKey: "playlist-9374", Effect: ALLOW, Expires: now.Add(24 * time.Hour)}}
+	if got := rs.Grants(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got grants %+v want %+v", got, want)
+	}
+
+	// the grant stops applying as soon as it expires, despite the cache
+	now = now.Add(24*time.Hour - time.Second)
+	if !rs.IsAllowed("jack", "modify", "playlist-9374") {
+		t.Errorf("got the grant expired early")
+	}
+	now = now.Add(time.Second)
+	if d := rs.QueryExplain("jack", "modify", "playlist-9374"); d.Effect != DENY || d.Granted {
+		t.Errorf("got %+v want the grant expired", d)
+	}
+	if got := rs.Grants(); len(got) != 0 {
+		t.Errorf("got expired grants %+v", got)
+	}
+	if removed := rs.SweepGrants(); removed != 1 || len(rs.current().grants) != 0 {
+		t.Errorf("got %d grants removed, %d left want 1, 0", removed, len(rs.current().grants))
+	}
+}
+
+func TestGrantAfterRules(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&Account{}, "view", &Account{}, effectMatcher("log"))
+	john, doc := &Account{ID: "42", Name: "john"}, &Account{ID: "7"}
+	rs.Grant("42", "view", "7", ALLOW, 0)
+	rs.Grant("42", "modify", "7", ALLOW, 0)
+
+	if d := rs.QueryExplain(john, "view", doc); d.Effect != ALLOW || !d.Granted {
+		t.Errorf("got %+v want the grant before the rules", d)
+	}
+	rs.GrantsAfterRules = true
+	if d := rs.QueryExplain(john, "view", doc); d.Effect != "log" || d.Granted {
+		t.Errorf("got %+v want the rule before the grant", d)
+	}
+	if d := rs.QueryExplain(john, "modify", doc); d.Effect != ALLOW || !d.Granted {
+		t.Errorf("got %+v want the grant when no rule applies", d)
+	}
+
+	// the grants without ttl last until revoked
+	if !rs.Revoke("42", "modify", "7") || rs.Revoke("42", "modify", "7") {
+		t.Errorf("got the wrong results revoking the grant")
+	}
+	if rs.IsAllowed(john, "modify", doc) {
+		t.Errorf("got modify allowed after revoking the grant")
+	}
+	if removed := rs.SweepGrants(); removed != 0 {
+		t.Errorf("got %d grants removed want none", removed)
+	}
+}
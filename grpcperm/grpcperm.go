@@ -0,0 +1,118 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package grpcperm enforces go-perms rule sets on gRPC servers.
+package grpcperm
+
+import (
+	"context"
+	"errors"
+
+	perms "github.com/panta/go-perms"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SubjectFn extracts the subject of a query from the context of a call, for example
+// reading the credentials in its incoming metadata. An error aborts the call with
+// codes.Unauthenticated.
+type SubjectFn func(ctx context.Context) (interface{}, error)
+
+// ResourceFn extracts the resource of a query from a call. req is the request message
+// of unary calls, and nil for streams. An error aborts the call with its status, eg.
+// status.Error(codes.InvalidArgument, ...) for a malformed request, or else with
+// codes.Internal, eg. for a failure loading the resource.
+type ResourceFn func(ctx context.Context, req interface{}) (interface{}, error)
+
+type method struct {
+	action     interface{}
+	resourceFn ResourceFn
+}
+
+// Mapping maps the full names of gRPC methods (eg. "/pkg.Service/Method") to the
+// action and the resource of the queries authorizing their calls.
+// Methods not registered use their full name as action and a nil resource.
+// The zero value is ready to use; a Mapping must not be modified while serving.
+type Mapping struct {
+	methods map[string]method
+}
+
+// NewMapping returns an empty mapping.
+func NewMapping() *Mapping {
+	return &Mapping{}
+}
+
+// Register sets the action and resource extractor for fullMethod.
+// A nil action means the full method name, a nil resourceFn a nil resource.
+func (mapping *Mapping) Register(fullMethod string, action interface{}, resourceFn ResourceFn) *Mapping {
+	if mapping.methods == nil {
+		mapping.methods = make(map[string]method)
+	}
+	mapping.methods[fullMethod] = method{action: action, resourceFn: resourceFn}
+	return mapping
+}
+
+func (mapping *Mapping) authorize(ctx context.Context, rs *perms.RuleSet, subjectFn SubjectFn, fullMethod string, req interface{}) error {
+	subject, err := subjectFn(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	var action, resource interface{} = fullMethod, nil
+	if mapping != nil {
+		if m, ok := mapping.methods[fullMethod]; ok {
+			if m.action != nil {
+				action = m.action
+			}
+			if m.resourceFn != nil {
+				if resource, err = m.resourceFn(ctx, req); err != nil {
+					if _, ok := status.FromError(err); ok {
+						return err
+					}
+					return status.Error(codes.Internal, err.Error())
+				}
+			}
+		}
+	}
+
+	err = rs.EnforceCtx(ctx, subject, action, resource)
+	var denied *perms.PermissionDeniedError
+	if errors.As(err, &denied) {
+		return status.Errorf(codes.PermissionDenied, "perms: effect %q", denied.Effect)
+	}
+	if err != nil {
+		if code := status.FromContextError(err).Code(); code != codes.Unknown {
+			return status.Error(code, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns an interceptor querying rs before each unary call,
+// calling the handler only when the effect is allowed (see
+// perms.RuleSet.AllowedEffects). Otherwise the call fails with codes.PermissionDenied
+// and the effect in the status message.
+// mapping may be nil.
+func UnaryServerInterceptor(rs *perms.RuleSet, subjectFn SubjectFn, mapping *Mapping) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := mapping.authorize(ctx, rs, subjectFn, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor, for streaming calls.
+// The resource extractors receive a nil request.
+func StreamServerInterceptor(rs *perms.RuleSet, subjectFn SubjectFn, mapping *Mapping) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := mapping.authorize(ss.Context(), rs, subjectFn, info.FullMethod, nil); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,158 @@
+package grpcperm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type user struct {
+	Name string
+}
+
+func subjectFromMetadata(ctx context.Context) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	names := md.Get("user")
+	if len(names) == 0 {
+		return nil, errors.New("missing user")
+	}
+	return &user{Name: names[0]}, nil
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	// john can check the "videos" service, jack can watch everything
+	rs.AddRule(&user{}, "check", nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return subj.(*user).Name == "john" && res == "videos", perms.Allow, false
+		})
+	rs.AddRule(&user{}, "/grpc.health.v1.Health/Watch", nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return subj.(*user).Name == "jack", perms.Allow, false
+		})
+	return rs
+}
+
+func dial(t *testing.T, rs *perms.RuleSet, mapping *Mapping) healthpb.HealthClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(rs, subjectFromMetadata, mapping)),
+		grpc.StreamInterceptor(StreamServerInterceptor(rs, subjectFromMetadata, mapping)),
+	)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("videos", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("users", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func asUser(name string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "user", name)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	mapping := NewMapping().Register("/grpc.health.v1.Health/Check", "check",
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return req.(*healthpb.HealthCheckRequest).GetService(), nil
+		})
+	client := dial(t, newRuleSet(), mapping)
+
+	if _, err := client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"}); err != nil {
+		t.Errorf("got %v want no error", err)
+	}
+
+	_, err := client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "users"})
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Errorf("got code %v want %v", got, codes.PermissionDenied)
+	}
+	if got, want := status.Convert(err).Message(), `perms: effect "deny"`; got != want {
+		t.Errorf("got message %q want %q", got, want)
+	}
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "videos"})
+	if got := status.Code(err); got != codes.Unauthenticated {
+		t.Errorf("got code %v want %v", got, codes.Unauthenticated)
+	}
+}
+
+func TestUnaryServerInterceptorAllowedEffects(t *testing.T) {
+	rs := newRuleSet()
+	rs.AddRule(&user{}, "/grpc.health.v1.Health/Check", nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, "audit", false
+	})
+	client := dial(t, rs, nil)
+
+	_, err := client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"})
+	if got, want := status.Convert(err).Message(), `perms: effect "audit"`; status.Code(err) != codes.PermissionDenied || got != want {
+		t.Errorf("got %v want codes.PermissionDenied and %q", err, want)
+	}
+	rs.AllowedEffects = []perms.Effect{perms.Allow, "audit"}
+	if _, err := client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"}); err != nil {
+		t.Errorf("got %v want the allowed effect", err)
+	}
+}
+
+func TestUnaryServerInterceptorResourceError(t *testing.T) {
+	var resourceErr error
+	mapping := NewMapping().Register("/grpc.health.v1.Health/Check", "check",
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, resourceErr
+		})
+	client := dial(t, newRuleSet(), mapping)
+
+	// the errors keep their status, the others are internal
+	resourceErr = status.Error(codes.InvalidArgument, "no service")
+	_, err := client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"})
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("got code %v want %v", got, codes.InvalidArgument)
+	}
+	resourceErr = errors.New("database unavailable")
+	_, err = client.Check(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"})
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("got code %v want %v", got, codes.Internal)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	// without a mapping the action is the full method name
+	client := dial(t, newRuleSet(), nil)
+
+	stream, err := client.Watch(asUser("jack"), &healthpb.HealthCheckRequest{Service: "videos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Errorf("got %v want no error", err)
+	}
+
+	stream, err = client.Watch(asUser("john"), &healthpb.HealthCheckRequest{Service: "videos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v want code %v", err, codes.PermissionDenied)
+	}
+}
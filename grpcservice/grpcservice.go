@@ -0,0 +1,365 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package grpcservice serves a go-perms rule set as a central policy decision service,
+// callable from any language through the Perms gRPC service defined in
+// permspb/perms.proto, and provides a Go client for it.
+//
+// The subjects, actions and resources of the queries travel as permspb.Value: a string
+// key, or the name of a type registered in the TypeRegistry of the rule set along with
+// the JSON encoding of the value, decoded by the server into a new value of that type.
+//
+//	server := grpc.NewServer()
+//	permspb.RegisterPermsServer(server, grpcservice.NewServer(rs, nil))
+//
+// The Client implements perms.Querier, like a local RuleSet:
+//
+//	var querier perms.Querier = grpcservice.NewClient(conn, rs.Types())
+//	decision, err := querier.QueryDecision(ctx, user, "view", video)
+//
+// The calls are not authorized by the server: the rule management ones in particular
+// should be restricted, eg. with the interceptors of package grpcperm, or disabled with
+// Options.ReadOnly.
+package grpcservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	perms "github.com/panta/go-perms"
+	"github.com/panta/go-perms/grpcservice/permspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options configures a Server.
+type Options struct {
+	// ReadOnly rejects the calls changing the rules with codes.PermissionDenied.
+	ReadOnly bool
+}
+
+// Server is a permspb.PermsServer backed by a RuleSet.
+type Server struct {
+	permspb.UnimplementedPermsServer
+	rs       *perms.RuleSet
+	readOnly bool
+}
+
+// NewServer returns a server evaluating the queries with rs, and decoding their values
+// with its TypeRegistry. The options can be nil.
+func NewServer(rs *perms.RuleSet, options *Options) *Server {
+	server := &Server{rs: rs}
+	if options != nil {
+		server.readOnly = options.ReadOnly
+	}
+	return server
+}
+
+// Evaluate returns the decision of the query, see RuleSet.QueryDecision. Values of
+// unknown types are rejected with codes.InvalidArgument.
+func (server *Server) Evaluate(ctx context.Context, req *permspb.EvaluateRequest) (*permspb.Decision, error) {
+	var values [3]interface{}
+	for i, value := range []*permspb.Value{req.GetSubject(), req.GetAction(), req.GetResource()} {
+		decoded, err := server.decodeValue(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		values[i] = decoded
+	}
+	decision, err := server.rs.QueryDecision(ctx, values[0], values[1], values[2])
+	if err != nil {
+		return nil, statusError(err)
+	}
+	reply := &permspb.Decision{
+		Effect:    decision.Effect,
+		Reason:    decision.Reason,
+		Default:   decision.Default,
+		Role:      decision.Role,
+		Superuser: decision.Superuser,
+		Granted:   decision.Granted,
+		Revoked:   decision.Revoked,
+	}
+	if decision.Rule != nil {
+		reply.RuleId = string(decision.Rule.ID)
+	}
+	for _, obligation := range decision.Obligations {
+		encoded, err := json.Marshal(obligation.Value)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("grpcservice: obligation %s: %v", obligation.Name, err))
+		}
+		reply.Obligations = append(reply.Obligations, &permspb.Obligation{Name: obligation.Name, Json: encoded})
+	}
+	return reply, nil
+}
+
+// decodeValue returns the value of a query, see permspb.Value.
+func (server *Server) decodeValue(value *permspb.Value) (interface{}, error) {
+	switch kind := value.GetKind().(type) {
+	case *permspb.Value_Key:
+		return kind.Key, nil
+	case *permspb.Value_Typed:
+		typeName := kind.Typed.GetType()
+		t, ok := server.rs.Types().Lookup(typeName)
+		if !ok {
+			return nil, errors.New("grpcservice: unknown type " + typeName)
+		}
+		ptr := t.Kind() == reflect.Ptr
+		if ptr {
+			t = t.Elem()
+		}
+		v := reflect.New(t)
+		if data := kind.Typed.GetJson(); len(data) > 0 {
+			if err := json.Unmarshal(data, v.Interface()); err != nil {
+				return nil, errors.New("grpcservice: bad " + typeName + " value: " + err.Error())
+			}
+		}
+		if ptr {
+			return v.Interface(), nil
+		}
+		return v.Elem().Interface(), nil
+	}
+	return nil, nil
+}
+
+// AddDeclarativeRule adds the rule, see RuleSet.AddDeclarativeRule. A duplicate id is
+// rejected with codes.AlreadyExists, an invalid rule with codes.InvalidArgument.
+func (server *Server) AddDeclarativeRule(ctx context.Context, req *permspb.AddDeclarativeRuleRequest) (*permspb.AddDeclarativeRuleResponse, error) {
+	if server.readOnly {
+		return nil, status.Error(codes.PermissionDenied, "grpcservice: read only")
+	}
+	decl, err := declarativeRule(req.GetRule())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	id, err := server.rs.AddDeclarativeRule(decl)
+	if err == perms.ErrDuplicateRuleID {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &permspb.AddDeclarativeRuleResponse{Id: string(id)}, nil
+}
+
+// RemoveRule removes the rule, see RuleSet.RemoveRule. A missing rule is reported with
+// codes.NotFound.
+func (server *Server) RemoveRule(ctx context.Context, req *permspb.RemoveRuleRequest) (*permspb.RemoveRuleResponse, error) {
+	if server.readOnly {
+		return nil, status.Error(codes.PermissionDenied, "grpcservice: read only")
+	}
+	if err := server.rs.RemoveRule(perms.RuleID(req.GetId())); err == perms.ErrRuleNotFound {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &permspb.RemoveRuleResponse{}, nil
+}
+
+// ListRules returns the rules of the rule set, see RuleSet.Rules.
+func (server *Server) ListRules(ctx context.Context, req *permspb.ListRulesRequest) (*permspb.ListRulesResponse, error) {
+	decls := make(map[perms.RuleID]perms.DeclarativeRule)
+	for _, decl := range server.rs.DeclarativeRules() {
+		decls[decl.ID] = decl
+	}
+	reply := &permspb.ListRulesResponse{}
+	for _, info := range server.rs.Rules() {
+		rule := &permspb.Rule{
+			Id:           string(info.ID),
+			SubjectType:  server.typeName(info.SubjectType),
+			ResourceType: server.typeName(info.ResourceType),
+			Priority:     int32(info.Priority),
+			Enabled:      info.Enabled,
+			Name:         info.Name,
+			Description:  info.Description,
+			Tags:         info.Tags,
+		}
+		if info.ActionTemplate != nil {
+			rule.Action = fmt.Sprint(info.ActionTemplate)
+		}
+		if decl, ok := decls[info.ID]; ok {
+			rule.Declarative = declarativeMessage(decl)
+		}
+		reply.Rules = append(reply.Rules, rule)
+	}
+	return reply, nil
+}
+
+func (server *Server) typeName(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	if name, ok := server.rs.Types().Name(t); ok {
+		return name
+	}
+	return t.String()
+}
+
+// statusError converts the error of a query.
+func statusError(err error) error {
+	if code := status.FromContextError(err).Code(); code != codes.Unknown {
+		return status.Error(code, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func declarativeMessage(decl perms.DeclarativeRule) *permspb.DeclarativeRule {
+	msg := &permspb.DeclarativeRule{
+		Id:        string(decl.ID),
+		Subject:   decl.Subject,
+		Action:    decl.Action,
+		Resource:  decl.Resource,
+		Condition: decl.Condition,
+		Effect:    decl.Effect,
+		Quick:     decl.Quick,
+		Priority:  int32(decl.Priority),
+		Fields:    decl.Fields,
+	}
+	for _, cond := range decl.Conditions {
+		msg.Conditions = append(msg.Conditions, cond.String())
+	}
+	return msg
+}
+
+func declarativeRule(msg *permspb.DeclarativeRule) (perms.DeclarativeRule, error) {
+	decl := perms.DeclarativeRule{
+		ID:        perms.RuleID(msg.GetId()),
+		Subject:   msg.GetSubject(),
+		Action:    msg.GetAction(),
+		Resource:  msg.GetResource(),
+		Condition: msg.GetCondition(),
+		Effect:    msg.GetEffect(),
+		Quick:     msg.GetQuick(),
+		Priority:  int(msg.GetPriority()),
+		Fields:    msg.GetFields(),
+	}
+	for _, s := range msg.GetConditions() {
+		cond, err := perms.ParseCondition(s)
+		if err != nil {
+			return perms.DeclarativeRule{}, err
+		}
+		decl.Conditions = append(decl.Conditions, cond)
+	}
+	return decl, nil
+}
+
+// Client queries a remote rule set served by a Server. It is safe for concurrent use.
+type Client struct {
+	client permspb.PermsClient
+	types  *perms.TypeRegistry
+}
+
+var _ perms.Querier = (*Client)(nil)
+
+// NewClient returns a client calling the Perms service over conn. The values of the
+// queries are sent under the names their types are registered under in types, which
+// must match those of the server: usually the same registrations are run on both sides.
+func NewClient(conn grpc.ClientConnInterface, types *perms.TypeRegistry) *Client {
+	if types == nil {
+		types = perms.NewTypeRegistry()
+	}
+	return &Client{client: permspb.NewPermsClient(conn), types: types}
+}
+
+// QueryDecision evaluates the query on the server. Strings are sent as keys, the other
+// non nil values as typed values, which is an error for the types not registered. The
+// Rule of the Decision holds only the ID of the rule, and the values of the Obligations
+// are decoded from JSON, as maps, slices, strings, float64s and bools. On error the
+// Decision is the zero value.
+func (client *Client) QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (perms.Decision, error) {
+	req := &permspb.EvaluateRequest{}
+	for _, field := range []struct {
+		value   interface{}
+		encoded **permspb.Value
+	}{{subject, &req.Subject}, {action, &req.Action}, {resource, &req.Resource}} {
+		encoded, err := client.encodeValue(field.value)
+		if err != nil {
+			return perms.Decision{}, err
+		}
+		*field.encoded = encoded
+	}
+	reply, err := client.client.Evaluate(ctx, req)
+	if err != nil {
+		return perms.Decision{}, err
+	}
+	decision := perms.Decision{
+		Effect:    reply.GetEffect(),
+		Reason:    reply.GetReason(),
+		Default:   reply.GetDefault(),
+		Role:      reply.GetRole(),
+		Superuser: reply.GetSuperuser(),
+		Granted:   reply.GetGranted(),
+		Revoked:   reply.GetRevoked(),
+	}
+	if id := reply.GetRuleId(); id != "" {
+		decision.Rule = &perms.RuleInfo{ID: perms.RuleID(id)}
+	}
+	for _, obligation := range reply.GetObligations() {
+		var value interface{}
+		if err := json.Unmarshal(obligation.GetJson(), &value); err != nil {
+			return perms.Decision{}, fmt.Errorf("grpcservice: obligation %s: %v", obligation.GetName(), err)
+		}
+		decision.Obligations = append(decision.Obligations, perms.Obligation{Name: obligation.GetName(), Value: value})
+	}
+	return decision, nil
+}
+
+// IsAllowed reports whether the effect of the query is perms.Allow. Errors deny.
+func (client *Client) IsAllowed(ctx context.Context, subject interface{}, action interface{}, resource interface{}) bool {
+	decision, err := client.QueryDecision(ctx, subject, action, resource)
+	return err == nil && decision.Effect == perms.Allow
+}
+
+func (client *Client) encodeValue(value interface{}) (*permspb.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return &permspb.Value{}, nil
+	case string:
+		return &permspb.Value{Kind: &permspb.Value_Key{Key: v}}, nil
+	}
+	t := reflect.TypeOf(value)
+	name, ok := client.types.Name(t)
+	if !ok {
+		return nil, errors.New("grpcservice: unregistered type " + t.String())
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("grpcservice: %s value: %v", name, err)
+	}
+	return &permspb.Value{Kind: &permspb.Value_Typed{Typed: &permspb.TypedValue{Type: name, Json: encoded}}}, nil
+}
+
+// AddDeclarativeRule adds the rule to the remote rule set, and returns its id. It
+// returns perms.ErrDuplicateRuleID if the id is in use.
+func (client *Client) AddDeclarativeRule(ctx context.Context, decl perms.DeclarativeRule) (perms.RuleID, error) {
+	reply, err := client.client.AddDeclarativeRule(ctx, &permspb.AddDeclarativeRuleRequest{Rule: declarativeMessage(decl)})
+	if status.Code(err) == codes.AlreadyExists {
+		return "", perms.ErrDuplicateRuleID
+	} else if err != nil {
+		return "", err
+	}
+	return perms.RuleID(reply.GetId()), nil
+}
+
+// RemoveRule removes the rule from the remote rule set. It returns
+// perms.ErrRuleNotFound if there is no such rule.
+func (client *Client) RemoveRule(ctx context.Context, id perms.RuleID) error {
+	_, err := client.client.RemoveRule(ctx, &permspb.RemoveRuleRequest{Id: string(id)})
+	if status.Code(err) == codes.NotFound {
+		return perms.ErrRuleNotFound
+	}
+	return err
+}
+
+// ListRules returns the rules of the remote rule set, in insertion order.
+func (client *Client) ListRules(ctx context.Context) ([]*permspb.Rule, error) {
+	reply, err := client.client.ListRules(ctx, &permspb.ListRulesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return reply.GetRules(), nil
+}
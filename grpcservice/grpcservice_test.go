@@ -0,0 +1,228 @@
+package grpcservice
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+	"github.com/panta/go-perms/grpcservice/permspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type user struct {
+	Name string
+}
+
+type document struct {
+	Owner  string
+	Secret bool
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.RegisterType("User", &user{})
+	rs.RegisterType("Document", &document{})
+	if _, err := rs.AddDeclarativeRule(perms.DeclarativeRule{
+		ID:         "owner-view",
+		Subject:    "User",
+		Action:     "view",
+		Resource:   "Document",
+		Conditions: []perms.Condition{{Field: "resource.Owner", Ref: "subject.Name"}},
+		Effect:     perms.Allow,
+	}); err != nil {
+		panic(err)
+	}
+	// secret documents are watermarked
+	rs.AddRuleWithObligations(&user{}, "view", &document{},
+		func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, []perms.Obligation, error) {
+			doc := res.(*document)
+			if !doc.Secret || doc.Owner != subj.(*user).Name {
+				return false, "", false, nil, nil
+			}
+			return true, perms.Allow, false, []perms.Obligation{{Name: "watermark", Value: "confidential"}}, nil
+		}, perms.Named("secret"))
+	return rs
+}
+
+func dial(t *testing.T, rs *perms.RuleSet, options *Options) *Client {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	permspb.RegisterPermsServer(server, NewServer(rs, options))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn, rs.Types())
+}
+
+func TestEvaluate(t *testing.T) {
+	rs := newRuleSet()
+	client := dial(t, rs, nil)
+	ctx := context.Background()
+	john := &user{Name: "john"}
+
+	for _, tc := range []struct {
+		resource *document
+		effect   string
+	}{
+		{&document{Owner: "john"}, perms.Allow},
+		{&document{Owner: "jack"}, perms.Deny},
+		{&document{Owner: "john", Secret: true}, perms.Allow},
+	} {
+		remote, err := client.QueryDecision(ctx, john, "view", tc.resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, err := rs.QueryDecision(ctx, john, "view", tc.resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remote.Effect != tc.effect || remote.Effect != local.Effect || remote.Default != local.Default {
+			t.Errorf("%+v: got %+v want %+v", tc.resource, remote, local)
+		}
+		if (remote.Rule == nil) != (local.Rule == nil) || (remote.Rule != nil && remote.Rule.ID != local.Rule.ID) {
+			t.Errorf("%+v: got rule %v want %v", tc.resource, remote.Rule, local.Rule)
+		}
+	}
+
+	decision, err := client.QueryDecision(ctx, john, "view", &document{Owner: "john", Secret: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decision.Obligations) != 1 || decision.Obligations[0] != (perms.Obligation{Name: "watermark", Value: "confidential"}) {
+		t.Errorf("got obligations %v", decision.Obligations)
+	}
+	if !client.IsAllowed(ctx, john, "view", &document{Owner: "john"}) || client.IsAllowed(ctx, john, "edit", &document{Owner: "john"}) {
+		t.Errorf("got the wrong IsAllowed")
+	}
+
+	// the queriers are interchangeable
+	for _, querier := range []perms.Querier{rs, client} {
+		if decision, err := querier.QueryDecision(ctx, nil, "view", nil); err != nil || decision.Effect != perms.Deny || !decision.Default {
+			t.Errorf("%T: got %+v, %v want the default", querier, decision, err)
+		}
+	}
+
+	type unknown struct{}
+	if _, err := client.QueryDecision(ctx, john, "view", unknown{}); err == nil {
+		t.Errorf("got no error for an unregistered type")
+	}
+	other := NewClient(nil, nil)
+	other.client = client.client
+	other.types.RegisterType("Folder", &document{})
+	if _, err := other.QueryDecision(ctx, nil, "view", &document{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v want codes.InvalidArgument for a type unknown to the server", err)
+	}
+}
+
+func TestAddDeclarativeRule(t *testing.T) {
+	rs := newRuleSet()
+	client := dial(t, rs, nil)
+	ctx := context.Background()
+	john := &user{Name: "john"}
+
+	id, err := client.AddDeclarativeRule(ctx, perms.DeclarativeRule{
+		ID:         "public-view",
+		Subject:    "User",
+		Action:     "view",
+		Resource:   "Document",
+		Conditions: []perms.Condition{{Field: "resource.Owner", Value: "public"}},
+		Effect:     perms.Allow,
+	})
+	if err != nil || id != "public-view" {
+		t.Fatalf("got %q, %v", id, err)
+	}
+	if !rs.IsAllowed(john, "view", &document{Owner: "public"}) {
+		t.Errorf("got the rule not added")
+	}
+	if _, err := client.AddDeclarativeRule(ctx, perms.DeclarativeRule{ID: "public-view", Effect: perms.Allow}); err != perms.ErrDuplicateRuleID {
+		t.Errorf("got %v want ErrDuplicateRuleID", err)
+	}
+	if _, err := client.AddDeclarativeRule(ctx, perms.DeclarativeRule{Subject: "Unknown", Effect: perms.Allow}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v want codes.InvalidArgument", err)
+	}
+
+	// a field rule takes no part in the decision of the whole resource
+	if _, err := client.AddDeclarativeRule(ctx, perms.DeclarativeRule{
+		ID:       "secret-field",
+		Subject:  "User",
+		Action:   "view",
+		Resource: "Document",
+		Effect:   perms.Allow,
+		Fields:   []string{"Secret"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	doc := &document{Owner: "jack"}
+	if rs.IsAllowed(john, "view", doc) {
+		t.Errorf("got the field rule added as a rule of the whole resource")
+	}
+	if fields, err := rs.QueryFields(john, "view", doc); err != nil || fields["Secret"] != perms.Allow || fields["Owner"] != perms.Deny {
+		t.Errorf("got %v, %v want only Secret allowed", fields, err)
+	}
+	rules, err := client.ListRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range rules {
+		if rule.GetId() == "secret-field" {
+			if fields := rule.GetDeclarative().GetFields(); len(fields) != 1 || fields[0] != "Secret" {
+				t.Errorf("got fields %v listed want [Secret]", fields)
+			}
+		}
+	}
+
+	if err := client.RemoveRule(ctx, "public-view"); err != nil {
+		t.Fatal(err)
+	}
+	if rs.IsAllowed(john, "view", &document{Owner: "public"}) {
+		t.Errorf("got the rule not removed")
+	}
+	if err := client.RemoveRule(ctx, "public-view"); err != perms.ErrRuleNotFound {
+		t.Errorf("got %v want ErrRuleNotFound", err)
+	}
+
+	readOnly := dial(t, rs, &Options{ReadOnly: true})
+	if _, err := readOnly.AddDeclarativeRule(ctx, perms.DeclarativeRule{Effect: perms.Allow}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v want codes.PermissionDenied", err)
+	}
+	if err := readOnly.RemoveRule(ctx, "owner-view"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v want codes.PermissionDenied", err)
+	}
+}
+
+func TestListRules(t *testing.T) {
+	rs := newRuleSet()
+	client := dial(t, rs, nil)
+	rules, err := client.ListRules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules want 2", len(rules))
+	}
+	owner, secret := rules[0], rules[1]
+	if owner.GetId() != "owner-view" || owner.GetSubjectType() != "User" || owner.GetAction() != "view" || owner.GetResourceType() != "Document" || !owner.GetEnabled() {
+		t.Errorf("got %v", owner)
+	}
+	decl := owner.GetDeclarative()
+	if decl == nil || decl.GetEffect() != perms.Allow || len(decl.GetConditions()) != 1 || decl.GetConditions()[0] != "resource.Owner == subject.Name" {
+		t.Errorf("got declarative %v", decl)
+	}
+	if secret.GetName() != "secret" || secret.GetDeclarative() != nil {
+		t.Errorf("got %v", secret)
+	}
+}
@@ -0,0 +1,1030 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: perms.proto
+
+package permspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Value is the subject, action or resource of a query. A Value with neither field set
+// is nil.
+type Value struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*Value_Typed
+	//	*Value_Key
+	Kind          isValue_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Value) Reset() {
+	*x = Value{}
+	mi := &file_perms_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Value) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Value) ProtoMessage() {}
+
+func (x *Value) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Value.ProtoReflect.Descriptor instead.
+func (*Value) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Value) GetKind() isValue_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *Value) GetTyped() *TypedValue {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Typed); ok {
+			return x.Typed
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetKey() string {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Key); ok {
+			return x.Key
+		}
+	}
+	return ""
+}
+
+type isValue_Kind interface {
+	isValue_Kind()
+}
+
+type Value_Typed struct {
+	// Typed is a value of a type registered in the TypeRegistry of the rule set.
+	Typed *TypedValue `protobuf:"bytes,1,opt,name=typed,proto3,oneof"`
+}
+
+type Value_Key struct {
+	// Key is a string.
+	Key string `protobuf:"bytes,2,opt,name=key,proto3,oneof"`
+}
+
+func (*Value_Typed) isValue_Kind() {}
+
+func (*Value_Key) isValue_Kind() {}
+
+// TypedValue is a value of a registered type, encoded as JSON.
+type TypedValue struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Type is the name the type is registered under.
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// Json is the JSON encoding of the value.
+	Json          []byte `protobuf:"bytes,2,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TypedValue) Reset() {
+	*x = TypedValue{}
+	mi := &file_perms_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TypedValue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TypedValue) ProtoMessage() {}
+
+func (x *TypedValue) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TypedValue.ProtoReflect.Descriptor instead.
+func (*TypedValue) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TypedValue) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TypedValue) GetJson() []byte {
+	if x != nil {
+		return x.Json
+	}
+	return nil
+}
+
+type EvaluateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subject       *Value                 `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
+	Action        *Value                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	Resource      *Value                 `protobuf:"bytes,3,opt,name=resource,proto3" json:"resource,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EvaluateRequest) Reset() {
+	*x = EvaluateRequest{}
+	mi := &file_perms_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EvaluateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvaluateRequest) ProtoMessage() {}
+
+func (x *EvaluateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvaluateRequest.ProtoReflect.Descriptor instead.
+func (*EvaluateRequest) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *EvaluateRequest) GetSubject() *Value {
+	if x != nil {
+		return x.Subject
+	}
+	return nil
+}
+
+func (x *EvaluateRequest) GetAction() *Value {
+	if x != nil {
+		return x.Action
+	}
+	return nil
+}
+
+func (x *EvaluateRequest) GetResource() *Value {
+	if x != nil {
+		return x.Resource
+	}
+	return nil
+}
+
+// Decision is the outcome of a query, see perms.Decision.
+type Decision struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Effect string                 `protobuf:"bytes,1,opt,name=effect,proto3" json:"effect,omitempty"`
+	// RuleId is the id of the rule producing the effect, empty when default is true.
+	RuleId        string        `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	Reason        string        `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	Obligations   []*Obligation `protobuf:"bytes,4,rep,name=obligations,proto3" json:"obligations,omitempty"`
+	Default       bool          `protobuf:"varint,5,opt,name=default,proto3" json:"default,omitempty"`
+	Role          string        `protobuf:"bytes,6,opt,name=role,proto3" json:"role,omitempty"`
+	Superuser     bool          `protobuf:"varint,7,opt,name=superuser,proto3" json:"superuser,omitempty"`
+	Granted       bool          `protobuf:"varint,8,opt,name=granted,proto3" json:"granted,omitempty"`
+	Revoked       bool          `protobuf:"varint,9,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Decision) Reset() {
+	*x = Decision{}
+	mi := &file_perms_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Decision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decision) ProtoMessage() {}
+
+func (x *Decision) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decision.ProtoReflect.Descriptor instead.
+func (*Decision) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Decision) GetEffect() string {
+	if x != nil {
+		return x.Effect
+	}
+	return ""
+}
+
+func (x *Decision) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *Decision) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Decision) GetObligations() []*Obligation {
+	if x != nil {
+		return x.Obligations
+	}
+	return nil
+}
+
+func (x *Decision) GetDefault() bool {
+	if x != nil {
+		return x.Default
+	}
+	return false
+}
+
+func (x *Decision) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *Decision) GetSuperuser() bool {
+	if x != nil {
+		return x.Superuser
+	}
+	return false
+}
+
+func (x *Decision) GetGranted() bool {
+	if x != nil {
+		return x.Granted
+	}
+	return false
+}
+
+func (x *Decision) GetRevoked() bool {
+	if x != nil {
+		return x.Revoked
+	}
+	return false
+}
+
+// Obligation is an obligation attached to the effect, with its value encoded as JSON.
+type Obligation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Json          []byte                 `protobuf:"bytes,2,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Obligation) Reset() {
+	*x = Obligation{}
+	mi := &file_perms_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Obligation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Obligation) ProtoMessage() {}
+
+func (x *Obligation) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Obligation.ProtoReflect.Descriptor instead.
+func (*Obligation) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Obligation) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Obligation) GetJson() []byte {
+	if x != nil {
+		return x.Json
+	}
+	return nil
+}
+
+// DeclarativeRule is a perms.DeclarativeRule, with the conditions in their string form
+// (see perms.ParseCondition). A rule with fields is a field rule, see perms.QueryFields.
+type DeclarativeRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Subject       string                 `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	Action        string                 `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	Resource      string                 `protobuf:"bytes,4,opt,name=resource,proto3" json:"resource,omitempty"`
+	Conditions    []string               `protobuf:"bytes,5,rep,name=conditions,proto3" json:"conditions,omitempty"`
+	Condition     string                 `protobuf:"bytes,6,opt,name=condition,proto3" json:"condition,omitempty"`
+	Effect        string                 `protobuf:"bytes,7,opt,name=effect,proto3" json:"effect,omitempty"`
+	Quick         bool                   `protobuf:"varint,8,opt,name=quick,proto3" json:"quick,omitempty"`
+	Priority      int32                  `protobuf:"varint,9,opt,name=priority,proto3" json:"priority,omitempty"`
+	Fields        []string               `protobuf:"bytes,10,rep,name=fields,proto3" json:"fields,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeclarativeRule) Reset() {
+	*x = DeclarativeRule{}
+	mi := &file_perms_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeclarativeRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeclarativeRule) ProtoMessage() {}
+
+func (x *DeclarativeRule) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeclarativeRule.ProtoReflect.Descriptor instead.
+func (*DeclarativeRule) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeclarativeRule) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetResource() string {
+	if x != nil {
+		return x.Resource
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetConditions() []string {
+	if x != nil {
+		return x.Conditions
+	}
+	return nil
+}
+
+func (x *DeclarativeRule) GetCondition() string {
+	if x != nil {
+		return x.Condition
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetEffect() string {
+	if x != nil {
+		return x.Effect
+	}
+	return ""
+}
+
+func (x *DeclarativeRule) GetQuick() bool {
+	if x != nil {
+		return x.Quick
+	}
+	return false
+}
+
+func (x *DeclarativeRule) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *DeclarativeRule) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+type AddDeclarativeRuleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rule          *DeclarativeRule       `protobuf:"bytes,1,opt,name=rule,proto3" json:"rule,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddDeclarativeRuleRequest) Reset() {
+	*x = AddDeclarativeRuleRequest{}
+	mi := &file_perms_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddDeclarativeRuleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddDeclarativeRuleRequest) ProtoMessage() {}
+
+func (x *AddDeclarativeRuleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddDeclarativeRuleRequest.ProtoReflect.Descriptor instead.
+func (*AddDeclarativeRuleRequest) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AddDeclarativeRuleRequest) GetRule() *DeclarativeRule {
+	if x != nil {
+		return x.Rule
+	}
+	return nil
+}
+
+type AddDeclarativeRuleResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddDeclarativeRuleResponse) Reset() {
+	*x = AddDeclarativeRuleResponse{}
+	mi := &file_perms_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddDeclarativeRuleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddDeclarativeRuleResponse) ProtoMessage() {}
+
+func (x *AddDeclarativeRuleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddDeclarativeRuleResponse.ProtoReflect.Descriptor instead.
+func (*AddDeclarativeRuleResponse) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *AddDeclarativeRuleResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type RemoveRuleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveRuleRequest) Reset() {
+	*x = RemoveRuleRequest{}
+	mi := &file_perms_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveRuleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveRuleRequest) ProtoMessage() {}
+
+func (x *RemoveRuleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveRuleRequest.ProtoReflect.Descriptor instead.
+func (*RemoveRuleRequest) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RemoveRuleRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type RemoveRuleResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveRuleResponse) Reset() {
+	*x = RemoveRuleResponse{}
+	mi := &file_perms_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveRuleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveRuleResponse) ProtoMessage() {}
+
+func (x *RemoveRuleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveRuleResponse.ProtoReflect.Descriptor instead.
+func (*RemoveRuleResponse) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{9}
+}
+
+type ListRulesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesRequest) Reset() {
+	*x = ListRulesRequest{}
+	mi := &file_perms_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesRequest) ProtoMessage() {}
+
+func (x *ListRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesRequest.ProtoReflect.Descriptor instead.
+func (*ListRulesRequest) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{10}
+}
+
+// Rule describes a rule, see perms.RuleInfo. The types are the names they are
+// registered under, or their Go names, and are empty for a "jolly".
+type Rule struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	SubjectType  string                 `protobuf:"bytes,2,opt,name=subject_type,json=subjectType,proto3" json:"subject_type,omitempty"`
+	Action       string                 `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	ResourceType string                 `protobuf:"bytes,4,opt,name=resource_type,json=resourceType,proto3" json:"resource_type,omitempty"`
+	Priority     int32                  `protobuf:"varint,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	Enabled      bool                   `protobuf:"varint,6,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	Name         string                 `protobuf:"bytes,7,opt,name=name,proto3" json:"name,omitempty"`
+	Description  string                 `protobuf:"bytes,8,opt,name=description,proto3" json:"description,omitempty"`
+	Tags         []string               `protobuf:"bytes,9,rep,name=tags,proto3" json:"tags,omitempty"`
+	// Declarative is set for the declarative rules.
+	Declarative   *DeclarativeRule `protobuf:"bytes,10,opt,name=declarative,proto3" json:"declarative,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rule) Reset() {
+	*x = Rule{}
+	mi := &file_perms_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rule) ProtoMessage() {}
+
+func (x *Rule) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rule.ProtoReflect.Descriptor instead.
+func (*Rule) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Rule) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Rule) GetSubjectType() string {
+	if x != nil {
+		return x.SubjectType
+	}
+	return ""
+}
+
+func (x *Rule) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Rule) GetResourceType() string {
+	if x != nil {
+		return x.ResourceType
+	}
+	return ""
+}
+
+func (x *Rule) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Rule) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+func (x *Rule) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Rule) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Rule) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Rule) GetDeclarative() *DeclarativeRule {
+	if x != nil {
+		return x.Declarative
+	}
+	return nil
+}
+
+type ListRulesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rules         []*Rule                `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesResponse) Reset() {
+	*x = ListRulesResponse{}
+	mi := &file_perms_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesResponse) ProtoMessage() {}
+
+func (x *ListRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_perms_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesResponse.ProtoReflect.Descriptor instead.
+func (*ListRulesResponse) Descriptor() ([]byte, []int) {
+	return file_perms_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListRulesResponse) GetRules() []*Rule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+var File_perms_proto protoreflect.FileDescriptor
+
+const file_perms_proto_rawDesc = "" +
+	"\n" +
+	"\vperms.proto\x12\bperms.v1\"Q\n" +
+	"\x05Value\x12,\n" +
+	"\x05typed\x18\x01 \x01(\v2\x14.perms.v1.TypedValueH\x00R\x05typed\x12\x12\n" +
+	"\x03key\x18\x02 \x01(\tH\x00R\x03keyB\x06\n" +
+	"\x04kind\"4\n" +
+	"\n" +
+	"TypedValue\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x12\n" +
+	"\x04json\x18\x02 \x01(\fR\x04json\"\x92\x01\n" +
+	"\x0fEvaluateRequest\x12)\n" +
+	"\asubject\x18\x01 \x01(\v2\x0f.perms.v1.ValueR\asubject\x12'\n" +
+	"\x06action\x18\x02 \x01(\v2\x0f.perms.v1.ValueR\x06action\x12+\n" +
+	"\bresource\x18\x03 \x01(\v2\x0f.perms.v1.ValueR\bresource\"\x8b\x02\n" +
+	"\bDecision\x12\x16\n" +
+	"\x06effect\x18\x01 \x01(\tR\x06effect\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x126\n" +
+	"\vobligations\x18\x04 \x03(\v2\x14.perms.v1.ObligationR\vobligations\x12\x18\n" +
+	"\adefault\x18\x05 \x01(\bR\adefault\x12\x12\n" +
+	"\x04role\x18\x06 \x01(\tR\x04role\x12\x1c\n" +
+	"\tsuperuser\x18\a \x01(\bR\tsuperuser\x12\x18\n" +
+	"\agranted\x18\b \x01(\bR\agranted\x12\x18\n" +
+	"\arevoked\x18\t \x01(\bR\arevoked\"4\n" +
+	"\n" +
+	"Obligation\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04json\x18\x02 \x01(\fR\x04json\"\x8f\x02\n" +
+	"\x0fDeclarativeRule\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\asubject\x18\x02 \x01(\tR\asubject\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12\x1a\n" +
+	"\bresource\x18\x04 \x01(\tR\bresource\x12\x1e\n" +
+	"\n" +
+	"conditions\x18\x05 \x03(\tR\n" +
+	"conditions\x12\x1c\n" +
+	"\tcondition\x18\x06 \x01(\tR\tcondition\x12\x16\n" +
+	"\x06effect\x18\a \x01(\tR\x06effect\x12\x14\n" +
+	"\x05quick\x18\b \x01(\bR\x05quick\x12\x1a\n" +
+	"\bpriority\x18\t \x01(\x05R\bpriority\x12\x16\n" +
+	"\x06fields\x18\n" +
+	" \x03(\tR\x06fields\"J\n" +
+	"\x19AddDeclarativeRuleRequest\x12-\n" +
+	"\x04rule\x18\x01 \x01(\v2\x19.perms.v1.DeclarativeRuleR\x04rule\",\n" +
+	"\x1aAddDeclarativeRuleResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"#\n" +
+	"\x11RemoveRuleRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x14\n" +
+	"\x12RemoveRuleResponse\"\x12\n" +
+	"\x10ListRulesRequest\"\xb3\x02\n" +
+	"\x04Rule\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
+	"\fsubject_type\x18\x02 \x01(\tR\vsubjectType\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12#\n" +
+	"\rresource_type\x18\x04 \x01(\tR\fresourceType\x12\x1a\n" +
+	"\bpriority\x18\x05 \x01(\x05R\bpriority\x12\x18\n" +
+	"\aenabled\x18\x06 \x01(\bR\aenabled\x12\x12\n" +
+	"\x04name\x18\a \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\b \x01(\tR\vdescription\x12\x12\n" +
+	"\x04tags\x18\t \x03(\tR\x04tags\x12;\n" +
+	"\vdeclarative\x18\n" +
+	" \x01(\v2\x19.perms.v1.DeclarativeRuleR\vdeclarative\"9\n" +
+	"\x11ListRulesResponse\x12$\n" +
+	"\x05rules\x18\x01 \x03(\v2\x0e.perms.v1.RuleR\x05rules2\xb2\x02\n" +
+	"\x05Perms\x129\n" +
+	"\bEvaluate\x12\x19.perms.v1.EvaluateRequest\x1a\x12.perms.v1.Decision\x12_\n" +
+	"\x12AddDeclarativeRule\x12#.perms.v1.AddDeclarativeRuleRequest\x1a$.perms.v1.AddDeclarativeRuleResponse\x12G\n" +
+	"\n" +
+	"RemoveRule\x12\x1b.perms.v1.RemoveRuleRequest\x1a\x1c.perms.v1.RemoveRuleResponse\x12D\n" +
+	"\tListRules\x12\x1a.perms.v1.ListRulesRequest\x1a\x1b.perms.v1.ListRulesResponseB/Z-github.com/panta/go-perms/grpcservice/permspbb\x06proto3"
+
+var (
+	file_perms_proto_rawDescOnce sync.Once
+	file_perms_proto_rawDescData []byte
+)
+
+func file_perms_proto_rawDescGZIP() []byte {
+	file_perms_proto_rawDescOnce.Do(func() {
+		file_perms_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_perms_proto_rawDesc), len(file_perms_proto_rawDesc)))
+	})
+	return file_perms_proto_rawDescData
+}
+
+var file_perms_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_perms_proto_goTypes = []any{
+	(*Value)(nil),                      // 0: perms.v1.Value
+	(*TypedValue)(nil),                 // 1: perms.v1.TypedValue
+	(*EvaluateRequest)(nil),            // 2: perms.v1.EvaluateRequest
+	(*Decision)(nil),                   // 3: perms.v1.Decision
+	(*Obligation)(nil),                 // 4: perms.v1.Obligation
+	(*DeclarativeRule)(nil),            // 5: perms.v1.DeclarativeRule
+	(*AddDeclarativeRuleRequest)(nil),  // 6: perms.v1.AddDeclarativeRuleRequest
+	(*AddDeclarativeRuleResponse)(nil), // 7: perms.v1.AddDeclarativeRuleResponse
+	(*RemoveRuleRequest)(nil),          // 8: perms.v1.RemoveRuleRequest
+	(*RemoveRuleResponse)(nil),         // 9: perms.v1.RemoveRuleResponse
+	(*ListRulesRequest)(nil),           // 10: perms.v1.ListRulesRequest
+	(*Rule)(nil),                       // 11: perms.v1.Rule
+	(*ListRulesResponse)(nil),          // 12: perms.v1.ListRulesResponse
+}
+var file_perms_proto_depIdxs = []int32{
+	1,  // 0: perms.v1.Value.typed:type_name -> perms.v1.TypedValue
+	0,  // 1: perms.v1.EvaluateRequest.subject:type_name -> perms.v1.Value
+	0,  // 2: perms.v1.EvaluateRequest.action:type_name -> perms.v1.Value
+	0,  // 3: perms.v1.EvaluateRequest.resource:type_name -> perms.v1.Value
+	4,  // 4: perms.v1.Decision.obligations:type_name -> perms.v1.Obligation
+	5,  // 5: perms.v1.AddDeclarativeRuleRequest.rule:type_name -> perms.v1.DeclarativeRule
+	5,  // 6: perms.v1.Rule.declarative:type_name -> perms.v1.DeclarativeRule
+	11, // 7: perms.v1.ListRulesResponse.rules:type_name -> perms.v1.Rule
+	2,  // 8: perms.v1.Perms.Evaluate:input_type -> perms.v1.EvaluateRequest
+	6,  // 9: perms.v1.Perms.AddDeclarativeRule:input_type -> perms.v1.AddDeclarativeRuleRequest
+	8,  // 10: perms.v1.Perms.RemoveRule:input_type -> perms.v1.RemoveRuleRequest
+	10, // 11: perms.v1.Perms.ListRules:input_type -> perms.v1.ListRulesRequest
+	3,  // 12: perms.v1.Perms.Evaluate:output_type -> perms.v1.Decision
+	7,  // 13: perms.v1.Perms.AddDeclarativeRule:output_type -> perms.v1.AddDeclarativeRuleResponse
+	9,  // 14: perms.v1.Perms.RemoveRule:output_type -> perms.v1.RemoveRuleResponse
+	12, // 15: perms.v1.Perms.ListRules:output_type -> perms.v1.ListRulesResponse
+	12, // [12:16] is the sub-list for method output_type
+	8,  // [8:12] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_perms_proto_init() }
+func file_perms_proto_init() {
+	if File_perms_proto != nil {
+		return
+	}
+	file_perms_proto_msgTypes[0].OneofWrappers = []any{
+		(*Value_Typed)(nil),
+		(*Value_Key)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_perms_proto_rawDesc), len(file_perms_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_perms_proto_goTypes,
+		DependencyIndexes: file_perms_proto_depIdxs,
+		MessageInfos:      file_perms_proto_msgTypes,
+	}.Build()
+	File_perms_proto = out.File
+	file_perms_proto_goTypes = nil
+	file_perms_proto_depIdxs = nil
+}
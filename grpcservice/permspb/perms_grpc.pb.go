@@ -0,0 +1,252 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: perms.proto
+
+package permspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Perms_Evaluate_FullMethodName           = "/perms.v1.Perms/Evaluate"
+	Perms_AddDeclarativeRule_FullMethodName = "/perms.v1.Perms/AddDeclarativeRule"
+	Perms_RemoveRule_FullMethodName         = "/perms.v1.Perms/RemoveRule"
+	Perms_ListRules_FullMethodName          = "/perms.v1.Perms/ListRules"
+)
+
+// PermsClient is the client API for Perms service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Perms evaluates the queries of a go-perms rule set and manages its declarative rules.
+type PermsClient interface {
+	// Evaluate returns the decision of a query.
+	Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*Decision, error)
+	// AddDeclarativeRule adds a declarative rule.
+	AddDeclarativeRule(ctx context.Context, in *AddDeclarativeRuleRequest, opts ...grpc.CallOption) (*AddDeclarativeRuleResponse, error)
+	// RemoveRule removes a rule.
+	RemoveRule(ctx context.Context, in *RemoveRuleRequest, opts ...grpc.CallOption) (*RemoveRuleResponse, error)
+	// ListRules lists the rules, in insertion order.
+	ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error)
+}
+
+type permsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPermsClient(cc grpc.ClientConnInterface) PermsClient {
+	return &permsClient{cc}
+}
+
+func (c *permsClient) Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*Decision, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Decision)
+	err := c.cc.Invoke(ctx, Perms_Evaluate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permsClient) AddDeclarativeRule(ctx context.Context, in *AddDeclarativeRuleRequest, opts ...grpc.CallOption) (*AddDeclarativeRuleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddDeclarativeRuleResponse)
+	err := c.cc.Invoke(ctx, Perms_AddDeclarativeRule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permsClient) RemoveRule(ctx context.Context, in *RemoveRuleRequest, opts ...grpc.CallOption) (*RemoveRuleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RemoveRuleResponse)
+	err := c.cc.Invoke(ctx, Perms_RemoveRule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *permsClient) ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRulesResponse)
+	err := c.cc.Invoke(ctx, Perms_ListRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PermsServer is the server API for Perms service.
+// All implementations must embed UnimplementedPermsServer
+// for forward compatibility.
+//
+// Perms evaluates the queries of a go-perms rule set and manages its declarative rules.
+type PermsServer interface {
+	// Evaluate returns the decision of a query.
+	Evaluate(context.Context, *EvaluateRequest) (*Decision, error)
+	// AddDeclarativeRule adds a declarative rule.
+	AddDeclarativeRule(context.Context, *AddDeclarativeRuleRequest) (*AddDeclarativeRuleResponse, error)
+	// RemoveRule removes a rule.
+	RemoveRule(context.Context, *RemoveRuleRequest) (*RemoveRuleResponse, error)
+	// ListRules lists the rules, in insertion order.
+	ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error)
+	mustEmbedUnimplementedPermsServer()
+}
+
+// UnimplementedPermsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPermsServer struct{}
+
+func (UnimplementedPermsServer) Evaluate(context.Context, *EvaluateRequest) (*Decision, error) {
+	return nil, status.Error(codes.Unimplemented, "method Evaluate not implemented")
+}
+func (UnimplementedPermsServer) AddDeclarativeRule(context.Context, *AddDeclarativeRuleRequest) (*AddDeclarativeRuleResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddDeclarativeRule not implemented")
+}
+func (UnimplementedPermsServer) RemoveRule(context.Context, *RemoveRuleRequest) (*RemoveRuleResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveRule not implemented")
+}
+func (UnimplementedPermsServer) ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListRules not implemented")
+}
+func (UnimplementedPermsServer) mustEmbedUnimplementedPermsServer() {}
+func (UnimplementedPermsServer) testEmbeddedByValue()               {}
+
+// UnsafePermsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PermsServer will
+// result in compilation errors.
+type UnsafePermsServer interface {
+	mustEmbedUnimplementedPermsServer()
+}
+
+func RegisterPermsServer(s grpc.ServiceRegistrar, srv PermsServer) {
+	// If the following call panics, it indicates UnimplementedPermsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Perms_ServiceDesc, srv)
+}
+
+func _Perms_Evaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvaluateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermsServer).Evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Perms_Evaluate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermsServer).Evaluate(ctx, req.(*EvaluateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Perms_AddDeclarativeRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddDeclarativeRuleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermsServer).AddDeclarativeRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Perms_AddDeclarativeRule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermsServer).AddDeclarativeRule(ctx, req.(*AddDeclarativeRuleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Perms_RemoveRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRuleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermsServer).RemoveRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Perms_RemoveRule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermsServer).RemoveRule(ctx, req.(*RemoveRuleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Perms_ListRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PermsServer).ListRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Perms_ListRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PermsServer).ListRules(ctx, req.(*ListRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Perms_ServiceDesc is the grpc.ServiceDesc for Perms service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Perms_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "perms.v1.Perms",
+	HandlerType: (*PermsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Evaluate",
+			Handler:    _Perms_Evaluate_Handler,
+		},
+		{
+			MethodName: "AddDeclarativeRule",
+			Handler:    _Perms_AddDeclarativeRule_Handler,
+		},
+		{
+			MethodName: "RemoveRule",
+			Handler:    _Perms_RemoveRule_Handler,
+		},
+		{
+			MethodName: "ListRules",
+			Handler:    _Perms_ListRules_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "perms.proto",
+}
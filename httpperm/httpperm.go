@@ -0,0 +1,102 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package httpperm enforces go-perms rule sets on net/http handlers.
+package httpperm
+
+import (
+	"errors"
+	"net/http"
+
+	perms "github.com/panta/go-perms"
+)
+
+// ExtractorFn extracts the subject, the action or the resource of a query from a request.
+// An extractor fails by returning an error value. The subject and action extractors
+// also fail by returning nil, while a nil resource (eg. for the routes of a collection)
+// is queried as it is.
+type ExtractorFn func(r *http.Request) interface{}
+
+type config struct {
+	deniedStatus int
+	deniedBody   string
+}
+
+// Option configures the Middleware.
+type Option func(*config)
+
+// WithDeniedStatus sets the status code written when the permission is denied.
+// The default is http.StatusForbidden.
+func WithDeniedStatus(status int) Option {
+	return func(c *config) {
+		c.deniedStatus = status
+	}
+}
+
+// WithDeniedBody sets the response body written when the permission is denied.
+// The default is the text of the status code.
+func WithDeniedBody(body string) Option {
+	return func(c *config) {
+		c.deniedBody = body
+	}
+}
+
+// Middleware returns a middleware enforcing rs on the subject, action and resource
+// extracted from each request, see perms.RuleSet.EnforceCtx, calling the next handler
+// only when the effect is allowed (see perms.RuleSet.AllowedEffects). Otherwise it
+// writes 403 Forbidden (see WithDeniedStatus and WithDeniedBody).
+// If subjectFn fails it writes 401 Unauthorized, if actionFn or resourceFn fail, or the
+// query returns an error, it writes 500 Internal Server Error.
+// The request context is passed to the matchers, see perms.RuleSet.QueryCtx.
+func Middleware(rs *perms.RuleSet, subjectFn ExtractorFn, resourceFn ExtractorFn, actionFn ExtractorFn, options ...Option) func(http.Handler) http.Handler {
+	c := config{deniedStatus: http.StatusForbidden}
+	for _, option := range options {
+		option(&c)
+	}
+	if c.deniedBody == "" {
+		c.deniedBody = http.StatusText(c.deniedStatus)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subject, ok := extract(subjectFn, r)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			action, ok := extract(actionFn, r)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			resource := resourceFn(r)
+			if _, isErr := resource.(error); isErr {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			err := rs.EnforceCtx(r.Context(), subject, action, resource)
+			if errors.Is(err, perms.ErrPermissionDenied) {
+				http.Error(w, c.deniedBody, c.deniedStatus)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func extract(fn ExtractorFn, r *http.Request) (interface{}, bool) {
+	value := fn(r)
+	if value == nil {
+		return nil, false
+	}
+	if _, isErr := value.(error); isErr {
+		return nil, false
+	}
+	return value, true
+}
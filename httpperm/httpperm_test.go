@@ -0,0 +1,165 @@
+package httpperm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+type user struct {
+	Name string
+}
+
+type userKey struct{}
+
+func subjectFromContext(r *http.Request) interface{} {
+	u, _ := r.Context().Value(userKey{}).(*user)
+	if u == nil {
+		return nil
+	}
+	return u
+}
+
+func method(r *http.Request) interface{} {
+	return r.Method
+}
+
+func path(r *http.Request) interface{} {
+	return r.URL.Path
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.AddRule(&user{}, "GET", nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return strings.HasPrefix(res.(string), "/"+subj.(*user).Name+"/"), perms.Allow, false
+		})
+	return rs
+}
+
+func serve(handler http.Handler, u *user, method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if u != nil {
+		req = req.WithContext(context.WithValue(req.Context(), userKey{}, u))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Write([]byte("ok"))
+	})
+	handler := Middleware(newRuleSet(), subjectFromContext, path, method)(next)
+
+	tests := []struct {
+		name   string
+		user   *user
+		method string
+		target string
+		status int
+		called int
+	}{
+		{"allow", &user{Name: "john"}, "GET", "/john/videos", http.StatusOK, 1},
+		{"deny", &user{Name: "john"}, "GET", "/jack/videos", http.StatusForbidden, 0},
+		{"no rule", &user{Name: "john"}, "DELETE", "/john/videos", http.StatusForbidden, 0},
+		{"no subject", nil, "GET", "/john/videos", http.StatusUnauthorized, 0},
+	}
+	for _, test := range tests {
+		called = 0
+		rec := serve(handler, test.user, test.method, test.target)
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d want %d", test.name, rec.Code, test.status)
+		}
+		if called != test.called {
+			t.Errorf("%s: got %d calls to the next handler want %d", test.name, called, test.called)
+		}
+	}
+}
+
+func TestMiddlewareExtractorErrors(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler called")
+	})
+	failing := func(r *http.Request) interface{} {
+		return errors.New("no resource")
+	}
+	john := &user{Name: "john"}
+
+	rec := serve(Middleware(newRuleSet(), subjectFromContext, failing, method)(next), john, "GET", "/john/videos")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d want %d for a failing resource extractor", rec.Code, http.StatusInternalServerError)
+	}
+	rec = serve(Middleware(newRuleSet(), subjectFromContext, path, failing)(next), john, "GET", "/john/videos")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d want %d for a failing action extractor", rec.Code, http.StatusInternalServerError)
+	}
+	rec = serve(Middleware(newRuleSet(), failing, path, method)(next), john, "GET", "/john/videos")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d want %d for a failing subject extractor", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMiddlewareNilResource(t *testing.T) {
+	rs := newRuleSet()
+	var queried []interface{}
+	rs.AddRule(&user{}, "LIST", nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		queried = append(queried, res)
+		return true, perms.Allow, false
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	collection := func(r *http.Request) interface{} {
+		return nil
+	}
+	handler := Middleware(rs, subjectFromContext, collection, method)(next)
+
+	if rec := serve(handler, &user{Name: "john"}, "LIST", "/videos"); rec.Code != http.StatusOK {
+		t.Errorf("got status %d want %d for a nil resource", rec.Code, http.StatusOK)
+	}
+	if len(queried) != 1 || queried[0] != nil {
+		t.Errorf("got %v queried want the nil resource", queried)
+	}
+}
+
+func TestMiddlewareAllowedEffects(t *testing.T) {
+	rs := newRuleSet()
+	rs.AddRule(&user{}, "POST", nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, "audit", false
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(rs, subjectFromContext, path, method)(next)
+	john := &user{Name: "john"}
+
+	if rec := serve(handler, john, "POST", "/john/videos"); rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d want %d for a non allow effect", rec.Code, http.StatusForbidden)
+	}
+	rs.AllowedEffects = []perms.Effect{perms.Allow, "audit"}
+	if rec := serve(handler, john, "POST", "/john/videos"); rec.Code != http.StatusOK {
+		t.Errorf("got status %d want %d for an allowed effect", rec.Code, http.StatusOK)
+	}
+	if rec := serve(handler, john, "GET", "/jack/videos"); rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestMiddlewareDeniedResponse(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(newRuleSet(), subjectFromContext, path, method,
+		WithDeniedStatus(http.StatusNotFound), WithDeniedBody("nothing here"))(next)
+
+	rec := serve(handler, &user{Name: "john"}, "GET", "/jack/videos")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d want %d", rec.Code, http.StatusNotFound)
+	}
+	if got, want := strings.TrimSpace(rec.Body.String()), "nothing here"; got != want {
+		t.Errorf("got body %q want %q", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// iamStrings is a JSON string or array of strings.
+type iamStrings []string
+
+func (list *iamStrings) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*list = iamStrings{s}
+		return nil
+	}
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return fmt.Errorf("want a string or an array of strings, got %s", data)
+	}
+	*list = strs
+	return nil
+}
+
+type iamStatement struct {
+	Sid          string          `json:"Sid"`
+	Effect       string          `json:"Effect"`
+	Action       iamStrings      `json:"Action"`
+	NotAction    iamStrings      `json:"NotAction"`
+	Resource     iamStrings      `json:"Resource"`
+	NotResource  iamStrings      `json:"NotResource"`
+	Principal    json.RawMessage `json:"Principal"`
+	NotPrincipal json.RawMessage `json:"NotPrincipal"`
+	Condition    json.RawMessage `json:"Condition"`
+}
+
+type iamPolicy struct {
+	Version   string          `json:"Version"`
+	ID        string          `json:"Id"`
+	Statement json.RawMessage `json:"Statement"`
+}
+
+// iamPriority is the priority of the rules of Deny statements, evaluated before the
+// rules of Allow statements.
+const iamPriority = 1
+
+// LoadIAMJSON reads a policy document in the AWS IAM JSON format and adds a rule for
+// each of its statements. The rules have a "jolly" subject and empty string templates
+// for action and resource, so that they apply to any subject and to the string
+// actions and resources matching the statement.
+//
+// The Statement is an object or an array of objects with an Effect, "Allow" or "Deny",
+// and either an Action or a NotAction and either a Resource or a NotResource, each
+// a string or an array of strings. In the Action, Resource, ... patterns '*' matches any
+// sequence of characters and '?' any single character; actions are compared ignoring
+// case. The rule of a statement with a Sid has the Sid as id.
+// As in IAM, Deny statements take precedence over Allow ones regardless of
+// ruleSet.Combining: their rules have a higher priority and end the query when they
+// match. Unknown fields and the unsupported Principal, NotPrincipal and Condition
+// fields are reported as errors. If a statement is invalid no rule is added.
+func LoadIAMJSON(ruleSet *RuleSet, r io.Reader) error {
+	var policy iamPolicy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return fmt.Errorf("perms: %v", err)
+	}
+	var raw []json.RawMessage
+	if trimmed := bytes.TrimSpace(policy.Statement); len(trimmed) > 0 && trimmed[0] == '{' {
+		raw = []json.RawMessage{policy.Statement}
+	} else if err := json.Unmarshal(policy.Statement, &raw); err != nil || len(raw) == 0 {
+		return fmt.Errorf("perms: the Statement must be an object or a non-empty array")
+	}
+
+	rules := make([]*Rule, 0, len(raw))
+	ids := make(map[RuleID]bool)
+	for i, data := range raw {
+		var stmt iamStatement
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&stmt); err != nil {
+			return fmt.Errorf("perms: statement %d: %v", i, err)
+		}
+		rule, err := stmt.rule()
+		if err != nil {
+			return fmt.Errorf("perms: statement %d: %v", i, err)
+		}
+		if rule.id != "" {
+			if ruleSet.hasRule(rule.id) || ids[rule.id] {
+				return fmt.Errorf("perms: statement %d: duplicate id %q", i, rule.id)
+			}
+			ids[rule.id] = true
+		}
+		rules = append(rules, rule)
+	}
+	return ruleSet.addRules(rules...)
+}
+
+// rule validates the statement and returns its rule.
+func (stmt *iamStatement) rule() (*Rule, error) {
+	unsupported := []struct {
+		field string
+		value json.RawMessage
+	}{{"Principal", stmt.Principal}, {"NotPrincipal", stmt.NotPrincipal}, {"Condition", stmt.Condition}}
+	for _, element := range unsupported {
+		if element.value != nil {
+			return nil, fmt.Errorf("unsupported field %s", element.field)
+		}
+	}
+	var effect Effect
+	switch stmt.Effect {
+	case "Allow":
+		effect = Allow
+	case "Deny":
+		effect = Deny
+	default:
+		return nil, fmt.Errorf("the Effect must be Allow or Deny, got %q", stmt.Effect)
+	}
+	actions, err := compileIAMPatterns("Action", stmt.Action, stmt.NotAction, true)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := compileIAMPatterns("Resource", stmt.Resource, stmt.NotResource, false)
+	if err != nil {
+		return nil, err
+	}
+
+	quick := effect == Deny
+	rule := newPlainRule(nil, "", "", MatcherFn(func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		if !actions.match(act.(string)) || !resources.match(res.(string)) {
+			return false, "", false
+		}
+		return true, effect, quick
+	}).ErrFn())
+	rule.id = RuleID(stmt.Sid)
+	if quick {
+		rule.priority = iamPriority
+	}
+	return rule, nil
+}
+
+// iamPatterns matches the values matching any of the patterns, or none of them if not.
+type iamPatterns struct {
+	patterns []*regexp.Regexp
+	not      bool
+}
+
+func (p iamPatterns) match(value string) bool {
+	for _, re := range p.patterns {
+		if re.MatchString(value) {
+			return !p.not
+		}
+	}
+	return p.not
+}
+
+// compileIAMPatterns compiles the patterns of the element name or of its Not form,
+// exactly one of which must be present.
+func compileIAMPatterns(name string, patterns iamStrings, notPatterns iamStrings, foldCase bool) (iamPatterns, error) {
+	if (patterns == nil) == (notPatterns == nil) {
+		return iamPatterns{}, fmt.Errorf("exactly one of %s and Not%s is required", name, name)
+	}
+	compiled := iamPatterns{not: notPatterns != nil}
+	if compiled.not {
+		patterns, name = notPatterns, "Not"+name
+	}
+	if len(patterns) == 0 {
+		return iamPatterns{}, fmt.Errorf("empty %s", name)
+	}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return iamPatterns{}, fmt.Errorf("empty pattern in %s", name)
+		}
+		var re strings.Builder
+		re.WriteString(`(?s)`)
+		if foldCase {
+			re.WriteString(`(?i)`)
+		}
+		re.WriteString(`\A`)
+		for _, c := range pattern {
+			switch c {
+			case '*':
+				re.WriteString(`.*`)
+			case '?':
+				re.WriteString(`.`)
+			default:
+				re.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		re.WriteString(`\z`)
+		compiled.patterns = append(compiled.patterns, regexp.MustCompile(re.String()))
+	}
+	return compiled, nil
+}
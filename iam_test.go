@@ -0,0 +1,102 @@
+package perms
+
+import (
+	"strings"
+	"testing"
+)
+
+const iamPolicyJSON = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {"Sid": "ReadBuckets", "Effect": "Allow", "Action": ["s3:Get*", "s3:List*"], "Resource": "arn:aws:s3:::*"},
+    {"Effect": "Deny", "Action": "s3:*", "Resource": ["arn:aws:s3:::secret", "arn:aws:s3:::secret/*"]},
+    {"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::uploads/user-??/*"},
+    {"Sid": "NoIAM", "Effect": "Deny", "NotAction": ["s3:*", "ec2:Describe*"], "Resource": "*"},
+    {"Effect": "Allow", "Action": "ec2:DescribeInstances", "NotResource": "arn:aws:ec2:*:*:instance/i-private*"},
+    {"Effect": "Allow", "Action": "iam:GetUser", "Resource": "*"}
+  ]
+}`
+
+func TestLoadIAMJSON(t *testing.T) {
+	for _, combining := range []CombiningStrategy{LastApplicable, FirstApplicable, DenyOverrides, AllowOverrides} {
+		rs := NewRuleSet(Deny)
+		rs.Combining = combining
+		if err := LoadIAMJSON(rs, strings.NewReader(iamPolicyJSON)); err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			action, resource string
+			want             Effect
+		}{
+			{"s3:GetObject", "arn:aws:s3:::photos/cat.jpg", Allow},
+			{"S3:getobject", "arn:aws:s3:::photos/cat.jpg", Allow},
+			{"s3:ListBucket", "arn:aws:s3:::photos", Allow},
+			{"s3:GetObject", "arn:aws:s3:::secret", Deny},
+			{"s3:GetObject", "arn:aws:s3:::secret/keys.txt", Deny},
+			{"s3:GetObject", "arn:aws:s3:::secrets", Allow},
+			{"s3:DeleteObject", "arn:aws:s3:::photos/cat.jpg", Deny},
+			{"s3:PutObject", "arn:aws:s3:::uploads/user-42/cv.pdf", Allow},
+			{"s3:PutObject", "arn:aws:s3:::uploads/user-421/cv.pdf", Deny},
+			{"s3:PutObject", "arn:aws:s3:::Uploads/user-42/cv.pdf", Deny},
+			{"ec2:DescribeInstances", "arn:aws:ec2:eu-west-1:123:instance/i-0abc", Allow},
+			{"ec2:DescribeInstances", "arn:aws:ec2:eu-west-1:123:instance/i-private-1", Deny},
+			// allowed by a statement, but denied by NoIAM
+			{"iam:GetUser", "arn:aws:iam::123:user/bob", Deny},
+		}
+		for _, c := range cases {
+			if got := rs.Query(&User{Name: "john"}, c.action, c.resource); got != c.want {
+				t.Errorf("%v: Query(%q, %q): got %q want %q", combining, c.action, c.resource, got, c.want)
+			}
+		}
+		if d := rs.QueryExplain("anyone", "s3:GetObject", "arn:aws:s3:::photos"); d.Rule == nil || d.Rule.ID != "ReadBuckets" {
+			t.Errorf("got %+v want the ReadBuckets rule", d)
+		}
+	}
+
+	// a single statement object
+	rs := NewRuleSet(Deny)
+	if err := LoadIAMJSON(rs, strings.NewReader(`{"Statement": {"Effect": "Allow", "Action": "*", "Resource": "*"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(nil, "s3:GetObject", "arn:aws:s3:::photos"); got != Allow {
+		t.Errorf("got %q want %q", got, Allow)
+	}
+}
+
+func TestLoadIAMJSONErrors(t *testing.T) {
+	cases := []struct {
+		policy string
+		err    string
+	}{
+		{`{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "*", "Resource": "*"}]}`,
+			`perms: statement 0: unsupported field Principal`},
+		{`{"Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}, {"Effect": "Deny", "Action": "*", "Resource": "*", "Condition": {"Bool": {"aws:SecureTransport": "false"}}}]}`,
+			`perms: statement 1: unsupported field Condition`},
+		{`{"Statement": [{"Effect": "Permit", "Action": "*", "Resource": "*"}]}`,
+			`perms: statement 0: the Effect must be Allow or Deny, got "Permit"`},
+		{`{"Statement": [{"Effect": "Allow", "Action": "*", "NotAction": "s3:*", "Resource": "*"}]}`,
+			`perms: statement 0: exactly one of Action and NotAction is required`},
+		{`{"Statement": [{"Effect": "Allow", "Action": "*"}]}`,
+			`perms: statement 0: exactly one of Resource and NotResource is required`},
+		{`{"Statement": [{"Effect": "Allow", "Action": [], "Resource": "*"}]}`,
+			`perms: statement 0: empty Action`},
+		{`{"Statement": [{"Effect": "Allow", "Action": "*", "Resource": 42}]}`,
+			`perms: statement 0: want a string or an array of strings, got 42`},
+		{`{"Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*", "Extra": 1}]}`,
+			`perms: statement 0: json: unknown field "Extra"`},
+		{`{"Statement": [{"Sid": "a", "Effect": "Allow", "Action": "*", "Resource": "*"}, {"Sid": "a", "Effect": "Deny", "Action": "*", "Resource": "*"}]}`,
+			`perms: statement 1: duplicate id "a"`},
+		{`{"Version": "2012-10-17"}`,
+			`perms: the Statement must be an object or a non-empty array`},
+	}
+	for _, c := range cases {
+		rs := NewRuleSet(Deny)
+		err := LoadIAMJSON(rs, strings.NewReader(c.policy))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+		if n := len(rs.byID); n != 0 {
+			t.Errorf("got %d rules loaded from an invalid policy", n)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Identifiable is implemented by the subjects and resources with a stable identity,
+// typically pointers to values loaded from a database, for which == compares the
+// addresses instead of the identities.
+//
+// A template implementing Identifiable, with a non-empty PermKey, admits the values
+// with the same PermKey, instead of being a template for its type only: a rule for
+// &User{ID: "42"} applies to every *User with ID "42". The IdentityCacheKey function
+// keys the decisions cache on PermKey, and the log messages report it in place of
+// the values.
+type Identifiable interface {
+	PermKey() string
+}
+
+// permKey returns the PermKey of value, if it is Identifiable and not a nil pointer.
+func permKey(value interface{}) (string, bool) {
+	identifiable, ok := value.(Identifiable)
+	if !ok {
+		return "", false
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return "", false
+	}
+	return identifiable.PermKey(), true
+}
+
+// identityTemplate matches the Identifiable values with a given PermKey.
+type identityTemplate struct {
+	key string
+}
+
+func (identity identityTemplate) matchTemplate(value interface{}) bool {
+	key, ok := permKey(value)
+	return ok && key == identity.key
+}
+
+// KeyOf returns a description of value suitable for logs and audit records: the
+// PermKey, prefixed by the type, of an Identifiable value, or the value formatted
+// with %v. An AuditFn hook can use it to record the subject and the resource.
+func KeyOf(value interface{}) string {
+	if key, ok := permKey(value); ok {
+		return fmt.Sprintf("%T(%s)", value, key)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// IdentityCacheKey is a CacheKeyFn for WithCache keying the triples on the PermKey of
+// Identifiable values and on the strings, declining the triples with other values.
+func IdentityCacheKey(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+	var key strings.Builder
+	for i, value := range []interface{}{subject, action, resource} {
+		if i > 0 {
+			key.WriteByte(0)
+		}
+		if s, ok := value.(string); ok {
+			fmt.Fprintf(&key, "%q", s)
+		} else if id, ok := permKey(value); ok {
+			fmt.Fprintf(&key, "%T(%q)", value, id)
+		} else {
+			return "", false
+		}
+	}
+	return key.String(), true
+}
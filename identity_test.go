@@ -0,0 +1,55 @@
+package perms
+
+import (
+	"testing"
+)
+
+type Account struct {
+	ID   string
+	Name string
+}
+
+func (account *Account) PermKey() string {
+	return account.ID
+}
+
+func TestIdentifiable(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&Account{ID: "42"}, "edit", &Video{}, effectMatcher(ALLOW))
+	rs.AddRule(&Account{}, "view", &Video{}, effectMatcher(ALLOW))
+
+	video := &Video{Name: "intro"}
+	// a different pointer with the same key
+	if !rs.IsAllowed(&Account{ID: "42", Name: "john"}, "edit", video) {
+		t.Errorf("got account 42 denied want allowed")
+	}
+	if rs.IsAllowed(&Account{ID: "43"}, "edit", video) {
+		t.Errorf("got account 43 allowed want denied")
+	}
+	// an empty key is a template for the type
+	if !rs.IsAllowed(&Account{ID: "43"}, "view", video) {
+		t.Errorf("got account 43 denied view want allowed")
+	}
+	if rs.IsAllowed((*Account)(nil), "edit", video) {
+		t.Errorf("got a nil account allowed want denied")
+	}
+}
+
+func TestIdentityCacheKey(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, IdentityCacheKey)
+	rs.AddRule(&Account{ID: "42"}, "edit", "doc", effectMatcher(ALLOW))
+	rs.Query(&Account{ID: "42"}, "edit", "doc")
+	rs.Query(&Account{ID: "42"}, "edit", "doc")
+	rs.Query(&Account{ID: "43"}, "edit", "doc")
+	rs.Query(&User{Name: "john"}, "edit", "doc")
+	if n := rs.cache.len(); n != 2 {
+		t.Errorf("got %d cached decisions want 2", n)
+	}
+
+	if key, ok := IdentityCacheKey(&Account{ID: "42"}, "edit", "doc"); !ok || key != `*perms.Account("42")`+"\x00\"edit\"\x00\"doc\"" {
+		t.Errorf("got key %q", key)
+	}
+	if got := KeyOf(&Account{ID: "42"}); got != "*perms.Account(42)" {
+		t.Errorf("got %q want %q", got, "*perms.Account(42)")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	// ErrImplicationCycle is returned by ImplyAction when the implication would make
+	// an action imply itself.
+	ErrImplicationCycle = errors.New("perms: action implication cycle")
+	// ErrUncomparableAction is returned by ImplyAction and AliasAction for an action
+	// which is not a comparable value, eg. a slice.
+	ErrUncomparableAction = errors.New("perms: uncomparable action")
+)
+
+// actionGraph holds the implications and the aliases of the actions. Like the rule
+// table holding it, it is immutable once published.
+type actionGraph struct {
+	// aliases maps the aliases to their canonical actions, see AliasAction
+	aliases map[interface{}]interface{}
+	// impliers maps the actions to those directly implying them, in order of
+	// registration
+	impliers map[interface{}][]interface{}
+	// implying maps the actions to all those implying them, transitively, the
+	// closest first
+	implying map[interface{}][]interface{}
+}
+
+// ImplyAction makes stronger imply weaker, eg. "modify" imply "view": when the rules
+// for a queried action produce no effect, the rules for the actions implying it are
+// evaluated, before the rules for any action. Implications are transitive, and the
+// implying actions are evaluated from the closest, in the order of the ImplyAction
+// calls.
+//
+// In detail, the fallback passes looking up the rules for the queried action are
+// evaluated first, then the same passes for each of the implying actions, and finally
+// the passes for any action. The matchers of the implying actions still receive the
+// queried action. Actions must be comparable values.
+//
+// ImplyAction returns ErrImplicationCycle, and changes nothing, if weaker already
+// implies stronger or is the same action, and ErrUncomparableAction if either is not
+// comparable.
+func (ruleSet *RuleSet) ImplyAction(stronger interface{}, weaker interface{}) error {
+	if !comparable(stronger) || !comparable(weaker) {
+		return ErrUncomparableAction
+	}
+	var err error
+	ruleSet.update(func(w *tableWriter) {
+		graph := w.table.actions
+		if stronger == weaker || graph.implies(weaker, stronger) {
+			err = ErrImplicationCycle
+			return
+		}
+		if graph.implies(stronger, weaker) {
+			return
+		}
+		graph = graph.clone()
+		graph.imply(stronger, weaker)
+		w.table.actions = graph
+	})
+	return err
+}
+
+// ImplyingActions returns the actions implying action, transitively, in the order
+// their rules are evaluated. See ImplyAction.
+func (ruleSet *RuleSet) ImplyingActions(action interface{}) []interface{} {
+	return append([]interface{}(nil), ruleSet.current().actions.implyingActions(action)...)
+}
+
+// implies reports whether stronger implies weaker, directly or transitively.
+func (graph *actionGraph) implies(stronger interface{}, weaker interface{}) bool {
+	for _, action := range graph.implyingActions(weaker) {
+		if action == stronger {
+			return true
+		}
+	}
+	return false
+}
+
+func (graph *actionGraph) implyingActions(action interface{}) []interface{} {
+	if graph == nil || len(graph.implying) == 0 || !comparable(action) {
+		return nil
+	}
+	return graph.implying[action]
+}
+
+// comparable reports whether value can be used as a map key.
+func comparable(value interface{}) bool {
+	t := reflect.TypeOf(value)
+	return t == nil || t.Comparable()
+}
+
+// clone returns a copy of the graph that can be modified without affecting graph.
+func (graph *actionGraph) clone() *actionGraph {
+	changed := &actionGraph{
+		aliases:  make(map[interface{}]interface{}),
+		impliers: make(map[interface{}][]interface{}),
+		implying: make(map[interface{}][]interface{}),
+	}
+	if graph == nil {
+		return changed
+	}
+	for alias, canonical := range graph.aliases {
+		changed.aliases[alias] = canonical
+	}
+	for action, impliers := range graph.impliers {
+		changed.impliers[action] = impliers
+	}
+	for action, implying := range graph.implying {
+		changed.implying[action] = implying
+	}
+	return changed
+}
+
+// imply makes stronger directly imply weaker, recomputing the closure.
+func (graph *actionGraph) imply(stronger interface{}, weaker interface{}) {
+	impliers := graph.impliers[weaker]
+	graph.impliers[weaker] = append(impliers[:len(impliers):len(impliers)], stronger)
+
+	// the closure, breadth first so that the closest actions come first
+	for action := range graph.impliers {
+		seen := map[interface{}]bool{action: true}
+		var implying []interface{}
+		for queue := []interface{}{action}; len(queue) > 0; queue = queue[1:] {
+			for _, implier := range graph.impliers[queue[0]] {
+				if !seen[implier] {
+					seen[implier] = true
+					implying = append(implying, implier)
+					queue = append(queue, implier)
+				}
+			}
+		}
+		graph.implying[action] = implying
+	}
+}
+
+// passStep is a fallback pass of an evaluation, looking up the rules for the implying
+// action if non-nil.
+type passStep struct {
+	pass     int
+	implying *queryValue
+}
+
+// defaultSteps are the fallback passes of the actions without implications.
+var defaultSteps = func() []passStep {
+	steps := make([]passStep, len(jollyTiers))
+	for pass := range steps {
+		steps[pass].pass = pass
+	}
+	return steps
+}()
+
+// steps returns the fallback passes of the evaluation, see ImplyAction.
+func (ev *evaluation) steps() []passStep {
+	implying := ev.table.actions.implyingActions(ev.action)
+	if len(implying) == 0 {
+		return defaultSteps
+	}
+	steps := make([]passStep, 0, len(jollyTiers)*(1+len(implying)))
+	for _, step := range defaultSteps {
+		if jollyTiers[step.pass][1] {
+			steps = append(steps, step)
+		}
+	}
+	for _, action := range implying {
+		q := ev.queryValue(1, action)
+		for _, step := range defaultSteps {
+			if jollyTiers[step.pass][1] {
+				steps = append(steps, passStep{pass: step.pass, implying: &q})
+			}
+		}
+	}
+	for _, step := range defaultSteps {
+		if !jollyTiers[step.pass][1] {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// action returns the action looked up by the step, queried if not implying.
+func (step passStep) action(queried queryValue) queryValue {
+	if step.implying != nil {
+		return *step.implying
+	}
+	return queried
+}
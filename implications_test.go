@@ -0,0 +1,102 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImplyAction(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if err := rs.ImplyAction("admin", "modify"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.ImplyAction("modify", "view"); err != nil {
+		t.Fatal(err)
+	}
+	rs.AddRule(&User{}, "modify", &Playlist{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		// the matcher receives the queried action
+		if act != "view" && act != "modify" {
+			return true, "unexpected", false
+		}
+		return true, ALLOW, false
+	})
+
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563"}
+	if !rs.IsAllowed(john, "view", playlist) {
+		t.Errorf("got view denied want it implied by modify")
+	}
+	if rs.IsAllowed(john, "delete", playlist) {
+		t.Errorf("got delete allowed want denied")
+	}
+	if rs.IsAllowed(john, "view", &Video{}) {
+		t.Errorf("got view of a video allowed want denied")
+	}
+	if got, want := rs.ImplyingActions("view"), []interface{}{"modify", "admin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// the rules for the queried action win over the implied ones
+	rs.AddRule(&User{}, "view", nil, effectMatcher(DENY))
+	if d := rs.QueryExplain(john, "view", playlist); d.Effect != DENY || d.Rule.ID != "rule-2" {
+		t.Errorf("got %+v want the direct view rule", d)
+	}
+	// while the implied ones win over the rules for any action
+	rs.AddRule(&User{}, nil, &Video{}, effectMatcher("any"))
+	rs.AddRule(&User{}, "admin", &Video{}, effectMatcher(ALLOW))
+	if d := rs.QueryExplain(john, "modify", &Video{}); d.Effect != ALLOW || d.Rule.ID != "rule-4" {
+		t.Errorf("got %+v want the admin rule", d)
+	}
+	var implied []interface{}
+	rs.QueryTrace(john, "modify", &Video{}, func(ev TraceEvent) {
+		if ev.Kind == TracePass && ev.ImpliedBy != nil {
+			implied = append(implied, ev.ImpliedBy)
+		}
+	})
+	// the first pass of admin, (S,A,R), produces the effect
+	if len(implied) != 1 || implied[0] != "admin" {
+		t.Errorf("got implied passes %v want a pass of admin", implied)
+	}
+}
+
+func TestImplyActionCycle(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if err := rs.ImplyAction("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.ImplyAction("b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][2]string{{"b", "a"}, {"c", "a"}, {"a", "a"}} {
+		if err := rs.ImplyAction(pair[0], pair[1]); err != ErrImplicationCycle {
+			t.Errorf("%s implies %s: got %v want %v", pair[0], pair[1], err, ErrImplicationCycle)
+		}
+	}
+	if got, want := rs.ImplyingActions("c"), []interface{}{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// a diamond is not a cycle
+	if err := rs.ImplyAction("a", "c"); err != nil {
+		t.Errorf("got %v for a implying c twice", err)
+	}
+	if err := rs.ImplyAction("d", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rs.ImplyingActions("c"), []interface{}{"b", "d", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestImplyActionUncomparable(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if err := rs.ImplyAction([]string{"modify"}, "view"); err != ErrUncomparableAction {
+		t.Errorf("got %v want %v", err, ErrUncomparableAction)
+	}
+	if err := rs.ImplyAction("modify", map[string]bool{"view": true}); err != ErrUncomparableAction {
+		t.Errorf("got %v want %v", err, ErrUncomparableAction)
+	}
+	if got := rs.ImplyingActions("view"); len(got) != 0 {
+		t.Errorf("got %v want no implication", got)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+const (
+	// indexMinRules is the length of the RuleLists from which the rules are indexed.
+	indexMinRules = 64
+	// indexMinLevel is the number of rules from which an index level holds the
+	// positions of the rules, the rules of smaller levels are scanned.
+	indexMinLevel = 16
+)
+
+// valueIndex indexes the rules of a RuleList by their non-empty string templates, so
+// that the rules for a queried string are found without scanning the whole list.
+// Like the rule table holding it, a valueIndex is immutable once published.
+//
+// The index is made of levels covering consecutive ranges of the list, with sizes
+// decreasing roughly by half: the rules appended to the list are added as a new level,
+// merged with the previous ones of the same size, so that appending a rule rebuilds
+// on average a logarithmic number of positions and the older levels are shared with
+// the previous versions of the index.
+type valueIndex struct {
+	levels []*indexLevel
+}
+
+// indexLevel indexes the rules of a RuleList in the positions from start to end.
+type indexLevel struct {
+	start, end int
+	// byValue maps, in each position (subject, action or resource), the non-empty
+	// string templates to the positions of their rules; it is nil for the positions
+	// without such templates
+	byValue [3]map[string][]int
+	// others holds, in each indexed position, the positions of the rules with other
+	// templates, like "" or a pattern, which must always be evaluated
+	others [3][]int
+}
+
+// literalString returns the template in the given position if it is a non-empty
+// string admitting only the equal strings.
+func (rule *Rule) literalString(position int) (string, bool) {
+	if rule.patterns[position] != nil {
+		return "", false
+	}
+	s, ok := rule.template(position).(string)
+	return s, ok && s != ""
+}
+
+// newValueIndex returns the index of the rules of list, or nil if the list is too short
+// to need one.
+func newValueIndex(list RuleList) *valueIndex {
+	if len(list) < indexMinRules {
+		return nil
+	}
+	return &valueIndex{levels: []*indexLevel{newIndexLevel(list, 0, len(list))}}
+}
+
+func newIndexLevel(list RuleList, start int, end int) *indexLevel {
+	level := &indexLevel{start: start, end: end}
+	if end-start < indexMinLevel {
+		return level
+	}
+	for position := range level.byValue {
+		for _, rule := range list[start:end] {
+			if _, ok := rule.literalString(position); ok {
+				level.byValue[position] = make(map[string][]int)
+				break
+			}
+		}
+		if level.byValue[position] == nil {
+			continue
+		}
+		for i := start; i < end; i++ {
+			if value, ok := list[i].literalString(position); ok {
+				level.byValue[position][value] = append(level.byValue[position][value], i)
+			} else {
+				level.others[position] = append(level.others[position], i)
+			}
+		}
+	}
+	return level
+}
+
+// appended returns the index of list, obtained appending a rule to the list indexed
+// by index.
+func (index *valueIndex) appended(list RuleList) *valueIndex {
+	if index == nil {
+		return newValueIndex(list)
+	}
+	levels := make([]*indexLevel, len(index.levels), len(index.levels)+1)
+	copy(levels, index.levels)
+	levels = append(levels, newIndexLevel(list, len(list)-1, len(list)))
+	for n := len(levels); n >= 2 && levels[n-1].size() >= levels[n-2].size(); n-- {
+		levels[n-2] = newIndexLevel(list, levels[n-2].start, levels[n-1].end)
+		levels = levels[:n-1]
+	}
+	return &valueIndex{levels: levels}
+}
+
+func (level *indexLevel) size() int {
+	return level.end - level.start
+}
+
+// scan calls fn with the positions of the indexed rules that may admit the queried
+// values, in increasing order, returning false if fn stopped the iteration. The nil
+// values don't restrict the rules.
+func (index *valueIndex) scan(values [3]*queryValue, fn func(i int) bool) bool {
+	for _, level := range index.levels {
+		// use the most selective of the indexed positions
+		best, candidates := -1, level.size()
+		var byValue []int
+		for position, q := range values {
+			if q == nil || q.t != stringType || level.byValue[position] == nil {
+				continue
+			}
+			rules := level.byValue[position][q.value.(string)]
+			if n := len(rules) + len(level.others[position]); n < candidates {
+				best, candidates, byValue = position, n, rules
+			}
+		}
+		if best < 0 {
+			for i := level.start; i < level.end; i++ {
+				if !fn(i) {
+					return false
+				}
+			}
+			continue
+		}
+		if !mergePositions(byValue, level.others[best], fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePositions calls fn with the positions of a and b, two increasing lists, in
+// increasing order, returning false if fn stopped the iteration.
+func mergePositions(a []int, b []int, fn func(i int) bool) bool {
+	for len(a) > 0 || len(b) > 0 {
+		var i int
+		if len(b) == 0 || (len(a) > 0 && a[0] < b[0]) {
+			i, a = a[0], a[1:]
+		} else {
+			i, b = b[0], b[1:]
+		}
+		if !fn(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanList calls fn with the positions of the rules of list, registered under the
+// types triple key, that may admit the queried values, in increasing order, using
+// the index of the list if it has one. It returns false if fn stopped the iteration.
+func (table *ruleTable) scanList(key [3]typ, list RuleList, values [3]*queryValue, fn func(i int) bool) bool {
+	if index := table.indexes[key]; index != nil {
+		return index.scan(values, fn)
+	}
+	for i := range list {
+		if !fn(i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package perms
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// checkIndexedDecisions checks that the queries of the keys produce the same decisions
+// with the index and with the scan of the whole RuleLists done when tracing.
+func checkIndexedDecisions(t *testing.T, rs *RuleSet, keys []string, actions []string) {
+	t.Helper()
+	for _, key := range keys {
+		for _, action := range actions {
+			indexed := rs.QueryExplain(key, action, "doc")
+			var scanned Decision
+			rs.QueryTrace(key, action, "doc", func(ev TraceEvent) {
+				if ev.Kind == TraceResult {
+					scanned = ev.Decision
+				}
+			})
+			if !reflect.DeepEqual(indexed, scanned) {
+				t.Errorf("%s %s: got %+v want %+v", key, action, indexed, scanned)
+				return
+			}
+		}
+	}
+}
+
+func TestValueIndex(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	keys := make([]string, 40)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	actions := []string{"read", "write", "delete"}
+
+	rs := NewRuleSet(DENY)
+	var ids []RuleID
+	for i := 0; i < 1000; i++ {
+		effect := ALLOW
+		if random.Intn(3) == 0 {
+			effect = DENY
+		}
+		subject, action := keys[random.Intn(len(keys))], actions[random.Intn(len(actions))]
+		switch random.Intn(10) {
+		case 0:
+			// a wildcard subject, always evaluated
+			subject = ""
+		case 1:
+			action = ""
+		case 2:
+			id, err := rs.AddGlobRule("key-1*", action, "doc", effectMatcher(effect))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			continue
+		case 3:
+			// inserted before the rules with a lower priority, the index is rebuilt
+			ids = append(ids, rs.AddRuleWithPriority(random.Intn(3), subject, action, "doc", effectMatcher(effect)))
+			continue
+		}
+		ids = append(ids, rs.AddRule(subject, action, "doc", effectMatcher(effect)))
+		if i%100 == 0 {
+			checkIndexedDecisions(t, rs, keys, actions)
+		}
+	}
+	if rs.current().indexes[[3]typ{stringType, stringType, stringType}] == nil {
+		t.Fatal("got no index for the string rules")
+	}
+	checkIndexedDecisions(t, rs, keys, actions)
+
+	for _, i := range random.Perm(len(ids))[:900] {
+		if err := rs.RemoveRule(ids[i]); err != nil {
+			t.Fatal(err)
+		}
+		if i%50 == 0 {
+			checkIndexedDecisions(t, rs, keys, actions)
+		}
+	}
+	checkIndexedDecisions(t, rs, keys, actions)
+	rs.addRules(newRule("key-2", "read", "doc", effectMatcher(DENY).ErrFn().CtxFn()))
+	checkIndexedDecisions(t, rs, keys, actions)
+}
+
+func TestValueIndexLevels(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < 1000; i++ {
+		rs.AddRule(fmt.Sprintf("key-%d", i), "read", "doc", effectMatcher(ALLOW))
+	}
+	index := rs.current().indexes[[3]typ{stringType, stringType, stringType}]
+	end := 0
+	for i, level := range index.levels {
+		if level.start != end || (i > 0 && level.size() >= index.levels[i-1].size()) {
+			t.Fatalf("got level %d from %d to %d after %d", i, level.start, level.end, end)
+		}
+		end = level.end
+	}
+	if end != 1000 || len(index.levels) > 12 {
+		t.Errorf("got %d levels up to %d", len(index.levels), end)
+	}
+
+	// the snapshots keep the index of their rules
+	snapshot := rs.Snapshot()
+	rs.RemoveRule("rule-1")
+	if !snapshot.IsAllowed("key-0", "read", "doc") || rs.IsAllowed("key-0", "read", "doc") {
+		t.Errorf("got the rule of key-0 removed from the snapshot")
+	}
+	d := rs.QueryExplain("key-999", "read", "doc")
+	if d.Rule == nil || d.Rule.ID != "rule-1000" || d.Rule.Index != 998 {
+		t.Errorf("got %+v want rule-1000 at index 998", d.Rule)
+	}
+}
+
+func benchmarkStringRules(b *testing.B, rules int) {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < rules; i++ {
+		rs.AddRule(fmt.Sprintf("key-%d", i), "read", "doc", effectMatcher(ALLOW))
+	}
+	key := fmt.Sprintf("key-%d", rules/2)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query(key, "read", "doc") != ALLOW {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func BenchmarkStringRules1k(b *testing.B)   { benchmarkStringRules(b, 1000) }
+func BenchmarkStringRules100k(b *testing.B) { benchmarkStringRules(b, 100000) }
@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// Instrumentation observes the queries of a RuleSet, see RuleSet.Instrumentation and
+// the otelperm package, which traces them with OpenTelemetry.
+type Instrumentation interface {
+	// StartQuery is called when a query starts, with its context, and returns the
+	// context of the evaluation, passed to the matchers, and the function called with
+	// the decision of the query when it ends.
+	StartQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (context.Context, func(decision Decision, err error))
+}
+
+// RuleInstrumentation is an Instrumentation also observing the matchers run by the
+// queries.
+type RuleInstrumentation interface {
+	Instrumentation
+	// StartRule is called before running the matcher of rule, with the context of
+	// the evaluation, and returns the context passed to the matcher and the function
+	// called with the values it returned; err is the *MatcherPanic of a panic
+	// recovered by WithRecover, which doesn't fail the query.
+	StartRule(ctx context.Context, rule *RuleInfo) (context.Context, func(matched bool, effect Effect, err error))
+}
+
+// MultiInstrumentation returns an Instrumentation observing the queries with each of
+// instrumentations, eg. to trace them and collect their metrics at once. It is a
+// RuleInstrumentation if any of them is, observing the matchers with those that are.
+func MultiInstrumentation(instrumentations ...Instrumentation) Instrumentation {
+	multi := multiInstrumentation(instrumentations)
+	for _, instrumentation := range instrumentations {
+		if _, ok := instrumentation.(RuleInstrumentation); ok {
+			return multiRuleInstrumentation{multi}
+		}
+	}
+	return multi
+}
+
+type multiInstrumentation []Instrumentation
+
+func (m multiInstrumentation) StartQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (context.Context, func(decision Decision, err error)) {
+	ends := make([]func(decision Decision, err error), len(m))
+	for i, instrumentation := range m {
+		ctx, ends[i] = instrumentation.StartQuery(ctx, subject, action, resource)
+	}
+	return ctx, func(decision Decision, err error) {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](decision, err)
+		}
+	}
+}
+
+type multiRuleInstrumentation struct {
+	multiInstrumentation
+}
+
+func (m multiRuleInstrumentation) StartRule(ctx context.Context, rule *RuleInfo) (context.Context, func(matched bool, effect Effect, err error)) {
+	var ends []func(matched bool, effect Effect, err error)
+	for _, instrumentation := range m.multiInstrumentation {
+		if instrumentation, ok := instrumentation.(RuleInstrumentation); ok {
+			var end func(matched bool, effect Effect, err error)
+			ctx, end = instrumentation.StartRule(ctx, rule)
+			ends = append(ends, end)
+		}
+	}
+	return ctx, func(matched bool, effect Effect, err error) {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](matched, effect, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package perms
+
+import (
+	"context"
+	"testing"
+)
+
+type instrumentationKey struct{}
+
+// recordingInstrumentation records the queries and the matchers run.
+type recordingInstrumentation struct {
+	queries   []Decision
+	rules     []RuleID
+	matchedBy []bool
+}
+
+func (ri *recordingInstrumentation) StartQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (context.Context, func(decision Decision, err error)) {
+	return context.WithValue(ctx, instrumentationKey{}, "query"), func(decision Decision, err error) {
+		ri.queries = append(ri.queries, decision)
+	}
+}
+
+type recordingRuleInstrumentation struct {
+	recordingInstrumentation
+}
+
+func (ri *recordingRuleInstrumentation) StartRule(ctx context.Context, rule *RuleInfo) (context.Context, func(matched bool, effect Effect, err error)) {
+	ri.rules = append(ri.rules, rule.ID)
+	return context.WithValue(ctx, instrumentationKey{}, "rule"), func(matched bool, effect Effect, err error) {
+		ri.matchedBy = append(ri.matchedBy, matched)
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	var seen []interface{}
+	id := rs.AddRuleCtx(&User{}, "view", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		seen = append(seen, ctx.Value(instrumentationKey{}))
+		return res == "readme", ALLOW, false, nil
+	})
+	instrumentation := &recordingInstrumentation{}
+	rs.Instrumentation = instrumentation
+	john := &User{Name: "john"}
+
+	if !rs.IsAllowed(john, "view", "readme") || rs.IsAllowed(john, "view", "secrets") {
+		t.Fatalf("got the wrong decisions")
+	}
+	if len(instrumentation.queries) != 2 || instrumentation.queries[0].Effect != ALLOW || !instrumentation.queries[1].Default {
+		t.Errorf("got queries %+v", instrumentation.queries)
+	}
+	if len(seen) != 2 || seen[0] != "query" {
+		t.Errorf("got the matchers called with %v want the context of the query", seen)
+	}
+
+	// the matchers are observed by a RuleInstrumentation
+	rules := &recordingRuleInstrumentation{}
+	rs.Instrumentation = rules
+	seen = nil
+	rs.Query(john, "view", "readme")
+	if len(rules.rules) != 1 || rules.rules[0] != id || len(rules.matchedBy) != 1 || !rules.matchedBy[0] || seen[0] != "rule" {
+		t.Errorf("got rules %v matched %v, context %v", rules.rules, rules.matchedBy, seen)
+	}
+
+	// the cached decisions are reported as such
+	rs.WithCache(10, func(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+		return "key", true
+	})
+	rs.Query(john, "view", "readme")
+	decision := rs.QueryExplain(john, "view", "readme")
+	if !decision.Cached || decision.Effect != ALLOW || !rules.queries[len(rules.queries)-1].Cached {
+		t.Errorf("got %+v want a cached decision", decision)
+	}
+}
+
+func TestMultiInstrumentation(t *testing.T) {
+	rs := NewRuleSet(DENY).WithRecover(nil)
+	rs.AddRuleCtx(&User{}, "view", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		if res == "broken" {
+			panic("broken")
+		}
+		return true, ALLOW, false, nil
+	})
+	queries, rules := &recordingInstrumentation{}, &recordingRuleInstrumentation{}
+	errs := &errorInstrumentation{}
+	rs.Instrumentation = MultiInstrumentation(queries, rules, errs)
+	if _, ok := rs.Instrumentation.(RuleInstrumentation); !ok {
+		t.Fatalf("got no RuleInstrumentation")
+	}
+	john := &User{Name: "john"}
+
+	rs.Query(john, "view", "readme")
+	rs.Query(john, "view", "broken")
+	if len(queries.queries) != 2 || len(rules.queries) != 2 || len(rules.rules) != 2 {
+		t.Errorf("got queries %v and %v, rules %v", queries.queries, rules.queries, rules.rules)
+	}
+	// the recovered panics are reported to the rule instrumentations
+	if len(errs.errs) != 2 || errs.errs[0] != nil {
+		t.Fatalf("got errors %v", errs.errs)
+	}
+	if _, ok := errs.errs[1].(*MatcherPanic); !ok {
+		t.Errorf("got %v want a *MatcherPanic", errs.errs[1])
+	}
+
+	if _, ok := MultiInstrumentation(queries).(RuleInstrumentation); ok {
+		t.Errorf("got a RuleInstrumentation")
+	}
+}
+
+// errorInstrumentation records the errors of the matchers.
+type errorInstrumentation struct {
+	recordingInstrumentation
+	errs []error
+}
+
+func (ei *errorInstrumentation) StartRule(ctx context.Context, rule *RuleInfo) (context.Context, func(matched bool, effect Effect, err error)) {
+	return ctx, func(matched bool, effect Effect, err error) {
+		ei.errs = append(ei.errs, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// InterfaceOf returns a template matching every value implementing the interface T,
+// the same as passing (*T)(nil). It panics if T is not an interface type.
+//
+//	type Ownable interface{ OwnerName() string }
+//	rs.AddRule(&User{}, "edit", perms.InterfaceOf[Ownable](), matcher)
+func InterfaceOf[T any]() interface{} {
+	template := (*T)(nil)
+	if reflect.TypeOf(template).Elem().Kind() != reflect.Interface {
+		panic("perms: InterfaceOf called with a non interface type")
+	}
+	return template
+}
+
+// interfaceType returns the interface type T if template is a (*T)(nil) value.
+func interfaceType(template interface{}) (typ, bool) {
+	t := reflect.TypeOf(template)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		return nil, false
+	}
+	if !reflect.ValueOf(template).IsNil() {
+		return nil, false
+	}
+	return t.Elem(), true
+}
+
+// interfaceTemplate matches the values implementing an interface type.
+type interfaceTemplate struct {
+	t typ
+}
+
+func (iface interfaceTemplate) matchTemplate(value interface{}) bool {
+	t := reflect.TypeOf(value)
+	return t != nil && t.Implements(iface.t)
+}
+
+// interfaceKeys tracks the interface types used as keys in m3rules, in one position
+// (subject, action or resource), in order of registration.
+type interfaceKeys struct {
+	types []typ
+	refs  map[typ]int
+}
+
+// clone returns a copy of the keys that can be modified without affecting keys.
+func (keys interfaceKeys) clone() interfaceKeys {
+	refs := make(map[typ]int, len(keys.refs))
+	for t, n := range keys.refs {
+		refs[t] = n
+	}
+	return interfaceKeys{types: append([]typ(nil), keys.types...), refs: refs}
+}
+
+func (keys *interfaceKeys) add(t typ) {
+	if keys.refs == nil {
+		keys.refs = make(map[typ]int)
+	}
+	keys.refs[t]++
+	if keys.refs[t] == 1 {
+		keys.types = append(keys.types[:len(keys.types):len(keys.types)], t)
+	}
+}
+
+func (keys *interfaceKeys) remove(t typ) {
+	keys.refs[t]--
+	if keys.refs[t] > 0 {
+		return
+	}
+	delete(keys.refs, t)
+	remaining := make([]typ, 0, len(keys.types)-1)
+	for _, key := range keys.types {
+		if key != t {
+			remaining = append(remaining, key)
+		}
+	}
+	keys.types = remaining
+}
+
+// implementedBy returns the interface types implemented by t, except those in excluded.
+func (keys *interfaceKeys) implementedBy(t typ, excluded ...typ) []typ {
+	if t == nil || len(keys.types) == 0 {
+		return nil
+	}
+	var implemented []typ
+next:
+	for _, key := range keys.types {
+		if !t.Implements(key) {
+			continue
+		}
+		for _, other := range excluded {
+			if key == other {
+				continue next
+			}
+		}
+		implemented = append(implemented, key)
+	}
+	return implemented
+}
@@ -0,0 +1,82 @@
+package perms
+
+import (
+	"testing"
+)
+
+type NamedOwner interface {
+	OwnerName() string
+}
+
+func (video *Video) OwnerName() string {
+	return video.User
+}
+
+func (playlist *Playlist) OwnerName() string {
+	return playlist.User
+}
+
+func ownerMatcher(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+	return res.(NamedOwner).OwnerName() == subj.(*User).Name, ALLOW, false
+}
+
+func TestInterfaceTemplates(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	id := rs.AddRule(&User{}, "edit", (*NamedOwner)(nil), ownerMatcher)
+
+	john := &User{Name: "john"}
+	resources := []struct {
+		resource interface{}
+		want     string
+	}{
+		{&Video{Name: "intro", User: "john"}, ALLOW},
+		{&Video{Name: "outro", User: "jack"}, DENY},
+		{&Playlist{ID: "6563", User: "john"}, ALLOW},
+		{&Playlist{ID: "9374", User: "jack"}, DENY},
+		{&Archive{Name: "2019", User: "john"}, DENY},
+	}
+	for _, r := range resources {
+		if got := rs.Query(john, "edit", r.resource); got != r.want {
+			t.Errorf("%T: got %q want %q", r.resource, got, r.want)
+		}
+	}
+
+	d := rs.QueryExplain(john, "edit", &Video{User: "john"})
+	if d.Rule == nil || d.Rule.ID != id || d.Rule.ResourceType.Name() != "NamedOwner" {
+		t.Errorf("got %+v want the NamedOwner rule", d)
+	}
+
+	if err := rs.RemoveRule(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(john, "edit", &Video{User: "john"}); got != DENY {
+		t.Errorf("got %q want %q after removing the rule", got, DENY)
+	}
+	if n := len(rs.current().interfaces[2].types); n != 0 {
+		t.Errorf("got %d interface keys want 0", n)
+	}
+}
+
+func TestInterfaceTemplatesPrecedence(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "edit", InterfaceOf[NamedOwner](), ownerMatcher)
+	// rules for the exact type are evaluated first
+	rs.AddRule(&User{}, "edit", &Video{}, quickMatcher("review"))
+
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "edit", &Video{User: "john"}); got != "review" {
+		t.Errorf("got %q want %q", got, "review")
+	}
+	if got := rs.Query(john, "edit", &Playlist{User: "john"}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+}
+
+func TestInterfaceOfPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("InterfaceOf did not panic for a non interface type")
+		}
+	}()
+	InterfaceOf[Video]()
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Rules returns the descriptions of the rules of the rule set, see Walk.
+func (ruleSet *RuleSet) Rules() []RuleInfo {
+	return ruleSet.rulesOf(ruleSet.current())
+}
+
+func (ruleSet *RuleSet) rulesOf(table *ruleTable) []RuleInfo {
+	infos := make([]RuleInfo, 0, table.size)
+	ruleSet.walk(table, func(info RuleInfo) bool {
+		infos = append(infos, info)
+		return true
+	})
+	return infos
+}
+
+// Walk calls fn with the description of each rule of the rule set, until fn returns
+// false. The rules are grouped by their types triple, in the order the triples were
+// first registered, and the rules of a triple are visited in evaluation order.
+// The rules added or removed by fn are not visited.
+func (ruleSet *RuleSet) Walk(fn func(info RuleInfo) bool) {
+	ruleSet.walk(ruleSet.current(), fn)
+}
+
+func (ruleSet *RuleSet) walk(table *ruleTable, fn func(info RuleInfo) bool) {
+	ruleSet.walkTable(table, func(rule *Rule, info *RuleInfo) bool {
+		return fn(*info)
+	})
+}
+
+// walkRules is like Walk, but also passes the rules to fn.
+func (ruleSet *RuleSet) walkRules(fn func(rule *Rule, info *RuleInfo) bool) {
+	ruleSet.walkTable(ruleSet.current(), fn)
+}
+
+func (ruleSet *RuleSet) walkTable(table *ruleTable, fn func(rule *Rule, info *RuleInfo) bool) {
+	now := ruleSet.now()
+	visited := make(map[[3]typ]bool)
+	for _, first := range table.allRules() {
+		types := first.types()
+		if visited[types] {
+			continue
+		}
+		visited[types] = true
+		for index, rule := range table.m3rules[first.sT][first.aT][first.rT] {
+			info := rule.info(index)
+			info.Enabled = !rule.disabled && (!rule.expires() || rule.validAt(now))
+			ruleSet.stats.countersOf(rule).fill(info)
+			if !fn(rule, info) {
+				return
+			}
+		}
+	}
+}
+
+// SubjectTypes returns the distinct types of the subject templates of the rules, nil
+// for the "jolly" rules with a nil subject template, sorted by their string with nil
+// first. The interface types are those passed to InterfaceOf. The rules of the
+// namespaces are not considered, like in TripleCount and RuleCount.
+func (ruleSet *RuleSet) SubjectTypes() []reflect.Type {
+	return ruleSet.registeredTypes(0)
+}
+
+// ResourceTypes is like SubjectTypes, for the resource templates.
+func (ruleSet *RuleSet) ResourceTypes() []reflect.Type {
+	return ruleSet.registeredTypes(2)
+}
+
+func (ruleSet *RuleSet) registeredTypes(position int) []reflect.Type {
+	seen := make(map[typ]bool)
+	var types []reflect.Type
+	for _, rule := range ruleSet.current().allRules() {
+		if t := rule.types()[position]; !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return typeString(types[i]) < typeString(types[j])
+	})
+	return types
+}
+
+// typeString sorts nil before the types.
+func typeString(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// ActionTemplates returns the distinct action templates of the rules, as passed to
+// AddRule, including nil for the rules applying to any action, sorted by their type
+// and then by their value as formatted by fmt, with nil first. The templates that are
+// not comparable are listed once per distinct formatted value.
+func (ruleSet *RuleSet) ActionTemplates() []interface{} {
+	type entry struct {
+		template interface{}
+		typ, key string
+	}
+	seen := make(map[[2]string]bool)
+	var entries []entry
+	for _, rule := range ruleSet.current().allRules() {
+		e := entry{template: rule.action, typ: typeString(reflect.TypeOf(rule.action)), key: fmt.Sprintf("%#v", rule.action)}
+		if k := [2]string{e.typ, e.key}; !seen[k] {
+			seen[k] = true
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].typ != entries[j].typ {
+			return entries[i].typ < entries[j].typ
+		}
+		return entries[i].key < entries[j].key
+	})
+	templates := make([]interface{}, len(entries))
+	for i, e := range entries {
+		templates[i] = e.template
+	}
+	return templates
+}
+
+// TripleCount returns the number of distinct types triples the rules are registered
+// under, that is of the RuleLists evaluated in the passes of the queries.
+func (ruleSet *RuleSet) TripleCount() int {
+	n := 0
+	for _, aMap := range ruleSet.current().m3rules {
+		for _, rMap := range aMap {
+			n += len(rMap)
+		}
+	}
+	return n
+}
+
+// RuleCount returns the number of rules, the length of Rules.
+func (ruleSet *RuleSet) RuleCount() int {
+	return ruleSet.current().size
+}
@@ -0,0 +1,110 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	// the six rules of TestAddRule, and a rule for the same types as the first one.
+	// Since the action types are the same, the view and modify rules share a triple.
+	rs := newVideoRuleSet()
+	rs.AddRuleWithPriority(10, &User{}, "view", &Playlist{}, effectMatcher(DENY))
+
+	userType, playlistType, videoType := reflect.TypeOf(&User{}), reflect.TypeOf(&Playlist{}), reflect.TypeOf(&Video{})
+	groupType, actionType := reflect.TypeOf(&Group{}), reflect.TypeOf("")
+	want := []struct {
+		id       RuleID
+		subject  reflect.Type
+		action   interface{}
+		resource reflect.Type
+		index    int
+		priority int
+	}{
+		{"rule-7", userType, "view", playlistType, 0, 10},
+		{"rule-1", userType, "view", playlistType, 1, 0},
+		{"rule-2", userType, "modify", playlistType, 2, 0},
+		{"rule-3", userType, "view", videoType, 0, 0},
+		{"rule-4", userType, "modify", videoType, 1, 0},
+		{"rule-5", groupType, "modify", playlistType, 0, 0},
+		{"rule-6", userType, "view", nil, 0, 0},
+	}
+	rules := rs.Rules()
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules want %d", len(rules), len(want))
+	}
+	for i, w := range want {
+		info := rules[i]
+		if info.ID != w.id || info.Index != w.index || info.Priority != w.priority || !info.Enabled {
+			t.Errorf("%d: got %+v want id %s, index %d, priority %d", i, info, w.id, w.index, w.priority)
+		}
+		if info.SubjectType != w.subject || info.ActionType != actionType || info.ResourceType != w.resource {
+			t.Errorf("%d: got types (%v, %v, %v) want (%v, %v, %v)", i, info.SubjectType, info.ActionType, info.ResourceType, w.subject, actionType, w.resource)
+		}
+		if info.ActionTemplate != w.action || reflect.TypeOf(info.SubjectTemplate) != w.subject || reflect.TypeOf(info.ResourceTemplate) != w.resource {
+			t.Errorf("%d: got templates (%v, %v, %v)", i, info.SubjectTemplate, info.ActionTemplate, info.ResourceTemplate)
+		}
+	}
+
+	// the index is the evaluation order of the query
+	d := rs.QueryExplain(&User{Name: "john"}, "view", &Playlist{User: "john"})
+	if d.Rule == nil || d.Rule.ID != "rule-1" || d.Rule.Index != rules[1].Index {
+		t.Errorf("got %+v want rule-1 at index %d", d.Rule, rules[1].Index)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	rs := newVideoRuleSet()
+	var ids []RuleID
+	rs.Walk(func(info RuleInfo) bool {
+		ids = append(ids, info.ID)
+		return len(ids) < 3
+	})
+	if want := []RuleID{"rule-1", "rule-2", "rule-3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v want %v", ids, want)
+	}
+
+	if rules := NewRuleSet(DENY).Rules(); len(rules) != 0 {
+		t.Errorf("got %v for an empty rule set", rules)
+	}
+
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	rs = NewRuleSet(DENY)
+	rs.Now = func() time.Time { return now }
+	rs.AddRuleWithExpiry(&User{}, "view", nil, effectMatcher(ALLOW), time.Time{}, now.Add(-time.Hour))
+	rs.AddRuleWithExpiry(&User{}, "view", nil, effectMatcher(ALLOW), now, now.Add(time.Hour))
+	rules := rs.Rules()
+	if len(rules) != 2 || rules[0].Enabled || !rules[1].Enabled {
+		t.Errorf("got %+v want the expired rule disabled", rules)
+	}
+}
+
+func TestRegisteredTypes(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddRule(nil, nil, &Archive{}, effectMatcher(DENY))
+	rs.AddRule(&User{}, 3, &Video{}, effectMatcher(DENY))
+
+	if got, want := rs.SubjectTypes(), []reflect.Type{nil, reflect.TypeOf(&Group{}), reflect.TypeOf(&User{})}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got subject types %v want %v", got, want)
+	}
+	if got, want := rs.ResourceTypes(), []reflect.Type{nil, reflect.TypeOf(&Archive{}), reflect.TypeOf(&Playlist{}), reflect.TypeOf(&Video{})}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got resource types %v want %v", got, want)
+	}
+	if got, want := rs.ActionTemplates(), []interface{}{nil, 3, "modify", "view"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got action templates %v want %v", got, want)
+	}
+	// (User, string, Playlist), (User, string, Video), (Group, string, Playlist),
+	// (User, string, nil), (nil, nil, Archive), (User, int, Video)
+	if got := rs.TripleCount(); got != 6 {
+		t.Errorf("got %d triples want 6", got)
+	}
+	if got := rs.RuleCount(); got != 8 {
+		t.Errorf("got %d rules want 8", got)
+	}
+
+	empty := NewRuleSet(DENY)
+	if len(empty.SubjectTypes()) != 0 || len(empty.ActionTemplates()) != 0 || empty.TripleCount() != 0 || empty.RuleCount() != 0 {
+		t.Errorf("got registered types for an empty rule set")
+	}
+}
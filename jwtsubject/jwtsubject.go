@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package jwtsubject adapts the claims of JSON Web Tokens to go-perms subjects, so that
+// the rules of every service can be registered against the same subject type.
+//
+//	subject := jwtsubject.New(token.Claims.(jwt.MapClaims))
+//	rs.Query(subject, "read", invoice)
+package jwtsubject
+
+import (
+	"strings"
+
+	perms "github.com/panta/go-perms"
+)
+
+// Subject is a subject whose attributes are the claims of a token. Its PermKey is the
+// "sub" claim, see perms.Identifiable. Rules for any Subject are registered with the
+// &Subject{} template.
+type Subject struct {
+	claims map[string]interface{}
+}
+
+// New returns the subject of the claims, eg. a jwt.MapClaims. The claims must not be
+// modified afterwards.
+func New(claims map[string]interface{}) *Subject {
+	return &Subject{claims: claims}
+}
+
+// Claim returns the value of the claim with the given name, nil if missing.
+func (subject *Subject) Claim(name string) interface{} {
+	return subject.claims[name]
+}
+
+// Subject returns the "sub" claim.
+func (subject *Subject) Subject() string {
+	return subject.stringClaim("sub")
+}
+
+// Tenant returns the "tenant" claim.
+func (subject *Subject) Tenant() string {
+	return subject.stringClaim("tenant")
+}
+
+// Roles returns the "roles" claim, an array of strings or a space separated string.
+func (subject *Subject) Roles() []string {
+	return subject.listClaim("roles")
+}
+
+// Scopes returns the "scope" claim, a space separated string, or else the "scp" claim,
+// an array of strings.
+func (subject *Subject) Scopes() []string {
+	if scopes := subject.listClaim("scope"); scopes != nil {
+		return scopes
+	}
+	return subject.listClaim("scp")
+}
+
+// PermKey returns the "sub" claim, identifying the subject.
+func (subject *Subject) PermKey() string {
+	return subject.Subject()
+}
+
+// HasRole reports whether role is one of the Roles.
+func (subject *Subject) HasRole(role string) bool {
+	return contains(subject.Roles(), role)
+}
+
+// HasScope reports whether scope is one of the Scopes.
+func (subject *Subject) HasScope(scope string) bool {
+	return contains(subject.Scopes(), scope)
+}
+
+func (subject *Subject) stringClaim(name string) string {
+	s, _ := subject.claims[name].(string)
+	return s
+}
+
+func (subject *Subject) listClaim(name string) []string {
+	switch value := subject.claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []string:
+		return value
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AddScopeRule adds to rs a rule allowing the action on the resources of the type of
+// resourceType to the subjects with any of the scopes. The rule doesn't match the
+// other subjects.
+func AddScopeRule(rs *perms.RuleSet, action string, resourceType interface{}, scopes ...string) perms.RuleID {
+	return addClaimRule(rs, action, resourceType, (*Subject).HasScope, scopes)
+}
+
+// AddRoleRule is like AddScopeRule, for the subjects with any of the roles.
+func AddRoleRule(rs *perms.RuleSet, action string, resourceType interface{}, roles ...string) perms.RuleID {
+	return addClaimRule(rs, action, resourceType, (*Subject).HasRole, roles)
+}
+
+func addClaimRule(rs *perms.RuleSet, action string, resourceType interface{}, has func(subject *Subject, value string) bool, values []string) perms.RuleID {
+	values = append([]string(nil), values...)
+	return rs.AddRule(&Subject{}, action, resourceType, perms.WithEffect(func(subj interface{}, act interface{}, res interface{}) bool {
+		for _, value := range values {
+			if has(subj.(*Subject), value) {
+				return true
+			}
+		}
+		return false
+	}, perms.Allow, false))
+}
@@ -0,0 +1,69 @@
+package jwtsubject
+
+import (
+	"reflect"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+type invoice struct {
+	Tenant string
+}
+
+func TestSubject(t *testing.T) {
+	subject := New(map[string]interface{}{
+		"sub":    "user-42",
+		"tenant": "acme",
+		"roles":  []interface{}{"admin", "billing"},
+		"scope":  "invoices:read invoices:write",
+	})
+	if subject.Subject() != "user-42" || subject.PermKey() != "user-42" || subject.Tenant() != "acme" {
+		t.Errorf("got sub %q tenant %q", subject.Subject(), subject.Tenant())
+	}
+	if roles := subject.Roles(); !reflect.DeepEqual(roles, []string{"admin", "billing"}) {
+		t.Errorf("got roles %v", roles)
+	}
+	if scopes := subject.Scopes(); !reflect.DeepEqual(scopes, []string{"invoices:read", "invoices:write"}) {
+		t.Errorf("got scopes %v", scopes)
+	}
+	if scopes := New(map[string]interface{}{"scp": []interface{}{"a", "b"}}).Scopes(); !reflect.DeepEqual(scopes, []string{"a", "b"}) {
+		t.Errorf("got scp scopes %v", scopes)
+	}
+}
+
+func TestRules(t *testing.T) {
+	rs := perms.NewRuleSet(perms.Deny)
+	AddScopeRule(rs, "read", &invoice{}, "invoices:read", "invoices:admin")
+	AddRoleRule(rs, "delete", &invoice{}, "admin")
+	// a rule for a single subject, matching the other tokens of the same subject
+	rs.AddRule(New(map[string]interface{}{"sub": "auditor"}), "export", &invoice{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, perms.Allow, false
+	})
+	rs.AddRule(&Subject{}, "write", &invoice{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return subj.(*Subject).Tenant() == res.(*invoice).Tenant, perms.Allow, false
+	})
+
+	reader := New(map[string]interface{}{"sub": "user-1", "tenant": "acme", "scope": "invoices:read"})
+	admin := New(map[string]interface{}{"sub": "user-2", "roles": []interface{}{"admin"}})
+	auditor := New(map[string]interface{}{"sub": "auditor", "exp": 1700000000})
+	tests := []struct {
+		subject *Subject
+		action  string
+		want    string
+	}{
+		{reader, "read", perms.Allow},
+		{reader, "delete", perms.Deny},
+		{reader, "write", perms.Allow},
+		{admin, "read", perms.Deny},
+		{admin, "delete", perms.Allow},
+		{admin, "write", perms.Deny},
+		{auditor, "export", perms.Allow},
+		{reader, "export", perms.Deny},
+	}
+	for _, test := range tests {
+		if effect := rs.Query(test.subject, test.action, &invoice{Tenant: "acme"}); effect != test.want {
+			t.Errorf("%s %s: got %q want %q", test.subject.Subject(), test.action, effect, test.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// matchMapEntries reports whether every entry of the template map is present in value,
+// a map of the same type, with an equal value. The values are compared with sameValue,
+// so nested maps and slices must be deeply equal. An empty or nil template matches any
+// map.
+// Note that the maps decoded from JSON hold float64 numbers: a template entry must use
+// the same type to match.
+func matchMapEntries(template reflect.Value, value reflect.Value) bool {
+	if !template.IsValid() || template.Len() == 0 {
+		return true
+	}
+	if template.Type() != value.Type() || value.Len() < template.Len() {
+		return false
+	}
+	iter := template.MapRange()
+	for iter.Next() {
+		v := value.MapIndex(iter.Key())
+		if !v.IsValid() || !sameValue(iter.Value().Interface(), v.Interface()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapTemplates(t *testing.T) {
+	type claims = map[string]interface{}
+	rs := NewRuleSet(DENY)
+	var received interface{}
+	rs.AddRule(claims{"role": "editor", "scopes": []interface{}{"docs"}}, "edit", claims{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			received = subj
+			return true, ALLOW, false
+		})
+	rs.AddRule(claims{}, "view", claims{"public": true}, effectMatcher(ALLOW))
+
+	editor := claims{"sub": "john", "role": "editor", "scopes": []interface{}{"docs"}}
+	doc := claims{"id": "1", "public": false}
+	cases := []struct {
+		subject  claims
+		action   string
+		resource claims
+		effect   string
+	}{
+		{editor, "edit", doc, ALLOW},
+		{claims{"sub": "jack", "role": "viewer", "scopes": []interface{}{"docs"}}, "edit", doc, DENY},
+		// nested values must be deeply equal
+		{claims{"role": "editor", "scopes": []interface{}{"docs", "admin"}}, "edit", doc, DENY},
+		{claims{"role": "editor"}, "edit", doc, DENY},
+		{nil, "edit", doc, DENY},
+		{editor, "view", doc, DENY},
+		{editor, "view", claims{"id": "2", "public": true}, ALLOW},
+		{nil, "view", claims{"public": true}, ALLOW},
+	}
+	for _, c := range cases {
+		if effect := rs.Query(c.subject, c.action, c.resource); effect != c.effect {
+			t.Errorf("%v %s %v: got %q want %q", c.subject, c.action, c.resource, effect, c.effect)
+		}
+	}
+
+	// the matcher receives the queried map
+	received = nil
+	rs.Query(editor, "edit", doc)
+	if m, ok := received.(claims); !ok || reflect.ValueOf(m).Pointer() != reflect.ValueOf(editor).Pointer() || len(m) != 3 {
+		t.Errorf("got %v want the queried map", received)
+	}
+}
+
+func TestMapValuesNotLiteral(t *testing.T) {
+	// maps are never compared with ==, which would panic
+	for _, value := range []interface{}{map[string]interface{}{}, map[string]int{"a": 1}, map[int][]string{}} {
+		if q := newQueryValue(value); q.literal {
+			t.Errorf("got %T literal", value)
+		}
+	}
+	rs := NewRuleSet(DENY)
+	rs.AddRule(map[string]int{"a": 1}, "view", nil, effectMatcher(ALLOW))
+	rs.AddRule(nil, "view", map[string][]int{"a": {1}}, effectMatcher(ALLOW))
+	if !rs.IsAllowed(map[string]int{"a": 1, "b": 2}, "view", nil) || rs.IsAllowed(map[string]int{"a": 2}, "view", nil) {
+		t.Errorf("got the wrong decisions for the map subject")
+	}
+	if !rs.IsAllowed(nil, "view", map[string][]int{"a": {1}}) || rs.IsAllowed(nil, "view", map[string][]int{"a": {1, 2}}) {
+		t.Errorf("got the wrong decisions for the map resource")
+	}
+}
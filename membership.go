@@ -0,0 +1,175 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MembershipResolver tells the groups of the subjects, typically from a database. It
+// is set as RuleSet.Membership, and consulted by the rules added with
+// AddMembershipRule and by QueryWithGroups. Wrap it with NewMembershipCache to avoid
+// consulting it for every query.
+type MembershipResolver interface {
+	// IsMember reports whether the subject with subjectKey is a member of the group
+	// with groupKey.
+	IsMember(ctx context.Context, subjectKey string, groupKey string) (bool, error)
+	// GroupsOf returns the keys of the groups of the subject with subjectKey.
+	GroupsOf(ctx context.Context, subjectKey string) ([]string, error)
+}
+
+// GroupKey is the subject evaluated by QueryWithGroups for each group of the queried
+// subject: rules with a GroupKey("") subject template apply to any group, those with
+// GroupKey("editors") to the editors group.
+type GroupKey string
+
+// membershipKey returns the key of subject for the MembershipResolver.
+func (ruleSet *RuleSet) membershipKey(subject interface{}) (string, bool) {
+	if ruleSet.MembershipKey != nil {
+		return ruleSet.MembershipKey(subject)
+	}
+	return valueKey(subject)
+}
+
+// AddMembershipRule is like AddGroupOwnershipRule, but the membership of the subject
+// in the group of the resource is resolved through the Membership of the rule set,
+// with the context of the query. The errors of the resolver are returned by the
+// error-returning queries, eg. QueryCtx. The rules don't match the subjects without a
+// key or when the rule set has no Membership.
+func (ruleSet *RuleSet) AddMembershipRule(subjectType interface{}, actions []string, resourceType interface{}, effect string, options ...RuleOption) []RuleID {
+	return ruleSet.addPerActionCtx(subjectType, actions, resourceType, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		owned, ok := res.(GroupOwnable)
+		if !ok || ruleSet.Membership == nil {
+			return false, "", false, nil
+		}
+		key, ok := ruleSet.membershipKey(subj)
+		if !ok {
+			return false, "", false, nil
+		}
+		member, err := ruleSet.Membership.IsMember(ctx, key, owned.GroupID())
+		if err != nil || !member {
+			return false, "", false, err
+		}
+		return true, effect, false, nil
+	}, options)
+}
+
+// QueryWithGroups is like QueryMultiCtx, for subject followed by the GroupKey of each of
+// its groups, as returned by the Membership of the rule set. The errors of the
+// resolver are returned along with the default effect.
+func (ruleSet *RuleSet) QueryWithGroups(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (MultiDecision, error) {
+	groups, err := ruleSet.groupsOf(ctx, subject)
+	if err != nil {
+		return ruleSet.multiDefault(), err
+	}
+	return ruleSet.QueryMultiCtx(ctx, append([]interface{}{subject}, groups...), action, resource)
+}
+
+// groupsOf returns the GroupKey of each group of subject, see QueryWithGroups.
+func (ruleSet *RuleSet) groupsOf(ctx context.Context, subject interface{}) ([]interface{}, error) {
+	key, ok := ruleSet.membershipKey(subject)
+	if !ok || ruleSet.Membership == nil {
+		return nil, nil
+	}
+	keys, err := ruleSet.Membership.GroupsOf(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]interface{}, len(keys))
+	for i, group := range keys {
+		groups[i] = GroupKey(group)
+	}
+	return groups, nil
+}
+
+// MembershipCache is a MembershipResolver caching the answers of another one for a
+// fixed time. The errors are not cached. It is safe for concurrent use.
+type MembershipCache struct {
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+
+	resolver MembershipResolver
+	ttl      time.Duration
+	mu       sync.Mutex
+	members  map[[2]string]cachedMembership
+	groups   map[string]cachedGroups
+}
+
+type cachedMembership struct {
+	member  bool
+	expires time.Time
+}
+
+type cachedGroups struct {
+	groups  []string
+	expires time.Time
+}
+
+// NewMembershipCache returns a MembershipCache keeping the answers of resolver for ttl.
+func NewMembershipCache(resolver MembershipResolver, ttl time.Duration) *MembershipCache {
+	return &MembershipCache{
+		resolver: resolver,
+		ttl:      ttl,
+		members:  make(map[[2]string]cachedMembership),
+		groups:   make(map[string]cachedGroups),
+	}
+}
+
+func (cache *MembershipCache) now() time.Time {
+	if cache.Now != nil {
+		return cache.Now()
+	}
+	return time.Now()
+}
+
+// IsMember implements MembershipResolver.
+func (cache *MembershipCache) IsMember(ctx context.Context, subjectKey string, groupKey string) (bool, error) {
+	key := [2]string{subjectKey, groupKey}
+	now := cache.now()
+	cache.mu.Lock()
+	cached, ok := cache.members[key]
+	cache.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.member, nil
+	}
+	member, err := cache.resolver.IsMember(ctx, subjectKey, groupKey)
+	if err != nil {
+		return false, err
+	}
+	cache.mu.Lock()
+	cache.members[key] = cachedMembership{member: member, expires: now.Add(cache.ttl)}
+	cache.mu.Unlock()
+	return member, nil
+}
+
+// GroupsOf implements MembershipResolver. The returned slice is shared by the callers.
+func (cache *MembershipCache) GroupsOf(ctx context.Context, subjectKey string) ([]string, error) {
+	now := cache.now()
+	cache.mu.Lock()
+	cached, ok := cache.groups[subjectKey]
+	cache.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.groups, nil
+	}
+	groups, err := cache.resolver.GroupsOf(ctx, subjectKey)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.groups[subjectKey] = cachedGroups{groups: groups, expires: now.Add(cache.ttl)}
+	cache.mu.Unlock()
+	return groups, nil
+}
+
+// Purge discards the cached answers, eg. after changing the groups in the database.
+func (cache *MembershipCache) Purge() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.members = make(map[[2]string]cachedMembership)
+	cache.groups = make(map[string]cachedGroups)
+}
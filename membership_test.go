@@ -0,0 +1,118 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// membershipMap is a MembershipResolver counting its calls.
+type membershipMap struct {
+	groups map[string][]string
+	err    error
+	calls  int
+}
+
+func (m *membershipMap) IsMember(ctx context.Context, subjectKey string, groupKey string) (bool, error) {
+	m.calls++
+	for _, group := range m.groups[subjectKey] {
+		if group == groupKey {
+			return true, m.err
+		}
+	}
+	return false, m.err
+}
+
+func (m *membershipMap) GroupsOf(ctx context.Context, subjectKey string) ([]string, error) {
+	m.calls++
+	return m.groups[subjectKey], m.err
+}
+
+func TestMembershipRule(t *testing.T) {
+	resolver := &membershipMap{groups: map[string][]string{"john": {"editors"}}}
+	rs := NewRuleSet(DENY)
+	rs.Membership = resolver
+	rs.MembershipKey = userKey
+	ids := rs.AddMembershipRule(&User{}, []string{"view", "modify"}, &Playlist{}, ALLOW)
+	if len(ids) != 2 {
+		t.Fatalf("got rules %v want 2", ids)
+	}
+
+	john, jack := &User{Name: "john"}, &User{Name: "jack"}
+	playlist := &Playlist{ID: "6563", Group: "editors"}
+	if !rs.IsAllowed(john, "modify", playlist) || rs.IsAllowed(jack, "modify", playlist) {
+		t.Errorf("got the wrong membership decisions")
+	}
+	if rs.IsAllowed(john, "delete", playlist) {
+		t.Errorf("got delete allowed want denied")
+	}
+
+	resolver.err = errors.New("unreachable")
+	if effect, err := rs.QueryCtx(context.Background(), john, "modify", playlist); err != resolver.err || effect != DENY {
+		t.Errorf("got %q, %v want the default effect and the resolver error", effect, err)
+	}
+}
+
+func TestQueryWithGroups(t *testing.T) {
+	resolver := &membershipMap{groups: map[string][]string{"john": {"banned", "editors"}}}
+	rs := NewRuleSet(DENY)
+	rs.Membership = resolver
+	rs.AddRule("", "view", nil, effectMatcher(ALLOW))
+	rs.AddRule(GroupKey("editors"), "modify", nil, effectMatcher(ALLOW))
+	rs.AddRule(GroupKey("banned"), "view", nil, effectMatcher(DENY))
+
+	d, err := rs.QueryWithGroups(context.Background(), "john", "modify", "doc")
+	if err != nil || d.Effect != ALLOW || d.Subject != GroupKey("editors") || d.Index != 2 {
+		t.Errorf("got %+v, %v want an allow for the editors group", d, err)
+	}
+	// any deny wins
+	if d, _ := rs.QueryWithGroups(context.Background(), "john", "view", "doc"); d.Effect != DENY || d.Subject != GroupKey("banned") {
+		t.Errorf("got %+v want a deny for the banned group", d)
+	}
+	if d, _ := rs.QueryWithGroups(context.Background(), "jack", "modify", "doc"); !d.Default {
+		t.Errorf("got %+v want the default effect", d)
+	}
+
+	resolver.err = errors.New("unreachable")
+	if d, err := rs.QueryWithGroups(context.Background(), "john", "view", "doc"); err != resolver.err || !d.Default || d.Index != -1 {
+		t.Errorf("got %+v, %v want the default effect and the resolver error", d, err)
+	}
+}
+
+func TestMembershipCache(t *testing.T) {
+	resolver := &membershipMap{groups: map[string][]string{"john": {"editors"}}}
+	cache := NewMembershipCache(resolver, time.Minute)
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if member, err := cache.IsMember(ctx, "john", "editors"); !member || err != nil {
+			t.Fatalf("got %v, %v want a member", member, err)
+		}
+		if groups, err := cache.GroupsOf(ctx, "john"); len(groups) != 1 || err != nil {
+			t.Fatalf("got %v, %v want the editors group", groups, err)
+		}
+	}
+	if resolver.calls != 2 {
+		t.Errorf("got %d calls want 2", resolver.calls)
+	}
+
+	// the errors are not cached
+	now = now.Add(time.Minute)
+	resolver.err = errors.New("unreachable")
+	if _, err := cache.IsMember(ctx, "john", "editors"); err != resolver.err {
+		t.Errorf("got %v want the resolver error once expired", err)
+	}
+	resolver.err = nil
+	if member, err := cache.IsMember(ctx, "john", "editors"); !member || err != nil || resolver.calls != 4 {
+		t.Errorf("got %v, %v after %d calls want a member looked up again", member, err, resolver.calls)
+	}
+
+	cache.Purge()
+	cache.GroupsOf(ctx, "john")
+	if resolver.calls != 5 {
+		t.Errorf("got %d calls want 5 after Purge", resolver.calls)
+	}
+}
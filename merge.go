@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// Merge adds all the rules of other to the rule set, in their insertion order. For the
+// same types triple and priority, the rules of other come after the existing ones.
+// Rules whose id is already taken in the rule set get a new generated id.
+// The type names of other missing in the rule set are registered too, while the rules
+// of the namespaces of other are not merged.
+// The options of the rule set, like DefaultEffect and Roles, are kept: the options of
+// other are ignored. Later changes to either rule set don't affect the other one.
+func (ruleSet *RuleSet) Merge(other *RuleSet) {
+	for name, t := range other.types.byName {
+		if _, ok := ruleSet.types.byName[name]; !ok {
+			ruleSet.types.byName[name] = t
+			ruleSet.types.names[t] = name
+		}
+	}
+	rules := other.current().allRules()
+	ruleSet.update(func(w *tableWriter) {
+		for _, rule := range rules {
+			merged := *rule
+			if _, ok := ruleSet.byID[merged.id]; ok {
+				merged.id = ruleSet.nextRuleID()
+				if merged.decl != nil && merged.decl.ID != "" {
+					decl := *merged.decl
+					decl.ID = merged.id
+					merged.decl = &decl
+				}
+			}
+			w.add(&merged)
+		}
+	})
+}
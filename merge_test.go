@@ -0,0 +1,144 @@
+package perms
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newVideoRuleSet returns a rule set with the playlist and video rules of TestAddRule.
+func newVideoRuleSet() *RuleSet {
+	rs := NewRuleSet(DENY)
+	ownerOrPublic := func(public bool, owner string, user *User) (bool, string, bool) {
+		if user.IsSuperuser {
+			return true, ALLOW, true
+		}
+		if public || owner == user.Name {
+			return true, ALLOW, false
+		}
+		return true, DENY, false
+	}
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			playlist := res.(*Playlist)
+			return ownerOrPublic(playlist.Public, playlist.User, subj.(*User))
+		})
+	rs.AddRule(&User{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*Playlist).User == subj.(*User).Name {
+				return true, ALLOW, false
+			}
+			return true, DENY, false
+		})
+	rs.AddRule(&User{}, "view", &Video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			video := res.(*Video)
+			return ownerOrPublic(video.Public, video.User, subj.(*User))
+		})
+	rs.AddRule(&User{}, "modify", &Video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return ownerOrPublic(false, res.(*Video).User, subj.(*User))
+		})
+	rs.AddRule(&Group{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*Playlist).Group == subj.(*Group).Name {
+				return true, ALLOW, false
+			}
+			return true, DENY, false
+		})
+	rs.AddRule(&User{}, "view", nil,
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if subj.(*User).IsSuperuser {
+				return true, ALLOW, true
+			}
+			return true, DENY, false
+		})
+	return rs
+}
+
+var (
+	videoSubjects = []interface{}{
+		&User{Name: "john"},
+		&User{Name: "jack"},
+		&User{Name: "admin", IsSuperuser: true},
+		&Group{Name: "editors"},
+	}
+	videoActions   = []interface{}{"view", "modify", "delete"}
+	videoResources = []interface{}{
+		&Playlist{ID: "6563", User: "john", Group: "editors"},
+		&Playlist{ID: "9374", User: "jack", Public: true},
+		&Video{Name: "intro", User: "jack"},
+		&Video{Name: "outro", User: "john", Public: true},
+		&Archive{Name: "2019", User: "john"},
+	}
+)
+
+// checkSameDecisions checks that rs and want produce the same effects for all the
+// combinations of the video subjects, actions and resources.
+func checkSameDecisions(t *testing.T, rs *RuleSet, want *RuleSet) {
+	t.Helper()
+	for _, subject := range videoSubjects {
+		for _, action := range videoActions {
+			for _, resource := range videoResources {
+				if got, want := rs.Query(subject, action, resource), want.Query(subject, action, resource); got != want {
+					t.Errorf("(%v, %v, %v): got %q want %q", subject, action, resource, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	source := newVideoRuleSet()
+	rs := NewRuleSet(DENY)
+	rs.Merge(source)
+	checkSameDecisions(t, rs, source)
+
+	// merged rules are independent from the source
+	source.AddRule(nil, nil, nil, quickMatcher(ALLOW))
+	if got := rs.Query(&User{Name: "john"}, "delete", &Archive{}); got != DENY {
+		t.Errorf("got %q want %q after changing the source", got, DENY)
+	}
+	rs.AddRule(nil, nil, nil, quickMatcher("none"))
+	if got := source.Query(&User{Name: "john"}, "delete", &Archive{}); got != ALLOW {
+		t.Errorf("got %q want %q after changing the merged set", got, ALLOW)
+	}
+}
+
+func TestMergeOrder(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRuleWithID("a", &User{}, "view", &Video{}, effectMatcher("first"))
+	other := NewRuleSet(ALLOW)
+	other.AddRuleWithID("a", &User{}, "view", &Video{}, effectMatcher("second"))
+	other.AddRuleWithID("b", &User{}, "view", &Video{}, effectMatcher("third"))
+
+	rs.Merge(other)
+	if rs.DefaultEffect != DENY {
+		t.Errorf("got default effect %q want %q", rs.DefaultEffect, DENY)
+	}
+	// the conflicting id "a" of other is replaced
+	var ids []RuleID
+	rs.findRules(newQueryValue(&User{}), newQueryValue("view"), newQueryValue(&Video{}), func(c candidate) bool {
+		ids = append(ids, c.rule.id)
+		return true
+	})
+	if want := []RuleID{"a", "rule-1", "b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got rules %v want %v", ids, want)
+	}
+	if got := rs.Query(&User{}, "view", &Video{}); got != "third" {
+		t.Errorf("got %q want %q", got, "third")
+	}
+}
+
+func TestMergeDeclarative(t *testing.T) {
+	other := newPolicyRuleSet()
+	if err := other.LoadJSON(strings.NewReader(playlistPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	rs := NewRuleSet(DENY)
+	rs.Merge(other)
+	checkPlaylistPolicy(t, rs)
+	if got, want := len(rs.DeclarativeRules()), len(other.DeclarativeRules()); got != want {
+		t.Errorf("got %d declarative rules want %d", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// RuleOption sets metadata of a rule being added, reported in RuleInfo and thus in
+// the decisions and to the AuditFn hook.
+type RuleOption func(rule *Rule)
+
+// Named sets the human-readable name of the rule. Names need not be unique, see
+// DuplicateRuleNames.
+func Named(name string) RuleOption {
+	return func(rule *Rule) {
+		rule.name = name
+	}
+}
+
+// Described sets the free-form description of the rule.
+func Described(description string) RuleOption {
+	return func(rule *Rule) {
+		rule.description = description
+	}
+}
+
+// Tagged adds tags to the rule, see RulesByTag.
+func Tagged(tags ...string) RuleOption {
+	return func(rule *Rule) {
+		rule.tags = append(rule.tags, tags...)
+	}
+}
+
+func (rule *Rule) apply(options []RuleOption) {
+	for _, option := range options {
+		option(rule)
+	}
+	// the rules are shared once added, the tags must not be appended to anymore
+	rule.tags = rule.tags[:len(rule.tags):len(rule.tags)]
+}
+
+// copyTags returns a copy of tags, so that the rule tags can't be altered through it.
+func copyTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return append([]string(nil), tags...)
+}
+
+// RulesByTag returns the descriptions of the rules with the given tag, in the order
+// of Walk.
+func (ruleSet *RuleSet) RulesByTag(tag string) []RuleInfo {
+	var infos []RuleInfo
+	ruleSet.Walk(func(info RuleInfo) bool {
+		for _, t := range info.Tags {
+			if t == tag {
+				infos = append(infos, info)
+				break
+			}
+		}
+		return true
+	})
+	return infos
+}
+
+// DuplicateRuleNames returns the names given to more than one rule, with the ids of
+// those rules in insertion order, or nil if every name is unique.
+func (ruleSet *RuleSet) DuplicateRuleNames() map[string][]RuleID {
+	byName := make(map[string][]RuleID)
+	for _, rule := range ruleSet.current().allRules() {
+		if rule.name != "" {
+			byName[rule.name] = append(byName[rule.name], rule.id)
+		}
+	}
+	var duplicates map[string][]RuleID
+	for name, ids := range byName {
+		if len(ids) > 1 {
+			if duplicates == nil {
+				duplicates = make(map[string][]RuleID)
+			}
+			duplicates[name] = ids
+		}
+	}
+	return duplicates
+}
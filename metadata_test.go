@@ -0,0 +1,63 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRuleMetadata(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	var audited *RuleInfo
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		audited = rule
+	}
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW),
+		Named("public videos"), Described("anyone can view the videos"), Tagged("videos", "public"))
+	rs.AddRuleWithPriority(1, &User{}, "delete", &Video{}, effectMatcher(DENY), Named("no deletions"), Tagged("billing"))
+	rs.AddRule(&User{}, "modify", &Video{}, effectMatcher(ALLOW), Tagged("videos"))
+
+	john := &User{Name: "john"}
+	d := rs.QueryExplain(john, "view", &Video{})
+	want := RuleInfo{Name: "public videos", Description: "anyone can view the videos", Tags: []string{"videos", "public"}}
+	if d.Rule == nil || d.Rule.Name != want.Name || d.Rule.Description != want.Description || !reflect.DeepEqual(d.Rule.Tags, want.Tags) {
+		t.Fatalf("got %+v want %+v", d.Rule, want)
+	}
+	if audited == nil || audited.Name != want.Name || !reflect.DeepEqual(audited.Tags, want.Tags) {
+		t.Errorf("got %+v in the audit hook", audited)
+	}
+	// the tags of the rule can't be altered through the decisions
+	d.Rule.Tags[0] = "changed"
+	audited.Tags[1] = "changed"
+	if d := rs.QueryExplain(john, "view", &Video{}); !reflect.DeepEqual(d.Rule.Tags, want.Tags) {
+		t.Errorf("got tags %v want %v", d.Rule.Tags, want.Tags)
+	}
+
+	var ids []RuleID
+	for _, info := range rs.RulesByTag("videos") {
+		ids = append(ids, info.ID)
+	}
+	if want := []RuleID{"rule-1", "rule-3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v want %v", ids, want)
+	}
+	if infos := rs.RulesByTag("billing"); len(infos) != 1 || infos[0].Name != "no deletions" {
+		t.Errorf("got %+v for the billing tag", infos)
+	}
+	if infos := rs.RulesByTag("unknown"); infos != nil {
+		t.Errorf("got %+v for an unknown tag", infos)
+	}
+}
+
+func TestDuplicateRuleNames(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW), Named("videos"))
+	rs.AddRule(&User{}, "view", nil, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "modify", nil, effectMatcher(ALLOW))
+	if duplicates := rs.DuplicateRuleNames(); duplicates != nil {
+		t.Errorf("got %v want no duplicates", duplicates)
+	}
+	rs.AddRuleWithID("videos-2", &User{}, "modify", &Video{}, effectMatcher(ALLOW), Named("videos"))
+	want := map[string][]RuleID{"videos": {"rule-1", "videos-2"}}
+	if duplicates := rs.DuplicateRuleNames(); !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("got %v want %v", duplicates, want)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// MultiDecision is the outcome of QueryMulti.
+type MultiDecision struct {
+	Decision
+	// Subject is the subject whose evaluation produced the decision, nil when the
+	// default effect is used.
+	Subject interface{}
+	// Index is the position of Subject in the queried subjects, -1 when the default
+	// effect is used.
+	Index int
+}
+
+// QueryMulti evaluates the (subject, action, resource) triple for each of the subjects
+// (eg. a user and each of its groups), and combines the effects produced by rules or
+// role grants with the rule set combining strategy. Since for a set of subjects the
+// sensible default is that any deny wins, the LastApplicable strategy is replaced by
+// DenyOverrides. The default decision, with the default effect of the DefaultEffectFn
+// or of the resource type (see SetDefaultEffectForType) for the first subject, is used
+// only if no subject produced an effect.
+func (ruleSet *RuleSet) QueryMulti(subjects []interface{}, action interface{}, resource interface{}) MultiDecision {
+	decision, _ := ruleSet.queryMulti(context.Background(), subjects, nil, action, resource, false)
+	return decision
+}
+
+// QueryMultiCtx is like QueryMulti, but passes ctx to the matchers, and stops at the
+// first evaluation returning an error, returning the default effect along with that
+// error, while QueryMulti ignores the subjects whose evaluation fails.
+func (ruleSet *RuleSet) QueryMultiCtx(ctx context.Context, subjects []interface{}, action interface{}, resource interface{}) (MultiDecision, error) {
+	return ruleSet.queryMulti(ctx, subjects, nil, action, resource, true)
+}
+
+// queryMulti evaluates the subjects, with the options of the same index if options is
+// non-nil.
+func (ruleSet *RuleSet) queryMulti(ctx context.Context, subjects []interface{}, options []queryOptions, action interface{}, resource interface{}, strict bool) (MultiDecision, error) {
+	strategy := ruleSet.Combining
+	if strategy == LastApplicable {
+		strategy = DenyOverrides
+	}
+
+	var decisions []MultiDecision
+	var effects []Effect
+	var fallback *Decision
+	for i, subject := range subjects {
+		var opts queryOptions
+		if options != nil {
+			opts = options[i]
+		}
+		decision, err := ruleSet.evaluateWith(ctx, opts, subject, action, resource)
+		if err != nil && strict {
+			return ruleSet.multiDefault(), err
+		}
+		if err != nil {
+			continue
+		}
+		if decision.Default {
+			if fallback == nil {
+				fallback = &decision
+			}
+			continue
+		}
+		decisions = append(decisions, MultiDecision{Decision: decision, Subject: subject, Index: i})
+		effects = append(effects, decision.Effect)
+	}
+	winner := combineEffects(strategy, effects)
+	if winner < 0 {
+		if fallback == nil {
+			// no subject, or the evaluations of all of them failed
+			return MultiDecision{Decision: ruleSet.defaultDecision(ruleSet.current(), nil, action, resource), Index: -1}, nil
+		}
+		return MultiDecision{Decision: *fallback, Index: -1}, nil
+	}
+	return decisions[winner], nil
+}
+
+// multiDefault returns the MultiDecision with the default effect.
+func (ruleSet *RuleSet) multiDefault() MultiDecision {
+	return MultiDecision{
+		Decision: Decision{Effect: ruleSet.DefaultEffect, Default: true},
+		Index:    -1,
+	}
+}
@@ -0,0 +1,74 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestQueryMulti(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+	rs.AddRule(&Group{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*Playlist).Group == subj.(*Group).Name {
+				return true, ALLOW, false
+			}
+			return true, DENY, false
+		})
+
+	john := &User{Name: "john"}
+	editors := &Group{Name: "editors", Members: []string{"john"}}
+	john_friends := &Group{Name: "john", Members: []string{"john"}}
+	jack_playlist := &Playlist{ID: "9374", User: "jack", Group: "editors"}
+
+	if got := rs.Query(john, "modify", jack_playlist); got != DENY {
+		t.Fatalf("got %q want %q for the user alone", got, DENY)
+	}
+	d := rs.QueryMulti([]interface{}{john, editors}, "modify", jack_playlist)
+	if d.Effect != ALLOW || d.Subject != editors || d.Index != 1 {
+		t.Errorf("got %+v want an allow through the editors group", d)
+	}
+
+	// any explicit deny wins
+	d = rs.QueryMulti([]interface{}{john, editors, john_friends}, "modify", jack_playlist)
+	if d.Effect != DENY || d.Subject != john_friends || d.Index != 2 || d.Default {
+		t.Errorf("got %+v want a deny through the john group", d)
+	}
+	rs.Combining = FirstApplicable
+	d = rs.QueryMulti([]interface{}{john, john_friends, editors}, "modify", jack_playlist)
+	if d.Effect != DENY || d.Subject != john_friends {
+		t.Errorf("got %+v want the first effect with FirstApplicable", d)
+	}
+
+	d = rs.QueryMulti([]interface{}{john}, "delete", jack_playlist)
+	if d.Effect != DENY || !d.Default || d.Subject != nil || d.Index != -1 {
+		t.Errorf("got %+v want the default effect", d)
+	}
+}
+
+func TestQueryMultiDefault(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.SetDefaultEffectForType(&Playlist{}, ALLOW)
+	john := &User{Name: "john"}
+	editors := &Group{Name: "editors"}
+	playlist := &Playlist{ID: "9374", User: "jack"}
+
+	if d := rs.QueryMulti([]interface{}{john, editors}, "view", playlist); d.Effect != ALLOW || !d.Default || d.Index != -1 {
+		t.Errorf("got %+v want the default effect of the playlists", d)
+	}
+	if d := rs.QueryMulti(nil, "view", playlist); d.Effect != ALLOW || !d.Default {
+		t.Errorf("got %+v want the default effect of the playlists without subjects", d)
+	}
+
+	rs.DefaultEffectFn = func(subject interface{}, action interface{}, resource interface{}) string {
+		if _, ok := subject.(*User); ok {
+			return "review"
+		}
+		return ""
+	}
+	if d := rs.QueryMulti([]interface{}{john, editors}, "view", playlist); d.Effect != "review" || d.Reason != DynamicDefaultReason {
+		t.Errorf("got %+v want the dynamic default of the first subject", d)
+	}
+}
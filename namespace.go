@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"sort"
+)
+
+// Namespace is a scoped view of a RuleSet, eg. for a tenant: the rules added through
+// a Namespace are only evaluated by the queries made through the same namespace,
+// while the rules added to the RuleSet itself are shared by all the namespaces.
+// The queries of a namespace evaluate, in each fallback pass, the rules of the
+// namespace before the shared ones, combining their effects with the strategy of the
+// RuleSet, whose options (DefaultEffect, Roles...) apply to all the namespaces.
+// The queries through a namespace with rules don't use the cache enabled with WithCache.
+type Namespace struct {
+	ruleSet *RuleSet
+	name    string
+}
+
+// Namespace returns the namespace with the given name, which exists as long as it
+// has rules. The empty name is the namespace of the shared rules.
+func (ruleSet *RuleSet) Namespace(name string) *Namespace {
+	return &Namespace{ruleSet: ruleSet, name: name}
+}
+
+// Namespaces returns the names of the namespaces with rules, sorted.
+func (ruleSet *RuleSet) Namespaces() []string {
+	var names []string
+	for name := range ruleSet.current().namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteNamespace removes all the rules of the namespace, returning how many were removed.
+func (ruleSet *RuleSet) DeleteNamespace(name string) int {
+	if name == "" {
+		return 0
+	}
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	root := ruleSet.current()
+	ns := root.namespaces[name]
+	if ns == nil {
+		return 0
+	}
+	for _, rule := range ns.allRules() {
+		delete(ruleSet.byID, rule.id)
+	}
+	delete(ruleSet.rules.tails, name)
+	ruleSet.publish(root, root.withNamespace(name, nil))
+	return ns.size
+}
+
+// removeRule removes the rule with the given id, if it belongs to the namespace or
+// anyNamespace is true.
+func (ruleSet *RuleSet) removeRule(id RuleID, namespace string, anyNamespace bool) error {
+	ruleSet.rules.mu.Lock()
+	rule, ok := ruleSet.byID[id]
+	ruleSet.rules.mu.Unlock()
+	if !ok || (!anyNamespace && rule.namespace != namespace) {
+		return ErrRuleNotFound
+	}
+	err := ErrRuleNotFound
+	ruleSet.updateIn(rule.namespace, func(w *tableWriter) {
+		if ruleSet.byID[id] == rule {
+			w.remove(rule)
+			err = nil
+		}
+	})
+	return err
+}
+
+// Name returns the name of the namespace.
+func (namespace *Namespace) Name() string {
+	return namespace.name
+}
+
+// AddRule is like RuleSet.AddRule, adding the rule to the namespace.
+func (namespace *Namespace) AddRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) RuleID {
+	return namespace.AddRuleE(subjectType, actionType, resourceType, matcher.ErrFn(), options...)
+}
+
+// AddRuleE is like RuleSet.AddRuleE, adding the rule to the namespace.
+func (namespace *Namespace) AddRuleE(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherErrFn, options ...RuleOption) RuleID {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	namespace.ruleSet.addRulesIn(namespace.name, rule)
+	return rule.id
+}
+
+// AddRuleCtx is like RuleSet.AddRuleCtx, adding the rule to the namespace.
+func (namespace *Namespace) AddRuleCtx(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherCtxFn, options ...RuleOption) RuleID {
+	rule := newRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	namespace.ruleSet.addRulesIn(namespace.name, rule)
+	return rule.id
+}
+
+// AddRuleWithPriority is like RuleSet.AddRuleWithPriority, adding the rule to the namespace.
+func (namespace *Namespace) AddRuleWithPriority(priority int, subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) RuleID {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	rule.priority = priority
+	namespace.ruleSet.addRulesIn(namespace.name, rule)
+	return rule.id
+}
+
+// AddRuleWithID is like RuleSet.AddRuleWithID, adding the rule to the namespace. The
+// ids are unique across all the namespaces of the rule set.
+func (namespace *Namespace) AddRuleWithID(id RuleID, subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) error {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	rule.id = id
+	return namespace.ruleSet.addRulesIn(namespace.name, rule)
+}
+
+// RemoveRule is like RuleSet.RemoveRule, but only removes the rules of the namespace.
+func (namespace *Namespace) RemoveRule(id RuleID) error {
+	return namespace.ruleSet.removeRule(id, namespace.name, false)
+}
+
+// Rules is like RuleSet.Rules, for the rules of the namespace only.
+func (namespace *Namespace) Rules() []RuleInfo {
+	if namespace.name == "" {
+		return namespace.ruleSet.Rules()
+	}
+	ns := namespace.ruleSet.current().namespaces[namespace.name]
+	if ns == nil {
+		return []RuleInfo{}
+	}
+	return namespace.ruleSet.rulesOf(ns)
+}
+
+// Query is like RuleSet.Query, evaluating the rules of the namespace and the shared ones.
+func (namespace *Namespace) Query(subject interface{}, action interface{}, resource interface{}) string {
+	return namespace.QueryExplain(subject, action, resource).Effect
+}
+
+// IsAllowed is like RuleSet.IsAllowed, for the namespace.
+func (namespace *Namespace) IsAllowed(subject interface{}, action interface{}, resource interface{}) bool {
+	return namespace.Query(subject, action, resource) == Allow
+}
+
+// QueryExplain is like RuleSet.QueryExplain, for the namespace.
+func (namespace *Namespace) QueryExplain(subject interface{}, action interface{}, resource interface{}) Decision {
+	decision, _ := namespace.QueryDecision(context.Background(), subject, action, resource)
+	return decision
+}
+
+// QueryE is like RuleSet.QueryE, for the namespace.
+func (namespace *Namespace) QueryE(subject interface{}, action interface{}, resource interface{}) (string, error) {
+	return namespace.QueryCtx(context.Background(), subject, action, resource)
+}
+
+// QueryCtx is like RuleSet.QueryCtx, for the namespace.
+func (namespace *Namespace) QueryCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (string, error) {
+	decision, err := namespace.QueryDecision(ctx, subject, action, resource)
+	return decision.Effect, err
+}
+
+// QueryDecision is like RuleSet.QueryDecision, for the namespace.
+func (namespace *Namespace) QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	ruleSet := namespace.ruleSet
+	if namespace.name == "" {
+		return ruleSet.evaluate(ctx, subject, action, resource)
+	}
+	// the rules of the namespace and the shared ones are from the same version
+	root := ruleSet.current()
+	opts := queryOptions{table: root, namespace: root.namespaces[namespace.name]}
+	return ruleSet.evaluateWith(ctx, opts, subject, action, resource)
+}
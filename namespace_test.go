@@ -0,0 +1,129 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamespaces(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, func(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+		return subject.(*User).Name + ":" + action.(string) + ":" + resource.(*Video).Name, true
+	})
+	// shared: anyone can view the public videos
+	rs.AddRule(&User{}, "view", &Video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Video).Public, ALLOW, false
+		})
+	tenantA, tenantB := rs.Namespace("tenant-a"), rs.Namespace("tenant-b")
+	// tenant A: its users can edit anything they own, and never view the drafts
+	tenantA.AddRule(&User{}, "edit", (*NamedOwner)(nil), ownerMatcher, Named("owners"))
+	tenantA.AddRule(&User{}, "view", &Video{}, quickMatcher(DENY))
+	// tenant B: anyone can view anything
+	tenantB.AddRule(&User{}, "view", nil, effectMatcher(ALLOW))
+
+	john := &User{Name: "john"}
+	public := &Video{Name: "intro", User: "john", Public: true}
+	draft := &Video{Name: "draft", User: "john"}
+
+	// the shared rules apply to the root and all the namespaces
+	if !rs.IsAllowed(john, "view", public) || rs.IsAllowed(john, "view", draft) || rs.IsAllowed(john, "edit", draft) {
+		t.Errorf("got the namespace rules applied to the shared queries")
+	}
+	// the quick deny of tenant A comes before the shared allow
+	if d := tenantA.QueryExplain(john, "view", public); d.Effect != DENY || d.Rule == nil || d.Rule.ID != "rule-3" {
+		t.Errorf("got %+v want the tenant A deny", d)
+	}
+	if d := tenantA.QueryExplain(john, "edit", draft); d.Effect != ALLOW || d.Rule == nil || d.Rule.Name != "owners" {
+		t.Errorf("got %+v want the tenant A owners rule", d)
+	}
+	// tenant A's rules never influence tenant B's queries
+	if !tenantB.IsAllowed(john, "view", public) || !tenantB.IsAllowed(john, "view", draft) || tenantB.IsAllowed(john, "edit", draft) {
+		t.Errorf("got the tenant A rules applied to tenant B")
+	}
+	if effect := rs.Namespace("tenant-c").Query(john, "view", draft); effect != DENY {
+		t.Errorf("got %q for a namespace without rules", effect)
+	}
+	// the queries of the namespaces don't use the cache of the shared rules
+	if effect := rs.Query(john, "view", public); effect != ALLOW {
+		t.Errorf("got %q from the cache", effect)
+	}
+	if effect := tenantA.Query(john, "view", public); effect != DENY {
+		t.Errorf("got %q for tenant A after caching the shared decision", effect)
+	}
+
+	if got := rs.Namespaces(); !reflect.DeepEqual(got, []string{"tenant-a", "tenant-b"}) {
+		t.Errorf("got namespaces %v", got)
+	}
+	if n := len(tenantA.Rules()); n != 2 || len(rs.Rules()) != 1 || len(rs.Namespace("").Rules()) != 1 {
+		t.Errorf("got %d tenant A rules, %d shared rules", n, len(rs.Rules()))
+	}
+
+	// the rules can only be removed through their namespace, or the rule set
+	if err := tenantB.RemoveRule("rule-3"); err != ErrRuleNotFound {
+		t.Errorf("got %v removing a tenant A rule from tenant B", err)
+	}
+	if err := tenantA.RemoveRule("rule-3"); err != nil {
+		t.Fatal(err)
+	}
+	if !tenantA.IsAllowed(john, "view", public) {
+		t.Errorf("got tenant A view denied after removing its deny rule")
+	}
+	if err := rs.RemoveRule("rule-4"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Namespaces(); !reflect.DeepEqual(got, []string{"tenant-a"}) {
+		t.Errorf("got namespaces %v after removing the tenant B rules", got)
+	}
+
+	// deleting a namespace drops all its rules
+	tenantA.AddRule(&User{}, "delete", nil, effectMatcher(ALLOW))
+	if n := rs.DeleteNamespace("tenant-a"); n != 2 {
+		t.Errorf("got %d rules removed want 2", n)
+	}
+	if tenantA.IsAllowed(john, "edit", draft) || tenantA.IsAllowed(john, "delete", draft) || len(tenantA.Rules()) != 0 {
+		t.Errorf("got the tenant A rules after deleting the namespace")
+	}
+	if err := rs.RemoveRule("rule-2"); err != ErrRuleNotFound {
+		t.Errorf("got %v removing a deleted rule", err)
+	}
+	if n := rs.DeleteNamespace("tenant-a"); n != 0 || rs.Namespaces() != nil {
+		t.Errorf("got %d rules removed, namespaces %v", n, rs.Namespaces())
+	}
+	// the ids are unique across the namespaces
+	if err := tenantB.AddRuleWithID("rule-1", &User{}, "view", nil, effectMatcher(ALLOW)); err != ErrDuplicateRuleID {
+		t.Errorf("got %v want ErrDuplicateRuleID", err)
+	}
+}
+
+func TestNamespacesCombining(t *testing.T) {
+	rs := NewRuleSet("none")
+	tenant := rs.Namespace("tenant")
+	rs.AddRule(&User{}, "view", nil, effectMatcher(ALLOW))
+	tenant.AddRule(&User{}, "view", nil, effectMatcher(DENY))
+	john := &User{Name: "john"}
+
+	// with LastApplicable the shared rules, evaluated last, win
+	if effect := tenant.Query(john, "view", nil); effect != ALLOW {
+		t.Errorf("got %q with LastApplicable", effect)
+	}
+	rs.Combining = FirstApplicable
+	if effect := tenant.Query(john, "view", nil); effect != DENY {
+		t.Errorf("got %q with FirstApplicable", effect)
+	}
+	rs.Combining = DenyOverrides
+	if effect := tenant.Query(john, "view", nil); effect != DENY {
+		t.Errorf("got %q with DenyOverrides", effect)
+	}
+
+	// expired rules of the namespaces are pruned too
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	rs.Now = func() time.Time { return now }
+	expired := newRule(&User{}, "edit", nil, effectMatcher(ALLOW).ErrFn().CtxFn())
+	expired.notAfter = now.Add(-time.Hour)
+	rs.addRulesIn("other", expired)
+	rs.AddRuleWithExpiry(&User{}, "edit", nil, effectMatcher(ALLOW), time.Time{}, now.Add(-time.Hour))
+	if n := rs.PruneExpired(); n != 2 || !reflect.DeepEqual(rs.Namespaces(), []string{"tenant"}) {
+		t.Errorf("got %d rules pruned, namespaces %v", n, rs.Namespaces())
+	}
+}
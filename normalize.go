@@ -0,0 +1,31 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// counterpartOf returns the pointer/value counterpart of q: a pointer to a copy of
+// a non-pointer value, or the value pointed to by a non-nil pointer.
+func counterpartOf(q *queryValue) *queryValue {
+	if q.t == nil || q.t.Kind() == reflect.Interface {
+		return nil
+	}
+	var counterpart queryValue
+	if q.t.Kind() == reflect.Ptr {
+		v := reflect.ValueOf(q.value)
+		if v.IsNil() || q.t.Elem().Kind() == reflect.Interface {
+			return nil
+		}
+		counterpart = newQueryValue(v.Elem().Interface())
+	} else {
+		p := reflect.New(q.t)
+		p.Elem().Set(reflect.ValueOf(q.value))
+		counterpart = newQueryValue(p.Interface())
+	}
+	return &counterpart
+}
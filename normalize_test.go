@@ -0,0 +1,93 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestNormalizePointers(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	var received []interface{}
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			received = append(received, res)
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+	// value templates are compared with the whole value
+	rs.AddRule(&User{}, "view", Video{Name: "intro"},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			received = append(received, res)
+			return true, ALLOW, false
+		})
+
+	john := &User{Name: "john"}
+	playlist := Playlist{ID: "6563", User: "john"}
+	video := &Video{Name: "intro"}
+	if got := rs.Query(john, "view", playlist); got != DENY {
+		t.Errorf("got %q want %q without normalization", got, DENY)
+	}
+	if got := rs.Query(john, "view", video); got != DENY {
+		t.Errorf("got %q want %q without normalization", got, DENY)
+	}
+
+	rs.NormalizePointers = true
+	received = nil
+	// value queried, pointer rule
+	if got := rs.Query(john, "view", playlist); got != ALLOW {
+		t.Errorf("got %q want %q for a playlist value", got, ALLOW)
+	}
+	// pointer queried, value rule
+	if got := rs.Query(john, "view", video); got != ALLOW {
+		t.Errorf("got %q want %q for a video pointer", got, ALLOW)
+	}
+	if len(received) != 2 {
+		t.Fatalf("got %d matcher calls want 2", len(received))
+	}
+	if p, ok := received[0].(*Playlist); !ok || p == &playlist || p.ID != playlist.ID {
+		t.Errorf("got %#v want a pointer to a copy of the playlist", received[0])
+	}
+	if v, ok := received[1].(Video); !ok || v != *video {
+		t.Errorf("got %#v want the video value", received[1])
+	}
+
+	// nil pointers only match pointer rules
+	received = nil
+	if got := rs.Query(john, "view", (*Video)(nil)); got != DENY {
+		t.Errorf("got %q want %q for a nil video", got, DENY)
+	}
+	if len(received) != 0 {
+		t.Errorf("got %d matcher calls want 0", len(received))
+	}
+}
+
+func TestNormalizePointersPrecedence(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.NormalizePointers = true
+	rs.AddRule(&User{}, "view", &Video{}, quickMatcher(ALLOW))
+	rs.AddRule(&User{}, "view", Video{}, quickMatcher("value"))
+
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Video{}); got != ALLOW {
+		t.Errorf("got %q want %q from the pointer rule", got, ALLOW)
+	}
+	if got := rs.Query(john, "view", Video{}); got != "value" {
+		t.Errorf("got %q want %q from the value rule", got, "value")
+	}
+	// the value template is compared with the whole value
+	if got := rs.Query(john, "view", &Video{Name: "intro"}); got != ALLOW {
+		t.Errorf("got %q want %q from the pointer rule", got, ALLOW)
+	}
+	if got := rs.Query(john, "view", Video{Name: "intro"}); got != ALLOW {
+		t.Errorf("got %q want %q from the pointer rule", got, ALLOW)
+	}
+}
+
+func TestNormalizePointersInterfaces(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.NormalizePointers = true
+	// only *Video implements NamedOwner
+	rs.AddRule(&User{}, "edit", InterfaceOf[NamedOwner](), ownerMatcher)
+
+	if got := rs.Query(&User{Name: "john"}, "edit", Video{User: "john"}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// Obligation is a requirement attached to an effect, which the caller must fulfil
+// when enforcing the decision, eg. {"watermark", "confidential"} for an Allow.
+type Obligation struct {
+	Name  string
+	Value interface{}
+}
+
+// ObligationMatcherFn is like MatcherCtxFn, but can also return the obligations
+// attached to the effect.
+type ObligationMatcherFn func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool, obligations []Obligation, err error)
+
+// AddRuleWithObligations is like AddRuleCtx, but the matcher can attach obligations to
+// the effect. The obligations of the rules producing the final effect of a query are
+// reported in Decision.Obligations, see QueryDecision.
+func (ruleSet *RuleSet) AddRuleWithObligations(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher ObligationMatcherFn, options ...RuleOption) RuleID {
+	rule := newObligationRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+func newObligationRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher ObligationMatcherFn) *Rule {
+	rule := newRule(subjectType, actionType, resourceType, func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		matches, effect, quick, _, err := matcher(ctx, subject, action, resource)
+		return matches, effect, quick, err
+	})
+	rule.obligationMatcher = matcher
+	return rule
+}
+
+// Querier answers queries with their Decision. It is implemented by RuleSet, Snapshot,
+// and by the clients of remote rule sets like the one of package grpcservice, so that
+// applications can switch between embedded and remote evaluation.
+type Querier interface {
+	QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error)
+}
+
+var (
+	_ Querier = (*RuleSet)(nil)
+	_ Querier = (*Snapshot)(nil)
+)
+
+// QueryDecision is like QueryCtx, but returns the Decision, with the obligations of the
+// rules producing the effect in evaluation order. The obligations of a rule are kept
+// only as long as the effect it produced is the effect of the query: when a later rule
+// overrides the effect with a different one, they are dropped.
+func (ruleSet *RuleSet) QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	return ruleSet.evaluate(ctx, subject, action, resource)
+}
@@ -0,0 +1,116 @@
+package perms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// obligationMatcher returns a matcher producing effect with the given obligations.
+func obligationMatcher(effect string, obligations ...Obligation) ObligationMatcherFn {
+	return func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, []Obligation, error) {
+		return true, effect, false, obligations, nil
+	}
+}
+
+func TestObligations(t *testing.T) {
+	watermark := Obligation{Name: "watermark", Value: "confidential"}
+	fields := Obligation{Name: "fields", Value: []string{"Name", "Duration"}}
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+
+	rs := NewRuleSet(DENY)
+	rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(ALLOW, watermark))
+	decision, err := rs.QueryDecision(context.Background(), john, "view", video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Effect != ALLOW || !reflect.DeepEqual(decision.Obligations, []Obligation{watermark}) {
+		t.Errorf("got %+v want allow with the watermark obligation", decision)
+	}
+
+	// the obligations of the rules producing the same effect are merged in evaluation order
+	rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(ALLOW, fields))
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	decision, _ = rs.QueryDecision(context.Background(), john, "view", video)
+	if decision.Effect != ALLOW || !reflect.DeepEqual(decision.Obligations, []Obligation{watermark, fields}) {
+		t.Errorf("got %+v want allow with the watermark and fields obligations", decision)
+	}
+	if got := rs.QueryExplain(john, "view", video).Obligations; !reflect.DeepEqual(got, []Obligation{watermark, fields}) {
+		t.Errorf("got %v from QueryExplain", got)
+	}
+
+	// a later rule overriding the effect drops them
+	rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(DENY, Obligation{Name: "notify", Value: "owner"}))
+	decision, _ = rs.QueryDecision(context.Background(), john, "view", video)
+	if want := []Obligation{{Name: "notify", Value: "owner"}}; decision.Effect != DENY || !reflect.DeepEqual(decision.Obligations, want) {
+		t.Errorf("got %+v want deny with the notify obligation", decision)
+	}
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	decision, _ = rs.QueryDecision(context.Background(), john, "view", video)
+	if decision.Effect != ALLOW || decision.Obligations != nil {
+		t.Errorf("got %+v want allow with no obligations", decision)
+	}
+}
+
+func TestObligationsCombining(t *testing.T) {
+	watermark := Obligation{Name: "watermark"}
+	audit := Obligation{Name: "audit"}
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	cases := []struct {
+		combining CombiningStrategy
+		effect    Effect
+		want      []Obligation
+	}{
+		{LastApplicable, DENY, nil},
+		{FirstApplicable, ALLOW, []Obligation{watermark}},
+		{DenyOverrides, DENY, nil},
+		{AllowOverrides, ALLOW, []Obligation{watermark}},
+	}
+	for _, c := range cases {
+		rs := NewRuleSet("none")
+		rs.Combining = c.combining
+		rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(ALLOW, watermark))
+		rs.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+		decision, err := rs.QueryDecision(context.Background(), john, "view", video)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Effect != c.effect || !reflect.DeepEqual(decision.Obligations, c.want) {
+			t.Errorf("%v: got %+v want %q with %v", c.combining, decision, c.effect, c.want)
+		}
+	}
+
+	// with DenyOverrides, the rules producing the first non-overriding effect contribute
+	rs := NewRuleSet("none")
+	rs.Combining = DenyOverrides
+	rs.AddRuleWithObligations(&User{}, "view", nil, obligationMatcher(ALLOW, audit))
+	rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(ALLOW, watermark))
+	decision, _ := rs.QueryDecision(context.Background(), john, "view", video)
+	if want := []Obligation{watermark, audit}; decision.Effect != ALLOW || !reflect.DeepEqual(decision.Obligations, want) {
+		t.Errorf("got %+v want allow with %v", decision, want)
+	}
+}
+
+func TestObligationsCached(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, func(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+		return "key", true
+	})
+	rs.AddRuleWithObligations(&User{}, "view", &Video{}, obligationMatcher(ALLOW, Obligation{Name: "watermark"}))
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	first, _ := rs.QueryDecision(context.Background(), john, "view", video)
+	first.Obligations[0].Name = "changed"
+	if stats := rs.Stats(); stats.RuleHits["rule-1"] != 1 {
+		t.Fatalf("got %d rule hits want 1", stats.RuleHits["rule-1"])
+	}
+	second, _ := rs.QueryDecision(context.Background(), john, "view", video)
+	if want := []Obligation{{Name: "watermark"}}; !reflect.DeepEqual(second.Obligations, want) {
+		t.Errorf("got %v from the cache want %v", second.Obligations, want)
+	}
+	if stats := rs.Stats(); stats.RuleHits["rule-1"] != 1 {
+		t.Errorf("got %d rule hits want the cached decision", stats.RuleHits["rule-1"])
+	}
+}
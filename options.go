@@ -0,0 +1,71 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// QueryOption configures a single query of QueryOpt. The options only affect the query
+// they are passed to, never the RuleSet, so that concurrent queries can use different
+// ones.
+type QueryOption func(opts *queryOptions)
+
+// WithTrace makes the query call traceFn for every step of the evaluation, see
+// QueryTrace.
+func WithTrace(traceFn func(ev TraceEvent)) QueryOption {
+	return func(opts *queryOptions) {
+		opts.trace = traceFn
+	}
+}
+
+// WithDefaultEffect makes the query produce effect when no rule nor role applies, or
+// when the evaluation fails, instead of the DefaultEffect of the RuleSet and of the
+// default effects of the resource types (see SetDefaultEffectForType).
+func WithDefaultEffect(effect Effect) QueryOption {
+	return func(opts *queryOptions) {
+		opts.defaultEffect = &effect
+	}
+}
+
+// WithMaxEvaluations limits the number of matchers run by the query, instead of
+// RuleSet.MaxEvaluations. Zero means no limit.
+func WithMaxEvaluations(max int) QueryOption {
+	return func(opts *queryOptions) {
+		opts.maxEvaluations = &max
+	}
+}
+
+// WithCombining makes the query merge the effects of the matching rules, and of the
+// roles, with strategy instead of RuleSet.Combining.
+func WithCombining(strategy CombiningStrategy) QueryOption {
+	return func(opts *queryOptions) {
+		opts.combining = &strategy
+	}
+}
+
+// QueryOpt is like QueryExplain, with the given options. The queries overriding the
+// settings of the RuleSet, with WithDefaultEffect, WithMaxEvaluations or WithCombining,
+// and those with an environment (see WithEnv) don't use the cache enabled with
+// WithCache. Query is QueryOpt without options.
+func (ruleSet *RuleSet) QueryOpt(subject interface{}, action interface{}, resource interface{}, options ...QueryOption) Decision {
+	if len(options) == 0 {
+		// spare the allocation of the options, escaping to the heap
+		decision, _ := ruleSet.evaluate(context.Background(), subject, action, resource)
+		return decision
+	}
+	opts := new(queryOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	decision, _ := ruleSet.evaluateWith(context.Background(), *opts, subject, action, resource)
+	return decision
+}
+
+// overrides reports whether the options override the settings of the RuleSet.
+func (opts *queryOptions) overrides() bool {
+	return opts.defaultEffect != nil || opts.maxEvaluations != nil || opts.combining != nil
+}
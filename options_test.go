@@ -0,0 +1,71 @@
+package perms
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQueryOpt(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, playlistKey)
+	rs.AddRule(&User{}, "view", &Playlist{}, effectMatcher(DENY))
+	rs.AddRule(&User{}, "view", &Playlist{}, effectMatcher(ALLOW))
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563"}
+
+	if d := rs.QueryOpt(john, "view", playlist); d.Effect != ALLOW || d.Rule.ID != "rule-2" {
+		t.Errorf("got %+v want the last rule", d)
+	}
+	// two options in one call: the first rule wins, and the passes are traced
+	var rules []RuleID
+	d := rs.QueryOpt(john, "view", playlist, WithCombining(FirstApplicable), WithTrace(func(ev TraceEvent) {
+		if ev.Kind == TraceRule {
+			rules = append(rules, ev.Rule.ID)
+		}
+	}))
+	if d.Effect != DENY || d.Rule.ID != "rule-1" {
+		t.Errorf("got %+v want the first rule", d)
+	}
+	if len(rules) != 1 || rules[0] != "rule-1" {
+		t.Errorf("got traced rules %v want rule-1", rules)
+	}
+	// the override is not cached, nor did it change the rule set
+	if d := rs.QueryOpt(john, "view", playlist); d.Effect != ALLOW || rs.Combining != LastApplicable {
+		t.Errorf("got %+v want the last rule", d)
+	}
+
+	// the budget stops the evaluation, producing the overridden default
+	d = rs.QueryOpt(john, "view", playlist, WithMaxEvaluations(1), WithDefaultEffect("unknown"))
+	if d.Effect != "unknown" || !d.Default || d.Evaluations != 1 {
+		t.Errorf("got %+v want the overridden default after one evaluation", d)
+	}
+	rs.SetDefaultEffectForType(&Video{}, ALLOW)
+	if d := rs.QueryOpt(john, "view", &Video{}, WithDefaultEffect("unknown")); d.Effect != "unknown" {
+		t.Errorf("got %+v want the default of the type overridden", d)
+	}
+	if got := rs.Query(john, "view", &Video{}); got != ALLOW {
+		t.Errorf("got %q want the default of the type", got)
+	}
+}
+
+func TestQueryOptConcurrent(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := Effect(string(rune('a' + i)))
+			for j := 0; j < 100; j++ {
+				if d := rs.QueryOpt(john, "delete", &Video{}, WithDefaultEffect(want)); d.Effect != want {
+					t.Errorf("got %q want %q", d.Effect, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := rs.Query(john, "delete", &Video{}); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package otelperm traces the queries of go-perms rule sets with OpenTelemetry, see
+// perms.RuleSet.Instrumentation:
+//
+//	rs.Instrumentation = otelperm.New(otel.GetTracerProvider(), nil)
+//
+// Each query has a span, a child of the span in the context of the query (see
+// perms.RuleSet.QueryCtx), with the types of the subject and of the resource and the
+// action, and once decided the effect, the rule producing it, the number of matchers
+// run and whether the decision comes from the cache. Optionally each matcher run has a
+// child span too, whose context is passed to the matcher.
+package otelperm
+
+import (
+	"context"
+	"fmt"
+
+	perms "github.com/panta/go-perms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the tracer.
+const ScopeName = "github.com/panta/go-perms/otelperm"
+
+// The attributes of the spans.
+const (
+	SubjectTypeKey  = attribute.Key("perms.subject.type")
+	ActionKey       = attribute.Key("perms.action")
+	ResourceTypeKey = attribute.Key("perms.resource.type")
+	EffectKey       = attribute.Key("perms.effect")
+	// RuleIDKey and RuleNameKey identify the rule producing the effect of a query, or
+	// the rule of a matcher span.
+	RuleIDKey   = attribute.Key("perms.rule.id")
+	RuleNameKey = attribute.Key("perms.rule.name")
+	// DefaultKey is set when the effect is the default one, no rule applying.
+	DefaultKey     = attribute.Key("perms.default")
+	EvaluationsKey = attribute.Key("perms.evaluations")
+	CachedKey      = attribute.Key("perms.cached")
+	// MatchedKey reports whether the rule of a matcher span applied.
+	MatchedKey = attribute.Key("perms.matched")
+)
+
+// Options configures the tracing.
+type Options struct {
+	// SpanName is the name of the query spans, "perms.Query" if empty.
+	SpanName string
+	// RuleSpans enables a span for each matcher run, named "perms.Rule". They can be
+	// many, since a query usually runs several matchers.
+	RuleSpans bool
+}
+
+// queryTracer traces the queries.
+type queryTracer struct {
+	tracer trace.Tracer
+	name   string
+}
+
+// ruleTracer also traces the matchers.
+type ruleTracer struct {
+	queryTracer
+}
+
+// New returns an instrumentation tracing the queries with the tracers of provider.
+// The options can be nil.
+func New(provider trace.TracerProvider, options *Options) perms.Instrumentation {
+	t := queryTracer{tracer: provider.Tracer(ScopeName), name: "perms.Query"}
+	if options != nil {
+		if options.SpanName != "" {
+			t.name = options.SpanName
+		}
+		if options.RuleSpans {
+			return &ruleTracer{t}
+		}
+	}
+	return &t
+}
+
+func (t *queryTracer) StartQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (context.Context, func(decision perms.Decision, err error)) {
+	ctx, span := t.tracer.Start(ctx, t.name, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(
+		SubjectTypeKey.String(typeName(subject)),
+		ActionKey.String(fmt.Sprint(action)),
+		ResourceTypeKey.String(typeName(resource)),
+	))
+	return ctx, func(decision perms.Decision, err error) {
+		attributes := []attribute.KeyValue{
+			EffectKey.String(decision.Effect),
+			DefaultKey.Bool(decision.Default),
+			EvaluationsKey.Int(decision.Evaluations),
+			CachedKey.Bool(decision.Cached),
+		}
+		span.SetAttributes(append(attributes, ruleAttributes(decision.Rule)...)...)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (t *ruleTracer) StartRule(ctx context.Context, rule *perms.RuleInfo) (context.Context, func(matched bool, effect perms.Effect, err error)) {
+	ctx, span := t.tracer.Start(ctx, "perms.Rule", trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(ruleAttributes(rule)...))
+	return ctx, func(matched bool, effect perms.Effect, err error) {
+		span.SetAttributes(MatchedKey.Bool(matched), EffectKey.String(effect))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func ruleAttributes(rule *perms.RuleInfo) []attribute.KeyValue {
+	if rule == nil {
+		return nil
+	}
+	attributes := []attribute.KeyValue{RuleIDKey.String(string(rule.ID))}
+	if rule.Name != "" {
+		attributes = append(attributes, RuleNameKey.String(rule.Name))
+	}
+	return attributes
+}
+
+// typeName returns the name of the type of value, eg. "*main.User".
+func typeName(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,134 @@
+package otelperm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type user struct {
+	Name string
+}
+
+type document struct {
+	Owner string
+}
+
+func newRuleSet(exporter *tracetest.InMemoryExporter, options *Options) *perms.RuleSet {
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.AddRule(&user{}, "view", &document{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res.(*document).Owner == subj.(*user).Name, perms.Allow, false
+	}, perms.Named("owner"))
+	rs.Instrumentation = New(provider, options)
+	return rs
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestQuerySpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	rs := newRuleSet(exporter, nil)
+	john := &user{Name: "john"}
+
+	for _, tc := range []struct {
+		resource *document
+		effect   string
+		rule     string
+	}{
+		{&document{Owner: "john"}, perms.Allow, "owner"},
+		{&document{Owner: "jack"}, perms.Deny, ""},
+	} {
+		exporter.Reset()
+		if effect := rs.Query(john, "view", tc.resource); effect != tc.effect {
+			t.Fatalf("got %s want %s", effect, tc.effect)
+		}
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("got %d spans want 1", len(spans))
+		}
+		if spans[0].Name != "perms.Query" {
+			t.Errorf("got span %q", spans[0].Name)
+		}
+		values := attributes(spans[0])
+		for key, want := range map[attribute.Key]attribute.Value{
+			SubjectTypeKey:  attribute.StringValue("*otelperm.user"),
+			ActionKey:       attribute.StringValue("view"),
+			ResourceTypeKey: attribute.StringValue("*otelperm.document"),
+			EffectKey:       attribute.StringValue(tc.effect),
+			DefaultKey:      attribute.BoolValue(tc.rule == ""),
+			EvaluationsKey:  attribute.IntValue(1),
+			CachedKey:       attribute.BoolValue(false),
+		} {
+			if values[key] != want {
+				t.Errorf("%s: got %s %v want %v", tc.effect, key, values[key].Emit(), want.Emit())
+			}
+		}
+		if name, ok := values[RuleNameKey]; ok != (tc.rule != "") || name.AsString() != tc.rule {
+			t.Errorf("got rule name %v want %q", name.Emit(), tc.rule)
+		}
+	}
+
+	// the decisions from the cache
+	rs.WithCache(10, func(subj interface{}, act interface{}, res interface{}) (string, bool) {
+		return subj.(*user).Name + ":" + res.(*document).Owner, true
+	})
+	rs.Query(john, "view", &document{Owner: "john"})
+	exporter.Reset()
+	rs.Query(john, "view", &document{Owner: "john"})
+	if spans := exporter.GetSpans(); len(spans) != 1 || attributes(spans[0])[CachedKey] != attribute.BoolValue(true) {
+		t.Errorf("got %v want a cached decision", spans)
+	}
+}
+
+func TestRuleSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	rs := newRuleSet(exporter, &Options{SpanName: "authorize", RuleSpans: true})
+	failure := errors.New("no clock")
+	var matcherSpan trace.SpanContext
+	rs.AddRuleCtx(nil, "edit", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		matcherSpan = trace.SpanContextFromContext(ctx)
+		return false, "", false, failure
+	})
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	if _, err := rs.QueryCtx(ctx, &user{Name: "john"}, "edit", &document{}); err != failure {
+		t.Fatalf("got %v want the matcher error", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans want 2", len(spans))
+	}
+	rule, query := spans[0], spans[1]
+	if query.Name != "authorize" || rule.Name != "perms.Rule" {
+		t.Fatalf("got spans %q and %q", query.Name, rule.Name)
+	}
+	if query.Parent.SpanID() != parent.SpanContext().SpanID() || rule.Parent.SpanID() != query.SpanContext.SpanID() {
+		t.Errorf("got the spans out of the hierarchy")
+	}
+	if matcherSpan.SpanID() != rule.SpanContext.SpanID() {
+		t.Errorf("got the matcher called without its span")
+	}
+	if query.Status.Code != codes.Error || rule.Status.Code != codes.Error || attributes(rule)[MatchedKey] != attribute.BoolValue(false) {
+		t.Errorf("got statuses %v and %v", query.Status, rule.Status)
+	}
+	if rule.EndTime.Before(rule.StartTime) || query.EndTime.Sub(query.StartTime) < rule.EndTime.Sub(rule.StartTime) {
+		t.Errorf("got the rule span outside the query span")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+// Ownable is implemented by the resources owned by a subject, see AddOwnershipRule.
+type Ownable interface {
+	OwnerID() string
+}
+
+// GroupOwnable is implemented by the resources owned by a group of subjects, see
+// AddGroupOwnershipRule.
+type GroupOwnable interface {
+	GroupID() string
+}
+
+// AddOwnershipRule adds, for each of the actions, a rule producing effect when the
+// resource is Ownable and its OwnerID is equal to the subjectID of the subject. The
+// rule doesn't match the other resources. It returns the ids of the rules, in the
+// order of the actions, so that they can be removed independently; the options are
+// applied to every rule.
+//
+//	rs.AddOwnershipRule(&User{}, []string{"modify", "delete"}, &Playlist{},
+//		func(subject interface{}) string { return subject.(*User).Name }, perms.ALLOW)
+func (ruleSet *RuleSet) AddOwnershipRule(subjectType interface{}, actions []string, resourceType interface{}, subjectID func(subject interface{}) string, effect string, options ...RuleOption) []RuleID {
+	return ruleSet.addPerAction(subjectType, actions, resourceType, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		if owned, ok := res.(Ownable); ok && owned.OwnerID() == subjectID(subj) {
+			return true, effect, false
+		}
+		return false, "", false
+	}, options)
+}
+
+// AddGroupOwnershipRule is like AddOwnershipRule, but the rules produce effect when
+// the resource is GroupOwnable and the subject is a member of the group with its
+// GroupID, as reported by memberOf.
+func (ruleSet *RuleSet) AddGroupOwnershipRule(subjectType interface{}, actions []string, resourceType interface{}, memberOf func(subject interface{}, groupID string) bool, effect string, options ...RuleOption) []RuleID {
+	return ruleSet.addPerAction(subjectType, actions, resourceType, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		if owned, ok := res.(GroupOwnable); ok && memberOf(subj, owned.GroupID()) {
+			return true, effect, false
+		}
+		return false, "", false
+	}, options)
+}
+
+// addPerAction adds a rule with matcher for each of the actions, returning their ids.
+func (ruleSet *RuleSet) addPerAction(subjectType interface{}, actions []string, resourceType interface{}, matcher MatcherFn, options []RuleOption) []RuleID {
+	return ruleSet.addPerActionCtx(subjectType, actions, resourceType, matcher.ErrFn().CtxFn(), options)
+}
+
+// addPerActionCtx is like addPerAction, for a MatcherCtxFn.
+func (ruleSet *RuleSet) addPerActionCtx(subjectType interface{}, actions []string, resourceType interface{}, matcher MatcherCtxFn, options []RuleOption) []RuleID {
+	rules := make([]*Rule, len(actions))
+	ids := make([]RuleID, len(actions))
+	for i, action := range actions {
+		rules[i] = newRule(subjectType, action, resourceType, matcher)
+		rules[i].apply(options)
+	}
+	ruleSet.addRules(rules...)
+	for i, rule := range rules {
+		ids[i] = rule.id
+	}
+	return ids
+}
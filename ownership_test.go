@@ -0,0 +1,62 @@
+package perms
+
+import (
+	"testing"
+)
+
+func (playlist *Playlist) OwnerID() string {
+	return playlist.User
+}
+
+func (playlist *Playlist) GroupID() string {
+	return playlist.Group
+}
+
+func TestOwnershipRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	ids := rs.AddOwnershipRule(&User{}, []string{"view", "modify"}, &Playlist{}, func(subject interface{}) string {
+		return subject.(*User).Name
+	}, ALLOW)
+	rs.AddGroupOwnershipRule(&Group{}, []string{"modify"}, &Playlist{}, func(subject interface{}, groupID string) bool {
+		return subject.(*Group).Name == groupID
+	}, ALLOW)
+	if len(ids) != 2 || len(rs.Rules()) != 3 {
+		t.Fatalf("got ids %v and %d rules want one rule per action", ids, len(rs.Rules()))
+	}
+
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	editors := &Group{Name: "editors"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john", Group: "john"}
+	jackPlaylist := &Playlist{ID: "9374", User: "jack", Group: "editors"}
+
+	tests := []struct {
+		subject  interface{}
+		action   string
+		resource interface{}
+		want     string
+	}{
+		{john, "modify", johnPlaylist, ALLOW},
+		{john, "modify", jackPlaylist, DENY},
+		{jack, "modify", jackPlaylist, ALLOW},
+		{jack, "view", johnPlaylist, DENY},
+		{overlord, "modify", jackPlaylist, DENY},
+		{editors, "modify", johnPlaylist, DENY},
+		{editors, "modify", jackPlaylist, ALLOW},
+		{jack, "view", &Archive{Name: "test"}, DENY},
+	}
+	for i, test := range tests {
+		if effect := rs.Query(test.subject, test.action, test.resource); effect != test.want {
+			t.Errorf("%d: got %q want %q", i, effect, test.want)
+		}
+	}
+
+	// the rules of the actions are independent
+	if err := rs.RemoveRule(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if rs.IsAllowed(john, "modify", johnPlaylist) || !rs.IsAllowed(john, "view", johnPlaylist) {
+		t.Errorf("got modify allowed or view denied after removing the modify rule")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// HasParent is implemented by resources nested in a parent resource, see RuleSet.ParentOf.
+type HasParent interface {
+	// Parent returns the parent resource, or nil for a root.
+	Parent() interface{}
+}
+
+// DefaultMaxParentDepth is the number of ancestors evaluated when RuleSet.MaxParentDepth is 0.
+const DefaultMaxParentDepth = 16
+
+// runAncestors evaluates the rules for the ancestors of the queried resource, from the
+// closest, until one produces an effect. The queried resource is restored afterwards.
+func (ev *evaluation) runAncestors() {
+	ruleSet := ev.ruleSet
+	resource := ev.resource
+	defer func() { ev.resource, ev.depth = resource, 0 }()
+
+	maxDepth := ruleSet.MaxParentDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxParentDepth
+	}
+	var visited map[interface{}]bool
+	current := resource
+	for depth := 1; depth <= maxDepth; depth++ {
+		parent, ok := ruleSet.parentOf(current)
+		if !ok {
+			return
+		}
+		if parent != nil && reflect.TypeOf(parent).Comparable() {
+			if visited == nil {
+				visited = make(map[interface{}]bool)
+				if resource != nil && reflect.TypeOf(resource).Comparable() {
+					visited[resource] = true
+				}
+			}
+			if visited[parent] {
+				ruleSet.logf("perms: cycle in the parents of %s", KeyOf(resource))
+				return
+			}
+			visited[parent] = true
+		}
+		ruleSet.logf("perms: evaluating parent %s", KeyOf(parent))
+		ev.resource = parent
+		ev.depth = depth
+		ev.run()
+		if ev.err != nil {
+			return
+		}
+		if ev.result.Effect != "" {
+			ev.result.ParentDepth = depth
+			return
+		}
+		current = parent
+	}
+}
+
+// parentOf returns the parent of resource, if any.
+func (ruleSet *RuleSet) parentOf(resource interface{}) (interface{}, bool) {
+	var parent interface{}
+	if ruleSet.ParentOf != nil {
+		parent = ruleSet.ParentOf(resource)
+	} else if child, ok := resource.(HasParent); ok {
+		parent = child.Parent()
+	}
+	if parent == nil {
+		return nil, false
+	}
+	if v := reflect.ValueOf(parent); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	}
+	return parent, true
+}
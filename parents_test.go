@@ -0,0 +1,119 @@
+package perms
+
+import (
+	"testing"
+)
+
+// folder is a resource implementing HasParent.
+type folder struct {
+	Name   string
+	parent *folder
+}
+
+func (f *folder) Parent() interface{} {
+	return f.parent
+}
+
+func TestParentOf(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+
+	john_playlist := &Playlist{ID: "6563", User: "john"}
+	jack_playlist := &Playlist{ID: "9374", User: "jack"}
+	parents := map[*Video]*Playlist{}
+	rs.ParentOf = func(resource interface{}) interface{} {
+		if video, ok := resource.(*Video); ok {
+			if playlist, ok := parents[video]; ok {
+				return playlist
+			}
+		}
+		return nil
+	}
+
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	if got := rs.Query(john, "view", video); got != DENY {
+		t.Errorf("got %q want %q without a parent", got, DENY)
+	}
+
+	parents[video] = john_playlist
+	d := rs.QueryExplain(john, "view", video)
+	if d.Effect != ALLOW || d.ParentDepth != 1 || d.Default {
+		t.Errorf("got %+v want %q from the parent", d, ALLOW)
+	}
+
+	parents[video] = jack_playlist
+	if d := rs.QueryExplain(john, "view", video); !d.Default {
+		t.Errorf("got %+v want the default effect", d)
+	}
+
+	// rules for the resource itself take precedence
+	parents[video] = john_playlist
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+	if d := rs.QueryExplain(john, "view", video); d.Effect != DENY || d.ParentDepth != 0 {
+		t.Errorf("got %+v want %q from the video rule", d, DENY)
+	}
+}
+
+func TestHasParent(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(nil, "view", &folder{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*folder).Name == "root", ALLOW, false
+		})
+
+	root := &folder{Name: "root"}
+	docs := &folder{Name: "docs", parent: root}
+	drafts := &folder{Name: "drafts", parent: docs}
+	d := rs.QueryExplain(&User{}, "view", drafts)
+	if d.Effect != ALLOW || d.ParentDepth != 2 {
+		t.Errorf("got %+v want %q at depth 2", d, ALLOW)
+	}
+
+	rs.MaxParentDepth = 1
+	if got := rs.Query(&User{}, "view", drafts); got != DENY {
+		t.Errorf("got %q want %q beyond the maximum depth", got, DENY)
+	}
+	rs.MaxParentDepth = -1
+	if got := rs.Query(&User{}, "view", docs); got != DENY {
+		t.Errorf("got %q want %q with parents disabled", got, DENY)
+	}
+}
+
+func TestParentCycle(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.MaxParentDepth = 1000
+	walked := 0
+	rs.AddRule(nil, "view", &folder{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			walked++
+			return false, "", false
+		})
+
+	a := &folder{Name: "a"}
+	b := &folder{Name: "b", parent: a}
+	a.parent = b
+	if got := rs.Query(&User{}, "view", a); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+	if walked != 2 {
+		t.Errorf("got %d evaluations want 2", walked)
+	}
+}
+
+func TestParentOfNilResource(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(nil, "view", "", effectMatcher(ALLOW))
+	rs.ParentOf = func(resource interface{}) interface{} {
+		if resource == "root" {
+			return nil
+		}
+		return "root"
+	}
+	if d := rs.QueryExplain("john", "view", nil); d.Effect != ALLOW || d.ParentDepth != 1 {
+		t.Errorf("got %+v want %q from the parent", d, ALLOW)
+	}
+}
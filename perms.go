@@ -21,210 +21,1522 @@
 package perms
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"time"
 )
 
 type typ reflect.Type
 
+// Effect is the outcome of a rule or query. It is an alias of string, so any
+// custom effect besides the predefined ones can be used.
+type Effect = string
+
+const (
+	// Allow is the conventional effect granting a permission.
+	Allow Effect = "allow"
+	// Deny is the conventional effect refusing a permission.
+	Deny Effect = "deny"
+	// NotApplicable is the empty effect, returned by matchers expressing no opinion.
+	NotApplicable Effect = ""
+)
+
 type MatcherFn func (subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool)
+
+// MatcherErrFn is a matcher that can fail, for example when it needs to perform I/O
+// to decide. A non-nil error stops the evaluation of the query.
+type MatcherErrFn func(subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool, err error)
+
+// ErrFn adapts the matcher to the MatcherErrFn signature. The returned matcher never fails.
+func (matcher MatcherFn) ErrFn() MatcherErrFn {
+	if matcher == nil {
+		return nil
+	}
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		matches, effect, quick := matcher(subject, action, resource)
+		return matches, effect, quick, nil
+	}
+}
+
+// MatcherCtxFn is a matcher receiving the context of the query, see QueryCtx.
+type MatcherCtxFn func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool, err error)
+
+// CtxFn adapts the matcher to the MatcherCtxFn signature, ignoring the context.
+func (matcher MatcherErrFn) CtxFn() MatcherCtxFn {
+	if matcher == nil {
+		return nil
+	}
+	return func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		return matcher(subject, action, resource)
+	}
+}
 type Rule struct {
+	id RuleID
 	subject interface{}
 	action interface{}
 	resource interface{}
-	matcher MatcherFn
+	matcher MatcherCtxFn
+	// plain is set when matcher ignores the context, which can't make nested queries
+	plain bool
+	// obligationMatcher, if non-nil, is the matcher returning obligations that matcher
+	// wraps, see AddRuleWithObligations
+	obligationMatcher ObligationMatcherFn
+	// reasonMatcher, if non-nil, is the matcher returning a reason that matcher wraps,
+	// see AddRuleWithReason
+	reasonMatcher ReasonMatcherFn
+	// fieldMatcher, if non-nil, makes the rule a field rule, see AddFieldRule
+	fieldMatcher FieldMatcherFn
+	// priority orders the evaluation of rules, higher first
+	priority int
+	// notBefore and notAfter, when non-zero, limit the validity of the rule
+	notBefore, notAfter time.Time
+	// disabled rules are skipped, see DisableRule
+	disabled bool
+	// counters of the rule in the rule set it was added to, shared by its copies
+	counters *ruleCounters
+	// patterns replace the equality test of subject, action and resource templates
+	patterns [3]templateMatcher
+	// deep reports the non-comparable non-zero templates, see RuleSet.DeepValueMatch
+	deep [3]bool
+	// seq is the insertion sequence number
+	seq uint64
+	// decl is the source of declarative rules
+	decl *DeclarativeRule
+	// namespace is the namespace of the rule, empty for the shared rules
+	namespace string
+	// name, description and tags are the metadata set by the RuleOptions
+	name        string
+	description string
+	tags        []string
+	// matcherName is the name of the matcher in a MatcherRegistry, see MatcherName
+	matcherName string
+
+	// types of subject, action and resource, the keys in m3rules
+	sT, aT, rT typ
+}
+type RuleList []*Rule
+
+// templateMatcher is implemented by templates with custom matching semantics.
+type templateMatcher interface {
+	matchTemplate(value interface{}) bool
+}
+
+var stringType = reflect.TypeOf("")
+
+// RuleID identifies a rule inside a RuleSet.
+type RuleID string
+
+var (
+	// ErrRuleNotFound is returned when referring to a rule id not present in the rule set.
+	ErrRuleNotFound = errors.New("perms: rule not found")
+	// ErrDuplicateRuleID is returned when adding a rule with an id already in use.
+	ErrDuplicateRuleID = errors.New("perms: duplicate rule id")
+	// ErrBudgetExceeded is returned by a query needing to run more matchers than
+	// allowed by RuleSet.MaxEvaluations.
+	ErrBudgetExceeded = errors.New("perms: evaluation budget exceeded")
+	// ErrPermissionDenied is matched by the errors returned by Enforce, see
+	// PermissionDeniedError.
+	ErrPermissionDenied = errors.New("perms: permission denied")
+)
+
+// RuleInfo describes a rule registered in a RuleSet.
+type RuleInfo struct {
+	// SubjectTemplate, ActionTemplate and ResourceTemplate are the templates
+	// the rule was registered with (nil for a "jolly").
+	SubjectTemplate  interface{}
+	ActionTemplate   interface{}
+	ResourceTemplate interface{}
+
+	// SubjectType, ActionType and ResourceType are the types of the templates,
+	// used as keys in the rule set (nil for a "jolly").
+	SubjectType  reflect.Type
+	ActionType   reflect.Type
+	ResourceType reflect.Type
+
+	// ID is the rule identifier.
+	ID RuleID
+
+	// Index is the position of the rule in the RuleList of its types triple.
+	Index int
+
+	// Priority is the priority of the rule, see AddRuleWithPriority.
+	Priority int
+	// Enabled reports whether the rule is evaluated by queries. It is false for the
+	// rules added with AddRuleWithExpiry outside of their validity, at the time of
+	// the call for Rules and Walk, and for the rules disabled with DisableRule.
+	Enabled bool
+	// Name is the descriptive name of the rule, empty if none was set.
+	Name string
+	// Description is the free-form description of the rule, see Described.
+	Description string
+	// Tags are the tags of the rule, see Tagged.
+	Tags []string
+
+	// Evaluations and Matches count how many times the matcher of the rule ran and
+	// matched, and LastMatch is the time of the last match, zero if none, according to
+	// the Now clock. They are only set by Rules and Walk, see RuleSet.DisableRuleStats.
+	Evaluations uint64
+	Matches     uint64
+	LastMatch   time.Time
+}
+
+// Decision is the detailed outcome of a query.
+type Decision struct {
+	// Effect is the resulting effect.
+	Effect Effect
+	// Rule describes the rule that produced Effect. It is nil when Default is true.
+	Rule *RuleInfo
+	// Role is the role whose grant produced Effect, when no rule applied and the
+	// effect comes from the RuleSet Roles.
+	Role string
+	// ParentDepth is the number of steps from the queried resource to the ancestor
+	// whose rules produced Effect, 0 for the resource itself. See RuleSet.ParentOf.
+	ParentDepth int
+	// Default is true when no rule applied and Effect is the default effect.
+	Default bool
+	// Obligations are the obligations attached to Effect by the rules producing it,
+	// in evaluation order, see AddRuleWithObligations.
+	Obligations []Obligation
+	// Evaluations is the number of matchers run to reach the decision, 0 when it
+	// comes from the cache. See RuleSet.MaxEvaluations.
+	Evaluations int
+	// Reason is the reason given for Effect by the rule producing it, see
+	// AddRuleWithReason. The reasons of the other rules are reported by QueryTrace.
+	Reason string
+	// Superuser is true when Effect is the SuperuserEffect of a superuser subject,
+	// produced without evaluating the rules. See RuleSet.SuperuserFn.
+	Superuser bool
+	// Actor is the subject acting on behalf of the queried one, see QueryAs.
+	Actor interface{}
+	// Granted is true when Effect comes from a temporary grant, see RuleSet.Grant.
+	Granted bool
+	// Revoked is true when Effect is the RevokedEffect of a revoked subject, produced
+	// without evaluating the rules. See RuleSet.RevokeSubject.
+	Revoked bool
+	// Cached is true when the decision comes from the decision cache, see WithCache.
+	Cached bool
+}
+
+func (rule *Rule) info(index int) *RuleInfo {
+	return &RuleInfo{
+		ID:               rule.id,
+		SubjectTemplate:  rule.subject,
+		ActionTemplate:   rule.action,
+		ResourceTemplate: rule.resource,
+		SubjectType:      rule.sT,
+		ActionType:       rule.aT,
+		ResourceType:     rule.rT,
+		Index:            index,
+		Priority:         rule.priority,
+		Enabled:          !rule.disabled,
+		Name:             rule.name,
+		Description:      rule.description,
+		Tags:             copyTags(rule.tags),
+	}
+}
+
+// Logger is the minimal logging interface used by a RuleSet to report what
+// happens during rule evaluation.
+type Logger interface {
+	Logf(format string, args ...interface{})
+}
+
+// LoggerFunc adapts an ordinary printf-like function (eg. log.Printf) to the
+// Logger interface.
+type LoggerFunc func(format string, args ...interface{})
+
+// Logf calls f(format, args...).
+func (f LoggerFunc) Logf(format string, args ...interface{}) {
+	f(format, args...)
 }
-type RuleList []Rule
 
 type RuleSet struct {
-	m3rules       map[typ]map[typ]map[typ]RuleList
-	DefaultEffect string
+	// rules holds the current version of the rules, see Snapshot
+	rules   *ruleStore
+	byID    map[RuleID]*Rule
+	lastID  uint64
+	lastSeq uint64
+	types   *TypeRegistry
+	cache   *decisionCache
+	stats   *ruleStats
+	// checkpoints holds the versions recorded by Checkpoint
+	checkpoints   *checkpoints
+	DefaultEffect Effect
+
+	// DefaultEffectFn, when non-nil, is called with the values of the queries for which
+	// no rule nor role produces an effect, and returns their default effect, in place of
+	// DefaultEffect and of the default effects of the resource types, used when it
+	// returns "". The decisions have Default set and DynamicDefaultReason as Reason.
+	// It's not called for the queries stopped by an error evaluating their rules, for
+	// those overriding the default effect with WithDefaultEffect, nor for the cached
+	// decisions.
+	DefaultEffectFn func(subject interface{}, action interface{}, resource interface{}) string
+
+	// Combining is the strategy used to merge the effects of the matching rules.
+	// The zero value is LastApplicable.
+	Combining CombiningStrategy
+
+	// PerPassQuick restores the original meaning of the quick flag returned by matchers:
+	// a quick rule only ends the current pass instead of the whole query, so with a
+	// combining strategy evaluating all the passes (eg. DenyOverrides) the rules of the
+	// following passes are still evaluated.
+	PerPassQuick bool
+
+	// FlatEvaluation, when true, evaluates the candidate rules of all the "jolly" fallback
+	// passes as a single list ordered by priority, instead of stopping at the first pass
+	// producing an effect. Rules with the same priority keep the order of the passes.
+	FlatEvaluation bool
+
+	// GrantsAfterRules, when true, consults the temporary grants (see Grant) when no
+	// rule produces an effect, instead of before the rules.
+	GrantsAfterRules bool
+
+	// CanonicalActions, when true, passes to the matchers the canonical action of the
+	// queried aliases (see AliasAction) instead of the queried action.
+	CanonicalActions bool
+
+	// StructFieldTemplates, when true, changes how non-pointer struct templates constrain
+	// the queried values: instead of requiring the whole value to be equal to the template,
+	// a value adheres to the template when every non-zero exported field of the template
+	// equals the corresponding field of the value. Nested struct fields are compared the
+	// same way, unexported fields are ignored.
+	// For example, the subject template User{IsSuperuser: true} admits only superusers.
+	StructFieldTemplates bool
+
+	// DeepValueMatch, when true, makes the templates whose type is not comparable,
+	// like slices or structs holding them, admit only the values deeply equal to them
+	// (see reflect.DeepEqual), instead of any value of their type. Nil templates keep
+	// admitting any value, while an empty non-nil slice template only admits the empty
+	// non-nil slices, as reflect.DeepEqual tells them apart. The maps and, with
+	// StructFieldTemplates, the structs keep being matched by their entries and fields.
+	DeepValueMatch bool
+
+	// NormalizePointers, when true, lets rules registered with a pointer template
+	// (eg. &Playlist{}) apply to queries passing the value (Playlist{}), and vice versa.
+	// The matchers receive the value in the form the rule was registered with: a
+	// pointer to a copy of the queried value, or the value pointed to. Rules for the
+	// queried form are evaluated first. Nil pointers only match pointer rules.
+	NormalizePointers bool
+
+	// ParentOf returns the parent of a resource, or nil if it has none. When nil,
+	// resources implementing HasParent are asked for their parent.
+	// When no rule produces an effect for the queried resource, the rules are
+	// evaluated for its ancestors, from the closest, passing the ancestor as resource
+	// to the matchers. The traversal stops at cycles in the chain of parents.
+	ParentOf func(resource interface{}) interface{}
+	// MaxParentDepth limits the number of ancestors evaluated. Zero means DefaultMaxParentDepth,
+	// a negative value disables the evaluation of ancestors.
+	MaxParentDepth int
+
+	// MaxQueryDepth limits the nesting of the queries made by the matchers with the
+	// context they are given, eg. a rule for "modify" asking QueryCtx for "view". A
+	// query nested deeper, or repeating one of the queries it is nested in, returns the
+	// default effect along with ErrQueryDepthExceeded or ErrQueryCycle, reported to the
+	// Logger and as a TraceNested event. Zero means DefaultMaxQueryDepth, a negative
+	// value only stops the cycles. The queries made without the context of the
+	// matcher are not nested.
+	MaxQueryDepth int
+
+	// Now returns the current time, used to check the validity of the rules added with
+	// AddRuleWithExpiry. When nil, time.Now is used.
+	Now func() time.Time
+
+	// StrictExpressions, when true, makes the errors evaluating the Condition expression
+	// of a declarative rule fail the query (see QueryE). By default such a rule simply
+	// doesn't match. It applies to the declarative rules added after it is set.
+	StrictExpressions bool
+
+	// Attributes, when non-nil, fetches the attributes of the attr function of the
+	// Condition expressions (see CompileExpression). Each query fetches an attribute
+	// once, whatever the number of rules using it, and fails with an *AttributeError
+	// if it can't, see QueryE.
+	Attributes AttributeProvider
+
+	// DisableRuleStats, when true, stops counting the evaluations and the matches of
+	// each rule (see RuleInfo.Evaluations and DeadRules), avoiding their small cost.
+	DisableRuleStats bool
+
+	// MaxEvaluations, when positive, limits the number of matchers run by a query,
+	// across all the fallback passes and the ancestors of the resource. A query
+	// needing more stops and returns the default effect along with ErrBudgetExceeded
+	// (see QueryE). Zero, the default, means no limit.
+	MaxEvaluations int
+
+	// MaxCheckpoints is the number of checkpoints kept by Checkpoint, the oldest being
+	// evicted, DefaultMaxCheckpoints if zero.
+	MaxCheckpoints int
+
+	// Roles, when non-nil, is consulted when no rule produces an effect.
+	Roles *Roles
+
+	// Membership, when non-nil, resolves the groups of the subjects for the rules added
+	// with AddMembershipRule and for QueryWithGroups.
+	Membership MembershipResolver
+	// MembershipKey maps a subject to its key for Membership, returning false if the
+	// subject has no key. When nil, the key of a string subject is the string itself,
+	// and that of an Identifiable one its PermKey.
+	MembershipKey func(subject interface{}) (string, bool)
+
+	// SuperuserFn, when non-nil, is called with the subject of every query: when it
+	// returns true the query produces SuperuserEffect without evaluating any rule, nor
+	// the roles. The decision has Superuser set, see also AuditFn.
+	SuperuserFn func(subject interface{}) bool
+	// SuperuserEffect is the effect of the queries of superusers, Allow if empty.
+	SuperuserEffect Effect
+
+	// RevokedEffect is the effect of the queries of the revoked subjects (see
+	// RevokeSubject), Deny if empty.
+	RevokedEffect Effect
+	// RevocationKey maps a subject to its key for RevokeSubject, returning false if
+	// the subject has no key. When nil, the key of a string subject is the string
+	// itself, and that of an Identifiable one its PermKey.
+	RevocationKey func(subject interface{}) (string, bool)
+
+	// DelegationPolicy, when non-nil, is called by QueryAs before evaluating the query
+	// of actor on behalf of subject: when it returns false the delegation is vetoed.
+	DelegationPolicy func(actor interface{}, subject interface{}, action interface{}, resource interface{}) bool
+
+	// AllowedEffects are the effects for which Enforce grants the permission. When
+	// empty, only Allow does.
+	AllowedEffects []Effect
+	// FormatValue describes the subject, action and resource in the errors returned by
+	// Enforce. When nil, KeyOf is used.
+	FormatValue func(value interface{}) string
+
+	// AuditFn, when non-nil, is called once per query with the final effect and the rule
+	// producing it (nil when the effect comes from a role or is the default effect).
+	// The superuser overrides (see SuperuserFn) are reported with a rule whose ID is
+	// SuperuserRuleID, the revoked subjects (see RevokeSubject) with RevokedRuleID.
+	// Panics in AuditFn are recovered and reported to the Logger.
+	// Use KeyOf to record the Identifiable subjects and resources by their PermKey.
+	AuditFn func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo)
+	// AuditDecisionFn, when non-nil, is called like AuditFn, with the whole decision of
+	// the query, including its Reason and Obligations.
+	AuditDecisionFn func(event AuditEvent)
+	// Instrumentation, when non-nil, observes the queries and, if it is also a
+	// RuleInstrumentation, the matchers they run, eg. to trace them.
+	Instrumentation Instrumentation
+
+	// Logger, when non-nil, receives diagnostic messages about queries.
+	// A nil Logger (the default) disables logging entirely.
+	Logger Logger
+	// SlogAllowSampling, when greater than 1, makes the logger set by SetSlogLogger
+	// log only one in SlogAllowSampling Allow decisions, and all the others.
+	SlogAllowSampling int
+	// slog is set by SetSlogLogger
+	slog *slogLogger
+
+	// recoverPanics and recoverFn are set by WithRecover
+	recoverPanics bool
+	recoverFn     func(rule RuleInfo, recovered interface{})
 }
 
 // NewRuleSet returns a new rule set, the context object that hold and evaluate rules.
-func NewRuleSet(defaultEffect string) *RuleSet {
+func NewRuleSet(defaultEffect Effect) *RuleSet {
 	return &RuleSet{
-		m3rules:       make(map[typ]map[typ]map[typ]RuleList),
+		rules:         newRuleStore(&ruleTable{m3rules: make(map[typ]map[typ]map[typ]RuleList)}),
+		byID:          make(map[RuleID]*Rule),
+		types:         NewTypeRegistry(),
+		stats:         &ruleStats{},
 		DefaultEffect: defaultEffect,
 	}
 }
 
+// SetLogger sets the logger used to report query diagnostics.
+// Pass nil to disable logging.
+func (ruleSet *RuleSet) SetLogger(logger Logger) {
+	ruleSet.Logger = logger
+}
+
+func (ruleSet *RuleSet) logf(format string, args ...interface{}) {
+	if ruleSet.Logger == nil {
+		return
+	}
+	ruleSet.Logger.Logf(format, args...)
+}
+
 // AddRule adds a rule for the (subject, action, resource) types triple.
 // Pass a nil subjectType/actionType/resourceType to specify a "jolly" for that parameter.
+// Templates implementing ValueMatcher admit the values they match instead, and
+// Identifiable templates with a non-empty PermKey the values with the same PermKey.
 // Note that the matcher can inspect and decide if/how to apply the rule independently from
 // subjectType, actionType and resourceType. But if these are specified (non-nil), then
 // when evaluating a (subject, action, resource) tuple, its constituents must adhere to the
-// provided types (and values if comparable and non-zero, eg. strings).
-func (ruleSet *RuleSet) AddRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn) {
-	rule := Rule{
-		subject: subjectType,
-		action: actionType,
+// provided types (and values if comparable and non-zero, eg. strings, see also
+// RuleSet.StructFieldTemplates). Map templates, eg. map[string]interface{}{"role": "admin"}
+// for JWT claims, admit the maps having all the template entries with deeply equal values.
+// The returned RuleID can be used to later remove the rule.
+// The options attach metadata to the rule, see Named, Described and Tagged.
+func (ruleSet *RuleSet) AddRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) RuleID {
+	return ruleSet.AddRuleE(subjectType, actionType, resourceType, matcher.ErrFn(), options...)
+}
+
+// AddRuleE is like AddRule, but takes a matcher that can return an error.
+// See QueryE.
+func (ruleSet *RuleSet) AddRuleE(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherErrFn, options ...RuleOption) RuleID {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+// AddRuleCtx is like AddRule, but takes a matcher receiving the query context.
+// See QueryCtx.
+func (ruleSet *RuleSet) AddRuleCtx(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherCtxFn, options ...RuleOption) RuleID {
+	rule := newRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+// AddRuleWithPriority is like AddRule, but assigns a priority to the rule.
+// Rules registered under the same types triple are evaluated by decreasing priority,
+// and in insertion order when the priority is the same. AddRule uses priority 0.
+func (ruleSet *RuleSet) AddRuleWithPriority(priority int, subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) RuleID {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	rule.priority = priority
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+// AddRuleWithID is like AddRule, but registers the rule under an explicit id.
+// It returns ErrDuplicateRuleID if a rule with the same id is already present.
+func (ruleSet *RuleSet) AddRuleWithID(id RuleID, subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) error {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	rule.id = id
+	return ruleSet.addRules(rule)
+}
+
+// RemoveRule removes the rule with the given id, preserving the evaluation order of
+// the remaining rules. It returns ErrRuleNotFound if there is no such rule.
+// The rules of the namespaces can be removed too, see Namespace.
+func (ruleSet *RuleSet) RemoveRule(id RuleID) error {
+	return ruleSet.removeRule(id, "", true)
+}
+
+// RemoveRulesFor removes all the rules added with the given templates types, as
+// passed to AddRule (nil for the "jolly" rules), and returns their number. Only the
+// types matter: RemoveRulesFor(&User{}, "view", nil) removes the rules for any string
+// action, not only "view". The rules of the namespaces are not removed.
+func (ruleSet *RuleSet) RemoveRulesFor(subjectType interface{}, actionType interface{}, resourceType interface{}) int {
+	key := newRule(subjectType, actionType, resourceType, nil).types()
+	removed := 0
+	ruleSet.update(func(w *tableWriter) {
+		removed = w.removeIf(key, func(rule *Rule) bool {
+			return true
+		})
+	})
+	return removed
+}
+
+// Clear removes all the rules, including those of the namespaces, and returns their
+// number. The options of the rule set, as well as the action implications and
+// aliases, the grants, the revocations and the default effects by type, are kept,
+// while the RuleHits of the removed rules are dropped from the Stats. The ids of the
+// rules added afterwards don't reuse those of the removed rules.
+func (ruleSet *RuleSet) Clear() int {
+	removed := 0
+	ruleSet.update(func(w *tableWriter) {
+		removed = w.table.size
+		for _, ns := range w.table.namespaces {
+			removed += ns.size
+		}
+		w.table.m3rules = make(map[typ]map[typ]map[typ]RuleList)
+		w.table.interfaces = [3]interfaceKeys{}
+		w.table.indexes = make(map[[3]typ]*valueIndex)
+		w.table.namespaces = nil
+		w.table.size = 0
+		// drop the references to the removed rules, so that they can be collected
+		ruleSet.byID = make(map[RuleID]*Rule)
+		ruleSet.rules.tails = make(map[string]map[[3]typ]RuleList)
+	})
+	ruleSet.stats.forgetRules()
+	return removed
+}
+
+// DisableRule makes the queries skip the rule with the given id, which keeps its
+// position and can be enabled again with EnableRule. It returns ErrRuleNotFound if
+// there is no such rule.
+func (ruleSet *RuleSet) DisableRule(id RuleID) error {
+	return ruleSet.setRuleDisabled(id, true)
+}
+
+// EnableRule enables the rule with the given id, disabled with DisableRule. It returns
+// ErrRuleNotFound if there is no such rule.
+func (ruleSet *RuleSet) EnableRule(id RuleID) error {
+	return ruleSet.setRuleDisabled(id, false)
+}
+
+func (ruleSet *RuleSet) setRuleDisabled(id RuleID, disabled bool) error {
+	ruleSet.rules.mu.Lock()
+	rule, ok := ruleSet.byID[id]
+	ruleSet.rules.mu.Unlock()
+	if !ok {
+		return ErrRuleNotFound
+	}
+	err := ErrRuleNotFound
+	ruleSet.updateIn(rule.namespace, func(w *tableWriter) {
+		if current := ruleSet.byID[id]; current != nil {
+			// the published rules are immutable, the snapshots keep the previous state
+			changed := *current
+			changed.disabled = disabled
+			w.replace(current, &changed)
+			err = nil
+		}
+	})
+	return err
+}
+
+// newPlainRule is like newRule, for a matcher ignoring the context.
+func newPlainRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherErrFn) *Rule {
+	rule := newRule(subjectType, actionType, resourceType, matcher.CtxFn())
+	rule.plain = true
+	return rule
+}
+
+func newRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherCtxFn) *Rule {
+	rule := &Rule{
+		subject:  subjectType,
+		action:   actionType,
 		resource: resourceType,
-		matcher: matcher,
+		matcher:  matcher,
+		sT:       reflect.TypeOf(subjectType),
+		aT:       reflect.TypeOf(actionType),
+		rT:       reflect.TypeOf(resourceType),
+	}
+	// rules for interface types are keyed by the interface type
+	for position, t := range []*typ{&rule.sT, &rule.aT, &rule.rT} {
+		if iface, ok := interfaceType(rule.template(position)); ok {
+			*t = iface
+			rule.patterns[position] = interfaceTemplate{iface}
+		} else if matcher, ok := rule.template(position).(ValueMatcher); ok {
+			*t = valueMatcherType(matcher)
+			rule.patterns[position] = valueMatcherTemplate{matcher}
+		} else if key, ok := permKey(rule.template(position)); ok && key != "" {
+			rule.patterns[position] = identityTemplate{key}
+		}
+		if v := reflect.ValueOf(rule.template(position)); v.IsValid() && !v.Type().Comparable() && !v.IsZero() {
+			rule.deep[position] = true
+		}
+	}
+	return rule
+}
+
+// template returns the rule template in the given position (0 for the subject,
+// 1 for the action, 2 for the resource).
+func (rule *Rule) template(position int) interface{} {
+	switch position {
+	case 0:
+		return rule.subject
+	case 1:
+		return rule.action
 	}
-	sT := reflect.TypeOf(subjectType)
-	aT := reflect.TypeOf(actionType)
-	rT := reflect.TypeOf(resourceType)
+	return rule.resource
+}
 
-	aMap, ok := ruleSet.m3rules[sT]
-	if !ok {
-		aMap := map[typ]map[typ]RuleList{
-			aT: map[typ]RuleList{
-				rT: []Rule{rule},
-			},
+// types returns the rule types, the keys in m3rules.
+func (rule *Rule) types() [3]typ {
+	return [3]typ{rule.sT, rule.aT, rule.rT}
+}
+
+// nextRuleID returns a new automatically generated rule id, skipping any
+// id already taken by an explicitly registered rule.
+func (ruleSet *RuleSet) nextRuleID() RuleID {
+	for {
+		ruleSet.lastID++
+		id := RuleID(fmt.Sprintf("rule-%d", ruleSet.lastID))
+		if _, ok := ruleSet.byID[id]; !ok {
+			return id
 		}
-		ruleSet.m3rules[sT] = aMap
-		return
 	}
+}
 
-	rMap, ok := aMap[aT]
-	if !ok {
-		rMap := map[typ]RuleList{
-			rT: []Rule{rule},
+// insertByPriority returns a list with the rule inserted after all the rules with
+// the same or higher priority. The passed list is not modified.
+func insertByPriority(list RuleList, rule *Rule) RuleList {
+	i := len(list)
+	for i > 0 && list[i-1].priority < rule.priority {
+		i--
+	}
+	if i == len(list) {
+		return append(list, rule)
+	}
+	inserted := make(RuleList, 0, len(list)+1)
+	inserted = append(inserted, list[:i]...)
+	inserted = append(inserted, rule)
+	return append(inserted, list[i:]...)
+}
+
+// queryValue is a queried value along with the facts about its type needed to match
+// the rule templates, computed once per query.
+type queryValue struct {
+	// value is passed to the matchers, t is the type used to look up the rules
+	// (nil for the "jolly" passes)
+	value interface{}
+	t     typ
+	// literal is set when the templates must be equal to the value: strings and
+	// other comparable non-pointer values.
+	literal bool
+	// interfaces are the interface types implemented by t with registered rules
+	interfaces []typ
+	// counterpart is the pointer to the value, or the value pointed to, when
+	// normalizing pointers (see RuleSet.NormalizePointers)
+	counterpart *queryValue
+}
+
+func newQueryValue(value interface{}) queryValue {
+	q := queryValue{value: value, t: reflect.TypeOf(value)}
+	if q.t != nil {
+		q.literal = q.t == stringType || (q.t.Comparable() && q.t.Kind() != reflect.Ptr)
+	}
+	return q
+}
+
+// queryValue returns the queryValue for value in the given position (0 for the subject,
+// 1 for the action, 2 for the resource), with the interfaces it implements registered
+// in the tables and its counterpart.
+func (ruleSet *RuleSet) queryValue(position int, value interface{}, tables ...*ruleTable) queryValue {
+	q := newQueryValue(value)
+	for _, table := range tables {
+		q.interfaces = append(q.interfaces, table.interfaces[position].implementedBy(q.t, q.interfaces...)...)
+	}
+	if ruleSet.NormalizePointers {
+		if q.counterpart = counterpartOf(&q); q.counterpart != nil {
+			for _, table := range tables {
+				excluded := append(q.interfaces[:len(q.interfaces):len(q.interfaces)], q.counterpart.interfaces...)
+				q.counterpart.interfaces = append(q.counterpart.interfaces, table.interfaces[position].implementedBy(q.counterpart.t, excluded...)...)
+			}
 		}
-		aMap[aT] = rMap
-		return
 	}
+	return q
+}
 
-	rMap[rT] = append(rMap[rT], rule)
+// numTypes returns the number of types to look up in m3rules for the value: its
+// own type, the interfaces it implements, and the same for its counterpart.
+func (q *queryValue) numTypes() int {
+	n := 1 + len(q.interfaces)
+	if q.counterpart != nil {
+		n += q.counterpart.numTypes()
+	}
+	return n
 }
 
-func (ruleSet *RuleSet) findRules(subject interface{}, action interface{}, resource interface{}) RuleList {
-	typeOfSubject := reflect.TypeOf(subject)
-	typeOfAction := reflect.TypeOf(action)
-	typeOfResource := reflect.TypeOf(resource)
+// form returns the i-th type to look up for the value, see numTypes, along with
+// the form of the value (q or its counterpart) having that type.
+func (q *queryValue) form(i int) (typ, *queryValue) {
+	if i == 0 {
+		return q.t, q
+	}
+	if i <= len(q.interfaces) {
+		return q.interfaces[i-1], q
+	}
+	return q.counterpart.form(i - 1 - len(q.interfaces))
+}
 
-	aMap, ok := ruleSet.m3rules[typeOfSubject]
-	if !ok {
+// key returns the value if it is used to look up the rules, nil in the "jolly" passes.
+func (q *queryValue) key() interface{} {
+	if q.t == nil {
 		return nil
 	}
+	return q.value
+}
 
-	rMap, ok := aMap[typeOfAction]
-	if !ok {
-		return nil
+// pick returns q, or the "jolly" value with a nil type if keep is false.
+func (q queryValue) pick(keep bool) queryValue {
+	if keep {
+		return q
 	}
+	return queryValue{value: q.value}
+}
 
-	stringTypeOf := reflect.TypeOf("")		// cache
-	rules := []Rule{}
-	candidates := rMap[typeOfResource]
-	for _, candidate := range candidates {
-		// string subject?
-		if typeOfSubject == stringTypeOf {
-			s_subject := subject.(string)
-			if s_subject != "" && subject != candidate.subject {
-				// fmt.Printf("skipping rule %v - different subjects (%v != %v)\n", candidate, subject, candidate.subject)
-				continue
-			}
-		}
-		// comparable non-pointer subject?
-		if subject != nil && typeOfSubject.Comparable() && reflect.ValueOf(subject).Kind() != reflect.Ptr {
-			if reflect.ValueOf(subject).IsValid() && subject != candidate.subject {
-				continue
-			}
+// templateMode holds the options of a RuleSet changing how the templates admit the
+// queried values.
+type templateMode struct {
+	structFields, deepValues bool
+}
+
+func (ruleSet *RuleSet) templateMode() templateMode {
+	return templateMode{structFields: ruleSet.StructFieldTemplates, deepValues: ruleSet.DeepValueMatch}
+}
+
+// admits reports whether the queried value, in the given position (0 for the
+// subject, 1 for the action, 2 for the resource), adheres to the rule template.
+func (rule *Rule) admits(position int, q *queryValue, template interface{}, mode templateMode) bool {
+	if pattern := rule.patterns[position]; pattern != nil {
+		return pattern.matchTemplate(q.value)
+	}
+	// struct value matched field by field?
+	if mode.structFields && q.t != nil && q.t.Kind() == reflect.Struct {
+		return matchStructFields(reflect.ValueOf(template), reflect.ValueOf(q.value))
+	}
+	// map values are matched entry by entry, maps are never compared with ==
+	if q.t != nil && q.t.Kind() == reflect.Map {
+		return matchMapEntries(reflect.ValueOf(template), reflect.ValueOf(q.value))
+	}
+	if !q.literal {
+		// non-comparable value deeply equal to the template?
+		if mode.deepValues && rule.deep[position] && q.t != nil && !q.t.Comparable() {
+			return reflect.DeepEqual(template, q.value)
 		}
+		return true
+	}
+	// an empty string template matches any string
+	if q.t == stringType && template == "" {
+		return true
+	}
+	return q.value == template
+}
 
-		// string action?
-		if typeOfAction == stringTypeOf {
-			s_action := action.(string)
-			if s_action != "" && action != candidate.action {
-				// fmt.Printf("skipping rule %v - different actions (%v != %v)\n", candidate, action, candidate.action)
-				continue
-			}
+// findRules calls fn for every rule registered under the types of the (subject, action, resource)
+// triple, or under interfaces they implement, whose templates are compatible with the triple
+// values, passing the rule and its position in its RuleList. Iteration stops when fn returns false.
+func (ruleSet *RuleSet) findRules(subject queryValue, action queryValue, resource queryValue, fn func(c candidate) bool) {
+	ruleSet.lookupRules(ruleSet.current(), subject, action, resource, nil, fn)
+}
+
+// lookup calls fn for the rules admitting the (subject, action, resource) values in
+// the given pass, see lookupRules.
+func (ev *evaluation) lookup(step passStep, subject queryValue, action queryValue, resource queryValue, skipped func(rule *Rule, index int), fn func(c candidate) bool) {
+	if step.implying != nil || ev.aliased {
+		// the matchers receive the queried action
+		action := ev.action
+		if ev.aliased {
+			action = ev.alias
 		}
-		// comparable non-pointer action?
-		if action != nil && typeOfAction.Comparable() && reflect.ValueOf(action).Kind() != reflect.Ptr {
-			if reflect.ValueOf(action).IsValid() && action != candidate.action {
-				continue
-			}
+		looked := fn
+		fn = func(c candidate) bool {
+			c.action = action
+			return looked(c)
 		}
+	}
+	if step.implying == nil && ev.resolved != nil && ev.depth == 0 {
+		ev.resolved.scan(ev.ruleSet, step.pass, ev.queried, skipped, fn)
+		return
+	}
+	// the rules of the namespace come before the shared ones
+	if ev.namespace != nil && !ev.ruleSet.lookupRules(ev.namespace, subject, action, resource, skipped, fn) {
+		return
+	}
+	ev.ruleSet.lookupRules(ev.table, subject, action, resource, skipped, fn)
+}
 
-		// string resource?
-		if typeOfResource == stringTypeOf {
-			s_resource := resource.(string)
-			if s_resource != "" && resource != candidate.resource {
-				// fmt.Printf("skipping rule %v - different resources (%v != %v)\n", candidate, resource, candidate.resource)
+// lookupRules is like findRules, for the rules of table, but also calls skipped, if
+// non-nil, for the rules registered under the triple types whose templates reject the
+// triple values. It returns false if fn stopped the iteration.
+func (ruleSet *RuleSet) lookupRules(table *ruleTable, subject queryValue, action queryValue, resource queryValue, skipped func(rule *Rule, index int), fn func(c candidate) bool) bool {
+	// the rules for the exact types come before the rules for the interfaces they implement
+	for i := 0; i < subject.numTypes(); i++ {
+		sT, s := subject.form(i)
+		aMap, ok := table.m3rules[sT]
+		if !ok {
+			continue
+		}
+		for j := 0; j < action.numTypes(); j++ {
+			aT, a := action.form(j)
+			rMap, ok := aMap[aT]
+			if !ok {
 				continue
 			}
+			for k := 0; k < resource.numTypes(); k++ {
+				rT, r := resource.form(k)
+				if skipped != nil {
+					// the rules skipped by the index are reported too
+					if !ruleSet.scanRules(rMap[rT], s, a, r, skipped, fn) {
+						return false
+					}
+					continue
+				}
+				if !ruleSet.scanIndexed(table, [3]typ{sT, aT, rT}, rMap[rT], s, a, r, fn) {
+					return false
+				}
+			}
 		}
-		// comparable non-pointer resource?
-		if resource != nil && typeOfResource.Comparable() && reflect.ValueOf(resource).Kind() != reflect.Ptr {
-			if reflect.ValueOf(resource).IsValid() && resource != candidate.resource {
-				continue
+	}
+	return true
+}
+
+// candidate is a rule admitting the queried values, along with the values to pass
+// to its matcher.
+type candidate struct {
+	rule                      *Rule
+	index                     int
+	subject, action, resource interface{}
+}
+
+// scanRules calls fn for the rules in rules whose templates are compatible with
+// the (subject, action, resource) values, returning false if fn stopped the iteration.
+func (ruleSet *RuleSet) scanRules(rules RuleList, subject *queryValue, action *queryValue, resource *queryValue, skipped func(rule *Rule, index int), fn func(c candidate) bool) bool {
+	mode := ruleSet.templateMode()
+	for i, rule := range rules {
+		if !rule.admits(0, subject, rule.subject, mode) ||
+			!rule.admits(1, action, rule.action, mode) ||
+			!rule.admits(2, resource, rule.resource, mode) {
+			if skipped != nil {
+				skipped(rule, i)
 			}
+			continue
 		}
 
-		rules = append(rules, candidate)
+		if !fn(candidate{rule, i, subject.value, action.value, resource.value}) {
+			return false
+		}
 	}
-	return rules
+	return true
+}
+
+// scanIndexed is like scanRules without skipped, but uses the index of the rules
+// registered under the types triple key, if any.
+func (ruleSet *RuleSet) scanIndexed(table *ruleTable, key [3]typ, rules RuleList, subject *queryValue, action *queryValue, resource *queryValue, fn func(c candidate) bool) bool {
+	mode := ruleSet.templateMode()
+	return table.scanList(key, rules, [3]*queryValue{subject, action, resource}, func(i int) bool {
+		rule := rules[i]
+		if !rule.admits(0, subject, rule.subject, mode) ||
+			!rule.admits(1, action, rule.action, mode) ||
+			!rule.admits(2, resource, rule.resource, mode) {
+			return true
+		}
+		return fn(candidate{rule, i, subject.value, action.value, resource.value})
+	})
 }
 
 // Query applies the permissions rules to the (subject, action, resource) triple returning
 // an effect (or the default effect if no rule applies).
+// If a matcher fails, evaluation stops and the default effect is returned: use QueryE
+// to get the error.
 func (ruleSet *RuleSet) Query(subject interface{}, action interface{}, resource interface{}) string {
-	fmt.Printf("QUERY subj:%v act:%v res:%v\n", subject, action, resource)
-	// finalEffect := ruleSet.DefaultEffect
-
-	// the first triple (tplSubject, tplAction, tplResource) is used to find matching matchers,
-	// while the second triple (subject, action, resource) is the actual values passed to the
-	// matcher functions.
-	// The distinction is done to be able to pass a nil tpl* value to match with "jolly" rules.
-	queryRules := func (tplSubject interface{}, tplAction interface{}, tplResource interface{},
-		subject interface{}, action interface{}, resource interface{}) string {
-		resultEffect := ""
-		rules := ruleSet.findRules(tplSubject, tplAction, tplResource)
-		for _, rule := range rules {
-			matcher := rule.matcher
-			if matcher == nil {
-				continue
-			}
-			matches, effect, quick := matcher(subject, action, resource)
-			if !matches {
-				continue
-			}
+	return ruleSet.QueryOpt(subject, action, resource).Effect
+}
 
-			if effect != "" {
-				resultEffect = effect
-				if quick {
-					break
-				}
-			}
+// IsAllowed reports whether querying the (subject, action, resource) triple results
+// in the Allow effect.
+func (ruleSet *RuleSet) IsAllowed(subject interface{}, action interface{}, resource interface{}) bool {
+	return ruleSet.Query(subject, action, resource) == Allow
+}
+
+// QueryMatched is like Query, but also reports whether a rule (or a role, see Roles)
+// produced the effect, false when the default effect is returned because nothing
+// applied. This distinguishes a rule explicitly producing the default effect from the
+// absence of an opinion, eg. to consult another policy source only in the latter case.
+func (ruleSet *RuleSet) QueryMatched(subject interface{}, action interface{}, resource interface{}) (effect string, matched bool) {
+	decision := ruleSet.QueryExplain(subject, action, resource)
+	return decision.Effect, !decision.Default
+}
+
+// QueryExplain is like Query, but returns a Decision describing which rule produced the
+// effect, or whether the default effect was used because no rule applied.
+func (ruleSet *RuleSet) QueryExplain(subject interface{}, action interface{}, resource interface{}) Decision {
+	decision, _ := ruleSet.evaluate(context.Background(), subject, action, resource)
+	return decision
+}
+
+// QueryE is like Query, but stops at the first matcher returning an error, and returns
+// the default effect along with that error.
+func (ruleSet *RuleSet) QueryE(subject interface{}, action interface{}, resource interface{}) (string, error) {
+	return ruleSet.QueryCtx(context.Background(), subject, action, resource)
+}
+
+// QueryCtx is like QueryE, but passes ctx to the matchers. If ctx is done before the
+// evaluation completes, QueryCtx stops and returns the default effect along with ctx.Err().
+func (ruleSet *RuleSet) QueryCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (string, error) {
+	decision, err := ruleSet.evaluate(ctx, subject, action, resource)
+	return decision.Effect, err
+}
+
+// CombiningStrategy controls how the effects of multiple matching rules are merged
+// into the effect of a query.
+type CombiningStrategy int
+
+const (
+	// LastApplicable is the default strategy: the passes are evaluated from the most
+	// specific to the most generic (the "jolly" rules) and the first pass producing an
+	// effect decides. Inside a pass the last non-empty effect wins.
+	LastApplicable CombiningStrategy = iota
+	// FirstApplicable is like LastApplicable, but inside a pass the first non-empty
+	// effect wins.
+	FirstApplicable
+	// DenyOverrides evaluates the rules of all the passes: the Deny effect wins over any
+	// other effect, otherwise the first non-empty effect is used.
+	DenyOverrides
+	// AllowOverrides evaluates the rules of all the passes: the Allow effect wins over
+	// any other effect, otherwise the first non-empty effect is used.
+	AllowOverrides
+)
+
+// acrossPasses reports whether the strategy merges effects produced in different passes.
+func (strategy CombiningStrategy) acrossPasses() bool {
+	return strategy == DenyOverrides || strategy == AllowOverrides
+}
+
+// String returns the name of the strategy.
+func (strategy CombiningStrategy) String() string {
+	switch strategy {
+	case LastApplicable:
+		return "last-applicable"
+	case FirstApplicable:
+		return "first-applicable"
+	case DenyOverrides:
+		return "deny-overrides"
+	case AllowOverrides:
+		return "allow-overrides"
+	}
+	return fmt.Sprintf("CombiningStrategy(%d)", int(strategy))
+}
+
+// jollyTiers lists, in order of precedence, which of subject, action and resource are
+// kept (true) or replaced by nil (false) to look up rules in the fallback passes.
+var jollyTiers = [8][3]bool{
+	{true, true, true},
+	{true, true, false},
+	{true, false, true},
+	{false, true, true},
+	{true, false, false},
+	{false, false, true},
+	{false, true, false},
+	{false, false, false},
+}
+
+// evaluation holds the state of a single query.
+type evaluation struct {
+	ruleSet *RuleSet
+	table   *ruleTable
+	// namespace, if non-nil, holds the rules of the namespace of the query
+	namespace *ruleTable
+	ctx       context.Context
+	subject   interface{}
+	action    interface{}
+	resource  interface{}
+	// alias, when aliased, is the queried alias of action, passed to the matchers
+	alias   interface{}
+	aliased bool
+
+	// time of the evaluation, set on first use
+	time time.Time
+	// trace, if non-nil, receives the evaluation steps
+	trace func(TraceEvent)
+	// resolved, if non-nil, holds the rules for the queried resource, whose
+	// queryValue is queried
+	resolved *resolvedRules
+	queried  *queryValue
+	// depth is the distance of the evaluated resource from the queried one
+	depth int
+	// query holds the queried values, and caller the evaluation the query is nested
+	// in, if any, for the context of the matchers, frame, set on first use
+	query  [3]interface{}
+	caller *evaluationContext
+	frame  *evaluationContext
+	// evaluations is the number of matchers run
+	evaluations int
+	// bound, if non-nil, holds the subject resolved for table
+	bound *boundSubject
+	// maxEvaluations and combining are the RuleSet settings, unless overridden by
+	// the query options
+	maxEvaluations int
+	combining      CombiningStrategy
+	// defaultOverridden is set when the query options override the default effect,
+	// including those of the resource types
+	defaultOverridden bool
+
+	// fields, if non-nil, holds the field effects of a QueryFields evaluation, which
+	// runs only the field rules; pass is the position of the current pass
+	fields map[string]fieldEffect
+	pass   int
+	// panicked holds the panic of the last matcher recovered by callRecovering
+	panicked *MatcherPanic
+
+	// result of the evaluation so far
+	result Decision
+	err    error
+	// done is set when the result can't change anymore
+	done bool
+}
+
+// evalRule runs the matcher of the candidate rule, returning false when
+// the current pass must stop.
+func (ev *evaluation) evalRule(c candidate) bool {
+	rule, index := c.rule, c.index
+	matcher := rule.matcher
+	if matcher == nil {
+		return true
+	}
+	if rule.disabled {
+		if ev.trace != nil {
+			ev.trace(TraceEvent{Kind: TraceSkipped, Rule: rule.info(index)})
+		}
+		return true
+	}
+	if rule.expires() && !rule.validAt(ev.now()) {
+		if ev.trace != nil {
+			info := rule.info(index)
+			info.Enabled = false
+			ev.trace(TraceEvent{Kind: TraceSkipped, Rule: info, Expired: true})
 		}
-		return resultEffect
+		return true
+	}
+	if (rule.fieldMatcher != nil) != (ev.fields != nil) {
+		// the field rules are evaluated only by QueryFields, and only them
+		return true
+	}
+	if ev.err = ev.ctx.Err(); ev.err != nil {
+		return false
+	}
+	if max := ev.maxEvaluations; max > 0 && ev.evaluations >= max {
+		ev.err = ErrBudgetExceeded
+		return false
+	}
+	ev.evaluations++
+	var counters *ruleCounters
+	if !ev.ruleSet.DisableRuleStats {
+		counters = ev.ruleSet.stats.countersOf(rule)
+		counters.evaluations.Add(1)
+	}
+	var matches, quick bool
+	var effect string
+	var obligations []Obligation
+	var reason string
+	var fields map[string]Effect
+	var err error
+	var endRule func(matched bool, effect Effect, err error)
+	if !rule.plain {
+		if ev.frame == nil {
+			ev.enterFrame()
+		}
+		ev.frame.evaluations = ev.evaluations
+	}
+	ctx := ev.ctx
+	if ev.ruleSet.Instrumentation != nil {
+		if instrumentation, ok := ev.ruleSet.Instrumentation.(RuleInstrumentation); ok {
+			ev.ctx, endRule = instrumentation.StartRule(ctx, rule.info(index))
+		}
+	}
+	if ev.ruleSet.recoverPanics {
+		matches, effect, quick, obligations, reason, fields, err = ev.callRecovering(c)
+	} else {
+		matches, effect, quick, obligations, reason, fields, err = ev.call(c)
+	}
+	if !rule.plain {
+		// the sub-queries of the matcher count in the budget of the query
+		ev.evaluations = ev.frame.evaluations
+	}
+	if endRule != nil {
+		ev.ctx = ctx
+		if ev.panicked != nil {
+			endRule(matches, effect, ev.panicked)
+			ev.panicked = nil
+		} else {
+			endRule(matches, effect, err)
+		}
+	}
+	if ev.trace != nil {
+		ev.trace(TraceEvent{Kind: TraceRule, Rule: rule.info(index), Matched: matches, Effect: effect, Quick: quick, Reason: reason, Err: err})
+	}
+	if err != nil {
+		ev.err = err
+		return false
+	}
+	if !matches {
+		return true
+	}
+	if counters != nil {
+		counters.matches.Add(1)
+		counters.lastMatch.Store(ev.now().UnixNano())
+	}
+	if ev.fields != nil {
+		ev.combineFields(fields)
+		return true
 	}
 
-	final := queryRules(subject, action, resource, subject, action, resource)
-	if final != "" {
-		return final
+	if effect == "" {
+		return true
+	}
+	ev.ruleSet.stats.countHit(rule)
+	if !ev.combine(effect, rule, index, obligations, reason) {
+		return false
+	}
+	if quick {
+		// a quick rule settles the whole query, unless asked to only end the current pass
+		if !ev.ruleSet.PerPassQuick {
+			ev.done = true
+		}
+		return false
 	}
+	return true
+}
 
-	final = queryRules(subject, action, nil, subject, action, resource)
-	if final != "" {
-		return final
+// call runs the matcher of the candidate rule.
+func (ev *evaluation) call(c candidate) (matches bool, effect string, quick bool, obligations []Obligation, reason string, fields map[string]Effect, err error) {
+	rule := c.rule
+	if rule.fieldMatcher != nil {
+		matches, fields, err = rule.fieldMatcher(ev.ctx, c.subject, c.action, c.resource)
+	} else if rule.obligationMatcher != nil {
+		matches, effect, quick, obligations, err = rule.obligationMatcher(ev.ctx, c.subject, c.action, c.resource)
+	} else if rule.reasonMatcher != nil {
+		matches, effect, quick, reason, err = rule.reasonMatcher(ev.ctx, c.subject, c.action, c.resource)
+	} else {
+		matches, effect, quick, err = rule.matcher(ev.ctx, c.subject, c.action, c.resource)
 	}
-	final = queryRules(subject, nil, resource, subject, action, resource)
-	if final != "" {
-		return final
+	return
+}
+
+// combine merges the effect produced by rule into the result according to the
+// combining strategy, returning false when no other rule needs to be evaluated
+// in the current pass. The obligations of rules producing the current effect
+// without replacing the rule of the result are kept along the result ones, while
+// the reason is only the one of the rule of the result.
+func (ev *evaluation) combine(effect string, rule *Rule, index int, obligations []Obligation, reason string) bool {
+	switch ev.combining {
+	case FirstApplicable:
+		if ev.result.Effect == "" {
+			ev.setResult(effect, rule, index, obligations, reason)
+		}
+		return false
+	case DenyOverrides, AllowOverrides:
+		overriding := Deny
+		if ev.combining == AllowOverrides {
+			overriding = Allow
+		}
+		if effect == overriding {
+			ev.setResult(effect, rule, index, obligations, reason)
+			ev.done = true
+			return false
+		}
+		if ev.result.Effect == "" {
+			ev.setResult(effect, rule, index, obligations, reason)
+		} else if effect == ev.result.Effect {
+			ev.result.Obligations = append(ev.result.Obligations, obligations...)
+		}
+		return true
+	default:
+		ev.setResult(effect, rule, index, obligations, reason)
+		return true
+	}
+}
+
+// setResult makes rule the one producing the result, keeping the obligations of
+// the previous rules only if they produced the same effect.
+func (ev *evaluation) setResult(effect string, rule *Rule, index int, obligations []Obligation, reason string) {
+	if effect != ev.result.Effect {
+		ev.result.Obligations = nil
+	}
+	ev.result.Effect = effect
+	ev.result.Rule = rule.info(index)
+	ev.result.Reason = reason
+	ev.result.Obligations = append(ev.result.Obligations, obligations...)
+}
+
+func (ruleSet *RuleSet) evaluate(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	return ruleSet.evaluateWith(ctx, queryOptions{}, subject, action, resource)
+}
+
+// queryOptions alter the evaluation of a query.
+type queryOptions struct {
+	// trace, if non-nil, receives the evaluation steps, see QueryTrace
+	trace func(TraceEvent)
+	// resolved, if non-nil, holds the rules to evaluate for the queried resource,
+	// whose queryValue is resource, see FilterAllowed
+	resolved *resolvedRules
+	resource *queryValue
+	// table, if non-nil, holds the rules to evaluate instead of the current ones,
+	// see Snapshot
+	table *ruleTable
+	// namespace, if non-nil, holds the rules of the namespace evaluated before
+	// those of table, see Namespace
+	namespace *ruleTable
+	// actor, when delegated, acts on behalf of the queried subject, see QueryAs
+	actor     interface{}
+	delegated bool
+	// bound, if non-nil, holds the queried subject resolved for table, see For
+	bound *boundSubject
+	// env holds the attributes of the environment of the query, see WithEnv
+	env map[string]interface{}
+	// overrides of the RuleSet settings, see QueryOpt
+	defaultEffect  *Effect
+	maxEvaluations *int
+	combining      *CombiningStrategy
+}
+
+// evaluateWith is like evaluate, with the given options.
+func (ruleSet *RuleSet) evaluateWith(ctx context.Context, opts queryOptions, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	var decision Decision
+	var err error
+	var start time.Time
+	if ruleSet.slog != nil {
+		start = time.Now()
+	}
+	if ruleSet.Instrumentation != nil {
+		var end func(decision Decision, err error)
+		ctx, end = ruleSet.Instrumentation.StartQuery(ctx, subject, action, resource)
+		defer func() { end(decision, err) }()
+	}
+	if opts.delegated && ruleSet.DelegationPolicy != nil && !ruleSet.DelegationPolicy(opts.actor, subject, action, resource) {
+		ruleSet.logf("perms: delegation to %s denied", KeyOf(opts.actor))
+		decision, err = Decision{Effect: Deny}, ErrDelegationDenied
+	} else {
+		decision, err = ruleSet.decide(ctx, opts, subject, action, resource)
 	}
-	final = queryRules(nil, action, resource, subject, action, resource)
-	if final != "" {
-		return final
+	if opts.delegated {
+		decision.Actor = opts.actor
 	}
-	final = queryRules(subject, nil, nil, subject, action, resource)
-	if final != "" {
-		return final
+	ruleSet.stats.countDecision(decision, err)
+	if ruleSet.AuditFn != nil || ruleSet.AuditDecisionFn != nil {
+		ruleSet.audit(subject, action, resource, decision)
 	}
-	final = queryRules(nil, nil, resource, subject, action, resource)
-	if final != "" {
-		return final
+	if ruleSet.slog != nil {
+		ruleSet.logDecision(ctx, start, subject, action, resource, decision, err)
 	}
-	final = queryRules(nil, action, nil, subject, action, resource)
-	if final != "" {
-		return final
+	if opts.trace != nil {
+		opts.trace(TraceEvent{Kind: TraceResult, Effect: decision.Effect, Decision: decision, Err: err})
 	}
-	final = queryRules(nil, nil, nil, subject, action, resource)
-	if final != "" {
-		return final
+	return decision, err
+}
+
+// decide evaluates the rules for the (subject, action, resource) triple.
+func (ruleSet *RuleSet) decide(ctx context.Context, opts queryOptions, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	// the Logger checks avoid boxing the arguments in the hot path when logging is disabled
+	if ruleSet.Logger != nil {
+		ruleSet.logf("perms: query subject:%s action:%s resource:%s", KeyOf(subject), KeyOf(action), KeyOf(resource))
+	}
+	if opts.env != nil {
+		ctx = withEnv(ctx, opts.env)
+	}
+	if ruleSet.Attributes != nil && attributesFrom(ctx) == nil {
+		// the nested queries share the attributes fetched by the query
+		ctx = withAttributes(ctx, ruleSet.Attributes)
 	}
 
-	return ruleSet.DefaultEffect
+	// the revocations are the current ones, whatever the rules evaluated, so that
+	// they also apply to the snapshots and the bound checkers taken before
+	bound := opts.bound
+	if ruleSet.revokedSubject(ruleSet.current(), subject, bound) {
+		effect := ruleSet.RevokedEffect
+		if effect == "" {
+			effect = Deny
+		}
+		ruleSet.logf("perms: revoked subject, effect %q", effect)
+		return Decision{Effect: effect, Revoked: true}, nil
+	}
+
+	if ruleSet.SuperuserFn != nil && ruleSet.isSuperuser(subject, bound) {
+		effect := ruleSet.SuperuserEffect
+		if effect == "" {
+			effect = Allow
+		}
+		ruleSet.logf("perms: superuser effect %q", effect)
+		return Decision{Effect: effect, Superuser: true}, nil
+	}
+
+	cache := ruleSet.cache
+	key, cacheable := "", false
+	if cache != nil {
+		key, cacheable = cache.keyFn(subject, action, resource)
+	}
+	var generation uint64
+	if opts.namespace != nil || opts.overrides() || opts.env != nil {
+		// the cached decisions are for the shared rules and settings, without an
+		// environment
+		cacheable = false
+	}
+	if cacheable {
+		decision, current, ok := cache.get(key)
+		if opts.table != nil && opts.table != ruleSet.current() {
+			// the cached decisions are for the current rules
+			cacheable = false
+		} else if ok {
+			ruleSet.logf("perms: cached effect %q", decision.Effect)
+			// the obligations of the cached decision are shared by the queries
+			decision.Obligations = append([]Obligation(nil), decision.Obligations...)
+			decision.Evaluations = 0
+			decision.Cached = true
+			return decision, nil
+		}
+		generation = current
+	}
+
+	defaultDecision := Decision{
+		Effect:  ruleSet.DefaultEffect,
+		Default: true,
+	}
+	if opts.defaultEffect != nil {
+		defaultDecision.Effect = *opts.defaultEffect
+	}
+	// the rules are loaded after the cache generation, so that the decision is not
+	// cached if they change during the evaluation
+	table := opts.table
+	if table == nil {
+		table = ruleSet.current()
+	}
+	caller := callerOf(ctx)
+	if err := ruleSet.checkNesting(caller, subject, action, resource); err != nil {
+		return defaultDecision, err
+	}
+	ev := &evaluation{
+		ruleSet:   ruleSet,
+		table:     table,
+		namespace: opts.namespace,
+		ctx:       ctx,
+		trace:     opts.trace,
+		resolved:  opts.resolved,
+		queried:   opts.resource,
+		subject:   subject,
+		action:    action,
+		resource:  resource,
+
+		bound:  bound,
+		query:  [3]interface{}{subject, action, resource},
+		caller: caller,
+
+		maxEvaluations: ruleSet.MaxEvaluations,
+		combining:      ruleSet.Combining,
+	}
+	if opts.maxEvaluations != nil {
+		ev.maxEvaluations = *opts.maxEvaluations
+	}
+	if opts.combining != nil {
+		ev.combining = *opts.combining
+	}
+	ev.defaultOverridden = opts.defaultEffect != nil
+	if canonical, ok := table.actions.canonical(action); ok {
+		ev.action = canonical
+		if !ruleSet.CanonicalActions {
+			ev.alias, ev.aliased = action, true
+		}
+	}
+
+	granted, ok := ev.grant()
+	if ok && !ruleSet.GrantsAfterRules {
+		ev.result = granted
+	} else {
+		ev.run()
+		if ev.err == nil && ev.result.Effect == "" {
+			ev.runAncestors()
+		}
+		if ok && ev.err == nil && ev.result.Effect == "" {
+			ev.result = granted
+		}
+	}
+	decision, err := ev.finish(defaultDecision, subject, action, resource)
+	decision.Evaluations = ev.evaluations
+	// the grants expire without invalidating the cache
+	if cacheable && err == nil && !decision.Granted {
+		cached := decision
+		cached.Obligations = append([]Obligation(nil), decision.Obligations...)
+		cache.add(key, cached, generation)
+	}
+	return decision, err
+}
+
+// queryValue returns the queryValue for value in the given position, for the rules
+// of the evaluation.
+func (ev *evaluation) queryValue(position int, value interface{}) queryValue {
+	if ev.namespace != nil {
+		return ev.ruleSet.queryValue(position, value, ev.namespace, ev.table)
+	}
+	return ev.ruleSet.queryValue(position, value, ev.table)
+}
+
+// subjectValue returns the queryValue of the subject, for the rules of the evaluation.
+func (ev *evaluation) subjectValue() queryValue {
+	if ev.bound != nil && ev.namespace == nil && ev.bound.table == ev.table {
+		return ev.bound.value
+	}
+	return ev.queryValue(0, ev.subject)
+}
+
+// run evaluates the rules for the current (subject, action, resource) values.
+func (ev *evaluation) run() {
+	ruleSet := ev.ruleSet
+	var subject, action, resource queryValue
+	if ev.resolved != nil && ev.depth == 0 {
+		// the values are only used for logging, see lookup
+		subject, action, resource = ev.resolved.subject, ev.resolved.action, *ev.queried
+	} else {
+		subject, action, resource = ev.subjectValue(), ev.queryValue(1, ev.action), ev.queryValue(2, ev.resource)
+	}
+	var skipped func(rule *Rule, index int)
+	if ev.trace != nil {
+		skipped = ev.traceSkipped
+	}
+
+	if ruleSet.FlatEvaluation {
+		// gather the candidates of all the passes and evaluate them as a single list
+		var candidates []candidate
+		for _, step := range ev.steps() {
+			tier := jollyTiers[step.pass]
+			if ev.trace != nil {
+				ev.tracePass(step)
+			}
+			ev.lookup(step, subject.pick(tier[0]), step.action(action).pick(tier[1]), resource.pick(tier[2]), skipped, func(c candidate) bool {
+				candidates = append(candidates, c)
+				return true
+			})
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].rule.priority > candidates[j].rule.priority
+		})
+		ruleSet.logf("perms: %d candidate rules", len(candidates))
+		for _, c := range candidates {
+			if !ev.evalRule(c) {
+				break
+			}
+		}
+		return
+	}
+
+	// probe the exact types first, then progressively replace subject, action and
+	// resource with nil to reach the "jolly" rules.
+	// The values passed to the matchers are always the queried ones (or their
+	// pointer/value counterparts, see RuleSet.NormalizePointers).
+	for i, step := range ev.steps() {
+		if ev.err = ev.ctx.Err(); ev.err != nil {
+			break
+		}
+		ev.pass = i
+		if ev.trace != nil {
+			ev.tracePass(step)
+		}
+		tier := jollyTiers[step.pass]
+		tplSubject, tplAction, tplResource := subject.pick(tier[0]), step.action(action).pick(tier[1]), resource.pick(tier[2])
+		candidates := 0
+		ev.lookup(step, tplSubject, tplAction, tplResource, skipped, func(c candidate) bool {
+			candidates++
+			return ev.evalRule(c)
+		})
+		if ruleSet.Logger != nil {
+			ruleSet.logf("perms: %d candidate rules for templates (%T, %v, %T)", candidates, tplSubject.key(), tplAction.key(), tplResource.key())
+		}
+		if ev.err != nil || ev.done || (ev.result.Effect != "" && !ev.combining.acrossPasses()) {
+			break
+		}
+	}
+}
+
+// finish returns the outcome of the evaluation of the query, using defaultDecision when
+// it failed or when no rule produced an effect.
+func (ev *evaluation) finish(defaultDecision Decision, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	ruleSet := ev.ruleSet
+	if ev.err != nil {
+		ruleSet.logf("perms: evaluation stopped: %v, default effect %q", ev.err, defaultDecision.Effect)
+		return defaultDecision, ev.err
+	}
+	if ev.result.Effect != "" {
+		if ruleSet.Logger != nil {
+			ruleSet.logf("perms: effect %q", ev.result.Effect)
+		}
+		return ev.result, nil
+	}
+	if decision := ev.roleDecision(); decision.Effect != "" {
+		ruleSet.logf("perms: effect %q granted to role %q", decision.Effect, decision.Role)
+		return decision, nil
+	}
+	if !ev.defaultOverridden {
+		return ruleSet.defaultDecision(ev.table, subject, action, resource), nil
+	}
+	if ruleSet.Logger != nil {
+		ruleSet.logf("perms: no rule applies, default effect %q", defaultDecision.Effect)
+	}
+	return defaultDecision, nil
 }
@@ -1,7 +1,12 @@
 package perms
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
 )
 
@@ -189,3 +194,761 @@ func TestAddRule(t *testing.T) {
 	check(rs.Query(overlord, "modify", &Archive{Name: "test"}), DENY)
 	check(rs.Query(overlord, "view", &Archive{Name: "test"}), ALLOW)
 }
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Logf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestQueryWithoutLoggerIsSilent(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (matches bool, effect string, quick bool) {
+			return true, ALLOW, false
+		})
+	out := captureStdout(t, func() {
+		rs.Query(&User{Name: "john"}, "view", &Playlist{ID: "1"})
+		rs.Query(&User{Name: "john"}, "view", &Video{Name: "v"})
+	})
+	if out != "" {
+		t.Errorf("got output %q want none", out)
+	}
+}
+
+func TestQueryLogger(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (matches bool, effect string, quick bool) {
+			return true, ALLOW, false
+		})
+	logger := &recordingLogger{}
+	rs.SetLogger(logger)
+
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Playlist{ID: "1"}); got != ALLOW {
+		t.Fatalf("got %q want %q", got, ALLOW)
+	}
+	want := []string{
+		`perms: query subject:&{john false} action:view resource:&{1 [] false  }`,
+		`perms: 1 candidate rules for templates (*perms.User, view, *perms.Playlist)`,
+		`perms: effect "allow"`,
+	}
+	if !reflect.DeepEqual(logger.lines, want) {
+		t.Errorf("got log %q want %q", logger.lines, want)
+	}
+
+	logger.lines = nil
+	if got := rs.Query(john, "view", &Video{Name: "v"}); got != DENY {
+		t.Fatalf("got %q want %q", got, DENY)
+	}
+	if len(logger.lines) != 10 {
+		t.Fatalf("got %d log lines want 10: %q", len(logger.lines), logger.lines)
+	}
+	if last := logger.lines[len(logger.lines)-1]; last != `perms: no rule applies, default effect "deny"` {
+		t.Errorf("got last log line %q", last)
+	}
+
+	rs.SetLogger(nil)
+	logger.lines = nil
+	rs.Query(john, "view", &Playlist{ID: "1"})
+	if len(logger.lines) != 0 {
+		t.Errorf("got log %q after removing the logger", logger.lines)
+	}
+}
+
+func TestQueryExplain(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (matches bool, effect string, quick bool) {
+			return false, "", false
+		})
+	rs.AddRule(&User{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (matches bool, effect string, quick bool) {
+			if res.(*Playlist).User == subj.(*User).Name {
+				return true, ALLOW, false
+			}
+			return true, DENY, false
+		})
+	rs.AddRule(&User{}, "view", nil,
+		func(subj interface{}, act interface{}, res interface{}) (matches bool, effect string, quick bool) {
+			return true, ALLOW, false
+		})
+
+	jack := &User{Name: "jack"}
+	d := rs.QueryExplain(jack, "modify", &Playlist{ID: "6563", User: "john"})
+	if d.Effect != DENY || d.Default {
+		t.Fatalf("got %+v want an explicit %q", d, DENY)
+	}
+	if d.Rule == nil {
+		t.Fatal("got no rule")
+	}
+	if d.Rule.Index != 1 {
+		t.Errorf("got rule index %d want 1", d.Rule.Index)
+	}
+	if d.Rule.SubjectType != reflect.TypeOf(&User{}) || d.Rule.ActionType != reflect.TypeOf("") || d.Rule.ResourceType != reflect.TypeOf(&Playlist{}) {
+		t.Errorf("got rule types (%v, %v, %v)", d.Rule.SubjectType, d.Rule.ActionType, d.Rule.ResourceType)
+	}
+	if d.Rule.ActionTemplate != "modify" {
+		t.Errorf("got action template %v want modify", d.Rule.ActionTemplate)
+	}
+
+	d = rs.QueryExplain(jack, "view", &Archive{Name: "test"})
+	if d.Effect != ALLOW || d.Default || d.Rule == nil {
+		t.Fatalf("got %+v want an explicit %q", d, ALLOW)
+	}
+	if d.Rule.ResourceType != nil || d.Rule.ResourceTemplate != nil {
+		t.Errorf("got resource (%v, %v) want a jolly", d.Rule.ResourceType, d.Rule.ResourceTemplate)
+	}
+
+	d = rs.QueryExplain(jack, "delete", &Archive{Name: "test"})
+	if d.Effect != DENY || !d.Default || d.Rule != nil {
+		t.Errorf("got %+v want the default effect", d)
+	}
+	if got := rs.Query(jack, "delete", &Archive{Name: "test"}); got != d.Effect {
+		t.Errorf("Query got %q want %q", got, d.Effect)
+	}
+}
+
+func effectMatcher(effect string) MatcherFn {
+	return func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, effect, false
+	}
+}
+
+func TestRemoveRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	only := rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	jack := &User{Name: "jack"}
+
+	if got := rs.Query(jack, "view", &Video{}); got != ALLOW {
+		t.Fatalf("got %q want %q", got, ALLOW)
+	}
+	if err := rs.RemoveRule(only); err != nil {
+		t.Fatal(err)
+	}
+	if d := rs.QueryExplain(jack, "view", &Video{}); !d.Default {
+		t.Errorf("got %+v after removing the only rule, want the default effect", d)
+	}
+	if len(rs.current().m3rules) != 0 {
+		t.Errorf("got %d subject types left want 0", len(rs.current().m3rules))
+	}
+	if err := rs.RemoveRule(only); err != ErrRuleNotFound {
+		t.Errorf("got error %v removing twice want %v", err, ErrRuleNotFound)
+	}
+}
+
+func TestRemoveRuleFromMiddle(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	const (
+		first  = "first"
+		second = "second"
+		third  = "third"
+	)
+	if err := rs.AddRuleWithID("a", &User{}, "view", &Video{}, effectMatcher(first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.AddRuleWithID("b", &User{}, "view", &Video{}, effectMatcher(second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.AddRuleWithID("b", &User{}, "view", &Video{}, effectMatcher(second)); err != ErrDuplicateRuleID {
+		t.Errorf("got error %v want %v", err, ErrDuplicateRuleID)
+	}
+	c := rs.AddRule(&User{}, "view", &Video{}, effectMatcher(third))
+
+	jack := &User{Name: "jack"}
+	if d := rs.QueryExplain(jack, "view", &Video{}); d.Effect != third || d.Rule.ID != c || d.Rule.Index != 2 {
+		t.Fatalf("got %+v want the third rule", d)
+	}
+	if err := rs.RemoveRule("b"); err != nil {
+		t.Fatal(err)
+	}
+	var ids []RuleID
+	rs.findRules(newQueryValue(jack), newQueryValue("view"), newQueryValue(&Video{}), func(c candidate) bool {
+		ids = append(ids, c.rule.id)
+		return true
+	})
+	if want := []RuleID{"a", c}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got rules %v want %v", ids, want)
+	}
+	if d := rs.QueryExplain(jack, "view", &Video{}); d.Effect != third || d.Rule.Index != 1 {
+		t.Errorf("got %+v want the third rule at index 1", d)
+	}
+}
+
+func TestRemoveRulesFor(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Playlist{User: "john"}); got != ALLOW {
+		t.Fatalf("got %q want %q", got, ALLOW)
+	}
+	// the rules for any string action, view and modify
+	if n := rs.RemoveRulesFor(&User{}, "", &Playlist{}); n != 2 {
+		t.Errorf("got %d rules removed want 2", n)
+	}
+	if got := rs.Query(john, "modify", &Playlist{User: "john"}); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+	if d := rs.QueryExplain(john, "view", &Playlist{User: "john"}); d.Rule == nil || d.Rule.ID != "rule-6" {
+		t.Errorf("got %+v want the jolly rule", d)
+	}
+	if n := rs.RemoveRulesFor(&User{}, "view", nil); n != 1 {
+		t.Errorf("got %d jolly rules removed want 1", n)
+	}
+	if n := rs.RemoveRulesFor(&User{}, "view", nil); n != 0 {
+		t.Errorf("got %d rules removed twice want 0", n)
+	}
+	if n := len(rs.Rules()); n != 3 {
+		t.Errorf("got %d rules left want 3", n)
+	}
+	if _, ok := rs.byID["rule-1"]; ok {
+		t.Errorf("got a removed rule still registered")
+	}
+}
+
+func TestClear(t *testing.T) {
+	rs := newVideoRuleSet().WithCache(100, playlistKey)
+	rs.SetDefaultEffectForType(&Archive{}, "archived")
+	rs.Namespace("tenant").AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	john := &User{Name: "john"}
+	for _, resource := range videoResources {
+		rs.Query(john, "view", resource)
+	}
+	if n := rs.Clear(); n != 7 {
+		t.Errorf("got %d rules removed want 7", n)
+	}
+	for _, subject := range videoSubjects {
+		for _, action := range videoActions {
+			for _, resource := range videoResources {
+				want := DENY
+				if _, ok := resource.(*Archive); ok {
+					want = "archived"
+				}
+				if d := rs.QueryExplain(subject, action, resource); d.Effect != want || d.Rule != nil {
+					t.Errorf("(%v, %v, %v): got %+v want the default %q", subject, action, resource, d, want)
+				}
+			}
+		}
+	}
+	if len(rs.Rules()) != 0 || len(rs.Namespaces()) != 0 || len(rs.byID) != 0 || len(rs.Stats().RuleHits) != 0 {
+		t.Errorf("got rules left after Clear")
+	}
+
+	// the rules can be added again
+	if id := rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW)); id != "rule-8" {
+		t.Errorf("got id %q want rule-8", id)
+	}
+	if !rs.IsAllowed(john, "view", &Video{}) {
+		t.Errorf("got the new rule not applied")
+	}
+}
+
+func TestClearConcurrent(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			rs.Clear()
+			rs.AddRules(RuleSpec{Subject: &User{}, Action: "view", Resource: &Video{}, Matcher: effectMatcher(ALLOW).ErrFn().CtxFn()})
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if !rs.IsAllowed(john, "view", &Video{}) || len(rs.Rules()) != 1 {
+				t.Errorf("got %v want just the last rule", rs.Rules())
+			}
+			return
+		default:
+		}
+		if got := rs.Query(john, "view", &Video{}); got != ALLOW && got != DENY {
+			t.Fatalf("got %q", got)
+		}
+	}
+}
+
+func TestEmptyStringTemplates(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+	rs.AddRule("", "view", "", effectMatcher(ALLOW))
+	rs.AddRule("", "delete", "archive", effectMatcher(ALLOW))
+
+	john := &User{Name: "john"}
+	john_playlist := &Playlist{ID: "6563", User: "john"}
+	for _, action := range []string{"view", "modify", ""} {
+		if got := rs.Query(john, action, john_playlist); got != ALLOW {
+			t.Errorf("%q: got %q want %q", action, got, ALLOW)
+		}
+	}
+
+	cases := []struct {
+		subject, action, resource string
+		want                      string
+	}{
+		{"svc-billing", "view", "invoices", ALLOW},
+		{"", "view", "", ALLOW},
+		{"svc-billing", "delete", "archive", ALLOW},
+		// non-empty templates must still be equal to the queried values
+		{"svc-billing", "delete", "invoices", DENY},
+		{"svc-billing", "delete", "", DENY},
+	}
+	for _, c := range cases {
+		if got := rs.Query(c.subject, c.action, c.resource); got != c.want {
+			t.Errorf("(%q, %q, %q): got %q want %q", c.subject, c.action, c.resource, got, c.want)
+		}
+	}
+}
+
+func TestEffects(t *testing.T) {
+	const quarantine = "quarantine"
+	rs := NewRuleSet(Deny)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(Allow))
+	rs.AddRule(&User{}, "upload", &Video{}, effectMatcher(quarantine))
+	rs.AddRule(&User{}, "delete", &Video{}, effectMatcher(NotApplicable))
+
+	jack := &User{Name: "jack"}
+	cases := []struct {
+		action  string
+		effect  Effect
+		allowed bool
+	}{
+		{"view", Allow, true},
+		{"upload", quarantine, false},
+		{"delete", Deny, false},
+		{"share", Deny, false},
+	}
+	for _, c := range cases {
+		if got := rs.Query(jack, c.action, &Video{}); got != c.effect {
+			t.Errorf("%s: got %q want %q", c.action, got, c.effect)
+		}
+		if got := rs.IsAllowed(jack, c.action, &Video{}); got != c.allowed {
+			t.Errorf("%s: got allowed %v want %v", c.action, got, c.allowed)
+		}
+	}
+	if ALLOW != Allow || DENY != Deny {
+		t.Errorf("predefined effects differ from the conventional strings")
+	}
+}
+
+func TestQueryMatched(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(DENY))
+	rs.AddRule(&User{}, "edit", &Video{}, effectMatcher(NotApplicable))
+
+	john := &User{Name: "john"}
+	if effect, matched := rs.QueryMatched(john, "view", &Video{}); effect != DENY || !matched {
+		t.Errorf("got (%q, %v) want (%q, true)", effect, matched, DENY)
+	}
+	if effect, matched := rs.QueryMatched(john, "edit", &Video{}); effect != DENY || matched {
+		t.Errorf("got (%q, %v) want (%q, false)", effect, matched, DENY)
+	}
+	if effect, matched := rs.QueryMatched(john, "view", &Playlist{}); effect != DENY || matched {
+		t.Errorf("got (%q, %v) want (%q, false)", effect, matched, DENY)
+	}
+}
+
+func TestQueryE(t *testing.T) {
+	errLookup := errors.New("membership lookup failed")
+	rs := NewRuleSet(DENY)
+	rs.AddRuleE(&Group{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+			if subj.(*Group).Name == "broken" {
+				return false, "", false, errLookup
+			}
+			return false, "", false, nil
+		})
+	rs.AddRule(&Group{}, "modify", &Playlist{}, effectMatcher(ALLOW))
+
+	playlist := &Playlist{ID: "9374"}
+	effect, err := rs.QueryE(&Group{Name: "editors"}, "modify", playlist)
+	if err != nil || effect != ALLOW {
+		t.Errorf("got (%q, %v) want (%q, nil)", effect, err, ALLOW)
+	}
+
+	// the failing rule comes first: a later allowing rule must not mask the error
+	effect, err = rs.QueryE(&Group{Name: "broken"}, "modify", playlist)
+	if err != errLookup {
+		t.Errorf("got error %v want %v", err, errLookup)
+	}
+	if effect != DENY {
+		t.Errorf("got %q want the default effect %q", effect, DENY)
+	}
+	if got := rs.Query(&Group{Name: "broken"}, "modify", playlist); got != DENY {
+		t.Errorf("Query got %q want the default effect %q", got, DENY)
+	}
+}
+
+func TestQueryCtx(t *testing.T) {
+	type ctxKey struct{}
+	rs := NewRuleSet(DENY)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "request-1"))
+	defer cancel()
+
+	evaluated := 0
+	rs.AddRuleCtx(&User{}, "view", &Video{},
+		func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+			evaluated++
+			if ctx.Value(ctxKey{}) != "request-1" {
+				t.Errorf("matcher got a different context")
+			}
+			// the request goes away while the matcher does its I/O
+			cancel()
+			return false, "", false, nil
+		})
+	rs.AddRule(&User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		evaluated++
+		return true, ALLOW, false
+	})
+	rs.AddRule(nil, nil, nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		evaluated++
+		return true, ALLOW, false
+	})
+
+	effect, err := rs.QueryCtx(ctx, &User{Name: "jack"}, "view", &Video{})
+	if err != context.Canceled {
+		t.Errorf("got error %v want %v", err, context.Canceled)
+	}
+	if effect != DENY {
+		t.Errorf("got %q want the default effect %q", effect, DENY)
+	}
+	if evaluated != 1 {
+		t.Errorf("got %d matchers evaluated want 1", evaluated)
+	}
+
+	// an already cancelled context stops before the first tier
+	evaluated = 0
+	if _, err := rs.QueryCtx(ctx, &User{Name: "jack"}, "view", &Archive{}); err != context.Canceled {
+		t.Errorf("got error %v want %v", err, context.Canceled)
+	}
+	if evaluated != 0 {
+		t.Errorf("got %d matchers evaluated want 0", evaluated)
+	}
+
+	effect, err = rs.QueryCtx(context.Background(), &User{Name: "jack"}, "view", &Archive{})
+	if err != nil || effect != ALLOW {
+		t.Errorf("got (%q, %v) want (%q, nil)", effect, err, ALLOW)
+	}
+}
+
+func quickMatcher(effect string) MatcherFn {
+	return func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, effect, true
+	}
+}
+
+func TestRulePriority(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, quickMatcher(ALLOW))
+	jack := &User{Name: "jack"}
+	if got := rs.Query(jack, "view", &Video{}); got != ALLOW {
+		t.Fatalf("got %q want %q", got, ALLOW)
+	}
+
+	// security lockdown added later with a higher priority
+	lockdown := rs.AddRuleWithPriority(10, &User{}, "view", &Video{}, quickMatcher("lockdown"))
+	d := rs.QueryExplain(jack, "view", &Video{})
+	if d.Effect != "lockdown" || d.Rule.ID != lockdown || d.Rule.Index != 0 {
+		t.Errorf("got %+v want the lockdown rule first", d)
+	}
+
+	// same priority keeps the insertion order
+	rs.AddRuleWithPriority(10, &User{}, "view", &Video{}, quickMatcher("second lockdown"))
+	if got := rs.Query(jack, "view", &Video{}); got != "lockdown" {
+		t.Errorf("got %q want %q", got, "lockdown")
+	}
+	var priorities []int
+	rs.findRules(newQueryValue(jack), newQueryValue("view"), newQueryValue(&Video{}), func(c candidate) bool {
+		priorities = append(priorities, c.rule.priority)
+		return true
+	})
+	if want := []int{10, 10, 0}; !reflect.DeepEqual(priorities, want) {
+		t.Errorf("got priorities %v want %v", priorities, want)
+	}
+}
+
+func TestFlatEvaluation(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, quickMatcher(ALLOW))
+	rs.AddRuleWithPriority(10, nil, nil, nil, quickMatcher("lockdown"))
+
+	jack := &User{Name: "jack"}
+	if got := rs.Query(jack, "view", &Video{}); got != ALLOW {
+		t.Errorf("got %q want %q from the most specific pass", got, ALLOW)
+	}
+	rs.FlatEvaluation = true
+	if got := rs.Query(jack, "view", &Video{}); got != "lockdown" {
+		t.Errorf("got %q want %q in flat evaluation", got, "lockdown")
+	}
+	if got := rs.Query(jack, "modify", &Video{}); got != "lockdown" {
+		t.Errorf("got %q want %q in flat evaluation", got, "lockdown")
+	}
+}
+
+func TestCombiningStrategies(t *testing.T) {
+	rs := NewRuleSet("none")
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher("review"))
+	jolly := rs.AddRule(nil, nil, nil, effectMatcher(DENY))
+
+	jack := &User{Name: "jack"}
+	cases := []struct {
+		strategy CombiningStrategy
+		want     string
+	}{
+		{LastApplicable, "review"},
+		{FirstApplicable, ALLOW},
+		{DenyOverrides, DENY},
+		{AllowOverrides, ALLOW},
+	}
+	for _, c := range cases {
+		rs.Combining = c.strategy
+		if got := rs.Query(jack, "view", &Video{}); got != c.want {
+			t.Errorf("%v: got %q want %q", c.strategy, got, c.want)
+		}
+	}
+
+	rs.Combining = DenyOverrides
+	if d := rs.QueryExplain(jack, "view", &Video{}); d.Rule == nil || d.Rule.ID != jolly {
+		t.Errorf("got %+v want the jolly rule to decide", d)
+	}
+	rs.Combining = AllowOverrides
+	if got := rs.Query(jack, "modify", &Video{}); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+}
+
+func TestQuickEndsQuery(t *testing.T) {
+	for _, strategy := range []CombiningStrategy{LastApplicable, FirstApplicable, DenyOverrides, AllowOverrides} {
+		rs := NewRuleSet(DENY)
+		rs.Combining = strategy
+		superuser := rs.AddRule(&User{}, "view", nil,
+			func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+				if subj.(*User).IsSuperuser {
+					return true, ALLOW, true
+				}
+				return false, "", false
+			})
+		rs.AddRule(nil, nil, nil, effectMatcher(DENY))
+
+		overlord := &User{Name: "overlord", IsSuperuser: true}
+		d := rs.QueryExplain(overlord, "view", &Archive{Name: "test"})
+		if d.Effect != ALLOW || d.Rule == nil || d.Rule.ID != superuser {
+			t.Errorf("%v: got %+v want the quick superuser rule to decide", strategy, d)
+		}
+		if got := rs.Query(&User{Name: "jack"}, "view", &Archive{Name: "test"}); got != DENY {
+			t.Errorf("%v: got %q want %q", strategy, got, DENY)
+		}
+	}
+}
+
+func TestPerPassQuick(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Combining = DenyOverrides
+	rs.PerPassQuick = true
+	rs.AddRule(&User{}, "view", nil, quickMatcher(ALLOW))
+	rs.AddRule(&User{}, "view", nil, effectMatcher("unreached"))
+	rs.AddRule(nil, nil, nil, effectMatcher(DENY))
+
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	if got := rs.Query(overlord, "view", &Archive{Name: "test"}); got != DENY {
+		t.Errorf("got %q want %q from the following pass", got, DENY)
+	}
+	rs.PerPassQuick = false
+	if got := rs.Query(overlord, "view", &Archive{Name: "test"}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+}
+
+func newLargeRuleSet(rules int) *RuleSet {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < rules; i++ {
+		rs.AddRule(&User{}, "view", &Playlist{},
+			func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+				return false, "", false
+			})
+	}
+	return rs
+}
+
+func BenchmarkQueryNoRuleMatches(b *testing.B) {
+	rs := newLargeRuleSet(5000)
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query(john, "edit", video) != DENY {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func BenchmarkQueryNoMatcherApplies(b *testing.B) {
+	rs := newLargeRuleSet(5000)
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "john"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query(john, "view", playlist) != DENY {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func TestMaxEvaluations(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	runs := 0
+	counting := func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		runs++
+		return true, ALLOW, false
+	}
+	// 10 rules in the first pass and 10 in a fallback pass, all evaluated with
+	// DenyOverrides since none denies
+	rs.Combining = DenyOverrides
+	for i := 0; i < 10; i++ {
+		rs.AddRule(&User{}, "view", &Video{}, counting)
+		rs.AddRule(&User{}, "view", nil, counting)
+	}
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+
+	// unlimited by default
+	d, err := rs.QueryDecision(context.Background(), john, "view", video)
+	if err != nil || d.Effect != ALLOW || d.Evaluations != 20 || runs != 20 {
+		t.Fatalf("got %+v, %v after %d runs want allow after 20", d, err, runs)
+	}
+
+	rs.MaxEvaluations = 15
+	runs = 0
+	effect, err := rs.QueryE(john, "view", video)
+	if err != ErrBudgetExceeded || effect != DENY || runs != 15 {
+		t.Errorf("got %q, %v after %d runs want the default effect after 15", effect, err, runs)
+	}
+	d = rs.QueryExplain(john, "view", video)
+	if !d.Default || d.Evaluations != 15 {
+		t.Errorf("got %+v want the default decision after 15 evaluations", d)
+	}
+
+	// the budget is exactly enough
+	rs.MaxEvaluations = 20
+	if effect, err := rs.QueryE(john, "view", video); err != nil || effect != ALLOW {
+		t.Errorf("got %q, %v with a budget of 20", effect, err)
+	}
+	// quick settles the query before the budget is exceeded
+	rs.AddRuleWithPriority(1, &User{}, "view", &Video{}, quickMatcher(ALLOW))
+	rs.MaxEvaluations = 1
+	if d, err := rs.QueryDecision(context.Background(), john, "view", video); err != nil || d.Effect != ALLOW || d.Evaluations != 1 {
+		t.Errorf("got %+v, %v want the quick rule", d, err)
+	}
+}
+
+func TestDeepValueMatch(t *testing.T) {
+	type scope struct {
+		Names []string
+	}
+	rs := NewRuleSet(DENY)
+	// two rules under the same (User, []string, nil) triple, differing only in the template content
+	rs.AddRule(&User{}, []string{"read", "list"}, nil, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, []string{"write"}, nil, effectMatcher(DENY))
+	rs.AddRule(&User{}, []string{}, nil, effectMatcher("empty"))
+	rs.AddRule(&User{}, scope{Names: []string{"admin"}}, nil, effectMatcher("admin"))
+	rs.DefaultEffect = "default"
+	john := &User{Name: "john"}
+
+	// without DeepValueMatch the templates admit any value of their type
+	if effect := rs.Query(john, []string{"read", "list"}, nil); effect != "empty" {
+		t.Errorf("got %q want the last rule", effect)
+	}
+
+	rs.DeepValueMatch = true
+	cases := []struct {
+		action interface{}
+		effect string
+	}{
+		{[]string{"read", "list"}, ALLOW},
+		{[]string{"write"}, DENY},
+		{[]string{"list", "read"}, "default"},
+		{[]string{"read"}, "default"},
+		// nil and empty slices differ
+		{[]string{}, "empty"},
+		{[]string(nil), "default"},
+		{scope{Names: []string{"admin"}}, "admin"},
+		{scope{}, "default"},
+	}
+	for _, c := range cases {
+		if effect := rs.Query(john, c.action, nil); effect != c.effect {
+			t.Errorf("%#v: got %q want %q", c.action, effect, c.effect)
+		}
+	}
+
+	// a nil template keeps admitting any slice
+	rs.AddRule(&User{}, []string(nil), nil, effectMatcher("any"))
+	if effect := rs.Query(john, []string{"other"}, nil); effect != "any" {
+		t.Errorf("got %q want the nil template rule", effect)
+	}
+}
+
+func TestDisableRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	id := rs.AddRuleWithPriority(-1, &User{}, "view", &Video{}, effectMatcher(DENY))
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	if rs.IsAllowed(john, "view", video) {
+		t.Fatalf("got view allowed want the deny rule to apply")
+	}
+
+	snapshot := rs.Snapshot()
+	if err := rs.DisableRule(id); err != nil {
+		t.Fatal(err)
+	}
+	if !rs.IsAllowed(john, "view", video) {
+		t.Errorf("got view denied want the disabled rule skipped")
+	}
+	if snapshot.IsAllowed(john, "view", video) {
+		t.Errorf("got view allowed by the snapshot want the rule enabled there")
+	}
+	rules := rs.Rules()
+	if len(rules) != 2 || rules[1].ID != id || rules[1].Enabled || rules[1].Index != 1 {
+		t.Errorf("got %+v want %s disabled in its position", rules, id)
+	}
+
+	if err := rs.EnableRule(id); err != nil {
+		t.Fatal(err)
+	}
+	if rs.IsAllowed(john, "view", video) {
+		t.Errorf("got view allowed want the enabled rule to apply")
+	}
+	if err := rs.DisableRule("missing"); err != ErrRuleNotFound {
+		t.Errorf("got %v want %v", err, ErrRuleNotFound)
+	}
+	if err := rs.RemoveRule(id); err != nil {
+		t.Errorf("got %v removing the re-enabled rule", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package permstest provides helpers to test go-perms policies.
+package permstest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+// Case is a query and its expected effect, see Run.
+type Case struct {
+	// Name optionally identifies the case in the failure messages.
+	Name     string
+	Subject  interface{}
+	Action   interface{}
+	Resource interface{}
+	Want     perms.Effect
+}
+
+// AssertEffect reports an error if querying rs for the (subject, action, resource)
+// triple doesn't result in the want effect. It returns whether the assertion held.
+func AssertEffect(t testing.TB, rs *perms.RuleSet, subject interface{}, action interface{}, resource interface{}, want perms.Effect) bool {
+	t.Helper()
+	decision := rs.QueryExplain(subject, action, resource)
+	if decision.Effect != want {
+		t.Errorf("%s: got %q want %q%s", describeTriple(subject, action, resource), decision.Effect, want, describeDecision(decision))
+		return false
+	}
+	return true
+}
+
+// AssertAllowed is AssertEffect with the perms.Allow effect.
+func AssertAllowed(t testing.TB, rs *perms.RuleSet, subject interface{}, action interface{}, resource interface{}) bool {
+	t.Helper()
+	return AssertEffect(t, rs, subject, action, resource, perms.Allow)
+}
+
+// AssertDenied is AssertEffect with the perms.Deny effect.
+func AssertDenied(t testing.TB, rs *perms.RuleSet, subject interface{}, action interface{}, resource interface{}) bool {
+	t.Helper()
+	return AssertEffect(t, rs, subject, action, resource, perms.Deny)
+}
+
+// Run checks all the cases, reporting every failing one.
+func Run(t testing.TB, rs *perms.RuleSet, cases []Case) {
+	t.Helper()
+	for i, c := range cases {
+		decision := rs.QueryExplain(c.Subject, c.Action, c.Resource)
+		if decision.Effect == c.Want {
+			continue
+		}
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i)
+		}
+		t.Errorf("%s: %s: got %q want %q%s", name, describeTriple(c.Subject, c.Action, c.Resource), decision.Effect, c.Want, describeDecision(decision))
+	}
+}
+
+// RequireNoRuleFor stops the test if any rule (or role) applies to the (subject, action,
+// resource) triple, instead of falling through to the default effect. It is useful to
+// catch accidental wildcard rules.
+func RequireNoRuleFor(t testing.TB, rs *perms.RuleSet, subject interface{}, action interface{}, resource interface{}) {
+	t.Helper()
+	decision := rs.QueryExplain(subject, action, resource)
+	if !decision.Default {
+		t.Fatalf("%s: got %q want the default effect%s", describeTriple(subject, action, resource), decision.Effect, describeDecision(decision))
+	}
+}
+
+func describeTriple(subject interface{}, action interface{}, resource interface{}) string {
+	return fmt.Sprintf("(subject %s, action %s, resource %s)", describeValue(subject), describeValue(action), describeValue(resource))
+}
+
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("%q", value)
+	}
+	return fmt.Sprintf("%T %+v", value, value)
+}
+
+func describeDecision(decision perms.Decision) string {
+	switch {
+	case decision.Rule != nil:
+		return fmt.Sprintf(" from rule %q", decision.Rule.ID)
+	case decision.Role != "":
+		return fmt.Sprintf(" from role %q", decision.Role)
+	case decision.Default:
+		return " (default effect, no rule applies)"
+	}
+	return ""
+}
+
+// Membership is an in-memory perms.MembershipResolver, to test the policies based on
+// groups without a database. It is safe for concurrent use.
+type Membership struct {
+	mu     sync.Mutex
+	groups map[string]map[string]bool
+	err    error
+	calls  int
+}
+
+// NewMembership returns a Membership without groups.
+func NewMembership() *Membership {
+	return &Membership{groups: make(map[string]map[string]bool)}
+}
+
+// Add makes the subject with subjectKey a member of the groups.
+func (membership *Membership) Add(subjectKey string, groupKeys ...string) {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	groups, ok := membership.groups[subjectKey]
+	if !ok {
+		groups = make(map[string]bool)
+		membership.groups[subjectKey] = groups
+	}
+	for _, group := range groupKeys {
+		groups[group] = true
+	}
+}
+
+// Remove removes the subject with subjectKey from the groups.
+func (membership *Membership) Remove(subjectKey string, groupKeys ...string) {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	for _, group := range groupKeys {
+		delete(membership.groups[subjectKey], group)
+	}
+}
+
+// Fail makes the resolver return err, until called with nil.
+func (membership *Membership) Fail(err error) {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	membership.err = err
+}
+
+// Calls returns the number of calls of IsMember and GroupsOf so far, eg. to check
+// the caching of the answers.
+func (membership *Membership) Calls() int {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	return membership.calls
+}
+
+// IsMember implements perms.MembershipResolver.
+func (membership *Membership) IsMember(ctx context.Context, subjectKey string, groupKey string) (bool, error) {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	membership.calls++
+	if membership.err != nil {
+		return false, membership.err
+	}
+	return membership.groups[subjectKey][groupKey], nil
+}
+
+// GroupsOf implements perms.MembershipResolver, returning the groups in name order.
+func (membership *Membership) GroupsOf(ctx context.Context, subjectKey string) ([]string, error) {
+	membership.mu.Lock()
+	defer membership.mu.Unlock()
+	membership.calls++
+	if membership.err != nil {
+		return nil, membership.err
+	}
+	var groups []string
+	for group := range membership.groups[subjectKey] {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// RunWithCoverage is like Run, but also reports an error for each enabled rule of rs
+// that none of the cases evaluated, see perms.Coverage.
+func RunWithCoverage(t testing.TB, rs *perms.RuleSet, cases []Case) perms.CoverageReport {
+	t.Helper()
+	coverage := rs.StartCoverage()
+	Run(t, rs, cases)
+	coverage.Assert(t)
+	return coverage.Report()
+}
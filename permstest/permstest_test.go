@@ -0,0 +1,174 @@
+package permstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+)
+
+// recorder is a testing.TB recording the failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	failures []string
+	fatal    bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	r.fatal = true
+	runtime.Goexit()
+}
+
+// record runs fn in its own goroutine, so that Fatalf can stop it.
+func record(t *testing.T, fn func(r *recorder)) *recorder {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+	return r
+}
+
+type user struct {
+	Name string
+}
+
+type video struct {
+	Owner string
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.AddRuleWithID("owner", &user{}, "edit", &video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			if res.(*video).Owner == subj.(*user).Name {
+				return true, perms.Allow, false
+			}
+			return true, perms.Deny, false
+		})
+	return rs
+}
+
+func TestAssertions(t *testing.T) {
+	rs := newRuleSet()
+	john := &user{Name: "john"}
+	mine, theirs := &video{Owner: "john"}, &video{Owner: "jack"}
+
+	AssertAllowed(t, rs, john, "edit", mine)
+	AssertDenied(t, rs, john, "edit", theirs)
+	AssertEffect(t, rs, john, "view", mine, perms.Deny)
+	RequireNoRuleFor(t, rs, john, "view", mine)
+
+	r := record(t, func(r *recorder) {
+		if AssertAllowed(r, rs, john, "edit", theirs) {
+			t.Error("AssertAllowed held for a denied query")
+		}
+	})
+	want := `(subject *permstest.user &{Name:john}, action "edit", resource *permstest.video &{Owner:jack}): got "deny" want "allow" from rule "owner"`
+	if len(r.failures) != 1 || r.failures[0] != want {
+		t.Errorf("got failures %q want %q", r.failures, want)
+	}
+
+	r = record(t, func(r *recorder) {
+		RequireNoRuleFor(r, rs, john, "edit", theirs)
+		t.Error("RequireNoRuleFor didn't stop the test")
+	})
+	if !r.fatal || len(r.failures) != 1 || !strings.Contains(r.failures[0], `want the default effect from rule "owner"`) {
+		t.Errorf("got failures %q want a fatal failure", r.failures)
+	}
+}
+
+func TestRun(t *testing.T) {
+	rs := newRuleSet()
+	john := &user{Name: "john"}
+	mine, theirs := &video{Owner: "john"}, &video{Owner: "jack"}
+	cases := []Case{
+		{Name: "owner edits", Subject: john, Action: "edit", Resource: mine, Want: perms.Allow},
+		{Name: "other edits", Subject: john, Action: "edit", Resource: theirs, Want: perms.Allow},
+		{Subject: john, Action: "view", Resource: mine, Want: perms.Allow},
+		{Subject: john, Action: "view", Resource: nil, Want: perms.Deny},
+	}
+	Run(t, rs, cases[:1])
+
+	r := record(t, func(r *recorder) {
+		Run(r, rs, cases)
+	})
+	want := []string{
+		`other edits: (subject *permstest.user &{Name:john}, action "edit", resource *permstest.video &{Owner:jack}): got "deny" want "allow" from rule "owner"`,
+		`case 2: (subject *permstest.user &{Name:john}, action "view", resource *permstest.video &{Owner:john}): got "deny" want "allow" (default effect, no rule applies)`,
+	}
+	if strings.Join(r.failures, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got failures\n%s\nwant\n%s", strings.Join(r.failures, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+type folder struct {
+	Group string
+}
+
+func (f *folder) GroupID() string { return f.Group }
+
+func TestMembership(t *testing.T) {
+	membership := NewMembership()
+	membership.Add("john", "editors", "staff")
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.Membership = membership
+	rs.AddMembershipRule("", []string{"edit"}, &folder{}, perms.Allow)
+
+	AssertAllowed(t, rs, "john", "edit", &folder{Group: "editors"})
+	AssertDenied(t, rs, "john", "edit", &folder{Group: "admins"})
+	AssertDenied(t, rs, "jack", "edit", &folder{Group: "editors"})
+
+	rs.AddRule(perms.GroupKey("staff"), "view", &folder{}, perms.WithEffect(func(s, a, r interface{}) bool { return true }, perms.Allow, false))
+	d, err := rs.QueryWithGroups(context.Background(), "john", "view", &folder{})
+	if err != nil || d.Effect != perms.Allow || d.Subject != perms.GroupKey("staff") {
+		t.Errorf("got %+v, %v want an allow for the staff group", d, err)
+	}
+
+	failure := errors.New("database down")
+	membership.Fail(failure)
+	if _, err := rs.QueryCtx(context.Background(), "john", "edit", &folder{Group: "editors"}); err != failure {
+		t.Errorf("got %v want the resolver error", err)
+	}
+	if d, err := rs.QueryWithGroups(context.Background(), "john", "view", &folder{}); err != failure || !d.Default {
+		t.Errorf("got %+v, %v want the default effect and the resolver error", d, err)
+	}
+}
+
+func TestRunWithCoverage(t *testing.T) {
+	rs := newRuleSet()
+	rs.AddRuleWithID("viewers", &user{}, "view", &video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return true, perms.Allow, false
+		})
+	john := &user{Name: "john"}
+	cases := []Case{
+		{Subject: john, Action: "edit", Resource: &video{Owner: "john"}, Want: perms.Allow},
+	}
+
+	r := record(t, func(r *recorder) {
+		RunWithCoverage(r, rs, cases)
+	})
+	if want := []string{"perms: rule viewers was never evaluated"}; strings.Join(r.failures, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got failures %q want %q", r.failures, want)
+	}
+
+	cases = append(cases, Case{Subject: john, Action: "view", Resource: &video{}, Want: perms.Allow})
+	report := RunWithCoverage(t, rs, cases)
+	if report.Rules != 2 || report.Matched != 2 {
+		t.Errorf("got %+v want both rules matched", report)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package promperm collects Prometheus metrics about the queries of a go-perms rule
+// set, see perms.RuleSet.Instrumentation:
+//
+//	collector := promperm.New(rs, nil)
+//	prometheus.MustRegister(collector)
+//	rs.Instrumentation = collector
+//
+// The metrics are, with the "perms" namespace by default:
+//
+//	perms_decisions_total{effect, subject_type, resource_type, action}
+//	perms_query_duration_seconds
+//	perms_matcher_errors_total{kind}    kind is "error" or "panic"
+//	perms_rules
+//
+// The types are those of the subjects and of the resources, eg. "*main.User", and the
+// actions are formatted with fmt.Sprint: a rule set with unbounded kinds of actions
+// makes unbounded series. The panics are those recovered by perms.RuleSet.WithRecover.
+// Use perms.MultiInstrumentation to also trace the queries, eg. with otelperm.
+package promperm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	perms "github.com/panta/go-perms"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures the metrics.
+type Options struct {
+	// Namespace is the prefix of the names of the metrics, "perms" if empty.
+	Namespace string
+	// Buckets are the buckets of the latency histogram, prometheus.DefBuckets if nil.
+	Buckets []float64
+	// ConstLabels are added to all the metrics, eg. to tell apart several rule sets.
+	ConstLabels prometheus.Labels
+}
+
+// Collector is a prometheus.Collector of the metrics of a rule set, and the
+// perms.RuleInstrumentation updating them.
+type Collector struct {
+	decisions *prometheus.CounterVec
+	duration  prometheus.Histogram
+	errors    *prometheus.CounterVec
+	rules     prometheus.GaugeFunc
+	// endRule is the function returned by StartRule, allocated once
+	endRule func(matched bool, effect perms.Effect, err error)
+}
+
+// New returns a collector of the metrics of ruleSet, to register with a
+// prometheus.Registerer and to set as the Instrumentation of ruleSet. The options can
+// be nil.
+func New(ruleSet *perms.RuleSet, options *Options) *Collector {
+	if options == nil {
+		options = &Options{}
+	}
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = "perms"
+	}
+	c := &Collector{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "decisions_total",
+			Help:        "Number of decisions of the queries, by effect, subject type, resource type and action.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"effect", "subject_type", "resource_type", "action"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "query_duration_seconds",
+			Help:        "Latency of the queries.",
+			Buckets:     options.Buckets,
+			ConstLabels: options.ConstLabels,
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "matcher_errors_total",
+			Help:        "Number of errors and recovered panics of the matchers.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"kind"}),
+		rules: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "rules",
+			Help:        "Number of rules of the rule set.",
+			ConstLabels: options.ConstLabels,
+		}, func() float64 {
+			return float64(ruleSet.RuleCount())
+		}),
+	}
+	matcherErrors, matcherPanics := c.errors.WithLabelValues("error"), c.errors.WithLabelValues("panic")
+	c.endRule = func(matched bool, effect perms.Effect, err error) {
+		if err == nil {
+			return
+		}
+		var recovered *perms.MatcherPanic
+		if errors.As(err, &recovered) {
+			matcherPanics.Inc()
+		} else {
+			matcherErrors.Inc()
+		}
+	}
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.decisions.Describe(ch)
+	c.duration.Describe(ch)
+	c.errors.Describe(ch)
+	c.rules.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.decisions.Collect(ch)
+	c.duration.Collect(ch)
+	c.errors.Collect(ch)
+	c.rules.Collect(ch)
+}
+
+// StartQuery implements perms.Instrumentation. The failed queries are timed, but make
+// no decision.
+func (c *Collector) StartQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (context.Context, func(decision perms.Decision, err error)) {
+	start := time.Now()
+	return ctx, func(decision perms.Decision, err error) {
+		c.duration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			return
+		}
+		c.decisions.WithLabelValues(decision.Effect, typeName(subject), typeName(resource), fmt.Sprint(action)).Inc()
+	}
+}
+
+// StartRule implements perms.RuleInstrumentation.
+func (c *Collector) StartRule(ctx context.Context, rule *perms.RuleInfo) (context.Context, func(matched bool, effect perms.Effect, err error)) {
+	return ctx, c.endRule
+}
+
+// typeName returns the name of the type of value, eg. "*main.User".
+func typeName(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,130 @@
+package promperm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	perms "github.com/panta/go-perms"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type user struct {
+	Name string
+}
+
+type document struct {
+	Owner string
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny).WithRecover(nil)
+	rs.AddRule(&user{}, "view", &document{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return res.(*document).Owner == subj.(*user).Name, perms.Allow, false
+	})
+	rs.AddRuleCtx(&user{}, "delete", &document{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		switch res.(*document).Owner {
+		case "broken":
+			panic("broken")
+		case "failing":
+			return false, "", false, errors.New("failing")
+		}
+		return false, "", false, nil
+	})
+	return rs
+}
+
+// scrape returns the values of the samples of the registry, by name and labels, eg.
+// "perms_matcher_errors_total{kind=panic}".
+func scrape(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			sort.Strings(labels)
+			name := family.GetName()
+			if len(labels) > 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case metric.Counter != nil:
+				samples[name] = metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				samples[name] = metric.GetGauge().GetValue()
+			case metric.Histogram != nil:
+				samples[name+"_count"] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return samples
+}
+
+func TestCollector(t *testing.T) {
+	rs := newRuleSet()
+	collector := New(rs, nil)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	rs.Instrumentation = collector
+	john := &user{Name: "john"}
+
+	rs.Query(john, "view", &document{Owner: "john"})
+	rs.Query(john, "view", &document{Owner: "john"})
+	rs.Query(john, "view", &document{Owner: "jack"})
+	rs.Query(john, "delete", &document{Owner: "broken"})
+	if _, err := rs.QueryCtx(context.Background(), john, "delete", &document{Owner: "failing"}); err == nil {
+		t.Fatal("got no error")
+	}
+	rs.Query(john, "share", "readme")
+
+	want := map[string]float64{
+		"perms_decisions_total{action=view,effect=allow,resource_type=*promperm.document,subject_type=*promperm.user}":  2,
+		"perms_decisions_total{action=view,effect=deny,resource_type=*promperm.document,subject_type=*promperm.user}":   1,
+		"perms_decisions_total{action=delete,effect=deny,resource_type=*promperm.document,subject_type=*promperm.user}": 1,
+		"perms_decisions_total{action=share,effect=deny,resource_type=string,subject_type=*promperm.user}":              1,
+		"perms_query_duration_seconds_count":     6,
+		"perms_matcher_errors_total{kind=error}": 1,
+		"perms_matcher_errors_total{kind=panic}": 1,
+		"perms_rules":                            2,
+	}
+	samples := scrape(t, registry)
+	for name, value := range want {
+		if samples[name] != value {
+			t.Errorf("got %s %v want %v", name, samples[name], value)
+		}
+	}
+	if len(samples) != len(want) {
+		t.Errorf("got samples %v want %v", samples, want)
+	}
+
+	rs.AddRule(nil, "share", nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, perms.Allow, false
+	})
+	if samples := scrape(t, registry); samples["perms_rules"] != 3 {
+		t.Errorf("got %v rules want 3", samples["perms_rules"])
+	}
+}
+
+func TestCollectorOptions(t *testing.T) {
+	rs := newRuleSet()
+	collector := New(rs, &Options{Namespace: "acl", Buckets: []float64{0.5}, ConstLabels: prometheus.Labels{"rule_set": "documents"}})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	rs.Instrumentation = perms.MultiInstrumentation(collector)
+	rs.Query(&user{Name: "john"}, "view", &document{Owner: "john"})
+
+	samples := scrape(t, registry)
+	if samples["acl_decisions_total{action=view,effect=allow,resource_type=*promperm.document,rule_set=documents,subject_type=*promperm.user}"] != 1 ||
+		samples["acl_rules{rule_set=documents}"] != 2 {
+		t.Errorf("got samples %v", samples)
+	}
+}
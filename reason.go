@@ -0,0 +1,34 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// ReasonMatcherFn is like MatcherCtxFn, but can also return a human-readable reason
+// for the effect, eg. "playlist 9374 is private and owned by jack".
+type ReasonMatcherFn func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool, reason string, err error)
+
+// AddRuleWithReason is like AddRuleCtx, but the matcher can give a reason for the
+// effect. The reason of the rule producing the final effect of a query is reported
+// in Decision.Reason (see QueryExplain) and to the AuditDecisionFn hook, those of
+// all the evaluated rules in the TraceRule events of QueryTrace.
+func (ruleSet *RuleSet) AddRuleWithReason(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher ReasonMatcherFn, options ...RuleOption) RuleID {
+	rule := newReasonRule(subjectType, actionType, resourceType, matcher)
+	rule.apply(options)
+	ruleSet.addRules(rule)
+	return rule.id
+}
+
+func newReasonRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher ReasonMatcherFn) *Rule {
+	rule := newRule(subjectType, actionType, resourceType, func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		matches, effect, quick, _, err := matcher(ctx, subject, action, resource)
+		return matches, effect, quick, err
+	})
+	rule.reasonMatcher = matcher
+	return rule
+}
@@ -0,0 +1,63 @@
+package perms
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestReason(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Combining = DenyOverrides
+	rs.AddRuleWithReason(&User{}, "view", &Playlist{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, string, error) {
+		playlist := res.(*Playlist)
+		if playlist.Public || playlist.User == subj.(*User).Name {
+			return true, ALLOW, false, "public or owned playlist", nil
+		}
+		return true, DENY, false, fmt.Sprintf("playlist %s is private and owned by %s", playlist.ID, playlist.User), nil
+	})
+	rs.AddRuleWithReason(&User{}, "view", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, string, error) {
+		return true, ALLOW, false, "everyone can view", nil
+	})
+	// the plain matchers give no reason
+	rs.AddRule(&User{}, "modify", &Playlist{}, effectMatcher(DENY))
+	var audited []AuditEvent
+	rs.AuditDecisionFn = func(event AuditEvent) {
+		audited = append(audited, event)
+	}
+
+	john := &User{Name: "john"}
+	jackPlaylist := &Playlist{ID: "9374", User: "jack"}
+	want := "playlist 9374 is private and owned by jack"
+	if d := rs.QueryExplain(john, "view", jackPlaylist); d.Effect != DENY || d.Reason != want {
+		t.Errorf("got %+v want the reason %q", d, want)
+	}
+	if len(audited) != 1 || audited[0].Decision.Reason != want || audited[0].Subject != john {
+		t.Errorf("got audit events %+v want the reason %q", audited, want)
+	}
+	if d := rs.QueryExplain(john, "view", &Playlist{ID: "6563", User: "john"}); d.Reason != "public or owned playlist" {
+		t.Errorf("got reason %q want %q", d.Reason, "public or owned playlist")
+	}
+	if d := rs.QueryExplain(john, "modify", jackPlaylist); d.Effect != DENY || d.Reason != "" {
+		t.Errorf("got %+v want no reason", d)
+	}
+
+	// the reasons of the other rules are only traced
+	reasons := map[string]bool{}
+	var result Decision
+	rs.QueryTrace(john, "view", &Playlist{ID: "6563", User: "john"}, func(ev TraceEvent) {
+		switch ev.Kind {
+		case TraceRule:
+			reasons[ev.Reason] = true
+		case TraceResult:
+			result = ev.Decision
+		}
+	})
+	if !reasons["public or owned playlist"] || !reasons["everyone can view"] {
+		t.Errorf("got traced reasons %v", reasons)
+	}
+	// with DenyOverrides the later allow doesn't replace the rule of the result
+	if result.Reason != "public or owned playlist" {
+		t.Errorf("got result reason %q want %q", result.Reason, "public or owned playlist")
+	}
+}
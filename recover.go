@@ -0,0 +1,55 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// MatcherPanic is the value passed to the WithRecover handler for a panicking
+// matcher.
+type MatcherPanic struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (p *MatcherPanic) Error() string {
+	return fmt.Sprintf("perms: matcher panic: %v", p.Value)
+}
+
+// WithRecover makes the queries recover from the panics of the matchers: a panicking
+// rule does not match, and the evaluation goes on with the next rules. Each panic is
+// reported to the Logger and, if non-nil, to handler, with the rule and a
+// *MatcherPanic holding the panic value and the stack trace. It returns the rule set,
+// to allow chaining.
+//
+// The matcher panics are not recovered otherwise, as a buggy matcher might as well
+// leave things in an inconsistent state.
+func (ruleSet *RuleSet) WithRecover(handler func(rule RuleInfo, recovered interface{})) *RuleSet {
+	ruleSet.recoverPanics = true
+	ruleSet.recoverFn = handler
+	return ruleSet
+}
+
+// callRecovering is like call, recovering from the panics of the matcher.
+func (ev *evaluation) callRecovering(c candidate) (matches bool, effect string, quick bool, obligations []Obligation, reason string, fields map[string]Effect, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			matches, effect, quick, obligations, reason, fields, err = false, "", false, nil, "", nil, nil
+			recovered := &MatcherPanic{Value: r, Stack: debug.Stack()}
+			ev.panicked = recovered
+			info := c.rule.info(c.index)
+			ev.ruleSet.logf("perms: rule %q matcher panic: %v\n%s", info.ID, r, recovered.Stack)
+			if ev.ruleSet.recoverFn != nil {
+				ev.ruleSet.recoverFn(*info, recovered)
+			}
+		}
+	}()
+	return ev.call(c)
+}
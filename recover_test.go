@@ -0,0 +1,57 @@
+package perms
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWithRecover(t *testing.T) {
+	var logged bytes.Buffer
+	var rules []RuleInfo
+	var panics []*MatcherPanic
+	rs := NewRuleSet(DENY).WithRecover(func(rule RuleInfo, recovered interface{}) {
+		rules = append(rules, rule)
+		panics = append(panics, recovered.(*MatcherPanic))
+	})
+	rs.SetLogger(LoggerFunc(func(format string, args ...interface{}) {
+		fmt.Fprintf(&logged, format, args...)
+	}))
+	rs.AddRule(&User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		var owners map[string]*User
+		return owners[res.(*Video).User].Name == subj.(*User).Name, DENY, true
+	}, Named("buggy"))
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+
+	if got := rs.Query(&User{Name: "john"}, "view", &Video{User: "john"}); got != ALLOW {
+		t.Errorf("got %q want the second rule allowing", got)
+	}
+	if len(panics) != 1 {
+		t.Fatalf("got %d panics want 1", len(panics))
+	}
+	if rules[0].ID != "rule-1" || rules[0].Name != "buggy" {
+		t.Errorf("got rule %+v want the buggy one", rules[0])
+	}
+	if !bytes.Contains(panics[0].Stack, []byte("TestWithRecover")) {
+		t.Errorf("got stack %s want it through the matcher", panics[0].Stack)
+	}
+	if !strings.Contains(panics[0].Error(), "nil pointer") {
+		t.Errorf("got %q want the panic value", panics[0].Error())
+	}
+	if !strings.Contains(logged.String(), `rule "rule-1" matcher panic`) {
+		t.Errorf("got log %q want the panic reported", logged.String())
+	}
+
+	// opt-in
+	rs = NewRuleSet(DENY)
+	rs.AddRule(&User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		panic("buggy")
+	})
+	defer func() {
+		if r := recover(); r != "buggy" {
+			t.Errorf("got %v want the panic propagated", r)
+		}
+	}()
+	rs.Query(&User{}, "view", &Video{})
+}
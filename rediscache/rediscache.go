@@ -0,0 +1,131 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package rediscache stores the decisions of go-perms rule sets in Redis, so that the
+// replicas of a program share them, see perms.Cache.
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	rs.WithSharedCache(rediscache.New(client, nil), time.Minute, perms.IdentityCacheKey)
+//
+// The effects are stored under keys made of a prefix, the version of the cache and the
+// key of the decision. InvalidateAll increments the version, stored under the prefix
+// too and read by every Get, so that the effects stored before by any replica are not
+// returned anymore, and expire.
+package rediscache
+
+import (
+	"context"
+	"time"
+
+	perms "github.com/panta/go-perms"
+	"github.com/redis/go-redis/v9"
+)
+
+// Options configures a Cache.
+type Options struct {
+	// Prefix is the prefix of the keys of the cache, "perms:" if empty. The rule sets
+	// with different rules must use different prefixes.
+	Prefix string
+	// Timeout, if positive, limits the duration of each operation.
+	Timeout time.Duration
+	// OnError, if not nil, is called with the errors of Redis, which are otherwise
+	// ignored: Get misses, and Set and InvalidateAll do nothing.
+	OnError func(error)
+}
+
+// Cache is a perms.Cache over Redis.
+type Cache struct {
+	client  redis.UniversalClient
+	prefix  string
+	timeout time.Duration
+	onError func(error)
+}
+
+var _ perms.Cache = (*Cache)(nil)
+
+// New returns a cache storing the effects with client. The options can be nil.
+func New(client redis.UniversalClient, options *Options) *Cache {
+	cache := &Cache{client: client, prefix: "perms:"}
+	if options != nil {
+		if options.Prefix != "" {
+			cache.prefix = options.Prefix
+		}
+		cache.timeout = options.Timeout
+		cache.onError = options.OnError
+	}
+	return cache
+}
+
+func (cache *Cache) context() (context.Context, context.CancelFunc) {
+	if cache.timeout > 0 {
+		return context.WithTimeout(context.Background(), cache.timeout)
+	}
+	return context.Background(), func() {}
+}
+
+func (cache *Cache) report(err error) {
+	if cache.onError != nil {
+		cache.onError(err)
+	}
+}
+
+// version returns the current version of the cache.
+func (cache *Cache) version(ctx context.Context) (string, error) {
+	version, err := cache.client.Get(ctx, cache.prefix+"version").Result()
+	if err == redis.Nil {
+		return "0", nil
+	}
+	return version, err
+}
+
+func (cache *Cache) key(version string, key string) string {
+	return cache.prefix + "v" + version + ":" + key
+}
+
+// Get returns the effect stored for key with the current version.
+func (cache *Cache) Get(key string) (string, bool) {
+	ctx, cancel := cache.context()
+	defer cancel()
+	version, err := cache.version(ctx)
+	if err != nil {
+		cache.report(err)
+		return "", false
+	}
+	effect, err := cache.client.Get(ctx, cache.key(version, key)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			cache.report(err)
+		}
+		return "", false
+	}
+	return effect, true
+}
+
+// Set stores the effect for key with the current version, expiring after ttl. A non
+// positive ttl never expires, keeping the effects of the old versions too.
+func (cache *Cache) Set(key string, effect string, ttl time.Duration) {
+	ctx, cancel := cache.context()
+	defer cancel()
+	version, err := cache.version(ctx)
+	if err != nil {
+		cache.report(err)
+		return
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	if err := cache.client.Set(ctx, cache.key(version, key), effect, ttl).Err(); err != nil {
+		cache.report(err)
+	}
+}
+
+// InvalidateAll increments the version of the cache.
+func (cache *Cache) InvalidateAll() {
+	ctx, cancel := cache.context()
+	defer cancel()
+	if err := cache.client.Incr(ctx, cache.prefix+"version").Err(); err != nil {
+		cache.report(err)
+	}
+}
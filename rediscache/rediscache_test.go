@@ -0,0 +1,107 @@
+package rediscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	perms "github.com/panta/go-perms"
+	"github.com/redis/go-redis/v9"
+)
+
+type user struct {
+	ID string
+}
+
+func (u *user) PermKey() string {
+	return u.ID
+}
+
+type document struct {
+	ID     string
+	Public bool
+}
+
+func (d *document) PermKey() string {
+	return d.ID
+}
+
+func newCache(t *testing.T) (*miniredis.Miniredis, *Cache) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return server, New(client, &Options{Prefix: "test:", OnError: func(err error) {
+		t.Errorf("got %v", err)
+	}})
+}
+
+func TestCache(t *testing.T) {
+	server, cache := newCache(t)
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("got a missing key")
+	}
+	cache.Set("key", perms.Allow, time.Minute)
+	if effect, ok := cache.Get("key"); !ok || effect != perms.Allow {
+		t.Errorf("got %q, %v want allow", effect, ok)
+	}
+	if !server.Exists("test:v0:key") || server.TTL("test:v0:key") != time.Minute {
+		t.Errorf("got keys %v want test:v0:key expiring", server.Keys())
+	}
+	cache.InvalidateAll()
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("got a key stored before InvalidateAll")
+	}
+	cache.Set("key", perms.Deny, time.Minute)
+	if effect, ok := cache.Get("key"); !ok || effect != perms.Deny {
+		t.Errorf("got %q, %v want deny", effect, ok)
+	}
+	server.FastForward(2 * time.Minute)
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("got an expired key")
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	_, cache := newCache(t)
+	// two replicas, with the same rules
+	queries := 0
+	newReplica := func() *perms.RuleSet {
+		rs := perms.NewRuleSet(perms.Deny).WithSharedCache(cache, time.Minute, perms.IdentityCacheKey)
+		rs.AddRule(&user{}, "view", &document{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			queries++
+			return res.(*document).Public, perms.Allow, false
+		})
+		return rs
+	}
+	first, second := newReplica(), newReplica()
+	john := &user{ID: "john"}
+	// the document changed without a change of the rules, to tell the cached decisions
+	doc := &document{ID: "readme", Public: true}
+	if !first.IsAllowed(john, "view", doc) || queries != 1 {
+		t.Fatalf("got the decision denied or cached")
+	}
+	doc.Public = false
+	if !second.IsAllowed(john, "view", doc) || queries != 1 {
+		t.Errorf("got the decision of the first replica not shared")
+	}
+
+	// a policy change of a replica invalidates the decisions of the others
+	second.AddRuleWithPriority(10, &user{}, "view", &document{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, perms.Deny, true
+	})
+	if first.IsAllowed(john, "view", doc) || queries != 2 {
+		t.Errorf("got the stale decision after the policy change")
+	}
+	// the default decisions are not cached, the others are again
+	doc.Public = true
+	if !first.IsAllowed(john, "view", doc) || queries != 3 {
+		t.Errorf("got the default decision cached")
+	}
+	doc.Public = false
+	if !first.IsAllowed(john, "view", doc) || queries != 3 {
+		t.Errorf("got the decision not cached again")
+	}
+}
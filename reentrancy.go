@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxQueryDepth is the number of nested queries allowed when
+// RuleSet.MaxQueryDepth is 0.
+const DefaultMaxQueryDepth = 16
+
+var (
+	// ErrQueryCycle is matched by the error of a nested query (see MaxQueryDepth)
+	// repeating one of the queries it is nested in, which would never end.
+	ErrQueryCycle = errors.New("perms: query cycle")
+	// ErrQueryDepthExceeded is returned by a query nested deeper than
+	// RuleSet.MaxQueryDepth.
+	ErrQueryDepthExceeded = errors.New("perms: query depth exceeded")
+)
+
+// evaluationKey is the context key of the evaluationContext of a query.
+type evaluationKey struct{}
+
+// evaluationContext is the context passed to the matchers by an evaluation, telling
+// the queries they make, with the context they are given, that they are nested in it.
+// It is only allocated for the matchers receiving the context, sparing an allocation
+// to the queries of the others.
+type evaluationContext struct {
+	context.Context
+	// ruleSet and table are the rules of the evaluation, and trace its trace
+	ruleSet *RuleSet
+	table   *ruleTable
+	trace   func(TraceEvent)
+	// maxEvaluations is the evaluation budget of the query, shared with its
+	// sub-queries (see QueryContext.SubQuery), and evaluations the matchers run so far
+	maxEvaluations int
+	evaluations    int
+	// subject, action and resource are the values of the query
+	subject  interface{}
+	action   interface{}
+	resource interface{}
+	// caller is the evaluation the query is nested in, nil for the outermost one,
+	// and depth the number of the queries it is nested in
+	caller *evaluationContext
+	depth  int
+}
+
+func (ctx *evaluationContext) Value(key interface{}) interface{} {
+	if key == (evaluationKey{}) {
+		return ctx
+	}
+	return ctx.Context.Value(key)
+}
+
+// enterFrame makes the evaluation pass an evaluationContext to the matchers.
+func (ev *evaluation) enterFrame() {
+	ev.frame = &evaluationContext{
+		Context:        ev.ctx,
+		ruleSet:        ev.ruleSet,
+		table:          ev.table,
+		trace:          ev.trace,
+		maxEvaluations: ev.maxEvaluations,
+		subject:        ev.query[0],
+		action:         ev.query[1],
+		resource:       ev.query[2],
+		caller:         ev.caller,
+	}
+	if ev.caller != nil {
+		ev.frame.depth = ev.caller.depth + 1
+	}
+	ev.ctx = ev.frame
+}
+
+// callerOf returns the evaluation running the matcher which made the query of ctx,
+// nil if the query is not nested.
+func callerOf(ctx context.Context) *evaluationContext {
+	caller, _ := ctx.Value(evaluationKey{}).(*evaluationContext)
+	return caller
+}
+
+// checkNesting returns an error if the query of (subject, action, resource), nested in
+// caller, repeats one of the queries it is nested in or is nested too deep. The error
+// is reported to the logger and to the trace of the closest caller having one.
+func (ruleSet *RuleSet) checkNesting(caller *evaluationContext, subject interface{}, action interface{}, resource interface{}) error {
+	if caller == nil {
+		return nil
+	}
+	var err error
+	max := ruleSet.MaxQueryDepth
+	if max == 0 {
+		max = DefaultMaxQueryDepth
+	}
+	if max > 0 && caller.depth+1 > max {
+		err = ErrQueryDepthExceeded
+	} else {
+		keys := [...]string{KeyOf(subject), KeyOf(action), KeyOf(resource)}
+		for frame := caller; frame != nil; frame = frame.caller {
+			if KeyOf(frame.subject) == keys[0] && KeyOf(frame.action) == keys[1] && KeyOf(frame.resource) == keys[2] {
+				err = fmt.Errorf("%w: %s", ErrQueryCycle, describeNesting(caller, keys))
+				break
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	ruleSet.logf("perms: nested query stopped: %v", err)
+	for frame := caller; frame != nil; frame = frame.caller {
+		if frame.trace != nil {
+			frame.trace(TraceEvent{Kind: TraceNested, Err: err})
+			break
+		}
+	}
+	return err
+}
+
+// describeNesting returns the chain of the queries nested in one another, from the
+// outermost one to the query of keys.
+func describeNesting(caller *evaluationContext, keys [3]string) string {
+	steps := []string{strings.Join(keys[:], " ")}
+	for frame := caller; frame != nil; frame = frame.caller {
+		steps = append(steps, KeyOf(frame.subject)+" "+KeyOf(frame.action)+" "+KeyOf(frame.resource))
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return strings.Join(steps, " -> ")
+}
@@ -0,0 +1,104 @@
+package perms
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// delegatingMatcher allows the actions allowed by rs for action on the same values.
+func delegatingMatcher(rs *RuleSet, action string, calls *int) MatcherCtxFn {
+	return func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		*calls++
+		effect, err := rs.QueryCtx(ctx, subj, action, res)
+		if err != nil {
+			return false, "", false, err
+		}
+		return effect == ALLOW, ALLOW, false, nil
+	}
+}
+
+func TestQueryCycle(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	logger := &recordingLogger{}
+	rs.Logger = logger
+	var calls int
+	// modify is allowed to those who can view, and view to those who can modify
+	rs.AddRuleCtx(&User{}, "modify", &Playlist{}, delegatingMatcher(rs, "view", &calls))
+	rs.AddRuleCtx(&User{}, "view", &Playlist{}, delegatingMatcher(rs, "modify", &calls))
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "best"}
+
+	var nested []TraceEvent
+	if got := rs.QueryTrace(john, "modify", playlist, func(ev TraceEvent) {
+		if ev.Kind == TraceNested {
+			nested = append(nested, ev)
+		}
+	}); got != DENY {
+		t.Errorf("got %q want the default effect", got)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls want 2", calls)
+	}
+	if len(nested) != 1 || !errors.Is(nested[0].Err, ErrQueryCycle) {
+		t.Fatalf("got %+v want the cycle traced", nested)
+	}
+	if msg := nested[0].Err.Error(); !strings.Contains(msg, "modify") || !strings.Contains(msg, " -> ") {
+		t.Errorf("got %q want the chain of the queries", msg)
+	}
+	reported := false
+	for _, line := range logger.lines {
+		reported = reported || strings.Contains(line, "nested query stopped") && strings.Contains(line, ErrQueryCycle.Error())
+	}
+	if !reported {
+		t.Errorf("got %q want the cycle logged", logger.lines)
+	}
+
+	calls = 0
+	if _, err := rs.QueryE(john, "view", playlist); !errors.Is(err, ErrQueryCycle) {
+		t.Errorf("got %v want %v", err, ErrQueryCycle)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls want 2", calls)
+	}
+
+	// the queries not nested in the matcher are independent
+	calls = 0
+	rs.AddRuleCtx(&User{}, "share", &Playlist{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		calls++
+		if calls > 1 {
+			return true, ALLOW, false, nil
+		}
+		return true, rs.Query(subj, "share", res), false, nil
+	})
+	if got := rs.Query(john, "share", playlist); got != ALLOW || calls != 2 {
+		t.Errorf("got %q, %d calls want %q from the inner query", got, calls, ALLOW)
+	}
+}
+
+func TestMaxQueryDepth(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.MaxQueryDepth = 3
+	var depth int
+	// every query asks about the parent folder, with no end
+	rs.AddRuleCtx(nil, "view", nil, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		depth++
+		effect, err := rs.QueryCtx(ctx, subj, act, res.(string)+"/..")
+		return err == nil, effect, false, err
+	})
+
+	if _, err := rs.QueryE("john", "view", "/docs"); !errors.Is(err, ErrQueryDepthExceeded) {
+		t.Errorf("got %v want %v", err, ErrQueryDepthExceeded)
+	}
+	// the outermost query and the 3 nested ones
+	if depth != 4 {
+		t.Errorf("got %d matchers run want 4", depth)
+	}
+
+	depth = 0
+	rs.MaxQueryDepth = 0
+	if _, err := rs.QueryE("john", "view", "/docs"); !errors.Is(err, ErrQueryDepthExceeded) || depth != DefaultMaxQueryDepth+1 {
+		t.Errorf("got %v, %d matchers run want %v after %d", err, depth, ErrQueryDepthExceeded, DefaultMaxQueryDepth+1)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// regexpTemplate matches string values against a compiled regular expression.
+type regexpTemplate struct {
+	re *regexp.Regexp
+}
+
+func (template *regexpTemplate) matchTemplate(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && template.re.MatchString(s)
+}
+
+// compileFullMatch compiles expr so that it must match the whole string.
+func compileFullMatch(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
+// AddRegexpRule is like AddRule, but non-empty string templates are regular expressions
+// (in the syntax of the regexp package) that the queried strings must match entirely,
+// as if the expression was enclosed between ^ and $. Expressions are compiled once,
+// when the rule is added; an invalid expression returns an error and no rule is added.
+func (ruleSet *RuleSet) AddRegexpRule(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) (RuleID, error) {
+	rule := newPlainRule(subjectType, actionType, resourceType, matcher.ErrFn())
+	rule.apply(options)
+	for position, template := range [...]interface{}{subjectType, actionType, resourceType} {
+		expr, ok := template.(string)
+		if !ok || expr == "" {
+			continue
+		}
+		re, err := compileFullMatch(expr)
+		if err != nil {
+			return "", fmt.Errorf("perms: %v", err)
+		}
+		rule.patterns[position] = &regexpTemplate{re: re}
+	}
+	ruleSet.addRules(rule)
+	return rule.id, nil
+}
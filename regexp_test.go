@@ -0,0 +1,75 @@
+package perms
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAddRegexpRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if _, err := rs.AddRegexpRule(`svc-[a-z]+`, "view|list", nil, effectMatcher(ALLOW)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.AddRegexpRule(`ops-.*`, `delete`, `/projects/\d+/.*`, effectMatcher("audit")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		subject, action, resource string
+		want                      string
+	}{
+		{"svc-billing", "view", "invoices", ALLOW},
+		{"svc-billing", "list", "invoices", ALLOW},
+		// the whole string must match
+		{"svc-billing2", "view", "invoices", DENY},
+		{"my-svc-billing", "view", "invoices", DENY},
+		{"svc-billing", "preview", "invoices", DENY},
+		{"ops-jack", "delete", "/projects/42/assets/7", "audit"},
+		{"ops-jack", "delete", "/projects/x/assets/7", DENY},
+	}
+	for _, c := range cases {
+		if got := rs.Query(c.subject, c.action, c.resource); got != c.want {
+			t.Errorf("Query(%q, %q, %q): got %q want %q", c.subject, c.action, c.resource, got, c.want)
+		}
+	}
+}
+
+func TestAddRegexpRuleInvalid(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if _, err := rs.AddRegexpRule(`svc-[a-z`, nil, nil, effectMatcher(ALLOW)); err == nil {
+		t.Error("got no error for an invalid expression")
+	}
+	if n := len(rs.byID); n != 0 {
+		t.Errorf("got %d rules after an invalid expression", n)
+	}
+}
+
+func BenchmarkRegexpRuleQuery(b *testing.B) {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < 100; i++ {
+		if _, err := rs.AddRegexpRule(fmt.Sprintf(`svc-%d-[a-z]+`, i), "view", "invoices", effectMatcher(ALLOW)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query("svc-99-billing", "view", "invoices") != ALLOW {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func BenchmarkLiteralRuleQuery(b *testing.B) {
+	rs := NewRuleSet(DENY)
+	for i := 0; i < 100; i++ {
+		rs.AddRule(fmt.Sprintf(`svc-%d-billing`, i), "view", "invoices", effectMatcher(ALLOW))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query("svc-99-billing", "view", "invoices") != ALLOW {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"sort"
+	"time"
+)
+
+// RevokedRuleID is the ID of the rule passed to the AuditFn hook for the queries of
+// revoked subjects, see RevokeSubject.
+const RevokedRuleID RuleID = "revoked"
+
+// RevocationInfo describes a revoked subject, see Revocations.
+type RevocationInfo struct {
+	SubjectKey string
+	// Expires is the time the revocation ends, zero if it doesn't expire.
+	Expires time.Time
+}
+
+// RevokeSubject revokes every permission of the subject with subjectKey, eg. a
+// compromised API key: its queries produce RevokedEffect without evaluating any rule,
+// nor the SuperuserFn, until UnrevokeSubject. The keys are those returned by
+// RevocationKey, by default the strings themselves for string subjects and the
+// PermKey of the Identifiable ones. The decisions have Revoked set.
+//
+// The revocation applies at once to every query of the rule set, including those of
+// the snapshots (see Snapshot), the bound checkers (see For) and the versions
+// rolled back to later (see Rollback), whatever rules they evaluate.
+func (ruleSet *RuleSet) RevokeSubject(subjectKey string) {
+	ruleSet.RevokeSubjectUntil(subjectKey, time.Time{})
+}
+
+// RevokeSubjectUntil is like RevokeSubject, but the revocation ends at expires,
+// according to the RuleSet Now clock. A zero expires never ends.
+func (ruleSet *RuleSet) RevokeSubjectUntil(subjectKey string, expires time.Time) {
+	ruleSet.updateRevocations(func(revoked map[string]time.Time) {
+		revoked[subjectKey] = expires
+	})
+}
+
+// UnrevokeSubject undoes RevokeSubject, returning false if the subject wasn't revoked.
+func (ruleSet *RuleSet) UnrevokeSubject(subjectKey string) bool {
+	found := false
+	ruleSet.updateRevocations(func(revoked map[string]time.Time) {
+		_, found = revoked[subjectKey]
+		delete(revoked, subjectKey)
+	})
+	return found
+}
+
+// Revocations returns the current revocations, in subject key order.
+func (ruleSet *RuleSet) Revocations() []RevocationInfo {
+	now := ruleSet.now()
+	var infos []RevocationInfo
+	for key, expires := range ruleSet.current().revoked {
+		if revokedAt(expires, now) {
+			infos = append(infos, RevocationInfo{SubjectKey: key, Expires: expires})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SubjectKey < infos[j].SubjectKey })
+	return infos
+}
+
+// updateRevocations publishes a copy of the revocations changed by fn, dropping the
+// expired ones.
+func (ruleSet *RuleSet) updateRevocations(fn func(revoked map[string]time.Time)) {
+	now := ruleSet.now()
+	ruleSet.update(func(w *tableWriter) {
+		revoked := make(map[string]time.Time, len(w.table.revoked)+1)
+		for key, expires := range w.table.revoked {
+			if revokedAt(expires, now) {
+				revoked[key] = expires
+			}
+		}
+		fn(revoked)
+		w.table.revoked = revoked
+	})
+}
+
+func revokedAt(expires time.Time, t time.Time) bool {
+	return expires.IsZero() || t.Before(expires)
+}
+
+// revoked reports whether subject is revoked in table.
+func (ruleSet *RuleSet) revoked(table *ruleTable, subject interface{}) bool {
+	if len(table.revoked) == 0 {
+		return false
+	}
+	key, ok := ruleSet.revocationKey(subject)
+	return ok && ruleSet.revokedKey(table, key)
+}
+
+// revocationKey returns the key of subject for RevokeSubject.
+func (ruleSet *RuleSet) revocationKey(subject interface{}) (string, bool) {
+	if ruleSet.RevocationKey != nil {
+		return ruleSet.RevocationKey(subject)
+	}
+	return valueKey(subject)
+}
+
+// revokedKey reports whether the subject with key is revoked in table.
+func (ruleSet *RuleSet) revokedKey(table *ruleTable, key string) bool {
+	expires, ok := table.revoked[key]
+	return ok && revokedAt(expires, ruleSet.now())
+}
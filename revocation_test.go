@@ -0,0 +1,127 @@
+package perms
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRevokeSubject(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.WithCache(100, nil)
+	rs.SuperuserFn = func(subject interface{}) bool {
+		user, ok := subject.(*User)
+		return ok && user.IsSuperuser
+	}
+	rs.RevocationKey = userKey
+	var audited []*RuleInfo
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		audited = append(audited, rule)
+	}
+	want := newVideoRuleSet()
+	want.SuperuserFn = rs.SuperuserFn
+	admin := &User{Name: "admin", IsSuperuser: true}
+	playlist := &Playlist{ID: "6563", User: "john"}
+
+	if !rs.IsAllowed(admin, "delete", playlist) {
+		t.Fatalf("got the superuser denied before the revocation")
+	}
+	rs.RevokeSubject("admin")
+	d := rs.QueryExplain(admin, "delete", playlist)
+	if d.Effect != DENY || !d.Revoked || d.Superuser {
+		t.Errorf("got %+v want the revoked superuser denied", d)
+	}
+	if rule := audited[len(audited)-1]; rule == nil || rule.ID != RevokedRuleID {
+		t.Errorf("got audited rule %+v want %q", rule, RevokedRuleID)
+	}
+	var traced Decision
+	rs.QueryTrace(admin, "view", playlist, func(ev TraceEvent) {
+		if ev.Kind == TraceResult {
+			traced = ev.Decision
+		}
+	})
+	if !traced.Revoked {
+		t.Errorf("got traced decision %+v want it revoked", traced)
+	}
+	if got, want := rs.Revocations(), []RevocationInfo{{SubjectKey: "admin"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got revocations %+v want %+v", got, want)
+	}
+
+	rs.RevocationKey, rs.SuperuserFn = nil, nil
+	rs.RevokeSubject("mallory")
+	if got := rs.Query("mallory", "view", "report"); got != DENY {
+		t.Errorf("got %q want %q for a revoked string subject", got, DENY)
+	}
+	rs.RevokedEffect = "revoked"
+	if got := rs.Query("mallory", "view", "report"); got != "revoked" {
+		t.Errorf("got %q want the RevokedEffect", got)
+	}
+
+	rs.RevocationKey, rs.SuperuserFn = userKey, want.SuperuserFn
+	if !rs.UnrevokeSubject("admin") || rs.UnrevokeSubject("admin") {
+		t.Errorf("got the wrong results unrevoking the subject")
+	}
+	checkSameDecisions(t, rs, want)
+}
+
+func TestRevokeSubjectSnapshot(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.WithCache(100, nil)
+	rs.RevocationKey = userKey
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "john"}
+	snapshot := rs.Snapshot()
+	checker := rs.For(john)
+	if !snapshot.IsAllowed(john, "view", playlist) || !checker.IsAllowed("view", playlist) {
+		t.Fatalf("got john denied before the revocation")
+	}
+
+	// the revocations apply to the snapshots and the checkers taken before
+	rs.RevokeSubject("john")
+	if d := snapshot.QueryExplain(john, "view", playlist); d.Effect != DENY || !d.Revoked {
+		t.Errorf("got %+v from the snapshot want the revoked subject denied", d)
+	}
+	if d := checker.QueryExplain("view", playlist); d.Effect != DENY || !d.Revoked {
+		t.Errorf("got %+v from the checker want the revoked subject denied", d)
+	}
+	if rs.UnrevokeSubject("john"); !snapshot.IsAllowed(john, "view", playlist) || !checker.IsAllowed("view", playlist) {
+		t.Errorf("got john denied after the end of the revocation")
+	}
+}
+
+func TestRevokeSubjectUntil(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	rs := NewRuleSet(DENY)
+	rs.Now = func() time.Time { return now }
+	rs.AddRule("", "view", "", effectMatcher(ALLOW))
+	rs.RevokeSubjectUntil("key-1", now.Add(time.Hour))
+
+	if rs.IsAllowed("key-1", "view", "report") || !rs.IsAllowed("key-2", "view", "report") {
+		t.Errorf("got the wrong decisions during the revocation")
+	}
+	now = now.Add(time.Hour)
+	if !rs.IsAllowed("key-1", "view", "report") {
+		t.Errorf("got key-1 denied after the revocation ended")
+	}
+	if got := rs.Revocations(); len(got) != 0 {
+		t.Errorf("got revocations %+v after they ended", got)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rs.RevokeSubject("key-3")
+				rs.Query("key-3", "view", "report")
+				rs.UnrevokeSubject("key-3")
+			}
+		}()
+	}
+	wg.Wait()
+	if !rs.IsAllowed("key-3", "view", "report") {
+		t.Errorf("got key-3 still revoked")
+	}
+}
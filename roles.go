@@ -0,0 +1,320 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrRoleCycle is returned by AddInheritance when the inheritance would make a role
+// inherit from itself.
+var ErrRoleCycle = errors.New("perms: role inheritance cycle")
+
+// Roles is a role based access control layer: subjects are assigned roles, and roles
+// are granted effects for (action, resource type) pairs.
+// When set as RuleSet.Roles, it is consulted by queries for which no rule applies.
+// Roles can inherit the grants of other roles, see AddInheritance.
+// Roles is safe for concurrent use.
+type Roles struct {
+	// SubjectKey maps a queried subject to the key roles are assigned to. It returns
+	// false if the subject has no key. When nil, only string subjects have a key, the
+	// string itself.
+	SubjectKey func(subject interface{}) (string, bool)
+
+	mu          sync.RWMutex
+	assignments map[string]map[string]bool
+	grants      map[string][]roleGrant
+	// parents maps the roles to those they directly inherit from, in name order
+	parents map[string][]string
+	// ancestors caches the closure of parents: the roles each role inherits from,
+	// transitively, the closest first. It is rebuilt when parents changes.
+	ancestors map[string][]string
+}
+
+type roleGrant struct {
+	action       interface{}
+	resourceType reflect.Type
+	effect       Effect
+}
+
+// NewRoles returns an empty RBAC layer.
+func NewRoles() *Roles {
+	return &Roles{
+		assignments: make(map[string]map[string]bool),
+		grants:      make(map[string][]roleGrant),
+	}
+}
+
+// AssignRole assigns role to the subject with the given key.
+func (roles *Roles) AssignRole(subjectKey string, role string) {
+	roles.mu.Lock()
+	defer roles.mu.Unlock()
+	assigned, ok := roles.assignments[subjectKey]
+	if !ok {
+		assigned = make(map[string]bool)
+		roles.assignments[subjectKey] = assigned
+	}
+	assigned[role] = true
+}
+
+// RevokeRole removes role from the roles of the subject with the given key.
+func (roles *Roles) RevokeRole(subjectKey string, role string) {
+	roles.mu.Lock()
+	defer roles.mu.Unlock()
+	assigned := roles.assignments[subjectKey]
+	delete(assigned, role)
+	if len(assigned) == 0 {
+		delete(roles.assignments, subjectKey)
+	}
+}
+
+// RolesOf returns the sorted roles of the subject with the given key.
+func (roles *Roles) RolesOf(subjectKey string) []string {
+	roles.mu.RLock()
+	defer roles.mu.RUnlock()
+	return roles.rolesOf(subjectKey)
+}
+
+func (roles *Roles) rolesOf(subjectKey string) []string {
+	assigned := roles.assignments[subjectKey]
+	names := make([]string, 0, len(assigned))
+	for role := range assigned {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GrantRole grants effect to the subjects holding role, for the action on resources of
+// the same type as resourceType. A nil action or resourceType applies to any action or
+// resource.
+func (roles *Roles) GrantRole(role string, action interface{}, resourceType interface{}, effect Effect) {
+	roles.mu.Lock()
+	defer roles.mu.Unlock()
+	roles.grants[role] = append(roles.grants[role], roleGrant{
+		action:       action,
+		resourceType: reflect.TypeOf(resourceType),
+		effect:       effect,
+	})
+}
+
+// AddInheritance makes child inherit the grants of parent, eg. "editor" those of
+// "viewer": the grants of parent, and of the roles parent inherits from, apply to the
+// subjects holding child. AddInheritance returns ErrRoleCycle, and changes nothing, if
+// parent already inherits from child or is the same role.
+func (roles *Roles) AddInheritance(parent string, child string) error {
+	roles.mu.Lock()
+	defer roles.mu.Unlock()
+	if parent == child || roles.inherits(parent, child) {
+		return ErrRoleCycle
+	}
+	parents := roles.parents[child]
+	i := sort.SearchStrings(parents, parent)
+	if i < len(parents) && parents[i] == parent {
+		return nil
+	}
+	if roles.parents == nil {
+		roles.parents = make(map[string][]string)
+	}
+	parents = append(parents, "")
+	copy(parents[i+1:], parents[i:])
+	parents[i] = parent
+	roles.parents[child] = parents
+	roles.buildAncestors()
+	return nil
+}
+
+// RemoveInheritance undoes AddInheritance: child no longer inherits directly from
+// parent.
+func (roles *Roles) RemoveInheritance(parent string, child string) {
+	roles.mu.Lock()
+	defer roles.mu.Unlock()
+	parents := roles.parents[child]
+	i := sort.SearchStrings(parents, parent)
+	if i == len(parents) || parents[i] != parent {
+		return
+	}
+	parents = append(parents[:i:i], parents[i+1:]...)
+	if len(parents) == 0 {
+		delete(roles.parents, child)
+	} else {
+		roles.parents[child] = parents
+	}
+	roles.buildAncestors()
+}
+
+// InheritedRoles returns the roles role inherits from, transitively, the closest
+// first and the roles at the same distance in name order.
+func (roles *Roles) InheritedRoles(role string) []string {
+	roles.mu.RLock()
+	defer roles.mu.RUnlock()
+	return append([]string(nil), roles.ancestors[role]...)
+}
+
+// inherits reports whether child inherits from parent, transitively.
+func (roles *Roles) inherits(child string, parent string) bool {
+	for _, ancestor := range roles.ancestors[child] {
+		if ancestor == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAncestors rebuilds the closure of parents, breadth first so that the closest
+// roles come first.
+func (roles *Roles) buildAncestors() {
+	roles.ancestors = make(map[string][]string, len(roles.parents))
+	for role := range roles.parents {
+		seen := map[string]bool{role: true}
+		var ancestors []string
+		for queue := []string{role}; len(queue) > 0; queue = queue[1:] {
+			for _, parent := range roles.parents[queue[0]] {
+				if !seen[parent] {
+					seen[parent] = true
+					ancestors = append(ancestors, parent)
+					queue = append(queue, parent)
+				}
+			}
+		}
+		roles.ancestors[role] = ancestors
+	}
+}
+
+func (roles *Roles) subjectKey(subject interface{}) (string, bool) {
+	if roles.SubjectKey != nil {
+		return roles.SubjectKey(subject)
+	}
+	key, ok := subject.(string)
+	return key, ok
+}
+
+// roleEffect is an effect granted through a role.
+type roleEffect struct {
+	role   string
+	effect Effect
+}
+
+// effects returns the effects granted to subject for the (action, resource) pair, in
+// role name order and then in grant order. The grants of the roles inherited by each
+// role follow its own ones, in the order of InheritedRoles, and the roles inherited
+// more than once are only considered the first time.
+func (roles *Roles) effects(subject interface{}, action interface{}, resource interface{}) []roleEffect {
+	key, ok := roles.subjectKey(subject)
+	if !ok {
+		return nil
+	}
+	return roles.effectsOf(key, action, resource)
+}
+
+// effectsOf is like effects, for the subject with key.
+func (roles *Roles) effectsOf(key string, action interface{}, resource interface{}) []roleEffect {
+	roles.mu.RLock()
+	defer roles.mu.RUnlock()
+	var effects []roleEffect
+	resourceType := reflect.TypeOf(resource)
+	granted := func(role string) {
+		for _, grant := range roles.grants[role] {
+			if grant.action != nil && !sameValue(grant.action, action) {
+				continue
+			}
+			if grant.resourceType != nil && grant.resourceType != resourceType {
+				continue
+			}
+			effects = append(effects, roleEffect{role: role, effect: grant.effect})
+		}
+	}
+	if len(roles.ancestors) == 0 {
+		for _, role := range roles.rolesOf(key) {
+			granted(role)
+		}
+		return effects
+	}
+	seen := make(map[string]bool)
+	visit := func(role string) {
+		if !seen[role] {
+			seen[role] = true
+			granted(role)
+		}
+	}
+	for _, role := range roles.rolesOf(key) {
+		visit(role)
+		for _, ancestor := range roles.ancestors[role] {
+			visit(ancestor)
+		}
+	}
+	return effects
+}
+
+// sameValue compares two values without panicking on non comparable types.
+func sameValue(a interface{}, b interface{}) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if ta != nil && !ta.Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+// combineEffects returns the index of the effect winning according to strategy,
+// or -1 if effects is empty.
+func combineEffects(strategy CombiningStrategy, effects []Effect) int {
+	if len(effects) == 0 {
+		return -1
+	}
+	switch strategy {
+	case FirstApplicable:
+		return 0
+	case DenyOverrides, AllowOverrides:
+		overriding := Deny
+		if strategy == AllowOverrides {
+			overriding = Allow
+		}
+		for i, effect := range effects {
+			if effect == overriding {
+				return i
+			}
+		}
+		return 0
+	default:
+		return len(effects) - 1
+	}
+}
+
+// roleDecision consults the RBAC layer, returning a zero Decision if no grant applies.
+// The effects granted by several roles are combined with the combining strategy of
+// the evaluation.
+func (ev *evaluation) roleDecision() Decision {
+	roles := ev.ruleSet.Roles
+	if roles == nil {
+		return Decision{}
+	}
+	var granted []roleEffect
+	if bound := ev.bound; bound != nil && bound.roles == roles {
+		if bound.rolesKeyed {
+			granted = roles.effectsOf(bound.rolesKey, ev.action, ev.resource)
+		}
+	} else {
+		granted = roles.effects(ev.subject, ev.action, ev.resource)
+	}
+	effects := make([]Effect, len(granted))
+	for i, g := range granted {
+		effects[i] = g.effect
+	}
+	winner := combineEffects(ev.combining, effects)
+	if winner < 0 {
+		return Decision{}
+	}
+	return Decision{
+		Effect: granted[winner].effect,
+		Role:   granted[winner].role,
+	}
+}
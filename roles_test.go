@@ -0,0 +1,191 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func userKey(subject interface{}) (string, bool) {
+	user, ok := subject.(*User)
+	if !ok {
+		return "", false
+	}
+	return user.Name, true
+}
+
+func TestRoles(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	// owners may modify their playlists, otherwise the rule has no opinion
+	owner := rs.AddRule(&User{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+		})
+	rs.Roles = NewRoles()
+	rs.Roles.SubjectKey = userKey
+	rs.Roles.GrantRole("editor", "modify", &Playlist{}, ALLOW)
+	rs.Roles.GrantRole("viewer", "view", nil, ALLOW)
+
+	john := &User{Name: "john"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john"}
+	jackPlaylist := &Playlist{ID: "9374", User: "jack"}
+
+	if d := rs.QueryExplain(john, "modify", jackPlaylist); !d.Default {
+		t.Errorf("got %+v want the default effect without roles", d)
+	}
+
+	rs.Roles.AssignRole("john", "editor")
+	rs.Roles.AssignRole("john", "viewer")
+	if got, want := rs.Roles.RolesOf("john"), []string{"editor", "viewer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got roles %v want %v", got, want)
+	}
+	d := rs.QueryExplain(john, "modify", jackPlaylist)
+	if d.Effect != ALLOW || d.Role != "editor" || d.Rule != nil {
+		t.Errorf("got %+v want an allow through the editor role", d)
+	}
+	// direct rules come first
+	d = rs.QueryExplain(john, "modify", johnPlaylist)
+	if d.Effect != ALLOW || d.Role != "" || d.Rule == nil || d.Rule.ID != owner {
+		t.Errorf("got %+v want an allow through the owner rule", d)
+	}
+	if got := rs.Query(john, "view", &Archive{}); got != ALLOW {
+		t.Errorf("got %q want %q through the viewer role", got, ALLOW)
+	}
+	if got := rs.Query(john, "modify", &Video{}); got != DENY {
+		t.Errorf("got %q want %q: editors may only modify playlists", got, DENY)
+	}
+	if got := rs.Query(&User{Name: "jack"}, "modify", johnPlaylist); got != DENY {
+		t.Errorf("got %q want %q for a subject without roles", got, DENY)
+	}
+
+	rs.Roles.RevokeRole("john", "editor")
+	if got := rs.Query(john, "modify", jackPlaylist); got != DENY {
+		t.Errorf("got %q want %q after revoking the role", got, DENY)
+	}
+	if got, want := rs.Roles.RolesOf("john"), []string{"viewer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got roles %v want %v", got, want)
+	}
+}
+
+func TestRolesCombining(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Roles = NewRoles()
+	rs.Roles.GrantRole("auditor", "view", nil, "log")
+	rs.Roles.GrantRole("banned", nil, nil, DENY)
+	rs.Roles.GrantRole("reader", "view", nil, ALLOW)
+	rs.Roles.AssignRole("mike", "auditor")
+	rs.Roles.AssignRole("mike", "banned")
+	rs.Roles.AssignRole("mike", "reader")
+
+	cases := []struct {
+		strategy CombiningStrategy
+		want     string
+		role     string
+	}{
+		{LastApplicable, ALLOW, "reader"},
+		{FirstApplicable, "log", "auditor"},
+		{DenyOverrides, DENY, "banned"},
+		{AllowOverrides, ALLOW, "reader"},
+	}
+	for _, c := range cases {
+		rs.Combining = c.strategy
+		d := rs.QueryExplain("mike", "view", "report")
+		if d.Effect != c.want || d.Role != c.role {
+			t.Errorf("%v: got %+v want %q through %q", c.strategy, d, c.want, c.role)
+		}
+	}
+}
+
+func TestRoleInheritance(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Roles = NewRoles()
+	rs.Roles.GrantRole("viewer", "view", nil, ALLOW)
+	rs.Roles.GrantRole("editor", "modify", nil, ALLOW)
+	rs.Roles.GrantRole("admin", "delete", nil, ALLOW)
+	if err := rs.Roles.AddInheritance("viewer", "editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Roles.AddInheritance("editor", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	rs.Roles.AssignRole("mike", "admin")
+	rs.Roles.AssignRole("jane", "editor")
+
+	cases := []struct {
+		subject, action, want, role string
+	}{
+		{"mike", "view", ALLOW, "viewer"},
+		{"mike", "modify", ALLOW, "editor"},
+		{"mike", "delete", ALLOW, "admin"},
+		{"jane", "view", ALLOW, "viewer"},
+		{"jane", "delete", DENY, ""},
+	}
+	for _, c := range cases {
+		if d := rs.QueryExplain(c.subject, c.action, "report"); d.Effect != c.want || d.Role != c.role {
+			t.Errorf("%s %s: got %+v want %q through %q", c.subject, c.action, d, c.want, c.role)
+		}
+	}
+	if got, want := rs.Roles.InheritedRoles("admin"), []string{"editor", "viewer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got inherited roles %v want %v", got, want)
+	}
+
+	// the closure follows the changes of the hierarchy
+	rs.Roles.RemoveInheritance("viewer", "editor")
+	if got := rs.Query("mike", "view", "report"); got != DENY {
+		t.Errorf("got %q want %q after removing the inheritance", got, DENY)
+	}
+	if got, want := rs.Roles.InheritedRoles("admin"), []string{"editor"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got inherited roles %v want %v", got, want)
+	}
+}
+
+func TestRoleInheritanceDiamond(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Roles = NewRoles()
+	rs.Combining = FirstApplicable
+	rs.Roles.GrantRole("member", "view", nil, "log")
+	rs.Roles.GrantRole("writer", "view", nil, ALLOW)
+	rs.Roles.GrantRole("reviewer", "view", nil, "audit")
+	for _, pair := range [][2]string{{"member", "writer"}, {"member", "reviewer"}, {"writer", "lead"}, {"reviewer", "lead"}} {
+		if err := rs.Roles.AddInheritance(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs.Roles.AssignRole("mike", "lead")
+
+	// the closest roles come first, in name order, and member only once
+	if got, want := rs.Roles.InheritedRoles("lead"), []string{"reviewer", "writer", "member"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got inherited roles %v want %v", got, want)
+	}
+	granted := rs.Roles.effects("mike", "view", "report")
+	var roles []string
+	for _, g := range granted {
+		roles = append(roles, g.role)
+	}
+	if want := []string{"reviewer", "writer", "member"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("got grants of %v want %v", roles, want)
+	}
+	if d := rs.QueryExplain("mike", "view", "report"); d.Effect != "audit" || d.Role != "reviewer" {
+		t.Errorf("got %+v want audit through reviewer", d)
+	}
+}
+
+func TestRoleInheritanceCycle(t *testing.T) {
+	roles := NewRoles()
+	roles.AddInheritance("viewer", "editor")
+	roles.AddInheritance("editor", "admin")
+	for _, pair := range [][2]string{{"admin", "viewer"}, {"editor", "viewer"}, {"admin", "admin"}} {
+		if err := roles.AddInheritance(pair[0], pair[1]); err != ErrRoleCycle {
+			t.Errorf("%s to %s: got %v want ErrRoleCycle", pair[0], pair[1], err)
+		}
+	}
+	if got := roles.InheritedRoles("viewer"); len(got) != 0 {
+		t.Errorf("got viewer inheriting from %v after the rejected cycles", got)
+	}
+	// adding an inheritance twice changes nothing
+	if err := roles.AddInheritance("viewer", "editor"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := roles.InheritedRoles("admin"), []string{"editor", "viewer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got inherited roles %v want %v", got, want)
+	}
+}
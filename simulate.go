@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// Triple is a query of a corpus, see Simulate.
+type Triple struct {
+	// Subject, Action and Resource are the values of the query, or their serialized
+	// forms, see TypedValue.
+	Subject  interface{}
+	Action   interface{}
+	Resource interface{}
+}
+
+// TypedValue is the serialized form of a value of a type registered in the
+// TypeRegistry of a rule set, like the subjects and the resources of the declarative
+// rules: the name of the type and the JSON encoding of the value.
+type TypedValue struct {
+	Type string          `json:"type"`
+	JSON json.RawMessage `json:"json,omitempty"`
+}
+
+// ErrUnknownType is returned when decoding a TypedValue of a type not registered.
+var ErrUnknownType = errors.New("perms: unknown type")
+
+// decode returns the value of the type registered under value.Type in registry.
+func (value TypedValue) decode(registry *TypeRegistry) (interface{}, error) {
+	t, ok := registry.Lookup(value.Type)
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if len(value.JSON) > 0 {
+		if err := json.Unmarshal(value.JSON, v.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	if ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// DecisionDelta is a query of a corpus decided differently by the two rule sets
+// compared by Simulate.
+type DecisionDelta struct {
+	// Index is the position of Triple in the corpus.
+	Index  int
+	Triple Triple
+	// Current and Candidate are the decisions of the two rule sets, with the rules
+	// producing their effects, and CurrentErr and CandidateErr their errors: those of
+	// the queries, see QueryDecision, or of the decoding of the TypedValues.
+	Current      Decision
+	CurrentErr   error
+	Candidate    Decision
+	CandidateErr error
+}
+
+// Simulate evaluates the queries of corpus with the current and the candidate rule
+// sets, eg. a policy change under review, and returns those decided differently: with
+// a different effect, or failing with only one of them. The TypedValues of the corpus
+// are decoded with the TypeRegistry of each rule set.
+//
+// The queries don't reach the audit hooks, the Instrumentation and the slog logger of
+// the rule sets, nor their caches and Stats.
+func Simulate(current *RuleSet, candidate *RuleSet, corpus []Triple) []DecisionDelta {
+	current, candidate = current.quietClone(), candidate.quietClone()
+	var deltas []DecisionDelta
+	for i, triple := range corpus {
+		delta := DecisionDelta{Index: i, Triple: triple}
+		delta.Current, delta.CurrentErr = current.simulate(triple)
+		delta.Candidate, delta.CandidateErr = candidate.simulate(triple)
+		if delta.Current.Effect != delta.Candidate.Effect || (delta.CurrentErr == nil) != (delta.CandidateErr == nil) {
+			deltas = append(deltas, delta)
+		}
+	}
+	return deltas
+}
+
+// quietClone returns a clone of the rule set without the hooks observing the queries.
+func (ruleSet *RuleSet) quietClone() *RuleSet {
+	clone := ruleSet.Clone()
+	clone.AuditFn, clone.AuditDecisionFn = nil, nil
+	clone.Instrumentation = nil
+	clone.slog = nil
+	clone.cache = nil
+	return clone
+}
+
+// simulate decides the query of triple, see Simulate.
+func (ruleSet *RuleSet) simulate(triple Triple) (Decision, error) {
+	values := [3]interface{}{triple.Subject, triple.Action, triple.Resource}
+	for i, value := range values {
+		if typed, ok := value.(TypedValue); ok {
+			decoded, err := typed.decode(ruleSet.types)
+			if err != nil {
+				return Decision{}, err
+			}
+			values[i] = decoded
+		}
+	}
+	return ruleSet.QueryDecision(context.Background(), values[0], values[1], values[2])
+}
+
+// Recorder records the queries of a rule set as the corpus of Simulate, eg. sampling
+// the production traffic:
+//
+//	recorder := perms.NewRecorder(rs, 10000)
+//	rs.AuditDecisionFn = recorder.Audit
+//
+// The subjects and the resources of a type registered in the TypeRegistry of the rule
+// set are recorded as TypedValues, the other Identifiable ones by their PermKey and the
+// rest as they are. A Recorder is safe for concurrent use.
+type Recorder struct {
+	ruleSet *RuleSet
+	max     int
+	mu      sync.Mutex
+	triples []Triple
+}
+
+// NewRecorder returns a recorder of the first max queries of ruleSet, or of all of them
+// if max is not positive.
+func NewRecorder(ruleSet *RuleSet, max int) *Recorder {
+	return &Recorder{ruleSet: ruleSet, max: max}
+}
+
+// Audit records the query of event, as the AuditDecisionFn of the rule set.
+func (recorder *Recorder) Audit(event AuditEvent) {
+	recorder.mu.Lock()
+	full := recorder.max > 0 && len(recorder.triples) >= recorder.max
+	recorder.mu.Unlock()
+	if full {
+		return
+	}
+	triple := Triple{
+		Subject:  recorder.encode(event.Subject),
+		Action:   event.Action,
+		Resource: recorder.encode(event.Resource),
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.max <= 0 || len(recorder.triples) < recorder.max {
+		recorder.triples = append(recorder.triples, triple)
+	}
+}
+
+// encode returns the recorded form of value.
+func (recorder *Recorder) encode(value interface{}) interface{} {
+	if name, ok := recorder.ruleSet.types.Name(reflect.TypeOf(value)); ok {
+		if data, err := json.Marshal(value); err == nil {
+			return TypedValue{Type: name, JSON: data}
+		}
+	}
+	if key, ok := permKey(value); ok {
+		return key
+	}
+	return value
+}
+
+// Triples returns the recorded queries, in order.
+func (recorder *Recorder) Triples() []Triple {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]Triple(nil), recorder.triples...)
+}
+
+// Reset drops the recorded queries.
+func (recorder *Recorder) Reset() {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.triples = nil
+}
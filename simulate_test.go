@@ -0,0 +1,95 @@
+package perms
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newSimulatedPolicies(t *testing.T) (current *RuleSet, candidate *RuleSet) {
+	t.Helper()
+	current = newPolicyRuleSet()
+	if err := current.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	// the candidate denies viewing the playlists of the editors to everyone
+	candidate = current.Clone()
+	if _, err := candidate.AddDeclarativeRule(DeclarativeRule{
+		ID:        "editors-hidden",
+		Subject:   "User",
+		Action:    "view",
+		Resource:  "Playlist",
+		Condition: `resource.Group == "editors"`,
+		Effect:    DENY,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return current, candidate
+}
+
+func TestSimulate(t *testing.T) {
+	current, candidate := newSimulatedPolicies(t)
+	john, jack := &User{Name: "john"}, &User{Name: "jack"}
+	corpus := []Triple{
+		{john, "view", &Playlist{ID: "1", User: "john", Group: "editors"}},
+		{john, "view", &Playlist{ID: "2", User: "jack", Group: "writers", Public: true}},
+		{jack, "view", &Playlist{ID: "3", User: "mary", Group: "editors", Public: true}},
+		{jack, "view", &Playlist{ID: "4", User: "mary", Group: "editors"}},
+		{john, "modify", &Playlist{ID: "1", User: "john", Group: "editors"}},
+		{TypedValue{Type: "User", JSON: []byte(`{"Name": "jack"}`)}, "view", TypedValue{Type: "Playlist", JSON: []byte(`{"User": "jack", "Group": "editors"}`)}},
+		{TypedValue{Type: "Customer"}, "view", "readme"},
+	}
+	deltas := Simulate(current, candidate, corpus)
+	var indexes []int
+	for _, delta := range deltas {
+		indexes = append(indexes, delta.Index)
+		if !reflect.DeepEqual(delta.Triple, corpus[delta.Index]) {
+			t.Errorf("got triple %v want %v", delta.Triple, corpus[delta.Index])
+		}
+		if delta.Current.Effect != ALLOW || delta.Candidate.Effect != DENY || delta.Current.Rule == nil || delta.Candidate.Rule == nil ||
+			delta.Candidate.Rule.ID != "editors-hidden" {
+			t.Errorf("%d: got %+v want allow flipped to deny by editors-hidden", delta.Index, delta)
+		}
+	}
+	// the unknown types fail with both rule sets
+	if !reflect.DeepEqual(indexes, []int{0, 2, 5}) {
+		t.Errorf("got deltas %v want 0, 2 and 5", indexes)
+	}
+	// jack owns the decoded playlist
+	if delta := deltas[2]; delta.Current.Rule.ID != "owner-view" {
+		t.Errorf("got %s want owner-view allowing", delta.Current.Rule.ID)
+	}
+
+	if deltas := Simulate(current, current, corpus); len(deltas) != 0 {
+		t.Errorf("got %v want no deltas", deltas)
+	}
+}
+
+func TestSimulateRecorded(t *testing.T) {
+	current, candidate := newSimulatedPolicies(t)
+	recorder := NewRecorder(current, 3)
+	current.AuditDecisionFn = recorder.Audit
+	john, jack := &User{Name: "john"}, &User{Name: "jack"}
+	current.Query(john, "view", &Playlist{ID: "1", User: "john", Group: "editors"})
+	current.Query(jack, "view", &Playlist{ID: "2", User: "jack", Group: "writers"})
+	current.Query("john", "view", "readme")
+	current.Query(jack, "view", &Playlist{ID: "3", User: "mary", Group: "editors", Public: true})
+
+	corpus := recorder.Triples()
+	if len(corpus) != 3 {
+		t.Fatalf("got %d triples want 3", len(corpus))
+	}
+	if subject, ok := corpus[0].Subject.(TypedValue); !ok || subject.Type != "User" || corpus[2].Subject != "john" {
+		t.Errorf("got %+v", corpus)
+	}
+	deltas := Simulate(current, candidate, corpus)
+	if len(deltas) != 1 || deltas[0].Index != 0 || deltas[0].Candidate.Effect != DENY {
+		t.Errorf("got deltas %+v want the first triple flipped", deltas)
+	}
+	// the simulated queries are not audited
+	recorder.Reset()
+	Simulate(current, candidate, corpus)
+	if len(recorder.Triples()) != 0 {
+		t.Errorf("got the simulated queries recorded")
+	}
+}
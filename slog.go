@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// slogLogger logs the decisions of a RuleSet, see SetSlogLogger.
+type slogLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+	// allows counts the Allow decisions, for the sampling
+	allows atomic.Uint64
+}
+
+// SetSlogLogger makes the rule set log a structured record per decision to logger, at
+// level, with the attributes:
+//
+//   - subject_key and resource_key: the PermKey of Identifiable values, the strings
+//     themselves, or else the type of the value, eg. "*main.User";
+//   - subject_type, action and resource_type;
+//   - effect, rule_id and rule_name: the effect and the rule producing it, if any
+//     (SuperuserRuleID and RevokedRuleID for the superusers and the revoked subjects);
+//   - duration: the time taken by the query;
+//   - default_used: whether the effect is the default one, no rule applying;
+//   - error, for the queries failing.
+//
+// To keep the volume manageable, SlogAllowSampling can limit the Allow decisions
+// logged. Pass a nil logger to disable the logging.
+func (ruleSet *RuleSet) SetSlogLogger(logger *slog.Logger, level slog.Level) {
+	if logger == nil {
+		ruleSet.slog = nil
+		return
+	}
+	ruleSet.slog = &slogLogger{logger: logger, level: level}
+}
+
+// logDecision logs the decision of a query started at start with the slog logger.
+func (ruleSet *RuleSet) logDecision(ctx context.Context, start time.Time, subject interface{}, action interface{}, resource interface{}, decision Decision, err error) {
+	l := ruleSet.slog
+	if !l.logger.Enabled(ctx, l.level) {
+		return
+	}
+	if n := ruleSet.SlogAllowSampling; n > 1 && decision.Effect == Allow && err == nil && (l.allows.Add(1)-1)%uint64(n) != 0 {
+		return
+	}
+	var ruleID RuleID
+	var ruleName string
+	switch {
+	case decision.Revoked:
+		ruleID = RevokedRuleID
+	case decision.Superuser:
+		ruleID = SuperuserRuleID
+	case decision.Rule != nil:
+		ruleID, ruleName = decision.Rule.ID, decision.Rule.Name
+	}
+	attrs := []slog.Attr{
+		slog.String("subject_key", slogKey(subject)),
+		slog.String("subject_type", fmt.Sprintf("%T", subject)),
+		slog.String("action", fmt.Sprint(action)),
+		slog.String("resource_key", slogKey(resource)),
+		slog.String("resource_type", fmt.Sprintf("%T", resource)),
+		slog.String("effect", decision.Effect),
+		slog.String("rule_id", string(ruleID)),
+		slog.String("rule_name", ruleName),
+		slog.Duration("duration", time.Since(start)),
+		slog.Bool("default_used", decision.Default),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	l.logger.LogAttrs(ctx, l.level, "perms: decision", attrs...)
+}
+
+// slogKey returns the key of value for the records of SetSlogLogger.
+func slogKey(value interface{}) string {
+	if key, ok := valueKey(value); ok {
+		return key
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,103 @@
+package perms
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+// recordHandler is a slog.Handler keeping the records.
+type recordHandler struct {
+	level   slog.Level
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(ctx context.Context, level slog.Level) bool { return level >= h.level }
+
+func (h *recordHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *recordHandler) WithGroup(name string) slog.Handler { return h }
+
+func recordAttrs(record slog.Record) map[string]slog.Value {
+	attrs := make(map[string]slog.Value)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	return attrs
+}
+
+func TestSlogLogger(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&Account{}, "view", &Video{}, effectMatcher(ALLOW), Named("viewers"))
+	handler := &recordHandler{level: slog.LevelInfo}
+	rs.SetSlogLogger(slog.New(handler), slog.LevelInfo)
+	account := &Account{ID: "42"}
+
+	rs.Query(account, "view", &Video{Name: "intro"})
+	rs.Query(account, "delete", "intro")
+	if len(handler.records) != 2 {
+		t.Fatalf("got %d records want 2", len(handler.records))
+	}
+	for i, want := range []map[string]interface{}{
+		{
+			"subject_key": "42", "subject_type": "*perms.Account", "action": "view",
+			"resource_key": "*perms.Video", "resource_type": "*perms.Video",
+			"effect": ALLOW, "rule_name": "viewers", "default_used": false,
+		},
+		{
+			"subject_key": "42", "subject_type": "*perms.Account", "action": "delete",
+			"resource_key": "intro", "resource_type": "string",
+			"effect": DENY, "rule_id": "", "rule_name": "", "default_used": true,
+		},
+	} {
+		record := handler.records[i]
+		if record.Level != slog.LevelInfo || record.Message != "perms: decision" {
+			t.Errorf("got record %v %q", record.Level, record.Message)
+		}
+		attrs := recordAttrs(record)
+		for key, value := range want {
+			if got := attrs[key].Any(); got != value {
+				t.Errorf("%d: got %s %v want %v", i, key, got, value)
+			}
+		}
+		if _, ok := attrs["duration"]; !ok || attrs["duration"].Kind() != slog.KindDuration {
+			t.Errorf("%d: got no duration", i)
+		}
+	}
+	if id := recordAttrs(handler.records[0])["rule_id"].String(); id == "" {
+		t.Errorf("got no rule id")
+	}
+
+	// one in 3 allow decisions is logged, all the others
+	handler.records = nil
+	rs.SlogAllowSampling = 3
+	for i := 0; i < 6; i++ {
+		rs.Query(account, "view", &Video{})
+		rs.Query(account, "delete", &Video{})
+	}
+	allows := 0
+	for _, record := range handler.records {
+		if recordAttrs(record)["effect"].String() == ALLOW {
+			allows++
+		}
+	}
+	if allows != 2 || len(handler.records) != 8 {
+		t.Errorf("got %d records, %d allows want 8 and 2", len(handler.records), allows)
+	}
+
+	// nothing is logged below the level of the handler, or without a logger
+	handler.records = nil
+	rs.SetSlogLogger(slog.New(handler), slog.LevelDebug)
+	rs.Query(account, "delete", "intro")
+	rs.SetSlogLogger(nil, slog.LevelInfo)
+	rs.Query(account, "delete", "intro")
+	if len(handler.records) != 0 {
+		t.Errorf("got %d records want none", len(handler.records))
+	}
+}
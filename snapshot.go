@@ -0,0 +1,485 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ruleTable is a version of the rules of a RuleSet. Once published it is never
+// modified: adding or removing rules builds a new version sharing the unchanged maps
+// and lists, so that queries read the rules without locking.
+type ruleTable struct {
+	m3rules map[typ]map[typ]map[typ]RuleList
+	// interfaces holds the interface types used as keys in m3rules, by position
+	interfaces [3]interfaceKeys
+	// indexes holds the indexes of the longer RuleLists, by types triple
+	indexes map[[3]typ]*valueIndex
+	// namespaces holds the rules of the namespaces, see RuleSet.Namespace
+	namespaces map[string]*ruleTable
+	// actions holds the relations between the actions, see ImplyAction
+	actions *actionGraph
+	// grants holds the temporary grants, see Grant
+	grants map[grantKey]grant
+	// revoked holds the revoked subjects and the end of their revocations, see
+	// RevokeSubject
+	revoked map[string]time.Time
+	// defaults holds the default effects by resource type, see SetDefaultEffectForType
+	defaults map[reflect.Type]Effect
+	// size is the number of rules
+	size int
+	// generation is the number of versions published before this one, see Generation
+	generation uint64
+}
+
+// ruleStore holds the current version of the rule table of a RuleSet.
+type ruleStore struct {
+	// mu serializes the writers, and guards the RuleSet byID, lastID and lastSeq
+	mu    sync.Mutex
+	table atomic.Pointer[ruleTable]
+	// tails holds, by namespace and types triple, the last RuleList grown by appending
+	// a rule in the spare capacity of its array: no version of the list uses that
+	// capacity, so the next rule can be appended in place too
+	tails map[string]map[[3]typ]RuleList
+}
+
+func newRuleStore(table *ruleTable) *ruleStore {
+	store := &ruleStore{tails: make(map[string]map[[3]typ]RuleList)}
+	store.table.Store(table)
+	return store
+}
+
+// current returns the current version of the rule table.
+func (ruleSet *RuleSet) current() *ruleTable {
+	return ruleSet.rules.table.Load()
+}
+
+// update calls fn with a writer of a new version of the rule table, holding the writer
+// lock, then publishes the new version.
+func (ruleSet *RuleSet) update(fn func(w *tableWriter)) {
+	ruleSet.updateIn("", fn)
+}
+
+// updateIn is like update, for the rules of the namespace.
+func (ruleSet *RuleSet) updateIn(namespace string, fn func(w *tableWriter)) {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	root := ruleSet.current()
+	base := root
+	if namespace != "" {
+		if base = root.namespaces[namespace]; base == nil {
+			base = &ruleTable{}
+		}
+	}
+	w := newTableWriter(ruleSet, namespace, base)
+	fn(w)
+	w.reindex()
+	table := w.table
+	if namespace != "" {
+		ns := w.table
+		if ns.size == 0 {
+			// a namespace exists as long as it has rules
+			ns = nil
+			delete(ruleSet.rules.tails, namespace)
+		}
+		table = root.withNamespace(namespace, ns)
+	}
+	ruleSet.publish(root, table)
+}
+
+// publish makes table, built from root, the current version of the rule table. The
+// caller holds the writer lock.
+func (ruleSet *RuleSet) publish(root *ruleTable, table *ruleTable) {
+	table.generation = root.generation + 1
+	ruleSet.rules.table.Store(table)
+	ruleSet.cache.purge()
+}
+
+// Generation returns the version of the rules and of the settings stored with them,
+// like the grants and the revocations, increased by every change: comparing the
+// generations tells whether the rule set changed meanwhile, eg. whether a reload by
+// WatchFile landed.
+func (ruleSet *RuleSet) Generation() uint64 {
+	return ruleSet.current().generation
+}
+
+// withNamespace returns a copy of the table with the rules of the namespace replaced
+// by ns, or removed if ns is nil.
+func (table *ruleTable) withNamespace(namespace string, ns *ruleTable) *ruleTable {
+	root := *table
+	root.namespaces = make(map[string]*ruleTable, len(table.namespaces)+1)
+	for name, other := range table.namespaces {
+		root.namespaces[name] = other
+	}
+	if ns != nil {
+		root.namespaces[namespace] = ns
+	} else {
+		delete(root.namespaces, namespace)
+	}
+	return &root
+}
+
+// addRules adds the rules, assigning automatically generated ids to those without one.
+// It returns ErrDuplicateRuleID, and adds no rule, if an id is already in use.
+func (ruleSet *RuleSet) addRules(rules ...*Rule) error {
+	return ruleSet.addRulesIn("", rules...)
+}
+
+// addRulesIn is like addRules, adding the rules to the namespace.
+func (ruleSet *RuleSet) addRulesIn(namespace string, rules ...*Rule) error {
+	var err error
+	ruleSet.updateIn(namespace, func(w *tableWriter) {
+		ids := make(map[RuleID]bool, len(rules))
+		for _, rule := range rules {
+			if rule.id == "" {
+				continue
+			}
+			if _, ok := ruleSet.byID[rule.id]; ok || ids[rule.id] {
+				err = ErrDuplicateRuleID
+				return
+			}
+			ids[rule.id] = true
+		}
+		if len(rules) > 1 {
+			w.indexOnce(rules)
+		}
+		for _, rule := range rules {
+			if rule.id == "" {
+				rule.id = ruleSet.nextRuleID()
+			}
+			w.add(rule)
+		}
+	})
+	return err
+}
+
+// hasRule reports whether a rule with the given id is present.
+func (ruleSet *RuleSet) hasRule(id RuleID) bool {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	_, ok := ruleSet.byID[id]
+	return ok
+}
+
+// tableWriter builds a new version of a rule table, copying the maps and lists of the
+// previous version the first time it modifies them.
+type tableWriter struct {
+	ruleSet   *RuleSet
+	namespace string
+	table     *ruleTable
+	aMaps     map[typ]bool
+	rMaps     map[[2]typ]bool
+	lists     map[[3]typ]bool
+	ifaces    [3]bool
+	// dirty holds the triples whose index must be rebuilt
+	dirty map[[3]typ]bool
+}
+
+func newTableWriter(ruleSet *RuleSet, namespace string, base *ruleTable) *tableWriter {
+	table := &ruleTable{
+		m3rules:    make(map[typ]map[typ]map[typ]RuleList, len(base.m3rules)+1),
+		interfaces: base.interfaces,
+		indexes:    make(map[[3]typ]*valueIndex, len(base.indexes)),
+		namespaces: base.namespaces,
+		actions:    base.actions,
+		grants:     base.grants,
+		revoked:    base.revoked,
+		defaults:   base.defaults,
+		size:       base.size,
+	}
+	for sT, aMap := range base.m3rules {
+		table.m3rules[sT] = aMap
+	}
+	for key, index := range base.indexes {
+		table.indexes[key] = index
+	}
+	return &tableWriter{
+		ruleSet:   ruleSet,
+		namespace: namespace,
+		table:     table,
+		aMaps:     make(map[typ]bool),
+		rMaps:     make(map[[2]typ]bool),
+		lists:     make(map[[3]typ]bool),
+		dirty:     make(map[[3]typ]bool),
+	}
+}
+
+// aMap returns the writable map of the rules for the subject type.
+func (w *tableWriter) aMap(sT typ) map[typ]map[typ]RuleList {
+	if !w.aMaps[sT] {
+		aMap := make(map[typ]map[typ]RuleList, len(w.table.m3rules[sT])+1)
+		for aT, rMap := range w.table.m3rules[sT] {
+			aMap[aT] = rMap
+		}
+		w.table.m3rules[sT] = aMap
+		w.aMaps[sT] = true
+	}
+	return w.table.m3rules[sT]
+}
+
+// rMap returns the writable map of the rules for the subject and action types.
+func (w *tableWriter) rMap(sT typ, aT typ) map[typ]RuleList {
+	aMap := w.aMap(sT)
+	if key := [2]typ{sT, aT}; !w.rMaps[key] {
+		rMap := make(map[typ]RuleList, len(aMap[aT])+1)
+		for rT, list := range aMap[aT] {
+			rMap[rT] = list
+		}
+		aMap[aT] = rMap
+		w.rMaps[key] = true
+	}
+	return aMap[aT]
+}
+
+// interfaceKeys returns the writable interface keys of the position.
+func (w *tableWriter) interfaceKeys(position int) *interfaceKeys {
+	if !w.ifaces[position] {
+		w.table.interfaces[position] = w.table.interfaces[position].clone()
+		w.ifaces[position] = true
+	}
+	return &w.table.interfaces[position]
+}
+
+// add appends the rule to the RuleList of its types triple, after the rules with the
+// same or higher priority.
+func (w *tableWriter) add(rule *Rule) {
+	ruleSet := w.ruleSet
+	rule.namespace = w.namespace
+	if rule.counters == nil || rule.counters.stats != ruleSet.stats {
+		rule.counters = &ruleCounters{stats: ruleSet.stats}
+	}
+	ruleSet.byID[rule.id] = rule
+	ruleSet.lastSeq++
+	rule.seq = ruleSet.lastSeq
+
+	for position, t := range rule.types() {
+		if t != nil && t.Kind() == reflect.Interface {
+			w.interfaceKeys(position).add(t)
+		}
+	}
+	rMap := w.rMap(rule.sT, rule.aT)
+	key := rule.types()
+	list := rMap[rule.rT]
+	last := len(list) == 0 || list[len(list)-1].priority >= rule.priority
+	tails := w.tails()
+	if !w.lists[key] && last && !sameArray(list, tails[key]) {
+		// grow geometrically, so that appending many rules copies the list a
+		// logarithmic number of times
+		list = append(make(RuleList, 0, 2*len(list)+1), list...)
+	}
+	// inserting before the last rule copies the list
+	w.lists[key] = true
+	list = insertByPriority(list, rule)
+	rMap[rule.rT] = list
+	w.table.size++
+
+	if !last {
+		w.dirty[key] = true
+		delete(tails, key)
+		return
+	}
+	tails[key] = list
+	if w.dirty[key] {
+		return
+	}
+	if index := w.table.indexes[key].appended(list); index != nil {
+		w.table.indexes[key] = index
+	}
+}
+
+// tails returns the tails of the RuleLists of the namespace, see ruleStore.
+func (w *tableWriter) tails() map[[3]typ]RuleList {
+	tails, ok := w.ruleSet.rules.tails[w.namespace]
+	if !ok {
+		tails = make(map[[3]typ]RuleList)
+		w.ruleSet.rules.tails[w.namespace] = tails
+	}
+	return tails
+}
+
+// sameArray reports whether a and b are the same slice of the same array.
+func sameArray(a RuleList, b RuleList) bool {
+	return len(a) > 0 && len(a) == len(b) && cap(a) == cap(b) && &a[0] == &b[0]
+}
+
+// indexOnce makes reindex build the indexes of the RuleLists growing at least by half
+// with the rules, instead of updating them at each add.
+func (w *tableWriter) indexOnce(rules []*Rule) {
+	added := make(map[[3]typ]int)
+	for _, rule := range rules {
+		added[rule.types()]++
+	}
+	for key, n := range added {
+		if n >= len(w.table.m3rules[key[0]][key[1]][key[2]]) {
+			w.dirty[key] = true
+		}
+	}
+}
+
+// reindex rebuilds the indexes of the RuleLists changed other than by appending.
+func (w *tableWriter) reindex() {
+	for key := range w.dirty {
+		list := w.table.m3rules[key[0]][key[1]][key[2]]
+		if index := newValueIndex(list); index != nil {
+			w.table.indexes[key] = index
+		} else {
+			delete(w.table.indexes, key)
+		}
+	}
+}
+
+// remove removes the rule from the RuleList of its types triple, preserving the order
+// of the remaining rules and dropping the maps that become empty.
+func (w *tableWriter) remove(rule *Rule) {
+	w.removeIf(rule.types(), func(candidate *Rule) bool {
+		return candidate == rule
+	})
+}
+
+// removeIf removes the rules of the RuleList of the types triple for which drop
+// returns true, like remove, returning their number.
+func (w *tableWriter) removeIf(key [3]typ, drop func(rule *Rule) bool) int {
+	sT, aT, rT := key[0], key[1], key[2]
+	list := w.table.m3rules[sT][aT][rT]
+	remaining := make(RuleList, 0, len(list))
+	for _, candidate := range list {
+		if !drop(candidate) {
+			remaining = append(remaining, candidate)
+			continue
+		}
+		delete(w.ruleSet.byID, candidate.id)
+		for position, t := range key {
+			if t != nil && t.Kind() == reflect.Interface {
+				w.interfaceKeys(position).remove(t)
+			}
+		}
+	}
+	removed := len(list) - len(remaining)
+	if removed == 0 {
+		return 0
+	}
+	rMap := w.rMap(sT, aT)
+	w.lists[key] = true
+	w.dirty[key] = true
+	delete(w.tails(), key)
+	w.table.size -= removed
+
+	if len(remaining) > 0 {
+		rMap[rT] = remaining
+		return removed
+	}
+	delete(rMap, rT)
+	if len(rMap) > 0 {
+		return removed
+	}
+	aMap := w.table.m3rules[sT]
+	delete(aMap, aT)
+	if len(aMap) > 0 {
+		return removed
+	}
+	delete(w.table.m3rules, sT)
+	return removed
+}
+
+// replace replaces the rule with changed, a copy with the same templates, in the same
+// position of its RuleList.
+func (w *tableWriter) replace(rule *Rule, changed *Rule) {
+	w.ruleSet.byID[rule.id] = changed
+	rMap := w.rMap(rule.sT, rule.aT)
+	list := append(RuleList(nil), rMap[rule.rT]...)
+	for i, candidate := range list {
+		if candidate == rule {
+			list[i] = changed
+		}
+	}
+	// the positions in the index are unchanged
+	key := rule.types()
+	w.lists[key] = true
+	delete(w.tails(), key)
+	rMap[rule.rT] = list
+}
+
+// allRules returns all the rules in insertion order.
+func (table *ruleTable) allRules() []*Rule {
+	rules := make([]*Rule, 0, table.size)
+	for _, aMap := range table.m3rules {
+		for _, rMap := range aMap {
+			for _, list := range rMap {
+				rules = append(rules, list...)
+			}
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].seq < rules[j].seq
+	})
+	return rules
+}
+
+// Snapshot is a read-only view of the rules of a RuleSet at a point in time: the
+// queries of a Snapshot are not affected by the rules added to or removed from the
+// RuleSet afterwards, so that a batch of related queries sees a consistent policy.
+// The other settings of the RuleSet, like the DefaultEffect and the Roles, are not
+// part of the snapshot, nor the revocations, see RevokeSubject. The cache enabled
+// with WithCache is only used as long as the rules of the snapshot are the current
+// ones.
+// A Snapshot is safe for concurrent use.
+type Snapshot struct {
+	ruleSet *RuleSet
+	table   *ruleTable
+}
+
+// Snapshot returns a view of the current rules of the rule set.
+//
+// Queries never take a lock: the rules are kept in an immutable structure, replaced as a
+// whole by AddRule, RemoveRule and the other methods changing the rules, so taking a
+// snapshot costs nothing and a snapshot can be held for as long as needed. Queries can
+// also run concurrently with the methods changing the rules.
+func (ruleSet *RuleSet) Snapshot() *Snapshot {
+	return &Snapshot{ruleSet: ruleSet, table: ruleSet.current()}
+}
+
+// Query is like RuleSet.Query, for the rules of the snapshot.
+func (snapshot *Snapshot) Query(subject interface{}, action interface{}, resource interface{}) string {
+	return snapshot.QueryExplain(subject, action, resource).Effect
+}
+
+// IsAllowed is like RuleSet.IsAllowed, for the rules of the snapshot.
+func (snapshot *Snapshot) IsAllowed(subject interface{}, action interface{}, resource interface{}) bool {
+	return snapshot.Query(subject, action, resource) == Allow
+}
+
+// QueryExplain is like RuleSet.QueryExplain, for the rules of the snapshot.
+func (snapshot *Snapshot) QueryExplain(subject interface{}, action interface{}, resource interface{}) Decision {
+	decision, _ := snapshot.QueryDecision(context.Background(), subject, action, resource)
+	return decision
+}
+
+// QueryCtx is like RuleSet.QueryCtx, for the rules of the snapshot.
+func (snapshot *Snapshot) QueryCtx(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (string, error) {
+	decision, err := snapshot.QueryDecision(ctx, subject, action, resource)
+	return decision.Effect, err
+}
+
+// QueryDecision is like RuleSet.QueryDecision, for the rules of the snapshot.
+func (snapshot *Snapshot) QueryDecision(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	return snapshot.ruleSet.evaluateWith(ctx, queryOptions{table: snapshot.table}, subject, action, resource)
+}
+
+// Rules is like RuleSet.Rules, for the rules of the snapshot.
+func (snapshot *Snapshot) Rules() []RuleInfo {
+	return snapshot.ruleSet.rulesOf(snapshot.table)
+}
+
+// Generation returns the generation of the rules of the snapshot, see
+// RuleSet.Generation.
+func (snapshot *Snapshot) Generation() uint64 {
+	return snapshot.table.generation
+}
@@ -0,0 +1,186 @@
+package perms
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john"}
+
+	snapshot := rs.Snapshot()
+	denyID := rs.AddRuleWithPriority(-10, &User{}, "view", &Playlist{}, effectMatcher(DENY))
+	if err := rs.RemoveRule("rule-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot keeps the rules it was taken with
+	if effect := snapshot.Query(john, "view", johnPlaylist); effect != ALLOW {
+		t.Errorf("got %q from the snapshot want %q", effect, ALLOW)
+	}
+	if !snapshot.IsAllowed(john, "modify", johnPlaylist) {
+		t.Errorf("got modify denied by the snapshot want the removed rule to apply")
+	}
+	if rules := snapshot.Rules(); len(rules) != 6 {
+		t.Errorf("got %d rules in the snapshot want 6", len(rules))
+	}
+	if effect := rs.Query(john, "view", johnPlaylist); effect != DENY {
+		t.Errorf("got %q from the rule set want %q", effect, DENY)
+	}
+	if d := rs.QueryExplain(john, "modify", johnPlaylist); !d.Default {
+		t.Errorf("got %+v want the default decision", d)
+	}
+
+	// the rule set changes don't affect the tables of the previous snapshots
+	rs.RemoveRule(denyID)
+	rs.PruneExpired()
+	if d := snapshot.QueryExplain(john, "view", johnPlaylist); d.Rule == nil || d.Rule.ID != "rule-1" || d.Rule.Index != 0 {
+		t.Errorf("got %+v want rule-1 at index 0", d.Rule)
+	}
+	if d := rs.Snapshot().QueryExplain(john, "view", johnPlaylist); d.Rule == nil || d.Rule.ID != "rule-1" {
+		t.Errorf("got %+v from a new snapshot want rule-1", d.Rule)
+	}
+}
+
+func TestSnapshotCache(t *testing.T) {
+	rs := NewRuleSet(DENY).WithCache(10, func(subject interface{}, action interface{}, resource interface{}) (string, bool) {
+		return "key", true
+	})
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	snapshot := rs.Snapshot()
+	rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	if effect := rs.Query(john, "view", video); effect != ALLOW {
+		t.Fatalf("got %q want %q", effect, ALLOW)
+	}
+	// the decision cached for the current rules is not used by an older snapshot
+	if effect := snapshot.Query(john, "view", video); effect != DENY {
+		t.Errorf("got %q from the snapshot want %q", effect, DENY)
+	}
+	if effect := rs.Query(john, "view", video); effect != ALLOW {
+		t.Errorf("got %q after the snapshot query want %q", effect, ALLOW)
+	}
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john"}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			id := rs.AddRuleWithPriority(10, &User{}, "view", &Playlist{}, effectMatcher(DENY))
+			if err := rs.RemoveRule(id); err != nil {
+				t.Error(err)
+			}
+		}
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// within a snapshot the two queries always see the same rules
+				snapshot := rs.Snapshot()
+				view := snapshot.Query(john, "view", johnPlaylist)
+				if again := snapshot.Query(john, "view", johnPlaylist); again != view {
+					t.Errorf("got %q then %q from the same snapshot", view, again)
+					return
+				}
+				rs.Query(john, "modify", johnPlaylist)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(rs.Rules()); n != 6 {
+		t.Errorf("got %d rules want 6", n)
+	}
+}
+
+// lockedRuleSet is the alternative to the snapshots: a rule set guarded by a mutex.
+type lockedRuleSet struct {
+	mu sync.RWMutex
+	rs *RuleSet
+}
+
+func (locked *lockedRuleSet) Query(subject interface{}, action interface{}, resource interface{}) string {
+	locked.mu.RLock()
+	defer locked.mu.RUnlock()
+	return locked.rs.Query(subject, action, resource)
+}
+
+func (locked *lockedRuleSet) update(fn func(rs *RuleSet)) {
+	locked.mu.Lock()
+	defer locked.mu.Unlock()
+	fn(locked.rs)
+}
+
+// benchmarkConcurrentQueries runs query from 32 goroutines while update adds and
+// removes a rule every 100µs.
+func benchmarkConcurrentQueries(b *testing.B, update func(fn func(rs *RuleSet)), query func() string) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				update(func(rs *RuleSet) {
+					rs.RemoveRule(rs.AddRule(&User{}, "edit", &Video{}, effectMatcher(ALLOW)))
+				})
+			}
+		}
+	}()
+
+	b.SetParallelism((32 + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if query() != ALLOW {
+				b.Error("unexpected effect")
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkConcurrentQueryMutex(b *testing.B) {
+	locked := &lockedRuleSet{rs: newVideoRuleSet()}
+	john := &User{Name: "john"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john"}
+	benchmarkConcurrentQueries(b, locked.update, func() string {
+		return locked.Query(john, "view", johnPlaylist)
+	})
+}
+
+func BenchmarkConcurrentQuerySnapshot(b *testing.B) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	johnPlaylist := &Playlist{ID: "6563", User: "john"}
+	benchmarkConcurrentQueries(b, func(fn func(rs *RuleSet)) { fn(rs) }, func() string {
+		return rs.Snapshot().Query(john, "view", johnPlaylist)
+	})
+}
@@ -0,0 +1,337 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+// Package sqladapter stores the declarative rules of go-perms rule sets in a SQL
+// database, see perms.Adapter. The rules are kept in a table, perms_rules by default,
+// created by CreateTable:
+//
+//	CREATE TABLE IF NOT EXISTS perms_rules (
+//		id         TEXT PRIMARY KEY,  -- the id of the rule
+//		seq        BIGINT NOT NULL,   -- the insertion order
+//		subject    TEXT NOT NULL,     -- the type names and the action template,
+//		action     TEXT NOT NULL,     -- empty for a "jolly"
+//		resource   TEXT NOT NULL,
+//		conditions TEXT NOT NULL,     -- a JSON array of conditions
+//		condition  TEXT NOT NULL,     -- an expression, see perms.CompileExpression
+//		effect     TEXT NOT NULL,
+//		quick      BOOLEAN NOT NULL,
+//		priority   INTEGER NOT NULL,
+//		fields     TEXT NOT NULL DEFAULT '[]' -- a JSON array of field names
+//	)
+//
+// The columns are the fields of perms.DeclarativeRule. Rules are saved with an
+// INSERT ... ON CONFLICT upsert, supported by PostgreSQL and SQLite. The tables
+// created before the fields column are upgraded with:
+//
+//	ALTER TABLE perms_rules ADD COLUMN fields TEXT NOT NULL DEFAULT '[]'
+//
+//	adapter := sqladapter.New(db, nil)
+//	if err := rs.LoadFrom(ctx, adapter); err != nil {
+//		return err
+//	}
+//	go rs.SyncFrom(ctx, adapter, nil)
+package sqladapter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	perms "github.com/panta/go-perms"
+)
+
+var (
+	// ErrNotDeclarative is returned by Save for a spec without a declarative rule.
+	ErrNotDeclarative = errors.New("sqladapter: rule not declarative")
+	// ErrMissingID is returned by Save for a spec without id.
+	ErrMissingID = errors.New("sqladapter: rule without id")
+)
+
+// Options configures an Adapter. The zero value is for PostgreSQL.
+type Options struct {
+	// Table is the name of the table of the rules, perms_rules if empty.
+	Table string
+	// Placeholder returns the placeholder of the n-th parameter of a statement, from
+	// 1, Dollar if nil. SQLite accepts Question too.
+	Placeholder func(n int) string
+	// PollInterval is how often Watch reads the table, five seconds if zero.
+	PollInterval time.Duration
+	// OnError, if not nil, is called with the errors of Watch reading the table, which
+	// is read again at the next interval, and with those of the rows it skips.
+	OnError func(error)
+}
+
+// Dollar returns the PostgreSQL placeholder $n.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Question returns the placeholder ?.
+func Question(n int) string {
+	return "?"
+}
+
+// Adapter is a perms.WatchAdapter storing the declarative rules in a table. It is safe
+// for concurrent use.
+type Adapter struct {
+	db           *sql.DB
+	table        string
+	placeholder  func(n int) string
+	pollInterval time.Duration
+	onError      func(error)
+
+	// loaded holds the rows of the last LoadAll, from which Watch starts
+	mu     sync.Mutex
+	loaded map[perms.RuleID]row
+}
+
+var _ perms.WatchAdapter = (*Adapter)(nil)
+
+// New returns an adapter storing the rules in db. The options can be nil.
+func New(db *sql.DB, options *Options) *Adapter {
+	adapter := &Adapter{
+		db:           db,
+		table:        "perms_rules",
+		placeholder:  Dollar,
+		pollInterval: 5 * time.Second,
+	}
+	if options != nil {
+		if options.Table != "" {
+			adapter.table = options.Table
+		}
+		if options.Placeholder != nil {
+			adapter.placeholder = options.Placeholder
+		}
+		if options.PollInterval > 0 {
+			adapter.pollInterval = options.PollInterval
+		}
+		adapter.onError = options.OnError
+	}
+	return adapter
+}
+
+// CreateTable creates the table of the rules, unless it exists.
+func (adapter *Adapter) CreateTable(ctx context.Context) error {
+	_, err := adapter.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+adapter.table+` (
+	id         TEXT PRIMARY KEY,
+	seq        BIGINT NOT NULL,
+	subject    TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	resource   TEXT NOT NULL,
+	conditions TEXT NOT NULL,
+	condition  TEXT NOT NULL,
+	effect     TEXT NOT NULL,
+	quick      BOOLEAN NOT NULL,
+	priority   INTEGER NOT NULL,
+	fields     TEXT NOT NULL DEFAULT '[]'
+)`)
+	return err
+}
+
+// row is a row of the table.
+type row struct {
+	id         string
+	subject    string
+	action     string
+	resource   string
+	conditions string
+	condition  string
+	effect     string
+	quick      bool
+	priority   int
+	fields     string
+}
+
+func (r row) spec() (perms.RuleSpec, error) {
+	decl := &perms.DeclarativeRule{
+		ID:        perms.RuleID(r.id),
+		Subject:   r.subject,
+		Action:    r.action,
+		Resource:  r.resource,
+		Condition: r.condition,
+		Effect:    r.effect,
+		Quick:     r.quick,
+		Priority:  r.priority,
+	}
+	if err := json.Unmarshal([]byte(r.conditions), &decl.Conditions); err != nil {
+		return perms.RuleSpec{}, fmt.Errorf("sqladapter: rule %s: %v", r.id, err)
+	}
+	if err := json.Unmarshal([]byte(r.fields), &decl.Fields); err != nil {
+		return perms.RuleSpec{}, fmt.Errorf("sqladapter: rule %s: %v", r.id, err)
+	}
+	return perms.RuleSpec{ID: decl.ID, Declarative: decl}, nil
+}
+
+// LoadAll returns the declarative specs of the stored rules, in insertion order.
+func (adapter *Adapter) LoadAll(ctx context.Context) ([]perms.RuleSpec, error) {
+	rows, err := adapter.rows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]perms.RuleSpec, len(rows))
+	loaded := make(map[perms.RuleID]row, len(rows))
+	for i, r := range rows {
+		if specs[i], err = r.spec(); err != nil {
+			return nil, err
+		}
+		loaded[perms.RuleID(r.id)] = r
+	}
+	adapter.mu.Lock()
+	adapter.loaded = loaded
+	adapter.mu.Unlock()
+	return specs, nil
+}
+
+func (adapter *Adapter) rows(ctx context.Context) ([]row, error) {
+	rows, err := adapter.db.QueryContext(ctx, `SELECT id, subject, action, resource, conditions, condition, effect, quick, priority, fields FROM `+adapter.table+` ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.subject, &r.action, &r.resource, &r.conditions, &r.condition, &r.effect, &r.quick, &r.priority, &r.fields); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// Save stores the declarative rule of spec, under the id of the spec, or else of the
+// rule. A replaced rule keeps its position in the insertion order.
+func (adapter *Adapter) Save(ctx context.Context, spec perms.RuleSpec) error {
+	decl := spec.Declarative
+	if decl == nil {
+		return ErrNotDeclarative
+	}
+	id := spec.ID
+	if id == "" {
+		id = decl.ID
+	}
+	if id == "" {
+		return ErrMissingID
+	}
+	conditions := decl.Conditions
+	if conditions == nil {
+		conditions = []perms.Condition{}
+	}
+	encoded, err := json.Marshal(conditions)
+	if err != nil {
+		return err
+	}
+	fields := decl.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+	encodedFields, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	p := adapter.placeholder
+	_, err = adapter.db.ExecContext(ctx, `INSERT INTO `+adapter.table+` (id, seq, subject, action, resource, conditions, condition, effect, quick, priority, fields)
+VALUES (`+p(1)+`, (SELECT COALESCE(MAX(seq), 0) + 1 FROM `+adapter.table+`), `+p(2)+`, `+p(3)+`, `+p(4)+`, `+p(5)+`, `+p(6)+`, `+p(7)+`, `+p(8)+`, `+p(9)+`, `+p(10)+`)
+ON CONFLICT (id) DO UPDATE SET subject = excluded.subject, action = excluded.action, resource = excluded.resource,
+	conditions = excluded.conditions, condition = excluded.condition, effect = excluded.effect, quick = excluded.quick, priority = excluded.priority,
+	fields = excluded.fields`,
+		string(id), decl.Subject, decl.Action, decl.Resource, string(encoded), decl.Condition, decl.Effect, decl.Quick, decl.Priority, string(encodedFields))
+	return err
+}
+
+// Delete removes the stored rule with the given id. Removing a missing rule is not an
+// error.
+func (adapter *Adapter) Delete(ctx context.Context, id perms.RuleID) error {
+	_, err := adapter.db.ExecContext(ctx, `DELETE FROM `+adapter.table+` WHERE id = `+adapter.placeholder(1), string(id))
+	return err
+}
+
+// Watch reads the table every PollInterval, and reports the rules changed since the
+// last LoadAll, or since the call if LoadAll was never called: the saved rules in
+// insertion order, then the deleted ones.
+func (adapter *Adapter) Watch(ctx context.Context) (<-chan perms.Change, error) {
+	adapter.mu.Lock()
+	known := adapter.loaded
+	adapter.mu.Unlock()
+	if known == nil {
+		rows, err := adapter.rows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		known = make(map[perms.RuleID]row, len(rows))
+		for _, r := range rows {
+			known[perms.RuleID(r.id)] = r
+		}
+	}
+	changes := make(chan perms.Change)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(adapter.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rows, err := adapter.rows(ctx)
+			if err != nil {
+				if adapter.onError != nil && ctx.Err() == nil {
+					adapter.onError(err)
+				}
+				continue
+			}
+			diff, current := adapter.changesOf(known, rows)
+			for _, change := range diff {
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = current
+		}
+	}()
+	return changes, nil
+}
+
+// changesOf returns the changes from the known rows to rows, and rows by id. The
+// broken rows are skipped.
+func (adapter *Adapter) changesOf(known map[perms.RuleID]row, rows []row) ([]perms.Change, map[perms.RuleID]row) {
+	var changes []perms.Change
+	current := make(map[perms.RuleID]row, len(rows))
+	for _, r := range rows {
+		id := perms.RuleID(r.id)
+		current[id] = r
+		if previous, ok := known[id]; ok && previous == r {
+			continue
+		}
+		spec, err := r.spec()
+		if err != nil {
+			if adapter.onError != nil {
+				adapter.onError(err)
+			}
+			continue
+		}
+		changes = append(changes, perms.Change{Kind: perms.RuleSaved, ID: id, Spec: spec})
+	}
+	var deleted []string
+	for id := range known {
+		if _, ok := current[id]; !ok {
+			deleted = append(deleted, string(id))
+		}
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		changes = append(changes, perms.Change{Kind: perms.RuleDeleted, ID: perms.RuleID(id)})
+	}
+	return changes, current
+}
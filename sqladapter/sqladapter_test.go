@@ -0,0 +1,319 @@
+package sqladapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	perms "github.com/panta/go-perms"
+)
+
+// memoryDriver is a database/sql driver running the statements of the adapter over a
+// table in memory.
+type memoryDriver struct {
+	mu   sync.Mutex
+	rows map[string][]driver.Value
+	seq  int64
+}
+
+func (d *memoryDriver) Open(name string) (driver.Conn, error) {
+	return memoryConn{d}, nil
+}
+
+type memoryConn struct {
+	driver *memoryDriver
+}
+
+func (c memoryConn) Prepare(query string) (driver.Stmt, error) {
+	return memoryStmt{c.driver, query}, nil
+}
+
+func (c memoryConn) Close() error {
+	return nil
+}
+
+func (c memoryConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("no transactions")
+}
+
+type memoryStmt struct {
+	driver *memoryDriver
+	query  string
+}
+
+func (s memoryStmt) Close() error {
+	return nil
+}
+
+func (s memoryStmt) NumInput() int {
+	return -1
+}
+
+func (s memoryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.driver
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS perms_rules"):
+		if d.rows == nil {
+			d.rows = make(map[string][]driver.Value)
+		}
+	case strings.HasPrefix(s.query, "INSERT INTO perms_rules"):
+		id := args[0].(string)
+		seq := d.seq + 1
+		if previous, ok := d.rows[id]; ok {
+			seq = previous[0].(int64)
+		} else {
+			d.seq++
+		}
+		d.rows[id] = append([]driver.Value{seq}, args...)
+	case strings.HasPrefix(s.query, "DELETE FROM perms_rules WHERE id = ?"):
+		delete(d.rows, args[0].(string))
+	default:
+		return nil, errors.New("unexpected statement " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s memoryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.driver
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !strings.HasPrefix(s.query, "SELECT id, subject, action, resource, conditions, condition, effect, quick, priority, fields FROM perms_rules ORDER BY seq") {
+		return nil, errors.New("unexpected query " + s.query)
+	}
+	rows := &memoryRows{}
+	for _, values := range d.rows {
+		rows.values = append(rows.values, values)
+	}
+	sort.Slice(rows.values, func(i, j int) bool {
+		return rows.values[i][0].(int64) < rows.values[j][0].(int64)
+	})
+	return rows, nil
+}
+
+type memoryRows struct {
+	values [][]driver.Value
+}
+
+func (rows *memoryRows) Columns() []string {
+	return []string{"id", "subject", "action", "resource", "conditions", "condition", "effect", "quick", "priority", "fields"}
+}
+
+func (rows *memoryRows) Close() error {
+	return nil
+}
+
+func (rows *memoryRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	// without the seq
+	copy(dest, rows.values[0][1:])
+	rows.values = rows.values[1:]
+	return nil
+}
+
+var registerDriver sync.Once
+
+func newAdapter(t *testing.T) *Adapter {
+	t.Helper()
+	registerDriver.Do(func() {
+		sql.Register("perms-memory", &memoryDriver{})
+	})
+	db, err := sql.Open("perms-memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	adapter := New(db, &Options{Placeholder: Question, PollInterval: time.Millisecond})
+	ctx := context.Background()
+	if err := adapter.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the driver is shared by the tests
+	specs, err := adapter.LoadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if err := adapter.Delete(ctx, spec.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return adapter
+}
+
+type user struct {
+	Name string
+}
+
+type document struct {
+	Owner  string
+	Public bool
+}
+
+func newRuleSet() *perms.RuleSet {
+	rs := perms.NewRuleSet(perms.Deny)
+	rs.RegisterType("User", &user{})
+	rs.RegisterType("Document", &document{})
+	return rs
+}
+
+func declarative(id perms.RuleID, action string, condition string) perms.RuleSpec {
+	return perms.RuleSpec{Declarative: &perms.DeclarativeRule{
+		ID:         id,
+		Subject:    "User",
+		Action:     action,
+		Resource:   "Document",
+		Conditions: []perms.Condition{{Field: "resource.Owner", Ref: "subject.Name"}},
+		Condition:  condition,
+		Effect:     perms.Allow,
+	}}
+}
+
+func TestLoadFrom(t *testing.T) {
+	adapter := newAdapter(t)
+	ctx := context.Background()
+	for _, spec := range []perms.RuleSpec{
+		declarative("owner-view", "view", ""),
+		declarative("owner-edit", "edit", ""),
+		declarative("owner-view", "view", "resource.Public == true"),
+	} {
+		if err := adapter.Save(ctx, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := adapter.Save(ctx, perms.RuleSpec{ID: "go"}); err != ErrNotDeclarative {
+		t.Errorf("got %v want ErrNotDeclarative", err)
+	}
+	if err := adapter.Save(ctx, declarative("", "view", "")); err != ErrMissingID {
+		t.Errorf("got %v want ErrMissingID", err)
+	}
+
+	rs := newRuleSet()
+	if err := rs.LoadFrom(ctx, adapter); err != nil {
+		t.Fatal(err)
+	}
+	var ids []perms.RuleID
+	for _, info := range rs.Rules() {
+		ids = append(ids, info.ID)
+	}
+	if len(ids) != 2 || ids[0] != "owner-view" || ids[1] != "owner-edit" {
+		t.Errorf("got rules %v want the saved rules in order", ids)
+	}
+	john := &user{Name: "john"}
+	if rs.IsAllowed(john, "view", &document{Owner: "john"}) || !rs.IsAllowed(john, "view", &document{Owner: "john", Public: true}) {
+		t.Errorf("got the replaced rule loaded")
+	}
+	if !rs.IsAllowed(john, "edit", &document{Owner: "john"}) || rs.IsAllowed(john, "edit", &document{Owner: "jack"}) {
+		t.Errorf("got the wrong edit decisions")
+	}
+}
+
+func TestLoadFromFields(t *testing.T) {
+	adapter := newAdapter(t)
+	ctx := context.Background()
+	spec := declarative("owner-field", "view", "")
+	spec.Declarative.Fields = []string{"Public"}
+	if err := adapter.Save(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := newRuleSet()
+	if err := rs.LoadFrom(ctx, adapter); err != nil {
+		t.Fatal(err)
+	}
+	decls := rs.DeclarativeRules()
+	if len(decls) != 1 || len(decls[0].Fields) != 1 || decls[0].Fields[0] != "Public" {
+		t.Fatalf("got %+v want the field rule", decls)
+	}
+	// the field rule takes no part in the decision of the whole resource
+	john := &user{Name: "john"}
+	doc := &document{Owner: "john"}
+	if rs.IsAllowed(john, "view", doc) {
+		t.Errorf("got the field rule loaded as a rule of the whole resource")
+	}
+	fields, err := rs.QueryFields(john, "view", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["Public"] != perms.Allow || fields["Owner"] != perms.Deny {
+		t.Errorf("got %v want only Public allowed", fields)
+	}
+}
+
+func TestSyncFrom(t *testing.T) {
+	adapter := newAdapter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := adapter.Save(ctx, declarative("owner-view", "view", "")); err != nil {
+		t.Fatal(err)
+	}
+	rs := newRuleSet()
+	if err := rs.LoadFrom(ctx, adapter); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	done := make(chan error)
+	go func() {
+		done <- rs.SyncFrom(ctx, adapter, func(err error) { errs <- err })
+	}()
+	// saved between LoadFrom and the start of SyncFrom
+	if err := adapter.Save(ctx, declarative("owner-edit", "edit", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	john := &user{Name: "john"}
+	doc := &document{Owner: "john"}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("got no %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("added rule", func() bool { return rs.IsAllowed(john, "edit", doc) })
+	if err := adapter.Save(ctx, declarative("owner-view", "view", "resource.Public == true")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("replaced rule", func() bool { return !rs.IsAllowed(john, "view", doc) })
+	if err := adapter.Delete(ctx, "owner-edit"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("deleted rule", func() bool { return !rs.IsAllowed(john, "edit", doc) })
+	if n := len(rs.Rules()); n != 1 {
+		t.Errorf("got %d rules want 1", n)
+	}
+
+	// an invalid rule is reported and skipped
+	spec := declarative("broken", "view", "")
+	spec.Declarative.Resource = "Unknown"
+	if err := adapter.Save(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "Unknown") {
+			t.Errorf("got %v want an unknown type", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no error")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v want context.Canceled", err)
+	}
+}
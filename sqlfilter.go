@@ -0,0 +1,825 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SQLDialect describes the SQL flavor of the clauses compiled by CompileFilter.
+type SQLDialect struct {
+	// Placeholder returns the placeholder of the n-th argument, from 1, eg. "$1"
+	Placeholder func(n int) string
+	// Like is the case sensitive LIKE operator ("LIKE" if empty), used with an ESCAPE
+	// clause to compile startsWith, endsWith and contains; with Glob set they are
+	// compiled to GLOB patterns instead.
+	Like string
+	Glob bool
+}
+
+func questionMark(n int) string {
+	return "?"
+}
+
+var (
+	// PostgresDialect is the dialect of PostgreSQL, with $1, $2... placeholders.
+	PostgresDialect = SQLDialect{Placeholder: func(n int) string { return "$" + strconv.Itoa(n) }}
+	// MySQLDialect is the dialect of MySQL and MariaDB, matching the strings with LIKE
+	// BINARY, since LIKE follows the (usually case insensitive) collation.
+	MySQLDialect = SQLDialect{Placeholder: questionMark, Like: "LIKE BINARY"}
+	// SQLiteDialect is the dialect of SQLite, matching the strings with GLOB, since
+	// LIKE is case insensitive.
+	SQLiteDialect = SQLDialect{Placeholder: questionMark, Glob: true}
+)
+
+// ErrNotStructType is returned by CompileFilter for a resource type that is not a
+// struct or a pointer to a struct.
+var ErrNotStructType = errors.New("perms: resource type not a struct or a pointer to a struct")
+
+// CompileFilter compiles the rules applying to subject and action on the resources of
+// the type of resourceType, a struct or a pointer to one, into a SQL WHERE clause
+// selecting the rows subject is allowed to act on, with its arguments, eg. to filter
+// a listing in the database instead of with FilterAllowed:
+//
+//	where, args, complete, err := rs.CompileFilter(user, "view", &Playlist{}, perms.PostgresDialect)
+//	if err != nil {
+//		return err
+//	}
+//	rows, err := db.Query("SELECT * FROM playlists WHERE "+where, args...)
+//
+// The columns are named by the db tags of the fields, eg. `db:"user_name"`, or else are
+// the field names in lower case; the fields tagged "-" have no column. The rules are
+// compiled when they are declarative and their conditions compare the fields of the
+// resource holding strings, numbers or booleans with constants or with values of the
+// subject and the action, which are computed for the query and bound as arguments.
+// The comparisons, && || !, in with a list and startsWith, endsWith and contains with
+// a constant are supported on the fields of the resource.
+//
+// complete is false when the decision can't be told in SQL for some rows: when an
+// applicable rule is a Go matcher or has a condition which can't be compiled, like
+// those on the environment of the query (see QueryEnv), which is not known, or on the
+// attributes of an AttributeProvider, or when
+// the grants, the roles, the parents of the resources or the DefaultEffectFn may
+// decide. The clause then selects a superset of the allowed rows, to be filtered again
+// with IsAllowed or FilterAllowed.
+//
+// The clause assumes that the columns are NOT NULL and that the strings compare case
+// sensitively, as with a binary collation.
+func (ruleSet *RuleSet) CompileFilter(subject interface{}, action interface{}, resourceType interface{}, dialect SQLDialect) (whereClause string, args []interface{}, complete bool, err error) {
+	t := reflect.TypeOf(resourceType)
+	sample := resourceType
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if t.Kind() == reflect.Struct {
+			// the rules of the pointer types admit any non nil one
+			sample = reflect.New(t).Interface()
+		}
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", nil, false, ErrNotStructType
+	}
+
+	table := ruleSet.current()
+	if ruleSet.revokedSubject(table, subject, nil) {
+		effect := ruleSet.RevokedEffect
+		if effect == "" {
+			effect = Deny
+		}
+		return constantFilter(effect == Allow), nil, true, nil
+	}
+	if ruleSet.SuperuserFn != nil && ruleSet.isSuperuser(subject, nil) {
+		effect := ruleSet.SuperuserEffect
+		if effect == "" {
+			effect = Allow
+		}
+		return constantFilter(effect == Allow), nil, true, nil
+	}
+
+	f := &sqlFilter{
+		ev: &evaluation{
+			ruleSet:  ruleSet,
+			table:    table,
+			ctx:      context.Background(),
+			subject:  subject,
+			action:   action,
+			resource: sample,
+
+			maxEvaluations: ruleSet.MaxEvaluations,
+			combining:      ruleSet.Combining,
+		},
+		structType: t,
+		dialect:    dialect,
+	}
+	if canonical, ok := table.actions.canonical(action); ok {
+		f.ev.action = canonical
+		if !ruleSet.CanonicalActions {
+			f.ev.alias, f.ev.aliased = action, true
+		}
+	}
+
+	fallback := filterEntry{}
+	if f.granted() {
+		if !ruleSet.GrantsAfterRules {
+			return constantFilter(true), nil, false, nil
+		}
+		fallback.unknown = true
+	}
+	if _, ok := sample.(HasParent); ok || ruleSet.ParentOf != nil || ruleSet.Roles != nil || ruleSet.DefaultEffectFn != nil {
+		fallback.unknown = true
+	}
+	if !fallback.unknown {
+		effect, ok := table.defaultEffectFor(sample, ruleSet.NormalizePointers)
+		if !ok {
+			effect = ruleSet.DefaultEffect
+		}
+		fallback.allowed = effect == Allow
+	}
+
+	passes := f.candidates()
+	whereClause, args, complete = f.render(f.entries(passes), fallback)
+	return whereClause, args, complete, nil
+}
+
+func constantFilter(allowed bool) string {
+	if allowed {
+		return "1 = 1"
+	}
+	return "1 = 0"
+}
+
+// sqlFilter holds the state of a CompileFilter.
+type sqlFilter struct {
+	ev         *evaluation
+	structType reflect.Type
+	dialect    SQLDialect
+}
+
+// granted reports whether some valid grant may apply to the query.
+func (f *sqlFilter) granted() bool {
+	grants := f.ev.table.grants
+	if len(grants) == 0 {
+		return false
+	}
+	action, ok := f.ev.action.(string)
+	if !ok {
+		return false
+	}
+	subject, ok := valueKey(f.ev.subject)
+	if !ok {
+		return false
+	}
+	for key, g := range grants {
+		if key.subject == subject && key.action == action && g.validAt(f.ev.now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRule is a rule applying to a CompileFilter query, with its compiled condition
+// (nil if it can't be compiled).
+type filterRule struct {
+	cond     *sqlExpr
+	effect   Effect
+	quick    bool
+	priority int
+}
+
+// candidates returns the rules applying to the query, for each pass.
+func (f *sqlFilter) candidates() [][]filterRule {
+	ev := f.ev
+	mode := ev.ruleSet.templateMode()
+	subject, action, resource := ev.subjectValue(), ev.queryValue(1, ev.action), ev.queryValue(2, ev.resource)
+
+	var candidates []candidate
+	var passes [][]filterRule
+	seen := make(map[*Rule]bool)
+	for _, step := range ev.steps() {
+		tier := jollyTiers[step.pass]
+		s, a, r := subject.pick(tier[0]), step.action(action).pick(tier[1]), resource.pick(tier[2])
+		candidates = candidates[:0]
+		// the rules whose templates depend on the resource are taken, whether they
+		// admit the sample resource or not
+		matcherAction := a.value
+		if step.implying != nil || ev.aliased {
+			matcherAction = ev.action
+			if ev.aliased {
+				matcherAction = ev.alias
+			}
+		}
+		skipped := func(rule *Rule, index int) {
+			if f.rowTemplate(rule, &r, mode) && admitsForms(rule, 0, &s, mode) && admitsForms(rule, 1, &a, mode) {
+				candidates = append(candidates, candidate{rule: rule, index: index, subject: s.value, action: matcherAction})
+			}
+		}
+		ev.lookup(step, s, a, r, skipped, func(c candidate) bool {
+			candidates = append(candidates, c)
+			return true
+		})
+		var rules []filterRule
+		for _, c := range candidates {
+			rule := c.rule
+			if seen[rule] {
+				// found again in a later pass, eg. for a nil subject
+				continue
+			}
+			seen[rule] = true
+			if rule.matcher == nil || rule.disabled || rule.fieldMatcher != nil || (rule.expires() && !rule.validAt(ev.now())) {
+				continue
+			}
+			fr := filterRule{priority: rule.priority}
+			if rule.decl != nil && !f.rowTemplate(rule, &r, mode) {
+				fr.cond, fr.effect, fr.quick = f.compileRule(rule.decl, c.subject, c.action), rule.decl.Effect, rule.decl.Quick
+			}
+			rules = append(rules, fr)
+		}
+		passes = append(passes, rules)
+	}
+
+	if ev.ruleSet.FlatEvaluation {
+		var flat []filterRule
+		for _, rules := range passes {
+			flat = append(flat, rules...)
+		}
+		sort.SliceStable(flat, func(i, j int) bool {
+			return flat[i].priority > flat[j].priority
+		})
+		passes = [][]filterRule{flat}
+	}
+	if max := ev.maxEvaluations; max > 0 {
+		n := 0
+		for _, rules := range passes {
+			n += len(rules)
+		}
+		if n > max {
+			// the budget may be exceeded, failing the query
+			return [][]filterRule{{{}}}
+		}
+	}
+	return passes
+}
+
+// rowTemplate reports whether the template of the rule admits the resources
+// depending on their values, see Rule.admits.
+func (f *sqlFilter) rowTemplate(rule *Rule, resource *queryValue, mode templateMode) bool {
+	if pattern := rule.patterns[2]; pattern != nil {
+		_, iface := pattern.(interfaceTemplate)
+		return !iface
+	}
+	if resource.t == nil {
+		return false
+	}
+	return resource.literal || (mode.structFields && resource.t.Kind() == reflect.Struct) ||
+		(mode.deepValues && rule.deep[2] && !resource.t.Comparable())
+}
+
+// admitsForms is like Rule.admits, admitting the value or its counterpart.
+func admitsForms(rule *Rule, position int, q *queryValue, mode templateMode) bool {
+	template := rule.template(position)
+	return rule.admits(position, q, template, mode) || (q.counterpart != nil && rule.admits(position, q.counterpart, template, mode))
+}
+
+// filterEntry is an entry of the first-match list of the outcomes of a CompileFilter:
+// the rows for which cond holds, and the previous entries don't, are allowed or not,
+// or their decision is unknown.
+type filterEntry struct {
+	cond    sqlExpr
+	allowed bool
+	unknown bool
+}
+
+// entries returns the first-match list of the candidate rules, following the
+// combining strategy, up to the first rule whose condition is not compiled.
+func (f *sqlFilter) entries(passes [][]filterRule) []filterEntry {
+	var entries []filterEntry
+	add := func(rule filterRule) bool {
+		if rule.cond == nil {
+			entries = append(entries, filterEntry{unknown: true})
+			return false
+		}
+		entries = append(entries, filterEntry{cond: *rule.cond, allowed: rule.effect == Allow})
+		return true
+	}
+
+	switch combining := f.ev.combining; combining {
+	case DenyOverrides, AllowOverrides:
+		overriding := Deny
+		if combining == AllowOverrides {
+			overriding = Allow
+		}
+		var all []filterRule
+		for _, rules := range passes {
+			all = append(all, rules...)
+		}
+		// the effect of a quick rule stopping the evaluation is the first one produced,
+		// its own when the rules produce a single effect but the overriding one
+		var effects []Effect
+		for _, rule := range all {
+			if rule.cond != nil && rule.effect != overriding && (len(effects) == 0 || effects[0] != rule.effect) {
+				effects = append(effects, rule.effect)
+			}
+		}
+		quickDecides := len(effects) <= 1 && !f.ev.ruleSet.PerPassQuick
+		// first the rules ending the evaluation, then the first one matching
+		for _, rule := range all {
+			if rule.cond != nil && rule.effect != overriding && rule.quick && !quickDecides {
+				rule.cond = nil
+			}
+			if (rule.cond == nil || rule.effect == overriding || rule.quick) && !add(rule) {
+				return entries
+			}
+		}
+		for _, rule := range all {
+			add(rule)
+		}
+	case FirstApplicable:
+		for _, rules := range passes {
+			for _, rule := range rules {
+				if !add(rule) {
+					return entries
+				}
+			}
+		}
+	default:
+		// in a pass the first quick rule matching decides, otherwise the last one
+		for _, rules := range passes {
+			for _, rule := range rules {
+				if (rule.cond == nil || rule.quick) && !add(rule) {
+					return entries
+				}
+			}
+			for i := len(rules) - 1; i >= 0; i-- {
+				if !rules[i].quick {
+					add(rules[i])
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// render returns the clause of the first-match list of entries, ending with fallback,
+// and whether it is complete.
+func (f *sqlFilter) render(entries []filterEntry, fallback filterEntry) (string, []interface{}, bool) {
+	var list []filterEntry
+	for _, entry := range entries {
+		if entry.unknown {
+			fallback = entry
+			break
+		}
+		if entry.cond.constant {
+			if entry.cond.value == true {
+				fallback = entry
+				break
+			}
+			continue
+		}
+		if n := len(list); n > 0 && list[n-1].allowed == entry.allowed {
+			list[n-1].cond = sqlJoin("OR", precOr, list[n-1].cond, entry.cond)
+			continue
+		}
+		list = append(list, entry)
+	}
+	complete := !fallback.unknown
+	allowed := fallback.allowed || fallback.unknown
+	for len(list) > 0 && list[len(list)-1].allowed == allowed {
+		list = list[:len(list)-1]
+	}
+
+	var fragment sqlFragment
+	switch {
+	case len(list) == 0:
+		fragment = sqlFragment{constantFilter(allowed)}
+	case len(list) == 1 && list[0].allowed:
+		fragment = list[0].cond.sql
+	case len(list) == 1:
+		fragment = sqlNot(list[0].cond).sql
+	default:
+		fragment = sqlFragment{"CASE"}
+		for _, entry := range list {
+			fragment = append(fragment, " WHEN ")
+			fragment = append(fragment, entry.cond.sql...)
+			fragment = append(fragment, " THEN "+sqlBit(entry.allowed))
+		}
+		fragment = append(fragment, " ELSE "+sqlBit(allowed)+" END = 1")
+	}
+
+	var sb strings.Builder
+	var args []interface{}
+	for _, part := range fragment {
+		switch part := part.(type) {
+		case string:
+			sb.WriteString(part)
+		case sqlArg:
+			args = append(args, part.value)
+			sb.WriteString(f.dialect.Placeholder(len(args)))
+		}
+	}
+	return sb.String(), args, complete
+}
+
+func sqlBit(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// compileRule compiles the conditions of a declarative rule for the subject and the
+// action passed to its matcher, returning nil if they can't be compiled.
+func (f *sqlFilter) compileRule(decl *DeclarativeRule, subject interface{}, action interface{}) *sqlExpr {
+	scope := &exprScope{roots: [...]interface{}{subject, action, nil, nil}}
+	result := sqlExpr{constant: true, value: true}
+	and := func(node exprNode) bool {
+		x, ok := f.compile(node, scope)
+		if !ok || !x.isBool() {
+			return false
+		}
+		result = sqlAnd(result, x)
+		return true
+	}
+	for _, cond := range decl.Conditions {
+		if result.constant && result.value == false {
+			// the conditions are tested in order, up to the first not holding
+			return &result
+		}
+		field, err := parseFieldPath(cond.Field)
+		if err != nil {
+			return nil
+		}
+		condition := compiledCondition{field: field, value: reflect.ValueOf(cond.Value)}
+		var operand exprNode = &exprLiteral{exprValue(condition.value)}
+		if cond.Ref != "" {
+			ref, err := parseFieldPath(cond.Ref)
+			if err != nil {
+				return nil
+			}
+			condition.ref, operand = &ref, &exprPath{path: ref}
+		}
+		if field.root == rootEnv || (condition.ref != nil && condition.ref.root == rootEnv) {
+			return nil
+		}
+		if field.root != rootResource && (condition.ref == nil || condition.ref.root != rootResource) {
+			result = sqlAnd(result, sqlExpr{constant: true, value: condition.holds(&scope.roots)})
+			continue
+		}
+		if !and(&exprBinary{op: "==", x: &exprPath{path: field}, y: operand}) {
+			return nil
+		}
+	}
+	if decl.Condition != "" && !(result.constant && result.value == false) {
+		expr, err := CompileExpression(decl.Condition)
+		if err != nil || usesRoot(expr.root, rootEnv) || !and(expr.root) {
+			return nil
+		}
+	}
+	result = result.predicate()
+	return &result
+}
+
+// sqlFragment is a piece of SQL: strings and the arguments of the placeholders.
+type sqlFragment []interface{}
+
+type sqlArg struct {
+	value interface{}
+}
+
+// the precedences of the SQL expressions, from the highest
+const (
+	precAtom = iota
+	precAnd
+	precOr
+)
+
+// sqlExpr is a compiled (sub)expression: a constant, computed from the subject
+// and the action, or else a SQL expression over the columns of the resource.
+type sqlExpr struct {
+	constant bool
+	value    interface{}
+
+	sql sqlFragment
+	// kind of the values of the SQL expression, reflect.Bool, String or Float64
+	kind reflect.Kind
+	// column, if non-nil, is the column the SQL expression is made of
+	column *sqlColumn
+	prec   int
+}
+
+// sqlColumn is the column of a field of the resource.
+type sqlColumn struct {
+	name string
+	kind reflect.Kind
+}
+
+func (x sqlExpr) isBool() bool {
+	if x.constant {
+		_, ok := x.value.(bool)
+		return ok
+	}
+	return x.kind == reflect.Bool
+}
+
+// predicate returns the SQL boolean expression of x, testing a boolean column for true.
+func (x sqlExpr) predicate() sqlExpr {
+	if x.column != nil {
+		return sqlCompare("=", x, sqlExpr{constant: true, value: true})
+	}
+	return x
+}
+
+func (x sqlExpr) wrap(prec int) sqlFragment {
+	if x.prec <= prec {
+		return x.sql
+	}
+	fragment := sqlFragment{"("}
+	fragment = append(fragment, x.sql...)
+	return append(fragment, ")")
+}
+
+// arg returns the argument binding the constant value for comparisons with column,
+// converting the integral numbers for the integer columns.
+func (column *sqlColumn) arg(value interface{}) sqlArg {
+	if f, ok := value.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		switch column.kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return sqlArg{int64(f)}
+		}
+	}
+	return sqlArg{value}
+}
+
+func sqlJoin(op string, prec int, x sqlExpr, y sqlExpr) sqlExpr {
+	x, y = x.predicate(), y.predicate()
+	fragment := append(sqlFragment{}, x.wrap(prec)...)
+	fragment = append(fragment, " "+op+" ")
+	fragment = append(fragment, y.wrap(prec)...)
+	return sqlExpr{sql: fragment, kind: reflect.Bool, prec: prec}
+}
+
+// sqlAnd returns x && y, both booleans.
+func sqlAnd(x sqlExpr, y sqlExpr) sqlExpr {
+	switch {
+	case x.constant && x.value == false, y.constant && y.value == true:
+		return x
+	case x.constant, y.constant && y.value == false:
+		return y
+	}
+	return sqlJoin("AND", precAnd, x, y)
+}
+
+// sqlOr returns x || y, both booleans.
+func sqlOr(x sqlExpr, y sqlExpr) sqlExpr {
+	switch {
+	case x.constant && x.value == true, y.constant && y.value == false:
+		return x
+	case x.constant, y.constant && y.value == true:
+		return y
+	}
+	return sqlJoin("OR", precOr, x, y)
+}
+
+// sqlNot returns !x, a non constant boolean.
+func sqlNot(x sqlExpr) sqlExpr {
+	if x.column != nil {
+		return sqlCompare("=", x, sqlExpr{constant: true, value: false})
+	}
+	fragment := append(sqlFragment{"NOT ("}, x.sql...)
+	return sqlExpr{sql: append(fragment, ")"), kind: reflect.Bool}
+}
+
+// sqlCompare returns the comparison of the column x with y, a column or a constant.
+func sqlCompare(op string, x sqlExpr, y sqlExpr) sqlExpr {
+	fragment := sqlFragment{x.column.name + " " + op + " "}
+	if y.constant {
+		fragment = append(fragment, x.column.arg(y.value))
+	} else {
+		fragment = append(fragment, y.column.name)
+	}
+	return sqlExpr{sql: fragment, kind: reflect.Bool}
+}
+
+// column returns the column of the field of the resource, if any.
+func (f *sqlFilter) column(name string) (*sqlColumn, bool) {
+	field, ok := f.structType.FieldByName(name)
+	if !ok || field.PkgPath != "" {
+		return nil, false
+	}
+	column := strings.ToLower(field.Name)
+	if tag, ok := field.Tag.Lookup("db"); ok {
+		if tag = strings.SplitN(tag, ",", 2)[0]; tag == "-" {
+			return nil, false
+		} else if tag != "" {
+			column = tag
+		}
+	}
+	switch kind := field.Type.Kind(); kind {
+	case reflect.String, reflect.Bool:
+		return &sqlColumn{name: column, kind: kind}, true
+	default:
+		if _, ok := asFloat(reflect.Zero(field.Type)); ok {
+			return &sqlColumn{name: column, kind: kind}, true
+		}
+	}
+	return nil, false
+}
+
+// valueKind returns the kind of the values of the column or of the constant x.
+func (x sqlExpr) valueKind() reflect.Kind {
+	if !x.constant {
+		return x.kind
+	}
+	switch x.value.(type) {
+	case bool:
+		return reflect.Bool
+	case string:
+		return reflect.String
+	case float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+// compile compiles node, evaluating the parts not referring to the resource in scope.
+// It returns false if node can't be compiled, or may fail for some resources.
+func (f *sqlFilter) compile(node exprNode, scope *exprScope) (sqlExpr, bool) {
+	if !usesRoot(node, rootResource) {
+		value, err := node.eval(scope)
+		return sqlExpr{constant: true, value: value}, err == nil
+	}
+	switch node := node.(type) {
+	case *exprPath:
+		if len(node.path.fields) != 1 {
+			return sqlExpr{}, false
+		}
+		column, ok := f.column(node.path.fields[0])
+		if !ok {
+			return sqlExpr{}, false
+		}
+		kind := column.kind
+		if kind != reflect.String && kind != reflect.Bool {
+			kind = reflect.Float64
+		}
+		return sqlExpr{sql: sqlFragment{column.name}, kind: kind, column: column}, true
+	case *exprUnary:
+		if node.op != "!" {
+			return sqlExpr{}, false
+		}
+		x, ok := f.compile(node.x, scope)
+		if !ok || x.kind != reflect.Bool {
+			return sqlExpr{}, false
+		}
+		return sqlNot(x), true
+	case *exprBinary:
+		return f.compileBinary(node, scope)
+	case *exprCall:
+		return f.compileCall(node, scope)
+	}
+	return sqlExpr{}, false
+}
+
+func (f *sqlFilter) compileBinary(node *exprBinary, scope *exprScope) (sqlExpr, bool) {
+	x, ok := f.compile(node.x, scope)
+	if !ok || (node.op == "&&" || node.op == "||") && !x.isBool() {
+		return sqlExpr{}, false
+	}
+	// the operands are evaluated left to right, the second one only if needed
+	if x.constant && (node.op == "&&" && x.value == false || node.op == "||" && x.value == true) {
+		return x, true
+	}
+	y, ok := f.compile(node.y, scope)
+	if !ok {
+		return sqlExpr{}, false
+	}
+	switch node.op {
+	case "&&", "||":
+		if !y.isBool() {
+			return sqlExpr{}, false
+		}
+		if node.op == "&&" {
+			return sqlAnd(x, y), true
+		}
+		return sqlOr(x, y), true
+	case "==", "!=":
+		if x.constant {
+			x, y = y, x
+		}
+		if x.column == nil || !y.constant && y.column == nil {
+			return sqlExpr{}, false
+		}
+		if x.valueKind() != y.valueKind() {
+			// the columns are never null, nor equal to values of other kinds
+			return sqlExpr{constant: true, value: node.op == "!="}, true
+		}
+		if node.op == "==" {
+			return sqlCompare("=", x, y), true
+		}
+		return sqlCompare("<>", x, y), true
+	case "<", "<=", ">", ">=":
+		// the strings are not compared, since the collation may not follow Go
+		if x.valueKind() != reflect.Float64 || y.valueKind() != reflect.Float64 {
+			return sqlExpr{}, false
+		}
+		op := node.op
+		if x.constant {
+			x, y = y, x
+			op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+		}
+		if x.column == nil || !y.constant && y.column == nil {
+			return sqlExpr{}, false
+		}
+		return sqlCompare(op, x, y), true
+	case "in":
+		if x.column == nil || !y.constant {
+			return sqlExpr{}, false
+		}
+		v := indirect(reflect.ValueOf(y.value))
+		var elems []reflect.Value
+		switch {
+		case v.IsValid() && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array):
+			for i := 0; i < v.Len(); i++ {
+				elems = append(elems, v.Index(i))
+			}
+		case v.IsValid() && v.Kind() == reflect.Map:
+			elems = v.MapKeys()
+		default:
+			return sqlExpr{}, false
+		}
+		fragment := sqlFragment{x.column.name + " IN ("}
+		n := 0
+		for _, elem := range elems {
+			value := exprValue(elem)
+			if (sqlExpr{constant: true, value: value}).valueKind() != x.kind {
+				continue
+			}
+			if n > 0 {
+				fragment = append(fragment, ", ")
+			}
+			fragment = append(fragment, x.column.arg(value))
+			n++
+		}
+		if n == 0 {
+			return sqlExpr{constant: true, value: false}, true
+		}
+		return sqlExpr{sql: append(fragment, ")"), kind: reflect.Bool}, true
+	}
+	return sqlExpr{}, false
+}
+
+func (f *sqlFilter) compileCall(node *exprCall, scope *exprScope) (sqlExpr, bool) {
+	if node.name != "startsWith" && node.name != "endsWith" && node.name != "contains" {
+		return sqlExpr{}, false
+	}
+	s, ok := f.compile(node.args[0], scope)
+	if !ok || s.column == nil || s.kind != reflect.String {
+		return sqlExpr{}, false
+	}
+	t, ok := f.compile(node.args[1], scope)
+	if !ok {
+		return sqlExpr{}, false
+	}
+	sub, ok := t.value.(string)
+	if !ok {
+		return sqlExpr{}, false
+	}
+
+	var pattern string
+	var fragment sqlFragment
+	if f.dialect.Glob {
+		pattern = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]").Replace(sub)
+		fragment = sqlFragment{s.column.name + " GLOB "}
+	} else {
+		pattern = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(sub)
+		like := f.dialect.Like
+		if like == "" {
+			like = "LIKE"
+		}
+		fragment = sqlFragment{s.column.name + " " + like + " "}
+	}
+	wildcard := "%"
+	if f.dialect.Glob {
+		wildcard = "*"
+	}
+	switch node.name {
+	case "startsWith":
+		pattern += wildcard
+	case "endsWith":
+		pattern = wildcard + pattern
+	default:
+		pattern = wildcard + pattern + wildcard
+	}
+	fragment = append(fragment, sqlArg{pattern})
+	if !f.dialect.Glob {
+		fragment = append(fragment, " ESCAPE '!'")
+	}
+	return sqlExpr{sql: fragment, kind: reflect.Bool}, true
+}
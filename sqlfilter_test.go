@@ -0,0 +1,248 @@
+package perms
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlPlaylist is a Playlist row.
+type sqlPlaylist struct {
+	ID     string `db:"id"`
+	Public bool
+	User   string `db:"user_name"`
+	Group  string `db:"group_name,omitempty"`
+	Rating int    `db:"rating"`
+	Notes  string `db:"-"`
+}
+
+var sqlPlaylists = []*sqlPlaylist{
+	{ID: "1", User: "john", Group: "editors", Rating: 5},
+	{ID: "2", User: "john", Group: "john", Public: true, Rating: 1},
+	{ID: "3", User: "jack", Group: "editors", Rating: 3},
+	{ID: "4", User: "jack", Group: "jack", Public: true, Rating: 2},
+	{ID: "john-5", User: "mary", Group: "writers", Rating: 4},
+	{ID: "j*hn-6", User: "mary", Group: "editors", Public: true},
+	{ID: "john_7%", User: "j*hn", Group: "writers", Rating: 3},
+}
+
+func openPlaylists(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE playlists (id TEXT NOT NULL, public BOOLEAN NOT NULL, user_name TEXT NOT NULL,
+		group_name TEXT NOT NULL, rating INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range sqlPlaylists {
+		if _, err := db.Exec("INSERT INTO playlists VALUES (?, ?, ?, ?, ?)", p.ID, p.Public, p.User, p.Group, p.Rating); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func newSQLPolicyRuleSet(t *testing.T) *RuleSet {
+	t.Helper()
+	rs := NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("Group", &Group{})
+	rs.RegisterType("Playlist", &sqlPlaylist{})
+	if err := rs.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// checkFilter compiles the filter of the query and checks the rows it selects against
+// the decisions of rs, returning the clause.
+func checkFilter(t *testing.T, db *sql.DB, rs *RuleSet, subject interface{}, action string, complete bool) string {
+	t.Helper()
+	where, args, gotComplete, err := rs.CompileFilter(subject, action, &sqlPlaylist{}, SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotComplete != complete {
+		t.Errorf("%v %s: got complete %v want %v", subject, action, gotComplete, complete)
+	}
+	rows, err := db.Query("SELECT id FROM playlists WHERE "+where, args...)
+	if err != nil {
+		t.Fatalf("%v %s: %v in %s", subject, action, err, where)
+	}
+	defer rows.Close()
+	got := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got[id] = true
+	}
+	var selected, allowed []string
+	for _, p := range sqlPlaylists {
+		if got[p.ID] {
+			selected = append(selected, p.ID)
+		}
+		if rs.IsAllowed(subject, action, p) {
+			allowed = append(allowed, p.ID)
+			if !got[p.ID] {
+				t.Errorf("%v %s: %s allowed but not selected by %s %v", subject, action, p.ID, where, args)
+			}
+		}
+	}
+	if complete && !reflect.DeepEqual(selected, allowed) {
+		t.Errorf("%v %s: got %v want %v selected by %s %v", subject, action, selected, allowed, where, args)
+	}
+	return where
+}
+
+func TestCompileFilter(t *testing.T) {
+	db := openPlaylists(t)
+	rs := newSQLPolicyRuleSet(t)
+	john := &User{Name: "john"}
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+
+	for _, tc := range []struct {
+		subject interface{}
+		action  string
+		where   string
+	}{
+		{john, "view", "user_name = ? OR public = ?"},
+		{&User{Name: "jack"}, "view", "user_name = ? OR public = ?"},
+		{john, "modify", "user_name = ?"},
+		{&Group{Name: "editors"}, "modify", "group_name = ?"},
+		{overlord, "view", "1 = 1"},
+		{overlord, "delete", "1 = 1"},
+		{john, "delete", "1 = 0"},
+	} {
+		if where := checkFilter(t, db, rs, tc.subject, tc.action, true); where != tc.where {
+			t.Errorf("%v %s: got %s want %s", tc.subject, tc.action, where, tc.where)
+		}
+	}
+
+	where, args, _, _ := rs.CompileFilter(john, "view", &sqlPlaylist{}, PostgresDialect)
+	if where != "user_name = $1 OR public = $2" || !reflect.DeepEqual(args, []interface{}{"john", true}) {
+		t.Errorf("got %s %v", where, args)
+	}
+	if _, _, _, err := rs.CompileFilter(john, "view", "playlist", SQLiteDialect); err != ErrNotStructType {
+		t.Errorf("got %v want ErrNotStructType", err)
+	}
+}
+
+func TestCompileFilterExpressions(t *testing.T) {
+	db := openPlaylists(t)
+	users := []*User{{Name: "john"}, {Name: "jack"}, {Name: "mary"}, {Name: "j*hn"}, {Name: "admin", IsSuperuser: true}}
+	rules := []DeclarativeRule{
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `startsWith(resource.ID, subject.Name + "-")`, Effect: ALLOW},
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `resource.Rating >= 4 && resource.Group in ["editors", "writers"]`, Effect: ALLOW},
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `contains(resource.ID, "_7%") || endsWith(resource.User, "*hn")`, Effect: ALLOW},
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `!resource.Public && 2 > resource.Rating`, Effect: DENY},
+		{Subject: "User", Action: "view", Resource: "Playlist", Conditions: []Condition{{Field: "resource.User", Ref: "subject.Name"}}, Effect: ALLOW, Priority: -1},
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `resource.Public && resource.User != subject.Name`, Effect: ALLOW},
+		{Subject: "User", Action: "view", Resource: "Playlist", Condition: `resource.Group == subject.Name && resource.Rating != 2`, Effect: DENY, Quick: true},
+		{Subject: "User", Condition: "subject.IsSuperuser", Effect: ALLOW, Quick: true},
+		{Subject: "User", Action: "view", Condition: `resource.Rating == 3 || size(subject.Name) > 4`, Effect: DENY},
+	}
+	for _, combining := range []CombiningStrategy{LastApplicable, FirstApplicable, DenyOverrides, AllowOverrides} {
+		for _, flat := range []bool{false, true} {
+			rs := NewRuleSet(DENY)
+			rs.Combining = combining
+			rs.FlatEvaluation = flat
+			rs.RegisterType("User", &User{})
+			rs.RegisterType("Playlist", &sqlPlaylist{})
+			if err := rs.AddPolicy(Policy{Rules: rules}); err != nil {
+				t.Fatal(err)
+			}
+			for _, user := range users {
+				checkFilter(t, db, rs, user, "view", true)
+			}
+		}
+	}
+}
+
+func TestCompileFilterIncomplete(t *testing.T) {
+	db := openPlaylists(t)
+	rs := newSQLPolicyRuleSet(t)
+	john := &User{Name: "john"}
+
+	// the matchers can't be compiled
+	rs.AddRuleCtx(&User{}, "view", &sqlPlaylist{}, func(ctx context.Context, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		return res.(*sqlPlaylist).Rating > 4, DENY, false, nil
+	})
+	checkFilter(t, db, rs, john, "view", false)
+	if where := checkFilter(t, db, rs, john, "modify", true); where != "user_name = ?" {
+		t.Errorf("got %s", where)
+	}
+
+	// nor the conditions on the unmapped fields
+	rs = newSQLPolicyRuleSet(t)
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "view", Resource: "Playlist", Condition: `resource.Notes == ""`, Effect: DENY}); err != nil {
+		t.Fatal(err)
+	}
+	checkFilter(t, db, rs, john, "view", false)
+
+	// nor those on the environment of the query
+	rs = newSQLPolicyRuleSet(t)
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "view", Resource: "Playlist", Condition: `env.mfa == false`, Effect: DENY}); err != nil {
+		t.Fatal(err)
+	}
+	checkFilter(t, db, rs, john, "view", false)
+	rs = newSQLPolicyRuleSet(t)
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "view", Resource: "Playlist", Condition: `attr(subject, "department") == "legal"`, Effect: DENY}); err != nil {
+		t.Fatal(err)
+	}
+	checkFilter(t, db, rs, john, "view", false)
+
+	// the grants and the roles may decide for any row
+	rs = newSQLPolicyRuleSet(t)
+	rs.Grant("john", "view", "3", ALLOW, 0)
+	if where := checkFilter(t, db, rs, "john", "view", false); where != "1 = 1" {
+		t.Errorf("got %s want 1 = 1", where)
+	}
+
+	rs = newSQLPolicyRuleSet(t)
+	rs.SuperuserFn = func(subject interface{}) bool {
+		return subject.(*User).IsSuperuser
+	}
+	if where := checkFilter(t, db, rs, &User{Name: "admin", IsSuperuser: true}, "delete", true); where != "1 = 1" {
+		t.Errorf("got %s want 1 = 1", where)
+	}
+	rs.Roles = NewRoles()
+	checkFilter(t, db, rs, john, "view", false)
+
+	rs = newSQLPolicyRuleSet(t)
+	rs.DefaultEffectFn = func(subject interface{}, action interface{}, resource interface{}) string {
+		return ALLOW
+	}
+	checkFilter(t, db, rs, john, "modify", false)
+}
+
+func TestCompileFilterPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		dialect SQLDialect
+		where   string
+		arg     string
+	}{
+		{SQLiteDialect, "id GLOB ?", "j[*]hn[?]_x[[]%*"},
+		{PostgresDialect, "id LIKE $1 ESCAPE '!'", "j*hn?!_x[!%%"},
+		{MySQLDialect, "id LIKE BINARY ? ESCAPE '!'", "j*hn?!_x[!%%"},
+	} {
+		rs := NewRuleSet(DENY)
+		rs.RegisterType("Playlist", &sqlPlaylist{})
+		if _, err := rs.AddDeclarativeRule(DeclarativeRule{Resource: "Playlist", Condition: `startsWith(resource.ID, "j*hn?_x[%")`, Effect: ALLOW}); err != nil {
+			t.Fatal(err)
+		}
+		where, args, complete, err := rs.CompileFilter(nil, "view", &sqlPlaylist{}, tc.dialect)
+		if err != nil || !complete || where != tc.where || !reflect.DeepEqual(args, []interface{}{tc.arg}) {
+			t.Errorf("got %s %v %v %v want %s [%s]", where, args, complete, err, tc.where, tc.arg)
+		}
+	}
+}
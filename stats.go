@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the decision counters of a RuleSet.
+type Stats struct {
+	// Queries is the number of queries evaluated.
+	Queries uint64
+	// Allows and Denies count the queries resulting in the Allow and Deny effects.
+	Allows uint64
+	Denies uint64
+	// Defaults counts the queries for which no rule applied, see Decision.Default.
+	Defaults uint64
+	// Errors counts the queries stopped by an error, see QueryE.
+	Errors uint64
+	// RuleHits counts, by rule id, how many times a rule matched producing an effect.
+	// Rules that never matched are missing, removed rules are kept until ResetStats,
+	// or Clear.
+	RuleHits map[RuleID]uint64
+}
+
+// ruleStats holds the counters of a RuleSet, safe for concurrent use.
+type ruleStats struct {
+	queries, allows, denies, defaults, errors atomic.Uint64
+	// ruleHits maps rules to *atomic.Uint64 counters
+	ruleHits sync.Map
+	// counters maps the *ruleCounters of the rules of other rule sets, shared by
+	// Clone, to the counters of this one
+	counters sync.Map
+}
+
+// ruleCounters holds the evaluation counters of a rule, see RuleInfo.Evaluations.
+type ruleCounters struct {
+	// stats is the owner of the counters
+	stats                *ruleStats
+	evaluations, matches atomic.Uint64
+	// lastMatch is the time of the last match in nanoseconds since the epoch
+	lastMatch atomic.Int64
+}
+
+// countersOf returns the counters of rule in the rule set of stats.
+func (stats *ruleStats) countersOf(rule *Rule) *ruleCounters {
+	if counters := rule.counters; counters != nil && counters.stats == stats {
+		return counters
+	}
+	return stats.sharedCounters(rule)
+}
+
+// sharedCounters is the slow path of countersOf, for the rules of other rule sets.
+func (stats *ruleStats) sharedCounters(rule *Rule) *ruleCounters {
+	counters, ok := stats.counters.Load(rule.counters)
+	if !ok {
+		counters, _ = stats.counters.LoadOrStore(rule.counters, &ruleCounters{stats: stats})
+	}
+	return counters.(*ruleCounters)
+}
+
+func (counters *ruleCounters) fill(info *RuleInfo) {
+	info.Evaluations = counters.evaluations.Load()
+	info.Matches = counters.matches.Load()
+	if last := counters.lastMatch.Load(); last != 0 {
+		info.LastMatch = time.Unix(0, last)
+	}
+}
+
+func (counters *ruleCounters) reset() {
+	counters.evaluations.Store(0)
+	counters.matches.Store(0)
+	counters.lastMatch.Store(0)
+}
+
+func (stats *ruleStats) countDecision(decision Decision, err error) {
+	stats.queries.Add(1)
+	switch {
+	case err != nil:
+		stats.errors.Add(1)
+	case decision.Default:
+		stats.defaults.Add(1)
+	}
+	switch decision.Effect {
+	case Allow:
+		stats.allows.Add(1)
+	case Deny:
+		stats.denies.Add(1)
+	}
+}
+
+func (stats *ruleStats) countHit(rule *Rule) {
+	counter, ok := stats.ruleHits.Load(rule)
+	if !ok {
+		counter, _ = stats.ruleHits.LoadOrStore(rule, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// Stats returns a snapshot of the decision counters.
+func (ruleSet *RuleSet) Stats() Stats {
+	stats := ruleSet.stats
+	snapshot := Stats{
+		Queries:  stats.queries.Load(),
+		Allows:   stats.allows.Load(),
+		Denies:   stats.denies.Load(),
+		Defaults: stats.defaults.Load(),
+		Errors:   stats.errors.Load(),
+		RuleHits: make(map[RuleID]uint64),
+	}
+	stats.ruleHits.Range(func(rule, counter interface{}) bool {
+		snapshot.RuleHits[rule.(*Rule).id] += counter.(*atomic.Uint64).Load()
+		return true
+	})
+	return snapshot
+}
+
+// DeadRules returns the rules that didn't match since the given time, including those
+// that never matched, in the order of Rules. The matches are counted from the addition
+// of the rules or the last ResetStats.
+func (ruleSet *RuleSet) DeadRules(since time.Time) []RuleInfo {
+	var dead []RuleInfo
+	ruleSet.Walk(func(info RuleInfo) bool {
+		if info.LastMatch.Before(since) {
+			dead = append(dead, info)
+		}
+		return true
+	})
+	return dead
+}
+
+// ResetStats zeroes the decision counters and those of the rules.
+func (ruleSet *RuleSet) ResetStats() {
+	stats := ruleSet.stats
+	stats.queries.Store(0)
+	stats.allows.Store(0)
+	stats.denies.Store(0)
+	stats.defaults.Store(0)
+	stats.errors.Store(0)
+	table := ruleSet.current()
+	for _, rule := range table.allRules() {
+		stats.countersOf(rule).reset()
+	}
+	for _, ns := range table.namespaces {
+		for _, rule := range ns.allRules() {
+			stats.countersOf(rule).reset()
+		}
+	}
+	stats.forgetRules()
+}
+
+// forgetRules drops the hits of the rules and the counters shared with other rule
+// sets, releasing the rules they refer to.
+func (stats *ruleStats) forgetRules() {
+	stats.ruleHits.Range(func(rule, _ interface{}) bool {
+		stats.ruleHits.Delete(rule)
+		return true
+	})
+	stats.counters.Range(func(key, _ interface{}) bool {
+		stats.counters.Delete(key)
+		return true
+	})
+}
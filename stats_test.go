@@ -0,0 +1,190 @@
+package perms
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	rs := newVideoRuleSet()
+	failing := rs.AddRuleE(&User{}, "delete", &Video{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+			return false, "", false, errors.New("storage unavailable")
+		})
+
+	john := &User{Name: "john"}
+	admin := &User{Name: "admin", IsSuperuser: true}
+	editors := &Group{Name: "editors"}
+	john_playlist := &Playlist{ID: "6563", User: "john", Group: "editors"}
+	jack_video := &Video{Name: "intro", User: "jack"}
+
+	rs.Query(john, "view", john_playlist)      // allow, view playlist rule
+	rs.Query(john, "view", jack_video)         // deny, view video rule
+	rs.Query(admin, "modify", jack_video)      // allow, quick modify video rule
+	rs.Query(editors, "modify", john_playlist) // allow, group rule
+	rs.Query(john, "view", &Archive{})         // deny, jolly view rule
+	rs.Query(john, "publish", jack_video)      // default
+	rs.QueryE(john, "delete", jack_video)      // error
+
+	ids := rs.current().allRules()
+	want := Stats{
+		Queries:  7,
+		Allows:   3,
+		Denies:   4,
+		Defaults: 1,
+		Errors:   1,
+		RuleHits: map[RuleID]uint64{
+			ids[0].id: 1,
+			ids[2].id: 1,
+			ids[3].id: 1,
+			ids[4].id: 1,
+			ids[5].id: 1,
+		},
+	}
+	if got := rs.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	if _, ok := rs.Stats().RuleHits[failing]; ok {
+		t.Errorf("got hits for the failing rule")
+	}
+
+	rs.ResetStats()
+	if got, want := rs.Stats(), (Stats{RuleHits: map[RuleID]uint64{}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v after reset", got, want)
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	rs := newVideoRuleSet()
+	john := &User{Name: "john"}
+	john_playlist := &Playlist{ID: "6563", User: "john"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rs.Query(john, "view", john_playlist)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := rs.Stats(); stats.Queries != 800 || stats.Allows != 800 || stats.RuleHits[rs.current().allRules()[0].id] != 800 {
+		t.Errorf("got %+v want 800 allowed queries", stats)
+	}
+}
+
+func benchmarkRuleStats(b *testing.B, disabled bool) {
+	rs := newLargeRuleSet(1000)
+	rs.DisableRuleStats = disabled
+	rs.AddRule(&User{}, "view", &Playlist{}, effectMatcher(ALLOW))
+	john := &User{Name: "john"}
+	playlist := &Playlist{ID: "6563", User: "john"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rs.Query(john, "view", playlist) != ALLOW {
+			b.Fatal("unexpected effect")
+		}
+	}
+}
+
+func BenchmarkRuleStats(b *testing.B)         { benchmarkRuleStats(b, false) }
+func BenchmarkRuleStatsDisabled(b *testing.B) { benchmarkRuleStats(b, true) }
+
+// ruleInfo returns the description of the rule with the given id.
+func ruleInfo(rs *RuleSet, id RuleID) RuleInfo {
+	for _, info := range rs.Rules() {
+		if info.ID == id {
+			return info
+		}
+	}
+	return RuleInfo{}
+}
+
+func TestRuleCounters(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	start := now
+	rs := newVideoRuleSet()
+	rs.Now = func() time.Time { return now }
+	never := rs.AddRule(&User{}, "view", &Video{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return false, "", false
+	})
+
+	john := &User{Name: "john"}
+	admin := &User{Name: "admin", IsSuperuser: true}
+	john_playlist := &Playlist{ID: "6563", User: "john"}
+	jack_video := &Video{Name: "intro", User: "jack"}
+
+	rs.Query(john, "view", john_playlist) // rule-1
+	rs.Query(john, "view", jack_video)    // rule-3 denies, rule-7 doesn't match
+	now = now.Add(time.Hour)
+	rs.Query(admin, "modify", jack_video) // rule-4, quick
+	rs.Query(john, "modify", jack_video)  // rule-4
+	rs.Query(john, "view", &Archive{})    // rule-6
+	rs.Query(john, "publish", jack_video) // no rule
+
+	type counts struct {
+		evaluations, matches uint64
+		last                 time.Time
+	}
+	want := map[RuleID]counts{
+		"rule-1": {1, 1, start},
+		"rule-2": {0, 0, time.Time{}},
+		"rule-3": {1, 1, start},
+		"rule-4": {2, 2, now},
+		"rule-5": {0, 0, time.Time{}},
+		"rule-6": {1, 1, now},
+		never:    {1, 0, time.Time{}},
+	}
+	for _, info := range rs.Rules() {
+		got := counts{info.Evaluations, info.Matches, info.LastMatch}
+		if w := want[info.ID]; got.evaluations != w.evaluations || got.matches != w.matches || !got.last.Equal(w.last) {
+			t.Errorf("%s: got %+v want %+v", info.ID, got, w)
+		}
+	}
+
+	var dead []RuleID
+	for _, info := range rs.DeadRules(now) {
+		dead = append(dead, info.ID)
+	}
+	// in the order of Rules, grouped by types triple
+	if want := []RuleID{"rule-1", "rule-2", "rule-3", never, "rule-5"}; !reflect.DeepEqual(dead, want) {
+		t.Errorf("got dead rules %v want %v", dead, want)
+	}
+
+	// the counters survive disabling the rules, not a reset
+	rs.DisableRule("rule-4")
+	if info := ruleInfo(rs, "rule-4"); info.Evaluations != 2 {
+		t.Errorf("got %+v want the counters of rule-4", info)
+	}
+	rs.ResetStats()
+	if got := len(rs.DeadRules(now.Add(time.Second))); got != 7 {
+		t.Errorf("got %d dead rules want all 7 after the reset", got)
+	}
+
+	rs.DisableRuleStats = true
+	rs.Query(john, "view", john_playlist)
+	if info := ruleInfo(rs, "rule-1"); info.Evaluations != 0 || info.Matches != 0 {
+		t.Errorf("got %+v want no counts with DisableRuleStats", info)
+	}
+}
+
+func TestRuleCountersClone(t *testing.T) {
+	rs := newVideoRuleSet()
+	clone := rs.Clone()
+	john := &User{Name: "john"}
+	clone.Query(john, "view", &Archive{})
+	clone.Query(john, "view", &Archive{})
+	rs.Query(john, "view", &Archive{})
+	if got := ruleInfo(clone, "rule-6"); got.Evaluations != 2 || got.Matches != 2 {
+		t.Errorf("got %+v want the counts of the clone", got)
+	}
+	if got := ruleInfo(rs, "rule-6"); got.Evaluations != 1 || got.Matches != 1 {
+		t.Errorf("got %+v want the counts of the original", got)
+	}
+}
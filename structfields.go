@@ -0,0 +1,39 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+)
+
+// matchStructFields reports whether every non-zero exported field of the template struct
+// equals the corresponding field of value, which must have the same type.
+// Struct fields are compared recursively, the others with sameValue. See RuleSet.StructFieldTemplates.
+func matchStructFields(template reflect.Value, value reflect.Value) bool {
+	if !template.IsValid() || template.Type() != value.Type() {
+		return false
+	}
+	t := template.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		tf, vf := template.Field(i), value.Field(i)
+		if tf.IsZero() {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if !matchStructFields(tf, vf) {
+				return false
+			}
+		} else if !sameValue(tf.Interface(), vf.Interface()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package perms
+
+import (
+	"testing"
+)
+
+type clearance struct {
+	Level int
+	Areas []string
+}
+
+type agent struct {
+	Name      string
+	Clearance clearance
+	Tags      map[string]string
+	codename  string
+}
+
+func TestStructFieldTemplates(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.StructFieldTemplates = true
+	rs.AddRule(User{IsSuperuser: true}, "delete", &Video{}, effectMatcher(ALLOW))
+
+	video := &Video{Name: "intro"}
+	if got := rs.Query(User{Name: "root", IsSuperuser: true}, "delete", video); got != ALLOW {
+		t.Errorf("got %q want %q for a superuser", got, ALLOW)
+	}
+	if got := rs.Query(User{Name: "john"}, "delete", video); got != DENY {
+		t.Errorf("got %q want %q for a regular user", got, DENY)
+	}
+
+	// without the option the whole value must be equal to the template
+	rs.StructFieldTemplates = false
+	if got := rs.Query(User{Name: "root", IsSuperuser: true}, "delete", video); got != DENY {
+		t.Errorf("got %q want %q with whole value templates", got, DENY)
+	}
+	if got := rs.Query(User{IsSuperuser: true}, "delete", video); got != ALLOW {
+		t.Errorf("got %q want %q with whole value templates", got, ALLOW)
+	}
+}
+
+func TestStructFieldTemplatesNested(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.StructFieldTemplates = true
+	rs.AddRule(agent{Clearance: clearance{Level: 3}, codename: "ignored"}, "read", nil, effectMatcher(ALLOW))
+	rs.AddRule(agent{Clearance: clearance{Areas: []string{"ops"}}}, "deploy", nil, effectMatcher(ALLOW))
+	rs.AddRule(agent{Tags: map[string]string{"team": "red"}}, "attack", nil, effectMatcher(ALLOW))
+
+	james := agent{Name: "james", Clearance: clearance{Level: 3, Areas: []string{"ops"}}, codename: "007"}
+	if got := rs.Query(james, "read", "file"); got != ALLOW {
+		t.Errorf("got %q want %q matching a nested field", got, ALLOW)
+	}
+	if got := rs.Query(agent{Clearance: clearance{Level: 2}}, "read", "file"); got != DENY {
+		t.Errorf("got %q want %q for a lower level", got, DENY)
+	}
+	if got := rs.Query(james, "deploy", "file"); got != ALLOW {
+		t.Errorf("got %q want %q matching a slice field", got, ALLOW)
+	}
+	if got := rs.Query(agent{Clearance: clearance{Areas: []string{"hr"}}}, "deploy", "file"); got != DENY {
+		t.Errorf("got %q want %q for another area", got, DENY)
+	}
+	if got := rs.Query(james, "attack", "file"); got != DENY {
+		t.Errorf("got %q want %q without the tag", got, DENY)
+	}
+	if got := rs.Query(agent{Tags: map[string]string{"team": "red"}}, "attack", "file"); got != ALLOW {
+		t.Errorf("got %q want %q with the tag", got, ALLOW)
+	}
+}
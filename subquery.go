@@ -0,0 +1,99 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotInQuery is returned by QueryContext.SubQuery when the matcher is not run by a
+// query.
+var ErrNotInQuery = errors.New("perms: sub-query outside of a query")
+
+// SubQueryMatcherFn is a matcher receiving the QueryContext of the query, to decide
+// with sub-queries, eg. allowing to modify a video those who may admin its playlist.
+type SubQueryMatcherFn func(qc *QueryContext, subject interface{}, action interface{}, resource interface{}) (matches bool, effect string, quick bool, err error)
+
+// AddRuleSubQuery is like AddRuleCtx, but takes a matcher receiving the QueryContext
+// of the query, instead of capturing the RuleSet, which is then the one evaluating the
+// rule, eg. a Clone.
+func (ruleSet *RuleSet) AddRuleSubQuery(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher SubQueryMatcherFn, options ...RuleOption) RuleID {
+	return ruleSet.AddRuleCtx(subjectType, actionType, resourceType, func(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (bool, string, bool, error) {
+		return matcher(&QueryContext{ctx: ctx, frame: callerOf(ctx)}, subject, action, resource)
+	}, options...)
+}
+
+// QueryContext is the context of the query running a matcher of AddRuleSubQuery.
+type QueryContext struct {
+	ctx   context.Context
+	frame *evaluationContext
+}
+
+// Context returns the context of the query, see QueryCtx.
+func (qc *QueryContext) Context() context.Context {
+	return qc.ctx
+}
+
+// RuleSet returns the rule set of the query, nil if the matcher is not run by a query.
+func (qc *QueryContext) RuleSet() *RuleSet {
+	if qc.frame == nil {
+		return nil
+	}
+	return qc.frame.ruleSet
+}
+
+// Snapshot returns the rules evaluated by the query, which the rules added or removed
+// since it started don't affect, nil if the matcher is not run by a query. Its
+// queries with Context are nested in the query, like those of SubQuery, but don't
+// share its evaluation budget.
+func (qc *QueryContext) Snapshot() *Snapshot {
+	if qc.frame == nil {
+		return nil
+	}
+	return &Snapshot{ruleSet: qc.frame.ruleSet, table: qc.frame.table}
+}
+
+// Env returns the attributes of the environment of the query, see QueryEnv.
+func (qc *QueryContext) Env() map[string]interface{} {
+	return envFrom(qc.ctx)
+}
+
+// Trace sends event to the trace of the query, if any, see QueryTrace.
+func (qc *QueryContext) Trace(event TraceEvent) {
+	if qc.frame != nil && qc.frame.trace != nil {
+		qc.frame.trace(event)
+	}
+}
+
+// SubQuery decides the (subject, action, resource) query with the rules and the
+// environment of the query. The sub-query is nested in the query (see
+// RuleSet.MaxQueryDepth), its matchers count in the evaluation budget of the query
+// (see RuleSet.MaxEvaluations), and it is not traced. Once the budget is spent, the
+// sub-query is not evaluated: its default decision (see RuleSet.DefaultEffectFn) is
+// returned along with ErrBudgetExceeded.
+func (qc *QueryContext) SubQuery(subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	frame := qc.frame
+	if frame == nil {
+		return Decision{}, ErrNotInQuery
+	}
+	return frame.subQuery(qc.ctx, subject, action, resource)
+}
+
+// subQuery runs a sub-query of the evaluation, with ctx derived from it.
+func (frame *evaluationContext) subQuery(ctx context.Context, subject interface{}, action interface{}, resource interface{}) (Decision, error) {
+	opts := queryOptions{table: frame.table, env: envFrom(ctx)}
+	if frame.maxEvaluations > 0 {
+		remaining := frame.maxEvaluations - frame.evaluations
+		if remaining <= 0 {
+			return frame.ruleSet.defaultDecision(frame.table, subject, action, resource), ErrBudgetExceeded
+		}
+		opts.maxEvaluations = &remaining
+	}
+	decision, err := frame.ruleSet.evaluateWith(ctx, opts, subject, action, resource)
+	frame.evaluations += decision.Evaluations
+	return decision, err
+}
@@ -0,0 +1,125 @@
+package perms
+
+import (
+	"errors"
+	"testing"
+)
+
+// playlistVideo is a video of a playlist, administered by the owner of the playlist.
+type playlistVideo struct {
+	Name     string
+	Playlist *Playlist
+}
+
+func playlistAdmin(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+	return res.(*Playlist).User == subj.(*User).Name, ALLOW, false
+}
+
+func TestSubQuery(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(&User{}, "admin", &Playlist{}, playlistAdmin)
+	rs.AddRuleSubQuery(&User{}, "modify", &playlistVideo{}, func(qc *QueryContext, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		if qc.RuleSet() == nil || qc.Env()["mfa"] != true {
+			return false, "", false, nil
+		}
+		decision, err := qc.SubQuery(subj, "admin", res.(*playlistVideo).Playlist)
+		return decision.Effect == ALLOW, ALLOW, false, err
+	})
+	john := &User{Name: "john"}
+	jack := &User{Name: "jack"}
+	video := &playlistVideo{Name: "intro", Playlist: &Playlist{ID: "best", User: "john"}}
+	env := map[string]interface{}{"mfa": true}
+
+	if got := rs.QueryEnv(john, "modify", video, env); got != ALLOW {
+		t.Errorf("got %q want %q for the admin of the playlist", got, ALLOW)
+	}
+	if got := rs.QueryEnv(jack, "modify", video, env); got != DENY {
+		t.Errorf("got %q want %q for another user", got, DENY)
+	}
+	if got := rs.Query(john, "modify", video); got != DENY {
+		t.Errorf("got %q want %q without the environment", got, DENY)
+	}
+
+	// the clones evaluate their own rules
+	clone := rs.Clone()
+	clone.AddRuleWithPriority(10, &User{}, "admin", &Playlist{}, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return true, DENY, true
+	})
+	if got := clone.QueryEnv(john, "modify", video, env); got != DENY {
+		t.Errorf("got %q want %q with the rules of the clone", got, DENY)
+	}
+	if got := rs.QueryEnv(john, "modify", video, env); got != ALLOW {
+		t.Errorf("got %q want %q with the original rules", got, ALLOW)
+	}
+
+	// the sub-queries count in the budget of the query
+	if d := rs.QueryOpt(john, "modify", video, WithEnv(env), WithMaxEvaluations(1)); d.Effect != DENY || !d.Default {
+		t.Errorf("got %+v want the default decision over budget", d)
+	}
+	if d := rs.QueryOpt(john, "modify", video, WithEnv(env), WithMaxEvaluations(2)); d.Effect != ALLOW || d.Evaluations != 2 {
+		t.Errorf("got %+v want 2 evaluations", d)
+	}
+
+	// over budget, the sub-query has its default decision
+	var sub Decision
+	var subErr error
+	rs.AddRuleSubQuery(&User{}, "share", &playlistVideo{}, func(qc *QueryContext, subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		sub, subErr = qc.SubQuery(subj, "admin", res.(*playlistVideo).Playlist)
+		return false, "", false, nil
+	})
+	rs.SetDefaultEffectForType(&Playlist{}, "playlist-default")
+	rs.QueryOpt(john, "share", video, WithMaxEvaluations(1))
+	if sub.Effect != "playlist-default" || !sub.Default || !errors.Is(subErr, ErrBudgetExceeded) {
+		t.Errorf("got %+v, %v want the default of the playlists over budget", sub, subErr)
+	}
+	rs.DefaultEffectFn = func(subject interface{}, action interface{}, resource interface{}) string {
+		return "dynamic"
+	}
+	rs.QueryOpt(john, "share", video, WithMaxEvaluations(1))
+	if sub.Effect != "dynamic" || sub.Reason != DynamicDefaultReason {
+		t.Errorf("got %+v want the dynamic default over budget", sub)
+	}
+}
+
+func TestSubQueryDeclarative(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.RegisterType("User", &User{})
+	rs.RegisterType("PlaylistVideo", &playlistVideo{})
+	rs.AddRule(&User{}, "admin", &Playlist{}, playlistAdmin)
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "modify", Resource: "PlaylistVideo",
+		Condition: `subquery(subject, "admin", resource.Playlist) == "allow"`, Effect: ALLOW}); err != nil {
+		t.Fatal(err)
+	}
+	video := &playlistVideo{Name: "intro", Playlist: &Playlist{ID: "best", User: "john"}}
+	if got := rs.Query(&User{Name: "john"}, "modify", video); got != ALLOW {
+		t.Errorf("got %q want %q for the admin of the playlist", got, ALLOW)
+	}
+	if got := rs.Query(&User{Name: "jack"}, "modify", video); got != DENY {
+		t.Errorf("got %q want %q for another user", got, DENY)
+	}
+
+	// a rule delegating to itself is stopped
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "share", Resource: "PlaylistVideo",
+		Condition: `subquery(subject, action, resource) == "allow"`, Effect: ALLOW}); err != nil {
+		t.Fatal(err)
+	}
+	rs.StrictExpressions = true
+	if _, err := rs.AddDeclarativeRule(DeclarativeRule{Subject: "User", Action: "publish", Resource: "PlaylistVideo",
+		Condition: `subquery(subject, action, resource) == "allow"`, Effect: ALLOW}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(&User{Name: "john"}, "share", video); got != DENY {
+		t.Errorf("got %q want %q for the cycle", got, DENY)
+	}
+	if _, err := rs.QueryE(&User{Name: "john"}, "publish", video); !errors.Is(err, ErrQueryCycle) {
+		t.Errorf("got %v want %v", err, ErrQueryCycle)
+	}
+
+	expr, err := CompileExpression(`subquery(subject, "admin", resource) == "allow"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := expr.Eval(&User{}, "view", &Playlist{}); !errors.Is(err, ErrNotInQuery) {
+		t.Errorf("got %v want %v", err, ErrNotInQuery)
+	}
+}
@@ -0,0 +1,51 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestSuperuserFn(t *testing.T) {
+	rs := newVideoRuleSet()
+	rs.AddRuleWithPriority(-10, &User{}, "delete", &Video{}, effectMatcher(DENY))
+	rs.SuperuserFn = func(subject interface{}) bool {
+		user, ok := subject.(*User)
+		return ok && user.IsSuperuser
+	}
+	var audited []RuleID
+	rs.AuditFn = func(subject interface{}, action interface{}, resource interface{}, effect string, rule *RuleInfo) {
+		if rule != nil {
+			audited = append(audited, rule.ID)
+		}
+	}
+
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	for _, resource := range []interface{}{&Playlist{ID: "9374", User: "jack"}, &Video{Name: "intro"}, &Archive{Name: "test"}} {
+		for _, action := range []string{"view", "modify", "delete"} {
+			if !rs.IsAllowed(overlord, action, resource) {
+				t.Errorf("got overlord denied %s on %T want allowed", action, resource)
+			}
+		}
+	}
+	if len(audited) != 9 || audited[0] != SuperuserRuleID {
+		t.Errorf("got audited rules %v want 9 superuser overrides", audited)
+	}
+	// the other subjects still go through the rules
+	if rs.IsAllowed(&User{Name: "jack"}, "delete", &Video{Name: "intro", User: "jack"}) {
+		t.Errorf("got jack allowed delete want denied")
+	}
+
+	var traced Decision
+	rs.QueryTrace(overlord, "delete", &Video{}, func(ev TraceEvent) {
+		if ev.Kind == TraceResult {
+			traced = ev.Decision
+		}
+	})
+	if !traced.Superuser || traced.Effect != ALLOW || traced.Evaluations != 0 {
+		t.Errorf("got traced %+v want the superuser override", traced)
+	}
+
+	rs.SuperuserEffect = "superuser"
+	if effect := rs.Query(overlord, "view", &Video{}); effect != "superuser" {
+		t.Errorf("got %q want %q", effect, "superuser")
+	}
+}
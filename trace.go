@@ -0,0 +1,103 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"context"
+)
+
+// TraceKind is the kind of a TraceEvent.
+type TraceKind int
+
+const (
+	// TracePass starts one of the "jolly" fallback passes.
+	TracePass TraceKind = iota
+	// TraceSkipped reports a candidate rule whose templates reject the queried values,
+	// or that is not valid at the time of the query (see AddRuleWithExpiry).
+	TraceSkipped
+	// TraceRule reports the outcome of a rule matcher.
+	TraceRule
+	// TraceResult is the last event, with the resulting effect.
+	TraceResult
+	// TraceNested reports a query made by a matcher which is stopped, with Err
+	// matching ErrQueryCycle or ErrQueryDepthExceeded, see RuleSet.MaxQueryDepth.
+	TraceNested
+)
+
+func (kind TraceKind) String() string {
+	switch kind {
+	case TracePass:
+		return "pass"
+	case TraceSkipped:
+		return "skipped"
+	case TraceRule:
+		return "rule"
+	case TraceResult:
+		return "result"
+	case TraceNested:
+		return "nested"
+	}
+	return "unknown"
+}
+
+// tierNames describes the jollyTiers, S, A and R standing for the subject, action and
+// resource types kept in the lookup.
+var tierNames = [len(jollyTiers)]string{
+	"(S,A,R)", "(S,A,nil)", "(S,nil,R)", "(nil,A,R)", "(S,nil,nil)", "(nil,nil,R)", "(nil,A,nil)", "(nil,nil,nil)",
+}
+
+// TraceEvent describes a step of the evaluation of a query, see QueryTrace.
+type TraceEvent struct {
+	Kind TraceKind
+	// Pass is the index of the fallback pass and Tier its description, eg. "(S,A,nil)",
+	// for TracePass events.
+	Pass int
+	Tier string
+	// ParentDepth is the distance from the queried resource of the resource whose
+	// rules are being evaluated, for TracePass events. See RuleSet.ParentOf.
+	ParentDepth int
+	// ImpliedBy is the action implying the queried one whose rules are evaluated, for
+	// the TracePass events of the passes added by ImplyAction.
+	ImpliedBy interface{}
+	// Rule is the rule, for TraceSkipped and TraceRule events.
+	Rule *RuleInfo
+	// Expired is set for TraceSkipped events of rules outside their validity.
+	Expired bool
+	// Matched, Effect and Quick are the values returned by the matcher, for TraceRule
+	// events. Effect is also the resulting effect for TraceResult events.
+	Matched bool
+	Effect  Effect
+	Quick   bool
+	// Reason is the reason returned by the matcher, for TraceRule events of the rules
+	// added with AddRuleWithReason.
+	Reason string
+	// Err is the error returned by the matcher, for TraceRule events, stopping the
+	// evaluation, for TraceResult events, or the nested query, for TraceNested events.
+	Err error
+	// Decision is the resulting decision, for TraceResult events.
+	Decision Decision
+}
+
+// QueryTrace is like Query, but calls traceFn for every step of the evaluation: the start
+// of each fallback pass, each candidate rule skipped or evaluated, and finally the result.
+// With FlatEvaluation the candidates of all the passes are gathered first, so the rules
+// are reported after all the passes.
+func (ruleSet *RuleSet) QueryTrace(subject interface{}, action interface{}, resource interface{}, traceFn func(ev TraceEvent)) string {
+	decision, _ := ruleSet.evaluateWith(context.Background(), queryOptions{trace: traceFn}, subject, action, resource)
+	return decision.Effect
+}
+
+func (ev *evaluation) tracePass(step passStep) {
+	event := TraceEvent{Kind: TracePass, Pass: step.pass, Tier: tierNames[step.pass], ParentDepth: ev.depth}
+	if step.implying != nil {
+		event.ImpliedBy = step.implying.value
+	}
+	ev.trace(event)
+}
+
+func (ev *evaluation) traceSkipped(rule *Rule, index int) {
+	ev.trace(TraceEvent{Kind: TraceSkipped, Rule: rule.info(index)})
+}
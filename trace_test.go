@@ -0,0 +1,121 @@
+package perms
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describe summarizes a trace event for comparisons.
+func describe(ev TraceEvent) string {
+	switch ev.Kind {
+	case TracePass:
+		return fmt.Sprintf("pass %s", ev.Tier)
+	case TraceSkipped:
+		return fmt.Sprintf("skipped %s", ev.Rule.ID)
+	case TraceRule:
+		return fmt.Sprintf("rule %s matched:%v effect:%q quick:%v", ev.Rule.ID, ev.Matched, ev.Effect, ev.Quick)
+	}
+	return fmt.Sprintf("%v %q", ev.Kind, ev.Effect)
+}
+
+func TestQueryTrace(t *testing.T) {
+	rs := newVideoRuleSet()
+	var trace []string
+	traceFn := func(ev TraceEvent) {
+		trace = append(trace, describe(ev))
+	}
+
+	john := &User{Name: "john"}
+	// no rule for (User, view, Archive): found in the (S,A,nil) pass
+	if got := rs.QueryTrace(john, "view", &Archive{Name: "2019"}, traceFn); got != DENY {
+		t.Errorf("got %q want %q", got, DENY)
+	}
+	want := []string{
+		"pass (S,A,R)",
+		"pass (S,A,nil)",
+		`rule rule-6 matched:true effect:"deny" quick:false`,
+		`result "deny"`,
+	}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(trace, "\n"), strings.Join(want, "\n"))
+	}
+
+	trace = nil
+	admin := &User{Name: "admin", IsSuperuser: true}
+	if got := rs.QueryTrace(admin, "modify", &Video{User: "john"}, traceFn); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+	// the action template of the (User, view, Video) rule rejects "modify"
+	want = []string{
+		"pass (S,A,R)",
+		"skipped rule-3",
+		`rule rule-4 matched:true effect:"allow" quick:true`,
+		`result "allow"`,
+	}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(trace, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestQueryTraceSkipped(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRuleWithID("invoices", "svc-billing", "view", "invoices", effectMatcher(ALLOW))
+	rs.AddRuleWithID("any", "svc-billing", "view", "", func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+		return false, "", false
+	})
+
+	var trace []string
+	effect := rs.QueryTrace("svc-billing", "view", "reports", func(ev TraceEvent) {
+		trace = append(trace, describe(ev))
+	})
+	if effect != DENY {
+		t.Errorf("got %q want %q", effect, DENY)
+	}
+	want := []string{
+		"pass (S,A,R)",
+		"skipped invoices",
+		`rule any matched:false effect:"" quick:false`,
+	}
+	for _, tier := range tierNames[1:] {
+		want = append(want, "pass "+tier)
+	}
+	want = append(want, `result "deny"`)
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(trace, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestQueryTraceError(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	failure := errors.New("storage unavailable")
+	rs.AddRuleE(nil, nil, nil, func(subj interface{}, act interface{}, res interface{}) (bool, string, bool, error) {
+		return false, "", false, failure
+	})
+
+	var last TraceEvent
+	var ruleErr error
+	rs.QueryTrace(&User{}, "view", &Video{}, func(ev TraceEvent) {
+		if ev.Kind == TraceRule {
+			ruleErr = ev.Err
+		}
+		last = ev
+	})
+	if ruleErr != failure {
+		t.Errorf("got rule error %v want %v", ruleErr, failure)
+	}
+	if last.Kind != TraceResult || last.Err != failure || !last.Decision.Default {
+		t.Errorf("got last event %+v want the default result with the error", last)
+	}
+}
+
+func TestQueryDoesNotAllocate(t *testing.T) {
+	rs := newLargeRuleSet(100)
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	if allocs := testing.AllocsPerRun(100, func() { rs.Query(john, "edit", video) }); allocs != 0 {
+		t.Errorf("got %v allocations per query want 0", allocs)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrTemplateType is returned by AddTypedRule for a template whose type is not
+// assignable to the parameter of the matcher, with which the rule would never match.
+var ErrTemplateType = errors.New("perms: template not of the type of the matcher")
+
+// TypedMatcherFn is a matcher receiving subject, action and resource already converted
+// to their static types.
+type TypedMatcherFn[S, A, R any] func(subject S, action A, resource R) (matches bool, effect string, quick bool)
+
+// AddTypedRule is like ruleSet.AddRule, but takes a statically typed matcher, sparing
+// it the type assertions. The templates follow the same rules as in AddRule and must
+// be either nil or values of type S, A and R, which are inferred from the matcher:
+// ErrTemplateType is returned, and no rule is added, for the others. Eg:
+//
+//	id, err := perms.AddTypedRule(rs, &User{}, "view", &Playlist{},
+//		func(user *User, action string, playlist *Playlist) (bool, string, bool) {
+//			return playlist.User == user.Name, perms.Allow, false
+//		})
+//
+// When the queried values can't be converted to S, A and R (eg. for a "jolly" rule
+// receiving other types) the matcher is not called and the rule does not match.
+// Typed and untyped rules can be mixed in the same RuleSet.
+func AddTypedRule[S, A, R any](ruleSet *RuleSet, subjectType interface{}, actionType interface{}, resourceType interface{}, matcher TypedMatcherFn[S, A, R]) (RuleID, error) {
+	params := [...]reflect.Type{
+		reflect.TypeOf((*S)(nil)).Elem(),
+		reflect.TypeOf((*A)(nil)).Elem(),
+		reflect.TypeOf((*R)(nil)).Elem(),
+	}
+	roles := [...]string{"subject", "action", "resource"}
+	for i, template := range [...]interface{}{subjectType, actionType, resourceType} {
+		if template != nil && !reflect.TypeOf(template).AssignableTo(params[i]) {
+			return "", fmt.Errorf("%w: %s template of type %T, parameter of type %v", ErrTemplateType, roles[i], template, params[i])
+		}
+	}
+	return ruleSet.AddRule(subjectType, actionType, resourceType, matcher.untyped()), nil
+}
+
+func (matcher TypedMatcherFn[S, A, R]) untyped() MatcherFn {
+	if matcher == nil {
+		return nil
+	}
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		s, ok := typedValue[S](subject)
+		if !ok {
+			return false, "", false
+		}
+		a, ok := typedValue[A](action)
+		if !ok {
+			return false, "", false
+		}
+		r, ok := typedValue[R](resource)
+		if !ok {
+			return false, "", false
+		}
+		return matcher(s, a, r)
+	}
+}
+
+// typedValue converts v to T. A nil v converts only to the zero value of interface types.
+func typedValue[T any](v interface{}) (T, bool) {
+	t, ok := v.(T)
+	if !ok && v == nil {
+		return t, reflect.TypeOf((*T)(nil)).Elem().Kind() == reflect.Interface
+	}
+	return t, ok
+}
+
+// SafeMatcher wraps fn, a strongly typed matcher function like
+//
+//	func(user *User, action string, video *Video) (bool, string, bool)
+//
+// into a MatcherFn not matching, instead of panicking, when the queried values are not
+// assignable to the parameters of fn, as happens with rules registered under nil
+// templates. A nil value is assignable only to interface parameters. Unlike
+// AddTypedRule it needs no type parameters, at the cost of a reflective call per
+// evaluation: fn is analyzed once, by SafeMatcher, which panics if it isn't a function
+// with three parameters returning a bool, a string and a bool.
+func SafeMatcher(fn interface{}) MatcherFn {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic("perms: SafeMatcher called with a non function")
+	}
+	t := v.Type()
+	if t.NumIn() != 3 || t.IsVariadic() || t.NumOut() != 3 ||
+		t.Out(0).Kind() != reflect.Bool || t.Out(1).Kind() != reflect.String || t.Out(2).Kind() != reflect.Bool {
+		panic("perms: SafeMatcher called with a function not a matcher")
+	}
+	var params [3]reflect.Type
+	for i := range params {
+		params[i] = t.In(i)
+	}
+	return func(subject interface{}, action interface{}, resource interface{}) (bool, string, bool) {
+		var args [3]reflect.Value
+		for i, value := range [3]interface{}{subject, action, resource} {
+			arg, ok := safeArg(params[i], value)
+			if !ok {
+				return false, "", false
+			}
+			args[i] = arg
+		}
+		out := v.Call(args[:])
+		return out[0].Bool(), out[1].String(), out[2].Bool()
+	}
+}
+
+// safeArg converts value to a parameter of type t, see SafeMatcher.
+func safeArg(t reflect.Type, value interface{}) (reflect.Value, bool) {
+	if value == nil {
+		return reflect.Zero(t), t.Kind() == reflect.Interface
+	}
+	arg := reflect.ValueOf(value)
+	return arg, arg.Type().AssignableTo(t)
+}
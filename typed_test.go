@@ -0,0 +1,180 @@
+package perms
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddTypedRule(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	AddTypedRule(rs, &User{}, "view", &Playlist{},
+		func(user *User, action string, playlist *Playlist) (bool, string, bool) {
+			if playlist.Public || playlist.User == user.Name {
+				return true, ALLOW, false
+			}
+			return true, DENY, false
+		})
+	// value types
+	AddTypedRule(rs, User{Name: "john"}, "view", nil,
+		func(user User, action string, video Video) (bool, string, bool) {
+			return video.User == user.Name, ALLOW, false
+		})
+	// a jolly resource with an interface type parameter
+	AddTypedRule(rs, &User{}, "view", nil,
+		func(user *User, action string, resource interface{}) (bool, string, bool) {
+			return user.IsSuperuser, ALLOW, true
+		})
+	// an untyped rule in the same set
+	rs.AddRule(&Group{}, "modify", &Playlist{},
+		func(subj interface{}, act interface{}, res interface{}) (bool, string, bool) {
+			return res.(*Playlist).Group == subj.(*Group).Name, ALLOW, false
+		})
+
+	check := func(got string, want string) {
+		t.Helper()
+		if got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+	john := &User{Name: "john"}
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	check(rs.Query(john, "view", &Playlist{User: "john"}), ALLOW)
+	check(rs.Query(john, "view", &Playlist{User: "jack"}), DENY)
+	check(rs.Query(User{Name: "john"}, "view", Video{User: "john"}), ALLOW)
+	check(rs.Query(User{Name: "john"}, "view", Video{User: "jack"}), DENY)
+	check(rs.Query(User{Name: "john"}, "view", &Video{User: "john"}), DENY)
+	check(rs.Query(overlord, "view", &Archive{Name: "test"}), ALLOW)
+	check(rs.Query(overlord, "view", nil), ALLOW)
+	check(rs.Query(&Group{Name: "editors"}, "modify", &Playlist{Group: "editors"}), ALLOW)
+}
+
+func TestTypedRuleMismatch(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	calls := 0
+	// a rule registered under jolly templates receives whatever is queried
+	AddTypedRule(rs, nil, nil, nil,
+		func(user *User, action string, video *Video) (bool, string, bool) {
+			calls++
+			return true, ALLOW, false
+		})
+
+	cases := []struct {
+		subject, action, resource interface{}
+		want                      string
+	}{
+		{&User{Name: "jack"}, "view", &Video{}, ALLOW},
+		{&Group{Name: "editors"}, "view", &Video{}, DENY},
+		{&User{Name: "jack"}, 42, &Video{}, DENY},
+		{&User{Name: "jack"}, "view", &Playlist{}, DENY},
+		{&User{Name: "jack"}, "view", nil, DENY},
+	}
+	for _, c := range cases {
+		if got := rs.Query(c.subject, c.action, c.resource); got != c.want {
+			t.Errorf("Query(%T, %v, %T): got %q want %q", c.subject, c.action, c.resource, got, c.want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d matcher calls want 1", calls)
+	}
+}
+
+func TestTypedRuleTemplateType(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	// the rule would never match
+	_, err := AddTypedRule(rs, &User{}, "view", &Group{},
+		func(user *User, action string, playlist *Playlist) (bool, string, bool) {
+			return true, ALLOW, false
+		})
+	if !errors.Is(err, ErrTemplateType) || !strings.Contains(err.Error(), "resource") {
+		t.Errorf("got %v want %v for the resource", err, ErrTemplateType)
+	}
+	if _, err := AddTypedRule(rs, &User{}, 42, nil,
+		func(user *User, action string, resource interface{}) (bool, string, bool) {
+			return true, ALLOW, false
+		}); !errors.Is(err, ErrTemplateType) {
+		t.Errorf("got %v want %v for the action", err, ErrTemplateType)
+	}
+	if n := rs.RuleCount(); n != 0 {
+		t.Errorf("got %d rules want none added", n)
+	}
+
+	// the templates assignable to the interface parameters are accepted
+	if _, err := AddTypedRule(rs, &User{}, "view", &Video{},
+		func(user *User, action interface{}, resource interface{}) (bool, string, bool) {
+			return true, ALLOW, false
+		}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Query(&User{}, "view", &Video{}); got != ALLOW {
+		t.Errorf("got %q want %q", got, ALLOW)
+	}
+}
+
+func TestSafeMatcher(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	// the pattern panicking with a type assertion on other subjects
+	rs.AddRule(nil, "view", nil, SafeMatcher(func(user *User, action string, resource interface{}) (bool, string, bool) {
+		return user.IsSuperuser, ALLOW, true
+	}))
+	rs.AddRule(&User{}, nil, nil, SafeMatcher(func(user *User, action string, video *Video) (bool, string, bool) {
+		return video.User == user.Name, ALLOW, false
+	}))
+
+	john := &User{Name: "john"}
+	overlord := &User{Name: "overlord", IsSuperuser: true}
+	tests := []struct {
+		name     string
+		subject  interface{}
+		action   interface{}
+		resource interface{}
+		want     string
+	}{
+		{"matching", john, "view", &Video{User: "john"}, ALLOW},
+		{"interface resource", overlord, "view", &Archive{}, ALLOW},
+		{"mismatched subject", &Group{Name: "john"}, "view", &Video{User: "john"}, DENY},
+		{"mismatched action", john, 42, &Video{User: "john"}, DENY},
+		{"mismatched resource", john, "view", &Playlist{User: "john"}, DENY},
+		{"nil resource", john, "modify", nil, DENY},
+		{"nil resource to an interface", overlord, "view", nil, ALLOW},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rs.Query(tt.subject, tt.action, tt.resource); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeMatcherSignature(t *testing.T) {
+	for _, fn := range []interface{}{
+		nil,
+		"not a function",
+		(func(*User, string, *Video) (bool, string, bool))(nil),
+		func(user *User, action string) (bool, string, bool) { return true, ALLOW, false },
+		func(user *User, action string, video *Video) bool { return true },
+		func(user *User, action string, video *Video) (bool, int, bool) { return true, 0, false },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("got no panic for %T", fn)
+				}
+			}()
+			SafeMatcher(fn)
+		}()
+	}
+}
+
+func BenchmarkSafeMatcher(b *testing.B) {
+	matcher := SafeMatcher(func(user *User, action string, video *Video) (bool, string, bool) {
+		return video.User == user.Name, ALLOW, false
+	})
+	john := &User{Name: "john"}
+	video := &Video{User: "john"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		matcher(john, "view", video)
+	}
+}
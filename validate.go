@@ -0,0 +1,177 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// NilMatcher is a rule added without matcher, which the queries skip.
+	NilMatcher FindingKind = "nil-matcher"
+	// DuplicateRules are rules with the same templates and the same name, usually
+	// registered twice by mistake.
+	DuplicateRules FindingKind = "duplicate-rule"
+	// EmptyRuleList is a types triple registered without any rule, a broken invariant
+	// of the rule set.
+	EmptyRuleList FindingKind = "empty-list"
+	// UnreachableTemplate is a string template never admitting a queried value, like
+	// the alias of an action (see AliasAction), or possibly mistyped, like a template
+	// differing only in case from those of other rules.
+	UnreachableTemplate FindingKind = "unreachable"
+)
+
+// AddRuleChecked is like AddRule, but returns ErrNilMatcher, and adds nothing, when
+// matcher is nil. The declarative rules always have a matcher.
+func (ruleSet *RuleSet) AddRuleChecked(subjectType interface{}, actionType interface{}, resourceType interface{}, matcher MatcherFn, options ...RuleOption) (RuleID, error) {
+	if matcher == nil {
+		return "", ErrNilMatcher
+	}
+	return ruleSet.AddRule(subjectType, actionType, resourceType, matcher, options...), nil
+}
+
+// Validate checks the invariants of the rules, including those of the namespaces, and
+// returns the findings: the rules without matcher, those with the same templates and
+// name, the empty RuleLists, and the string templates unreachable with the current
+// action aliases. Unlike Analyze, it reports what is most likely a mistake whatever
+// the rules do, and an empty result means a clean rule set.
+func (ruleSet *RuleSet) Validate() []Finding {
+	table := ruleSet.current()
+	mode := ruleSet.templateMode()
+	findings := validateTable(table, table.actions, mode)
+	names := make([]string, 0, len(table.namespaces))
+	for name := range table.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		findings = append(findings, validateTable(table.namespaces[name], table.actions, mode)...)
+	}
+	return findings
+}
+
+func validateTable(table *ruleTable, actions *actionGraph, mode templateMode) []Finding {
+	var findings []Finding
+	for sT, aMap := range table.m3rules {
+		for aT, rMap := range aMap {
+			for rT, list := range rMap {
+				if len(list) == 0 {
+					findings = append(findings, Finding{
+						Kind:     EmptyRuleList,
+						Severity: SeverityError,
+						Message:  fmt.Sprintf("no rules registered for the types (%v, %v, %v)", sT, aT, rT),
+					})
+				}
+			}
+		}
+	}
+	// the map iteration order is random
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Message < findings[j].Message
+	})
+
+	rules := table.allRules()
+	visited := make(map[[3]typ]bool)
+	for _, rule := range rules {
+		if rule.matcher == nil {
+			findings = append(findings, Finding{
+				Kind:     NilMatcher,
+				Severity: SeverityWarning,
+				Rules:    []RuleID{rule.id},
+				Message:  fmt.Sprintf("rule %s has no matcher", rule.id),
+			})
+		}
+		if canonical, ok := actions.canonical(rule.action); ok && rule.patterns[1] == nil {
+			findings = append(findings, Finding{
+				Kind:     UnreachableTemplate,
+				Severity: SeverityWarning,
+				Rules:    []RuleID{rule.id},
+				Message:  fmt.Sprintf("rule %s is registered for %q, an alias of %q, which is looked up instead", rule.id, rule.action, canonical),
+			})
+		}
+		types := rule.types()
+		if visited[types] {
+			continue
+		}
+		visited[types] = true
+		findings = append(findings, validateList(table.m3rules[rule.sT][rule.aT][rule.rT], mode)...)
+	}
+	return append(findings, caseMismatches(rules, actions)...)
+}
+
+// validateList returns the rules of a RuleList with the same templates and name.
+func validateList(rules RuleList, mode templateMode) []Finding {
+	var findings []Finding
+	for j, later := range rules {
+		if later.name == "" {
+			continue
+		}
+		for _, earlier := range rules[:j] {
+			if earlier.name == later.name && earlier.covers(later, mode) && later.covers(earlier, mode) {
+				findings = append(findings, Finding{
+					Kind:     DuplicateRules,
+					Severity: SeverityWarning,
+					Rules:    []RuleID{earlier.id, later.id},
+					Message:  fmt.Sprintf("rules %s and %s have the same templates and name %q", earlier.id, later.id, later.name),
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// caseMismatches returns the string templates differing only in case from the
+// templates in the same position of earlier rules, or from the actions with aliases.
+func caseMismatches(rules []*Rule, actions *actionGraph) []Finding {
+	var findings []Finding
+	type seen struct {
+		value string
+		rule  RuleID
+	}
+	var folded [3]map[string]seen
+	for position := range folded {
+		folded[position] = make(map[string]seen)
+	}
+	if actions != nil {
+		for alias, canonical := range actions.aliases {
+			for _, action := range [2]interface{}{alias, canonical} {
+				if s, ok := action.(string); ok && s != "" {
+					folded[1][strings.ToLower(s)] = seen{value: s}
+				}
+			}
+		}
+	}
+	for _, rule := range rules {
+		for position, template := range rule.templates() {
+			s, ok := template.(string)
+			if !ok || s == "" || rule.patterns[position] != nil {
+				continue
+			}
+			key := strings.ToLower(s)
+			previous, ok := folded[position][key]
+			if !ok {
+				folded[position][key] = seen{value: s, rule: rule.id}
+				continue
+			}
+			if previous.value == s {
+				continue
+			}
+			finding := Finding{Kind: UnreachableTemplate, Severity: SeverityWarning}
+			if previous.rule == "" {
+				finding.Rules = []RuleID{rule.id}
+				finding.Message = fmt.Sprintf("rule %s is registered for %q, differing only in case from the action %q", rule.id, s, previous.value)
+			} else {
+				finding.Rules = []RuleID{previous.rule, rule.id}
+				finding.Message = fmt.Sprintf("rule %s is registered for %q, differing only in case from %q of rule %s", rule.id, s, previous.value, previous.rule)
+			}
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
@@ -0,0 +1,92 @@
+package perms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddRuleChecked(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	if _, err := rs.AddRuleChecked(&User{}, "view", &Video{}, nil); err != ErrNilMatcher {
+		t.Errorf("got %v want ErrNilMatcher", err)
+	}
+	if n := len(rs.Rules()); n != 0 {
+		t.Errorf("got %d rules want none", n)
+	}
+	id, err := rs.AddRuleChecked(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	if err != nil || id != "rule-1" {
+		t.Errorf("got %q, %v want rule-1", id, err)
+	}
+	if !rs.IsAllowed(&User{}, "view", &Video{}) {
+		t.Errorf("got denied want allowed")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if findings := newVideoRuleSet().Validate(); len(findings) != 0 {
+		t.Errorf("got %+v want a clean rule set", findings)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(rs *RuleSet)
+		want  Finding
+	}{
+		{"nil matcher", func(rs *RuleSet) {
+			rs.AddRule(&User{}, "view", &Video{}, nil)
+		}, Finding{Kind: NilMatcher, Severity: SeverityWarning, Rules: []RuleID{"rule-1"}}},
+		{"duplicate rules", func(rs *RuleSet) {
+			rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW), Named("owner"))
+			rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW), Named("public"))
+			rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW), Named("owner"))
+		}, Finding{Kind: DuplicateRules, Severity: SeverityWarning, Rules: []RuleID{"rule-1", "rule-3"}}},
+		{"empty rule list", func(rs *RuleSet) {
+			rs.update(func(w *tableWriter) {
+				w.rMap(reflect.TypeOf(&User{}), reflect.TypeOf("view"))[reflect.TypeOf(&Video{})] = RuleList{}
+			})
+		}, Finding{Kind: EmptyRuleList, Severity: SeverityError}},
+		{"alias template", func(rs *RuleSet) {
+			if err := rs.AliasAction("GET", "view"); err != nil {
+				t.Fatal(err)
+			}
+			rs.AddRule(&User{}, "GET", &Video{}, effectMatcher(ALLOW))
+		}, Finding{Kind: UnreachableTemplate, Severity: SeverityWarning, Rules: []RuleID{"rule-1"}}},
+		{"wrong case", func(rs *RuleSet) {
+			rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+			rs.AddRule(&User{}, "View", &Playlist{}, effectMatcher(ALLOW))
+		}, Finding{Kind: UnreachableTemplate, Severity: SeverityWarning, Rules: []RuleID{"rule-1", "rule-2"}}},
+		{"wrong case of an alias", func(rs *RuleSet) {
+			if err := rs.AliasAction("GET", "view"); err != nil {
+				t.Fatal(err)
+			}
+			rs.AddRule(&User{}, "VIEW", &Video{}, effectMatcher(ALLOW))
+		}, Finding{Kind: UnreachableTemplate, Severity: SeverityWarning, Rules: []RuleID{"rule-1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := NewRuleSet(DENY)
+			tt.setup(rs)
+			findings := rs.Validate()
+			if len(findings) != 1 {
+				t.Fatalf("got %+v want a finding", findings)
+			}
+			got := findings[0]
+			if got.Message == "" {
+				t.Errorf("got a finding without message")
+			}
+			got.Message = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.Namespace("tenant").AddRule(&User{}, "view", &Video{}, nil)
+	findings := rs.Validate()
+	if len(findings) != 1 || findings[0].Kind != NilMatcher {
+		t.Errorf("got %+v want the nil matcher of the namespace", findings)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ValueMatcher is implemented by templates with custom matching semantics: a template
+// implementing it admits the queried values for which MatchValue returns true, instead
+// of the values equal to it. Since templates are checked before running the matchers,
+// a ValueMatcher can cheaply discard the rules not applying to a value, eg. a subject
+// template admitting the users of a tenant.
+//
+// A rule is registered under the type of its templates: a ValueMatcher template that
+// also has a ValueType() reflect.Type method is registered under the returned type,
+// like the ValueMatchers of this package, otherwise it is a "jolly" for that position,
+// evaluated after the rules for the queried types (see AddRule).
+type ValueMatcher interface {
+	MatchValue(value interface{}) bool
+}
+
+// valueTyper is implemented by the ValueMatchers reporting the type of the values
+// they admit.
+type valueTyper interface {
+	ValueType() reflect.Type
+}
+
+// valueMatcherTemplate adapts a ValueMatcher template.
+type valueMatcherTemplate struct {
+	matcher ValueMatcher
+}
+
+func (template valueMatcherTemplate) matchTemplate(value interface{}) bool {
+	return template.matcher.MatchValue(value)
+}
+
+// valueMatcherType returns the type the rules with the ValueMatcher template are
+// registered under.
+func valueMatcherType(matcher ValueMatcher) typ {
+	if typer, ok := matcher.(valueTyper); ok {
+		return typer.ValueType()
+	}
+	return nil
+}
+
+type oneOf struct {
+	values []interface{}
+	t      typ
+}
+
+// OneOf returns a template admitting the values equal to one of values, which should
+// have the same type. Values that aren't comparable are compared with reflect.DeepEqual.
+func OneOf(values ...interface{}) ValueMatcher {
+	template := &oneOf{values: append([]interface{}(nil), values...)}
+	for i, value := range values {
+		if t := reflect.TypeOf(value); i == 0 {
+			template.t = t
+		} else if t != template.t {
+			template.t = nil
+			break
+		}
+	}
+	return template
+}
+
+func (template *oneOf) MatchValue(value interface{}) bool {
+	for _, v := range template.values {
+		if sameValue(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValueType returns the type of the values, or nil if they have different types.
+func (template *oneOf) ValueType() reflect.Type {
+	return template.t
+}
+
+type notValue struct {
+	template interface{}
+}
+
+// NotValue returns a template admitting the values of the type of template that
+// template doesn't admit: the values different from it or, if template is a
+// ValueMatcher, those it doesn't match.
+func NotValue(template interface{}) ValueMatcher {
+	return &notValue{template: template}
+}
+
+func (template *notValue) MatchValue(value interface{}) bool {
+	if matcher, ok := template.template.(ValueMatcher); ok {
+		return !matcher.MatchValue(value)
+	}
+	return !sameValue(template.template, value)
+}
+
+// ValueType returns the type of the template.
+func (template *notValue) ValueType() reflect.Type {
+	if matcher, ok := template.template.(ValueMatcher); ok {
+		return valueMatcherType(matcher)
+	}
+	return reflect.TypeOf(template.template)
+}
+
+type prefix string
+
+// Prefix returns a template admitting the strings beginning with s.
+func Prefix(s string) ValueMatcher {
+	return prefix(s)
+}
+
+func (template prefix) MatchValue(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, string(template))
+}
+
+// ValueType returns the string type.
+func (template prefix) ValueType() reflect.Type {
+	return stringType
+}
@@ -0,0 +1,57 @@
+package perms
+
+import (
+	"testing"
+)
+
+func TestValueMatcher(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.AddRule(OneOf("john", "ann"), "edit", "doc", effectMatcher(ALLOW))
+	rs.AddRule(Prefix("guest-"), "view", "doc", effectMatcher(ALLOW))
+	rs.AddRule(NotValue("john"), "view", "doc", effectMatcher(ALLOW))
+	rs.AddRule(NotValue(Prefix("guest-")), "share", "doc", effectMatcher(ALLOW))
+
+	tests := []struct {
+		subject, action string
+		want            string
+	}{
+		{"john", "edit", ALLOW},
+		{"ann", "edit", ALLOW},
+		{"bob", "edit", DENY},
+		// the action template is still compared with ==
+		{"john", "delete", DENY},
+		{"guest-1", "view", ALLOW},
+		{"bob", "view", ALLOW},
+		{"john", "view", DENY},
+		{"ann", "share", ALLOW},
+		{"guest-1", "share", DENY},
+	}
+	for _, test := range tests {
+		if effect := rs.Query(test.subject, test.action, "doc"); effect != test.want {
+			t.Errorf("%s %s: got %q want %q", test.subject, test.action, effect, test.want)
+		}
+	}
+}
+
+func TestValueMatcherTypes(t *testing.T) {
+	john, ann := &User{Name: "john"}, &User{Name: "ann"}
+	admins := &Group{Name: "admins"}
+	rs := NewRuleSet(DENY)
+	rs.AddRule(OneOf(john, ann), "view", &Video{}, effectMatcher(ALLOW))
+	// a OneOf of values with different types is a jolly
+	rs.AddRule(OneOf("admin", admins), "view", &Video{}, effectMatcher(ALLOW))
+
+	video := &Video{Name: "intro"}
+	if !rs.IsAllowed(ann, "view", video) {
+		t.Errorf("got ann denied want allowed")
+	}
+	if rs.IsAllowed(&User{Name: "john"}, "view", video) {
+		t.Errorf("got a copy of john allowed want the pointers compared")
+	}
+	if !rs.IsAllowed("admin", "view", video) || !rs.IsAllowed(admins, "view", video) {
+		t.Errorf("got the jolly OneOf not applied")
+	}
+	if d := rs.QueryExplain(john, "view", video); d.Rule == nil || d.Rule.ID != "rule-1" {
+		t.Errorf("got %+v want rule-1", d.Rule)
+	}
+}
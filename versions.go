@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxCheckpoints is the number of checkpoints kept when RuleSet.MaxCheckpoints
+// is zero.
+const DefaultMaxCheckpoints = 10
+
+// ErrVersionNotFound is returned by Rollback for an unknown version, or one evicted.
+var ErrVersionNotFound = errors.New("perms: version not found")
+
+// Version describes a checkpoint of the rules, see Checkpoint.
+type Version struct {
+	// ID identifies the version, as returned by Checkpoint.
+	ID    string
+	Label string
+	// Time is the time of the checkpoint, according to the Now clock.
+	Time time.Time
+	// Rules is the number of rules, like RuleCount.
+	Rules int
+	// Generation is the Generation of the rules of the checkpoint.
+	Generation uint64
+}
+
+// checkpoint is a version of the rules kept by Checkpoint.
+type checkpoint struct {
+	version Version
+	table   *ruleTable
+}
+
+// checkpoints holds the checkpoints of a RuleSet, guarded by the writer lock.
+type checkpoints struct {
+	list   []checkpoint
+	lastID uint64
+}
+
+// Checkpoint records the current rules, including those of the namespaces, the action
+// implications and the default effects of the resource types, and returns the id of
+// the version, to restore it with Rollback, eg. before swapping a policy. Since the
+// rules are immutable, a checkpoint shares them with the rule set and costs nothing
+// whatever their number. Only the MaxCheckpoints most recent checkpoints are kept.
+func (ruleSet *RuleSet) Checkpoint(label string) string {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	if ruleSet.checkpoints == nil {
+		ruleSet.checkpoints = &checkpoints{}
+	}
+	store := ruleSet.checkpoints
+	table := ruleSet.current()
+	store.lastID++
+	version := Version{
+		ID:         fmt.Sprintf("v%d", store.lastID),
+		Label:      label,
+		Time:       ruleSet.now(),
+		Rules:      table.size,
+		Generation: table.generation,
+	}
+	store.list = append(store.list, checkpoint{version: version, table: table})
+	max := ruleSet.MaxCheckpoints
+	if max <= 0 {
+		max = DefaultMaxCheckpoints
+	}
+	if n := len(store.list) - max; n > 0 {
+		// drop the references to the evicted tables, so that they can be collected
+		store.list = append(store.list[:0:0], store.list[n:]...)
+	}
+	return version.ID
+}
+
+// Versions returns the checkpoints kept, the oldest first.
+func (ruleSet *RuleSet) Versions() []Version {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	if ruleSet.checkpoints == nil {
+		return nil
+	}
+	versions := make([]Version, len(ruleSet.checkpoints.list))
+	for i, checkpoint := range ruleSet.checkpoints.list {
+		versions[i] = checkpoint.version
+	}
+	return versions
+}
+
+// Rollback restores the rules recorded by Checkpoint with the id versionID, replacing
+// the current ones at once, like the other changes. The grants and the revocations,
+// which are not part of the policy, are kept as they are. The checkpoints are kept
+// too, so that a Rollback can be undone with a later one. It returns
+// ErrVersionNotFound if there is no such checkpoint.
+func (ruleSet *RuleSet) Rollback(versionID string) error {
+	ruleSet.rules.mu.Lock()
+	defer ruleSet.rules.mu.Unlock()
+	var restored *ruleTable
+	if ruleSet.checkpoints != nil {
+		for _, checkpoint := range ruleSet.checkpoints.list {
+			if checkpoint.version.ID == versionID {
+				restored = checkpoint.table
+			}
+		}
+	}
+	if restored == nil {
+		return ErrVersionNotFound
+	}
+	root := ruleSet.current()
+	table := *restored
+	table.grants, table.revoked = root.grants, root.revoked
+
+	ruleSet.byID = make(map[RuleID]*Rule, table.size)
+	for _, rule := range table.allRules() {
+		ruleSet.byID[rule.id] = rule
+	}
+	for _, ns := range table.namespaces {
+		for _, rule := range ns.allRules() {
+			ruleSet.byID[rule.id] = rule
+		}
+	}
+	// the restored lists share their arrays with the later versions, don't append to
+	// them in place
+	ruleSet.rules.tails = make(map[string]map[[3]typ]RuleList)
+	ruleSet.publish(root, &table)
+	return nil
+}
@@ -0,0 +1,94 @@
+package perms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointRollback(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	rs.Now = func() time.Time { return now }
+	john := &User{Name: "john"}
+	video := &Video{Name: "intro"}
+	viewID := rs.AddRule(&User{}, "view", &Video{}, effectMatcher(ALLOW))
+	rs.AddRule(&User{}, "edit", &Video{}, effectMatcher(ALLOW))
+
+	v1 := rs.Checkpoint("initial")
+	rs.RemoveRule(viewID)
+	rs.AddRule(&User{}, "delete", &Video{}, effectMatcher(ALLOW))
+	rs.Namespace("tenant-1").AddRule(&User{}, "share", &Video{}, effectMatcher(ALLOW))
+	rs.Grant("jack", "view", "intro", ALLOW, 0)
+	if rs.IsAllowed(john, "view", video) || !rs.IsAllowed(john, "delete", video) {
+		t.Fatalf("got the old rules")
+	}
+
+	now = now.Add(time.Hour)
+	v2 := rs.Checkpoint("delete")
+	if err := rs.Rollback(v1); err != nil {
+		t.Fatal(err)
+	}
+	if !rs.IsAllowed(john, "view", video) || !rs.IsAllowed(john, "edit", video) || rs.IsAllowed(john, "delete", video) || rs.RuleCount() != 2 {
+		t.Errorf("got the rules after the checkpoint")
+	}
+	if len(rs.Namespace("tenant-1").Rules()) != 0 {
+		t.Errorf("got the rules of the namespace added after the checkpoint")
+	}
+	// the rules are found by id again, the grants are kept
+	if err := rs.DisableRule(viewID); err != nil || rs.IsAllowed(john, "view", video) {
+		t.Errorf("got %v disabling the restored rule", err)
+	}
+	if !rs.IsAllowed("jack", "view", "intro") {
+		t.Errorf("got the grant rolled back")
+	}
+
+	versions := rs.Versions()
+	if len(versions) != 2 || versions[0].ID != v1 || versions[0].Label != "initial" || versions[0].Rules != 2 ||
+		versions[1].ID != v2 || versions[1].Rules != 2 || !versions[1].Time.Equal(now) {
+		t.Errorf("got versions %+v", versions)
+	}
+
+	// the rules added after a rollback don't affect the later checkpoints
+	rs.EnableRule(viewID)
+	rs.AddRule(&User{}, "edit", &Video{}, effectMatcher(DENY))
+	if err := rs.Rollback(v2); err != nil {
+		t.Fatal(err)
+	}
+	if !rs.IsAllowed(john, "edit", video) || !rs.IsAllowed(john, "delete", video) || rs.IsAllowed(john, "view", video) {
+		t.Errorf("got the rules of %s changed", v2)
+	}
+	if err := rs.Rollback("v9"); err != ErrVersionNotFound {
+		t.Errorf("got %v want ErrVersionNotFound", err)
+	}
+	if len(rs.Clone().Versions()) != 0 {
+		t.Errorf("got the checkpoints cloned")
+	}
+}
+
+func TestCheckpointRetention(t *testing.T) {
+	rs := NewRuleSet(DENY)
+	rs.MaxCheckpoints = 2
+	var ids []string
+	for _, action := range []string{"view", "edit", "delete"} {
+		rs.AddRule(nil, action, nil, effectMatcher(ALLOW))
+		ids = append(ids, rs.Checkpoint(action))
+	}
+	versions := rs.Versions()
+	if len(versions) != 2 || versions[0].ID != ids[1] || versions[1].ID != ids[2] {
+		t.Errorf("got versions %+v want the last two", versions)
+	}
+	if err := rs.Rollback(ids[0]); err != ErrVersionNotFound {
+		t.Errorf("got %v want the oldest checkpoint evicted", err)
+	}
+	if err := rs.Rollback(ids[1]); err != nil || rs.RuleCount() != 2 {
+		t.Errorf("got %v, %d rules", err, rs.RuleCount())
+	}
+
+	rs.MaxCheckpoints = 0
+	for i := 0; i < DefaultMaxCheckpoints+5; i++ {
+		rs.Checkpoint("")
+	}
+	if len(rs.Versions()) != DefaultMaxCheckpoints {
+		t.Errorf("got %d versions want %d", len(rs.Versions()), DefaultMaxCheckpoints)
+	}
+}
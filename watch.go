@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often WatchFile checks the policy file.
+var watchInterval = time.Second
+
+// WatchFile loads the Policy document at path into the rule set, then reloads it every
+// time the file changes, until stop is called. The document is YAML, or JSON if the
+// file name ends with .json, see LoadYAML and LoadJSON.
+//
+// A reload replaces the rules loaded from the file at once, leaving the other rules of
+// the rule set alone: the queries see either the old rules or the new ones. If the file
+// can't be read, parsed or validated, the rule set keeps the rules loaded before and
+// the error is passed to onError, or logged if onError is nil. Generation tells when a
+// reload landed.
+//
+// WatchFile returns an error, and watches nothing, if the first load fails. The file
+// is polled every second, so that it can be replaced by renaming, as editors and
+// configuration tools do.
+func WatchFile(path string, ruleSet *RuleSet, onError func(error)) (stop func(), err error) {
+	watcher := &fileWatcher{
+		ruleSet: ruleSet,
+		path:    path,
+		onError: onError,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := watcher.check(); err != nil {
+		return nil, err
+	}
+	go watcher.run()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(watcher.stop)
+		})
+		<-watcher.done
+	}, nil
+}
+
+// fileWatcher reloads a policy file, see WatchFile.
+type fileWatcher struct {
+	ruleSet *RuleSet
+	path    string
+	onError func(error)
+	// modTime and size identify the version of the file last read
+	modTime time.Time
+	size    int64
+	// content is the content last read, and ids the ids of the rules loaded from it
+	content []byte
+	ids     []RuleID
+	// failing is true while the file can't be read
+	failing bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (watcher *fileWatcher) run() {
+	defer close(watcher.done)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+			if err := watcher.check(); err != nil {
+				watcher.report(err)
+			}
+		}
+	}
+}
+
+// check reloads the file if it changed since last read.
+func (watcher *fileWatcher) check() error {
+	info, err := os.Stat(watcher.path)
+	if err == nil && watcher.content != nil && info.ModTime().Equal(watcher.modTime) && info.Size() == watcher.size {
+		return nil
+	}
+	var content []byte
+	if err == nil {
+		content, err = os.ReadFile(watcher.path)
+	}
+	if err != nil {
+		if watcher.failing {
+			// reported already
+			return nil
+		}
+		watcher.failing = true
+		return err
+	}
+	watcher.failing = false
+	// the file is read after the stat, a later change changes the stat again
+	watcher.modTime, watcher.size = info.ModTime(), info.Size()
+	if watcher.content != nil && bytes.Equal(content, watcher.content) {
+		return nil
+	}
+	watcher.content = content
+	return watcher.load(content)
+}
+
+// load replaces the rules loaded before with those of content.
+func (watcher *fileWatcher) load(content []byte) error {
+	ruleSet := watcher.ruleSet
+	var policy Policy
+	var err error
+	if strings.EqualFold(filepath.Ext(watcher.path), ".json") {
+		policy, err = parseJSON(bytes.NewReader(content))
+	} else {
+		policy, err = ruleSet.parseYAML(bytes.NewReader(content))
+	}
+	if err != nil {
+		return err
+	}
+	replaced := make(map[RuleID]bool, len(watcher.ids))
+	for _, id := range watcher.ids {
+		replaced[id] = true
+	}
+	rules, err := ruleSet.policyRules(policy, replaced)
+	if err != nil {
+		return err
+	}
+	if err := ruleSet.replaceRules(replaced, rules); err != nil {
+		return err
+	}
+	ids := make([]RuleID, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.id
+	}
+	watcher.ids = ids
+	return nil
+}
+
+func (watcher *fileWatcher) report(err error) {
+	if watcher.onError != nil {
+		watcher.onError(err)
+		return
+	}
+	watcher.ruleSet.logf("perms: reloading %s: %v", watcher.path, err)
+}
+
+// replaceRules removes the rules of the root namespace with the replaced ids and adds
+// the rules, at once. It returns ErrDuplicateRuleID, and changes nothing, if the id of
+// a rule is in use by a rule not replaced.
+func (ruleSet *RuleSet) replaceRules(replaced map[RuleID]bool, rules []*Rule) error {
+	var err error
+	ruleSet.update(func(w *tableWriter) {
+		ids := make(map[RuleID]bool, len(rules))
+		for _, rule := range rules {
+			if rule.id == "" {
+				continue
+			}
+			current, ok := ruleSet.byID[rule.id]
+			if (ok && (!replaced[rule.id] || current.namespace != "")) || ids[rule.id] {
+				err = ErrDuplicateRuleID
+				return
+			}
+			ids[rule.id] = true
+		}
+		for id := range replaced {
+			if current := ruleSet.byID[id]; current != nil && current.namespace == "" {
+				w.remove(current)
+			}
+		}
+		for _, rule := range rules {
+			if rule.id == "" {
+				rule.id = ruleSet.nextRuleID()
+			}
+			w.add(rule)
+		}
+	})
+	return err
+}
@@ -0,0 +1,142 @@
+package perms
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const watchedPolicyYAML = `
+rules:
+  - id: view
+    subject: User
+    action: view
+    resource: Video
+    effect: %s
+`
+
+// writePolicy replaces the file at path with content, like the editors do.
+func writePolicy(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time of the replaced file may be the same otherwise
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitGeneration waits for the rule set to change from generation.
+func waitGeneration(t *testing.T, rs *RuleSet, generation uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for rs.Generation() == generation {
+		if time.Now().After(deadline) {
+			t.Fatal("got no reload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	defer func(interval time.Duration) { watchInterval = interval }(watchInterval)
+	watchInterval = time.Millisecond
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	modTime := time.Now().Add(-time.Hour)
+	writePolicy(t, path, strings.Replace(watchedPolicyYAML, "%s", ALLOW, 1), modTime)
+
+	rs := newPolicyRuleSet()
+	rs.AddRule(&User{}, "modify", &Video{}, effectMatcher(ALLOW))
+	errs := make(chan error, 10)
+	stop, err := WatchFile(path, rs, func(err error) { errs <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	john := &User{Name: "john"}
+	if got := rs.Query(john, "view", &Video{}); got != ALLOW {
+		t.Fatalf("got %q want allow", got)
+	}
+
+	// a broken policy keeps the rules
+	generation := rs.Generation()
+	writePolicy(t, path, "rules:\n  - subject: Nobody\n    effect: deny\n", modTime.Add(time.Minute))
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "Nobody") {
+			t.Errorf("got %v want an unknown type", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no error")
+	}
+	if rs.Generation() != generation || rs.Query(john, "view", &Video{}) != ALLOW {
+		t.Errorf("got the rules changed by a broken policy")
+	}
+
+	writePolicy(t, path, strings.Replace(watchedPolicyYAML, "%s", DENY, 1), modTime.Add(2*time.Minute))
+	waitGeneration(t, rs, generation)
+	if got := rs.Query(john, "view", &Video{}); got != DENY {
+		t.Errorf("got %q after the reload want deny", got)
+	}
+	// the rules not from the file are kept, the replaced ones removed
+	if got := rs.Query(john, "modify", &Video{}); got != ALLOW {
+		t.Errorf("got %q want the other rules kept", got)
+	}
+	if n := len(rs.Rules()); n != 2 {
+		t.Errorf("got %d rules want 2", n)
+	}
+
+	// a removed file keeps the rules too
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("got %v want a missing file", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no error")
+	}
+	stop()
+	if got := rs.Query(john, "view", &Video{}); got != DENY {
+		t.Errorf("got %q want deny", got)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("got %v reported twice", err)
+	default:
+	}
+}
+
+func TestWatchFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	rs := newPolicyRuleSet()
+	if _, err := WatchFile(filepath.Join(dir, "missing.yaml"), rs, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v want a missing file", err)
+	}
+	path := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"subject": "User", "effect": "allow", "extra": 1}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WatchFile(path, rs, nil); err == nil || len(rs.Rules()) != 0 {
+		t.Errorf("got %v, %d rules want an error and no rule", err, len(rs.Rules()))
+	}
+	// the ids of the policy must be free
+	if err := rs.AddRuleWithID("view", &User{}, "view", &Video{}, effectMatcher(ALLOW)); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "policy.yaml")
+	writePolicy(t, path, strings.Replace(watchedPolicyYAML, "%s", DENY, 1), time.Now())
+	if _, err := WatchFile(path, rs, nil); err == nil || !strings.Contains(err.Error(), "duplicate id") {
+		t.Errorf("got %v want a duplicate id", err)
+	}
+}
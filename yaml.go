@@ -0,0 +1,161 @@
+// Copyright (C) 2019 Marco Pantaleoni. All rights reserved.
+// Use of this source file is governed by the GNU General Public License v2.0 that
+// can be found in the LICENSE.txt file.
+// Commercial users can obtain a commercial license by contacting the author.
+
+package perms
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML accepts both the mapping and the string form of a condition.
+func (cond *Condition) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		parsed, err := ParseCondition(node.Value)
+		if err != nil {
+			return err
+		}
+		*cond = parsed
+		return nil
+	}
+	type plain Condition
+	return node.Decode((*plain)(cond))
+}
+
+// MarshalYAML writes conditions in their string form.
+func (cond Condition) MarshalYAML() (interface{}, error) {
+	return cond.String(), nil
+}
+
+// LoadYAML reads a YAML Policy document and adds its rules, see AddPolicy. Conditions
+// can be written in their string form, eg.:
+//
+//	rules:
+//	  - subject: User
+//	    action: view
+//	    resource: Playlist
+//	    conditions:
+//	      - resource.User == subject.Name
+//	    effect: allow
+//
+// Errors report the line of the offending element.
+func (ruleSet *RuleSet) LoadYAML(r io.Reader) error {
+	policy, err := ruleSet.parseYAML(r)
+	if err != nil {
+		return err
+	}
+	return ruleSet.AddPolicy(policy)
+}
+
+// parseYAML reads a YAML Policy document, checking its rules like LoadYAML.
+func (ruleSet *RuleSet) parseYAML(r io.Reader) (Policy, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return Policy{}, nil
+		}
+		return Policy{}, fmt.Errorf("perms: %v", err)
+	}
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	rulesNode := mappingValue(root, "rules")
+	if root.Kind != yaml.MappingNode || (rulesNode != nil && rulesNode.Kind != yaml.SequenceNode) {
+		return Policy{}, fmt.Errorf("perms: line %d: a policy must be a mapping with a rules sequence", root.Line)
+	}
+	if unknown := unknownKey(root, "rules"); unknown != nil {
+		return Policy{}, fmt.Errorf("perms: line %d: unknown field %q", unknown.Line, unknown.Value)
+	}
+
+	var policy Policy
+	if rulesNode == nil {
+		return policy, nil
+	}
+	for _, ruleNode := range rulesNode.Content {
+		if err := ruleSet.checkYAMLRule(ruleNode); err != nil {
+			return Policy{}, err
+		}
+		var decl DeclarativeRule
+		if err := ruleNode.Decode(&decl); err != nil {
+			return Policy{}, fmt.Errorf("perms: line %d: %v", ruleNode.Line, err)
+		}
+		if _, _, _, _, err := decl.compile(ruleSet.types); err != nil {
+			return Policy{}, fmt.Errorf("perms: line %d: %w", ruleNode.Line, err)
+		}
+		policy.Rules = append(policy.Rules, decl)
+	}
+	return policy, nil
+}
+
+// checkYAMLRule validates the parts of a rule node whose errors are better reported
+// with the line of the element than with the line of the rule.
+func (ruleSet *RuleSet) checkYAMLRule(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("perms: line %d: a rule must be a mapping", node.Line)
+	}
+	if unknown := unknownKey(node, "id", "subject", "action", "resource", "conditions", "condition", "effect", "quick", "priority", "fields"); unknown != nil {
+		return fmt.Errorf("perms: line %d: unknown field %q", unknown.Line, unknown.Value)
+	}
+	for _, role := range []string{"subject", "resource"} {
+		value := mappingValue(node, role)
+		if value == nil || value.Value == "" || value.Value == "*" {
+			continue
+		}
+		if _, ok := ruleSet.types.Lookup(value.Value); !ok {
+			return fmt.Errorf("perms: line %d: unknown %s type %q", value.Line, role, value.Value)
+		}
+	}
+	if conditions := mappingValue(node, "conditions"); conditions != nil && conditions.Kind == yaml.SequenceNode {
+		for _, condNode := range conditions.Content {
+			var cond Condition
+			if err := condNode.Decode(&cond); err != nil {
+				return fmt.Errorf("perms: line %d: %v", condNode.Line, err)
+			}
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func unknownKey(node *yaml.Node, known ...string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		found := false
+		for _, key := range known {
+			if node.Content[i].Value == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// SaveYAML writes the declarative rules of the rule set as a YAML Policy document.
+// Rules added as Go matchers are not included.
+func (ruleSet *RuleSet) SaveYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(Policy{Rules: ruleSet.DeclarativeRules()}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,166 @@
+package perms
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const playlistPolicyYAML = `
+rules:
+  # User can view Playlist when playlist.Public or playlist.User == subject.Name
+  - subject: User
+    action: view
+    resource: Playlist
+    conditions:
+      - resource.Public == true
+    effect: allow
+  - id: owner-view
+    subject: User
+    action: view
+    resource: Playlist
+    conditions:
+      - resource.User == subject.Name
+    effect: allow
+  - subject: User
+    action: modify
+    resource: Playlist
+    conditions:
+      - field: resource.User
+        ref: subject.Name
+    effect: allow
+  - subject: Group
+    action: modify
+    resource: Playlist
+    conditions: ["resource.Group == subject.Name"]
+    effect: allow
+  - subject: User
+    action: "*"
+    conditions:
+      - subject.IsSuperuser == true
+    effect: allow
+    quick: true
+`
+
+func TestLoadYAMLRoundTrip(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadYAML(strings.NewReader(playlistPolicyYAML)); err != nil {
+		t.Fatal(err)
+	}
+	checkPlaylistPolicy(t, rs)
+
+	var buf bytes.Buffer
+	if err := rs.SaveYAML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newPolicyRuleSet()
+	if err := reloaded.LoadYAML(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("%v reloading:\n%s", err, buf.String())
+	}
+	checkPlaylistPolicy(t, reloaded)
+	if got, want := reloaded.DeclarativeRules(), rs.DeclarativeRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %+v want %+v", got, want)
+	}
+
+	// the YAML and the JSON policies are the same
+	fromJSON := newPolicyRuleSet()
+	if err := fromJSON.LoadJSON(strings.NewReader(playlistPolicyJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rs.DeclarativeRules(), fromJSON.DeclarativeRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %+v want %+v", got, want)
+	}
+}
+
+func TestLoadYAMLFields(t *testing.T) {
+	rs := newPolicyRuleSet()
+	if err := rs.LoadYAML(strings.NewReader(`
+rules:
+  - subject: User
+    action: view
+    resource: Playlist
+    effect: allow
+    fields: [Public]
+`)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := rs.SaveYAML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newPolicyRuleSet()
+	if err := reloaded.LoadYAML(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("%v reloading:\n%s", err, buf.String())
+	}
+	if got, want := reloaded.DeclarativeRules(), rs.DeclarativeRules(); !reflect.DeepEqual(got, want) || len(got) != 1 || !reflect.DeepEqual(got[0].Fields, []string{"Public"}) {
+		t.Errorf("got rules %+v want %+v", got, want)
+	}
+	john := &User{Name: "john"}
+	playlist := &Playlist{User: "jack"}
+	if got := reloaded.Query(john, "view", playlist); got != DENY {
+		t.Errorf("got %q want %q for the whole playlist", got, DENY)
+	}
+	if fields, err := reloaded.QueryFields(john, "view", playlist); err != nil || fields["Public"] != Allow || fields["User"] != Deny {
+		t.Errorf("got %v, %v want only Public allowed", fields, err)
+	}
+}
+
+func TestLoadYAMLErrors(t *testing.T) {
+	cases := []struct {
+		policy string
+		err    string
+	}{
+		{"rules:\n  - subject: User\n    resource: Song\n    effect: allow\n",
+			`perms: line 3: unknown resource type "Song"`},
+		{"rules:\n  - subject: User\n    effect: allow\n  - subject: Admin\n    effect: allow\n",
+			`perms: line 4: unknown subject type "Admin"`},
+		{"rules:\n  - subject: User\n    conditions:\n      - subject.Name\n    effect: allow\n",
+			`perms: line 4: malformed condition "subject.Name": missing ==`},
+		{"rules:\n  - subject: User\n    conditions:\n      - resource.Public == true\n      - name == john\n    effect: allow\n",
+			`perms: line 5: malformed condition "name == john": field "name" must start with subject, action, resource or env`},
+		{"rules:\n  - subject: User\n    conditions:\n      - subject.Age == 1 2\n    effect: allow\n",
+			`perms: line 4: malformed condition "subject.Age == 1 2": bad value 1 2`},
+		{"rules:\n  - subject: User\n    conditions:\n      - subject.Age == true\n    effect: allow\n",
+			`perms: line 2: type perms.User has no exported field "Age"`},
+		{"rules:\n  - subject: User\n    effekt: allow\n",
+			`perms: line 3: unknown field "effekt"`},
+	}
+	for _, c := range cases {
+		rs := newPolicyRuleSet()
+		err := rs.LoadYAML(strings.NewReader(c.policy))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("got error %v want %q", err, c.err)
+		}
+		if n := len(rs.byID); n != 0 {
+			t.Errorf("got %d rules loaded from an invalid policy", n)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	cases := []struct {
+		s    string
+		want Condition
+	}{
+		{"resource.Public == true", Condition{Field: "resource.Public", Value: true}},
+		{"resource.User==subject.Name", Condition{Field: "resource.User", Ref: "subject.Name"}},
+		{`subject.Name == "jack"`, Condition{Field: "subject.Name", Value: "jack"}},
+		{`subject.Name == jack`, Condition{Field: "subject.Name", Value: "jack"}},
+		{"resource.Duration == 600", Condition{Field: "resource.Duration", Value: 600.0}},
+		{"resource.Owner == null", Condition{Field: "resource.Owner"}},
+	}
+	for _, c := range cases {
+		got, err := ParseCondition(c.s)
+		if err != nil {
+			t.Errorf("%q: %v", c.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %+v want %+v", c.s, got, c.want)
+		}
+		if again, err := ParseCondition(got.String()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%q: String() %q does not parse back: %+v, %v", c.s, got.String(), again, err)
+		}
+	}
+}